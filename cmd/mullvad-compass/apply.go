@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+
+	"github.com/Ch00k/mullvad-compass/internal/cli"
+	"github.com/Ch00k/mullvad-compass/internal/errs"
+	"github.com/Ch00k/mullvad-compass/internal/logging"
+	"github.com/Ch00k/mullvad-compass/internal/relays"
+	"github.com/Ch00k/mullvad-compass/internal/wgconf"
+)
+
+// applyBestRelay points the wg-quick configuration given with --wg-config at the best server, and restarts its
+// interface with --restart. A configuration already pointing at it is left alone, and so is any configuration
+// when no server responded.
+func applyBestRelay(
+	ctx context.Context,
+	config *cli.Config,
+	deps Dependencies,
+	best relays.Location,
+	stdout io.Writer,
+) error {
+	if best.Latency == nil {
+		return fmt.Errorf("%w, leaving %s unchanged", errs.ErrAllTimeouts, config.WGConfig)
+	}
+	peer := wgconf.Peer{PublicKey: best.PublicKey, Host: best.IPv4Address}
+	if config.IPVersion.IsIPv6() {
+		peer.Host = best.IPv6Address
+	}
+	if peer.PublicKey == "" || peer.Host == "" {
+		return fmt.Errorf("%s has no public key or address to apply", best.Hostname)
+	}
+
+	changed, err := wgconf.Apply(config.WGConfig, peer)
+	if err != nil {
+		return fmt.Errorf("failed to apply %s: %w", best.Hostname, err)
+	}
+	if !changed {
+		_, _ = fmt.Fprintf(stdout, "\n%s already connects to %s\n", config.WGConfig, best.Hostname)
+		return nil
+	}
+	_, _ = fmt.Fprintf(stdout, "\nPointed %s at %s (backup at %s)\n",
+		config.WGConfig, best.Hostname, wgconf.BackupPath(config.WGConfig))
+
+	if !config.Restart {
+		return nil
+	}
+	if config.LogLevel <= logging.LogLevelDebug {
+		log.Printf("Restarting the wg-quick interface of %s...", config.WGConfig)
+	}
+	if err := deps.RestartWireGuard(ctx, config.WGConfig); err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintf(stdout, "Restarted the interface of %s\n", config.WGConfig)
+	return nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Ch00k/mullvad-compass/internal/cli"
+	"github.com/Ch00k/mullvad-compass/internal/logging"
+	"github.com/Ch00k/mullvad-compass/internal/ping"
+	"github.com/Ch00k/mullvad-compass/internal/relays"
+)
+
+const (
+	asymmetryServers = 5 // Best servers probed with --asymmetry
+	asymmetryRounds  = 3 // Timestamp requests per server, the fastest reply of each direction counts
+)
+
+// probeAsymmetry sends ICMP timestamp requests from the probe source carried by the context
+func probeAsymmetry(
+	ctx context.Context,
+	locations []relays.Location,
+	timeout time.Duration,
+	logLevel logging.LogLevel,
+) ([]ping.Asymmetry, error) {
+	return ping.ProbeAsymmetry(ctx, locations, asymmetryRounds, timeout, ping.SourceFromContext(ctx), logLevel)
+}
+
+// formatAsymmetry probes the best responding servers with ICMP timestamp requests for --asymmetry and renders their
+// upload and download delays. The probe is experimental, so its failure is reported in place of the estimates
+// rather than failing the run.
+func formatAsymmetry(ctx context.Context, config *cli.Config, deps Dependencies, ranked []relays.Location) string {
+	if !config.Asymmetry {
+		return ""
+	}
+	var candidates []relays.Location
+	for _, loc := range ranked {
+		if loc.Latency != nil && len(candidates) < asymmetryServers {
+			candidates = append(candidates, loc)
+		}
+	}
+	if len(candidates) == 0 {
+		return ""
+	}
+
+	const title = "\nUpload/download delay (experimental, from ICMP timestamps):\n"
+	timeout := time.Duration(config.Timeout) * time.Millisecond
+	results, err := deps.ProbeAsymmetry(ctx, candidates, timeout, config.LogLevel)
+	if err != nil {
+		return fmt.Sprintf("%s  not measured: %v\n", title, err)
+	}
+
+	latency := displayOptions(config).Latency
+	width := 0
+	for _, r := range results {
+		width = max(width, len(r.Location.Hostname))
+	}
+
+	var output strings.Builder
+	output.WriteString(title)
+	for _, r := range results {
+		if r.Outbound == nil || r.Return == nil {
+			fmt.Fprintf(&output, "  %-*s  no timestamp reply\n", width, r.Location.Hostname)
+			continue
+		}
+		fmt.Fprintf(&output, "  %-*s  up %s %s, down %s %s\n",
+			width,
+			r.Location.Hostname,
+			latency.Format(r.Outbound),
+			latency.Unit(),
+			latency.Format(r.Return),
+			latency.Unit())
+	}
+	return output.String()
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"log"
+
+	"github.com/Ch00k/mullvad-compass/internal/cli"
+	"github.com/Ch00k/mullvad-compass/internal/logging"
+	"github.com/Ch00k/mullvad-compass/internal/relays"
+	"github.com/Ch00k/mullvad-compass/internal/warnings"
+)
+
+// baselineCountry marks the baseline rows in the Country column, where no relay has it
+const baselineCountry = "Baseline"
+
+// baselineHost is a well-known anycast host pinged with --baseline. Anycast answers from the nearest of many
+// sites, so its latency is about the best the user's connection can do at the time.
+type baselineHost struct {
+	Name     string
+	Hostname string
+	IPv4     string
+	IPv6     string
+}
+
+// baselineHosts are pinged by address, so that they need no DNS lookup
+var baselineHosts = []baselineHost{
+	{Name: "Cloudflare", Hostname: "one.one.one.one", IPv4: "1.1.1.1", IPv6: "2606:4700:4700::1111"},
+	{Name: "Google", Hostname: "dns.google", IPv4: "8.8.8.8", IPv6: "2001:4860:4860::8888"},
+}
+
+// startBaseline starts pinging the baseline hosts in the background, so that they are measured alongside the
+// relays. The returned function waits for them as pseudo-locations, marked with baselineCountry, to be listed
+// after the servers.
+func startBaseline(ctx context.Context, config *cli.Config, deps Dependencies) func() []relays.Location {
+	locations := make([]relays.Location, len(baselineHosts))
+	for i, host := range baselineHosts {
+		locations[i] = relays.Location{Country: baselineCountry, City: host.Name, Hostname: host.Hostname}
+		// Only the address pinged is set, so that --ip-version both shows no IPv6 latency for them
+		if config.IPVersion.IsIPv6() {
+			locations[i].IPv6Address = host.IPv6
+		} else {
+			locations[i].IPv4Address = host.IPv4
+		}
+	}
+	if config.LogLevel <= logging.LogLevelDebug {
+		log.Printf("Pinging %d baseline hosts...", len(locations))
+	}
+
+	done := make(chan []relays.Location, 1)
+	go func() {
+		pinged, err := deps.PingLocations(
+			ctx,
+			locations,
+			config.Timeout,
+			len(locations),
+			config.IPVersion,
+			config.LogLevel,
+		)
+		if err != nil {
+			warnings.FromContext(ctx).Add(warnings.ProbeFailed, "Failed to ping the baseline hosts: %v", err)
+			pinged = locations
+		}
+		// The pings complete in any order, the rows keep that of baselineHosts
+		ordered := make([]relays.Location, 0, len(pinged))
+		for _, host := range baselineHosts {
+			for _, loc := range pinged {
+				if loc.Hostname == host.Hostname {
+					ordered = append(ordered, loc)
+				}
+			}
+		}
+		done <- ordered
+	}()
+
+	return func() []relays.Location { return <-done }
+}
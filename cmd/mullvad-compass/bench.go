@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/Ch00k/mullvad-compass/internal/cli"
+	"github.com/Ch00k/mullvad-compass/internal/formatter"
+	"github.com/Ch00k/mullvad-compass/internal/ping"
+	"github.com/Ch00k/mullvad-compass/internal/relays"
+	"github.com/Ch00k/mullvad-compass/internal/timing"
+)
+
+// benchDefaultRelays is the number of synthetic relays bench-internal generates without an argument
+const benchDefaultRelays = 5000
+
+// benchRelaysPerCity is the number of synthetic relays sharing a city
+const benchRelaysPerCity = 8
+
+// runBenchInternal runs the filter, ping, sort and format pipeline on synthetic relays and prints how long each
+// phase took. Pings are answered by a mock pinger without delay, so the ping phase measures the worker pool alone.
+func runBenchInternal(ctx context.Context, config *cli.Config, stdout io.Writer) error {
+	count := benchDefaultRelays
+	if len(config.Args) > 0 {
+		count, _ = strconv.Atoi(config.Args[0]) // Validated by cli.ParseFlags
+	}
+
+	timings := timing.New(timing.WithLogLevel(config.LogLevel))
+
+	stopGenerate := timings.Start(timing.PhaseParse, fmt.Sprintf("Generate %d relays", count))
+	relaysData := syntheticRelays(count)
+	stopGenerate()
+
+	userLoc := getDeterministicUserLocation()
+	locations, err := getLocations(ctx, timings, relaysData, relays.ACNone, false, 0, config.IPVersion)
+	if err != nil {
+		return err
+	}
+	// Relays are spread over the whole globe, so the search radius covers all of them
+	locations, err = filterByDistance(
+		ctx,
+		timings,
+		config.LogLevel,
+		locations,
+		userLoc.Latitude,
+		userLoc.Longitude,
+		maxSearchRadius,
+	)
+	if err != nil {
+		return err
+	}
+
+	stopPing := timings.Start(timing.PhasePing, "Ping locations")
+	locations, err = ping.LocationsWithFactory(
+		ctx,
+		locations,
+		config.Timeout,
+		config.Workers,
+		config.IPVersion,
+		benchPingerFactory(),
+		config.LogLevel,
+	)
+	stopPing()
+	if err != nil {
+		return err
+	}
+
+	sortLocationsByLatency(timings, locations)
+
+	stopFormat := timings.Start(timing.PhaseFormat, "Format results")
+	table := formatter.FormatTable(locations, displayOptions(config))
+	stopFormat()
+
+	_, _ = fmt.Fprintf(stdout, "Benchmarked %d synthetic relays (%d bytes of output)\n\n", len(locations), len(table))
+	_, _ = fmt.Fprint(stdout, formatter.FormatTimings(timings.Report()))
+	return nil
+}
+
+// syntheticRelays generates count active WireGuard relays in cities spread evenly over the globe
+func syntheticRelays(count int) *relays.File {
+	cities := (count + benchRelaysPerCity - 1) / benchRelaysPerCity
+	file := &relays.File{
+		Locations: make(map[string]relays.LocationEntry, cities),
+		WireGuard: relays.WireGuardSection{
+			PortRanges: []relays.PortRange{{51820, 51820}},
+			Relays:     make([]relays.WireGuardRelay, count),
+		},
+	}
+
+	for c := range cities {
+		// Points on a Fibonacci sphere are roughly equidistant
+		lat := math.Asin(1-2*(float64(c)+0.5)/float64(cities)) * 180 / math.Pi
+		lon := math.Mod(float64(c)*137.50776405, 360) - 180
+		file.Locations[syntheticLocationKey(c)] = relays.LocationEntry{
+			City:      fmt.Sprintf("City %d", c),
+			Country:   fmt.Sprintf("Country %d", c/10),
+			Latitude:  lat,
+			Longitude: lon,
+		}
+	}
+
+	for i := range count {
+		key := syntheticLocationKey(i / benchRelaysPerCity)
+		file.WireGuard.Relays[i] = relays.WireGuardRelay{
+			Hostname:         fmt.Sprintf("%s-wg-%03d", key, i%benchRelaysPerCity+1),
+			Active:           true,
+			Location:         key,
+			IPv4AddrIn:       fmt.Sprintf("10.%d.%d.%d", i>>16&0xff, i>>8&0xff, i&0xff),
+			IPv6AddrIn:       fmt.Sprintf("fd00::%x", i+1),
+			IncludeInCountry: true,
+		}
+	}
+	return file
+}
+
+// syntheticLocationKey returns the relays.json location key of a synthetic city, e.g. "x0-c0012"
+func syntheticLocationKey(city int) string {
+	return fmt.Sprintf("x%d-c%04d", city/10000, city%10000)
+}
+
+// benchPingerFactory returns a pinger factory answering each address instantly with a latency derived from it
+func benchPingerFactory() ping.PingerFactory {
+	factory := ping.NewMockPingerFactory()
+	factory.CreatePingerFunc = func(relays.IPVersion) (ping.Pinger, error) {
+		pinger := ping.NewMockPinger()
+		pinger.PingFunc = func(_ context.Context, ipAddr string, _ time.Duration) *float64 {
+			h := fnv.New32a()
+			_, _ = h.Write([]byte(ipAddr))
+			latency := 1 + float64(h.Sum32()%30000)/100
+			return &latency
+		}
+		return pinger, nil
+	}
+	return factory
+}
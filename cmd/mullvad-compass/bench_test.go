@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/Ch00k/mullvad-compass/internal/relays"
+)
+
+func TestSyntheticRelays(t *testing.T) {
+	file := syntheticRelays(20)
+
+	if len(file.WireGuard.Relays) != 20 {
+		t.Fatalf("Expected 20 relays, got %d", len(file.WireGuard.Relays))
+	}
+	if len(file.Locations) != 3 {
+		t.Errorf("Expected 3 cities of up to %d relays, got %d", benchRelaysPerCity, len(file.Locations))
+	}
+
+	locations, skipped, err := relays.GetLocations(file, relays.ACNone, false, relays.IPv4)
+	if err != nil {
+		t.Fatalf("GetLocations failed: %v", err)
+	}
+	if len(locations) != 20 || skipped != 0 {
+		t.Errorf("Expected all 20 relays to be selectable, got %d (%d skipped)", len(locations), skipped)
+	}
+
+	seen := make(map[string]bool)
+	for _, loc := range locations {
+		if seen[loc.IPv4Address] {
+			t.Errorf("Duplicate address %s", loc.IPv4Address)
+		}
+		seen[loc.IPv4Address] = true
+	}
+}
+
+func TestE2E_BenchCommand(t *testing.T) {
+	var output bytes.Buffer
+	deps := Dependencies{Stdout: &output}
+
+	if err := run(context.Background(), []string{"bench-internal", "100"}, deps); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	out := output.String()
+	if !strings.HasPrefix(out, "Benchmarked 100 synthetic relays") {
+		t.Errorf("Expected the relay count, got:\n%s", out)
+	}
+	for _, phase := range []string{"parse", "filter", "ping", "sort", "format", "total"} {
+		if !strings.Contains(out, phase) {
+			t.Errorf("Expected the %s phase in the timings, got:\n%s", phase, out)
+		}
+	}
+}
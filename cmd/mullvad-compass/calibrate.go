@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/Ch00k/mullvad-compass/internal/cli"
+	"github.com/Ch00k/mullvad-compass/internal/formatter"
+	"github.com/Ch00k/mullvad-compass/internal/logging"
+	"github.com/Ch00k/mullvad-compass/internal/netguard"
+	"github.com/Ch00k/mullvad-compass/internal/relays"
+	"github.com/Ch00k/mullvad-compass/internal/resolve"
+)
+
+// startCalibration resolves the reference host given with --calibrate and starts pinging it in the background,
+// so that it is measured alongside the relays. The returned function waits for the result.
+func startCalibration(ctx context.Context, config *cli.Config, deps Dependencies) (func() formatter.Reference, error) {
+	network := "ip4"
+	if config.IPVersion.IsIPv6() {
+		network = "ip6"
+	}
+	addrs, err := resolver(config).LookupIP(ctx, network, config.Calibrate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve reference host %s: %w", config.Calibrate, err)
+	}
+
+	// The checked address is the one pinged, so that the host cannot resolve to another one in between
+	if !config.AllowPrivate {
+		if err := netguard.CheckTarget(addrs[0]); err != nil {
+			return nil, fmt.Errorf("refusing to ping reference host %s: %w", config.Calibrate, err)
+		}
+	}
+
+	ref := formatter.Reference{Host: config.Calibrate, Address: addrs[0].String()}
+	loc := relays.Location{Hostname: ref.Host}
+	if config.IPVersion.IsIPv6() {
+		loc.IPv6Address = ref.Address
+	} else {
+		loc.IPv4Address = ref.Address
+	}
+	if config.LogLevel <= logging.LogLevelDebug {
+		log.Printf("Pinging reference %s (%s)...", ref.Host, ref.Address)
+	}
+
+	done := make(chan formatter.Reference, 1)
+	go func() {
+		pinged, err := deps.PingLocations(
+			ctx,
+			[]relays.Location{loc},
+			config.Timeout,
+			1,
+			config.IPVersion,
+			config.LogLevel,
+		)
+		switch {
+		case err != nil:
+			if config.LogLevel <= logging.LogLevelWarning {
+				log.Printf("Failed to ping reference %s: %v", ref.Host, err)
+			}
+		case len(pinged) > 0:
+			ref.Latency = pinged[0].Latency
+		}
+		done <- ref
+	}()
+
+	return func() formatter.Reference { return <-done }, nil
+}
+
+// resolver returns the resolver of the hosts that a run contacts: DNS-over-HTTPS with --doh, the system resolver
+// otherwise
+func resolver(config *cli.Config) resolve.Resolver {
+	if config.DoHURL == "" {
+		return resolve.System()
+	}
+	return resolve.NewDoH(config.DoHURL, resolve.WithLogLevel(config.LogLevel))
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/Ch00k/mullvad-compass/internal/cli"
+	"github.com/Ch00k/mullvad-compass/internal/logging"
+	"github.com/Ch00k/mullvad-compass/internal/pcap"
+	"github.com/Ch00k/mullvad-compass/internal/ping"
+	"github.com/Ch00k/mullvad-compass/internal/warnings"
+)
+
+// startCapture creates the --pcap file and returns the capture the pingers record to, with a function closing the
+// file. A failed write only loses packets, so it is logged as a warning when the capture is closed.
+func startCapture(ctx context.Context, config *cli.Config) (*pcap.Writer, func(), error) {
+	if !ping.CaptureSupported {
+		return nil, nil, errors.New("--pcap is not supported on this platform")
+	}
+
+	f, err := os.Create(config.PcapFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create capture file: %w", err)
+	}
+	capture, err := pcap.NewWriter(f)
+	if err != nil {
+		_ = f.Close()
+		return nil, nil, fmt.Errorf("failed to write capture file: %w", err)
+	}
+	if config.LogLevel <= logging.LogLevelDebug {
+		log.Printf("Recording ICMP packets to %s", config.PcapFile)
+	}
+
+	stop := func() {
+		err := errors.Join(capture.Err(), f.Close())
+		if err != nil {
+			warnings.FromContext(ctx).Add(
+				warnings.CaptureFailed,
+				"capture file %s is incomplete: %v",
+				config.PcapFile,
+				err,
+			)
+		}
+	}
+	return capture, stop, nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"slices"
+
+	"github.com/Ch00k/mullvad-compass/internal/api"
+	"github.com/Ch00k/mullvad-compass/internal/appsettings"
+	"github.com/Ch00k/mullvad-compass/internal/cli"
+	"github.com/Ch00k/mullvad-compass/internal/errs"
+	"github.com/Ch00k/mullvad-compass/internal/formatter"
+	"github.com/Ch00k/mullvad-compass/internal/logging"
+	"github.com/Ch00k/mullvad-compass/internal/relays"
+	"github.com/Ch00k/mullvad-compass/internal/timing"
+)
+
+// runConstraintCost searches the nearest servers and the nearest ones the app's relay constraints allow in a
+// single ping run, prints the best of each with the latency the constraints cost, and returns the allowed
+// locations ranked best first, as the app would connect to one of them
+func runConstraintCost(
+	ctx context.Context,
+	config *cli.Config,
+	settings *appsettings.Settings,
+	locations []relays.Location,
+	unlocated []relays.Location,
+	userLoc *api.UserLocation,
+	seed int64,
+	stdout io.Writer,
+	pingFn func(context.Context, []relays.Location, int, int, relays.IPVersion, logging.LogLevel) ([]relays.Location, error),
+) ([]relays.Location, error) {
+	timings := timing.FromContext(ctx)
+
+	allowed := filterByAppSettings(config, settings, locations)
+	allowedUnlocated := filterByAppSettings(config, settings, unlocated)
+	if len(allowed) == 0 && len(allowedUnlocated) == 0 {
+		return nil, fmt.Errorf("%w that the Mullvad app's relay settings allow", errs.ErrNoServers)
+	}
+
+	nearest, err := expandSearchRadius(config, timings, locations, userLoc)
+	if err != nil && len(unlocated) == 0 {
+		return nil, err
+	}
+	nearestAllowed, err := expandSearchRadius(config, timings, allowed, userLoc)
+	if err != nil && len(allowedUnlocated) == 0 {
+		return nil, err
+	}
+
+	// Both searches usually overlap, so each server is pinged once
+	candidates := slices.Clone(nearest)
+	for _, loc := range slices.Concat(nearestAllowed, unlocated) {
+		if !slices.ContainsFunc(candidates, func(c relays.Location) bool { return c.Hostname == loc.Hostname }) {
+			candidates = append(candidates, loc)
+		}
+	}
+
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	pinged, err := probeLocations(ctx, config, candidates, seed, pingFn)
+	if err != nil {
+		return nil, err
+	}
+	warnTimedOutPrefixes(ctx, pinged, config.IPVersion)
+
+	fellBack := rankLocations(config, timings, pinged, stdout)
+	ranked := filterByAppSettings(config, settings, pinged)
+	if len(ranked) == 0 {
+		return nil, fmt.Errorf("%w that the Mullvad app's relay settings allow", errs.ErrNoServers)
+	}
+
+	stopFormat := timings.Start(timing.PhaseFormat, "Format best server")
+	output := formatBestServer(config, *userLoc, pinged[0]) + formatConstraintCost(config, pinged[0], ranked[0])
+	stopFormat()
+	_, _ = fmt.Fprint(stdout, output)
+
+	if fellBack {
+		return ranked, errDistanceFallback
+	}
+	return ranked, nil
+}
+
+// formatConstraintCost renders the best server the app allows and what the constraints cost, as one line each with
+// --plain
+func formatConstraintCost(config *cli.Config, best, allowed relays.Location) string {
+	if config.Plain {
+		return formatter.FormatPlainConstraintCost(best, allowed, displayOptions(config))
+	}
+	if config.Pretty {
+		allowed.Country = formatter.PrettyCountry(allowed)
+	}
+	return formatter.FormatConstraintCost(best, allowed, displayOptions(config))
+}
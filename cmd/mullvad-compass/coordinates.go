@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+
+	"github.com/Ch00k/mullvad-compass/internal/cli"
+	"github.com/Ch00k/mullvad-compass/internal/distance"
+	"github.com/Ch00k/mullvad-compass/internal/formatter"
+	"github.com/Ch00k/mullvad-compass/internal/logging"
+	"github.com/Ch00k/mullvad-compass/internal/relays"
+)
+
+// checkCoordinates logs the relays that relays.json places far from the city their hostname names at debug level,
+// and lists them on stdout with --verify-coordinates, reporting whether that listing ends the run
+func checkCoordinates(config *cli.Config, relaysData *relays.File, stdout io.Writer) bool {
+	if !config.VerifyCoordinates && config.LogLevel > logging.LogLevelDebug {
+		return false
+	}
+
+	mismatches := distance.CoordinateMismatches(relaysData)
+	if config.VerifyCoordinates {
+		_, _ = fmt.Fprint(stdout, formatter.FormatCoordinateMismatches(relaysData.Locations, mismatches))
+		return true
+	}
+	for _, m := range mismatches {
+		log.Printf("%s is listed at %s, %.0f km from %s named by its hostname", m.Hostname, m.Location, m.Distance,
+			m.Advertised)
+	}
+	return false
+}
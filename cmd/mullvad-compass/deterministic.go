@@ -41,6 +41,7 @@ func getDeterministicLocations() []relays.Location {
 			Type:                   "wireguard",
 			Hostname:               "cz-prg-wg-201",
 			IPv4Address:            "178.249.209.162",
+			IPv6Address:            "2a02:6ea0:c201:1::f001",
 			DistanceFromMyLocation: &distance156,
 			Latency:                &latency978,
 		},
@@ -50,6 +51,7 @@ func getDeterministicLocations() []relays.Location {
 			Type:                   "wireguard",
 			Hostname:               "cz-prg-wg-202",
 			IPv4Address:            "178.249.209.175",
+			IPv6Address:            "2a02:6ea0:c201:1::f101",
 			DistanceFromMyLocation: &distance156,
 			Latency:                &latency1301,
 		},
@@ -59,6 +61,7 @@ func getDeterministicLocations() []relays.Location {
 			Type:                   "wireguard",
 			Hostname:               "cz-prg-wg-102",
 			IPv4Address:            "146.70.129.130",
+			IPv6Address:            "2001:ac8:33:d::a02f",
 			DistanceFromMyLocation: &distance156,
 			Latency:                &latency1394,
 		},
@@ -68,6 +71,7 @@ func getDeterministicLocations() []relays.Location {
 			Type:                   "wireguard",
 			Hostname:               "de-ber-wg-007",
 			IPv4Address:            "193.32.248.75",
+			IPv6Address:            "2a03:1b20:b:f011::f701",
 			DistanceFromMyLocation: &distance238,
 			Latency:                &latency1586,
 		},
@@ -77,6 +81,7 @@ func getDeterministicLocations() []relays.Location {
 			Type:                   "wireguard",
 			Hostname:               "de-ber-wg-001",
 			IPv4Address:            "193.32.248.66",
+			IPv6Address:            "2a03:1b20:b:f011::a01f",
 			DistanceFromMyLocation: &distance238,
 			Latency:                &latency1588,
 		},
@@ -86,6 +91,7 @@ func getDeterministicLocations() []relays.Location {
 			Type:                   "wireguard",
 			Hostname:               "de-ber-wg-005",
 			IPv4Address:            "193.32.248.70",
+			IPv6Address:            "2a03:1b20:b:f011::a05f",
 			DistanceFromMyLocation: &distance238,
 			Latency:                &latency1589,
 		},
@@ -95,6 +101,7 @@ func getDeterministicLocations() []relays.Location {
 			Type:                   "wireguard",
 			Hostname:               "de-ber-wg-008",
 			IPv4Address:            "193.32.248.74",
+			IPv6Address:            "2a03:1b20:b:f011::f801",
 			DistanceFromMyLocation: &distance238,
 			Latency:                &latency1591,
 		},
@@ -104,6 +111,7 @@ func getDeterministicLocations() []relays.Location {
 			Type:                   "wireguard",
 			Hostname:               "de-ber-wg-003",
 			IPv4Address:            "193.32.248.68",
+			IPv6Address:            "2a03:1b20:b:f011::a03f",
 			DistanceFromMyLocation: &distance238,
 			Latency:                &latency1593,
 		},
@@ -113,6 +121,7 @@ func getDeterministicLocations() []relays.Location {
 			Type:                   "wireguard",
 			Hostname:               "de-ber-wg-004",
 			IPv4Address:            "193.32.248.69",
+			IPv6Address:            "2a03:1b20:b:f011::a04f",
 			DistanceFromMyLocation: &distance238,
 			Latency:                &latency1595a,
 		},
@@ -122,6 +131,7 @@ func getDeterministicLocations() []relays.Location {
 			Type:                   "wireguard",
 			Hostname:               "de-ber-wg-006",
 			IPv4Address:            "193.32.248.71",
+			IPv6Address:            "2a03:1b20:b:f011::a06f",
 			DistanceFromMyLocation: &distance238,
 			Latency:                &latency1595b,
 		},
@@ -131,6 +141,7 @@ func getDeterministicLocations() []relays.Location {
 			Type:                   "wireguard",
 			Hostname:               "de-ber-wg-002",
 			IPv4Address:            "193.32.248.67",
+			IPv6Address:            "2a03:1b20:b:f011::a02f",
 			DistanceFromMyLocation: &distance238,
 			Latency:                &latency1599,
 		},
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// errLocked signals that another run holds the lock
+var errLocked = errors.New("another mullvad-compass run is in progress")
+
+// defaultLockPath returns the path of the run lock in the user's cache directory
+func defaultLockPath() (string, error) {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to determine cache directory: %w", err)
+	}
+	return filepath.Join(cacheDir, "mullvad-compass", "run.lock"), nil
+}
+
+// acquireRunLock locks the lock file, writes the current PID to it and returns a function that removes it. The
+// operating system releases the lock when its process exits, so a file left behind by a run that has exited is
+// simply locked again.
+func acquireRunLock(path string) (func(), error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create lock directory: %w", err)
+	}
+
+	// A run releasing the lock removes the file, so the file opened here may be gone by the time it is locked.
+	// The lock only counts if the path still names the locked file.
+	for range 3 {
+		f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open lock file: %w", err)
+		}
+		if err := lockFile(f); err != nil {
+			_ = f.Close()
+			if errors.Is(err, errLocked) {
+				return nil, fmt.Errorf("%w (pid %d, lock file %s); use --no-lock to run anyway",
+					errLocked, lockHolder(path), path)
+			}
+			return nil, fmt.Errorf("failed to lock %s: %w", path, err)
+		}
+		if !namesFile(path, f) {
+			_ = f.Close()
+			continue
+		}
+
+		if err := writePID(f); err != nil {
+			releaseLock(f, path)
+			return nil, fmt.Errorf("failed to write lock file: %w", err)
+		}
+		return func() { releaseLock(f, path) }, nil
+	}
+
+	return nil, fmt.Errorf("%w (lock file %s); use --no-lock to run anyway", errLocked, path)
+}
+
+// namesFile reports whether path names the open file
+func namesFile(path string, f *os.File) bool {
+	opened, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	named, err := os.Stat(path)
+	return err == nil && os.SameFile(opened, named)
+}
+
+// writePID replaces the contents of the locked file with the current PID
+func writePID(f *os.File) error {
+	if err := f.Truncate(0); err != nil {
+		return err
+	}
+	_, err := f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	return err
+}
+
+// lockHolder returns the PID the lock file holds, or 0 if the holder has not written it yet
+func lockHolder(path string) int {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || pid <= 0 {
+		return 0
+	}
+	return pid
+}
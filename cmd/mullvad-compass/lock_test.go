@@ -0,0 +1,134 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func writeLock(t *testing.T, path, content string, age time.Duration) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	modTime := time.Now().Add(-age)
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// exitedPID returns the PID of a process that has already exited
+func exitedPID(t *testing.T) int {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("Failed to run a short-lived process: %v", err)
+	}
+	return cmd.Process.Pid
+}
+
+func TestAcquireRunLock(t *testing.T) {
+	t.Run("Acquire and release", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "nested", "run.lock")
+
+		release, err := acquireRunLock(path)
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("Expected a lock file, got: %v", err)
+		}
+		if string(data) != strconv.Itoa(os.Getpid())+"\n" {
+			t.Errorf("Expected the lock to hold the current PID, got %q", data)
+		}
+
+		release()
+		if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("Expected the lock file to be removed, got: %v", err)
+		}
+	})
+
+	t.Run("Held by another run", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "run.lock")
+		release, err := acquireRunLock(path)
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		defer release()
+
+		_, err = acquireRunLock(path)
+		if !errors.Is(err, errLocked) {
+			t.Errorf("Expected errLocked, got: %v", err)
+		}
+		if err != nil && !strings.Contains(err.Error(), "pid "+strconv.Itoa(os.Getpid())) {
+			t.Errorf("Expected the error to name the holder, got: %v", err)
+		}
+	})
+
+	tests := []struct {
+		name    string
+		content func(t *testing.T) string
+		age     time.Duration
+	}{
+		{"Left behind by an exited process", func(t *testing.T) string { return strconv.Itoa(exitedPID(t)) }, 0},
+		{"Left behind by this process", func(*testing.T) string { return strconv.Itoa(os.Getpid()) }, 0},
+		{"Malformed", func(*testing.T) string { return "garbage" }, time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "run.lock")
+			writeLock(t, path, tt.content(t), tt.age)
+
+			release, err := acquireRunLock(path)
+			if err != nil {
+				t.Fatalf("Expected a lock left behind to be taken over, got: %v", err)
+			}
+			release()
+		})
+	}
+
+	t.Run("Racing for a lock left behind", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "run.lock")
+		writeLock(t, path, strconv.Itoa(exitedPID(t)), 0)
+
+		// Every acquirer that succeeds keeps the lock until all have tried
+		const acquirers = 8
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		var releases []func()
+		start := make(chan struct{})
+		for range acquirers {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				<-start
+				release, err := acquireRunLock(path)
+				if err != nil {
+					if !errors.Is(err, errLocked) {
+						t.Errorf("Expected errLocked, got: %v", err)
+					}
+					return
+				}
+				mu.Lock()
+				releases = append(releases, release)
+				mu.Unlock()
+			}()
+		}
+		close(start)
+		wg.Wait()
+
+		if len(releases) != 1 {
+			t.Errorf("Expected exactly one acquirer to take over the lock, got %d", len(releases))
+		}
+		for _, release := range releases {
+			release()
+		}
+	})
+}
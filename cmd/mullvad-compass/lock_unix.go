@@ -0,0 +1,24 @@
+//go:build !windows
+
+package main
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// lockFile locks the open file without waiting, returning errLocked if another run holds the lock
+func lockFile(f *os.File) error {
+	err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		return errLocked
+	}
+	return err
+}
+
+// releaseLock removes the lock file before closing it, so that a run can only lock the file while the path names it
+func releaseLock(f *os.File, path string) {
+	_ = os.Remove(path)
+	_ = f.Close()
+}
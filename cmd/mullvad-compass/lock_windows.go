@@ -0,0 +1,36 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// lockOffsetHigh places the locked byte beyond the PID, as other runs read the PID while the lock is held
+const lockOffsetHigh = 1
+
+// lockFile locks the open file without waiting, returning errLocked if another run holds the lock
+func lockFile(f *os.File) error {
+	err := windows.LockFileEx(
+		windows.Handle(f.Fd()),
+		windows.LOCKFILE_EXCLUSIVE_LOCK|windows.LOCKFILE_FAIL_IMMEDIATELY,
+		0,
+		1,
+		0,
+		&windows.Overlapped{OffsetHigh: lockOffsetHigh},
+	)
+	if errors.Is(err, windows.ERROR_LOCK_VIOLATION) {
+		return errLocked
+	}
+	return err
+}
+
+// releaseLock closes the lock file and then removes it. Windows does not remove a file another run has open, so
+// the removal fails harmlessly when another run is about to lock the file.
+func releaseLock(f *os.File, path string) {
+	_ = f.Close()
+	_ = os.Remove(path)
+}
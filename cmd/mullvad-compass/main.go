@@ -3,39 +3,138 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"math/rand/v2"
 	"os"
 	"os/signal"
+	"runtime"
+	"slices"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/Ch00k/mullvad-compass/internal/api"
+	"github.com/Ch00k/mullvad-compass/internal/appsettings"
+	"github.com/Ch00k/mullvad-compass/internal/asn"
+	"github.com/Ch00k/mullvad-compass/internal/buildinfo"
 	"github.com/Ch00k/mullvad-compass/internal/cli"
+	"github.com/Ch00k/mullvad-compass/internal/clock"
+	"github.com/Ch00k/mullvad-compass/internal/compare"
+	"github.com/Ch00k/mullvad-compass/internal/compass"
+	"github.com/Ch00k/mullvad-compass/internal/distance"
+	"github.com/Ch00k/mullvad-compass/internal/elevate"
+	"github.com/Ch00k/mullvad-compass/internal/errs"
 	"github.com/Ch00k/mullvad-compass/internal/formatter"
+	"github.com/Ch00k/mullvad-compass/internal/hooks"
+	"github.com/Ch00k/mullvad-compass/internal/hostlist"
 	"github.com/Ch00k/mullvad-compass/internal/logging"
+	"github.com/Ch00k/mullvad-compass/internal/netcheck"
+	"github.com/Ch00k/mullvad-compass/internal/netguard"
 	"github.com/Ch00k/mullvad-compass/internal/ping"
+	"github.com/Ch00k/mullvad-compass/internal/rank"
 	"github.com/Ch00k/mullvad-compass/internal/relays"
+	"github.com/Ch00k/mullvad-compass/internal/report"
+	"github.com/Ch00k/mullvad-compass/internal/resolve"
+	"github.com/Ch00k/mullvad-compass/internal/service"
+	"github.com/Ch00k/mullvad-compass/internal/stability"
+	"github.com/Ch00k/mullvad-compass/internal/timing"
+	"github.com/Ch00k/mullvad-compass/internal/tunnel"
+	"github.com/Ch00k/mullvad-compass/internal/warnings"
+	"github.com/Ch00k/mullvad-compass/internal/wgconf"
 )
 
 var Version = "dev"
 
+// Commit and BuildDate can be set with -ldflags -X for builds outside a git checkout. go build embeds both when
+// building from one.
+var (
+	Commit    string
+	BuildDate string
+)
+
+// Exit codes of failed runs, telling scripts why no server was picked
+const (
+	exitCodeError            = 1 // Any failure without a dedicated exit code
+	exitCodeDistanceFallback = 2 // No server responded to ping and results were ranked by distance
+	exitCodeNoServers        = 3 // No server matched the filters
+	exitCodeRelaysNotFound   = 4 // relays.json was not found
+	exitCodeLocationFailed   = 5 // The Mullvad API did not return the user's location
+	exitCodePermissionDenied = 6 // The operating system refused to open an ICMP socket
+)
+
+// maxSearchRadius is the largest distance searched, matching the upper bound of --max-distance
+const maxSearchRadius = 20000.0
+
+// ignoreSuggestionRuns is the number of consecutive runs a relay must time out in before it is suggested for the
+// ignore list
+const ignoreSuggestionRuns = 3
+
+// ipVersionProbes is the number of nearest servers pinged over both IP versions by --ip-version auto
+const ipVersionProbes = 5
+
+// tunnelAutoTimeout is the connection timeout of the tunnel command with --timeout auto, in milliseconds
+const tunnelAutoTimeout = 2000
+
+// stabilityCandidates is the number of best servers that --stability keeps pinging
+const stabilityCandidates = 10
+
+// connectedWarning is printed after the results when the user is connected to Mullvad VPN
+const connectedWarning = "\nWARNING: You are connected to Mullvad VPN. Results might not be meaningful. " +
+	"Use 'mullvad-compass tunnel' to measure latency through the connected relay instead.\n"
+
+// errDistanceFallback signals that results were ranked by distance because every ping timed out
+var errDistanceFallback = fmt.Errorf("%w, results ranked by distance", errs.ErrAllTimeouts)
+
 // Dependencies encapsulates external dependencies for testing
 type Dependencies struct {
-	GetUserLocation func(context.Context, logging.LogLevel) (*api.UserLocation, error)
-	PingLocations   func(context.Context, []relays.Location, int, int, relays.IPVersion, logging.LogLevel) ([]relays.Location, error)
-	ParseRelaysFile func(logging.LogLevel, string, func() (string, error)) (*relays.File, error)
-	Stdout          io.Writer
+	GetUserLocation  func(context.Context, logging.LogLevel) (*api.UserLocation, error)
+	CheckConnection  func(context.Context, logging.LogLevel) (*api.ConnectionCheck, error)
+	PingLocations    func(context.Context, []relays.Location, int, int, relays.IPVersion, logging.LogLevel) ([]relays.Location, error)
+	ParseRelaysFile  func(context.Context, logging.LogLevel, string, func() (string, error)) (*relays.File, error)
+	DownloadRelays   func(context.Context, logging.LogLevel, string) (bool, error)
+	RelaysCachePath  func() (string, error)
+	HookStatePath    func() (string, error)
+	LoadAppSettings  func(logging.LogLevel) (*appsettings.Settings, error)
+	GetAppLocation   func(context.Context, logging.LogLevel) (*api.UserLocation, error)
+	CheckIPv6Route   func(string) error           // Nil skips the IPv6 detection of IPv4 runs
+	ConfigPath       func(string) (string, error) // Nil keeps no host lists, timeouts or history
+	LockPath         func() (string, error)       // Nil runs without a lock
+	MeasureTunnel    func(context.Context, []string, time.Duration, logging.LogLevel) []tunnel.Result
+	RestartWireGuard func(context.Context, string) error
+	NewSession       func(context.Context, *cli.Config) (*compass.Session, error) // Nil starts every --every run afresh
+	ProbeAsymmetry   func(context.Context, []relays.Location, time.Duration, logging.LogLevel) ([]ping.Asymmetry, error)
+	CheckPrivileges  func(*cli.Config) error // Nil skips the socket check of --sudo
+	Confirm          func(string) bool       // Asks a yes/no question
+	Elevate          func([]string) error    // Runs the binary again with the arguments as root
+	Stdout           io.Writer
 }
 
 // DefaultDependencies returns production dependencies
 func DefaultDependencies() Dependencies {
 	return Dependencies{
-		GetUserLocation: makeGetUserLocation(Version),
-		PingLocations:   makePingLocations(),
-		ParseRelaysFile: parseRelaysFile,
-		Stdout:          os.Stdout,
+		GetUserLocation:  makeGetUserLocation(Version),
+		CheckConnection:  makeCheckConnection(Version),
+		PingLocations:    makePingLocations(),
+		ParseRelaysFile:  parseRelaysFile,
+		DownloadRelays:   makeDownloadRelays(Version),
+		RelaysCachePath:  relays.UserCacheFilePath,
+		HookStatePath:    hooks.DefaultStatePath,
+		LoadAppSettings:  appsettings.Load,
+		GetAppLocation:   appsettings.CachedLocation,
+		CheckIPv6Route:   netcheck.CheckIPv6Route,
+		ConfigPath:       hostlist.ConfigPath,
+		LockPath:         defaultLockPath,
+		MeasureTunnel:    measureTunnel,
+		RestartWireGuard: wgconf.Restart,
+		NewSession:       newSession,
+		ProbeAsymmetry:   probeAsymmetry,
+		CheckPrivileges:  checkPrivileges,
+		Confirm:          confirmOnTerminal,
+		Elevate:          elevate.Reexec,
+		Stdout:           os.Stdout,
 	}
 }
 
@@ -48,7 +147,7 @@ func makePingLocations() func(context.Context, []relays.Location, int, int, rela
 			timeout,
 			workers,
 			ipVersion,
-			ping.NewDefaultPingerFactory(),
+			ping.NewPingerFactoryWithCapture(ping.SourceFromContext(ctx), ping.CaptureFromContext(ctx)),
 			logLevel,
 		)
 	}
@@ -57,254 +156,1961 @@ func makePingLocations() func(context.Context, []relays.Location, int, int, rela
 // makeGetUserLocation creates a GetUserLocation function with the given version
 func makeGetUserLocation(version string) func(context.Context, logging.LogLevel) (*api.UserLocation, error) {
 	return func(ctx context.Context, logLevel logging.LogLevel) (*api.UserLocation, error) {
-		client := api.NewClient(api.WithVersion(version), api.WithLogLevel(logLevel))
+		client := newAPIClient(ctx, version, logLevel)
 		return client.GetUserLocation(ctx)
 	}
 }
 
+// makeCheckConnection creates a CheckConnection function with the given version
+func makeCheckConnection(version string) func(context.Context, logging.LogLevel) (*api.ConnectionCheck, error) {
+	return func(ctx context.Context, logLevel logging.LogLevel) (*api.ConnectionCheck, error) {
+		client := newAPIClient(ctx, version, logLevel)
+		return client.CheckConnection(ctx)
+	}
+}
+
+// makeDownloadRelays creates a DownloadRelays function with the given version
+func makeDownloadRelays(version string) func(context.Context, logging.LogLevel, string) (bool, error) {
+	return func(ctx context.Context, logLevel logging.LogLevel, path string) (bool, error) {
+		client := newAPIClient(ctx, version, logLevel)
+		return client.DownloadRelays(ctx, path)
+	}
+}
+
+type userAgentKey struct{}
+
+// withUserAgent returns a context whose Mullvad API requests are sent with userAgent
+func withUserAgent(ctx context.Context, userAgent string) context.Context {
+	return context.WithValue(ctx, userAgentKey{}, userAgent)
+}
+
+// newAPIClient creates a Mullvad API client, sending the User-Agent carried by the context, if any
+func newAPIClient(ctx context.Context, version string, logLevel logging.LogLevel) *api.Client {
+	opts := []api.ClientOption{api.WithVersion(version), api.WithLogLevel(logLevel)}
+	if userAgent, ok := ctx.Value(userAgentKey{}).(string); ok {
+		opts = append(opts, api.WithUserAgent(userAgent))
+	}
+	return api.NewClient(opts...)
+}
+
 func main() {
 	// Create a context that can be cancelled with SIGINT or SIGTERM
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 
 	if err := run(ctx, os.Args[1:], DefaultDependencies()); err != nil {
+		// Results were already printed with a notice, only the exit code differs
+		if errors.Is(err, errDistanceFallback) {
+			cancel()
+			os.Exit(exitCodeDistanceFallback)
+		}
 		// Don't print error if user cancelled with Ctrl-C
 		if err == context.Canceled {
 			fmt.Fprintln(os.Stderr, "Operation cancelled")
 		} else {
 			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			if hint := errorHint(err); hint != "" {
+				fmt.Fprintln(os.Stderr, hint)
+			}
 		}
 		cancel()
-		os.Exit(1)
+		os.Exit(exitCode(err))
 	}
 	cancel()
 }
 
-// runBestServerMode finds and returns the best server by progressively expanding search radius
+// exitCode returns the exit code of a run that failed with err
+func exitCode(err error) int {
+	switch {
+	case errors.Is(err, errs.ErrAllTimeouts):
+		return exitCodeDistanceFallback
+	case errors.Is(err, errs.ErrNoServers):
+		return exitCodeNoServers
+	case errors.Is(err, errs.ErrRelaysNotFound):
+		return exitCodeRelaysNotFound
+	case errors.Is(err, errs.ErrAPILocation):
+		return exitCodeLocationFailed
+	case errors.Is(err, errs.ErrPermission):
+		return exitCodePermissionDenied
+	default:
+		return exitCodeError
+	}
+}
+
+// errorHint returns a suggestion for resolving err, or an empty string if there is none
+func errorHint(err error) string {
+	switch {
+	case errors.Is(err, errs.ErrRelaysNotFound):
+		return "Install the Mullvad VPN app, or download the relay list with --update-relays."
+	case errors.Is(err, errs.ErrRestrictedTarget):
+		return "Pass --allow-private to probe local and special-purpose addresses."
+	case errors.Is(err, errs.ErrPermission) && runtime.GOOS == "linux":
+		return "Allow unprivileged ICMP sockets with: sudo sysctl -w net.ipv4.ping_group_range=\"0 2147483647\"\n" +
+			"or pass --sudo to be offered to run again as root."
+	default:
+		return ""
+	}
+}
+
+// runBestServerMode finds the best server by progressively expanding search radius, or among all servers in the
+// place given with --best-in, prints it, and returns all pinged locations ranked best first
 func runBestServerMode(
 	ctx context.Context,
-	logLevel logging.LogLevel,
+	config *cli.Config,
 	locations []relays.Location,
+	unlocated []relays.Location,
 	userLoc *api.UserLocation,
-	timeout int,
-	workers int,
-	ipVersion relays.IPVersion,
+	seed int64,
 	stdout io.Writer,
 	pingFn func(context.Context, []relays.Location, int, int, relays.IPVersion, logging.LogLevel) ([]relays.Location, error),
-) error {
-	currentRange := 500.0
-	maxRange := 20000.0
-	var filteredLocations []relays.Location
+) ([]relays.Location, error) {
+	timings := timing.FromContext(ctx)
 
-	for len(filteredLocations) == 0 {
-		// Check if context is cancelled
-		if ctx.Err() != nil {
-			return ctx.Err()
+	var filteredLocations []relays.Location
+	var err error
+	if config.BestIn != "" {
+		filteredLocations = slices.Clone(locations)
+		distance.AnnotateDistances(filteredLocations, userLoc.Latitude, userLoc.Longitude)
+		if config.LogLevel <= logging.LogLevelInfo {
+			log.Printf("Searching all %d servers in %s", len(filteredLocations), config.BestIn)
 		}
-
-		filteredLocations = filterByDistance(logLevel, locations, userLoc.Latitude, userLoc.Longitude, currentRange)
-		if len(filteredLocations) == 0 {
-			currentRange += 500.0
-			if currentRange > maxRange {
-				return fmt.Errorf("no servers found within maximum search radius of %.0f km", maxRange)
-			}
+	} else {
+		filteredLocations, err = expandSearchRadius(config, timings, locations, userLoc)
+		if err != nil && len(unlocated) == 0 {
+			return nil, err
 		}
 	}
+	filteredLocations = append(filteredLocations, unlocated...)
+
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
 
 	// Ping all servers in the found range
-	var err error
-	filteredLocations, err = pingLocations(
-		ctx,
-		logLevel,
-		filteredLocations,
-		timeout,
-		workers,
-		ipVersion,
-		pingFn,
-	)
+	filteredLocations, err = probeLocations(ctx, config, filteredLocations, seed, pingFn)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	warnTimedOutPrefixes(ctx, filteredLocations, config.IPVersion)
 
-	// Sort by latency and return only the best server
+	// Rank and return only the best server
 	if len(filteredLocations) > 0 {
-		sortLocationsByLatency(logLevel, filteredLocations)
+		fellBack := rankLocations(config, timings, filteredLocations, stdout)
+		breakTie(config, filteredLocations, seed)
 
-		bestServer := filteredLocations[0]
-		output := formatter.FormatBestServer(*userLoc, bestServer, ipVersion.IsIPv6())
+		stopFormat := timings.Start(timing.PhaseFormat, "Format best server")
+		output := formatBestServer(config, *userLoc, filteredLocations[0])
+		if config.MinCityRelays > 0 {
+			counts := relays.CountPerCity(slices.Concat(locations, unlocated))
+			output += formatCityAlternative(config, counts, filteredLocations)
+		}
+		stopFormat()
 		_, _ = fmt.Fprint(stdout, output)
+
+		if fellBack {
+			return filteredLocations, errDistanceFallback
+		}
 	}
 
-	return nil
+	return filteredLocations, nil
 }
 
-func run(ctx context.Context, args []string, deps Dependencies) error {
-	// Parse command-line flags
-	config, err := cli.ParseFlags(args, Version)
-	if err != nil {
-		return err
+// tieMargin is how many milliseconds slower than the best server a server may be to tie with it
+const tieMargin = 1.0
+
+// breakTie moves the server picked with --tie-break among those tying for best to the front
+func breakTie(config *cli.Config, ranked []relays.Location, seed int64) {
+	if config.TieBreak == relays.TieBreakFirst {
+		return
 	}
-	if config.LogLevel <= logging.LogLevelDebug {
-		log.Printf("Config: %+v", config)
+	ties := relays.Ties(ranked, tieMargin)
+	picked := relays.BreakTie(ranked, ties, config.TieBreak, rand.New(rand.NewPCG(uint64(seed), 1)))
+	if ties > 1 && config.LogLevel <= logging.LogLevelInfo {
+		log.Printf(
+			"%d servers within %.0f ms of the best, picked %s (ranked %d) by %s",
+			ties,
+			tieMargin,
+			ranked[0].Hostname,
+			picked+1,
+			config.TieBreak,
+		)
 	}
+}
 
-	// Handle help flag
-	if config.ShowHelp {
-		cli.PrintUsage(deps.Stdout, Version)
-		return nil
+// expandSearchRadius returns the locations within the smallest multiple of 500 km that reaches the nearest server
+func expandSearchRadius(
+	config *cli.Config,
+	timings *timing.Collector,
+	locations []relays.Location,
+	userLoc *api.UserLocation,
+) ([]relays.Location, error) {
+	// Distances are computed once; each radius step is then a binary search
+	index := newDistanceIndex(timings, locations, userLoc.Latitude, userLoc.Longitude)
+	nearest, ok := index.Nearest()
+	if !ok {
+		return nil, errs.ErrNoServers
 	}
 
-	// Handle version flag
-	if config.ShowVersion {
-		_, _ = fmt.Fprintf(deps.Stdout, "mullvad-compass %s\n", Version)
-		return nil
+	// Expand the radius in 500 km steps until it reaches the nearest server
+	const step = 500.0
+	currentRange := step
+	for currentRange < nearest {
+		currentRange += step
+	}
+	if currentRange > maxSearchRadius {
+		return nil, fmt.Errorf(
+			"%w within maximum search radius of %.0f km (nearest server is %.0f km away)",
+			errs.ErrNoServers,
+			maxSearchRadius,
+			nearest,
+		)
+	}
+	if currentRange > step && config.LogLevel <= logging.LogLevelInfo {
+		log.Printf("Nearest server is %.0f km away, searching within %.0f km", nearest, currentRange)
 	}
 
-	// Start timing for the entire operation
-	operationStart := time.Now()
-	defer func() {
-		if config.LogLevel <= logging.LogLevelDebug {
-			elapsed := time.Since(operationStart)
-			log.Printf("Total operation completed in %v", elapsed)
+	return index.Within(currentRange), nil
+}
+
+// rankLocations sorts pinged locations by latency, or by a combined score with --rank combined. When every ping timed
+// out and distance fallback is enabled, it sorts by distance instead, prints a notice, and returns true.
+func rankLocations(config *cli.Config, timings *timing.Collector, locations []relays.Location, stdout io.Writer) bool {
+	if !config.FallbackDistance || !allTimedOut(locations) {
+		if config.Rank == cli.RankCombined {
+			sortLocationsByScore(timings, locations, rank.Combined(config.DistanceWeight))
+		} else {
+			sortLocationsByLatency(timings, locations)
 		}
-	}()
+		return false
+	}
 
-	// Parse relays file
-	if config.LogLevel <= logging.LogLevelDebug {
-		log.Println("Parsing relays file...")
+	if config.LogLevel <= logging.LogLevelWarning {
+		log.Printf("Warning: all %d servers timed out, falling back to ranking by distance", len(locations))
 	}
-	relaysData, err := deps.ParseRelaysFile(config.LogLevel, "", relays.GetRelaysFilePath)
-	if err != nil {
-		return err
+	formatter.SortLocationsByDistance(locations)
+	_, _ = fmt.Fprint(
+		stdout,
+		"NOTICE: No servers responded to ping. Servers are ranked by distance instead of latency.\n\n",
+	)
+	return true
+}
+
+// filterByLatency keeps the locations that responded in less than maxLatency milliseconds
+func filterByLatency(locations []relays.Location, maxLatency float64) []relays.Location {
+	var filtered []relays.Location
+	for _, loc := range locations {
+		if loc.Latency != nil && *loc.Latency < maxLatency {
+			filtered = append(filtered, loc)
+		}
 	}
+	return filtered
+}
 
-	// Get locations from relays file, optionally filtered by anti-censorship, DAITA, and IPv6
-	if config.LogLevel <= logging.LogLevelDebug {
-		log.Println("Fetching and filtering relay locations...")
+// allTimedOut reports whether no location has a latency value
+func allTimedOut(locations []relays.Location) bool {
+	for _, loc := range locations {
+		if loc.Latency != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// probeLocations pings locations, first sampling at most config.Sample servers per city when sampling is enabled.
+// With config.SampleFullCity, the remaining servers of the best sampled city are pinged as well.
+func probeLocations(
+	ctx context.Context,
+	config *cli.Config,
+	locations []relays.Location,
+	seed int64,
+	pingFn func(context.Context, []relays.Location, int, int, relays.IPVersion, logging.LogLevel) ([]relays.Location, error),
+) ([]relays.Location, error) {
+	timings := timing.FromContext(ctx)
+	if config.Sample == 0 {
+		return pingLocations(
+			ctx,
+			timings,
+			config.LogLevel,
+			locations,
+			config.Timeout,
+			config.Workers,
+			config.IPVersion,
+			pingFn,
+		)
+	}
+
+	sampled := relays.SampleByCity(locations, config.Sample, rand.New(rand.NewPCG(uint64(seed), 0)))
+	if config.LogLevel <= logging.LogLevelInfo {
+		log.Printf("Sampled %d of %d servers (%d per city, seed %d)", len(sampled), len(locations), config.Sample, seed)
 	}
-	locations, err := getLocations(
+
+	results, err := pingLocations(
+		ctx,
+		timings,
 		config.LogLevel,
-		relaysData,
-		config.AntiCensorship,
-		config.Daita,
+		sampled,
+		config.Timeout,
+		config.Workers,
 		config.IPVersion,
+		pingFn,
 	)
-	if err == nil {
-		if config.LogLevel <= logging.LogLevelDebug {
-			log.Printf("Found %d matching servers", len(locations))
-		}
-	} else {
-		return err
+	if err != nil || !config.SampleFullCity {
+		return results, err
 	}
 
-	if len(locations) == 0 {
-		return fmt.Errorf("no servers found")
+	sortLocationsByLatency(timings, results)
+	if len(results) == 0 || results[0].Latency == nil {
+		return results, nil
 	}
+	best := results[0]
 
-	// Deterministic output is self-contained; skip live geolocation, distance filtering, and pinging
-	if config.DeterministicOutput {
-		writeDeterministicOutput(config, deps.Stdout)
-		return nil
+	probed := make(map[string]bool, len(results))
+	for _, loc := range results {
+		probed[loc.Hostname] = true
+	}
+	var remaining []relays.Location
+	for _, loc := range locations {
+		if loc.Country == best.Country && loc.City == best.City && !probed[loc.Hostname] {
+			remaining = append(remaining, loc)
+		}
+	}
+	if len(remaining) == 0 {
+		return results, nil
 	}
 
-	// Get user location
-	if config.LogLevel <= logging.LogLevelDebug {
-		log.Println("Fetching user location...")
+	if config.LogLevel <= logging.LogLevelInfo {
+		log.Printf("Pinging %d remaining servers in %s, %s", len(remaining), best.City, best.Country)
 	}
-	userLoc, err := getUserLocation(ctx, config.LogLevel, deps.GetUserLocation)
+	cityResults, err := pingLocations(
+		ctx,
+		timings,
+		config.LogLevel,
+		remaining,
+		config.Timeout,
+		config.Workers,
+		config.IPVersion,
+		pingFn,
+	)
 	if err != nil {
-		return fmt.Errorf("failed to get user location: %w", err)
+		return nil, err
 	}
 
-	// Best server mode: progressively expand range until we find servers
-	if config.BestServerMode {
-		err := runBestServerMode(
+	return append(results, cityResults...), nil
+}
+
+// resolveIPVersion pings the servers nearest to the user over IPv4 and IPv6, sets config.IPVersion to the version
+// with the lower median latency and returns the locations reachable over it. IPv4 is kept on a tie, and when IPv6
+// is unavailable or no server responds over it.
+func resolveIPVersion(
+	ctx context.Context,
+	config *cli.Config,
+	deps Dependencies,
+	locations []relays.Location,
+	userLoc *api.UserLocation,
+) []relays.Location {
+	var dualStack []relays.Location
+	for _, loc := range locations {
+		if loc.IPv6Address != "" {
+			dualStack = append(dualStack, loc)
+		}
+	}
+	if len(dualStack) == 0 {
+		return locations
+	}
+	if err := deps.CheckIPv6Route(dualStack[0].IPv6Address); err != nil {
+		if config.LogLevel <= logging.LogLevelInfo {
+			log.Printf("IPv6 is not available (%v), using IPv4", err)
+		}
+		return locations
+	}
+
+	candidates := slices.Clone(dualStack)
+	distance.AnnotateDistances(candidates, userLoc.Latitude, userLoc.Longitude)
+	formatter.SortLocationsByDistance(candidates)
+	candidates = candidates[:min(len(candidates), ipVersionProbes)]
+
+	median := func(ipVersion relays.IPVersion) *float64 {
+		pinged, err := pingLocations(
 			ctx,
+			timing.FromContext(ctx),
 			config.LogLevel,
-			locations,
-			userLoc,
+			slices.Clone(candidates),
 			config.Timeout,
 			config.Workers,
-			config.IPVersion,
-			deps.Stdout,
+			ipVersion,
 			deps.PingLocations,
 		)
-		if err == nil && userLoc.MullvadExitIP {
-			_, _ = fmt.Fprint(
-				deps.Stdout,
-				"\nWARNING: You are connected to Mullvad VPN. Results might not be meaningful.\n",
-			)
+		if err != nil {
+			warnings.FromContext(ctx).Add(warnings.ProbeFailed, "failed to ping over %s: %v", ipVersion, err)
+			return nil
 		}
-		return err
+		return formatter.Summarize(pinged).P50Latency
 	}
+	v4, v6 := median(relays.IPv4), median(relays.IPv6)
 
-	// Normal mode: filter by distance
-	if config.LogLevel <= logging.LogLevelDebug {
-		log.Printf("Filtering servers within %.0f km...", config.MaxDistance)
+	if v6 == nil || (v4 != nil && *v4 <= *v6) {
+		if config.LogLevel <= logging.LogLevelInfo {
+			log.Printf("Using IPv4 (median %s ms, IPv6 %s ms)", formatMedian(v4), formatMedian(v6))
+		}
+		return locations
 	}
-	locations = filterByDistance(config.LogLevel, locations, userLoc.Latitude, userLoc.Longitude, config.MaxDistance)
 
-	if config.LogLevel <= logging.LogLevelDebug {
-		serverWord := "servers"
-		if len(locations) == 1 {
-			serverWord = "server"
-		}
-		log.Printf("%d %s found within %.0f km", len(locations), serverWord, config.MaxDistance)
+	if config.LogLevel <= logging.LogLevelInfo {
+		log.Printf("Using IPv6 (median %s ms, IPv4 %s ms)", formatMedian(v6), formatMedian(v4))
 	}
+	config.IPVersion = relays.IPv6
+	return dualStack
+}
 
-	if len(locations) == 0 {
-		_, _ = fmt.Fprintf(deps.Stdout, "No servers found within %.0f km of your location\n", config.MaxDistance)
+// probeIPv6 pings the locations with an IPv6 address over IPv6 and records the latency in their LatencyIPv6, for
+// --ip-version both. Results are matched back by hostname, so that each relay stays a single row with both
+// latencies however the pinger orders them, and the rest of the pipeline counts it once.
+func probeIPv6(
+	ctx context.Context,
+	config *cli.Config,
+	locations []relays.Location,
+	pingFn func(context.Context, []relays.Location, int, int, relays.IPVersion, logging.LogLevel) ([]relays.Location, error),
+) error {
+	var dualStack []relays.Location
+	for _, loc := range locations {
+		if loc.IPv6Address != "" {
+			dualStack = append(dualStack, loc)
+		}
+	}
+	if len(dualStack) == 0 {
 		return nil
 	}
 
-	// Ping locations
 	if config.LogLevel <= logging.LogLevelDebug {
-		log.Println("Pinging servers...")
+		log.Printf("Pinging %d servers over IPv6...", len(dualStack))
 	}
-	locations, err = pingLocations(
+	pinged, err := pingLocations(
 		ctx,
+		timing.FromContext(ctx),
 		config.LogLevel,
-		locations,
+		dualStack,
 		config.Timeout,
 		config.Workers,
-		config.IPVersion,
-		deps.PingLocations,
+		relays.IPv6,
+		pingFn,
 	)
 	if err != nil {
 		return err
 	}
 
-	// Sort and display results
-	if config.LogLevel <= logging.LogLevelDebug {
-		log.Println("Sorting servers by latency...")
+	latencies := make(map[string]*float64, len(pinged))
+	for _, loc := range pinged {
+		latencies[loc.Hostname] = loc.Latency
+	}
+	for i := range locations {
+		locations[i].LatencyIPv6 = latencies[locations[i].Hostname]
 	}
-	sortLocationsByLatency(config.LogLevel, locations)
+	return nil
+}
 
-	table := formatter.FormatTable(locations, config.IPVersion.IsIPv6())
-	_, _ = fmt.Fprint(deps.Stdout, table)
+// formatMedian formats a median latency for logging, "timeout" when no server responded
+func formatMedian(median *float64) string {
+	if median == nil {
+		return "timeout"
+	}
+	return fmt.Sprintf("%.2f", *median)
+}
 
-	if userLoc.MullvadExitIP {
-		_, _ = fmt.Fprint(
-			deps.Stdout,
-			"\nWARNING: You are connected to Mullvad VPN. Results might not be meaningful.\n",
+// probeStability pings the best reachable servers once a second for config.Stability seconds and returns their
+// stats, most stable first. Returns nil when no server responded.
+func probeStability(
+	ctx context.Context,
+	config *cli.Config,
+	ranked []relays.Location,
+	pingFn func(context.Context, []relays.Location, int, int, relays.IPVersion, logging.LogLevel) ([]relays.Location, error),
+) ([]stability.Stats, error) {
+	var candidates []relays.Location
+	for _, loc := range ranked {
+		if loc.Latency != nil && len(candidates) < stabilityCandidates {
+			candidates = append(candidates, loc)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+
+	if config.LogLevel <= logging.LogLevelInfo {
+		log.Printf("Pinging %d servers every second for %d seconds", len(candidates), config.Stability)
+	}
+	timings := timing.FromContext(ctx)
+	ping := func(ctx context.Context, locations []relays.Location) ([]relays.Location, error) {
+		return pingLocations(
+			ctx,
+			timings,
+			config.LogLevel,
+			locations,
+			config.Timeout,
+			config.Workers,
+			config.IPVersion,
+			pingFn,
 		)
 	}
+	return stability.Probe(ctx, candidates, config.Stability, time.Second, ping)
+}
+
+// formatTimeoutWarning warns when pinged servers are too far away to plausibly answer within the timeout, so that
+// their timeouts are likely caused by the configuration. Empty with --timeout auto, which raises the timeout instead.
+func formatTimeoutWarning(config *cli.Config, pinged []relays.Location) string {
+	if config.Timeout == ping.AutoTimeout {
+		return ""
+	}
+	tooFar := ping.TooFarForTimeout(pinged, config.Timeout, config.TimeoutPerKm)
+	if tooFar == 0 {
+		return ""
+	}
+	serverWord := "servers are"
+	if tooFar == 1 {
+		serverWord = "server is"
+	}
+	timeout, advice := fmt.Sprintf("%d ms", config.Timeout), "Use a longer -t or --timeout auto."
+	if config.TimeoutPerKm > 0 {
+		timeout = fmt.Sprintf("%d ms + %g ms/km", config.Timeout, config.TimeoutPerKm)
+		advice = "Use a longer -t or --timeout-per-km."
+	}
+	return fmt.Sprintf(
+		"\nWARNING: %d %s too far away to reliably respond within the %s timeout (up to %.0f ms expected). %s\n",
+		tooFar,
+		serverWord,
+		timeout,
+		ping.FarthestExpectedRTT(pinged),
+		advice,
+	)
+}
+
+// annotateASN sets the autonomous system of every location from the database given with --asn-db
+func annotateASN(config *cli.Config, locations []relays.Location) error {
+	db, err := asn.Open(config.ASNDatabase)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = db.Close() }()
 
+	found := db.Annotate(locations, config.IPVersion)
+	if config.LogLevel <= logging.LogLevelDebug {
+		log.Printf("Found the autonomous system of %d of %d servers", found, len(locations))
+	}
 	return nil
 }
 
-// writeDeterministicOutput renders fixed sample data, independent of geolocation, distance, and latency
-func writeDeterministicOutput(config *cli.Config, stdout io.Writer) {
-	locations := getDeterministicLocations()
+// warnTimedOutPrefixes adds a warning for each network prefix in which every server timed out and returns them
+func warnTimedOutPrefixes(
+	ctx context.Context,
+	locations []relays.Location,
+	ipVersion relays.IPVersion,
+) []ping.PrefixTimeout {
+	prefixes := ping.TimedOutPrefixes(locations, ipVersion)
+	for _, p := range prefixes {
+		warnings.FromContext(ctx).Add(
+			warnings.NetworkBlock,
+			"all %d servers in %s timed out, which suggests a network-level block rather than individual server issues",
+			p.Servers,
+			p.Prefix,
+		)
+	}
+	return prefixes
+}
 
-	if config.BestServerMode {
-		if len(locations) > 0 {
-			userLoc := getDeterministicUserLocation()
-			output := formatter.FormatBestServer(userLoc, locations[0], config.IPVersion.IsIPv6())
-			_, _ = fmt.Fprint(stdout, output)
+// locateUser returns the user location cached by the Mullvad app with --app-location, and asks the Mullvad API
+// otherwise or when the app has no location, unless --offline forbids it
+func locateUser(
+	ctx context.Context,
+	config *cli.Config,
+	timings *timing.Collector,
+	deps Dependencies,
+) (*api.UserLocation, error) {
+	if config.AppLocation {
+		userLoc, err := getUserLocation(ctx, timings, config.LogLevel, deps.GetAppLocation)
+		if err == nil {
+			return userLoc, nil
 		}
-		return
+		if config.Offline {
+			return nil, fmt.Errorf("failed to get the location from the Mullvad app, which --offline requires: %w", err)
+		}
+		warnings.FromContext(ctx).Add(warnings.LocationSource, "%v, asking the Mullvad API instead", err)
+	}
+	return getUserLocation(ctx, timings, config.LogLevel, deps.GetUserLocation)
+}
+
+// runSeed returns the seed for random sampling and the probe order, picking one at random unless set explicitly
+func runSeed(config *cli.Config) int64 {
+	if config.SeedSet {
+		return config.Seed
+	}
+	return rand.Int64()
+}
+
+// formatSampleNote describes how servers were sampled so the run can be reproduced
+func formatSampleNote(config *cli.Config, seed int64) string {
+	return fmt.Sprintf("\nSampled up to %d servers per city (seed %d)\n", config.Sample, seed)
+}
+
+// formatGoodEnoughNote notes that the ranking may be partial when --good-enough found a server, and is empty
+// otherwise. The ranked locations are sorted, so the first one tells whether a good enough server was found.
+func formatGoodEnoughNote(config *cli.Config, ranked []relays.Location) string {
+	if config.GoodEnough == 0 || len(ranked) == 0 || ranked[0].Latency == nil ||
+		*ranked[0].Latency >= float64(config.GoodEnough) {
+		return ""
+	}
+	return fmt.Sprintf("\nRanked the servers pinged until one responded in less than %d ms (--good-enough)\n",
+		config.GoodEnough)
+}
+
+func run(ctx context.Context, args []string, deps Dependencies) error {
+	// Parse command-line flags
+	config, err := cli.ParseFlags(args, Version)
+	if err != nil {
+		return err
+	}
+	if config.LogLevel <= logging.LogLevelDebug {
+		log.Printf("Config: %+v", config)
 	}
 
-	table := formatter.FormatTable(locations, config.IPVersion.IsIPv6())
-	_, _ = fmt.Fprint(stdout, table)
+	// Every HTTP request is checked against the context, so that --offline holds for all of them, and looks up its
+	// host with the resolver of the context, so that --doh holds for them too
+	netguard.Install()
+	resolve.Install()
+	if config.Offline {
+		ctx = netguard.WithOffline(ctx)
+	}
+	if config.DoHURL != "" {
+		ctx = resolve.WithResolver(ctx, resolver(config))
+	}
+	if config.UserAgent != "" {
+		ctx = withUserAgent(ctx, config.UserAgent)
+	}
+
+	// Handle help flag
+	if config.ShowHelp {
+		cli.PrintUsage(deps.Stdout, Version)
+		return nil
+	}
+
+	// Handle version flag
+	if config.ShowVersion {
+		if config.VerboseVersion {
+			_, _ = fmt.Fprint(deps.Stdout, buildinfo.Read(Version, Commit, BuildDate).String())
+			return nil
+		}
+		_, _ = fmt.Fprintf(deps.Stdout, "mullvad-compass %s\n", Version)
+		return nil
+	}
+
+	if config.Command == cli.CommandCheck {
+		return runCheck(ctx, config, deps)
+	}
+
+	if config.Command == cli.CommandCompare {
+		return runCompare(config, deps.Stdout)
+	}
+
+	if config.Command == cli.CommandValidate {
+		return runValidate(config, deps.Stdout)
+	}
+
+	if config.Command == cli.CommandPaths {
+		return runPaths(config, deps, deps.Stdout)
+	}
+
+	if config.Command == cli.CommandService {
+		return runService(config, args, deps.Stdout)
+	}
+
+	if config.Command == cli.CommandTunnel {
+		return runTunnel(ctx, config, deps)
+	}
+
+	if config.Command == cli.CommandBench {
+		return runBenchInternal(ctx, config, deps.Stdout)
+	}
+
+	if config.Sudo && pingsRelays(config) {
+		if elevated, err := elevateIfNeeded(config, deps, args); elevated || err != nil {
+			return err
+		}
+	}
+
+	if config.Every > 0 {
+		return runEvery(ctx, args, config, deps)
+	}
+
+	return search(ctx, config, deps)
+}
+
+// search runs a single search for the best servers: the default command, and the favorite, ignore, ports,
+// capabilities and plan commands that share its relay filtering
+func search(ctx context.Context, config *cli.Config, deps Dependencies) error {
+	// Start timing for the entire operation
+	timings := timing.New(timing.WithLogLevel(config.LogLevel), timing.WithClock(clock.FromContext(ctx).Now))
+	ctx = timing.WithCollector(ctx, timings)
+	warns := warnings.New(warnings.WithLogLevel(config.LogLevel))
+	ctx = warnings.WithCollector(ctx, warns)
+	defer func() {
+		if config.LogLevel <= logging.LogLevelDebug {
+			log.Printf("Total operation completed in %v", timings.Total())
+		}
+	}()
+
+	// Parse relays file
+	if config.LogLevel <= logging.LogLevelDebug {
+		log.Println("Parsing relays file...")
+	}
+	relaysPath := ""
+	var err error
+	if config.UpdateRelays {
+		relaysPath, err = updateRelays(ctx, config, timings, deps)
+		if err != nil {
+			return err
+		}
+	}
+	stopParse := timings.Start(timing.PhaseParse, "Parse relays file")
+	relaysData, err := deps.ParseRelaysFile(ctx, config.LogLevel, relaysPath, relays.GetRelaysFilePath)
+	stopParse()
+	if err != nil {
+		return err
+	}
+	if err := validateRelayEndpoints(ctx, relaysData, config.Strict, config.AllowPrivate); err != nil {
+		return err
+	}
+	if checkCoordinates(config, relaysData, deps.Stdout) {
+		return nil
+	}
+
+	if config.Command == cli.CommandFavorite || config.Command == cli.CommandIgnore {
+		return runHostList(config, relaysData, deps)
+	}
+
+	var appSettings *appsettings.Settings
+	if config.UseAppSettings {
+		appSettings, err = deps.LoadAppSettings(config.LogLevel)
+		if err != nil {
+			return err
+		}
+		applyAppSettingsDefaults(config, appSettings)
+	}
+
+	// Get locations from relays file, optionally filtered by anti-censorship, DAITA, and IPv6
+	if config.LogLevel <= logging.LogLevelDebug {
+		log.Println("Fetching and filtering relay locations...")
+	}
+	var locations []relays.Location
+	if config.ServerType == relays.BridgeServer {
+		locations, err = getBridgeLocations(ctx, timings, relaysData, config.IPVersion)
+	} else {
+		locations, err = getLocations(
+			ctx,
+			timings,
+			relaysData,
+			config.AntiCensorship,
+			config.Daita,
+			config.Exclude,
+			config.IPVersion,
+		)
+	}
+	if err != nil {
+		return err
+	}
+	if len(config.Countries) > 0 {
+		locations = relays.FilterByCountry(locations, config.Countries)
+		if len(locations) == 0 {
+			return fmt.Errorf("%w in %s", errs.ErrNoServers, strings.Join(config.Countries, ", "))
+		}
+	}
+	if config.BestIn != "" {
+		locations = relays.FilterByPlace(locations, config.BestIn)
+		if len(locations) == 0 {
+			return fmt.Errorf("%w in %s", errs.ErrNoServers, config.BestIn)
+		}
+	}
+	if config.Preset != "" {
+		locations = filterByPreset(config, locations)
+		if len(locations) == 0 {
+			return fmt.Errorf("%w matching --preset %s", errs.ErrNoServers, config.Preset)
+		}
+	}
+	// The constraint cost search applies the app's constraints to one of its two searches only
+	if appSettings != nil && !config.ConstraintCost {
+		locations = filterByAppSettings(config, appSettings, locations)
+		if len(locations) == 0 {
+			return fmt.Errorf("%w that the Mullvad app's relay settings allow", errs.ErrNoServers)
+		}
+	}
+	locations, err = applyHostLists(config, deps, locations)
+	if err != nil {
+		return err
+	}
+	if config.LogLevel <= logging.LogLevelDebug {
+		log.Printf("Found %d matching servers", len(locations))
+	}
+
+	if len(locations) == 0 {
+		return errs.ErrNoServers
+	}
+
+	if config.Command == cli.CommandPorts {
+		output := formatter.FormatPorts(
+			relaysData.WireGuard.PortRanges,
+			relaysData.WireGuard.ShadowsocksPortRanges,
+			locations,
+		)
+		_, _ = fmt.Fprint(deps.Stdout, output)
+		return nil
+	}
+
+	if config.Command == cli.CommandCapabilities {
+		cities := relays.CapabilityMatrix(locations)
+		if config.Plain {
+			_, _ = fmt.Fprint(deps.Stdout, formatter.FormatPlainCapabilities(cities))
+		} else {
+			_, _ = fmt.Fprint(deps.Stdout, formatter.FormatCapabilities(cities))
+		}
+		return nil
+	}
+
+	if config.Command == cli.CommandPlan {
+		return runPlan(config, relaysData, locations, deps.Stdout)
+	}
+
+	// Deterministic output is self-contained; skip live geolocation, distance filtering, and pinging
+	if config.DeterministicOutput {
+		writeDeterministicOutput(config, deps.Stdout)
+		return nil
+	}
+
+	// Fail fast instead of printing a page of timeouts when the host cannot reach IPv6 servers
+	if config.IPVersion.IsIPv6() {
+		if config.LogLevel <= logging.LogLevelDebug {
+			log.Printf("Checking IPv6 route to %s...", locations[0].IPv6Address)
+		}
+		if err := deps.CheckIPv6Route(locations[0].IPv6Address); err != nil {
+			return fmt.Errorf("IPv6 is not available on this host (%w); run without -6 to use IPv4", err)
+		}
+	}
+	hostIPv6 := detectHostIPv6(ctx, config, deps, locations)
+
+	// Validate the probe source up front; pingers pick it up from the context
+	source := ping.Source{Interface: config.Interface, Address: config.SourceIP, Netns: config.Netns}
+	if !source.IsZero() || source.Netns != "" {
+		addr, err := source.Resolve(config.IPVersion)
+		if err != nil {
+			return fmt.Errorf("invalid probe source: %w", err)
+		}
+		if config.LogLevel <= logging.LogLevelDebug {
+			log.Printf(
+				"Sending pings from %v (interface: %q, network namespace: %q)",
+				addr,
+				config.Interface,
+				config.Netns,
+			)
+		}
+		ctx = ping.WithSource(ctx, source)
+	}
+
+	if config.Runs > 1 {
+		deps.PingLocations = repeatPings(deps.PingLocations, config.Runs, runGap)
+	}
+	if config.VerifyOutliers {
+		deps.PingLocations = verifyOutliers(deps.PingLocations)
+	}
+
+	// Overlapping runs (e.g. from cron) would double the ICMP load and skew each other's latencies
+	if !config.NoLock && deps.LockPath != nil {
+		lockPath, err := deps.LockPath()
+		if err != nil {
+			return err
+		}
+		release, err := acquireRunLock(lockPath)
+		if err != nil {
+			return err
+		}
+		defer release()
+	}
+
+	if config.PcapFile != "" {
+		capture, stop, err := startCapture(ctx, config)
+		if err != nil {
+			return err
+		}
+		defer stop()
+		ctx = ping.WithCapture(ctx, capture)
+	}
+
+	hookRunner, err := newHookRunner(config, deps)
+	if err != nil {
+		return err
+	}
+	if err := hookRunner.Run(ctx, hooks.Payload{Event: hooks.PreRun}); err != nil {
+		return err
+	}
+
+	// Get user location
+	if config.LogLevel <= logging.LogLevelDebug {
+		log.Println("Fetching user location...")
+	}
+	userLoc, err := locateUser(ctx, config, timings, deps)
+	if err != nil {
+		return fmt.Errorf("failed to get user location: %w", err)
+	}
+
+	seed := runSeed(config)
+	if config.LogLevel <= logging.LogLevelDebug {
+		log.Printf("Probing servers in random order (seed %d)", seed)
+	}
+	ctx = ping.WithShuffledOrder(ctx, seed)
+	if config.TimeoutPerKm > 0 {
+		ctx = ping.WithTimeoutPerKm(ctx, config.TimeoutPerKm)
+	}
+
+	if config.AutoIPVersion {
+		locations = resolveIPVersion(ctx, config, deps, locations, userLoc)
+	}
+	// Set after the IP version probe, which compares complete medians
+	if config.GoodEnough > 0 {
+		ctx = ping.WithGoodEnough(ctx, float64(config.GoodEnough))
+	}
+
+	if config.ASNDatabase != "" {
+		if err := annotateASN(config, locations); err != nil {
+			return err
+		}
+	}
+
+	locations, unlocated := splitUnlocated(ctx, config, locations)
+	if len(locations)+len(unlocated) == 0 {
+		return fmt.Errorf("%w with coordinates in relays.json", errs.ErrNoServers)
+	}
+
+	// The reference is pinged alongside the servers, and waited for once they are ranked
+	var waitReference func() formatter.Reference
+	if config.Calibrate != "" {
+		if waitReference, err = startCalibration(ctx, config, deps); err != nil {
+			return err
+		}
+	}
+	var waitBaseline func() []relays.Location
+	if config.Baseline {
+		waitBaseline = startBaseline(ctx, config, deps)
+	}
+
+	// A shared report, GeoJSON or the summary line replaces the regular output
+	stdout := deps.Stdout
+	if config.Share != "" || config.Output == cli.OutputGeoJSON || config.SummaryOnly {
+		deps.Stdout = io.Discard
+	}
+
+	// Best server mode: progressively expand range until we find servers
+	if config.BestServerMode {
+		var ranked []relays.Location
+		if config.ConstraintCost {
+			ranked, err = runConstraintCost(
+				ctx,
+				config,
+				appSettings,
+				locations,
+				unlocated,
+				userLoc,
+				seed,
+				deps.Stdout,
+				deps.PingLocations,
+			)
+		} else {
+			ranked, err = runBestServerMode(
+				ctx,
+				config,
+				locations,
+				unlocated,
+				userLoc,
+				seed,
+				deps.Stdout,
+				deps.PingLocations,
+			)
+		}
+		if err != nil && !errors.Is(err, errDistanceFallback) {
+			return err
+		}
+		if config.Share != "" {
+			shareErr := writeShareReport(
+				stdout,
+				config,
+				timings,
+				warns,
+				*userLoc,
+				ranked,
+				nil,
+				ping.TimedOutPrefixes(ranked, config.IPVersion),
+				err != nil,
+				hostIPv6,
+			)
+			if shareErr != nil {
+				return shareErr
+			}
+		}
+		if config.Output == cli.OutputGeoJSON {
+			if geoErr := writeGeoJSON(stdout, config, *userLoc, ranked); geoErr != nil {
+				return geoErr
+			}
+		}
+		if config.Output == cli.OutputHTML {
+			if reportErr := writeHTMLReport(ctx, config, *userLoc, ranked, deps.Stdout); reportErr != nil {
+				return reportErr
+			}
+		}
+		if waitReference != nil {
+			_, _ = fmt.Fprint(deps.Stdout, "\n"+formatter.FormatReference(waitReference()))
+		}
+		_, _ = fmt.Fprint(deps.Stdout, formatAsymmetry(ctx, config, deps, ranked))
+		if config.Sample > 0 {
+			_, _ = fmt.Fprint(deps.Stdout, formatSampleNote(config, seed))
+		}
+		_, _ = fmt.Fprint(deps.Stdout, formatRunsNote(config))
+		_, _ = fmt.Fprint(deps.Stdout, formatGoodEnoughNote(config, ranked))
+		_, _ = fmt.Fprint(deps.Stdout, formatTimeoutWarning(config, ranked))
+		if userLoc.MullvadExitIP {
+			_, _ = fmt.Fprint(deps.Stdout, connectedWarning)
+		}
+		recordTimeouts(config, deps, ranked, err != nil)
+		recordRun(ctx, config, deps, ranked)
+		if config.Command == cli.CommandApply && err == nil && len(ranked) > 0 {
+			if applyErr := applyBestRelay(ctx, config, deps, ranked[0], deps.Stdout); applyErr != nil {
+				return applyErr
+			}
+		}
+		if hookErr := runPostHooks(ctx, hookRunner, config, ranked, deps.Stdout); hookErr != nil {
+			return hookErr
+		}
+		writeSummaryLine(stdout, config, timings, ranked)
+		writeTimings(stdout, config, timings)
+		return err
+	}
+
+	// Normal mode: filter by distance, unless the limit spans the globe (a country filter without -m)
+	allLocations := append(slices.Clip(locations), unlocated...)
+	if config.MaxDistance >= maxSearchRadius {
+		distance.AnnotateDistances(locations, userLoc.Latitude, userLoc.Longitude)
+	} else {
+		if config.LogLevel <= logging.LogLevelDebug {
+			log.Printf("Filtering servers within %.0f km...", config.MaxDistance)
+		}
+		locations, err = filterByDistance(
+			ctx,
+			timings,
+			config.LogLevel,
+			locations,
+			userLoc.Latitude,
+			userLoc.Longitude,
+			config.MaxDistance,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	locations = append(locations, unlocated...)
+
+	if config.LogLevel <= logging.LogLevelDebug {
+		serverWord := "servers"
+		if len(locations) == 1 {
+			serverWord = "server"
+		}
+		log.Printf("%d %s found within %.0f km", len(locations), serverWord, config.MaxDistance)
+	}
+
+	if len(locations) == 0 {
+		_, _ = fmt.Fprintf(deps.Stdout, "No servers found within %.0f km of your location", config.MaxDistance)
+		if nearest, ok := distance.NewIndex(allLocations, userLoc.Latitude, userLoc.Longitude).Nearest(); ok {
+			_, _ = fmt.Fprintf(deps.Stdout, " (nearest server is %.0f km away)", nearest)
+		}
+		_, _ = fmt.Fprintln(deps.Stdout)
+		return writeNoServers(stdout, config, timings, warns, *userLoc, len(allLocations), hostIPv6)
+	}
+
+	// Ping locations
+	if config.LogLevel <= logging.LogLevelDebug {
+		log.Println("Pinging servers...")
+	}
+	locations, err = probeLocations(ctx, config, locations, seed, deps.PingLocations)
+	if err != nil {
+		return err
+	}
+	timedOut := warnTimedOutPrefixes(ctx, locations, config.IPVersion)
+	if config.DualStack {
+		if err := probeIPv6(ctx, config, locations, deps.PingLocations); err != nil {
+			return err
+		}
+	}
+
+	// Sort and display results
+	if config.LogLevel <= logging.LogLevelDebug {
+		log.Println("Sorting servers by latency...")
+	}
+	fellBack := rankLocations(config, timings, locations, deps.Stdout)
+
+	// The latency threshold trims the displayed rows only; the summary and hooks still cover every server
+	shown := locations
+	if config.LatencyUnder > 0 {
+		shown = filterByLatency(locations, float64(config.LatencyUnder))
+		if config.LogLevel <= logging.LogLevelDebug {
+			log.Printf("%d of %d servers responded in less than %d ms", len(shown), len(locations), config.LatencyUnder)
+		}
+		if len(shown) == 0 {
+			_, _ = fmt.Fprintf(deps.Stdout, "No servers responded in less than %d ms\n", config.LatencyUnder)
+		}
+	}
+
+	counts := formatter.Counts{
+		Matching:       len(allLocations),
+		Shown:          len(shown),
+		BeyondDistance: len(allLocations) - len(locations),
+		AboveLatency:   len(locations) - len(shown),
+	}
+
+	var stats []stability.Stats
+	if config.Stability > 0 && !fellBack {
+		stats, err = probeStability(ctx, config, shown, deps.PingLocations)
+		if err != nil {
+			return err
+		}
+	}
+
+	var ref *formatter.Reference
+	if waitReference != nil {
+		reference := waitReference()
+		ref = &reference
+	}
+
+	// The baseline rows follow the servers, outside the ranking, the summary and the footnote
+	rows := shown
+	if waitBaseline != nil {
+		rows = append(slices.Clip(shown), waitBaseline()...)
+	}
+
+	stopFormat := timings.Start(timing.PhaseFormat, "Format results")
+	table := formatResultsTable(config, rows, ref)
+	if len(stats) > 0 {
+		table = formatStabilityTable(config, stats)
+	}
+	stopFormat()
+	_, _ = fmt.Fprint(deps.Stdout, table)
+
+	// The stability table only covers the best servers, and an empty table is explained already
+	if len(stats) == 0 && len(shown) > 0 {
+		if footnote := formatter.FormatFootnote(counts, config.MaxDistance, config.LatencyUnder); footnote != "" {
+			_, _ = fmt.Fprint(deps.Stdout, "\n"+footnote)
+		}
+	}
+
+	if ref != nil {
+		_, _ = fmt.Fprint(deps.Stdout, "\n"+formatter.FormatReference(*ref))
+	}
+
+	if config.ServerType == relays.BridgeServer {
+		_, _ = fmt.Fprint(deps.Stdout, "\n"+formatter.FormatShadowsocksEndpoints(relaysData.Bridge.Shadowsocks))
+	}
+
+	if !config.NoSummary {
+		_, _ = fmt.Fprint(deps.Stdout, "\n"+formatter.FormatSummary(formatter.Summarize(locations)))
+	}
+
+	if config.Sample > 0 {
+		_, _ = fmt.Fprint(deps.Stdout, formatSampleNote(config, seed))
+	}
+	_, _ = fmt.Fprint(deps.Stdout, formatRunsNote(config))
+	_, _ = fmt.Fprint(deps.Stdout, formatGoodEnoughNote(config, locations))
+	_, _ = fmt.Fprint(deps.Stdout, formatAsymmetry(ctx, config, deps, shown))
+
+	if len(stats) > 0 {
+		_, _ = fmt.Fprintf(
+			deps.Stdout,
+			"\nRanked the %d best servers by loss and latency deviation over a %d second window\n",
+			len(stats),
+			config.Stability,
+		)
+	}
+
+	_, _ = fmt.Fprint(deps.Stdout, formatTimeoutWarning(config, locations))
+
+	if userLoc.MullvadExitIP {
+		_, _ = fmt.Fprint(deps.Stdout, connectedWarning)
+	}
+
+	recordTimeouts(config, deps, locations, fellBack)
+	recordRun(ctx, config, deps, locations)
+
+	if config.Share != "" {
+		err := writeShareReport(stdout, config, timings, warns, *userLoc, shown, &counts, timedOut, fellBack, hostIPv6)
+		if err != nil {
+			return err
+		}
+	}
+	if config.Output == cli.OutputGeoJSON {
+		if err := writeGeoJSON(stdout, config, *userLoc, shown); err != nil {
+			return err
+		}
+	}
+	if config.Output == cli.OutputHTML {
+		if err := writeHTMLReport(ctx, config, *userLoc, shown, deps.Stdout); err != nil {
+			return err
+		}
+	}
+
+	if err := runPostHooks(ctx, hookRunner, config, locations, deps.Stdout); err != nil {
+		return err
+	}
+
+	writeSummaryLine(stdout, config, timings, locations)
+	writeTimings(stdout, config, timings)
+
+	if fellBack {
+		return errDistanceFallback
+	}
+
+	return nil
+}
+
+// splitUnlocated sets aside the relays that relays.json lists without coordinates, as their distance cannot be
+// measured. They are searched regardless of distance with --include-unlocated and skipped otherwise, so unlocated
+// is always empty without it.
+func splitUnlocated(
+	ctx context.Context,
+	config *cli.Config,
+	locations []relays.Location,
+) (located, unlocated []relays.Location) {
+	located, unlocated = relays.SplitUnlocated(locations)
+	if len(unlocated) == 0 {
+		return located, nil
+	}
+
+	if !config.IncludeUnlocated {
+		warnings.FromContext(ctx).Add(
+			warnings.SkippedRelays,
+			"%d relay(s) skipped due to missing coordinates (use --include-unlocated to search them)",
+			len(unlocated),
+		)
+		return located, nil
+	}
+	if config.LogLevel <= logging.LogLevelInfo {
+		log.Printf("Searching %d relay(s) without coordinates regardless of distance", len(unlocated))
+	}
+	return located, unlocated
+}
+
+// detectHostIPv6 reports whether the host can route to IPv6 servers, when the IPv6 addresses are shown or a JSON
+// report is written. The IPv6 column, shown by default or with --show-ips both, is hidden on an IPv4-only host, and
+// --ip-version both falls back to IPv4. Returns nil if the host was not checked.
+func detectHostIPv6(
+	ctx context.Context,
+	config *cli.Config,
+	deps Dependencies,
+	locations []relays.Location,
+) *bool {
+	if config.IPVersion.IsIPv6() {
+		available := true // Checked before pinging
+		return &available
+	}
+	showsIPv6 := config.ShowIPs != cli.ShowIPsV4
+	if deps.CheckIPv6Route == nil || (!showsIPv6 && !config.DualStack && config.Share != formatter.ShareJSON) {
+		return nil
+	}
+
+	i := slices.IndexFunc(locations, func(loc relays.Location) bool { return loc.IPv6Address != "" })
+	if i < 0 {
+		return nil
+	}
+	if config.LogLevel <= logging.LogLevelDebug {
+		log.Printf("Checking IPv6 route to %s...", locations[i].IPv6Address)
+	}
+	err := deps.CheckIPv6Route(locations[i].IPv6Address)
+	available := err == nil
+	if available {
+		return &available
+	}
+
+	warns := warnings.FromContext(ctx)
+	if config.DualStack {
+		config.DualStack = false
+		if config.ShowIPs == cli.ShowIPsBoth {
+			config.ShowIPs = cli.ShowIPsV4
+		}
+		warns.Add(warnings.IPv6Unavailable, "IPv6 is not available on this host (%v), pinging over IPv4 only", err)
+		return &available
+	}
+
+	switch config.ShowIPs {
+	case "":
+		// The IPv6 column is only shown by default where it is of use
+		config.ShowIPs = cli.ShowIPsV4
+	case cli.ShowIPsBoth:
+		config.ShowIPs = cli.ShowIPsV4
+		warns.Add(warnings.IPv6Unavailable, "IPv6 is not available on this host (%v), hiding the IPv6 column", err)
+	case cli.ShowIPsV6:
+		warns.Add(
+			warnings.IPv6Unavailable,
+			"IPv6 is not available on this host (%v), the IPv6 addresses cannot be reached",
+			err,
+		)
+	}
+	return &available
+}
+
+// writeShareReport prints an anonymized report of the ranked locations in place of the regular output, with the
+// number of servers hidden by the display limits in Table Mode and the prefixes in which every server timed out
+func writeShareReport(
+	stdout io.Writer,
+	config *cli.Config,
+	timings *timing.Collector,
+	warns *warnings.Collector,
+	userLoc api.UserLocation,
+	ranked []relays.Location,
+	counts *formatter.Counts,
+	timedOut []ping.PrefixTimeout,
+	rankedByDistance bool,
+	hostIPv6 *bool,
+) error {
+	report := formatter.NewShareReport(Version, userLoc, ranked, config.IPVersion.IsIPv6(), rankedByDistance)
+	build := buildinfo.Read(Version, Commit, BuildDate)
+	report.Build = &build
+	if config.Runs > 1 {
+		report.Runs = config.Runs
+		report.Aggregation = aggregationMedian
+	}
+	report.Counts = counts
+	report.HostIPv6 = hostIPv6
+	report.Warnings = warns.Warnings()
+	report.TimedOutPrefixes = timedOut
+	if config.Timings {
+		timingReport := timings.Report()
+		report.Timings = &timingReport
+	}
+	output, err := formatter.FormatShareReport(report, config.Share)
+	if err != nil {
+		return err
+	}
+	_, _ = fmt.Fprint(stdout, output)
+	return nil
+}
+
+// writeNoServers writes the machine-readable output of a search that found no servers within the distance: an
+// empty shared report, a FeatureCollection of the user's location only, or a summary line with nothing reachable
+func writeNoServers(
+	stdout io.Writer,
+	config *cli.Config,
+	timings *timing.Collector,
+	warns *warnings.Collector,
+	userLoc api.UserLocation,
+	matching int,
+	hostIPv6 *bool,
+) error {
+	if config.Share != "" {
+		counts := formatter.Counts{Matching: matching, BeyondDistance: matching}
+		err := writeShareReport(stdout, config, timings, warns, userLoc, nil, &counts, nil, false, hostIPv6)
+		if err != nil {
+			return err
+		}
+	}
+	if config.Output == cli.OutputGeoJSON {
+		if err := writeGeoJSON(stdout, config, userLoc, nil); err != nil {
+			return err
+		}
+	}
+	writeSummaryLine(stdout, config, timings, nil)
+	writeTimings(stdout, config, timings)
+	return nil
+}
+
+// writeGeoJSON prints the ranked locations and the user's location as GeoJSON in place of the regular output
+func writeGeoJSON(stdout io.Writer, config *cli.Config, userLoc api.UserLocation, ranked []relays.Location) error {
+	output, err := formatter.FormatGeoJSON(userLoc, ranked, config.IPVersion.IsIPv6())
+	if err != nil {
+		return err
+	}
+	_, _ = fmt.Fprint(stdout, output)
+	return nil
+}
+
+// writeHTMLReport writes the ranked locations to the HTML report given with --output html, and says where
+func writeHTMLReport(
+	ctx context.Context,
+	config *cli.Config,
+	userLoc api.UserLocation,
+	ranked []relays.Location,
+	stdout io.Writer,
+) error {
+	r := report.New(Version, clock.FromContext(ctx).Now(), userLoc, ranked, config.IPVersion.IsIPv6())
+	if err := report.WriteFile(config.OutputFile, r); err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintf(stdout, "\nWrote the HTML report to %s\n", config.OutputFile)
+	return nil
+}
+
+// writeSummaryLine prints the single line of --summary-only, covering every pinged server
+func writeSummaryLine(stdout io.Writer, config *cli.Config, timings *timing.Collector, locations []relays.Location) {
+	if !config.SummaryOnly {
+		return
+	}
+	_, _ = fmt.Fprint(stdout, formatter.FormatSummaryLine(formatter.Summarize(locations), timings.Total()))
+}
+
+// writeTimings prints how long each phase took with --timings. A JSON report embeds the timings instead, and
+// GeoJSON leaves them out.
+func writeTimings(stdout io.Writer, config *cli.Config, timings *timing.Collector) {
+	if !config.Timings || config.Share == formatter.ShareJSON || config.Output == cli.OutputGeoJSON {
+		return
+	}
+	_, _ = fmt.Fprint(stdout, "\n"+formatter.FormatTimings(timings.Report()))
+}
+
+// updateRelays downloads the relay list to the user's cache unless the cached copy is current, and returns its path
+func updateRelays(
+	ctx context.Context,
+	config *cli.Config,
+	timings *timing.Collector,
+	deps Dependencies,
+) (string, error) {
+	path, err := deps.RelaysCachePath()
+	if err != nil {
+		return "", err
+	}
+	if config.LogLevel <= logging.LogLevelDebug {
+		log.Printf("Updating relay list at %s...", path)
+	}
+	defer timings.Start(timing.PhaseParse, "Download relay list")()
+	if _, err := deps.DownloadRelays(ctx, config.LogLevel, path); err != nil {
+		return "", fmt.Errorf("failed to download relay list: %w", err)
+	}
+	return path, nil
+}
+
+// runCheck prints the exit IP, Mullvad connection, blacklist, and DNS leak status
+func runCheck(ctx context.Context, config *cli.Config, deps Dependencies) error {
+	if config.LogLevel <= logging.LogLevelDebug {
+		log.Println("Checking connection...")
+	}
+	check, err := deps.CheckConnection(ctx, config.LogLevel)
+	if err != nil {
+		return fmt.Errorf("failed to check connection: %w", err)
+	}
+
+	_, _ = fmt.Fprint(deps.Stdout, formatter.FormatConnectionCheck(*check))
+	return nil
+}
+
+// measureTunnel measures in-tunnel latency through the SOCKS5 proxy of the connected relay
+func measureTunnel(
+	ctx context.Context,
+	destinations []string,
+	timeout time.Duration,
+	logLevel logging.LogLevel,
+) []tunnel.Result {
+	return tunnel.Measure(ctx, tunnel.DefaultProxy, destinations, timeout, logLevel)
+}
+
+// runTunnel prints the latency to destinations through the relay the user is connected to
+func runTunnel(ctx context.Context, config *cli.Config, deps Dependencies) error {
+	userLoc, err := deps.GetUserLocation(ctx, config.LogLevel)
+	if err != nil {
+		return fmt.Errorf("failed to get user location: %w", err)
+	}
+	if !userLoc.MullvadExitIP {
+		return errors.New("not connected to Mullvad VPN, the tunnel command measures latency through a connected relay")
+	}
+
+	destinations := config.Args
+	if len(destinations) == 0 {
+		destinations = tunnel.DefaultDestinations
+	}
+	timeout := config.Timeout
+	if timeout == ping.AutoTimeout {
+		timeout = tunnelAutoTimeout
+	}
+	if config.LogLevel <= logging.LogLevelDebug {
+		log.Printf("Measuring %d destinations through %s...", len(destinations), userLoc.MullvadExitIPHostname)
+	}
+
+	results := deps.MeasureTunnel(ctx, destinations, time.Duration(timeout)*time.Millisecond, config.LogLevel)
+	_, _ = fmt.Fprint(deps.Stdout, formatter.FormatTunnel(*userLoc, results))
+
+	for _, result := range results {
+		if result.Total != nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("failed to connect through the SOCKS5 proxy at %s: %w", tunnel.DefaultProxy, results[0].Err)
+}
+
+// runCompare prints per-relay latency and rank changes between two recorded runs
+func runCompare(config *cli.Config, stdout io.Writer) error {
+	before, err := compare.Load(config.Args[0])
+	if err != nil {
+		return err
+	}
+	after, err := compare.Load(config.Args[1])
+	if err != nil {
+		return err
+	}
+
+	deltas := compare.Compare(before, after)
+	if config.LogLevel <= logging.LogLevelDebug {
+		log.Printf(
+			"Compared %d relays from %s with %d from %s",
+			len(before),
+			config.Args[0],
+			len(after),
+			config.Args[1],
+		)
+	}
+
+	_, _ = fmt.Fprint(stdout, formatter.FormatComparison(deltas))
+	if !config.NoSummary {
+		_, _ = fmt.Fprint(stdout, formatter.FormatComparisonSummary(deltas))
+	}
+	return nil
+}
+
+// runValidate checks a relays file given on the command line, failing if it does not have the expected layout.
+// Warnings alone leave the exit code at zero, as the affected relays are skipped when searching.
+func runValidate(config *cli.Config, stdout io.Writer) error {
+	path := config.Args[0]
+	inspection, err := relays.Inspect(path)
+	if err != nil {
+		return err
+	}
+
+	_, _ = fmt.Fprint(stdout, formatter.FormatInspection(path, *inspection))
+	if inspection.Errors() > 0 {
+		return fmt.Errorf("%s is not a usable relays file", path)
+	}
+	return nil
+}
+
+// runService installs or removes the scheduled task running the server search in the background. The task searches
+// with the flags given to service install.
+func runService(config *cli.Config, args []string, stdout io.Writer) error {
+	if config.Args[0] == cli.ActionRemove {
+		if err := service.Remove(); err != nil {
+			return err
+		}
+		_, _ = fmt.Fprintf(stdout, "Removed scheduled task %s\n", service.TaskName)
+		return nil
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to locate the mullvad-compass executable: %w", err)
+	}
+	every := config.Every
+	if every == 0 {
+		every = service.DefaultEvery
+	}
+	flags := serviceFlags(args)
+	if _, err := cli.ParseFlags(append(slices.Clone(flags), "--every", every.String()), Version); err != nil {
+		return fmt.Errorf("the scheduled searches would fail: %w", err)
+	}
+	if err := service.Install(exe, every, config.Retain, flags); err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintf(stdout, "Installed scheduled task %s, searching every %s from system startup\n",
+		service.TaskName, every)
+	_, _ = fmt.Fprintf(stdout, "The task records its history in %s\n", service.ConfigDir)
+	return nil
+}
+
+// serviceFlags returns the flags of a service install command line that the task's searches are run with, without
+// the command and its action, and without --every and --retain, which the task is given explicitly
+func serviceFlags(args []string) []string {
+	var flags []string
+	action := false
+	for i := 1; i < len(args); i++ {
+		switch {
+		case args[i] == cli.ActionInstall && !action:
+			action = true
+		case args[i] == "--every" || args[i] == "--retain":
+			i++
+		default:
+			flags = append(flags, args[i])
+		}
+	}
+	return flags
+}
+
+// runHostList adds, removes, or lists favorite or ignored relays
+func runHostList(config *cli.Config, relaysData *relays.File, deps Dependencies) error {
+	name, noun := hostlist.Favorites, "a favorite"
+	if config.Command == cli.CommandIgnore {
+		name, noun = hostlist.Ignore, "ignored"
+	}
+
+	if deps.ConfigPath == nil {
+		return fmt.Errorf("no config directory to keep the %s list in", name)
+	}
+	path, err := deps.ConfigPath(name)
+	if err != nil {
+		return err
+	}
+	current, err := hostlist.Load(path)
+	if err != nil {
+		return err
+	}
+
+	action, hostnames := config.Args[0], config.Args[1:]
+	switch action {
+	case cli.ActionAdd:
+		for _, hostname := range hostnames {
+			if !relaysData.HasRelay(hostname) {
+				return fmt.Errorf("unknown relay: %s", hostname)
+			}
+		}
+		current = hostlist.Add(current, hostnames...)
+	case cli.ActionRemove:
+		for _, hostname := range hostnames {
+			if !slices.Contains(current, hostname) {
+				return fmt.Errorf("%s is not %s", hostname, noun)
+			}
+		}
+		current = hostlist.Remove(current, hostnames...)
+	default:
+		for _, hostname := range current {
+			_, _ = fmt.Fprintln(deps.Stdout, hostname)
+		}
+		return nil
+	}
+
+	if config.LogLevel <= logging.LogLevelDebug {
+		log.Printf("Saving %d hostnames to %s", len(current), path)
+	}
+	return hostlist.Save(path, current)
+}
+
+// loadHostList reads one of the hostname lists in the config directory
+func loadHostList(config *cli.Config, deps Dependencies, name string) ([]string, error) {
+	if deps.ConfigPath == nil {
+		return nil, nil
+	}
+	path, err := deps.ConfigPath(name)
+	if err != nil {
+		return nil, err
+	}
+	hostnames, err := hostlist.Load(path)
+	if err != nil {
+		return nil, err
+	}
+	if config.LogLevel <= logging.LogLevelDebug {
+		log.Printf("Loaded %d hostnames from %s", len(hostnames), path)
+	}
+	return hostnames, nil
+}
+
+// applyHostLists marks favorite relays and drops ignored ones, unless --include-ignored is given.
+// With --favorites-only, only favorites are kept.
+func applyHostLists(config *cli.Config, deps Dependencies, locations []relays.Location) ([]relays.Location, error) {
+	favorites, err := loadHostList(config, deps, hostlist.Favorites)
+	if err != nil {
+		return nil, err
+	}
+	hostlist.MarkFavorites(locations, favorites)
+
+	if !config.IncludeIgnored {
+		ignored, err := loadHostList(config, deps, hostlist.Ignore)
+		if err != nil {
+			return nil, err
+		}
+		kept := hostlist.Exclude(locations, ignored)
+		if config.LogLevel <= logging.LogLevelDebug && len(kept) < len(locations) {
+			log.Printf("Excluded %d ignored servers", len(locations)-len(kept))
+		}
+		locations = kept
+	}
+
+	if config.FavoritesOnly {
+		locations = hostlist.OnlyFavorites(locations)
+		if len(locations) == 0 {
+			return nil, fmt.Errorf("no favorite relays found (add some with 'mullvad-compass favorite add HOSTNAME')")
+		}
+	}
+	return locations, nil
+}
+
+// suggestIgnore records which pinged relays timed out and suggests ignoring those that timed out in each of the
+// last few runs
+func suggestIgnore(deps Dependencies, pinged []relays.Location) error {
+	if deps.ConfigPath == nil {
+		return nil
+	}
+
+	var timedOut []string
+	for _, loc := range pinged {
+		if loc.Latency == nil {
+			timedOut = append(timedOut, loc.Hostname)
+		}
+	}
+
+	path, err := deps.ConfigPath(hostlist.Timeouts)
+	if err != nil {
+		return err
+	}
+	runs, err := hostlist.RecordTimeouts(path, timedOut, ignoreSuggestionRuns)
+	if err != nil {
+		return err
+	}
+
+	if persistent := hostlist.Persistent(runs, ignoreSuggestionRuns); len(persistent) > 0 {
+		_, _ = fmt.Fprint(deps.Stdout, formatIgnoreSuggestion(persistent))
+	}
+	return nil
+}
+
+// recordTimeouts runs suggestIgnore unless every ping timed out, which points to the network rather than the
+// relays. The history is best effort: failing to record it only logs a warning.
+func recordTimeouts(config *cli.Config, deps Dependencies, pinged []relays.Location, fellBack bool) {
+	if fellBack {
+		return
+	}
+	if err := suggestIgnore(deps, pinged); err != nil && config.LogLevel <= logging.LogLevelWarning {
+		log.Printf("Failed to record timed out servers: %v", err)
+	}
+}
+
+// formatIgnoreSuggestion suggests adding relays that keep timing out to the ignore list
+func formatIgnoreSuggestion(hostnames []string) string {
+	return fmt.Sprintf(
+		"\n%s timed out in each of the last %d runs, ignore with:\n    mullvad-compass ignore add %s\n",
+		strings.Join(hostnames, ", "),
+		ignoreSuggestionRuns,
+		strings.Join(hostnames, " "),
+	)
+}
+
+// newHookRunner creates a hook runner for the hooks configured on the command line
+func newHookRunner(config *cli.Config, deps Dependencies) (*hooks.Runner, error) {
+	opts := []hooks.Option{
+		hooks.WithCommand(hooks.PreRun, config.PreRunHook),
+		hooks.WithCommand(hooks.PostRun, config.PostRunHook),
+		hooks.WithCommand(hooks.BestChange, config.BestChangeHook),
+		hooks.WithSwitchThreshold(config.SwitchThreshold),
+		hooks.WithLogLevel(config.LogLevel),
+	}
+
+	if config.BestChangeHook != "" {
+		statePath, err := deps.HookStatePath()
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, hooks.WithStatePath(statePath))
+	}
+
+	return hooks.NewRunner(opts...), nil
+}
+
+// runPostHooks runs the post_run and on_best_change hooks with the ranked results, and prints why the best server
+// was or was not switched with --switch-threshold
+func runPostHooks(
+	ctx context.Context,
+	runner *hooks.Runner,
+	config *cli.Config,
+	ranked []relays.Location,
+	stdout io.Writer,
+) error {
+	useIPv6 := config.IPVersion.IsIPv6()
+
+	servers := make([]hooks.Server, len(ranked))
+	for i, loc := range ranked {
+		servers[i] = hooks.NewServer(loc, useIPv6)
+	}
+
+	// Only a server that responded to ping counts as the best one
+	var best *hooks.Server
+	if len(ranked) > 0 && ranked[0].Latency != nil {
+		best = &servers[0]
+	}
+
+	if err := runner.Run(ctx, hooks.Payload{Event: hooks.PostRun, Best: best, Servers: servers}); err != nil {
+		return err
+	}
+
+	if best == nil {
+		return nil
+	}
+	reason, err := runner.RunBestChange(ctx, *best, servers)
+	if reason != "" {
+		_, _ = fmt.Fprintf(stdout, "\nSwitch threshold: %s\n", reason)
+	}
+	return err
+}
+
+// applyAppSettingsDefaults uses the app's obfuscation as the anti-censorship filter unless -a was given.
+// An explicit -c replaces the app's location constraints.
+func applyAppSettingsDefaults(config *cli.Config, settings *appsettings.Settings) {
+	if config.AntiCensorship == relays.ACNone && config.ServerType == relays.WireGuardServer {
+		config.AntiCensorship = settings.AntiCensorship
+	}
+	if len(config.Countries) > 0 {
+		settings.Relay.Locations = nil
+		settings.Bridge.Locations = nil
+	}
+}
+
+// filterByAppSettings keeps the locations the app's relay or bridge constraints allow connecting to
+func filterByAppSettings(
+	config *cli.Config,
+	settings *appsettings.Settings,
+	locations []relays.Location,
+) []relays.Location {
+	constraints := settings.Relay
+	if config.ServerType == relays.BridgeServer {
+		constraints = settings.Bridge
+	}
+
+	filtered := constraints.Filter(locations)
+	if config.LogLevel <= logging.LogLevelDebug {
+		log.Printf("Mullvad app settings allow %d of %d servers", len(filtered), len(locations))
+	}
+	return filtered
+}
+
+// filterByPreset applies the filters of --preset that are not applied while reading the relays file
+func filterByPreset(config *cli.Config, locations []relays.Location) []relays.Location {
+	count := len(locations)
+	if config.OwnedOnly {
+		locations = relays.FilterOwned(locations)
+	}
+	if config.StbootOnly {
+		locations = relays.FilterStboot(locations)
+	}
+	if config.AnyFeature != 0 {
+		locations = relays.FilterAnyFeature(locations, config.AnyFeature)
+	}
+	if config.TopWeight {
+		locations = relays.TopWeightPerCity(locations)
+	}
+	if config.LogLevel <= logging.LogLevelDebug {
+		log.Printf("Preset %s allows %d of %d servers", config.Preset, len(locations), count)
+	}
+	return locations
+}
+
+// formatResultsTable renders ranked locations as a table, or one line per server with --plain,
+// collapsed to each city's best server with --per-city, or grouped by country and city with --layout grouped.
+// Tables show latencies relative to the reference, if any.
+func formatResultsTable(config *cli.Config, locations []relays.Location, ref *formatter.Reference) string {
+	opts := displayOptions(config)
+	if config.Pretty {
+		locations = formatter.Prettify(locations)
+	}
+	switch {
+	case config.PerCity && config.Layout == cli.LayoutGrouped:
+		return formatter.FormatGroupedCityTable(relays.BestPerCity(locations), opts)
+	case config.Layout == cli.LayoutGrouped:
+		return formatter.FormatGroupedTable(locations, opts)
+	case config.PerCity && config.Plain:
+		return formatter.FormatPlainCityList(relays.BestPerCity(locations), opts)
+	case config.PerCity && ref != nil:
+		return formatter.FormatCalibratedCityTable(relays.BestPerCity(locations), opts, *ref)
+	case config.PerCity:
+		return formatter.FormatCityTable(relays.BestPerCity(locations), opts)
+	case config.Plain:
+		return formatter.FormatPlainList(locations, opts)
+	case ref != nil:
+		return formatter.FormatCalibratedTable(locations, opts, *ref)
+	default:
+		return formatter.FormatTable(locations, opts)
+	}
+}
+
+// displayOptions returns the addresses and latency format servers are displayed with
+func displayOptions(config *cli.Config) formatter.Options {
+	opts := formatter.Options{
+		Latency: formatter.LatencyFormat{
+			Microseconds: config.Microseconds,
+			Decimals:     config.Precision,
+		},
+		Features:  config.ShowFeatures,
+		IPv6:      config.DualStack,
+		MaxWidths: config.MaxWidths,
+	}
+	switch config.ShowIPs {
+	case "", cli.ShowIPsBoth:
+		opts.IPs = formatter.IPColumnsBoth
+	case cli.ShowIPsV4:
+		opts.IPs = formatter.IPColumnsIPv4
+	case cli.ShowIPsV6:
+		opts.IPs = formatter.IPColumnsIPv6
+	}
+	return opts
+}
+
+// formatStabilityTable renders the stability of the best servers, one line each with --plain
+func formatStabilityTable(config *cli.Config, stats []stability.Stats) string {
+	if config.Plain {
+		return formatter.FormatPlainStabilityList(stats)
+	}
+	if config.Pretty {
+		stats = slices.Clone(stats)
+		for i := range stats {
+			stats[i].Location.Country = formatter.PrettyCountry(stats[i].Location)
+		}
+	}
+	return formatter.FormatStabilityTable(stats, config.IPVersion.IsIPv6())
+}
+
+// formatBestServer renders the user location and best server, as one line each with --plain
+func formatBestServer(config *cli.Config, userLoc api.UserLocation, best relays.Location) string {
+	if config.Plain {
+		return formatter.FormatPlainBestServer(userLoc, best, displayOptions(config))
+	}
+	if config.Pretty {
+		best.Country = formatter.PrettyCountry(best)
+	}
+	return formatter.FormatBestServer(userLoc, best, displayOptions(config))
+}
+
+// formatCityAlternative renders the best responding server in a city with at least --min-city-relays relays, if the
+// best server's city has fewer, and nothing otherwise
+func formatCityAlternative(config *cli.Config, counts relays.CityCounts, ranked []relays.Location) string {
+	if counts.Of(ranked[0]) >= config.MinCityRelays {
+		return ""
+	}
+
+	var alternative *relays.Location
+	for i := range ranked {
+		if ranked[i].Latency != nil && counts.Of(ranked[i]) >= config.MinCityRelays {
+			alternative = &ranked[i]
+			break
+		}
+	}
+	relayCount := 0
+	if alternative != nil {
+		relayCount = counts.Of(*alternative)
+	}
+
+	opts := displayOptions(config)
+	if config.Plain {
+		return formatter.FormatPlainCityAlternative(alternative, relayCount, config.MinCityRelays, opts)
+	}
+	if alternative != nil && config.Pretty {
+		pretty := *alternative
+		pretty.Country = formatter.PrettyCountry(pretty)
+		alternative = &pretty
+	}
+	return formatter.FormatCityAlternative(alternative, relayCount, config.MinCityRelays, opts)
+}
+
+// writeDeterministicOutput renders fixed sample data, independent of geolocation, distance, and latency
+func writeDeterministicOutput(config *cli.Config, stdout io.Writer) {
+	locations := getDeterministicLocations()
+
+	if config.BestServerMode {
+		if len(locations) > 0 {
+			userLoc := getDeterministicUserLocation()
+			_, _ = fmt.Fprint(stdout, formatBestServer(config, userLoc, locations[0]))
+		}
+		return
+	}
+
+	_, _ = fmt.Fprint(stdout, formatResultsTable(config, locations, nil))
+
+	if !config.NoSummary {
+		_, _ = fmt.Fprint(stdout, "\n"+formatter.FormatSummary(formatter.Summarize(locations)))
+	}
 }
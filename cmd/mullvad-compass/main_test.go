@@ -3,14 +3,36 @@ package main
 import (
 	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
+	"math"
 	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/Ch00k/mullvad-compass/internal/api"
+	"github.com/Ch00k/mullvad-compass/internal/appsettings"
+	"github.com/Ch00k/mullvad-compass/internal/cli"
+	"github.com/Ch00k/mullvad-compass/internal/clock"
+	"github.com/Ch00k/mullvad-compass/internal/elevate"
+	"github.com/Ch00k/mullvad-compass/internal/errs"
+	"github.com/Ch00k/mullvad-compass/internal/formatter"
+	"github.com/Ch00k/mullvad-compass/internal/hostlist"
 	"github.com/Ch00k/mullvad-compass/internal/logging"
+	"github.com/Ch00k/mullvad-compass/internal/netcheck"
+	"github.com/Ch00k/mullvad-compass/internal/netguard"
+	"github.com/Ch00k/mullvad-compass/internal/ping"
 	"github.com/Ch00k/mullvad-compass/internal/relays"
+	"github.com/Ch00k/mullvad-compass/internal/service"
+	"github.com/Ch00k/mullvad-compass/internal/tunnel"
+	"github.com/Ch00k/mullvad-compass/internal/warnings"
 )
 
 func TestE2E_FullFlow(t *testing.T) {
@@ -34,7 +56,7 @@ func TestE2E_FullFlow(t *testing.T) {
 				}
 				return locs, nil
 			},
-			ParseRelaysFile: func(_ logging.LogLevel, _ string, _ func() (string, error)) (*relays.File, error) {
+			ParseRelaysFile: func(_ context.Context, _ logging.LogLevel, _ string, _ func() (string, error)) (*relays.File, error) {
 				return relays.ParseRelaysFile("../../testdata/relays.json")
 			},
 			Stdout: &output,
@@ -78,7 +100,7 @@ func TestE2E_FullFlow(t *testing.T) {
 			PingLocations: func(_ context.Context, locs []relays.Location, _, _ int, _ relays.IPVersion, _ logging.LogLevel) ([]relays.Location, error) {
 				return locs, nil
 			},
-			ParseRelaysFile: func(_ logging.LogLevel, _ string, _ func() (string, error)) (*relays.File, error) {
+			ParseRelaysFile: func(_ context.Context, _ logging.LogLevel, _ string, _ func() (string, error)) (*relays.File, error) {
 				return relays.ParseRelaysFile("../../testdata/relays.json")
 			},
 			Stdout: &output,
@@ -94,6 +116,9 @@ func TestE2E_FullFlow(t *testing.T) {
 		if !strings.Contains(result, "No servers found within") {
 			t.Error("Should indicate no servers found within distance")
 		}
+		if !strings.Contains(result, "(nearest server is ") {
+			t.Errorf("Should report the distance to the nearest server, got: %q", result)
+		}
 	})
 
 	t.Run("Locations sorted by latency", func(t *testing.T) {
@@ -114,7 +139,7 @@ func TestE2E_FullFlow(t *testing.T) {
 				}
 				return locs, nil
 			},
-			ParseRelaysFile: func(_ logging.LogLevel, _ string, _ func() (string, error)) (*relays.File, error) {
+			ParseRelaysFile: func(_ context.Context, _ logging.LogLevel, _ string, _ func() (string, error)) (*relays.File, error) {
 				return relays.ParseRelaysFile("../../testdata/relays.json")
 			},
 			Stdout: &output,
@@ -155,7 +180,7 @@ func TestE2E_FullFlow(t *testing.T) {
 				}
 				return locs, nil
 			},
-			ParseRelaysFile: func(_ logging.LogLevel, _ string, _ func() (string, error)) (*relays.File, error) {
+			ParseRelaysFile: func(_ context.Context, _ logging.LogLevel, _ string, _ func() (string, error)) (*relays.File, error) {
 				return relays.ParseRelaysFile("../../testdata/relays.json")
 			},
 			Stdout: &output,
@@ -185,7 +210,7 @@ func TestE2E_ErrorHandling(t *testing.T) {
 			PingLocations: func(_ context.Context, locs []relays.Location, _, _ int, _ relays.IPVersion, _ logging.LogLevel) ([]relays.Location, error) {
 				return locs, nil
 			},
-			ParseRelaysFile: func(_ logging.LogLevel, _ string, _ func() (string, error)) (*relays.File, error) {
+			ParseRelaysFile: func(_ context.Context, _ logging.LogLevel, _ string, _ func() (string, error)) (*relays.File, error) {
 				return relays.ParseRelaysFile("../../testdata/relays.json")
 			},
 			Stdout: &output,
@@ -212,7 +237,7 @@ func TestE2E_ErrorHandling(t *testing.T) {
 			PingLocations: func(_ context.Context, locs []relays.Location, _, _ int, _ relays.IPVersion, _ logging.LogLevel) ([]relays.Location, error) {
 				return locs, nil
 			},
-			ParseRelaysFile: func(_ logging.LogLevel, _ string, _ func() (string, error)) (*relays.File, error) {
+			ParseRelaysFile: func(_ context.Context, _ logging.LogLevel, _ string, _ func() (string, error)) (*relays.File, error) {
 				return nil, fmt.Errorf("file not found: nonexistent.json")
 			},
 			Stdout: &output,
@@ -236,7 +261,7 @@ func TestE2E_ErrorHandling(t *testing.T) {
 			PingLocations: func(_ context.Context, locs []relays.Location, _, _ int, _ relays.IPVersion, _ logging.LogLevel) ([]relays.Location, error) {
 				return locs, nil
 			},
-			ParseRelaysFile: func(_ logging.LogLevel, _ string, _ func() (string, error)) (*relays.File, error) {
+			ParseRelaysFile: func(_ context.Context, _ logging.LogLevel, _ string, _ func() (string, error)) (*relays.File, error) {
 				return nil, fmt.Errorf("unsupported platform")
 			},
 			Stdout: &output,
@@ -275,7 +300,7 @@ func TestE2E_ErrorHandling(t *testing.T) {
 				}
 				return locs, nil
 			},
-			ParseRelaysFile: func(_ logging.LogLevel, _ string, _ func() (string, error)) (*relays.File, error) {
+			ParseRelaysFile: func(_ context.Context, _ logging.LogLevel, _ string, _ func() (string, error)) (*relays.File, error) {
 				return relays.ParseRelaysFile("../../testdata/relays.json")
 			},
 			Stdout: &output,
@@ -332,7 +357,7 @@ func TestE2E_ErrorHandling(t *testing.T) {
 				}
 				return locs, nil
 			},
-			ParseRelaysFile: func(_ logging.LogLevel, _ string, _ func() (string, error)) (*relays.File, error) {
+			ParseRelaysFile: func(_ context.Context, _ logging.LogLevel, _ string, _ func() (string, error)) (*relays.File, error) {
 				return relays.ParseRelaysFile("../../testdata/relays.json")
 			},
 			Stdout: &output,
@@ -380,7 +405,7 @@ func TestE2E_FlagParsing(t *testing.T) {
 			PingLocations: func(_ context.Context, locs []relays.Location, _, _ int, _ relays.IPVersion, _ logging.LogLevel) ([]relays.Location, error) {
 				return locs, nil
 			},
-			ParseRelaysFile: func(_ logging.LogLevel, _ string, _ func() (string, error)) (*relays.File, error) {
+			ParseRelaysFile: func(_ context.Context, _ logging.LogLevel, _ string, _ func() (string, error)) (*relays.File, error) {
 				return relays.ParseRelaysFile("../../testdata/relays.json")
 			},
 			Stdout: &output,
@@ -408,7 +433,7 @@ func TestE2E_FlagParsing(t *testing.T) {
 			PingLocations: func(_ context.Context, locs []relays.Location, _, _ int, _ relays.IPVersion, _ logging.LogLevel) ([]relays.Location, error) {
 				return locs, nil
 			},
-			ParseRelaysFile: func(_ logging.LogLevel, _ string, _ func() (string, error)) (*relays.File, error) {
+			ParseRelaysFile: func(_ context.Context, _ logging.LogLevel, _ string, _ func() (string, error)) (*relays.File, error) {
 				return relays.ParseRelaysFile("../../testdata/relays.json")
 			},
 			Stdout: &output,
@@ -433,7 +458,7 @@ func TestE2E_FlagParsing(t *testing.T) {
 			PingLocations: func(_ context.Context, locs []relays.Location, _, _ int, _ relays.IPVersion, _ logging.LogLevel) ([]relays.Location, error) {
 				return locs, nil
 			},
-			ParseRelaysFile: func(_ logging.LogLevel, _ string, _ func() (string, error)) (*relays.File, error) {
+			ParseRelaysFile: func(_ context.Context, _ logging.LogLevel, _ string, _ func() (string, error)) (*relays.File, error) {
 				return relays.ParseRelaysFile("../../testdata/relays.json")
 			},
 			Stdout: &output,
@@ -461,7 +486,7 @@ func TestE2E_FlagParsing(t *testing.T) {
 			PingLocations: func(_ context.Context, locs []relays.Location, _, _ int, _ relays.IPVersion, _ logging.LogLevel) ([]relays.Location, error) {
 				return locs, nil
 			},
-			ParseRelaysFile: func(_ logging.LogLevel, _ string, _ func() (string, error)) (*relays.File, error) {
+			ParseRelaysFile: func(_ context.Context, _ logging.LogLevel, _ string, _ func() (string, error)) (*relays.File, error) {
 				return relays.ParseRelaysFile("../../testdata/relays.json")
 			},
 			Stdout: &output,
@@ -498,7 +523,7 @@ func TestE2E_BestServerMode(t *testing.T) {
 				}
 				return locs, nil
 			},
-			ParseRelaysFile: func(_ logging.LogLevel, _ string, _ func() (string, error)) (*relays.File, error) {
+			ParseRelaysFile: func(_ context.Context, _ logging.LogLevel, _ string, _ func() (string, error)) (*relays.File, error) {
 				return relays.ParseRelaysFile("../../testdata/relays.json")
 			},
 			Stdout: &output,
@@ -528,6 +553,7 @@ func TestE2E_BestServerMode(t *testing.T) {
 
 	t.Run("Best server mode finds servers in progressively expanding ranges", func(t *testing.T) {
 		var output bytes.Buffer
+		var pinged []relays.Location
 		callCount := 0
 
 		deps := Dependencies{
@@ -539,13 +565,14 @@ func TestE2E_BestServerMode(t *testing.T) {
 			},
 			PingLocations: func(_ context.Context, locs []relays.Location, _, _ int, _ relays.IPVersion, _ logging.LogLevel) ([]relays.Location, error) {
 				callCount++
+				pinged = locs
 				for i := range locs {
 					latency := float64(30 + i*2)
 					locs[i].Latency = &latency
 				}
 				return locs, nil
 			},
-			ParseRelaysFile: func(_ logging.LogLevel, _ string, _ func() (string, error)) (*relays.File, error) {
+			ParseRelaysFile: func(_ context.Context, _ logging.LogLevel, _ string, _ func() (string, error)) (*relays.File, error) {
 				return relays.ParseRelaysFile("../../testdata/relays.json")
 			},
 			Stdout: &output,
@@ -562,6 +589,25 @@ func TestE2E_BestServerMode(t *testing.T) {
 			t.Error("Expected PingLocations to be called at least once")
 		}
 
+		// Only servers within the first 500 km step reaching the nearest server are pinged
+		var nearest, farthest float64
+		for i, loc := range pinged {
+			d := *loc.DistanceFromMyLocation
+			if i == 0 || d < nearest {
+				nearest = d
+			}
+			farthest = max(farthest, d)
+		}
+		radius := math.Ceil(nearest/500) * 500
+		if len(pinged) == 0 || farthest > radius || radius <= 500 {
+			t.Errorf(
+				"Expected servers within %.0f km only, nearest %.0f km, farthest %.0f km",
+				radius,
+				nearest,
+				farthest,
+			)
+		}
+
 		// Should output one server in new 2-line format
 		result := output.String()
 		if !strings.Contains(result, "Your location:") {
@@ -636,7 +682,7 @@ func TestE2E_BestServerMode(t *testing.T) {
 				}
 				return locs, nil
 			},
-			ParseRelaysFile: func(_ logging.LogLevel, _ string, _ func() (string, error)) (*relays.File, error) {
+			ParseRelaysFile: func(_ context.Context, _ logging.LogLevel, _ string, _ func() (string, error)) (*relays.File, error) {
 				return relays.ParseRelaysFile(tempFile.Name())
 			},
 			Stdout: &output,
@@ -652,6 +698,9 @@ func TestE2E_BestServerMode(t *testing.T) {
 		if !strings.Contains(err.Error(), expectedError) {
 			t.Errorf("Expected error containing %q, got: %v", expectedError, err)
 		}
+		if !strings.Contains(err.Error(), "nearest server is 20015 km away") {
+			t.Errorf("Expected error to report the nearest server distance, got: %v", err)
+		}
 	})
 
 	t.Run("Any argument disables best server mode", func(t *testing.T) {
@@ -671,7 +720,7 @@ func TestE2E_BestServerMode(t *testing.T) {
 				}
 				return locs, nil
 			},
-			ParseRelaysFile: func(_ logging.LogLevel, _ string, _ func() (string, error)) (*relays.File, error) {
+			ParseRelaysFile: func(_ context.Context, _ logging.LogLevel, _ string, _ func() (string, error)) (*relays.File, error) {
 				return relays.ParseRelaysFile("../../testdata/relays.json")
 			},
 			Stdout: &output,
@@ -711,7 +760,7 @@ func TestE2E_Integration(t *testing.T) {
 				}
 				return locs, nil
 			},
-			ParseRelaysFile: func(_ logging.LogLevel, _ string, _ func() (string, error)) (*relays.File, error) {
+			ParseRelaysFile: func(_ context.Context, _ logging.LogLevel, _ string, _ func() (string, error)) (*relays.File, error) {
 				return relays.ParseRelaysFile("../../testdata/relays.json")
 			},
 			Stdout: &output,
@@ -759,7 +808,7 @@ func TestE2E_DeterministicOutput(t *testing.T) {
 				t.Error("PingLocations should not be called in deterministic mode")
 				return locs, nil
 			},
-			ParseRelaysFile: func(_ logging.LogLevel, _ string, _ func() (string, error)) (*relays.File, error) {
+			ParseRelaysFile: func(_ context.Context, _ logging.LogLevel, _ string, _ func() (string, error)) (*relays.File, error) {
 				return relays.ParseRelaysFile("../../testdata/relays.json")
 			},
 			Stdout: out,
@@ -781,6 +830,23 @@ func TestE2E_DeterministicOutput(t *testing.T) {
 		if !strings.Contains(result, "cz-prg-wg-201") {
 			t.Errorf("Expected deterministic table rows, got:\n%s", result)
 		}
+		if !strings.Contains(result, "11 servers, 100% reachable") {
+			t.Errorf("Expected summary line after the table, got:\n%s", result)
+		}
+	})
+
+	t.Run("Summary line can be suppressed", func(t *testing.T) {
+		var output bytes.Buffer
+
+		args := []string{"--deterministic-output", "--max-distance", "250", "--no-summary"}
+		if err := run(context.Background(), args, makeDeps(&output)); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+
+		result := output.String()
+		if strings.Contains(result, "reachable") {
+			t.Errorf("Expected no summary line with --no-summary, got:\n%s", result)
+		}
 	})
 
 	t.Run("Best server mode renders fixed sample without live lookups", func(t *testing.T) {
@@ -796,4 +862,3578 @@ func TestE2E_DeterministicOutput(t *testing.T) {
 			t.Errorf("Expected deterministic best-server output, got:\n%s", result)
 		}
 	})
+
+	t.Run("Plain output has one labeled line per server", func(t *testing.T) {
+		var output bytes.Buffer
+
+		args := []string{"--deterministic-output", "--max-distance", "250", "--plain", "--no-summary"}
+		if err := run(context.Background(), args, makeDeps(&output)); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+
+		lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+		if len(lines) != 11 {
+			t.Fatalf("Expected 11 lines, got %d:\n%s", len(lines), output.String())
+		}
+		want := "cz-prg-wg-201: 9.78 ms, 156 km, Prague, Czech Republic, 178.249.209.162, 2a02:6ea0:c201:1::f001"
+		if lines[0] != want {
+			t.Errorf("Unexpected first line: %q", lines[0])
+		}
+		for _, line := range lines {
+			if strings.Contains(line, "  ") {
+				t.Errorf("Expected no column padding, got %q", line)
+			}
+		}
+	})
+
+	t.Run("Plain best server", func(t *testing.T) {
+		var output bytes.Buffer
+
+		args := []string{"--deterministic-output", "--plain"}
+		if err := run(context.Background(), args, makeDeps(&output)); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+
+		want := "Your location: Dresden, Germany, 203.0.113.42\n" +
+			"Best server: cz-prg-wg-201: 9.78 ms, 156 km, Prague, Czech Republic, 178.249.209.162, " +
+			"2a02:6ea0:c201:1::f001\n"
+		if output.String() != want {
+			t.Errorf("Expected %q, got %q", want, output.String())
+		}
+	})
+}
+
+func TestE2E_Sampling(t *testing.T) {
+	// Frankfurt has 19 active WireGuard relays in the fixture and nothing else lies within 50 km
+	makeDeps := func(out *bytes.Buffer, pingCalls *[]int) Dependencies {
+		return Dependencies{
+			GetUserLocation: func(context.Context, logging.LogLevel) (*api.UserLocation, error) {
+				return &api.UserLocation{Latitude: 50.110924, Longitude: 8.682127}, nil
+			},
+			PingLocations: func(_ context.Context, locs []relays.Location, _, _ int, _ relays.IPVersion, _ logging.LogLevel) ([]relays.Location, error) {
+				*pingCalls = append(*pingCalls, len(locs))
+				for i := range locs {
+					latency := 10.0 + float64(i)
+					locs[i].Latency = &latency
+				}
+				return locs, nil
+			},
+			ParseRelaysFile: func(_ context.Context, _ logging.LogLevel, _ string, _ func() (string, error)) (*relays.File, error) {
+				return relays.ParseRelaysFile("../../testdata/relays.json")
+			},
+			ConfigPath: tempConfigPath(t),
+			Stdout:     out,
+		}
+	}
+
+	t.Run("Pings only sampled servers and reports the seed", func(t *testing.T) {
+		var output bytes.Buffer
+		var pingCalls []int
+
+		args := []string{"-m", "50", "--sample", "2", "--seed", "7"}
+		if err := run(context.Background(), args, makeDeps(&output, &pingCalls)); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+
+		if len(pingCalls) != 1 || pingCalls[0] != 2 {
+			t.Errorf("Expected a single ping call with 2 servers, got %v", pingCalls)
+		}
+		if !strings.Contains(output.String(), "seed 7") {
+			t.Errorf("Expected output to report the seed, got:\n%s", output.String())
+		}
+	})
+
+	t.Run("Same seed pings the same servers", func(t *testing.T) {
+		var first, second bytes.Buffer
+		var pingCalls []int
+
+		args := []string{"-m", "50", "--sample", "3", "--seed", "99"}
+		if err := run(context.Background(), args, makeDeps(&first, &pingCalls)); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if err := run(context.Background(), args, makeDeps(&second, &pingCalls)); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+
+		if first.String() != second.String() {
+			t.Errorf("Expected identical output for identical seeds:\n%s\n---\n%s", first.String(), second.String())
+		}
+	})
+
+	t.Run("Full city probing pings the rest of the best city", func(t *testing.T) {
+		var output bytes.Buffer
+		var pingCalls []int
+
+		args := []string{"-m", "50", "--sample", "2", "--seed", "7", "--sample-full-city"}
+		if err := run(context.Background(), args, makeDeps(&output, &pingCalls)); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+
+		if len(pingCalls) != 2 || pingCalls[0] != 2 || pingCalls[1] != 17 {
+			t.Errorf("Expected ping calls of 2 then 17 servers, got %v", pingCalls)
+		}
+		if !strings.Contains(output.String(), "19 servers") {
+			t.Errorf("Expected all 19 Frankfurt servers in the summary, got:\n%s", output.String())
+		}
+	})
+}
+
+func TestWarnTimedOutPrefixes(t *testing.T) {
+	locations := []relays.Location{
+		{Hostname: "de-ber-wg-001", IPv4Address: "193.32.248.66"},
+		{Hostname: "de-ber-wg-002", IPv4Address: "193.32.248.67"},
+	}
+
+	warns := warnings.New()
+	prefixes := warnTimedOutPrefixes(warnings.WithCollector(context.Background(), warns), locations, relays.IPv4)
+
+	if len(prefixes) != 1 || prefixes[0].Prefix != "193.32.248.0/24" {
+		t.Errorf("Expected the 193.32.248.0/24 prefix, got %+v", prefixes)
+	}
+	got := warns.Warnings()
+	if len(got) != 1 || got[0].Kind != warnings.NetworkBlock {
+		t.Fatalf("Expected a network block warning, got %+v", got)
+	}
+	if !strings.Contains(got[0].Message, "all 2 servers in 193.32.248.0/24 timed out") {
+		t.Errorf("Expected the prefix in the warning, got: %s", got[0].Message)
+	}
+}
+
+func TestE2E_DistanceFallback(t *testing.T) {
+	makeDeps := func(out *bytes.Buffer) Dependencies {
+		return Dependencies{
+			GetUserLocation: func(context.Context, logging.LogLevel) (*api.UserLocation, error) {
+				return &api.UserLocation{
+					Latitude:  41.327953, // Tirana, Albania
+					Longitude: 19.819025,
+					Country:   "Albania",
+					City:      "Tirana",
+				}, nil
+			},
+			PingLocations: func(_ context.Context, locs []relays.Location, _, _ int, _ relays.IPVersion, _ logging.LogLevel) ([]relays.Location, error) {
+				// Every ping times out
+				return locs, nil
+			},
+			ParseRelaysFile: func(_ context.Context, _ logging.LogLevel, _ string, _ func() (string, error)) (*relays.File, error) {
+				return relays.ParseRelaysFile("../../testdata/relays.json")
+			},
+			ConfigPath: tempConfigPath(t),
+			Stdout:     out,
+		}
+	}
+
+	t.Run("Table mode ranks by distance with a notice", func(t *testing.T) {
+		var output bytes.Buffer
+
+		err := run(context.Background(), []string{"-m", "1000"}, makeDeps(&output))
+		if !errors.Is(err, errDistanceFallback) {
+			t.Fatalf("Expected errDistanceFallback, got: %v", err)
+		}
+
+		result := output.String()
+		if !strings.HasPrefix(result, "NOTICE: No servers responded to ping") {
+			t.Errorf("Expected output to start with the fallback notice, got:\n%s", result)
+		}
+
+		// The first data row is the closest server, which is in Tirana itself
+		lines := strings.Split(result, "\n")
+		if len(lines) < 5 || !strings.HasPrefix(lines[4], "Albania") {
+			t.Errorf("Expected closest server (Albania) first, got:\n%s", result)
+		}
+	})
+
+	t.Run("Best server mode ranks by distance with a notice", func(t *testing.T) {
+		var output bytes.Buffer
+
+		err := run(context.Background(), []string{}, makeDeps(&output))
+		if !errors.Is(err, errDistanceFallback) {
+			t.Fatalf("Expected errDistanceFallback, got: %v", err)
+		}
+
+		result := output.String()
+		if !strings.Contains(result, "NOTICE: No servers responded to ping") {
+			t.Errorf("Expected fallback notice, got:\n%s", result)
+		}
+		if !strings.Contains(result, "Best server:     Tirana, Albania") {
+			t.Errorf("Expected closest server as best server, got:\n%s", result)
+		}
+	})
+
+	t.Run("Fallback can be disabled", func(t *testing.T) {
+		var output bytes.Buffer
+
+		err := run(context.Background(), []string{"-m", "1000", "--no-fallback-distance"}, makeDeps(&output))
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+
+		result := output.String()
+		if strings.Contains(result, "NOTICE") {
+			t.Errorf("Expected no fallback notice, got:\n%s", result)
+		}
+		if !strings.Contains(result, "timeout") {
+			t.Errorf("Expected timeouts in table, got:\n%s", result)
+		}
+	})
+}
+
+func TestE2E_Hooks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hook tests use POSIX shell commands")
+	}
+
+	makeDeps := func(out *bytes.Buffer, statePath string) Dependencies {
+		return Dependencies{
+			GetUserLocation: func(context.Context, logging.LogLevel) (*api.UserLocation, error) {
+				return &api.UserLocation{Latitude: 41.327953, Longitude: 19.819025}, nil
+			},
+			PingLocations: func(_ context.Context, locs []relays.Location, _, _ int, _ relays.IPVersion, _ logging.LogLevel) ([]relays.Location, error) {
+				for i := range locs {
+					latency := 10.0 + float64(i)
+					locs[i].Latency = &latency
+				}
+				return locs, nil
+			},
+			ParseRelaysFile: func(_ context.Context, _ logging.LogLevel, _ string, _ func() (string, error)) (*relays.File, error) {
+				return relays.ParseRelaysFile("../../testdata/relays.json")
+			},
+			HookStatePath: func() (string, error) {
+				return statePath, nil
+			},
+			ConfigPath: tempConfigPath(t),
+			Stdout:     out,
+		}
+	}
+
+	t.Run("Runs hooks in order with results", func(t *testing.T) {
+		dir := t.TempDir()
+		logFile := filepath.Join(dir, "hooks.log")
+		var output bytes.Buffer
+
+		args := []string{
+			"--pre-run", "echo pre >> " + logFile,
+			"--post-run", `echo "post $MULLVAD_COMPASS_BEST_HOSTNAME" >> ` + logFile,
+			"--on-best-change", `echo "change $MULLVAD_COMPASS_BEST_HOSTNAME" >> ` + logFile,
+		}
+		deps := makeDeps(&output, filepath.Join(dir, "state"))
+		if err := run(context.Background(), args, deps); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if err := run(context.Background(), args, deps); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+
+		data, err := os.ReadFile(logFile)
+		if err != nil {
+			t.Fatalf("Expected hook log file: %v", err)
+		}
+		expected := "pre\npost al-tia-wg-003\nchange al-tia-wg-003\npre\npost al-tia-wg-003\n"
+		if string(data) != expected {
+			t.Errorf("Expected hook log:\n%s\ngot:\n%s", expected, data)
+		}
+	})
+
+	t.Run("Switch threshold prints the decision", func(t *testing.T) {
+		dir := t.TempDir()
+		statePath := filepath.Join(dir, "state")
+		if err := os.WriteFile(statePath, []byte("de-ber-wg-001\n"), 0o644); err != nil {
+			t.Fatalf("Failed to write state: %v", err)
+		}
+		var output bytes.Buffer
+
+		args := []string{"--on-best-change", "true", "--switch-threshold", "5"}
+		if err := run(context.Background(), args, makeDeps(&output, statePath)); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		want := "Switch threshold: switching to al-tia-wg-003, the previous best server was not pinged\n"
+		if !strings.Contains(output.String(), want) {
+			t.Errorf("Expected %q in the output, got:\n%s", want, output.String())
+		}
+	})
+
+	t.Run("Failing pre-run hook aborts the run", func(t *testing.T) {
+		var output bytes.Buffer
+
+		err := run(context.Background(), []string{"--pre-run", "exit 1"}, makeDeps(&output, ""))
+		if err == nil || !strings.Contains(err.Error(), "pre_run hook failed") {
+			t.Errorf("Expected pre_run hook error, got: %v", err)
+		}
+		if output.Len() != 0 {
+			t.Errorf("Expected no output, got:\n%s", output.String())
+		}
+	})
+}
+
+func TestE2E_TunnelCommand(t *testing.T) {
+	makeDeps := func(out *bytes.Buffer, connected bool, measured *[]string) Dependencies {
+		return Dependencies{
+			GetUserLocation: func(context.Context, logging.LogLevel) (*api.UserLocation, error) {
+				return &api.UserLocation{
+					City:                  "Berlin",
+					Country:               "Germany",
+					MullvadExitIP:         connected,
+					MullvadExitIPHostname: "de-ber-wg-001",
+				}, nil
+			},
+			MeasureTunnel: func(_ context.Context, dests []string, timeout time.Duration, _ logging.LogLevel) []tunnel.Result {
+				*measured = dests
+				results := make([]tunnel.Result, len(dests))
+				for i, dest := range dests {
+					relay, total := 10.0, 25.0
+					results[i] = tunnel.Result{Destination: dest, Relay: &relay, Total: &total}
+				}
+				return results
+			},
+			ParseRelaysFile: func(_ context.Context, _ logging.LogLevel, _ string, _ func() (string, error)) (*relays.File, error) {
+				t.Error("ParseRelaysFile should not be called by the tunnel command")
+				return nil, fmt.Errorf("unexpected relays file parse")
+			},
+			ConfigPath: tempConfigPath(t),
+			Stdout:     out,
+		}
+	}
+
+	t.Run("Measures default destinations", func(t *testing.T) {
+		var output bytes.Buffer
+		var measured []string
+		if err := run(context.Background(), []string{"tunnel"}, makeDeps(&output, true, &measured)); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if !slices.Equal(measured, tunnel.DefaultDestinations) {
+			t.Errorf("Expected the default destinations, got %v", measured)
+		}
+		for _, want := range []string{"Connected via:   de-ber-wg-001 (Berlin, Germany)", "1.1.1.1:443   10.00"} {
+			if !strings.Contains(output.String(), want) {
+				t.Errorf("Expected %q in the output, got:\n%s", want, output.String())
+			}
+		}
+	})
+
+	t.Run("Requires a Mullvad connection", func(t *testing.T) {
+		var output bytes.Buffer
+		var measured []string
+		err := run(context.Background(), []string{"tunnel", "example.com:443"}, makeDeps(&output, false, &measured))
+		if err == nil || !strings.Contains(err.Error(), "not connected to Mullvad VPN") {
+			t.Errorf("Expected a connection error, got %v", err)
+		}
+		if measured != nil {
+			t.Errorf("Expected no measurement without a connection, got %v", measured)
+		}
+	})
+}
+
+func TestE2E_CheckCommand(t *testing.T) {
+	t.Run("Prints connection check without reading relays", func(t *testing.T) {
+		var output bytes.Buffer
+
+		deps := Dependencies{
+			CheckConnection: func(context.Context, logging.LogLevel) (*api.ConnectionCheck, error) {
+				return &api.ConnectionCheck{
+					Location: api.UserLocation{
+						IP:                    "193.32.248.66",
+						Country:               "Germany",
+						City:                  "Berlin",
+						MullvadExitIP:         true,
+						MullvadExitIPHostname: "de-ber-wg-001",
+					},
+					DNSServers: []api.DNSServer{{IP: "10.64.0.1", MullvadDNS: true}},
+				}, nil
+			},
+			ParseRelaysFile: func(_ context.Context, _ logging.LogLevel, _ string, _ func() (string, error)) (*relays.File, error) {
+				t.Error("ParseRelaysFile should not be called by the check command")
+				return nil, fmt.Errorf("unexpected relays file parse")
+			},
+			ConfigPath: tempConfigPath(t),
+			Stdout:     &output,
+		}
+
+		if err := run(context.Background(), []string{"check"}, deps); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+
+		result := output.String()
+		if !strings.Contains(result, "Exit IP:         193.32.248.66") {
+			t.Errorf("Expected exit IP, got:\n%s", result)
+		}
+		if !strings.Contains(result, "connected via de-ber-wg-001") {
+			t.Errorf("Expected Mullvad exit hostname, got:\n%s", result)
+		}
+		if !strings.Contains(result, "DNS leak:        no") {
+			t.Errorf("Expected DNS leak status, got:\n%s", result)
+		}
+	})
+
+	t.Run("Propagates API errors", func(t *testing.T) {
+		var output bytes.Buffer
+
+		deps := Dependencies{
+			CheckConnection: func(context.Context, logging.LogLevel) (*api.ConnectionCheck, error) {
+				return nil, fmt.Errorf("API connection failed")
+			},
+			ConfigPath: tempConfigPath(t),
+			Stdout:     &output,
+		}
+
+		err := run(context.Background(), []string{"check"}, deps)
+		if err == nil || !strings.Contains(err.Error(), "failed to check connection") {
+			t.Errorf("Expected check error, got: %v", err)
+		}
+	})
+}
+
+func TestE2E_PortsCommand(t *testing.T) {
+	var output bytes.Buffer
+
+	deps := Dependencies{
+		GetUserLocation: func(context.Context, logging.LogLevel) (*api.UserLocation, error) {
+			t.Error("GetUserLocation should not be called by the ports command")
+			return nil, fmt.Errorf("unexpected geolocation lookup")
+		},
+		ParseRelaysFile: func(_ context.Context, _ logging.LogLevel, _ string, _ func() (string, error)) (*relays.File, error) {
+			return relays.ParseRelaysFile("../../testdata/relays.json")
+		},
+		ConfigPath: tempConfigPath(t),
+		Stdout:     &output,
+	}
+
+	if err := run(context.Background(), []string{"ports", "-a", "shadowsocks"}, deps); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	result := output.String()
+	if !strings.HasPrefix(result, "WireGuard ports:     53, 123, 4000-33433, 33565-51820, 52001-60000\n") {
+		t.Errorf("Expected WireGuard port ranges, got:\n%s", result)
+	}
+	if !strings.Contains(result, "Shadowsocks ports:   51900-51949\n") {
+		t.Errorf("Expected Shadowsocks port ranges, got:\n%s", result)
+	}
+	if !strings.Contains(result, "al-tia-wg-003") || !strings.Contains(result, "103.204.123.136") {
+		t.Errorf("Expected relay with Shadowsocks extra address, got:\n%s", result[:200])
+	}
+}
+
+func TestE2E_CapabilitiesCommand(t *testing.T) {
+	makeDeps := func(out *bytes.Buffer) Dependencies {
+		return Dependencies{
+			GetUserLocation: func(context.Context, logging.LogLevel) (*api.UserLocation, error) {
+				t.Error("GetUserLocation should not be called by the capabilities command")
+				return nil, fmt.Errorf("unexpected geolocation lookup")
+			},
+			ParseRelaysFile: func(_ context.Context, _ logging.LogLevel, _ string, _ func() (string, error)) (*relays.File, error) {
+				return relays.ParseRelaysFile("../../testdata/relays.json")
+			},
+			ConfigPath: tempConfigPath(t),
+			Stdout:     out,
+		}
+	}
+
+	t.Run("Matrix per city", func(t *testing.T) {
+		var out bytes.Buffer
+		if err := run(context.Background(), []string{"capabilities", "-c", "se"}, makeDeps(&out)); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+
+		lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+		if len(lines) != 5 {
+			t.Fatalf("Expected header, separator and 3 Swedish cities, got:\n%s", out.String())
+		}
+		if !strings.HasPrefix(lines[4], "Sweden    Stockholm    18 ") ||
+			!strings.HasSuffix(strings.TrimSpace(lines[4]), "DAITA+QUIC+Shadowsocks+IPv6, LWO+QUIC+Shadowsocks+IPv6") {
+			t.Errorf("Unexpected Stockholm row: %q", lines[4])
+		}
+	})
+
+	t.Run("Filters narrow the relays", func(t *testing.T) {
+		var out bytes.Buffer
+		args := []string{"capabilities", "-c", "se", "-d", "-a", "quic", "--plain"}
+		if err := run(context.Background(), args, makeDeps(&out)); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		// Gothenburg has no DAITA relay, and only the relays offering both are counted
+		lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+		if len(lines) != 2 || !strings.HasPrefix(lines[0], "Malmö, Sweden: ") ||
+			!strings.HasSuffix(lines[1], ", DAITA+QUIC+Shadowsocks+IPv6") {
+			t.Errorf("Expected Malmö and Stockholm with DAITA and QUIC, got:\n%s", out.String())
+		}
+	})
+}
+
+func TestE2E_PlanCommand(t *testing.T) {
+	makeDeps := func(out *bytes.Buffer) Dependencies {
+		return Dependencies{
+			GetUserLocation: func(context.Context, logging.LogLevel) (*api.UserLocation, error) {
+				t.Error("GetUserLocation should not be called by the plan command")
+				return nil, fmt.Errorf("unexpected geolocation lookup")
+			},
+			PingLocations: func(context.Context, []relays.Location, int, int, relays.IPVersion, logging.LogLevel) ([]relays.Location, error) {
+				t.Error("PingLocations should not be called by the plan command")
+				return nil, fmt.Errorf("unexpected ping")
+			},
+			ParseRelaysFile: func(_ context.Context, _ logging.LogLevel, _ string, _ func() (string, error)) (*relays.File, error) {
+				return relays.ParseRelaysFile("../../testdata/relays.json")
+			},
+			ConfigPath: tempConfigPath(t),
+			Stdout:     out,
+		}
+	}
+
+	t.Run("Nearest relays per city", func(t *testing.T) {
+		var out bytes.Buffer
+		if err := run(context.Background(), []string{"plan", "--cities", "Lisbon,NYC"}, makeDeps(&out)); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+
+		lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+		if len(lines) != 2+2*planCandidates {
+			t.Fatalf("Expected header, separator and %d relays per city, got:\n%s", planCandidates, out.String())
+		}
+		if !strings.HasPrefix(lines[2], "Lisbon         Portugal   Lisbon         pt-lis-wg-") {
+			t.Errorf("Expected a Lisbon relay first, got %q", lines[2])
+		}
+		if !strings.HasPrefix(lines[2+planCandidates], "New York, NY   USA        New York, NY   us-nyc-wg-") {
+			t.Errorf("Expected a New York relay for NYC, got %q", lines[2+planCandidates])
+		}
+	})
+
+	t.Run("Filters apply to the candidates", func(t *testing.T) {
+		var out bytes.Buffer
+		if err := run(context.Background(), []string{"plan", "--cities", "Lisbon", "-c", "es"}, makeDeps(&out)); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if strings.Contains(out.String(), "pt-lis-wg-") || !strings.Contains(out.String(), "es-mad-wg-") {
+			t.Errorf("Expected Spanish relays nearest to Lisbon, got:\n%s", out.String())
+		}
+	})
+
+	t.Run("Unknown city", func(t *testing.T) {
+		var out bytes.Buffer
+		err := run(context.Background(), []string{"plan", "--cities", "Lisbon,Atlantis"}, makeDeps(&out))
+		if err == nil || !strings.Contains(err.Error(), "unknown city: Atlantis") {
+			t.Errorf("Expected an unknown city error, got %v", err)
+		}
+	})
+}
+
+func TestE2E_ValidateCommand(t *testing.T) {
+	deps := func(out *bytes.Buffer) Dependencies {
+		return Dependencies{
+			ParseRelaysFile: func(_ context.Context, _ logging.LogLevel, _ string, _ func() (string, error)) (*relays.File, error) {
+				t.Error("ParseRelaysFile should not be called by the validate command")
+				return nil, fmt.Errorf("unexpected relays lookup")
+			},
+			Stdout: out,
+		}
+	}
+
+	t.Run("Valid file", func(t *testing.T) {
+		var out bytes.Buffer
+		if err := run(context.Background(), []string{"validate", "../../testdata/relays.json"}, deps(&out)); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if !strings.HasSuffix(out.String(), "No problems found\n") {
+			t.Errorf("Expected no problems, got:\n%s", out.String())
+		}
+	})
+
+	t.Run("Structural problem", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "relays.json")
+		if err := os.WriteFile(path, []byte(`{"locations": {}}`), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		var out bytes.Buffer
+		err := run(context.Background(), []string{"validate", path}, deps(&out))
+		if err == nil || exitCode(err) != exitCodeError {
+			t.Errorf("Expected a failure with exit code %d, got %v", exitCodeError, err)
+		}
+		if !strings.HasPrefix(out.String(), "error: missing wireguard section\n") {
+			t.Errorf("Expected the missing section to be reported, got:\n%s", out.String())
+		}
+	})
+}
+
+func TestServiceFlags(t *testing.T) {
+	args := []string{"service", "-m", "500", "install", "--every", "30m", "--best-in", "New York", "--retain", "30d"}
+	want := []string{"-m", "500", "--best-in", "New York"}
+	if got := serviceFlags(args); !slices.Equal(got, want) {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+	if got := serviceFlags([]string{"service", "install"}); len(got) != 0 {
+		t.Errorf("Expected no flags, got %q", got)
+	}
+}
+
+func TestE2E_ServiceCommandUnsupported(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Installing the scheduled task modifies the system on Windows")
+	}
+
+	var out bytes.Buffer
+	deps := Dependencies{
+		ParseRelaysFile: func(_ context.Context, _ logging.LogLevel, _ string, _ func() (string, error)) (*relays.File, error) {
+			t.Error("ParseRelaysFile should not be called by the service command")
+			return nil, fmt.Errorf("unexpected relays lookup")
+		},
+		Stdout: &out,
+	}
+
+	for _, action := range []string{"install", "remove"} {
+		err := run(context.Background(), []string{"service", action}, deps)
+		if !errors.Is(err, service.ErrUnsupported) {
+			t.Errorf("Expected service %s to be unsupported, got %v", action, err)
+		}
+	}
+	if out.Len() != 0 {
+		t.Errorf("Expected no output, got:\n%s", out.String())
+	}
+
+	// Flags the scheduled searches would reject are refused at install time
+	err := run(context.Background(), []string{"service", "install", "--deterministic-output"}, deps)
+	if err == nil || !strings.Contains(err.Error(), "scheduled searches would fail") {
+		t.Errorf("Expected the flags to be refused, got %v", err)
+	}
+}
+
+func TestE2E_ApplyCommand(t *testing.T) {
+	const original = "[Interface]\nPrivateKey = cNlwWq9AjZ6TyEGaGnOOQUfOOkJTMaK7OBJhKt1RT2A=\n\n" +
+		"[Peer]\nPublicKey = 5JMPeO7gXIbR5CnUa/NPNK4L5GqUnreF0/Bozai4pl4=\nEndpoint = 185.213.154.68:51820\n"
+	path := filepath.Join(t.TempDir(), "mullvad.conf")
+	if err := os.WriteFile(path, []byte(original), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	var restarted []string
+	deps := Dependencies{
+		GetUserLocation: func(context.Context, logging.LogLevel) (*api.UserLocation, error) {
+			return &api.UserLocation{Latitude: 51.0514, Longitude: 13.7341}, nil // Dresden
+		},
+		PingLocations: func(_ context.Context, locs []relays.Location, _, _ int, _ relays.IPVersion, _ logging.LogLevel) ([]relays.Location, error) {
+			for i := range locs {
+				latency := float64(10 * (i + 1))
+				locs[i].Latency = &latency
+			}
+			return locs, nil
+		},
+		ParseRelaysFile: func(_ context.Context, _ logging.LogLevel, _ string, _ func() (string, error)) (*relays.File, error) {
+			return relays.ParseRelaysFile("../../testdata/relays.json")
+		},
+		RestartWireGuard: func(_ context.Context, path string) error {
+			restarted = append(restarted, path)
+			return nil
+		},
+		ConfigPath: tempConfigPath(t),
+		Stdout:     &out,
+	}
+
+	args := []string{"apply", "--wg-config", path, "--restart"}
+	if err := run(context.Background(), args, deps); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	file, err := relays.ParseRelaysFile("../../testdata/relays.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	locations, _, _ := relays.GetLocations(file, relays.ACNone, false, relays.IPv4)
+	var best relays.Location
+	for _, loc := range locations {
+		if strings.Contains(out.String(), "Pointed "+path+" at "+loc.Hostname+" ") {
+			best = loc
+		}
+	}
+	if best.Hostname == "" {
+		t.Fatalf("Expected the configuration to be pointed at a server, got:\n%s", out.String())
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"PublicKey = " + best.PublicKey, "Endpoint = " + best.IPv4Address + ":51820"} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("Expected %q in the configuration, got:\n%s", want, data)
+		}
+	}
+	if backup, _ := os.ReadFile(path + ".bak"); string(backup) != original {
+		t.Errorf("Expected the original configuration as backup, got:\n%s", backup)
+	}
+	if len(restarted) != 1 || restarted[0] != path {
+		t.Errorf("Expected the interface of %s to be restarted once, got %v", path, restarted)
+	}
+
+	// The configuration already points at the best server
+	out.Reset()
+	if err := run(context.Background(), args, deps); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if !strings.Contains(out.String(), "already connects to "+best.Hostname) || len(restarted) != 1 {
+		t.Errorf("Expected an unchanged configuration without restart, got:\n%s", out.String())
+	}
+}
+
+func TestE2E_PathsCommand(t *testing.T) {
+	relaysFile, err := filepath.Abs("../../testdata/relays.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("MULLVAD_COMPASS_RELAYS_FILE", relaysFile)
+	cacheDir := t.TempDir()
+	configPath := tempConfigPath(t)
+	favorites, _ := configPath(hostlist.Favorites)
+	if err := os.WriteFile(favorites, []byte("se-sto-wg-001\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	deps := Dependencies{
+		ConfigPath:      configPath,
+		RelaysCachePath: func() (string, error) { return filepath.Join(cacheDir, "relays.json"), nil },
+		HookStatePath:   func() (string, error) { return "", errors.New("no cache directory") },
+	}
+
+	t.Run("All paths", func(t *testing.T) {
+		var out bytes.Buffer
+		deps.Stdout = &out
+		if err := run(context.Background(), []string{"paths"}, deps); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		for _, want := range []string{
+			"config        " + filepath.Dir(favorites) + "\n",
+			"favorites     " + favorites + "\n",
+			"history       " + filepath.Join(filepath.Dir(favorites), "history.jsonl") + " (not created yet)\n",
+			"cache         " + cacheDir + "\n",
+			"relays        " + relaysFile + "\n",
+			"hook-state    (no cache directory)\n",
+		} {
+			if !strings.Contains(out.String(), want) {
+				t.Errorf("Expected %q in output:\n%s", want, out.String())
+			}
+		}
+		if strings.Contains(out.String(), "lock") {
+			t.Errorf("Expected no lock path without a lock, got:\n%s", out.String())
+		}
+	})
+
+	t.Run("Single path", func(t *testing.T) {
+		var out bytes.Buffer
+		deps.Stdout = &out
+		if err := run(context.Background(), []string{"paths", "relays"}, deps); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if out.String() != relaysFile+"\n" {
+			t.Errorf("Expected only the relays path, got %q", out.String())
+		}
+	})
+
+	t.Run("Unknown name", func(t *testing.T) {
+		err := run(context.Background(), []string{"paths", "logs"}, deps)
+		if err == nil || !strings.Contains(err.Error(), "unknown path") {
+			t.Errorf("Expected an unknown path error, got %v", err)
+		}
+	})
+}
+
+func TestE2E_BridgeServers(t *testing.T) {
+	var output bytes.Buffer
+	var pinged []relays.Location
+
+	deps := Dependencies{
+		GetUserLocation: func(context.Context, logging.LogLevel) (*api.UserLocation, error) {
+			return &api.UserLocation{Latitude: 59.3293, Longitude: 18.0686}, nil // Stockholm
+		},
+		PingLocations: func(_ context.Context, locs []relays.Location, _, _ int, _ relays.IPVersion, _ logging.LogLevel) ([]relays.Location, error) {
+			pinged = append(pinged, locs...)
+			for i := range locs {
+				latency := 5.0
+				locs[i].Latency = &latency
+			}
+			return locs, nil
+		},
+		ParseRelaysFile: func(_ context.Context, _ logging.LogLevel, _ string, _ func() (string, error)) (*relays.File, error) {
+			return relays.ParseRelaysFile("../../testdata/relays.json")
+		},
+		ConfigPath: tempConfigPath(t),
+		Stdout:     &output,
+	}
+
+	if err := run(context.Background(), []string{"-s", "bridge", "-m", "2000"}, deps); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	if len(pinged) == 0 {
+		t.Fatal("Expected bridge servers to be pinged")
+	}
+	for _, loc := range pinged {
+		if loc.Type != "bridge" {
+			t.Errorf("Expected only bridge servers to be pinged, got %s (%s)", loc.Hostname, loc.Type)
+		}
+	}
+
+	result := output.String()
+	if !strings.Contains(result, "-br-") {
+		t.Errorf("Expected bridge hostnames in table, got:\n%s", result)
+	}
+	if !strings.Contains(result, "Shadowsocks ports: 443/tcp (aes-256-gcm)") {
+		t.Errorf("Expected Shadowsocks ports after the table, got:\n%s", result)
+	}
+}
+
+func TestE2E_CountryFilter(t *testing.T) {
+	makeDeps := func(out *bytes.Buffer) Dependencies {
+		return Dependencies{
+			GetUserLocation: func(context.Context, logging.LogLevel) (*api.UserLocation, error) {
+				return &api.UserLocation{Latitude: 51.0514, Longitude: 13.7341}, nil // Dresden
+			},
+			PingLocations: func(_ context.Context, locs []relays.Location, _, _ int, _ relays.IPVersion, _ logging.LogLevel) ([]relays.Location, error) {
+				for i := range locs {
+					latency := 10.0
+					locs[i].Latency = &latency
+				}
+				return locs, nil
+			},
+			ParseRelaysFile: func(_ context.Context, _ logging.LogLevel, _ string, _ func() (string, error)) (*relays.File, error) {
+				return relays.ParseRelaysFile("../../testdata/relays.json")
+			},
+			ConfigPath: tempConfigPath(t),
+			Stdout:     out,
+		}
+	}
+
+	t.Run("Alias and code select the same servers", func(t *testing.T) {
+		var byAlias, byCode bytes.Buffer
+
+		if err := run(context.Background(), []string{"-c", "czechia", "--no-summary"}, makeDeps(&byAlias)); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if err := run(context.Background(), []string{"-c", "CZ", "--no-summary"}, makeDeps(&byCode)); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+
+		if byAlias.String() != byCode.String() {
+			t.Errorf("Expected identical output:\n%s\n---\n%s", byAlias.String(), byCode.String())
+		}
+		for _, line := range strings.Split(strings.TrimSpace(byAlias.String()), "\n")[2:] {
+			if !strings.HasPrefix(line, "Czech Republic") {
+				t.Errorf("Expected only Czech servers, got line: %s", line)
+			}
+		}
+	})
+
+	t.Run("Distant country is found without a distance limit", func(t *testing.T) {
+		var output bytes.Buffer
+
+		if err := run(context.Background(), []string{"-c", "Japan"}, makeDeps(&output)); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if !strings.Contains(output.String(), "Japan") {
+			t.Errorf("Expected Japanese servers, got:\n%s", output.String())
+		}
+	})
+
+	t.Run("Unknown country", func(t *testing.T) {
+		var output bytes.Buffer
+
+		err := run(context.Background(), []string{"-c", "Atlantis"}, makeDeps(&output))
+		if !errors.Is(err, errs.ErrNoServers) || !strings.Contains(err.Error(), "no servers found in Atlantis") {
+			t.Errorf("Expected no servers error, got: %v", err)
+		}
+	})
+}
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"distance fallback", errDistanceFallback, exitCodeDistanceFallback},
+		{"no servers", fmt.Errorf("%w in Atlantis", errs.ErrNoServers), exitCodeNoServers},
+		{"relays not found", fmt.Errorf("%w at /tmp", errs.ErrRelaysNotFound), exitCodeRelaysNotFound},
+		{
+			"location failed",
+			fmt.Errorf("failed to get user location: %w", fmt.Errorf("%w: timeout", errs.ErrAPILocation)),
+			exitCodeLocationFailed,
+		},
+		{"permission denied", fmt.Errorf("%w: socket", errs.ErrPermission), exitCodePermissionDenied},
+		{"other", errors.New("boom"), exitCodeError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := exitCode(tt.err); got != tt.want {
+				t.Errorf("exitCode(%v) = %d, want %d", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestE2E_StrictRelayValidation(t *testing.T) {
+	makeDeps := func(out *bytes.Buffer) Dependencies {
+		return Dependencies{
+			GetUserLocation: func(context.Context, logging.LogLevel) (*api.UserLocation, error) {
+				return &api.UserLocation{Latitude: 52.52, Longitude: 13.405}, nil
+			},
+			PingLocations: func(_ context.Context, locs []relays.Location, _, _ int, _ relays.IPVersion, _ logging.LogLevel) ([]relays.Location, error) {
+				for i := range locs {
+					latency := 10.0
+					locs[i].Latency = &latency
+				}
+				return locs, nil
+			},
+			ParseRelaysFile: func(_ context.Context, _ logging.LogLevel, _ string, _ func() (string, error)) (*relays.File, error) {
+				file, err := relays.ParseRelaysFile("../../testdata/relays.json")
+				if err != nil {
+					return nil, err
+				}
+				file.WireGuard.Relays[0].IPv4AddrIn = "not-an-ip"
+				return file, nil
+			},
+			ConfigPath: tempConfigPath(t),
+			Stdout:     out,
+		}
+	}
+
+	t.Run("Malformed endpoint is skipped by default", func(t *testing.T) {
+		var out bytes.Buffer
+		if err := run(context.Background(), []string{"-m", "1000"}, makeDeps(&out)); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if strings.Contains(out.String(), "not-an-ip") {
+			t.Errorf("Expected malformed relay to be skipped, got:\n%s", out.String())
+		}
+	})
+
+	t.Run("Malformed endpoint fails in strict mode", func(t *testing.T) {
+		var out bytes.Buffer
+		err := run(context.Background(), []string{"-m", "1000", "--strict"}, makeDeps(&out))
+		if err == nil || !strings.Contains(err.Error(), "not-an-ip") {
+			t.Fatalf("Expected strict validation error, got: %v", err)
+		}
+	})
+}
+
+func TestE2E_PerCity(t *testing.T) {
+	var output bytes.Buffer
+	deps := Dependencies{
+		GetUserLocation: func(context.Context, logging.LogLevel) (*api.UserLocation, error) {
+			return &api.UserLocation{Latitude: 51.0514, Longitude: 13.7341}, nil // Dresden
+		},
+		PingLocations: func(_ context.Context, locs []relays.Location, _, _ int, _ relays.IPVersion, _ logging.LogLevel) ([]relays.Location, error) {
+			for i := range locs {
+				latency := float64(100 - i)
+				locs[i].Latency = &latency
+			}
+			return locs, nil
+		},
+		ParseRelaysFile: func(_ context.Context, _ logging.LogLevel, _ string, _ func() (string, error)) (*relays.File, error) {
+			return relays.ParseRelaysFile("../../testdata/relays.json")
+		},
+		ConfigPath: tempConfigPath(t),
+		Stdout:     &output,
+	}
+
+	if err := run(context.Background(), []string{"-m", "250", "--per-city"}, deps); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+	if !strings.Contains(lines[0], "Relays") {
+		t.Errorf("Expected Relays column, got header: %q", lines[0])
+	}
+
+	// Prague and Berlin are within 250 km of Dresden; each appears once with its relay count
+	var rows []string
+	for _, line := range lines[2:] {
+		if line == "" {
+			break
+		}
+		rows = append(rows, line)
+	}
+	if len(rows) != 2 {
+		t.Fatalf("Expected one row per city, got %d:\n%s", len(rows), output.String())
+	}
+	for _, row := range rows {
+		if !strings.HasSuffix(strings.TrimSpace(row), " relays") {
+			t.Errorf("Expected relay count at end of row, got %q", row)
+		}
+	}
+
+	// The summary still covers every pinged server (8 in Berlin, 4 in Prague)
+	if !strings.Contains(output.String(), "12 servers") {
+		t.Errorf("Expected summary over all servers, got:\n%s", output.String())
+	}
+}
+
+func TestE2E_UseAppSettings(t *testing.T) {
+	makeDeps := func(out *bytes.Buffer, settings *appsettings.Settings, pinged *[]relays.Location) Dependencies {
+		return Dependencies{
+			GetUserLocation: func(context.Context, logging.LogLevel) (*api.UserLocation, error) {
+				return &api.UserLocation{Latitude: 59.33, Longitude: 18.07}, nil // Stockholm
+			},
+			PingLocations: func(_ context.Context, locs []relays.Location, _, _ int, _ relays.IPVersion, _ logging.LogLevel) ([]relays.Location, error) {
+				for i := range locs {
+					latency := 10.0
+					locs[i].Latency = &latency
+				}
+				*pinged = append(*pinged, locs...)
+				return locs, nil
+			},
+			ParseRelaysFile: func(_ context.Context, _ logging.LogLevel, _ string, _ func() (string, error)) (*relays.File, error) {
+				return relays.ParseRelaysFile("../../testdata/relays.json")
+			},
+			LoadAppSettings: func(logging.LogLevel) (*appsettings.Settings, error) {
+				if settings == nil {
+					return nil, fmt.Errorf("settings.json not found")
+				}
+				return settings, nil
+			},
+			ConfigPath: tempConfigPath(t),
+			Stdout:     out,
+		}
+	}
+
+	t.Run("Location and ownership constraints are applied", func(t *testing.T) {
+		settings := &appsettings.Settings{Relay: appsettings.Constraints{
+			Locations: []appsettings.Location{{CountryCode: "se", CityCode: "got"}},
+			Ownership: appsettings.MullvadOwned,
+		}}
+		var out bytes.Buffer
+		var pinged []relays.Location
+		if err := run(context.Background(), []string{"--use-app-settings", "-m", "20000"}, makeDeps(&out, settings, &pinged)); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if len(pinged) == 0 {
+			t.Fatal("Expected servers to be pinged")
+		}
+		for _, loc := range pinged {
+			if loc.City != "Gothenburg" || !loc.IsMullvadOwned {
+				t.Errorf("Expected only Mullvad-owned Gothenburg servers, got %s (%s)", loc.Hostname, loc.City)
+			}
+		}
+	})
+
+	t.Run("Obfuscation becomes the anti-censorship filter", func(t *testing.T) {
+		settings := &appsettings.Settings{AntiCensorship: relays.QUIC}
+		var out bytes.Buffer
+		var pinged []relays.Location
+		if err := run(context.Background(), []string{"--use-app-settings", "-m", "20000"}, makeDeps(&out, settings, &pinged)); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+
+		file, err := relays.ParseRelaysFile("../../testdata/relays.json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		quic, _, _ := relays.GetLocations(file, relays.QUIC, false, relays.IPv4)
+		if len(pinged) != len(quic) {
+			t.Errorf("Expected %d QUIC servers to be pinged, got %d", len(quic), len(pinged))
+		}
+	})
+
+	t.Run("Country flag replaces the app's location", func(t *testing.T) {
+		settings := &appsettings.Settings{Relay: appsettings.Constraints{
+			Locations: []appsettings.Location{{CountryCode: "se"}},
+		}}
+		var out bytes.Buffer
+		var pinged []relays.Location
+		if err := run(context.Background(), []string{"--use-app-settings", "-c", "no"}, makeDeps(&out, settings, &pinged)); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		for _, loc := range pinged {
+			if loc.CountryCode != "no" {
+				t.Errorf("Expected only Norwegian servers, got %s", loc.Hostname)
+			}
+		}
+	})
+
+	t.Run("No allowed servers", func(t *testing.T) {
+		settings := &appsettings.Settings{Relay: appsettings.Constraints{Providers: []string{"nonexistent"}}}
+		var out bytes.Buffer
+		var pinged []relays.Location
+		err := run(context.Background(), []string{"--use-app-settings"}, makeDeps(&out, settings, &pinged))
+		if err == nil || !strings.Contains(err.Error(), "Mullvad app's relay settings") {
+			t.Errorf("Expected no-servers error, got: %v", err)
+		}
+	})
+
+	t.Run("Load failure", func(t *testing.T) {
+		var out bytes.Buffer
+		var pinged []relays.Location
+		err := run(context.Background(), []string{"--use-app-settings"}, makeDeps(&out, nil, &pinged))
+		if err == nil || !strings.Contains(err.Error(), "settings.json not found") {
+			t.Errorf("Expected load error, got: %v", err)
+		}
+	})
+}
+
+func TestE2E_MinCityRelays(t *testing.T) {
+	makeDeps := func(out *bytes.Buffer) Dependencies {
+		return Dependencies{
+			GetUserLocation: func(context.Context, logging.LogLevel) (*api.UserLocation, error) {
+				return &api.UserLocation{Latitude: 51.0514, Longitude: 13.7341}, nil // Dresden
+			},
+			// Prague, with 4 relays, is the fastest, followed by Berlin, with 8
+			PingLocations: func(_ context.Context, locs []relays.Location, _, _ int, _ relays.IPVersion, _ logging.LogLevel) ([]relays.Location, error) {
+				for i := range locs {
+					latency := 30.0
+					switch {
+					case strings.HasPrefix(locs[i].Hostname, "cz-prg-"):
+						latency = 5.0
+					case strings.HasPrefix(locs[i].Hostname, "de-ber-"):
+						latency = 10.0
+					}
+					locs[i].Latency = &latency
+				}
+				return locs, nil
+			},
+			ParseRelaysFile: func(_ context.Context, _ logging.LogLevel, _ string, _ func() (string, error)) (*relays.File, error) {
+				return relays.ParseRelaysFile("../../testdata/relays.json")
+			},
+			ConfigPath: tempConfigPath(t),
+			Stdout:     out,
+		}
+	}
+
+	tests := []struct {
+		name    string
+		args    []string
+		want    []string
+		notWant string
+	}{
+		{
+			"Alternative in a larger city",
+			[]string{"--min-city-relays", "5"},
+			[]string{"Best server:     Prague", "Alternative:     Berlin, Germany (8 relays)"},
+			"",
+		},
+		{
+			"Best city large enough",
+			[]string{"--min-city-relays", "4"},
+			[]string{"Best server:     Prague"},
+			"Alternative",
+		},
+		{
+			"Plain",
+			[]string{"--min-city-relays", "5", "--plain"},
+			[]string{"Best server: cz-prg-", "Alternative: de-ber-wg-", "8 relays"},
+			"",
+		},
+		{
+			"No large enough city responded",
+			[]string{"--min-city-relays", "100"},
+			[]string{"No server in a city with at least 100 relays responded"},
+			"",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := run(context.Background(), tt.args, makeDeps(&out)); err != nil {
+				t.Fatalf("Expected no error, got: %v", err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(out.String(), want) {
+					t.Errorf("Expected %q in the output, got:\n%s", want, out.String())
+				}
+			}
+			if tt.notWant != "" && strings.Contains(out.String(), tt.notWant) {
+				t.Errorf("Did not expect %q in the output, got:\n%s", tt.notWant, out.String())
+			}
+		})
+	}
+}
+
+func TestE2E_TieBreak(t *testing.T) {
+	makeDeps := func(out *bytes.Buffer) Dependencies {
+		return Dependencies{
+			GetUserLocation: func(context.Context, logging.LogLevel) (*api.UserLocation, error) {
+				return &api.UserLocation{Latitude: 51.0514, Longitude: 13.7341}, nil // Dresden
+			},
+			// cz-prg-wg-202 is the fastest, and the other Prague relays are within a millisecond of it
+			PingLocations: func(_ context.Context, locs []relays.Location, _, _ int, _ relays.IPVersion, _ logging.LogLevel) ([]relays.Location, error) {
+				for i := range locs {
+					latency := 30.0
+					switch {
+					case locs[i].Hostname == "cz-prg-wg-202":
+						latency = 10.0
+					case strings.HasPrefix(locs[i].Hostname, "cz-prg-"):
+						latency = 10.5
+					}
+					locs[i].Latency = &latency
+				}
+				return locs, nil
+			},
+			ParseRelaysFile: func(_ context.Context, _ logging.LogLevel, _ string, _ func() (string, error)) (*relays.File, error) {
+				return relays.ParseRelaysFile("../../testdata/relays.json")
+			},
+			ConfigPath: tempConfigPath(t),
+			Stdout:     out,
+		}
+	}
+
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{nil, "cz-prg-wg-202"},
+		{[]string{"--tie-break", "first"}, "cz-prg-wg-202"},
+		{[]string{"--tie-break", "alphabetical"}, "cz-prg-wg-101"},
+		{[]string{"--tie-break", "random"}, "cz-prg-wg-"},
+		{[]string{"--tie-break", "weight"}, "cz-prg-wg-"},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.args, " "), func(t *testing.T) {
+			var out bytes.Buffer
+			if err := run(context.Background(), tt.args, makeDeps(&out)); err != nil {
+				t.Fatalf("Expected no error, got: %v", err)
+			}
+			if !strings.Contains(out.String(), "Best server:     Prague") || !strings.Contains(out.String(), tt.want) {
+				t.Errorf("Expected %s in Prague as the best server, got:\n%s", tt.want, out.String())
+			}
+		})
+	}
+}
+
+func TestE2E_GeoJSON(t *testing.T) {
+	makeDeps := func(out *bytes.Buffer) Dependencies {
+		return Dependencies{
+			GetUserLocation: func(context.Context, logging.LogLevel) (*api.UserLocation, error) {
+				return &api.UserLocation{City: "Dresden", Latitude: 51.0514, Longitude: 13.7341}, nil
+			},
+			PingLocations: func(_ context.Context, locs []relays.Location, _, _ int, _ relays.IPVersion, _ logging.LogLevel) ([]relays.Location, error) {
+				for i := range locs {
+					latency := 20.0
+					locs[i].Latency = &latency
+				}
+				return locs, nil
+			},
+			ParseRelaysFile: func(_ context.Context, _ logging.LogLevel, _ string, _ func() (string, error)) (*relays.File, error) {
+				return relays.ParseRelaysFile("../../testdata/relays.json")
+			},
+			ConfigPath: tempConfigPath(t),
+			Stdout:     out,
+		}
+	}
+
+	for _, args := range [][]string{
+		{"--output", "geojson"},
+		{"--output", "geojson", "-m", "250", "--timings"},
+	} {
+		t.Run(strings.Join(args, " "), func(t *testing.T) {
+			var out bytes.Buffer
+			if err := run(context.Background(), args, makeDeps(&out)); err != nil {
+				t.Fatalf("Expected no error, got: %v", err)
+			}
+
+			var collection struct {
+				Type     string `json:"type"`
+				Features []struct {
+					Properties struct {
+						Kind string `json:"kind"`
+						City string `json:"city"`
+						Rank int    `json:"rank"`
+					} `json:"properties"`
+				} `json:"features"`
+			}
+			if err := json.Unmarshal(out.Bytes(), &collection); err != nil {
+				t.Fatalf("Expected only GeoJSON in the output, got %v:\n%s", err, out.String())
+			}
+			if collection.Type != "FeatureCollection" || len(collection.Features) < 2 {
+				t.Fatalf("Expected the user and the servers, got:\n%s", out.String())
+			}
+			if user := collection.Features[0].Properties; user.Kind != "user" || user.City != "Dresden" {
+				t.Errorf("Expected the user's location first, got %+v", user)
+			}
+			if server := collection.Features[1].Properties; server.Kind != "server" || server.Rank != 1 {
+				t.Errorf("Expected the best server second, got %+v", server)
+			}
+		})
+	}
+
+	t.Run("No servers within distance", func(t *testing.T) {
+		var out bytes.Buffer
+		if err := run(context.Background(), []string{"--output", "geojson", "-m", "10"}, makeDeps(&out)); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+
+		var collection struct {
+			Type     string `json:"type"`
+			Features []struct {
+				Properties struct {
+					Kind string `json:"kind"`
+				} `json:"properties"`
+			} `json:"features"`
+		}
+		if err := json.Unmarshal(out.Bytes(), &collection); err != nil {
+			t.Fatalf("Expected only GeoJSON in the output, got %v:\n%s", err, out.String())
+		}
+		if collection.Type != "FeatureCollection" || len(collection.Features) != 1 ||
+			collection.Features[0].Properties.Kind != "user" {
+			t.Errorf("Expected the user's location alone, got:\n%s", out.String())
+		}
+	})
+}
+
+func TestE2E_HTMLReport(t *testing.T) {
+	for _, args := range [][]string{nil, {"-m", "250"}} {
+		t.Run(strings.Join(args, " "), func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "report.html")
+			var out bytes.Buffer
+			deps := Dependencies{
+				GetUserLocation: func(context.Context, logging.LogLevel) (*api.UserLocation, error) {
+					return &api.UserLocation{City: "Dresden", Latitude: 51.0514, Longitude: 13.7341}, nil
+				},
+				PingLocations: func(_ context.Context, locs []relays.Location, _, _ int, _ relays.IPVersion, _ logging.LogLevel) ([]relays.Location, error) {
+					for i := range locs {
+						latency := 20.0
+						locs[i].Latency = &latency
+					}
+					return locs, nil
+				},
+				ParseRelaysFile: func(_ context.Context, _ logging.LogLevel, _ string, _ func() (string, error)) (*relays.File, error) {
+					return relays.ParseRelaysFile("../../testdata/relays.json")
+				},
+				ConfigPath: tempConfigPath(t),
+				Stdout:     &out,
+			}
+
+			ctx := clock.WithClock(context.Background(), clock.NewFake(time.Date(2025, 1, 1, 12, 30, 0, 0, time.UTC)))
+			if err := run(ctx, append(args, "--output", "html", path), deps); err != nil {
+				t.Fatalf("Expected no error, got: %v", err)
+			}
+
+			// The regular output is printed as well
+			if !strings.Contains(out.String(), "Wrote the HTML report to "+path) ||
+				!strings.Contains(out.String(), "cz-prg") {
+				t.Errorf("Expected the regular output and the report path, got:\n%s", out.String())
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("Expected the report to be written: %v", err)
+			}
+			if !bytes.Contains(data, []byte(`<table id="servers">`)) || !bytes.Contains(data, []byte("From Dresden")) {
+				t.Errorf("Expected the servers in the report, got:\n%s", data)
+			}
+			if !bytes.Contains(data, []byte("generated 2025-01-01 12:30 UTC")) {
+				t.Errorf("Expected the report dated by the clock of the context, got:\n%s", data)
+			}
+		})
+	}
+}
+
+func TestE2E_ConstraintCost(t *testing.T) {
+	settings := &appsettings.Settings{Relay: appsettings.Constraints{
+		Locations: []appsettings.Location{{CountryCode: "se", CityCode: "got"}},
+	}}
+	var out bytes.Buffer
+	var pingCalls int
+	deps := Dependencies{
+		GetUserLocation: func(context.Context, logging.LogLevel) (*api.UserLocation, error) {
+			return &api.UserLocation{Latitude: 59.33, Longitude: 18.07}, nil // Stockholm
+		},
+		PingLocations: func(_ context.Context, locs []relays.Location, _, _ int, _ relays.IPVersion, _ logging.LogLevel) ([]relays.Location, error) {
+			pingCalls++
+			for i := range locs {
+				latency := 5 + *locs[i].DistanceFromMyLocation/10
+				locs[i].Latency = &latency
+			}
+			return locs, nil
+		},
+		ParseRelaysFile: func(_ context.Context, _ logging.LogLevel, _ string, _ func() (string, error)) (*relays.File, error) {
+			return relays.ParseRelaysFile("../../testdata/relays.json")
+		},
+		LoadAppSettings: func(logging.LogLevel) (*appsettings.Settings, error) {
+			return settings, nil
+		},
+		ConfigPath: tempConfigPath(t),
+		Stdout:     &out,
+	}
+
+	if err := run(context.Background(), []string{"--constraint-cost"}, deps); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if pingCalls != 1 {
+		t.Errorf("Expected both searches to be pinged at once, got %d ping calls", pingCalls)
+	}
+
+	output := out.String()
+	for _, want := range []string{
+		"Best server:     Stockholm, Sweden",
+		"App allows:      Gothenburg, Sweden",
+		"The Mullvad app's relay constraints cost ",
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("Expected output to contain %q, got:\n%s", want, output)
+		}
+	}
+}
+
+func TestE2E_DualStack(t *testing.T) {
+	makeDeps := func(out *bytes.Buffer, routeErr error, families *[]relays.IPVersion) Dependencies {
+		return Dependencies{
+			GetUserLocation: func(context.Context, logging.LogLevel) (*api.UserLocation, error) {
+				return &api.UserLocation{Latitude: 51.0514, Longitude: 13.7341}, nil // Dresden
+			},
+			PingLocations: func(_ context.Context, locs []relays.Location, _, _ int, ipVersion relays.IPVersion, _ logging.LogLevel) ([]relays.Location, error) {
+				*families = append(*families, ipVersion)
+				latency := 10.0
+				if ipVersion == relays.IPv6 {
+					latency = 16.5
+				}
+				for i := range locs {
+					locs[i].Latency = &latency
+				}
+				// Results come back in a different order than the locations were given in
+				slices.Reverse(locs)
+				return locs, nil
+			},
+			ParseRelaysFile: func(_ context.Context, _ logging.LogLevel, _ string, _ func() (string, error)) (*relays.File, error) {
+				return relays.ParseRelaysFile("../../testdata/relays.json")
+			},
+			CheckIPv6Route: func(string) error { return routeErr },
+			ConfigPath:     tempConfigPath(t),
+			Stdout:         out,
+		}
+	}
+
+	t.Run("One row per relay with both latencies", func(t *testing.T) {
+		var out bytes.Buffer
+		var families []relays.IPVersion
+		args := []string{"-m", "500", "--ip-version", "both", "--no-summary"}
+		if err := run(context.Background(), args, makeDeps(&out, nil, &families)); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+
+		if !slices.Equal(families, []relays.IPVersion{relays.IPv4, relays.IPv6}) {
+			t.Errorf("Expected a ping over IPv4, then IPv6, got %v", families)
+		}
+		lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+		if !strings.Contains(lines[0], "IPv4 Latency (ms)   IPv6 Latency (ms)") {
+			t.Errorf("Expected both latency columns, got %q", lines[0])
+		}
+		seen := make(map[string]bool)
+		for _, line := range lines[2:] {
+			if line == "" {
+				break
+			}
+			fields := strings.Fields(line)
+			hostname := fields[slices.IndexFunc(fields, func(f string) bool { return strings.Contains(f, "-wg-") })]
+			if seen[hostname] {
+				t.Errorf("Expected %s in a single row, got:\n%s", hostname, out.String())
+			}
+			seen[hostname] = true
+			if latencies := fields[len(fields)-2:]; latencies[0] != "10.00" || latencies[1] != "16.50" {
+				t.Errorf("Expected the IPv4 and IPv6 latency of the relay, got %q", line)
+			}
+		}
+		if len(seen) == 0 {
+			t.Errorf("Expected relays in the table, got:\n%s", out.String())
+		}
+	})
+
+	t.Run("IPv4-only host pings over IPv4 only", func(t *testing.T) {
+		var out bytes.Buffer
+		var families []relays.IPVersion
+		routeErr := fmt.Errorf("%w: network is unreachable", netcheck.ErrNoIPv6Route)
+		args := []string{"-m", "500", "--ip-version", "both"}
+		if err := run(context.Background(), args, makeDeps(&out, routeErr, &families)); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+
+		if !slices.Equal(families, []relays.IPVersion{relays.IPv4}) {
+			t.Errorf("Expected a single ping over IPv4, got %v", families)
+		}
+		if strings.Contains(out.String(), "IPv6") {
+			t.Errorf("Expected no IPv6 columns, got:\n%s", out.String())
+		}
+	})
+}
+
+func TestE2E_IPv6RouteCheck(t *testing.T) {
+	makeDeps := func(out *bytes.Buffer, routeErr error, checked *[]string, pinged *bool) Dependencies {
+		return Dependencies{
+			GetUserLocation: func(context.Context, logging.LogLevel) (*api.UserLocation, error) {
+				return &api.UserLocation{Latitude: 52.52, Longitude: 13.405}, nil
+			},
+			PingLocations: func(_ context.Context, locs []relays.Location, _, _ int, _ relays.IPVersion, _ logging.LogLevel) ([]relays.Location, error) {
+				*pinged = true
+				for i := range locs {
+					latency := 10.0
+					locs[i].Latency = &latency
+				}
+				return locs, nil
+			},
+			ParseRelaysFile: func(_ context.Context, _ logging.LogLevel, _ string, _ func() (string, error)) (*relays.File, error) {
+				return relays.ParseRelaysFile("../../testdata/relays.json")
+			},
+			CheckIPv6Route: func(addr string) error {
+				*checked = append(*checked, addr)
+				return routeErr
+			},
+			ConfigPath: tempConfigPath(t),
+			Stdout:     out,
+		}
+	}
+
+	t.Run("Missing route fails before pinging", func(t *testing.T) {
+		var out bytes.Buffer
+		var checked []string
+		var pinged bool
+		routeErr := fmt.Errorf("%w: network is unreachable", netcheck.ErrNoIPv6Route)
+
+		err := run(context.Background(), []string{"-6"}, makeDeps(&out, routeErr, &checked, &pinged))
+		if !errors.Is(err, netcheck.ErrNoIPv6Route) || !strings.Contains(err.Error(), "run without -6") {
+			t.Fatalf("Expected IPv6 route error, got: %v", err)
+		}
+		if pinged {
+			t.Error("Expected no pings without an IPv6 route")
+		}
+		if len(checked) != 1 || !strings.Contains(checked[0], ":") {
+			t.Errorf("Expected a single check against a relay IPv6 address, got %v", checked)
+		}
+	})
+
+	t.Run("Available route proceeds", func(t *testing.T) {
+		var out bytes.Buffer
+		var checked []string
+		var pinged bool
+
+		if err := run(context.Background(), []string{"-6"}, makeDeps(&out, nil, &checked, &pinged)); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if !pinged {
+			t.Error("Expected servers to be pinged")
+		}
+	})
+
+	t.Run("IPv4 is not checked", func(t *testing.T) {
+		var out bytes.Buffer
+		var checked []string
+		var pinged bool
+
+		args := []string{"-m", "500", "--ip-version", "4"}
+		if err := run(context.Background(), args, makeDeps(&out, nil, &checked, &pinged)); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if len(checked) != 0 {
+			t.Errorf("Expected no IPv6 route check, got %v", checked)
+		}
+	})
+
+	t.Run("IPv4-only host hides the IPv6 column", func(t *testing.T) {
+		var out bytes.Buffer
+		var checked []string
+		var pinged bool
+		routeErr := fmt.Errorf("%w: network is unreachable", netcheck.ErrNoIPv6Route)
+
+		args := []string{"-m", "500", "--show-ips", "both"}
+		if err := run(context.Background(), args, makeDeps(&out, routeErr, &checked, &pinged)); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if len(checked) != 1 {
+			t.Errorf("Expected a single IPv6 route check, got %v", checked)
+		}
+		if strings.Contains(out.String(), "IPv6") {
+			t.Errorf("Expected no IPv6 column, got:\n%s", out.String())
+		}
+	})
+
+	t.Run("Default columns follow the route", func(t *testing.T) {
+		var logBuf bytes.Buffer
+		log.SetOutput(&logBuf)
+		defer log.SetOutput(os.Stderr)
+
+		var out bytes.Buffer
+		var checked []string
+		var pinged bool
+		routeErr := fmt.Errorf("%w: network is unreachable", netcheck.ErrNoIPv6Route)
+
+		deps := makeDeps(&out, routeErr, &checked, &pinged)
+		if err := run(context.Background(), []string{"-m", "500"}, deps); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if strings.Contains(out.String(), "IPv6") {
+			t.Errorf("Expected no IPv6 column on an IPv4-only host, got:\n%s", out.String())
+		}
+		if strings.Contains(logBuf.String(), "IPv6 is not available") {
+			t.Errorf("Expected the default IPv6 column to be dropped without a warning, got:\n%s", logBuf.String())
+		}
+
+		out.Reset()
+		if err := run(context.Background(), []string{"-m", "500"}, makeDeps(&out, nil, &checked, &pinged)); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if !strings.Contains(out.String(), "IPv4") || !strings.Contains(out.String(), "IPv6") {
+			t.Errorf("Expected IPv4 and IPv6 columns by default, got:\n%s", out.String())
+		}
+	})
+
+	t.Run("Dual-stack host keeps the IPv6 column", func(t *testing.T) {
+		var out bytes.Buffer
+		var checked []string
+		var pinged bool
+
+		args := []string{"-m", "500", "--show-ips", "both"}
+		if err := run(context.Background(), args, makeDeps(&out, nil, &checked, &pinged)); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if !strings.Contains(out.String(), "IPv6") {
+			t.Errorf("Expected an IPv6 column, got:\n%s", out.String())
+		}
+	})
+
+	t.Run("JSON report records the detection", func(t *testing.T) {
+		var out bytes.Buffer
+		var checked []string
+		var pinged bool
+		routeErr := fmt.Errorf("%w: network is unreachable", netcheck.ErrNoIPv6Route)
+
+		args := []string{"-m", "500", "--share", "json"}
+		if err := run(context.Background(), args, makeDeps(&out, routeErr, &checked, &pinged)); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if !strings.Contains(out.String(), `"host_ipv6": false`) {
+			t.Errorf("Expected host_ipv6 in the report, got:\n%s", out.String())
+		}
+	})
+
+	t.Run("JSON report includes the warnings", func(t *testing.T) {
+		var out bytes.Buffer
+		var checked []string
+		var pinged bool
+		routeErr := fmt.Errorf("%w: network is unreachable", netcheck.ErrNoIPv6Route)
+
+		args := []string{"-m", "500", "--share", "json", "--show-ips", "both"}
+		if err := run(context.Background(), args, makeDeps(&out, routeErr, &checked, &pinged)); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		var report formatter.ShareReport
+		if err := json.Unmarshal(out.Bytes(), &report); err != nil {
+			t.Fatalf("Expected a JSON report, got %v:\n%s", err, out.String())
+		}
+		if len(report.Warnings) != 1 || report.Warnings[0].Kind != warnings.IPv6Unavailable {
+			t.Errorf("Expected an IPv6 warning in the report, got %+v", report.Warnings)
+		}
+	})
+}
+
+func TestE2E_RankCombined(t *testing.T) {
+	// Farther servers respond a fraction of a millisecond faster, within the noise of a measurement
+	search := func(t *testing.T, args ...string) (output, nearest, farthest string) {
+		var out bytes.Buffer
+		var nearestKm, farthestKm float64
+		deps := Dependencies{
+			GetUserLocation: func(context.Context, logging.LogLevel) (*api.UserLocation, error) {
+				return &api.UserLocation{Latitude: 51.0514, Longitude: 13.7341}, nil
+			},
+			PingLocations: func(_ context.Context, locs []relays.Location, _, _ int, _ relays.IPVersion, _ logging.LogLevel) ([]relays.Location, error) {
+				for i := range locs {
+					km := *locs[i].DistanceFromMyLocation
+					latency := 20 - km/1000
+					locs[i].Latency = &latency
+					if nearest == "" || km < nearestKm {
+						nearest, nearestKm = locs[i].Hostname, km
+					}
+					if farthest == "" || km > farthestKm {
+						farthest, farthestKm = locs[i].Hostname, km
+					}
+				}
+				return locs, nil
+			},
+			ParseRelaysFile: func(_ context.Context, _ logging.LogLevel, _ string, _ func() (string, error)) (*relays.File, error) {
+				return relays.ParseRelaysFile("../../testdata/relays.json")
+			},
+			ConfigPath: tempConfigPath(t),
+			Stdout:     &out,
+		}
+		if err := run(context.Background(), append([]string{"-m", "500"}, args...), deps); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		return out.String(), nearest, farthest
+	}
+
+	t.Run("Latency ranking", func(t *testing.T) {
+		out, nearest, farthest := search(t)
+		if strings.Index(out, farthest) > strings.Index(out, nearest) {
+			t.Errorf("Expected the fastest (farthest) server %s first, got:\n%s", farthest, out)
+		}
+	})
+
+	t.Run("Combined ranking", func(t *testing.T) {
+		out, nearest, farthest := search(t, "--rank", "combined", "--distance-weight", "0.8")
+		if strings.Index(out, nearest) > strings.Index(out, farthest) {
+			t.Errorf("Expected the nearest server %s first, got:\n%s", nearest, out)
+		}
+	})
+}
+
+func TestE2E_FeaturesColumn(t *testing.T) {
+	var out bytes.Buffer
+	var pinged []relays.Location
+	deps := Dependencies{
+		GetUserLocation: func(context.Context, logging.LogLevel) (*api.UserLocation, error) {
+			return &api.UserLocation{Latitude: 51.0514, Longitude: 13.7341}, nil // Dresden
+		},
+		PingLocations: func(_ context.Context, locs []relays.Location, _, _ int, _ relays.IPVersion, _ logging.LogLevel) ([]relays.Location, error) {
+			for i := range locs {
+				latency := 10.0
+				locs[i].Latency = &latency
+			}
+			pinged = locs
+			return locs, nil
+		},
+		ParseRelaysFile: func(_ context.Context, _ logging.LogLevel, _ string, _ func() (string, error)) (*relays.File, error) {
+			return relays.ParseRelaysFile("../../testdata/relays.json")
+		},
+		ConfigPath: tempConfigPath(t),
+		Stdout:     &out,
+	}
+
+	if err := run(context.Background(), []string{"-m", "500", "--features"}, deps); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if !strings.Contains(out.String(), "Features") {
+		t.Errorf("Expected a Features column, got:\n%s", out.String())
+	}
+	for _, loc := range pinged {
+		if !strings.Contains(out.String(), loc.Features.Flags()) {
+			t.Errorf("Expected the flags %s of %s, got:\n%s", loc.Features.Flags(), loc.Hostname, out.String())
+		}
+	}
+}
+
+func TestE2E_GoodEnough(t *testing.T) {
+	var out bytes.Buffer
+	pinger := ping.NewMockPinger()
+	pinger.PingFunc = func(context.Context, string, time.Duration) *float64 {
+		latency := 4.0
+		return &latency
+	}
+	deps := Dependencies{
+		GetUserLocation: func(context.Context, logging.LogLevel) (*api.UserLocation, error) {
+			return &api.UserLocation{Latitude: 51.0514, Longitude: 13.7341}, nil // Dresden
+		},
+		PingLocations: func(ctx context.Context, locs []relays.Location, timeout, _ int, ipVersion relays.IPVersion, logLevel logging.LogLevel) ([]relays.Location, error) {
+			return ping.LocationsWithPinger(ctx, locs, timeout, 1, ipVersion, pinger, logLevel)
+		},
+		ParseRelaysFile: func(_ context.Context, _ logging.LogLevel, _ string, _ func() (string, error)) (*relays.File, error) {
+			return relays.ParseRelaysFile("../../testdata/relays.json")
+		},
+		ConfigPath: tempConfigPath(t),
+		Stdout:     &out,
+	}
+
+	if err := run(context.Background(), []string{"--good-enough", "5"}, deps); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if calls := len(pinger.GetPingCalls()); calls != 1 {
+		t.Errorf("Expected the search to stop after the first server, got %d pings", calls)
+	}
+	if !strings.Contains(out.String(), "Best server:") ||
+		!strings.Contains(out.String(), "responded in less than 5 ms (--good-enough)") {
+		t.Errorf("Expected the best server with a note, got:\n%s", out.String())
+	}
+}
+
+func TestE2E_UnlocatedRelays(t *testing.T) {
+	makeDeps := func(out *bytes.Buffer, pinged *[]string) Dependencies {
+		return Dependencies{
+			GetUserLocation: func(context.Context, logging.LogLevel) (*api.UserLocation, error) {
+				return &api.UserLocation{Latitude: 51.0514, Longitude: 13.7341}, nil
+			},
+			PingLocations: func(_ context.Context, locs []relays.Location, _, _ int, _ relays.IPVersion, _ logging.LogLevel) ([]relays.Location, error) {
+				for i := range locs {
+					*pinged = append(*pinged, locs[i].Hostname)
+					latency := 10.0
+					locs[i].Latency = &latency
+				}
+				return locs, nil
+			},
+			ParseRelaysFile: func(_ context.Context, _ logging.LogLevel, _ string, _ func() (string, error)) (*relays.File, error) {
+				file, err := relays.ParseRelaysFile("../../testdata/relays.json")
+				if err != nil {
+					return nil, err
+				}
+				// A location relays.json lists without coordinates decodes to 0°, 0°
+				file.Locations["de-nul"] = relays.LocationEntry{City: "Nowhere", Country: "Germany"}
+				file.WireGuard.Relays = append(file.WireGuard.Relays, relays.WireGuardRelay{
+					Hostname:         "de-nul-wg-001",
+					Active:           true,
+					Location:         "de-nul",
+					IPv4AddrIn:       "10.9.9.9",
+					IncludeInCountry: true,
+				})
+				return file, nil
+			},
+			ConfigPath: tempConfigPath(t),
+			Stdout:     out,
+		}
+	}
+
+	t.Run("Skipped by default", func(t *testing.T) {
+		var out bytes.Buffer
+		var pinged []string
+		if err := run(context.Background(), []string{"-m", "500"}, makeDeps(&out, &pinged)); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if slices.Contains(pinged, "de-nul-wg-001") {
+			t.Error("Expected the relay without coordinates not to be pinged")
+		}
+	})
+
+	t.Run("Included regardless of distance", func(t *testing.T) {
+		var out bytes.Buffer
+		var pinged []string
+		args := []string{"-m", "500", "--include-unlocated"}
+		if err := run(context.Background(), args, makeDeps(&out, &pinged)); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if !slices.Contains(pinged, "de-nul-wg-001") || !strings.Contains(out.String(), "de-nul-wg-001") {
+			t.Errorf("Expected the relay without coordinates to be pinged and shown, got:\n%s", out.String())
+		}
+	})
+
+	t.Run("Included in Best Server Mode", func(t *testing.T) {
+		var out bytes.Buffer
+		var pinged []string
+		if err := run(context.Background(), []string{"--include-unlocated"}, makeDeps(&out, &pinged)); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if !slices.Contains(pinged, "de-nul-wg-001") {
+			t.Error("Expected the relay without coordinates to be pinged")
+		}
+	})
+}
+
+func TestE2E_ProbeSource(t *testing.T) {
+	makeDeps := func(out *bytes.Buffer, source *ping.Source, pinged *bool) Dependencies {
+		return Dependencies{
+			GetUserLocation: func(context.Context, logging.LogLevel) (*api.UserLocation, error) {
+				return &api.UserLocation{Latitude: 52.52, Longitude: 13.405}, nil
+			},
+			PingLocations: func(ctx context.Context, locs []relays.Location, _, _ int, _ relays.IPVersion, _ logging.LogLevel) ([]relays.Location, error) {
+				*pinged = true
+				*source = ping.SourceFromContext(ctx)
+				for i := range locs {
+					latency := 10.0
+					locs[i].Latency = &latency
+				}
+				return locs, nil
+			},
+			ParseRelaysFile: func(_ context.Context, _ logging.LogLevel, _ string, _ func() (string, error)) (*relays.File, error) {
+				return relays.ParseRelaysFile("../../testdata/relays.json")
+			},
+			ConfigPath: tempConfigPath(t),
+			Stdout:     out,
+		}
+	}
+
+	t.Run("Source address reaches the pinger", func(t *testing.T) {
+		var out bytes.Buffer
+		var source ping.Source
+		var pinged bool
+
+		err := run(context.Background(), []string{"--source-ip", "127.0.0.1"}, makeDeps(&out, &source, &pinged))
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if !pinged {
+			t.Fatal("Expected servers to be pinged")
+		}
+		if source.Address != "127.0.0.1" || source.Interface != "" {
+			t.Errorf("Expected source address 127.0.0.1, got %+v", source)
+		}
+	})
+
+	t.Run("No source by default", func(t *testing.T) {
+		var out bytes.Buffer
+		var source ping.Source
+		var pinged bool
+
+		if err := run(context.Background(), []string{}, makeDeps(&out, &source, &pinged)); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if !source.IsZero() {
+			t.Errorf("Expected zero source, got %+v", source)
+		}
+	})
+
+	t.Run("Unknown interface fails before pinging", func(t *testing.T) {
+		var out bytes.Buffer
+		var source ping.Source
+		var pinged bool
+
+		args := []string{"--interface", "mullvad-compass-none"}
+		err := run(context.Background(), args, makeDeps(&out, &source, &pinged))
+		if err == nil || !strings.Contains(err.Error(), "invalid probe source") {
+			t.Fatalf("Expected probe source error, got: %v", err)
+		}
+		if pinged {
+			t.Error("Expected no pings with an invalid probe source")
+		}
+	})
+}
+
+func TestE2E_Compare(t *testing.T) {
+	dir := t.TempDir()
+	before := filepath.Join(dir, "before.json")
+	after := filepath.Join(dir, "after.json")
+	writeRun := func(path, servers string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(`{"event":"post_run","servers":[`+servers+`]}`), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeRun(before, `{"hostname":"de-ber-wg-001","country":"Germany","city":"Berlin","latency_ms":10},
+		{"hostname":"de-ber-wg-002","country":"Germany","city":"Berlin","latency_ms":12}`)
+	writeRun(after, `{"hostname":"de-ber-wg-002","country":"Germany","city":"Berlin","latency_ms":9},
+		{"hostname":"de-ber-wg-001","country":"Germany","city":"Berlin","latency_ms":25}`)
+
+	t.Run("Prints deltas without touching the network", func(t *testing.T) {
+		var out bytes.Buffer
+		deps := Dependencies{
+			ParseRelaysFile: func(context.Context, logging.LogLevel, string, func() (string, error)) (*relays.File, error) {
+				t.Error("compare should not read the relays file")
+				return nil, errors.New("unexpected")
+			},
+			ConfigPath: tempConfigPath(t),
+			Stdout:     &out,
+		}
+
+		if err := run(context.Background(), []string{"compare", before, after}, deps); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+
+		output := out.String()
+		expected := []string{"2 -> 1", "1 -> 2", "+15.00", "-3.00", "regressed", "improved", "1 regressed, 1 improved"}
+		for _, want := range expected {
+			if !strings.Contains(output, want) {
+				t.Errorf("Expected output to contain %q, got:\n%s", want, output)
+			}
+		}
+	})
+
+	t.Run("No summary", func(t *testing.T) {
+		var out bytes.Buffer
+		err := run(context.Background(), []string{"compare", before, after, "--no-summary"}, Dependencies{Stdout: &out})
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if strings.Contains(out.String(), "regressed,") {
+			t.Errorf("Expected no summary line, got:\n%s", out.String())
+		}
+	})
+
+	t.Run("Missing run file", func(t *testing.T) {
+		var out bytes.Buffer
+		missing := filepath.Join(dir, "missing.json")
+		err := run(context.Background(), []string{"compare", before, missing}, Dependencies{Stdout: &out})
+		if err == nil || !strings.Contains(err.Error(), "failed to read run file") {
+			t.Fatalf("Expected read error, got: %v", err)
+		}
+	})
+}
+
+func TestE2E_Share(t *testing.T) {
+	makeDeps := func(out *bytes.Buffer) Dependencies {
+		return Dependencies{
+			GetUserLocation: func(context.Context, logging.LogLevel) (*api.UserLocation, error) {
+				return &api.UserLocation{
+					IP:        "203.0.113.7",
+					Latitude:  51.0514,
+					Longitude: 13.7341,
+					City:      "Dresden",
+					Country:   "Germany",
+				}, nil
+			},
+			PingLocations: func(_ context.Context, locs []relays.Location, _, _ int, _ relays.IPVersion, _ logging.LogLevel) ([]relays.Location, error) {
+				for i := range locs {
+					latency := float64(10 + i)
+					locs[i].Latency = &latency
+				}
+				return locs, nil
+			},
+			ParseRelaysFile: func(_ context.Context, _ logging.LogLevel, _ string, _ func() (string, error)) (*relays.File, error) {
+				return relays.ParseRelaysFile("../../testdata/relays.json")
+			},
+			ConfigPath: tempConfigPath(t),
+			Stdout:     out,
+		}
+	}
+
+	for _, tt := range []struct {
+		name string
+		args []string
+	}{
+		{"Best server mode", []string{"--share", "markdown"}},
+		{"Table mode", []string{"-m", "250", "--share", "markdown"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := run(context.Background(), tt.args, makeDeps(&out)); err != nil {
+				t.Fatalf("Expected no error, got: %v", err)
+			}
+
+			output := out.String()
+			if !strings.HasPrefix(output, "### mullvad-compass results") {
+				t.Errorf("Expected only the shared report, got:\n%s", output)
+			}
+			if !strings.Contains(output, "Dresden, Germany (51.1, 13.7), IP redacted") {
+				t.Errorf("Expected redacted location, got:\n%s", output)
+			}
+			if strings.Contains(output, "203.0.113.7") || strings.Contains(output, "Best server") {
+				t.Errorf("Expected no user IP or regular output, got:\n%s", output)
+			}
+		})
+	}
+
+	t.Run("JSON", func(t *testing.T) {
+		var out bytes.Buffer
+		if err := run(context.Background(), []string{"-m", "250", "--share", "json"}, makeDeps(&out)); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		var report formatter.ShareReport
+		if err := json.Unmarshal(out.Bytes(), &report); err != nil {
+			t.Fatalf("Expected JSON report, got %v:\n%s", err, out.String())
+		}
+		if len(report.Servers) == 0 || report.Location.Latitude != 51.1 {
+			t.Errorf("Unexpected report: %+v", report)
+		}
+		if report.Build == nil || report.Build.Version != Version || report.Build.Platform == "" {
+			t.Errorf("Expected the build metadata, got %+v", report.Build)
+		}
+		if len(report.TimedOutPrefixes) != 0 {
+			t.Errorf("Expected no timed out prefixes, got %+v", report.TimedOutPrefixes)
+		}
+	})
+
+	t.Run("No servers within distance", func(t *testing.T) {
+		var out bytes.Buffer
+		if err := run(context.Background(), []string{"-m", "10", "--share", "json"}, makeDeps(&out)); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		var report formatter.ShareReport
+		if err := json.Unmarshal(out.Bytes(), &report); err != nil {
+			t.Fatalf("Expected only the JSON report, got %v:\n%s", err, out.String())
+		}
+		if len(report.Servers) != 0 || report.Summary.Count != 0 {
+			t.Errorf("Expected no servers, got %+v", report)
+		}
+		if report.Counts == nil || report.Counts.Shown != 0 || report.Counts.BeyondDistance != report.Counts.Matching {
+			t.Errorf("Expected every matching server beyond the distance, got %+v", report.Counts)
+		}
+	})
+
+	t.Run("Timed out prefixes", func(t *testing.T) {
+		var out bytes.Buffer
+		deps := makeDeps(&out)
+		pingLocations := deps.PingLocations
+		deps.PingLocations = func(ctx context.Context, locs []relays.Location, timeout, workers int, ipVersion relays.IPVersion, logLevel logging.LogLevel) ([]relays.Location, error) {
+			locs, err := pingLocations(ctx, locs, timeout, workers, ipVersion, logLevel)
+			for i := range locs {
+				if strings.HasPrefix(locs[i].IPv4Address, "178.249.209.") {
+					locs[i].Latency = nil
+				}
+			}
+			return locs, err
+		}
+		if err := run(context.Background(), []string{"-m", "250", "--share", "json"}, deps); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		var report formatter.ShareReport
+		if err := json.Unmarshal(out.Bytes(), &report); err != nil {
+			t.Fatalf("Expected JSON report, got %v:\n%s", err, out.String())
+		}
+		want := []ping.PrefixTimeout{{Prefix: "178.249.209.0/24", Servers: 2}}
+		if !slices.Equal(report.TimedOutPrefixes, want) {
+			t.Errorf("Expected timed out prefixes %+v, got %+v", want, report.TimedOutPrefixes)
+		}
+		isBlock := func(w warnings.Warning) bool { return w.Kind == warnings.NetworkBlock }
+		if !slices.ContainsFunc(report.Warnings, isBlock) {
+			t.Errorf("Expected a network block warning, got %+v", report.Warnings)
+		}
+	})
+}
+
+func TestE2E_Runs(t *testing.T) {
+	clk := clock.NewFake(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	ctx := clock.WithClock(context.Background(), clk)
+	var out bytes.Buffer
+	var calls int
+	deps := Dependencies{
+		GetUserLocation: func(context.Context, logging.LogLevel) (*api.UserLocation, error) {
+			return &api.UserLocation{Latitude: 51.0514, Longitude: 13.7341}, nil // Dresden
+		},
+		PingLocations: func(_ context.Context, locs []relays.Location, _, _ int, _ relays.IPVersion, _ logging.LogLevel) ([]relays.Location, error) {
+			calls++
+			for i := range locs {
+				latency := 20.0 + float64(i)
+				// A congestion spike hits the otherwise fastest server in one run
+				if locs[i].Hostname == "cz-prg-wg-101" {
+					latency = 5.0
+					if calls == 2 {
+						latency = 500.0
+					}
+				}
+				locs[i].Latency = &latency
+			}
+			return locs, nil
+		},
+		ParseRelaysFile: func(_ context.Context, _ logging.LogLevel, _ string, _ func() (string, error)) (*relays.File, error) {
+			return relays.ParseRelaysFile("../../testdata/relays.json")
+		},
+		ConfigPath: tempConfigPath(t),
+		Stdout:     &out,
+	}
+
+	if err := run(ctx, []string{"-m", "250", "--runs", "3"}, deps); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if calls != 3 {
+		t.Errorf("Expected 3 ping runs, got %d", calls)
+	}
+	if waits := clk.Waits(); !slices.Equal(waits, []time.Duration{runGap, runGap}) {
+		t.Errorf("Expected a pause of %v between the runs, got %v", runGap, waits)
+	}
+	output := out.String()
+	if !strings.Contains(output, "Ranked by the median latency of 3 runs") {
+		t.Errorf("Expected the runs note, got:\n%s", output)
+	}
+	lines := strings.Split(output, "\n")
+	first := slices.IndexFunc(lines, func(line string) bool { return strings.Contains(line, "-wg-") })
+	if first < 0 || !strings.Contains(lines[first], "cz-prg-wg-101") || !strings.Contains(lines[first], "5.00") {
+		t.Errorf("Expected the spiking server ranked first by its median, got:\n%s", output)
+	}
+
+	out.Reset()
+	calls = 0
+	if err := run(ctx, []string{"-m", "250", "--runs", "2", "--share", "json"}, deps); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	var report formatter.ShareReport
+	if err := json.Unmarshal(out.Bytes(), &report); err != nil {
+		t.Fatalf("Expected JSON report, got %v:\n%s", err, out.String())
+	}
+	if report.Runs != 2 || report.Aggregation != "median" {
+		t.Errorf("Expected runs 2 and median aggregation, got %d and %q", report.Runs, report.Aggregation)
+	}
+}
+
+func TestE2E_SummaryOnly(t *testing.T) {
+	var out bytes.Buffer
+	deps := Dependencies{
+		GetUserLocation: func(context.Context, logging.LogLevel) (*api.UserLocation, error) {
+			return &api.UserLocation{Latitude: 51.0514, Longitude: 13.7341}, nil // Dresden
+		},
+		PingLocations: func(_ context.Context, locs []relays.Location, _, _ int, _ relays.IPVersion, _ logging.LogLevel) ([]relays.Location, error) {
+			for i := range locs {
+				if i%4 == 3 {
+					continue // Every fourth server times out
+				}
+				latency := 10.0 + float64(i)
+				locs[i].Latency = &latency
+			}
+			return locs, nil
+		},
+		ParseRelaysFile: func(_ context.Context, _ logging.LogLevel, _ string, _ func() (string, error)) (*relays.File, error) {
+			return relays.ParseRelaysFile("../../testdata/relays.json")
+		},
+		ConfigPath: tempConfigPath(t),
+		Stdout:     &out,
+	}
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"Best Server Mode", []string{"--summary-only"}, `reachable=\d+ total=\d+`},
+		{"Table Mode", []string{"-m", "250", "--summary-only"}, `reachable=9 total=12`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out.Reset()
+			if err := run(context.Background(), tt.args, deps); err != nil {
+				t.Fatalf("Expected no error, got: %v", err)
+			}
+			summaryLine := regexp.MustCompile(`^best=[a-z]{2}-[a-z]{3}-wg-\d{3} latency_ms=10\.00 ` + tt.want +
+				` duration_ms=\d+\n$`)
+			if !summaryLine.MatchString(out.String()) {
+				t.Errorf("Expected only the summary line, got:\n%s", out.String())
+			}
+		})
+	}
+
+	t.Run("No servers within distance", func(t *testing.T) {
+		out.Reset()
+		if err := run(context.Background(), []string{"-m", "10", "--summary-only"}, deps); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		summaryLine := regexp.MustCompile(`^best=- latency_ms=- reachable=0 total=0 duration_ms=\d+\n$`)
+		if !summaryLine.MatchString(out.String()) {
+			t.Errorf("Expected only the summary line, got:\n%s", out.String())
+		}
+	})
+}
+
+func TestE2E_Preset(t *testing.T) {
+	var out bytes.Buffer
+	var pinged []relays.Location
+	deps := Dependencies{
+		GetUserLocation: func(context.Context, logging.LogLevel) (*api.UserLocation, error) {
+			return &api.UserLocation{Latitude: 51.0514, Longitude: 13.7341}, nil // Dresden
+		},
+		PingLocations: func(_ context.Context, locs []relays.Location, _, _ int, _ relays.IPVersion, _ logging.LogLevel) ([]relays.Location, error) {
+			pinged = slices.Clone(locs)
+			for i := range locs {
+				latency := 10.0 + float64(i)
+				locs[i].Latency = &latency
+			}
+			return locs, nil
+		},
+		ParseRelaysFile: func(_ context.Context, _ logging.LogLevel, _ string, _ func() (string, error)) (*relays.File, error) {
+			return relays.ParseRelaysFile("../../testdata/relays.json")
+		},
+		ConfigPath: tempConfigPath(t),
+		Stdout:     &out,
+	}
+
+	// Prague has a single relay offering QUIC, Berlin's relays all offer QUIC and Shadowsocks
+	if err := run(context.Background(), []string{"-m", "250", "--preset", "censorship"}, deps); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	var hostnames []string
+	for _, loc := range pinged {
+		hostnames = append(hostnames, loc.Hostname)
+	}
+	if len(hostnames) != 9 || !slices.Contains(hostnames, "cz-prg-wg-102") ||
+		slices.Contains(hostnames, "cz-prg-wg-101") {
+		t.Errorf("Expected cz-prg-wg-102 and the 8 Berlin relays to be pinged, got %v", hostnames)
+	}
+
+	pinged = nil
+	if err := run(context.Background(), []string{"--preset", "privacy"}, deps); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if len(pinged) == 0 {
+		t.Fatal("Expected relays to be pinged")
+	}
+	for _, loc := range pinged {
+		if !loc.IsMullvadOwned || !loc.Features.Has(relays.FeatureDAITA) {
+			t.Errorf("Expected only owned DAITA relays to be pinged, got %s", loc.Hostname)
+		}
+	}
+}
+
+func TestE2E_VerifyCoordinates(t *testing.T) {
+	var out bytes.Buffer
+	deps := Dependencies{
+		GetUserLocation: func(context.Context, logging.LogLevel) (*api.UserLocation, error) {
+			t.Error("GetUserLocation should not be called with --verify-coordinates")
+			return nil, errors.New("unexpected call")
+		},
+		PingLocations: func(_ context.Context, locs []relays.Location, _, _ int, _ relays.IPVersion, _ logging.LogLevel) ([]relays.Location, error) {
+			t.Error("PingLocations should not be called with --verify-coordinates")
+			return locs, nil
+		},
+		ParseRelaysFile: func(_ context.Context, _ logging.LogLevel, _ string, _ func() (string, error)) (*relays.File, error) {
+			file, err := relays.ParseRelaysFile("../../testdata/relays.json")
+			if err != nil {
+				return nil, err
+			}
+			for i, relay := range file.WireGuard.Relays {
+				if relay.Hostname == "de-ber-wg-002" {
+					file.WireGuard.Relays[i].Location = "de-fra"
+				}
+			}
+			return file, nil
+		},
+		ConfigPath: tempConfigPath(t),
+		Stdout:     &out,
+	}
+
+	if err := run(context.Background(), []string{"--verify-coordinates"}, deps); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	want := "de-ber-wg-002 is listed at de-fra (Frankfurt), 424 km from de-ber (Berlin)\n" +
+		"\n" +
+		"1 relay more than 100 km from the city named by the hostname\n"
+	if out.String() != want {
+		t.Errorf("Unexpected output:\n got: %q\nwant: %q", out.String(), want)
+	}
+}
+
+func TestE2E_VerifyOutliers(t *testing.T) {
+	var out bytes.Buffer
+	var calls int
+	var reprobed []string
+	deps := Dependencies{
+		GetUserLocation: func(context.Context, logging.LogLevel) (*api.UserLocation, error) {
+			return &api.UserLocation{Latitude: 51.0514, Longitude: 13.7341}, nil // Dresden
+		},
+		PingLocations: func(_ context.Context, locs []relays.Location, _, _ int, _ relays.IPVersion, _ logging.LogLevel) ([]relays.Location, error) {
+			calls++
+			for i := range locs {
+				if calls > 1 {
+					reprobed = append(reprobed, locs[i].Hostname)
+				}
+				latency := 20.0
+				if locs[i].Hostname == "de-ber-wg-001" {
+					// The first reply is lost, the second shows the relay is the fastest
+					if calls == 1 {
+						locs[i].Latency = nil
+						continue
+					}
+					latency = 9.0
+				}
+				locs[i].Latency = &latency
+			}
+			return locs, nil
+		},
+		ParseRelaysFile: func(_ context.Context, _ logging.LogLevel, _ string, _ func() (string, error)) (*relays.File, error) {
+			return relays.ParseRelaysFile("../../testdata/relays.json")
+		},
+		ConfigPath: tempConfigPath(t),
+		Stdout:     &out,
+	}
+
+	if err := run(context.Background(), []string{"-m", "250", "--verify-outliers"}, deps); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if !slices.Equal(reprobed, []string{"de-ber-wg-001"}) {
+		t.Errorf("Expected only the outlier to be pinged again, got %q", reprobed)
+	}
+	lines := strings.Split(out.String(), "\n")
+	first := slices.IndexFunc(lines, func(line string) bool { return strings.Contains(line, "-wg-") })
+	if first < 0 || !strings.Contains(lines[first], "de-ber-wg-001") || !strings.Contains(lines[first], "9.00") {
+		t.Errorf("Expected the re-probed server ranked first, got:\n%s", out.String())
+	}
+}
+
+func TestE2E_VersionVerbose(t *testing.T) {
+	var out bytes.Buffer
+	if err := run(context.Background(), []string{"--version"}, Dependencies{Stdout: &out}); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if out.String() != "mullvad-compass "+Version+"\n" {
+		t.Errorf("Expected only the version, got:\n%s", out.String())
+	}
+
+	out.Reset()
+	if err := run(context.Background(), []string{"--version", "--verbose"}, Dependencies{Stdout: &out}); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	for _, want := range []string{"mullvad-compass " + Version, "Go:       go", "Platform: " + runtime.GOOS} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("Expected %q in the output, got:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestE2E_CountryFilterAnnotatesDistance(t *testing.T) {
+	relaysPath := filepath.Join(t.TempDir(), "relays.json")
+	antipodeRelays := `{
+		"etag": "test",
+		"locations": {
+			"aq-spo": {"city": "South Pole", "country": "Antarctica", "latitude": -90.0, "longitude": 0.0}
+		},
+		"wireguard": {
+			"relays": [
+				{
+					"hostname": "aq-spo-wg-001",
+					"active": true,
+					"owned": true,
+					"location": "aq-spo",
+					"provider": "test",
+					"ipv4_addr_in": "192.0.2.1",
+					"ipv6_addr_in": "2001:db8::1",
+					"weight": 1,
+					"include_in_country": true,
+					"public_key": "test",
+					"daita": false,
+					"shadowsocks_extra_addr_in": [],
+					"features": {"daita": null, "quic": null, "lwo": null}
+				}
+			]
+		},
+		"bridge": {"relays": []}
+	}`
+	if err := os.WriteFile(relaysPath, []byte(antipodeRelays), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var output bytes.Buffer
+	deps := Dependencies{
+		GetUserLocation: func(context.Context, logging.LogLevel) (*api.UserLocation, error) {
+			return &api.UserLocation{Latitude: 90.0, Longitude: 0.0}, nil // North Pole
+		},
+		PingLocations: func(_ context.Context, locs []relays.Location, _, _ int, _ relays.IPVersion, _ logging.LogLevel) ([]relays.Location, error) {
+			for i := range locs {
+				latency := 250.0
+				locs[i].Latency = &latency
+			}
+			return locs, nil
+		},
+		ParseRelaysFile: func(_ context.Context, _ logging.LogLevel, _ string, _ func() (string, error)) (*relays.File, error) {
+			return relays.ParseRelaysFile(relaysPath)
+		},
+		ConfigPath: tempConfigPath(t),
+		Stdout:     &output,
+	}
+
+	// The relay is farther than any --max-distance; a country filter alone must not drop it
+	if err := run(context.Background(), []string{"-c", "aq"}, deps); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	result := output.String()
+	if !strings.Contains(result, "aq-spo-wg-001") || !strings.Contains(result, "20015") {
+		t.Errorf("Expected the relay with its distance, got:\n%s", result)
+	}
+}
+
+func TestE2E_LatencyUnder(t *testing.T) {
+	makeDeps := func(out *bytes.Buffer) Dependencies {
+		return Dependencies{
+			GetUserLocation: func(context.Context, logging.LogLevel) (*api.UserLocation, error) {
+				return &api.UserLocation{Latitude: 51.0514, Longitude: 13.7341}, nil // Dresden
+			},
+			PingLocations: func(_ context.Context, locs []relays.Location, _, _ int, _ relays.IPVersion, _ logging.LogLevel) ([]relays.Location, error) {
+				// Every third server times out, the others respond in 10, 20, 30, ... ms
+				for i := range locs {
+					if i%3 == 2 {
+						continue
+					}
+					latency := float64(10 * (i + 1))
+					locs[i].Latency = &latency
+				}
+				return locs, nil
+			},
+			ParseRelaysFile: func(_ context.Context, _ logging.LogLevel, _ string, _ func() (string, error)) (*relays.File, error) {
+				return relays.ParseRelaysFile("../../testdata/relays.json")
+			},
+			ConfigPath: tempConfigPath(t),
+			Stdout:     out,
+		}
+	}
+
+	t.Run("Drops slow and timed out rows", func(t *testing.T) {
+		var out bytes.Buffer
+		args := []string{"--latency-under", "50", "-m", "250"}
+		if err := run(context.Background(), args, makeDeps(&out)); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+
+		table, summary, _ := strings.Cut(out.String(), "\n\n")
+		rows := strings.Split(strings.TrimSpace(table), "\n")[2:]
+		if len(rows) != 3 {
+			t.Fatalf("Expected 3 rows under 50 ms (10, 20, 40), got %d:\n%s", len(rows), table)
+		}
+		if strings.Contains(table, "timeout") || strings.Contains(table, "50.00") {
+			t.Errorf("Expected no timed out or 50 ms rows, got:\n%s", table)
+		}
+		// The summary still describes every pinged server
+		if !strings.Contains(summary, "12 servers") {
+			t.Errorf("Expected summary of all pinged servers, got: %q", summary)
+		}
+	})
+
+	t.Run("Nothing under the threshold", func(t *testing.T) {
+		var out bytes.Buffer
+		if err := run(context.Background(), []string{"--latency-under", "5", "-m", "250"}, makeDeps(&out)); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if !strings.HasPrefix(out.String(), "No servers responded in less than 5 ms\n") {
+			t.Errorf("Expected no servers notice, got:\n%s", out.String())
+		}
+	})
+}
+
+// tempConfigPath returns a ConfigPath dependency resolving to files in an empty temporary directory
+func tempConfigPath(t *testing.T) func(string) (string, error) {
+	dir := t.TempDir()
+	return func(name string) (string, error) { return filepath.Join(dir, name), nil }
+}
+
+func TestE2E_Favorites(t *testing.T) {
+	configPath := tempConfigPath(t)
+	makeDeps := func(out *bytes.Buffer) Dependencies {
+		return Dependencies{
+			GetUserLocation: func(context.Context, logging.LogLevel) (*api.UserLocation, error) {
+				return &api.UserLocation{Latitude: 51.0514, Longitude: 13.7341}, nil // Dresden
+			},
+			PingLocations: func(_ context.Context, locs []relays.Location, _, _ int, _ relays.IPVersion, _ logging.LogLevel) ([]relays.Location, error) {
+				for i := range locs {
+					latency := float64(10 * (i + 1))
+					locs[i].Latency = &latency
+				}
+				return locs, nil
+			},
+			ParseRelaysFile: func(_ context.Context, _ logging.LogLevel, _ string, _ func() (string, error)) (*relays.File, error) {
+				return relays.ParseRelaysFile("../../testdata/relays.json")
+			},
+			ConfigPath: configPath,
+			Stdout:     out,
+		}
+	}
+
+	runArgs := func(t *testing.T, args ...string) string {
+		t.Helper()
+		var out bytes.Buffer
+		if err := run(context.Background(), args, makeDeps(&out)); err != nil {
+			t.Fatalf("run(%v) failed: %v", args, err)
+		}
+		return out.String()
+	}
+
+	t.Run("Unknown relay is rejected", func(t *testing.T) {
+		var out bytes.Buffer
+		err := run(context.Background(), []string{"favorite", "add", "de-ber-wg-999"}, makeDeps(&out))
+		if err == nil || !strings.Contains(err.Error(), "unknown relay: de-ber-wg-999") {
+			t.Errorf("Expected unknown relay error, got %v", err)
+		}
+	})
+
+	t.Run("Inactive relay is accepted", func(t *testing.T) {
+		runArgs(t, "favorite", "add", "de-fra-wg-304")
+		runArgs(t, "favorite", "remove", "de-fra-wg-304")
+	})
+
+	t.Run("No favorites yet", func(t *testing.T) {
+		var out bytes.Buffer
+		err := run(context.Background(), []string{"--favorites-only"}, makeDeps(&out))
+		if err == nil || !strings.Contains(err.Error(), "no favorite relays found") {
+			t.Errorf("Expected no favorites error, got %v", err)
+		}
+	})
+
+	t.Run("Add, list and remove", func(t *testing.T) {
+		runArgs(t, "favorite", "add", "de-ber-wg-001", "cz-prg-wg-201")
+		runArgs(t, "favorite", "add", "de-ber-wg-001")
+		if got := runArgs(t, "favorite", "list"); got != "de-ber-wg-001\ncz-prg-wg-201\n" {
+			t.Errorf("Expected two favorites, got %q", got)
+		}
+
+		runArgs(t, "favorite", "remove", "cz-prg-wg-201")
+		if got := runArgs(t, "favorite", "list"); got != "de-ber-wg-001\n" {
+			t.Errorf("Expected one favorite, got %q", got)
+		}
+	})
+
+	t.Run("Marked in the table", func(t *testing.T) {
+		table, _, _ := strings.Cut(runArgs(t, "-m", "250"), "\n\n")
+		rows := strings.Split(table, "\n")[2:]
+		if len(rows) != 12 {
+			t.Fatalf("Expected 12 rows, got %d:\n%s", len(rows), table)
+		}
+		for _, row := range rows {
+			if strings.HasPrefix(row, "★") != strings.Contains(row, "de-ber-wg-001") {
+				t.Errorf("Expected only de-ber-wg-001 to be marked, got row %q", row)
+			}
+		}
+	})
+
+	t.Run("Favorites only", func(t *testing.T) {
+		table, _, _ := strings.Cut(runArgs(t, "--favorites-only"), "\n\n")
+		rows := strings.Split(table, "\n")[2:]
+		if len(rows) != 1 || !strings.HasPrefix(rows[0], "★") || !strings.Contains(rows[0], "de-ber-wg-001") {
+			t.Errorf("Expected only the favorite, got:\n%s", table)
+		}
+	})
+}
+
+func TestE2E_Ignore(t *testing.T) {
+	configPath := tempConfigPath(t)
+	makeDeps := func(out *bytes.Buffer, timedOut ...string) Dependencies {
+		return Dependencies{
+			GetUserLocation: func(context.Context, logging.LogLevel) (*api.UserLocation, error) {
+				return &api.UserLocation{Latitude: 51.0514, Longitude: 13.7341}, nil // Dresden
+			},
+			PingLocations: func(_ context.Context, locs []relays.Location, _, _ int, _ relays.IPVersion, _ logging.LogLevel) ([]relays.Location, error) {
+				for i := range locs {
+					if slices.Contains(timedOut, locs[i].Hostname) {
+						continue
+					}
+					latency := float64(10 * (i + 1))
+					locs[i].Latency = &latency
+				}
+				return locs, nil
+			},
+			ParseRelaysFile: func(_ context.Context, _ logging.LogLevel, _ string, _ func() (string, error)) (*relays.File, error) {
+				return relays.ParseRelaysFile("../../testdata/relays.json")
+			},
+			ConfigPath: configPath,
+			Stdout:     out,
+		}
+	}
+
+	runArgs := func(t *testing.T, args []string, timedOut ...string) string {
+		t.Helper()
+		var out bytes.Buffer
+		if err := run(context.Background(), args, makeDeps(&out, timedOut...)); err != nil {
+			t.Fatalf("run(%v) failed: %v", args, err)
+		}
+		return out.String()
+	}
+
+	t.Run("Timeouts in consecutive runs are suggested", func(t *testing.T) {
+		args := []string{"-m", "250", "--no-summary"}
+		for range ignoreSuggestionRuns - 1 {
+			if out := runArgs(t, args, "cz-prg-wg-101"); strings.Contains(out, "ignore add") {
+				t.Fatalf("Expected no suggestion before %d runs, got:\n%s", ignoreSuggestionRuns, out)
+			}
+		}
+		out := runArgs(t, args, "cz-prg-wg-101")
+		if !strings.Contains(out, "cz-prg-wg-101 timed out in each of the last 3 runs") ||
+			!strings.HasSuffix(out, "    mullvad-compass ignore add cz-prg-wg-101\n") {
+			t.Errorf("Expected an ignore suggestion, got:\n%s", out)
+		}
+	})
+
+	t.Run("Ignored relays are excluded", func(t *testing.T) {
+		runArgs(t, []string{"ignore", "add", "cz-prg-wg-101"})
+		if got := runArgs(t, []string{"ignore", "list"}); got != "cz-prg-wg-101\n" {
+			t.Errorf("Expected one ignored relay, got %q", got)
+		}
+
+		out := runArgs(t, []string{"-m", "250", "--no-summary"})
+		if strings.Contains(out, "cz-prg-wg-101") {
+			t.Errorf("Expected cz-prg-wg-101 to be excluded, got:\n%s", out)
+		}
+
+		out = runArgs(t, []string{"-m", "250", "--no-summary", "--include-ignored"})
+		if !strings.Contains(out, "cz-prg-wg-101") {
+			t.Errorf("Expected --include-ignored to search cz-prg-wg-101, got:\n%s", out)
+		}
+	})
+
+	t.Run("Remove", func(t *testing.T) {
+		var out bytes.Buffer
+		err := run(context.Background(), []string{"ignore", "remove", "cz-prg-wg-102"}, makeDeps(&out))
+		if err == nil || !strings.Contains(err.Error(), "cz-prg-wg-102 is not ignored") {
+			t.Errorf("Expected not ignored error, got %v", err)
+		}
+
+		runArgs(t, []string{"ignore", "remove", "cz-prg-wg-101"})
+		if got := runArgs(t, []string{"ignore", "list"}); got != "" {
+			t.Errorf("Expected no ignored relays, got %q", got)
+		}
+	})
+}
+
+func TestE2E_Timings(t *testing.T) {
+	makeDeps := func(out *bytes.Buffer) Dependencies {
+		return Dependencies{
+			GetUserLocation: func(context.Context, logging.LogLevel) (*api.UserLocation, error) {
+				return &api.UserLocation{Latitude: 51.0514, Longitude: 13.7341}, nil // Dresden
+			},
+			PingLocations: func(_ context.Context, locs []relays.Location, _, _ int, _ relays.IPVersion, _ logging.LogLevel) ([]relays.Location, error) {
+				for i := range locs {
+					latency := float64(10 * (i + 1))
+					locs[i].Latency = &latency
+				}
+				return locs, nil
+			},
+			ParseRelaysFile: func(_ context.Context, _ logging.LogLevel, _ string, _ func() (string, error)) (*relays.File, error) {
+				return relays.ParseRelaysFile("../../testdata/relays.json")
+			},
+			ConfigPath: tempConfigPath(t),
+			Stdout:     out,
+		}
+	}
+
+	phases := []string{"parse", "filter", "geoip", "ping", "sort", "format", "total"}
+
+	for _, args := range [][]string{{"--timings"}, {"-m", "250", "--timings"}} {
+		t.Run(strings.Join(args, " "), func(t *testing.T) {
+			var out bytes.Buffer
+			if err := run(context.Background(), args, makeDeps(&out)); err != nil {
+				t.Fatalf("Expected no error, got: %v", err)
+			}
+
+			_, table, ok := strings.Cut(out.String(), "\nPhase   ")
+			if !ok {
+				t.Fatalf("Expected a timings table at the end, got:\n%s", out.String())
+			}
+			for _, phase := range phases {
+				if !strings.Contains(table, "\n"+phase+" ") {
+					t.Errorf("Expected %s phase in timings table, got:\n%s", phase, table)
+				}
+			}
+		})
+	}
+
+	t.Run("No table without the flag", func(t *testing.T) {
+		var out bytes.Buffer
+		if err := run(context.Background(), []string{"-m", "250"}, makeDeps(&out)); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if strings.Contains(out.String(), "Phase") {
+			t.Errorf("Expected no timings table, got:\n%s", out.String())
+		}
+	})
+
+	t.Run("Embedded in JSON report", func(t *testing.T) {
+		var out bytes.Buffer
+		args := []string{"-m", "250", "--share", "json", "--timings"}
+		if err := run(context.Background(), args, makeDeps(&out)); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+
+		var report formatter.ShareReport
+		if err := json.Unmarshal(out.Bytes(), &report); err != nil {
+			t.Fatalf("Expected only the JSON report on stdout, got %v:\n%s", err, out.String())
+		}
+		if report.Timings == nil || len(report.Timings.Phases) == 0 {
+			t.Errorf("Expected timings in the report, got %+v", report.Timings)
+		}
+	})
+}
+
+func TestE2E_Stability(t *testing.T) {
+	var out bytes.Buffer
+	var calls int
+	var stabilityCandidates []string
+	deps := Dependencies{
+		GetUserLocation: func(context.Context, logging.LogLevel) (*api.UserLocation, error) {
+			return &api.UserLocation{Latitude: 51.0514, Longitude: 13.7341}, nil // Dresden
+		},
+		PingLocations: func(_ context.Context, locs []relays.Location, _, _ int, _ relays.IPVersion, _ logging.LogLevel) ([]relays.Location, error) {
+			calls++
+			for i := range locs {
+				latency := float64(10 * (i + 1))
+				locs[i].Latency = &latency
+			}
+			if calls > 1 {
+				// The best server of the first run drops every stability ping
+				for _, loc := range locs {
+					stabilityCandidates = append(stabilityCandidates, loc.Hostname)
+				}
+				locs[0].Latency = nil
+			}
+			return locs, nil
+		},
+		ParseRelaysFile: func(_ context.Context, _ logging.LogLevel, _ string, _ func() (string, error)) (*relays.File, error) {
+			return relays.ParseRelaysFile("../../testdata/relays.json")
+		},
+		ConfigPath: tempConfigPath(t),
+		Stdout:     &out,
+	}
+
+	if err := run(context.Background(), []string{"-m", "250", "--stability", "1"}, deps); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	if calls != 2 {
+		t.Errorf("Expected one regular and one stability round of pings, got %d", calls)
+	}
+	if len(stabilityCandidates) != 10 {
+		t.Fatalf("Expected the 10 best servers to be pinged again, got %v", stabilityCandidates)
+	}
+
+	output := out.String()
+	lines := strings.Split(output, "\n")
+	if !strings.Contains(lines[0], "Mean (ms)") || !strings.Contains(lines[0], "Loss") {
+		t.Fatalf("Expected a stability table, got:\n%s", output)
+	}
+	if last := lines[11]; !strings.Contains(last, stabilityCandidates[0]) || !strings.Contains(last, "100%") {
+		t.Errorf("Expected %s to be ranked last with 100%% loss, got:\n%s", stabilityCandidates[0], output)
+	}
+	if !strings.Contains(output, "Ranked the 10 best servers by loss and latency deviation over a 1 second window") {
+		t.Errorf("Expected a stability note, got:\n%s", output)
+	}
+}
+
+func TestE2E_AppLocation(t *testing.T) {
+	makeDeps := func(out *bytes.Buffer, appErr error, apiCalls *int) Dependencies {
+		return Dependencies{
+			GetUserLocation: func(context.Context, logging.LogLevel) (*api.UserLocation, error) {
+				*apiCalls++
+				return &api.UserLocation{Latitude: 59.33, Longitude: 18.07}, nil // Stockholm
+			},
+			GetAppLocation: func(context.Context, logging.LogLevel) (*api.UserLocation, error) {
+				if appErr != nil {
+					return nil, appErr
+				}
+				return &api.UserLocation{Latitude: 51.0514, Longitude: 13.7341}, nil // Dresden
+			},
+			PingLocations: func(_ context.Context, locs []relays.Location, _, _ int, _ relays.IPVersion, _ logging.LogLevel) ([]relays.Location, error) {
+				for i := range locs {
+					latency := float64(10 * (i + 1))
+					locs[i].Latency = &latency
+				}
+				return locs, nil
+			},
+			ParseRelaysFile: func(_ context.Context, _ logging.LogLevel, _ string, _ func() (string, error)) (*relays.File, error) {
+				return relays.ParseRelaysFile("../../testdata/relays.json")
+			},
+			ConfigPath: tempConfigPath(t),
+			Stdout:     out,
+		}
+	}
+
+	t.Run("Cached location skips the API", func(t *testing.T) {
+		var out bytes.Buffer
+		var apiCalls int
+		args := []string{"--app-location", "-m", "250"}
+		if err := run(context.Background(), args, makeDeps(&out, nil, &apiCalls)); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if apiCalls != 0 {
+			t.Errorf("Expected no API lookup, got %d", apiCalls)
+		}
+		if !strings.Contains(out.String(), "Prague") || strings.Contains(out.String(), "Stockholm") {
+			t.Errorf("Expected servers near Dresden, got:\n%s", out.String())
+		}
+	})
+
+	t.Run("Falls back to the API", func(t *testing.T) {
+		var logBuf bytes.Buffer
+		log.SetOutput(&logBuf)
+		defer log.SetOutput(os.Stderr)
+
+		var out bytes.Buffer
+		var apiCalls int
+		deps := makeDeps(&out, fmt.Errorf("failed to query the Mullvad app"), &apiCalls)
+		args := []string{"--app-location", "-m", "250", "-l", "warning"}
+		if err := run(context.Background(), args, deps); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if apiCalls != 1 {
+			t.Errorf("Expected one API lookup, got %d", apiCalls)
+		}
+		if !strings.Contains(out.String(), "Stockholm") {
+			t.Errorf("Expected servers near Stockholm, got:\n%s", out.String())
+		}
+		if !strings.Contains(logBuf.String(), "asking the Mullvad API instead") {
+			t.Errorf("Expected a fallback warning, got: %s", logBuf.String())
+		}
+	})
+}
+
+func TestE2E_Offline(t *testing.T) {
+	t.Run("Location comes from the app only", func(t *testing.T) {
+		var out bytes.Buffer
+		var apiCalls int
+		deps := Dependencies{
+			GetUserLocation: func(context.Context, logging.LogLevel) (*api.UserLocation, error) {
+				apiCalls++
+				return &api.UserLocation{Latitude: 59.33, Longitude: 18.07}, nil
+			},
+			GetAppLocation: func(context.Context, logging.LogLevel) (*api.UserLocation, error) {
+				return nil, fmt.Errorf("failed to query the Mullvad app")
+			},
+			ParseRelaysFile: func(_ context.Context, _ logging.LogLevel, _ string, _ func() (string, error)) (*relays.File, error) {
+				return relays.ParseRelaysFile("../../testdata/relays.json")
+			},
+			ConfigPath: tempConfigPath(t),
+			Stdout:     &out,
+		}
+		err := run(context.Background(), []string{"--offline", "-m", "250"}, deps)
+		if err == nil || !strings.Contains(err.Error(), "which --offline requires") {
+			t.Errorf("Expected the app location to be required, got: %v", err)
+		}
+		if apiCalls != 0 {
+			t.Errorf("Expected no API lookup, got %d", apiCalls)
+		}
+	})
+
+	t.Run("API requests are refused", func(t *testing.T) {
+		netguard.Install()
+		ctx := netguard.WithOffline(context.Background())
+		if _, err := makeGetUserLocation("dev")(ctx, logging.LogLevelError); !errors.Is(err, errs.ErrOffline) {
+			t.Errorf("Expected an offline error, got: %v", err)
+		}
+	})
+}
+
+func TestE2E_IPVersionAuto(t *testing.T) {
+	errUnreachable := errors.New("network is unreachable")
+	makeDeps := func(out *bytes.Buffer, v4, v6 float64, routeErr error, versions *[]relays.IPVersion) Dependencies {
+		return Dependencies{
+			GetUserLocation: func(context.Context, logging.LogLevel) (*api.UserLocation, error) {
+				return &api.UserLocation{Latitude: 51.0514, Longitude: 13.7341}, nil // Dresden
+			},
+			PingLocations: func(_ context.Context, locs []relays.Location, _, _ int, ipVersion relays.IPVersion, _ logging.LogLevel) ([]relays.Location, error) {
+				*versions = append(*versions, ipVersion)
+				for i := range locs {
+					latency := v4 + float64(i)
+					if ipVersion.IsIPv6() {
+						latency = v6 + float64(i)
+					}
+					locs[i].Latency = &latency
+				}
+				return locs, nil
+			},
+			ParseRelaysFile: func(_ context.Context, _ logging.LogLevel, _ string, _ func() (string, error)) (*relays.File, error) {
+				return relays.ParseRelaysFile("../../testdata/relays.json")
+			},
+			CheckIPv6Route: func(string) error {
+				return routeErr
+			},
+			ConfigPath: tempConfigPath(t),
+			Stdout:     out,
+		}
+	}
+
+	tests := []struct {
+		name         string
+		v4, v6       float64
+		routeErr     error
+		wantVersions []relays.IPVersion
+		wantIP       string
+	}{
+		{"IPv6 is faster", 20, 10, nil, []relays.IPVersion{relays.IPv4, relays.IPv6, relays.IPv6}, "2a03:"},
+		{"IPv4 is faster", 10, 20, nil, []relays.IPVersion{relays.IPv4, relays.IPv6, relays.IPv4}, "178.249.209.162"},
+		{"A tie keeps IPv4", 10, 10, nil, []relays.IPVersion{relays.IPv4, relays.IPv6, relays.IPv4}, "178.249.209.162"},
+		{"No IPv6 route", 20, 10, errUnreachable, []relays.IPVersion{relays.IPv4}, "178.249.209.162"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			var versions []relays.IPVersion
+			deps := makeDeps(&out, tt.v4, tt.v6, tt.routeErr, &versions)
+			if err := run(context.Background(), []string{"--ip-version", "auto", "-m", "250"}, deps); err != nil {
+				t.Fatalf("Expected no error, got: %v", err)
+			}
+			if !slices.Equal(versions, tt.wantVersions) {
+				t.Errorf("Expected pings over %v, got %v", tt.wantVersions, versions)
+			}
+			if !strings.Contains(out.String(), tt.wantIP) {
+				t.Errorf("Expected addresses like %s in the results, got:\n%s", tt.wantIP, out.String())
+			}
+		})
+	}
+}
+
+func TestE2E_BestIn(t *testing.T) {
+	makeDeps := func(out *bytes.Buffer, pinged *[]string) Dependencies {
+		return Dependencies{
+			GetUserLocation: func(context.Context, logging.LogLevel) (*api.UserLocation, error) {
+				return &api.UserLocation{Latitude: 51.0514, Longitude: 13.7341}, nil // Dresden
+			},
+			PingLocations: func(_ context.Context, locs []relays.Location, _, _ int, _ relays.IPVersion, _ logging.LogLevel) ([]relays.Location, error) {
+				for i := range locs {
+					*pinged = append(*pinged, locs[i].Hostname)
+					latency := 100.0
+					if locs[i].Hostname == "se-got-wg-001" {
+						latency = 20.0
+					}
+					locs[i].Latency = &latency
+				}
+				return locs, nil
+			},
+			ParseRelaysFile: func(_ context.Context, _ logging.LogLevel, _ string, _ func() (string, error)) (*relays.File, error) {
+				return relays.ParseRelaysFile("../../testdata/relays.json")
+			},
+			ConfigPath: tempConfigPath(t),
+			Stdout:     out,
+		}
+	}
+
+	t.Run("Country pings every server in it", func(t *testing.T) {
+		var out bytes.Buffer
+		var pinged []string
+		if err := run(context.Background(), []string{"--best-in", "Sweden"}, makeDeps(&out, &pinged)); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		for _, city := range []string{"se-got-", "se-mma-", "se-sto-"} {
+			if !slices.ContainsFunc(pinged, func(hostname string) bool { return strings.HasPrefix(hostname, city) }) {
+				t.Errorf("Expected servers in every Swedish city to be pinged, none matched %s", city)
+			}
+		}
+		for _, hostname := range pinged {
+			if !strings.HasPrefix(hostname, "se-") {
+				t.Errorf("Expected only Swedish servers, pinged %s", hostname)
+			}
+		}
+		if !strings.Contains(out.String(), "se-got-wg-001") {
+			t.Errorf("Expected se-got-wg-001 as the best server, got:\n%s", out.String())
+		}
+	})
+
+	t.Run("City", func(t *testing.T) {
+		var out bytes.Buffer
+		var pinged []string
+		if err := run(context.Background(), []string{"--best-in", "gothenburg"}, makeDeps(&out, &pinged)); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		for _, hostname := range pinged {
+			if !strings.HasPrefix(hostname, "se-got-") {
+				t.Errorf("Expected only Gothenburg servers, pinged %s", hostname)
+			}
+		}
+	})
+
+	t.Run("Unknown place", func(t *testing.T) {
+		var out bytes.Buffer
+		var pinged []string
+		err := run(context.Background(), []string{"--best-in", "Atlantis"}, makeDeps(&out, &pinged))
+		if err == nil || !strings.Contains(err.Error(), "no servers found in Atlantis") {
+			t.Errorf("Expected no servers error, got: %v", err)
+		}
+	})
+}
+
+func TestE2E_TimeoutWarning(t *testing.T) {
+	makeDeps := func(out *bytes.Buffer) Dependencies {
+		return Dependencies{
+			GetUserLocation: func(context.Context, logging.LogLevel) (*api.UserLocation, error) {
+				return &api.UserLocation{Latitude: 51.0514, Longitude: 13.7341}, nil // Dresden
+			},
+			PingLocations: func(_ context.Context, locs []relays.Location, _, _ int, _ relays.IPVersion, _ logging.LogLevel) ([]relays.Location, error) {
+				return locs, nil
+			},
+			ParseRelaysFile: func(_ context.Context, _ logging.LogLevel, _ string, _ func() (string, error)) (*relays.File, error) {
+				return relays.ParseRelaysFile("../../testdata/relays.json")
+			},
+			ConfigPath: tempConfigPath(t),
+			Stdout:     out,
+		}
+	}
+
+	tests := []struct {
+		name string
+		args []string
+		want bool
+	}{
+		{"Distant country with a short timeout", []string{"-c", "Australia", "-t", "200"}, true},
+		{"Distant country with the default timeout", []string{"-c", "Australia"}, false},
+		{"Distant country with an automatic timeout", []string{"-c", "Australia", "-t", "auto"}, false},
+		{"Nearby servers with a short timeout", []string{"-m", "250", "-t", "100"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			args := append(tt.args, "--no-fallback-distance")
+			if err := run(context.Background(), args, makeDeps(&out)); err != nil {
+				t.Fatalf("Expected no error, got: %v", err)
+			}
+			if got := strings.Contains(out.String(), "too far away to reliably respond"); got != tt.want {
+				t.Errorf("Expected warning: %v, got output:\n%s", tt.want, out.String())
+			}
+		})
+	}
+}
+
+func TestE2E_RunLock(t *testing.T) {
+	lockPath := filepath.Join(t.TempDir(), "run.lock")
+	makeDeps := func(out *bytes.Buffer) Dependencies {
+		return Dependencies{
+			GetUserLocation: func(context.Context, logging.LogLevel) (*api.UserLocation, error) {
+				return &api.UserLocation{Latitude: 51.0514, Longitude: 13.7341}, nil // Dresden
+			},
+			PingLocations: func(_ context.Context, locs []relays.Location, _, _ int, _ relays.IPVersion, _ logging.LogLevel) ([]relays.Location, error) {
+				if _, err := os.Stat(lockPath); err != nil {
+					t.Errorf("Expected the lock to be held while pinging, got: %v", err)
+				}
+				for i := range locs {
+					latency := 10.0
+					locs[i].Latency = &latency
+				}
+				return locs, nil
+			},
+			ParseRelaysFile: func(_ context.Context, _ logging.LogLevel, _ string, _ func() (string, error)) (*relays.File, error) {
+				return relays.ParseRelaysFile("../../testdata/relays.json")
+			},
+			ConfigPath: tempConfigPath(t),
+			LockPath:   func() (string, error) { return lockPath, nil },
+			Stdout:     out,
+		}
+	}
+
+	t.Run("Lock is released after the run", func(t *testing.T) {
+		var out bytes.Buffer
+		if err := run(context.Background(), []string{"-m", "250"}, makeDeps(&out)); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if _, err := os.Stat(lockPath); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("Expected the lock file to be removed, got: %v", err)
+		}
+	})
+
+	t.Run("Concurrent run fails", func(t *testing.T) {
+		release, err := acquireRunLock(lockPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer release()
+
+		var out bytes.Buffer
+		err = run(context.Background(), []string{"-m", "250"}, makeDeps(&out))
+		if !errors.Is(err, errLocked) || !strings.Contains(err.Error(), "--no-lock") {
+			t.Errorf("Expected a lock error suggesting --no-lock, got: %v", err)
+		}
+
+		out.Reset()
+		if err := run(context.Background(), []string{"-m", "250", "--no-lock"}, makeDeps(&out)); err != nil {
+			t.Errorf("Expected --no-lock to run anyway, got: %v", err)
+		}
+	})
+}
+
+func TestE2E_UpdateRelays(t *testing.T) {
+	cachePath := filepath.Join(t.TempDir(), "relays.json")
+	makeDeps := func(out *bytes.Buffer, downloadErr error, parsedPath *string) Dependencies {
+		return Dependencies{
+			GetUserLocation: func(context.Context, logging.LogLevel) (*api.UserLocation, error) {
+				return &api.UserLocation{Latitude: 51.0514, Longitude: 13.7341}, nil // Dresden
+			},
+			PingLocations: func(_ context.Context, locs []relays.Location, _, _ int, _ relays.IPVersion, _ logging.LogLevel) ([]relays.Location, error) {
+				for i := range locs {
+					latency := 10.0
+					locs[i].Latency = &latency
+				}
+				return locs, nil
+			},
+			ParseRelaysFile: func(_ context.Context, _ logging.LogLevel, path string, _ func() (string, error)) (*relays.File, error) {
+				*parsedPath = path
+				return relays.ParseRelaysFile("../../testdata/relays.json")
+			},
+			DownloadRelays: func(_ context.Context, _ logging.LogLevel, path string) (bool, error) {
+				if path != cachePath {
+					t.Errorf("Expected a download to %s, got %s", cachePath, path)
+				}
+				return true, downloadErr
+			},
+			RelaysCachePath: func() (string, error) { return cachePath, nil },
+			ConfigPath:      tempConfigPath(t),
+			Stdout:          out,
+		}
+	}
+
+	t.Run("Downloaded relay list is parsed", func(t *testing.T) {
+		var out bytes.Buffer
+		var parsedPath string
+		if err := run(context.Background(), []string{"--update-relays"}, makeDeps(&out, nil, &parsedPath)); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if parsedPath != cachePath {
+			t.Errorf("Expected %s to be parsed, got %q", cachePath, parsedPath)
+		}
+	})
+
+	t.Run("Download failure", func(t *testing.T) {
+		var out bytes.Buffer
+		var parsedPath string
+		err := run(context.Background(), []string{"--update-relays"}, makeDeps(&out, errors.New("offline"), &parsedPath))
+		if err == nil || !strings.Contains(err.Error(), "failed to download relay list: offline") {
+			t.Errorf("Expected a download error, got: %v", err)
+		}
+	})
+
+	t.Run("App cache by default", func(t *testing.T) {
+		var out bytes.Buffer
+		var parsedPath string
+		if err := run(context.Background(), []string{}, makeDeps(&out, nil, &parsedPath)); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if parsedPath != "" {
+			t.Errorf("Expected the default relays path, got %q", parsedPath)
+		}
+	})
+}
+
+func TestE2E_Pretty(t *testing.T) {
+	makeDeps := func(out *bytes.Buffer) Dependencies {
+		return Dependencies{
+			GetUserLocation: func(context.Context, logging.LogLevel) (*api.UserLocation, error) {
+				return &api.UserLocation{Latitude: 51.0514, Longitude: 13.7341}, nil // Dresden
+			},
+			PingLocations: func(_ context.Context, locs []relays.Location, _, _ int, _ relays.IPVersion, _ logging.LogLevel) ([]relays.Location, error) {
+				for i := range locs {
+					latency := 10.0 + float64(i)
+					locs[i].Latency = &latency
+				}
+				return locs, nil
+			},
+			ParseRelaysFile: func(_ context.Context, _ logging.LogLevel, _ string, _ func() (string, error)) (*relays.File, error) {
+				return relays.ParseRelaysFile("../../testdata/relays.json")
+			},
+			ConfigPath: tempConfigPath(t),
+			Stdout:     out,
+		}
+	}
+
+	tests := []struct {
+		name string
+		args []string
+		want []string
+	}{
+		{"Table", []string{"-m", "250", "--pretty"}, []string{"🇨🇿 Czechia", "🇩🇪 Germany"}},
+		{"Per city", []string{"-m", "250", "--per-city", "--pretty"}, []string{"🇨🇿 Czechia   Prague"}},
+		{"Best server", []string{"--pretty"}, []string{"Best server:     Vienna, 🇦🇹 Austria"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := run(context.Background(), tt.args, makeDeps(&out)); err != nil {
+				t.Fatalf("Expected no error, got: %v", err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(out.String(), want) {
+					t.Errorf("Expected %q in the output, got:\n%s", want, out.String())
+				}
+			}
+			if strings.Contains(out.String(), "Czech Republic") {
+				t.Errorf("Expected relays.json country names to be replaced, got:\n%s", out.String())
+			}
+		})
+	}
+}
+
+func TestE2E_Calibrate(t *testing.T) {
+	makeDeps := func(out *bytes.Buffer) Dependencies {
+		return Dependencies{
+			GetUserLocation: func(context.Context, logging.LogLevel) (*api.UserLocation, error) {
+				return &api.UserLocation{Latitude: 51.0514, Longitude: 13.7341}, nil // Dresden
+			},
+			PingLocations: func(_ context.Context, locs []relays.Location, _, _ int, _ relays.IPVersion, _ logging.LogLevel) ([]relays.Location, error) {
+				for i := range locs {
+					latency := 20.0
+					if locs[i].IPv4Address == "192.0.2.1" {
+						latency = 5.0
+					}
+					locs[i].Latency = &latency
+				}
+				return locs, nil
+			},
+			ParseRelaysFile: func(_ context.Context, _ logging.LogLevel, _ string, _ func() (string, error)) (*relays.File, error) {
+				return relays.ParseRelaysFile("../../testdata/relays.json")
+			},
+			ConfigPath: tempConfigPath(t),
+			Stdout:     out,
+		}
+	}
+
+	tests := []struct {
+		name string
+		args []string
+		want []string
+	}{
+		{
+			"Table",
+			[]string{"-m", "250", "--calibrate", "192.0.2.1"},
+			[]string{"vs Reference (ms)", "20.00          +15.00", "Reference: 192.0.2.1, 5.00 ms"},
+		},
+		{
+			"Per city",
+			[]string{"-m", "250", "--per-city", "--calibrate", "192.0.2.1"},
+			[]string{"vs Reference (ms)", "Relays", "Reference: 192.0.2.1, 5.00 ms"},
+		},
+		{"Best server", []string{"--calibrate", "192.0.2.1"}, []string{"Reference: 192.0.2.1, 5.00 ms"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := run(context.Background(), tt.args, makeDeps(&out)); err != nil {
+				t.Fatalf("Expected no error, got: %v", err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(out.String(), want) {
+					t.Errorf("Expected %q in the output, got:\n%s", want, out.String())
+				}
+			}
+		})
+	}
+
+	t.Run("Loopback refused", func(t *testing.T) {
+		var out bytes.Buffer
+		err := run(context.Background(), []string{"--calibrate", "127.0.0.1"}, makeDeps(&out))
+		if !errors.Is(err, errs.ErrRestrictedTarget) {
+			t.Fatalf("Expected ErrRestrictedTarget, got: %v", err)
+		}
+		if hint := errorHint(err); !strings.Contains(hint, "--allow-private") {
+			t.Errorf("Expected a hint naming --allow-private, got %q", hint)
+		}
+
+		out.Reset()
+		err = run(context.Background(), []string{"--calibrate", "127.0.0.1", "--allow-private"}, makeDeps(&out))
+		if err != nil {
+			t.Fatalf("Expected no error with --allow-private, got: %v", err)
+		}
+		if !strings.Contains(out.String(), "Reference: 127.0.0.1") {
+			t.Errorf("Expected the loopback reference with --allow-private, got:\n%s", out.String())
+		}
+	})
+}
+
+func TestE2E_Baseline(t *testing.T) {
+	var out bytes.Buffer
+	deps := Dependencies{
+		GetUserLocation: func(context.Context, logging.LogLevel) (*api.UserLocation, error) {
+			return &api.UserLocation{Latitude: 51.0514, Longitude: 13.7341}, nil // Dresden
+		},
+		PingLocations: func(_ context.Context, locs []relays.Location, _, _ int, _ relays.IPVersion, _ logging.LogLevel) ([]relays.Location, error) {
+			for i := range locs {
+				latency := 20.0
+				if locs[i].IPv4Address == "1.1.1.1" {
+					latency = 3.0
+				}
+				locs[i].Latency = &latency
+			}
+			return locs, nil
+		},
+		ParseRelaysFile: func(_ context.Context, _ logging.LogLevel, _ string, _ func() (string, error)) (*relays.File, error) {
+			return relays.ParseRelaysFile("../../testdata/relays.json")
+		},
+		ConfigPath: tempConfigPath(t),
+		Stdout:     &out,
+	}
+
+	if err := run(context.Background(), []string{"-m", "250", "--baseline"}, deps); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	lines := strings.Split(out.String(), "\n")
+	cloudflare := slices.IndexFunc(lines, func(line string) bool { return strings.Contains(line, "one.one.one.one") })
+	google := slices.IndexFunc(lines, func(line string) bool { return strings.Contains(line, "dns.google") })
+	if cloudflare < 0 || google != cloudflare+1 {
+		t.Fatalf("Expected the Cloudflare and Google baseline rows, got:\n%s", out.String())
+	}
+	// The faster baseline is not ranked among the servers, but listed after them
+	if !strings.HasPrefix(lines[cloudflare], "Baseline") || !strings.Contains(lines[cloudflare-1], "-wg-") {
+		t.Errorf("Expected the baseline rows right after the servers, got:\n%s", out.String())
+	}
+	if !strings.Contains(out.String(), "3.00") {
+		t.Errorf("Expected the baseline latency in the output, got:\n%s", out.String())
+	}
+}
+
+func TestE2E_Asymmetry(t *testing.T) {
+	if !ping.TimestampSupported {
+		t.Skip("ICMP timestamp requests are not supported on this platform")
+	}
+	var out bytes.Buffer
+	var probed []string
+	deps := Dependencies{
+		GetUserLocation: func(context.Context, logging.LogLevel) (*api.UserLocation, error) {
+			return &api.UserLocation{Latitude: 51.0514, Longitude: 13.7341}, nil // Dresden
+		},
+		PingLocations: func(_ context.Context, locs []relays.Location, _, _ int, _ relays.IPVersion, _ logging.LogLevel) ([]relays.Location, error) {
+			for i := range locs {
+				latency := 10.0 + float64(i)
+				locs[i].Latency = &latency
+			}
+			return locs, nil
+		},
+		ParseRelaysFile: func(_ context.Context, _ logging.LogLevel, _ string, _ func() (string, error)) (*relays.File, error) {
+			return relays.ParseRelaysFile("../../testdata/relays.json")
+		},
+		ProbeAsymmetry: func(_ context.Context, locs []relays.Location, _ time.Duration, _ logging.LogLevel) ([]ping.Asymmetry, error) {
+			results := make([]ping.Asymmetry, len(locs))
+			for i, loc := range locs {
+				probed = append(probed, loc.Hostname)
+				results[i] = ping.Asymmetry{Location: loc}
+				if i == 0 {
+					outbound, back := 7.5, 2.5
+					results[i].Outbound, results[i].Return = &outbound, &back
+				}
+			}
+			return results, nil
+		},
+		ConfigPath: tempConfigPath(t),
+		Stdout:     &out,
+	}
+
+	if err := run(context.Background(), []string{"-m", "250", "--asymmetry"}, deps); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	if len(probed) != asymmetryServers {
+		t.Fatalf("Expected the %d best servers to be probed, got %q", asymmetryServers, probed)
+	}
+	output := out.String()
+	if !strings.Contains(output, "Upload/download delay (experimental") {
+		t.Fatalf("Expected the asymmetry section, got:\n%s", output)
+	}
+	if !strings.Contains(output, probed[0]) || !strings.Contains(output, "up 7.50 ms, down 2.50 ms") {
+		t.Errorf("Expected the delays of the best server, got:\n%s", output)
+	}
+	if strings.Count(output, "no timestamp reply") != asymmetryServers-1 {
+		t.Errorf("Expected the servers without a reply to be listed, got:\n%s", output)
+	}
+
+	// A failed probe is reported without failing the run
+	out.Reset()
+	deps.ProbeAsymmetry = func(
+		context.Context,
+		[]relays.Location,
+		time.Duration,
+		logging.LogLevel,
+	) ([]ping.Asymmetry, error) {
+		return nil, errors.New("raw socket denied")
+	}
+	if err := run(context.Background(), []string{"-m", "250", "--asymmetry"}, deps); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if !strings.Contains(out.String(), "not measured: raw socket denied") {
+		t.Errorf("Expected the probe error in the output, got:\n%s", out.String())
+	}
+}
+
+func TestE2E_Sudo(t *testing.T) {
+	if !elevate.Supported {
+		t.Skip("--sudo is not supported on this platform")
+	}
+	var out bytes.Buffer
+	var pinged bool
+	var confirmed bool
+	var elevated []string
+	deps := Dependencies{
+		GetUserLocation: func(context.Context, logging.LogLevel) (*api.UserLocation, error) {
+			return &api.UserLocation{Latitude: 51.0514, Longitude: 13.7341}, nil // Dresden
+		},
+		PingLocations: func(_ context.Context, locs []relays.Location, _, _ int, _ relays.IPVersion, _ logging.LogLevel) ([]relays.Location, error) {
+			pinged = true
+			for i := range locs {
+				latency := 10.0 + float64(i)
+				locs[i].Latency = &latency
+			}
+			return locs, nil
+		},
+		ParseRelaysFile: func(_ context.Context, _ logging.LogLevel, _ string, _ func() (string, error)) (*relays.File, error) {
+			return relays.ParseRelaysFile("../../testdata/relays.json")
+		},
+		CheckPrivileges: func(*cli.Config) error {
+			return fmt.Errorf("%w: socket: operation not permitted", errs.ErrPermission)
+		},
+		Confirm: func(question string) bool {
+			if !strings.Contains(question, "operation not permitted") || !strings.Contains(question, "[y/N]") {
+				t.Errorf("Expected the permission error in the question, got %q", question)
+			}
+			return confirmed
+		},
+		Elevate: func(args []string) error {
+			elevated = args
+			return nil
+		},
+		ConfigPath: tempConfigPath(t),
+		Stdout:     &out,
+	}
+	args := []string{"-m", "250", "--sudo"}
+
+	t.Run("Declined", func(t *testing.T) {
+		err := run(context.Background(), args, deps)
+		if !errors.Is(err, errs.ErrPermission) {
+			t.Fatalf("Expected a permission error, got: %v", err)
+		}
+		if pinged || elevated != nil {
+			t.Errorf("Expected neither pings nor a run as root, pinged %v, elevated %q", pinged, elevated)
+		}
+	})
+
+	t.Run("Confirmed", func(t *testing.T) {
+		confirmed = true
+		if err := run(context.Background(), args, deps); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if !slices.Equal(elevated, args) {
+			t.Errorf("Expected to run again with %q, got %q", args, elevated)
+		}
+		if pinged {
+			t.Error("Expected the pings to be left to the run as root")
+		}
+	})
+
+	t.Run("Permitted", func(t *testing.T) {
+		elevated = nil
+		deps.CheckPrivileges = func(*cli.Config) error { return nil }
+		if err := run(context.Background(), args, deps); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if !pinged || elevated != nil {
+			t.Errorf("Expected the run to ping without running again, pinged %v, elevated %q", pinged, elevated)
+		}
+	})
+}
+
+func TestE2E_GroupedLayout(t *testing.T) {
+	var out bytes.Buffer
+	deps := Dependencies{
+		GetUserLocation: func(context.Context, logging.LogLevel) (*api.UserLocation, error) {
+			return &api.UserLocation{Latitude: 51.0514, Longitude: 13.7341}, nil // Dresden
+		},
+		PingLocations: func(_ context.Context, locs []relays.Location, _, _ int, _ relays.IPVersion, _ logging.LogLevel) ([]relays.Location, error) {
+			for i := range locs {
+				latency := 10.0 + float64(i)
+				locs[i].Latency = &latency
+			}
+			return locs, nil
+		},
+		ParseRelaysFile: func(_ context.Context, _ logging.LogLevel, _ string, _ func() (string, error)) (*relays.File, error) {
+			return relays.ParseRelaysFile("../../testdata/relays.json")
+		},
+		ConfigPath: tempConfigPath(t),
+		Stdout:     &out,
+	}
+
+	if err := run(context.Background(), []string{"--layout", "grouped", "-m", "250"}, deps); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	for _, want := range []string{"Czech Republic\n    Prague, ", "Germany\n    Berlin, ", "servers, 100% reachable"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("Expected %q in the output, got:\n%s", want, out.String())
+		}
+	}
+	if strings.Contains(out.String(), "Latency (ms)") {
+		t.Errorf("Expected no table header, got:\n%s", out.String())
+	}
+}
+
+func TestE2E_Footnote(t *testing.T) {
+	makeDeps := func(out *bytes.Buffer) Dependencies {
+		return Dependencies{
+			GetUserLocation: func(context.Context, logging.LogLevel) (*api.UserLocation, error) {
+				return &api.UserLocation{Latitude: 51.0514, Longitude: 13.7341}, nil // Dresden
+			},
+			PingLocations: func(_ context.Context, locs []relays.Location, _, _ int, _ relays.IPVersion, _ logging.LogLevel) ([]relays.Location, error) {
+				for i := range locs {
+					latency := 10.0 + float64(i)
+					locs[i].Latency = &latency
+				}
+				return locs, nil
+			},
+			ParseRelaysFile: func(_ context.Context, _ logging.LogLevel, _ string, _ func() (string, error)) (*relays.File, error) {
+				return relays.ParseRelaysFile("../../testdata/relays.json")
+			},
+			ConfigPath: tempConfigPath(t),
+			Stdout:     out,
+		}
+	}
+
+	t.Run("Table", func(t *testing.T) {
+		var out bytes.Buffer
+		if err := run(context.Background(), []string{"-c", "de", "-m", "250", "--latency-under", "15"}, makeDeps(&out)); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if !regexp.MustCompile(`\nShowing 5 of \d+ matching servers \(\d+ beyond 250 km, 3 not under 15 ms\)\n`).
+			MatchString(out.String()) {
+			t.Errorf("Expected a footnote on the hidden servers, got:\n%s", out.String())
+		}
+	})
+
+	t.Run("Nothing hidden", func(t *testing.T) {
+		var out bytes.Buffer
+		if err := run(context.Background(), []string{"-c", "cz"}, makeDeps(&out)); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if strings.Contains(out.String(), "Showing") {
+			t.Errorf("Expected no footnote without hidden servers, got:\n%s", out.String())
+		}
+	})
+
+	t.Run("JSON", func(t *testing.T) {
+		var out bytes.Buffer
+		if err := run(context.Background(), []string{"-m", "250", "--share", "json"}, makeDeps(&out)); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		var report formatter.ShareReport
+		if err := json.Unmarshal(out.Bytes(), &report); err != nil {
+			t.Fatalf("Expected a JSON report, got %v:\n%s", err, out.String())
+		}
+		if report.Counts == nil || report.Counts.Shown != 12 || report.Counts.BeyondDistance != report.Counts.Matching-12 {
+			t.Errorf("Expected the counts of shown and hidden servers, got %+v", report.Counts)
+		}
+	})
 }
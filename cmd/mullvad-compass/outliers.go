@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"log"
+	"slices"
+
+	"github.com/Ch00k/mullvad-compass/internal/logging"
+	"github.com/Ch00k/mullvad-compass/internal/ping"
+	"github.com/Ch00k/mullvad-compass/internal/relays"
+)
+
+// verifyOutliers wraps pingFn to ping the relays whose latency stands out from their city peers once more, keeping
+// the lower of the two latencies, so that a lost or delayed packet does not rank a relay down
+func verifyOutliers(
+	pingFn func(context.Context, []relays.Location, int, int, relays.IPVersion, logging.LogLevel) ([]relays.Location, error),
+) func(context.Context, []relays.Location, int, int, relays.IPVersion, logging.LogLevel) ([]relays.Location, error) {
+	return func(
+		ctx context.Context,
+		locations []relays.Location,
+		timeout, workers int,
+		ipVersion relays.IPVersion,
+		logLevel logging.LogLevel,
+	) ([]relays.Location, error) {
+		pinged, err := pingFn(ctx, locations, timeout, workers, ipVersion, logLevel)
+		if err != nil {
+			return nil, err
+		}
+		outliers := ping.CityOutliers(pinged)
+		if len(outliers) == 0 {
+			return pinged, nil
+		}
+
+		suspects := make([]relays.Location, len(outliers))
+		for i, idx := range outliers {
+			suspects[i] = pinged[idx]
+		}
+		if logLevel <= logging.LogLevelInfo {
+			log.Printf("Re-probing %d server(s) whose latency stands out from their city", len(suspects))
+		}
+		reprobed, err := pingFn(ctx, slices.Clone(suspects), timeout, workers, ipVersion, logLevel)
+		if err != nil {
+			return nil, err
+		}
+
+		latencies := make(map[string]*float64, len(reprobed))
+		for _, loc := range reprobed {
+			latencies[loc.Hostname] = loc.Latency
+		}
+		for _, idx := range outliers {
+			again := latencies[pinged[idx].Hostname]
+			if again == nil {
+				continue
+			}
+			if logLevel <= logging.LogLevelDebug {
+				log.Printf("Re-probed %s: %s, first %s",
+					pinged[idx].Hostname, formatMedian(again), formatMedian(pinged[idx].Latency))
+			}
+			if pinged[idx].Latency == nil || *again < *pinged[idx].Latency {
+				pinged[idx].Latency = again
+			}
+		}
+		return pinged, nil
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Ch00k/mullvad-compass/internal/appsettings"
+	"github.com/Ch00k/mullvad-compass/internal/cli"
+	"github.com/Ch00k/mullvad-compass/internal/history"
+	"github.com/Ch00k/mullvad-compass/internal/hostlist"
+	"github.com/Ch00k/mullvad-compass/internal/relays"
+)
+
+// pathEntry is a file or directory mullvad-compass reads or writes, resolved for the current platform
+type pathEntry struct {
+	name    string
+	resolve func() (string, error)
+}
+
+// pathEntries lists the paths shown by the paths command, resolved as a run would, honoring the environment
+// overrides of the relay list and app settings and the XDG directories on Linux
+func pathEntries(config *cli.Config, deps Dependencies) []pathEntry {
+	var entries []pathEntry
+	if deps.ConfigPath != nil {
+		configFile := func(name string) func() (string, error) {
+			return func() (string, error) { return deps.ConfigPath(name) }
+		}
+		entries = append(entries,
+			pathEntry{"config", func() (string, error) {
+				path, err := deps.ConfigPath(hostlist.Favorites)
+				return filepath.Dir(path), err
+			}},
+			pathEntry{"favorites", configFile(hostlist.Favorites)},
+			pathEntry{"ignore", configFile(hostlist.Ignore)},
+			pathEntry{"timeouts", configFile(hostlist.Timeouts)},
+			pathEntry{"history", configFile(history.File)},
+		)
+	}
+	entries = append(entries,
+		pathEntry{"cache", func() (string, error) {
+			path, err := deps.RelaysCachePath()
+			return filepath.Dir(path), err
+		}},
+		pathEntry{"relays", func() (string, error) { return relays.GetRelaysFilePathWithLogLevel(config.LogLevel) }},
+		pathEntry{"relays-cache", deps.RelaysCachePath},
+		pathEntry{"hook-state", deps.HookStatePath},
+		pathEntry{"settings", func() (string, error) {
+			return appsettings.GetSettingsFilePathWithLogLevel(config.LogLevel)
+		}},
+	)
+	if deps.LockPath != nil {
+		entries = append(entries, pathEntry{"lock", deps.LockPath})
+	}
+	return entries
+}
+
+// runPaths prints the resolved paths, noting those that do not exist yet. Given a name, only that path is printed,
+// for use in scripts.
+func runPaths(config *cli.Config, deps Dependencies, stdout io.Writer) error {
+	entries := pathEntries(config, deps)
+
+	if len(config.Args) == 1 {
+		names := make([]string, len(entries))
+		for i, entry := range entries {
+			if entry.name == config.Args[0] {
+				path, err := entry.resolve()
+				if err != nil {
+					return err
+				}
+				_, _ = fmt.Fprintln(stdout, path)
+				return nil
+			}
+			names[i] = entry.name
+		}
+		return fmt.Errorf("unknown path %q (one of: %s)", config.Args[0], strings.Join(names, ", "))
+	}
+
+	width := 0
+	for _, entry := range entries {
+		width = max(width, len(entry.name))
+	}
+	for _, entry := range entries {
+		path, err := entry.resolve()
+		switch {
+		case err != nil:
+			path = fmt.Sprintf("(%v)", err)
+		case !exists(path):
+			path += " (not created yet)"
+		}
+		_, _ = fmt.Fprintf(stdout, "%-*s  %s\n", width, entry.name, path)
+	}
+	return nil
+}
+
+// exists reports whether a file or directory is present at path
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return !errors.Is(err, os.ErrNotExist)
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/Ch00k/mullvad-compass/internal/cli"
+	"github.com/Ch00k/mullvad-compass/internal/distance"
+	"github.com/Ch00k/mullvad-compass/internal/formatter"
+	"github.com/Ch00k/mullvad-compass/internal/relays"
+)
+
+// planCandidates is the number of relays the plan command lists for each destination
+const planCandidates = 3
+
+// runPlan lists the relays nearest to each destination of the plan command. Destinations are looked up among the
+// Mullvad locations, and relays are ranked by distance only, as they cannot be pinged from where the user will be.
+func runPlan(config *cli.Config, relaysData *relays.File, locations []relays.Location, stdout io.Writer) error {
+	cities := make([]formatter.PlannedCity, 0, len(config.Cities))
+	for _, name := range config.Cities {
+		_, entry, ok := relaysData.LookupCity(name)
+		if !ok {
+			return fmt.Errorf("unknown city: %s (must be a Mullvad location such as \"Lisbon\" or \"nyc\")", name)
+		}
+		nearest := distance.NewIndex(locations, entry.Latitude, entry.Longitude).Closest(planCandidates)
+		cities = append(cities, formatter.PlannedCity{Name: entry.City, Relays: nearest})
+	}
+
+	_, _ = fmt.Fprint(stdout, formatter.FormatPlan(cities, displayOptions(config)))
+	return nil
+}
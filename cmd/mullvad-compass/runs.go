@@ -0,0 +1,94 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"time"
+
+	"github.com/Ch00k/mullvad-compass/internal/cli"
+	"github.com/Ch00k/mullvad-compass/internal/clock"
+	"github.com/Ch00k/mullvad-compass/internal/logging"
+	"github.com/Ch00k/mullvad-compass/internal/relays"
+)
+
+// runGap is the pause between the runs of --runs
+const runGap = time.Second
+
+// aggregationMedian names how the latencies of several runs are combined, in the metadata of reports
+const aggregationMedian = "median"
+
+// repeatPings wraps pingFn to ping the locations runs times, waiting gap between the runs, and to set each latency to
+// the median of the runs, a timeout counting as slower than any reply
+func repeatPings(
+	pingFn func(context.Context, []relays.Location, int, int, relays.IPVersion, logging.LogLevel) ([]relays.Location, error),
+	runs int,
+	gap time.Duration,
+) func(context.Context, []relays.Location, int, int, relays.IPVersion, logging.LogLevel) ([]relays.Location, error) {
+	return func(
+		ctx context.Context,
+		locations []relays.Location,
+		timeout, workers int,
+		ipVersion relays.IPVersion,
+		logLevel logging.LogLevel,
+	) ([]relays.Location, error) {
+		samples := make(map[string][]*float64, len(locations))
+		var pinged []relays.Location
+		for run := 0; run < runs; run++ {
+			if run > 0 {
+				select {
+				case <-ctx.Done():
+					return nil, ctx.Err()
+				case <-clock.FromContext(ctx).After(gap):
+				}
+			}
+			if logLevel <= logging.LogLevelDebug {
+				log.Printf("Ping run %d of %d (%d servers)", run+1, runs, len(locations))
+			}
+
+			var err error
+			pinged, err = pingFn(ctx, slices.Clone(locations), timeout, workers, ipVersion, logLevel)
+			if err != nil {
+				return nil, err
+			}
+			for _, loc := range pinged {
+				samples[loc.Hostname] = append(samples[loc.Hostname], loc.Latency)
+			}
+		}
+
+		for i := range pinged {
+			pinged[i].Latency = medianLatency(samples[pinged[i].Hostname])
+		}
+		return pinged, nil
+	}
+}
+
+// medianLatency returns the nearest-rank median of latencies, nil for a timeout. Timeouts sort after every reply, so
+// a server that timed out in most runs stays a timeout.
+func medianLatency(latencies []*float64) *float64 {
+	if len(latencies) == 0 {
+		return nil
+	}
+	sorted := slices.SortedFunc(slices.Values(latencies), func(a, b *float64) int {
+		switch {
+		case a == nil && b == nil:
+			return 0
+		case a == nil:
+			return 1
+		case b == nil:
+			return -1
+		}
+		return cmp.Compare(*a, *b)
+	})
+	return sorted[(len(sorted)-1)/2]
+}
+
+// formatRunsNote notes that latencies are medians of several runs, and is empty for a single run
+func formatRunsNote(config *cli.Config) string {
+	if config.Runs <= 1 {
+		return ""
+	}
+	return fmt.Sprintf("\nRanked by the %s latency of %d runs\n", aggregationMedian, config.Runs)
+}
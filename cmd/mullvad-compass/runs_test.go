@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Ch00k/mullvad-compass/internal/logging"
+	"github.com/Ch00k/mullvad-compass/internal/relays"
+)
+
+func TestMedianLatency(t *testing.T) {
+	ms := func(v float64) *float64 { return &v }
+
+	tests := []struct {
+		name      string
+		latencies []*float64
+		want      *float64
+	}{
+		{"No runs", nil, nil},
+		{"Single run", []*float64{ms(12)}, ms(12)},
+		{"Spike ignored", []*float64{ms(10), ms(95), ms(11)}, ms(11)},
+		{"Even count takes the lower middle", []*float64{ms(14), ms(10)}, ms(10)},
+		{"One timeout", []*float64{nil, ms(20), ms(10)}, ms(20)},
+		{"Mostly timeouts", []*float64{nil, ms(10), nil}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := medianLatency(tt.latencies)
+			if (got == nil) != (tt.want == nil) || (got != nil && *got != *tt.want) {
+				t.Errorf("Expected %v, got %v", formatMedian(tt.want), formatMedian(got))
+			}
+		})
+	}
+}
+
+func TestRepeatPings(t *testing.T) {
+	ms := func(v float64) *float64 { return &v }
+	// The first server has a spike in the second run, the second times out in the last
+	rounds := [][]*float64{{ms(10), ms(20)}, {ms(80), ms(21)}, {ms(11), nil}}
+	var calls int
+	pingFn := func(_ context.Context, locs []relays.Location, _, _ int, _ relays.IPVersion, _ logging.LogLevel) ([]relays.Location, error) {
+		for i := range locs {
+			locs[i].Latency = rounds[calls][i]
+		}
+		calls++
+		return locs, nil
+	}
+
+	locations := []relays.Location{{Hostname: "a"}, {Hostname: "b"}}
+	pinged, err := repeatPings(pingFn, 3, 0)(context.Background(), locations, 500, 1, relays.IPv4, logging.LogLevelError)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if calls != 3 {
+		t.Errorf("Expected 3 runs, got %d", calls)
+	}
+	if *pinged[0].Latency != 11 || *pinged[1].Latency != 21 {
+		t.Errorf("Expected medians 11 and 21, got %v and %v", *pinged[0].Latency, *pinged[1].Latency)
+	}
+	if locations[0].Latency != nil {
+		t.Error("Expected the input locations to be left untouched")
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"slices"
+	"time"
+
+	"github.com/Ch00k/mullvad-compass/internal/cli"
+	"github.com/Ch00k/mullvad-compass/internal/clock"
+	"github.com/Ch00k/mullvad-compass/internal/compass"
+	"github.com/Ch00k/mullvad-compass/internal/history"
+	"github.com/Ch00k/mullvad-compass/internal/hooks"
+	"github.com/Ch00k/mullvad-compass/internal/logging"
+	"github.com/Ch00k/mullvad-compass/internal/ping"
+	"github.com/Ch00k/mullvad-compass/internal/relays"
+)
+
+// runEvery searches for the best servers every config.Every until the context is cancelled. The runs share a
+// session, so that relays.json is parsed and the ICMP sockets are opened once rather than on every run. A reload
+// signal (SIGHUP) parses the flags and relays.json again, and a dump signal (SIGUSR1) logs the last run.
+func runEvery(ctx context.Context, args []string, config *cli.Config, deps Dependencies) error {
+	var session *compass.Session
+	if deps.NewSession != nil {
+		var err error
+		session, err = deps.NewSession(ctx, config)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = session.Close() }()
+		deps = sessionDependencies(session, deps)
+	}
+
+	signals := make(chan os.Signal, 1)
+	if reloadSignal != nil {
+		signal.Notify(signals, reloadSignal, dumpSignal)
+		defer signal.Stop(signals)
+	}
+
+	ticker := clock.FromContext(ctx).NewTicker(config.Every)
+	defer ticker.Stop()
+	return runSchedule(ctx, config, deps, schedule{
+		ticks:   ticker.C(),
+		signals: signals,
+		reload: func(ctx context.Context) (*cli.Config, error) {
+			reloaded, err := cli.ParseFlags(args, Version)
+			if err != nil {
+				return nil, err
+			}
+			if session != nil {
+				if err := session.Refresh(ctx); err != nil {
+					return nil, err
+				}
+			}
+			return reloaded, nil
+		},
+	})
+}
+
+// schedule is what a schedule waits for between runs
+type schedule struct {
+	ticks   <-chan time.Time
+	signals <-chan os.Signal                           // reloadSignal and dumpSignal
+	reload  func(context.Context) (*cli.Config, error) // Called on reloadSignal for the config of the next runs
+}
+
+// runSchedule searches once, then again on every tick. A failed run is logged and the schedule goes on, since the
+// network or the Mullvad API may well be back by the next tick. Cancelling the context ends the schedule.
+// Signals received between runs reload the config or log the last run, without starting a run.
+func runSchedule(ctx context.Context, config *cli.Config, deps Dependencies, s schedule) error {
+	for {
+		_, _ = fmt.Fprintf(deps.Stdout, "=== %s ===\n", clock.FromContext(ctx).Now().Format(time.DateTime))
+
+		// Each run starts from the parsed flags, as a search adjusts its config to the app settings and IP version
+		runConfig := *config
+		err := search(ctx, &runConfig, deps)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil && !errors.Is(err, errDistanceFallback) && config.LogLevel <= logging.LogLevelError {
+			log.Printf("Scheduled run failed: %v", err)
+		}
+		if config.LogLevel <= logging.LogLevelInfo {
+			log.Printf("Next run in %v", config.Every)
+		}
+
+	wait:
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-s.ticks:
+				break wait
+			case sig := <-s.signals:
+				if sig == reloadSignal {
+					config = reloadConfig(ctx, config, s.reload)
+				} else {
+					logLastRun(config, deps)
+				}
+			}
+		}
+		_, _ = fmt.Fprintln(deps.Stdout)
+	}
+}
+
+// reloadConfig returns the config reload returns, or config unchanged when reloading fails
+func reloadConfig(
+	ctx context.Context,
+	config *cli.Config,
+	reload func(context.Context) (*cli.Config, error),
+) *cli.Config {
+	if reload == nil {
+		return config
+	}
+	reloaded, err := reload(ctx)
+	if err != nil {
+		if config.LogLevel <= logging.LogLevelError {
+			log.Printf("Failed to reload, keeping the current config: %v", err)
+		}
+		return config
+	}
+	if reloaded.LogLevel <= logging.LogLevelInfo {
+		log.Printf("Reloaded the flags and relays.json")
+	}
+	return reloaded
+}
+
+// logLastRun logs the servers of the last run recorded in the history store, best first
+func logLastRun(config *cli.Config, deps Dependencies) {
+	var runs []history.Run
+	var err error
+	if deps.ConfigPath != nil {
+		var path string
+		path, err = deps.ConfigPath(history.File)
+		if err == nil {
+			runs, err = history.Load(path)
+		}
+	}
+	if err != nil {
+		log.Printf("Failed to load the last run: %v", err)
+		return
+	}
+	if len(runs) == 0 {
+		log.Printf("No run recorded yet")
+		return
+	}
+
+	last := runs[len(runs)-1]
+	log.Printf("Last run at %s, %d servers:", last.Time.Local().Format(time.DateTime), len(last.Servers))
+	for _, server := range last.Servers {
+		latency := "timeout"
+		if server.Latency != nil {
+			latency = fmt.Sprintf("%.*f ms", config.Precision, *server.Latency)
+		}
+		log.Printf("  %s (%s, %s) %s", server.Hostname, server.City, server.Country, latency)
+	}
+}
+
+// recordRun appends the ranked locations to the history store when running on a schedule, dropping runs older
+// than --retain. The history is best effort: failing to record it only logs a warning.
+func recordRun(ctx context.Context, config *cli.Config, deps Dependencies, ranked []relays.Location) {
+	if config.Every == 0 || deps.ConfigPath == nil {
+		return
+	}
+
+	useIPv6 := config.IPVersion.IsIPv6()
+	run := history.Run{Time: clock.FromContext(ctx).Now().UTC(), Servers: make([]hooks.Server, len(ranked))}
+	for i, loc := range ranked {
+		run.Servers[i] = hooks.NewServer(loc, useIPv6)
+	}
+
+	path, err := deps.ConfigPath(history.File)
+	if err == nil {
+		err = history.Append(path, run, config.Retain)
+	}
+	if err != nil && config.LogLevel <= logging.LogLevelWarning {
+		log.Printf("Failed to record run: %v", err)
+	}
+}
+
+// newSession opens the session shared by the runs of a schedule, with a pinger of the configured IP version and
+// probe source. It leaves locating the user to each run, which may ask the Mullvad app or the API.
+func newSession(ctx context.Context, config *cli.Config) (*compass.Session, error) {
+	source := ping.Source{Interface: config.Interface, Address: config.SourceIP, Netns: config.Netns}
+	return compass.NewSession(
+		ctx,
+		compass.WithIPVersion(config.IPVersion),
+		compass.WithLogLevel(config.LogLevel),
+		compass.WithPingerFactory(ping.NewPingerFactoryWithCapture(source, nil)),
+		compass.WithRelaysLoader(func(ctx context.Context) (*relays.File, error) {
+			return parseRelaysFile(ctx, config.LogLevel, "", relays.GetRelaysFilePath)
+		}),
+		compass.WithLocator(nil),
+	)
+}
+
+// sessionDependencies returns deps taking relays.json and the pinger from the session. relays.json is parsed again
+// only once it has changed, e.g. when the Mullvad app has updated it.
+func sessionDependencies(session *compass.Session, deps Dependencies) Dependencies {
+	parseRelaysFile, pingLocations := deps.ParseRelaysFile, deps.PingLocations
+
+	deps.ParseRelaysFile = func(
+		ctx context.Context,
+		logLevel logging.LogLevel,
+		path string,
+		getPath func() (string, error),
+	) (*relays.File, error) {
+		// A relay list just downloaded with --update-relays is parsed as usual
+		if path != "" {
+			return parseRelaysFile(ctx, logLevel, path, getPath)
+		}
+		if relaysChanged(getPath, session.Refreshed()) {
+			if err := session.Refresh(ctx); err != nil {
+				return nil, err
+			}
+		}
+		return copyRelays(session.Relays()), nil
+	}
+
+	deps.PingLocations = func(
+		ctx context.Context,
+		locations []relays.Location,
+		timeout, workers int,
+		ipVersion relays.IPVersion,
+		logLevel logging.LogLevel,
+	) ([]relays.Location, error) {
+		// The session's pinger is of the configured IP version, and writes no --pcap capture
+		if ipVersion != session.IPVersion() || ping.CaptureFromContext(ctx) != nil {
+			return pingLocations(ctx, locations, timeout, workers, ipVersion, logLevel)
+		}
+		return session.Ping(ctx, locations, timeout, workers)
+	}
+
+	return deps
+}
+
+// relaysChanged reports whether the relays.json found now was modified after since. A file that cannot be found
+// counts as changed, leaving the error to the parser.
+func relaysChanged(getPath func() (string, error), since time.Time) bool {
+	path, err := getPath()
+	if err != nil {
+		return true
+	}
+	info, err := os.Stat(path)
+	return err != nil || info.ModTime().After(since)
+}
+
+// copyRelays returns a copy of the file whose relay lists a run can filter without changing the session's
+func copyRelays(file *relays.File) *relays.File {
+	c := *file
+	c.WireGuard.Relays = slices.Clone(file.WireGuard.Relays)
+	c.Bridge.Relays = slices.Clone(file.Bridge.Relays)
+	return &c
+}
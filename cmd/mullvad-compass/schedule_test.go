@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Ch00k/mullvad-compass/internal/api"
+	"github.com/Ch00k/mullvad-compass/internal/cli"
+	"github.com/Ch00k/mullvad-compass/internal/clock"
+	"github.com/Ch00k/mullvad-compass/internal/compass"
+	"github.com/Ch00k/mullvad-compass/internal/history"
+	"github.com/Ch00k/mullvad-compass/internal/logging"
+	"github.com/Ch00k/mullvad-compass/internal/ping"
+	"github.com/Ch00k/mullvad-compass/internal/relays"
+)
+
+func TestRunSchedule(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// The first run fails, the second succeeds, and the third is cancelled
+	var calls int
+	var out bytes.Buffer
+	configPath := tempConfigPath(t)
+	deps := Dependencies{
+		GetUserLocation: func(context.Context, logging.LogLevel) (*api.UserLocation, error) {
+			calls++
+			switch calls {
+			case 1:
+				return nil, errors.New("connection refused")
+			case 3:
+				cancel()
+				return nil, context.Canceled
+			}
+			return &api.UserLocation{Latitude: 51.0514, Longitude: 13.7341}, nil // Dresden
+		},
+		PingLocations: func(_ context.Context, locs []relays.Location, _, _ int, _ relays.IPVersion, _ logging.LogLevel) ([]relays.Location, error) {
+			for i := range locs {
+				latency := 10.0 + float64(i)
+				locs[i].Latency = &latency
+			}
+			return locs, nil
+		},
+		ParseRelaysFile: func(_ context.Context, _ logging.LogLevel, _ string, _ func() (string, error)) (*relays.File, error) {
+			return relays.ParseRelaysFile("../../testdata/relays.json")
+		},
+		ConfigPath: configPath,
+		Stdout:     &out,
+	}
+
+	config, err := cli.ParseFlags([]string{"--every", "15m", "--retain", "1d"}, "dev")
+	if err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+
+	ticks := make(chan time.Time, 2)
+	ticks <- time.Now()
+	ticks <- time.Now()
+	if err := runSchedule(ctx, config, deps, schedule{ticks: ticks}); err != nil {
+		t.Fatalf("Expected the schedule to end without an error, got: %v", err)
+	}
+	if calls != 3 {
+		t.Errorf("Expected 3 runs, got %d", calls)
+	}
+	if got := strings.Count(out.String(), "=== "); got != 3 {
+		t.Errorf("Expected a header for each of the 3 runs, got %d:\n%s", got, out.String())
+	}
+
+	path, err := configPath(history.File)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runs, err := history.Load(path)
+	if err != nil {
+		t.Fatalf("Failed to load the history: %v", err)
+	}
+	if len(runs) != 1 {
+		t.Fatalf("Expected only the successful run in the history, got %d", len(runs))
+	}
+	if len(runs[0].Servers) == 0 || runs[0].Servers[0].Latency == nil {
+		t.Errorf("Expected the ranked servers in the history, got %+v", runs[0].Servers)
+	}
+}
+
+func TestRunEvery(t *testing.T) {
+	start := time.Now().Truncate(time.Second)
+	clk := clock.NewFake(start)
+	ctx, cancel := context.WithCancel(clock.WithClock(context.Background(), clk))
+	defer cancel()
+
+	// Every search takes a minute of virtual time, the schedule is cancelled during the second one
+	var calls int
+	var out bytes.Buffer
+	configPath := tempConfigPath(t)
+	deps := Dependencies{
+		GetUserLocation: func(context.Context, logging.LogLevel) (*api.UserLocation, error) {
+			return &api.UserLocation{Latitude: 51.0514, Longitude: 13.7341}, nil // Dresden
+		},
+		PingLocations: func(_ context.Context, locs []relays.Location, _, _ int, _ relays.IPVersion, _ logging.LogLevel) ([]relays.Location, error) {
+			calls++
+			clk.Advance(time.Minute)
+			if calls == 2 {
+				cancel()
+			}
+			for i := range locs {
+				latency := 10.0 + float64(i)
+				locs[i].Latency = &latency
+			}
+			return locs, nil
+		},
+		ParseRelaysFile: func(_ context.Context, _ logging.LogLevel, _ string, _ func() (string, error)) (*relays.File, error) {
+			return relays.ParseRelaysFile("../../testdata/relays.json")
+		},
+		ConfigPath: configPath,
+		Stdout:     &out,
+	}
+
+	config, err := cli.ParseFlags([]string{"--every", "15m"}, "dev")
+	if err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+
+	path, err := configPath(history.File)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing ticks until the clock has passed the interval, which it must not before the first run is recorded
+	done := make(chan error, 1)
+	go func() { done <- runEvery(ctx, []string{"--every", "15m"}, config, deps) }()
+	deadline := time.After(10 * time.Second)
+	for recorded := false; !recorded; {
+		select {
+		case <-deadline:
+			t.Fatal("Expected the first run to be recorded")
+		case <-time.After(time.Millisecond):
+			runs, _ := history.Load(path)
+			recorded = len(runs) > 0
+		}
+	}
+	clk.Advance(14 * time.Minute)
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Expected the schedule to end without an error, got: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Expected the schedule to run again once the interval passed")
+	}
+	for _, at := range []time.Time{start, start.Add(15 * time.Minute)} {
+		if header := "=== " + at.Format(time.DateTime) + " ==="; !strings.Contains(out.String(), header) {
+			t.Errorf("Expected a run at %s, got:\n%s", at.Format(time.DateTime), out.String())
+		}
+	}
+
+	runs, err := history.Load(path)
+	if err != nil {
+		t.Fatalf("Failed to load the history: %v", err)
+	}
+	var times []time.Time
+	for _, run := range runs {
+		times = append(times, run.Time)
+	}
+	want := []time.Time{start.Add(time.Minute).UTC(), start.Add(16 * time.Minute).UTC()}
+	if !slices.EqualFunc(times, want, time.Time.Equal) {
+		t.Errorf("Expected the runs recorded at their virtual end %v, got %v", want, times)
+	}
+}
+
+func TestRunScheduleSession(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	data, err := os.ReadFile("../../testdata/relays.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	relaysPath := filepath.Join(t.TempDir(), "relays.json")
+	if err := os.WriteFile(relaysPath, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("MULLVAD_COMPASS_RELAYS_FILE", relaysPath)
+
+	var loads int
+	factory := ping.NewMockPingerFactory()
+	session, err := compass.NewSession(
+		ctx,
+		compass.WithPingerFactory(factory),
+		compass.WithRelaysLoader(func(context.Context) (*relays.File, error) {
+			loads++
+			return relays.ParseRelaysFile(relaysPath)
+		}),
+		compass.WithLocator(nil),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	defer func() { _ = session.Close() }()
+
+	// The Mullvad app updates relays.json after the first run, and the third run is cancelled
+	var calls int
+	var out bytes.Buffer
+	configPath := tempConfigPath(t)
+	deps := sessionDependencies(session, Dependencies{
+		GetUserLocation: func(context.Context, logging.LogLevel) (*api.UserLocation, error) {
+			calls++
+			switch calls {
+			case 1:
+				now := time.Now()
+				if err := os.Chtimes(relaysPath, now, now); err != nil {
+					t.Fatal(err)
+				}
+			case 3:
+				cancel()
+				return nil, context.Canceled
+			}
+			return &api.UserLocation{Latitude: 51.0514, Longitude: 13.7341}, nil // Dresden
+		},
+		PingLocations: func(context.Context, []relays.Location, int, int, relays.IPVersion, logging.LogLevel) ([]relays.Location, error) {
+			t.Error("Expected the servers to be pinged through the session")
+			return nil, errors.New("unexpected ping")
+		},
+		ParseRelaysFile: func(context.Context, logging.LogLevel, string, func() (string, error)) (*relays.File, error) {
+			t.Error("Expected relays.json to be parsed by the session")
+			return nil, errors.New("unexpected parse")
+		},
+		ConfigPath: configPath,
+		Stdout:     &out,
+	})
+
+	config, err := cli.ParseFlags([]string{"--every", "15m"}, "dev")
+	if err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+
+	ticks := make(chan time.Time, 2)
+	ticks <- time.Now()
+	ticks <- time.Now()
+	if err := runSchedule(ctx, config, deps, schedule{ticks: ticks}); err != nil {
+		t.Fatalf("Expected the schedule to end without an error, got: %v", err)
+	}
+	if loads != 2 {
+		t.Errorf("Expected relays.json to be parsed again only once it changed, got %d parses", loads)
+	}
+	if pingers := factory.GetCreatePingerCalls(); len(pingers) != 1 {
+		t.Errorf("Expected the runs to share a pinger, got %d", len(pingers))
+	}
+
+	path, err := configPath(history.File)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runs, err := history.Load(path)
+	if err != nil {
+		t.Fatalf("Failed to load the history: %v", err)
+	}
+	if len(runs) != 2 || len(runs[1].Servers) == 0 || runs[1].Servers[0].Latency == nil {
+		t.Errorf("Expected two runs of pinged servers in the history, got %+v", runs)
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// reloadSignal and dumpSignal make a schedule reload its config and log its last run
+var reloadSignal, dumpSignal os.Signal = syscall.SIGHUP, syscall.SIGUSR1
@@ -0,0 +1,95 @@
+//go:build !windows
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/Ch00k/mullvad-compass/internal/api"
+	"github.com/Ch00k/mullvad-compass/internal/cli"
+	"github.com/Ch00k/mullvad-compass/internal/logging"
+	"github.com/Ch00k/mullvad-compass/internal/relays"
+)
+
+func TestRunScheduleSignals(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var logBuf bytes.Buffer
+	log.SetOutput(&logBuf)
+	defer log.SetOutput(os.Stderr)
+
+	// The first run succeeds and the second is cancelled
+	var calls, reloads int
+	var out bytes.Buffer
+	deps := Dependencies{
+		GetUserLocation: func(context.Context, logging.LogLevel) (*api.UserLocation, error) {
+			calls++
+			if calls == 2 {
+				cancel()
+				return nil, context.Canceled
+			}
+			return &api.UserLocation{Latitude: 51.0514, Longitude: 13.7341}, nil // Dresden
+		},
+		PingLocations: func(_ context.Context, locs []relays.Location, _, _ int, _ relays.IPVersion, _ logging.LogLevel) ([]relays.Location, error) {
+			for i := range locs {
+				latency := 10.0 + float64(i)
+				locs[i].Latency = &latency
+			}
+			return locs, nil
+		},
+		ParseRelaysFile: func(_ context.Context, _ logging.LogLevel, _ string, _ func() (string, error)) (*relays.File, error) {
+			return relays.ParseRelaysFile("../../testdata/relays.json")
+		},
+		ConfigPath: tempConfigPath(t),
+		Stdout:     &out,
+	}
+
+	config, err := cli.ParseFlags([]string{"--every", "15m"}, "dev")
+	if err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+
+	ticks := make(chan time.Time)
+	signals := make(chan os.Signal)
+	done := make(chan error, 1)
+	go func() {
+		done <- runSchedule(ctx, config, deps, schedule{
+			ticks:   ticks,
+			signals: signals,
+			reload: func(context.Context) (*cli.Config, error) {
+				reloads++
+				return cli.ParseFlags([]string{"--every", "15m", "--precision", "0"}, "dev")
+			},
+		})
+	}()
+
+	// Each send waits for the previous signal to be handled, and none of them starts a run
+	signals <- syscall.SIGHUP
+	signals <- syscall.SIGUSR1
+	ticks <- time.Now()
+	if err := <-done; err != nil {
+		t.Fatalf("Expected the schedule to end without an error, got: %v", err)
+	}
+
+	if reloads != 1 {
+		t.Errorf("Expected SIGHUP to reload once, got %d reloads", reloads)
+	}
+	if calls != 2 {
+		t.Errorf("Expected the signals not to start runs, got %d runs", calls)
+	}
+	logs := logBuf.String()
+	if !strings.Contains(logs, "Last run at") || !strings.Contains(logs, "cz-prg-wg-") {
+		t.Errorf("Expected SIGUSR1 to log the last run, got:\n%s", logs)
+	}
+	if !strings.Contains(logs, " 10 ms") {
+		t.Errorf("Expected the last run to be logged with the reloaded --precision, got:\n%s", logs)
+	}
+}
@@ -0,0 +1,8 @@
+//go:build windows
+
+package main
+
+import "os"
+
+// reloadSignal and dumpSignal are nil, as Windows delivers neither SIGHUP nor SIGUSR1
+var reloadSignal, dumpSignal os.Signal
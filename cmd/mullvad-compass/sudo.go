@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/Ch00k/mullvad-compass/internal/cli"
+	"github.com/Ch00k/mullvad-compass/internal/elevate"
+	"github.com/Ch00k/mullvad-compass/internal/logging"
+)
+
+// checkPrivileges reports the first socket of the run that is not permitted, nil when all of them are
+func checkPrivileges(config *cli.Config) error {
+	return elevate.Check(config.IPVersion, config.Asymmetry)
+}
+
+// confirmOnTerminal asks the question on stderr and reads the answer from stdin. Without a terminal on stdin the
+// answer is no, so that a scheduled run does not wait for it.
+func confirmOnTerminal(question string) bool {
+	info, err := os.Stdin.Stat()
+	if err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+	fmt.Fprint(os.Stderr, question)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	default:
+		return false
+	}
+}
+
+// pingsRelays reports whether the command pings the relays, and so needs the sockets --sudo checks
+func pingsRelays(config *cli.Config) bool {
+	return config.Command == "" || config.Command == cli.CommandApply
+}
+
+// elevateIfNeeded runs mullvad-compass again with args as root for --sudo when the sockets of the run are not
+// permitted and the user agrees, and reports whether it did. The re-executed run replaces this one, so it only
+// returns from doing so on failure. When the user declines, the permission error is returned, so that the run
+// fails as it would have without --sudo.
+func elevateIfNeeded(config *cli.Config, deps Dependencies, args []string) (bool, error) {
+	if deps.CheckPrivileges == nil {
+		return false, nil
+	}
+	missing := deps.CheckPrivileges(config)
+	if missing == nil {
+		return false, nil
+	}
+	if config.LogLevel <= logging.LogLevelDebug {
+		log.Printf("Sockets not permitted: %v", missing)
+	}
+	if !deps.Confirm(fmt.Sprintf("Error: %v\nRun mullvad-compass again as root with sudo or pkexec? [y/N] ", missing)) {
+		return false, missing
+	}
+	return true, deps.Elevate(args)
+}
@@ -2,48 +2,38 @@ package main
 
 import (
 	"context"
-	"log"
-	"time"
+	"fmt"
 
 	"github.com/Ch00k/mullvad-compass/internal/api"
 	"github.com/Ch00k/mullvad-compass/internal/distance"
 	"github.com/Ch00k/mullvad-compass/internal/formatter"
 	"github.com/Ch00k/mullvad-compass/internal/logging"
+	"github.com/Ch00k/mullvad-compass/internal/rank"
 	"github.com/Ch00k/mullvad-compass/internal/relays"
+	"github.com/Ch00k/mullvad-compass/internal/timing"
+	"github.com/Ch00k/mullvad-compass/internal/warnings"
 )
 
-// getUserLocation fetches user location with optional debug timing
+// getUserLocation fetches user location, timed as the geoip phase
 func getUserLocation(
 	ctx context.Context,
+	timings *timing.Collector,
 	logLevel logging.LogLevel,
 	getUserLocationFn func(context.Context, logging.LogLevel) (*api.UserLocation, error),
 ) (*api.UserLocation, error) {
-	start := time.Now()
-	defer func() {
-		if logLevel <= logging.LogLevelDebug {
-			elapsed := time.Since(start)
-			log.Printf("User location fetch completed in %v", elapsed)
-		}
-	}()
+	defer timings.Start(timing.PhaseGeoIP, "User location fetch")()
 
 	return getUserLocationFn(ctx, logLevel)
 }
 
-// parseRelaysFile parses the relays JSON file with optional debug timing
+// parseRelaysFile parses the relays JSON file
 // If path is empty, it will attempt to find the default relays.json location
 func parseRelaysFile(
+	ctx context.Context,
 	logLevel logging.LogLevel,
 	path string,
 	getRelaysPathFn func() (string, error),
 ) (*relays.File, error) {
-	start := time.Now()
-	defer func() {
-		if logLevel <= logging.LogLevelDebug {
-			elapsed := time.Since(start)
-			log.Printf("Parse relays file completed in %v", elapsed)
-		}
-	}()
-
 	// If no path provided, try to find default location
 	if path == "" {
 		defaultPath, err := getRelaysPathFn()
@@ -53,86 +43,147 @@ func parseRelaysFile(
 		path = defaultPath
 	}
 
-	return relays.ParseRelaysFileWithLogLevel(path, logLevel)
+	return relays.ParseRelaysFileContext(ctx, path, logLevel)
 }
 
-// getLocations fetches and filters relay locations with optional debug timing
+// validateRelayEndpoints checks relay entry addresses, skipping malformed ones unless strict is set, and relays at
+// loopback, link-local or multicast addresses unless allowPrivate is set
+func validateRelayEndpoints(ctx context.Context, relaysData *relays.File, strict, allowPrivate bool) error {
+	skipped, err := relays.ValidateEndpoints(relaysData, strict)
+	if err != nil {
+		return fmt.Errorf("invalid relays file: %w", err)
+	}
+
+	if skipped > 0 {
+		warnings.FromContext(ctx).Add(
+			warnings.SkippedRelays,
+			"%d relay endpoint(s) skipped due to malformed address",
+			skipped,
+		)
+	}
+
+	if !allowPrivate {
+		if removed := relays.RemoveRestricted(relaysData); removed > 0 {
+			warnings.FromContext(ctx).Add(
+				warnings.SkippedRelays,
+				"%d relay(s) skipped due to a loopback, link-local or multicast address (see --allow-private)",
+				removed,
+			)
+		}
+	}
+
+	return nil
+}
+
+// getLocations fetches and filters relay locations, timed as the filter phase
 func getLocations(
-	logLevel logging.LogLevel,
+	ctx context.Context,
+	timings *timing.Collector,
 	relaysData *relays.File,
 	antiCensorship relays.AntiCensorship,
 	daita bool,
+	exclude relays.Feature,
 	ipVersion relays.IPVersion,
 ) ([]relays.Location, error) {
-	start := time.Now()
-	defer func() {
-		if logLevel <= logging.LogLevelDebug {
-			elapsed := time.Since(start)
-			log.Printf("Get locations completed in %v", elapsed)
-		}
-	}()
+	defer timings.Start(timing.PhaseFilter, "Get locations")()
 
-	locations, skipped, err := relays.GetLocations(relaysData, antiCensorship, daita, ipVersion)
+	locations, skipped, err := relays.GetLocationsContext(ctx, relaysData, antiCensorship, daita, exclude, ipVersion)
 	if err != nil {
 		return nil, err
 	}
 
-	if skipped > 0 && logLevel <= logging.LogLevelWarning {
-		log.Printf("Warning: %d relay(s) skipped due to unresolvable location key", skipped)
+	if skipped > 0 {
+		warnings.FromContext(ctx).Add(
+			warnings.SkippedRelays,
+			"%d relay(s) skipped due to unresolvable location key",
+			skipped,
+		)
 	}
 
 	return locations, nil
 }
 
-// filterByDistance filters locations by distance with optional debug timing
+// filterByDistance filters locations by distance, timed as the filter phase
 func filterByDistance(
+	ctx context.Context,
+	timings *timing.Collector,
 	logLevel logging.LogLevel,
 	locations []relays.Location,
 	userLat, userLon, maxDistance float64,
-) []relays.Location {
-	start := time.Now()
-	defer func() {
-		if logLevel <= logging.LogLevelDebug {
-			elapsed := time.Since(start)
-			log.Printf("Filter by distance completed in %v", elapsed)
-		}
-	}()
+) ([]relays.Location, error) {
+	defer timings.Start(timing.PhaseFilter, "Filter by distance")()
+
+	return distance.FilterByDistanceContext(ctx, locations, userLat, userLon, maxDistance, logLevel)
+}
+
+// newDistanceIndex indexes locations by distance, timed as the filter phase
+func newDistanceIndex(
+	timings *timing.Collector,
+	locations []relays.Location,
+	userLat, userLon float64,
+) *distance.Index {
+	defer timings.Start(timing.PhaseFilter, fmt.Sprintf("Distance index of %d locations", len(locations)))()
 
-	return distance.FilterByDistanceWithLogLevel(locations, userLat, userLon, maxDistance, logLevel)
+	return distance.NewIndex(locations, userLat, userLon)
 }
 
-// pingLocations pings locations with optional debug timing
+// pingLocations pings locations, timed as the ping phase
 func pingLocations(
 	ctx context.Context,
+	timings *timing.Collector,
 	logLevel logging.LogLevel,
 	locations []relays.Location,
 	timeout, workers int,
 	ipVersion relays.IPVersion,
 	pingLocationsFn func(context.Context, []relays.Location, int, int, relays.IPVersion, logging.LogLevel) ([]relays.Location, error),
 ) ([]relays.Location, error) {
-	start := time.Now()
-	defer func() {
-		if logLevel <= logging.LogLevelDebug {
-			elapsed := time.Since(start)
-			log.Printf("Ping locations completed in %v", elapsed)
-		}
-	}()
+	defer timings.Start(timing.PhasePing, "Ping locations")()
 
 	return pingLocationsFn(ctx, locations, timeout, workers, ipVersion, logLevel)
 }
 
-// sortLocationsByLatency sorts locations by latency with optional debug timing
+// sortLocationsByLatency sorts locations by latency, timed as the sort phase
 func sortLocationsByLatency(
-	logLevel logging.LogLevel,
+	timings *timing.Collector,
 	locations []relays.Location,
 ) {
-	start := time.Now()
-	defer func() {
-		if logLevel <= logging.LogLevelDebug {
-			elapsed := time.Since(start)
-			log.Printf("Sort locations by latency completed in %v", elapsed)
-		}
-	}()
+	defer timings.Start(timing.PhaseSort, "Sort locations by latency")()
 
 	formatter.SortLocationsByLatency(locations)
 }
+
+// sortLocationsByScore sorts locations by the scores of a ranking, timed as the sort phase
+func sortLocationsByScore(
+	timings *timing.Collector,
+	locations []relays.Location,
+	scorer rank.Scorer,
+) {
+	defer timings.Start(timing.PhaseSort, "Sort locations by score")()
+
+	rank.Sort(locations, scorer)
+}
+
+// getBridgeLocations fetches bridge relay locations, timed as the filter phase
+func getBridgeLocations(
+	ctx context.Context,
+	timings *timing.Collector,
+	relaysData *relays.File,
+	ipVersion relays.IPVersion,
+) ([]relays.Location, error) {
+	defer timings.Start(timing.PhaseFilter, "Get bridge locations")()
+
+	locations, skipped, err := relays.GetBridgeLocationsContext(ctx, relaysData, ipVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	if skipped > 0 {
+		warnings.FromContext(ctx).Add(
+			warnings.SkippedRelays,
+			"%d bridge relay(s) skipped due to unresolvable location key",
+			skipped,
+		)
+	}
+
+	return locations, nil
+}
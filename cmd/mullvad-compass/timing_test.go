@@ -4,7 +4,9 @@ import (
 	"bytes"
 	"context"
 	"errors"
+	"io"
 	"log"
+	"os"
 	"strings"
 	"testing"
 
@@ -12,6 +14,7 @@ import (
 	"github.com/Ch00k/mullvad-compass/internal/formatter"
 	"github.com/Ch00k/mullvad-compass/internal/logging"
 	"github.com/Ch00k/mullvad-compass/internal/relays"
+	"github.com/Ch00k/mullvad-compass/internal/timing"
 )
 
 func TestGetUserLocation(t *testing.T) {
@@ -25,6 +28,7 @@ func TestGetUserLocation(t *testing.T) {
 
 		result, err := getUserLocation(
 			context.Background(),
+			newCollector(logging.LogLevelError),
 			logging.LogLevelError,
 			func(context.Context, logging.LogLevel) (*api.UserLocation, error) {
 				return expectedLoc, nil
@@ -43,6 +47,7 @@ func TestGetUserLocation(t *testing.T) {
 
 		result, err := getUserLocation(
 			context.Background(),
+			newCollector(logging.LogLevelError),
 			logging.LogLevelError,
 			func(context.Context, logging.LogLevel) (*api.UserLocation, error) {
 				return nil, expectedErr
@@ -64,6 +69,7 @@ func TestGetUserLocation(t *testing.T) {
 
 		_, _ = getUserLocation(
 			context.Background(),
+			newCollector(logging.LogLevelDebug),
 			logging.LogLevelDebug,
 			func(context.Context, logging.LogLevel) (*api.UserLocation, error) {
 				return &api.UserLocation{}, nil
@@ -83,6 +89,7 @@ func TestGetUserLocation(t *testing.T) {
 
 		_, _ = getUserLocation(
 			context.Background(),
+			newCollector(logging.LogLevelError),
 			logging.LogLevelError,
 			func(context.Context, logging.LogLevel) (*api.UserLocation, error) {
 				return &api.UserLocation{}, nil
@@ -97,38 +104,52 @@ func TestGetUserLocation(t *testing.T) {
 }
 
 func TestParseRelaysFile(t *testing.T) {
-	t.Run("Logs timing at debug level", func(t *testing.T) {
-		var logBuf bytes.Buffer
-		log.SetOutput(&logBuf)
-		defer log.SetOutput(nil)
+	t.Run("Resolves the default path when none is given", func(t *testing.T) {
+		log.SetOutput(io.Discard)
+		defer log.SetOutput(os.Stderr)
 
+		var resolved bool
 		mockGetRelaysPath := func() (string, error) {
+			resolved = true
 			return "/nonexistent/path", nil
 		}
 
-		// This will fail because the path doesn't exist, but we just want to verify logging
-		_, _ = parseRelaysFile(logging.LogLevelDebug, "/nonexistent/path", mockGetRelaysPath)
-
-		logOutput := logBuf.String()
-		if !strings.Contains(logOutput, "Parse relays file completed in") {
-			t.Errorf("Expected timing log message, got: %s", logOutput)
+		_, err := parseRelaysFile(context.Background(), logging.LogLevelError, "", mockGetRelaysPath)
+		if !resolved {
+			t.Error("Expected the default path to be resolved")
+		}
+		if err == nil {
+			t.Error("Expected an error for a nonexistent relays file")
 		}
 	})
 
-	t.Run("Does not log timing at error level", func(t *testing.T) {
-		var logBuf bytes.Buffer
-		log.SetOutput(&logBuf)
-		defer log.SetOutput(nil)
+	t.Run("Stops when the context is cancelled", func(t *testing.T) {
+		log.SetOutput(io.Discard)
+		defer log.SetOutput(os.Stderr)
 
-		mockGetRelaysPath := func() (string, error) {
-			return "/nonexistent/path", nil
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := parseRelaysFile(ctx, logging.LogLevelError, "../../testdata/relays.json", relays.GetRelaysFilePath)
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Expected context.Canceled, got %v", err)
 		}
+	})
 
-		_, _ = parseRelaysFile(logging.LogLevelError, "/nonexistent/path", mockGetRelaysPath)
+	t.Run("Uses the given path", func(t *testing.T) {
+		mockGetRelaysPath := func() (string, error) {
+			t.Error("Did not expect the default path to be resolved")
+			return "", nil
+		}
 
-		logOutput := logBuf.String()
-		if strings.Contains(logOutput, "Parse relays file completed in") {
-			t.Errorf("Did not expect timing log at error level, got: %s", logOutput)
+		_, err := parseRelaysFile(
+			context.Background(),
+			logging.LogLevelError,
+			"../../testdata/relays.json",
+			mockGetRelaysPath,
+		)
+		if err != nil {
+			t.Errorf("Expected no error, got %v", err)
 		}
 	})
 }
@@ -143,10 +164,12 @@ func TestGetLocations(t *testing.T) {
 		relaysData := &relays.File{}
 
 		_, _ = getLocations(
-			logging.LogLevelDebug,
+			context.Background(),
+			newCollector(logging.LogLevelDebug),
 			relaysData,
 			relays.ACNone,
 			false,
+			0,
 			relays.IPv4,
 		)
 
@@ -164,10 +187,12 @@ func TestGetLocations(t *testing.T) {
 		relaysData := &relays.File{}
 
 		_, _ = getLocations(
-			logging.LogLevelError,
+			context.Background(),
+			newCollector(logging.LogLevelError),
 			relaysData,
 			relays.ACNone,
 			false,
+			0,
 			relays.IPv4,
 		)
 
@@ -189,7 +214,9 @@ func TestFilterByDistance(t *testing.T) {
 			},
 		}
 
-		result := filterByDistance(
+		result, _ := filterByDistance(
+			context.Background(),
+			newCollector(logging.LogLevelError),
 			logging.LogLevelError,
 			locations,
 			59.3293,
@@ -209,7 +236,9 @@ func TestFilterByDistance(t *testing.T) {
 		defer log.SetOutput(nil)
 
 		locations := []relays.Location{}
-		_ = filterByDistance(
+		_, _ = filterByDistance(
+			context.Background(),
+			newCollector(logging.LogLevelDebug),
 			logging.LogLevelDebug,
 			locations,
 			0.0,
@@ -229,7 +258,9 @@ func TestFilterByDistance(t *testing.T) {
 		defer log.SetOutput(nil)
 
 		locations := []relays.Location{}
-		_ = filterByDistance(
+		_, _ = filterByDistance(
+			context.Background(),
+			newCollector(logging.LogLevelError),
 			logging.LogLevelError,
 			locations,
 			0.0,
@@ -254,7 +285,7 @@ func TestSortLocationsByLatency(t *testing.T) {
 			{Country: "Germany", City: "Berlin", Latency: &latency2},
 		}
 
-		sortLocationsByLatency(logging.LogLevelError, locations)
+		sortLocationsByLatency(newCollector(logging.LogLevelError), locations)
 
 		if locations[0].Country != "Germany" {
 			t.Errorf("Expected first location to be Germany, got %s", locations[0].Country)
@@ -270,7 +301,7 @@ func TestSortLocationsByLatency(t *testing.T) {
 		defer log.SetOutput(nil)
 
 		locations := []relays.Location{}
-		sortLocationsByLatency(logging.LogLevelDebug, locations)
+		sortLocationsByLatency(newCollector(logging.LogLevelDebug), locations)
 
 		logOutput := logBuf.String()
 		if !strings.Contains(logOutput, "Sort locations by latency completed in") {
@@ -284,7 +315,7 @@ func TestSortLocationsByLatency(t *testing.T) {
 		defer log.SetOutput(nil)
 
 		locations := []relays.Location{}
-		sortLocationsByLatency(logging.LogLevelError, locations)
+		sortLocationsByLatency(newCollector(logging.LogLevelError), locations)
 
 		logOutput := logBuf.String()
 		if strings.Contains(logOutput, "Sort locations by latency completed in") {
@@ -310,7 +341,7 @@ func TestFormatTableTiming(t *testing.T) {
 			},
 		}
 
-		result := formatter.FormatTable(locations, false)
+		result := formatter.FormatTable(locations, formatter.DefaultOptions())
 
 		if !strings.Contains(result, "Germany") {
 			t.Error("Expected table to contain 'Germany'")
@@ -334,6 +365,7 @@ func TestPingLocations(t *testing.T) {
 		locations := []relays.Location{{Country: "Test", City: "Test"}}
 		_, _ = pingLocations(
 			context.Background(),
+			newCollector(logging.LogLevelDebug),
 			logging.LogLevelDebug,
 			locations,
 			1000,
@@ -360,6 +392,7 @@ func TestPingLocations(t *testing.T) {
 		locations := []relays.Location{{Country: "Test", City: "Test"}}
 		_, _ = pingLocations(
 			context.Background(),
+			newCollector(logging.LogLevelError),
 			logging.LogLevelError,
 			locations,
 			1000,
@@ -394,7 +427,7 @@ func TestTotalOperationTiming(t *testing.T) {
 			PingLocations: func(_ context.Context, locs []relays.Location, _, _ int, _ relays.IPVersion, _ logging.LogLevel) ([]relays.Location, error) {
 				return locs, nil
 			},
-			ParseRelaysFile: func(_ logging.LogLevel, _ string, _ func() (string, error)) (*relays.File, error) {
+			ParseRelaysFile: func(_ context.Context, _ logging.LogLevel, _ string, _ func() (string, error)) (*relays.File, error) {
 				return &relays.File{}, nil
 			},
 			Stdout: &output,
@@ -427,7 +460,7 @@ func TestTotalOperationTiming(t *testing.T) {
 			PingLocations: func(_ context.Context, locs []relays.Location, _, _ int, _ relays.IPVersion, _ logging.LogLevel) ([]relays.Location, error) {
 				return locs, nil
 			},
-			ParseRelaysFile: func(_ logging.LogLevel, _ string, _ func() (string, error)) (*relays.File, error) {
+			ParseRelaysFile: func(_ context.Context, _ logging.LogLevel, _ string, _ func() (string, error)) (*relays.File, error) {
 				return &relays.File{}, nil
 			},
 			Stdout: &output,
@@ -460,7 +493,7 @@ func TestTotalOperationTiming(t *testing.T) {
 			PingLocations: func(_ context.Context, locs []relays.Location, _, _ int, _ relays.IPVersion, _ logging.LogLevel) ([]relays.Location, error) {
 				return locs, nil
 			},
-			ParseRelaysFile: func(_ logging.LogLevel, _ string, _ func() (string, error)) (*relays.File, error) {
+			ParseRelaysFile: func(_ context.Context, _ logging.LogLevel, _ string, _ func() (string, error)) (*relays.File, error) {
 				return &relays.File{}, nil
 			},
 			Stdout: &output,
@@ -476,3 +509,8 @@ func TestTotalOperationTiming(t *testing.T) {
 		}
 	})
 }
+
+// newCollector returns a timing collector logging step durations at the given level
+func newCollector(level logging.LogLevel) *timing.Collector {
+	return timing.New(timing.WithLogLevel(level))
+}
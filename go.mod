@@ -5,3 +5,5 @@ go 1.24.0
 require golang.org/x/net v0.47.0
 
 require golang.org/x/sys v0.38.0
+
+require github.com/oschwald/maxminddb-golang v1.13.1
@@ -3,6 +3,8 @@ package api
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -11,25 +13,37 @@ import (
 	"strings"
 	"time"
 
+	"github.com/Ch00k/mullvad-compass/internal/clock"
+	"github.com/Ch00k/mullvad-compass/internal/errs"
 	"github.com/Ch00k/mullvad-compass/internal/logging"
+	"github.com/Ch00k/mullvad-compass/internal/resolve"
 )
 
 const (
-	defaultAPIURL     = "https://am.i.mullvad.net/json"
-	defaultTimeout    = 10 * time.Second
-	defaultMaxRetries = 3
-	defaultRetryDelay = 1 * time.Second
-	defaultVersion    = "dev"
+	defaultAPIURL           = "https://am.i.mullvad.net/json"
+	defaultDNSLeakURLFormat = "https://%s.dnsleak.am.i.mullvad.net/"
+	defaultTimeout          = 10 * time.Second
+	defaultMaxRetries       = 3
+	defaultRetryDelay       = 1 * time.Second
+	defaultVersion          = "dev"
 )
 
 // Client encapsulates the HTTP client for interacting with the Mullvad API
 type Client struct {
 	httpClient *http.Client
 	url        string
+	dnsLeakURL string // empty means a random subdomain of the default DNS leak endpoint
+	relaysURL  string
 	maxRetries int
-	retryDelay time.Duration
-	version    string
-	logLevel   logging.LogLevel
+	retryDelay time.Duration // Delay before the first retry
+
+	backoffMultiplier float64
+	maxRetryDelay     time.Duration
+	jitter            float64
+
+	version   string
+	userAgent string // empty means mullvad-compass/version
+	logLevel  logging.LogLevel
 }
 
 // ClientOption is a function that configures a Client
@@ -42,6 +56,13 @@ func WithURL(url string) ClientOption {
 	}
 }
 
+// WithDNSLeakURL sets a custom DNS leak check URL
+func WithDNSLeakURL(url string) ClientOption {
+	return func(c *Client) {
+		c.dnsLeakURL = url
+	}
+}
+
 // WithTimeout sets a custom timeout for HTTP requests
 func WithTimeout(timeout time.Duration) ClientOption {
 	return func(c *Client) {
@@ -56,7 +77,7 @@ func WithMaxRetries(maxRetries int) ClientOption {
 	}
 }
 
-// WithRetryDelay sets the initial delay between retries
+// WithRetryDelay sets the delay before the first retry, which grows by the backoff multiplier after every attempt
 func WithRetryDelay(delay time.Duration) ClientOption {
 	return func(c *Client) {
 		c.retryDelay = delay
@@ -70,6 +91,13 @@ func WithVersion(version string) ClientOption {
 	}
 }
 
+// WithUserAgent replaces the User-Agent header, which otherwise names mullvad-compass and its version
+func WithUserAgent(userAgent string) ClientOption {
+	return func(c *Client) {
+		c.userAgent = userAgent
+	}
+}
+
 // WithLogLevel sets the log level for the client
 func WithLogLevel(logLevel logging.LogLevel) ClientOption {
 	return func(c *Client) {
@@ -84,10 +112,16 @@ func NewClient(opts ...ClientOption) *Client {
 			Timeout: defaultTimeout,
 		},
 		url:        defaultAPIURL,
+		relaysURL:  defaultRelaysURL,
 		maxRetries: defaultMaxRetries,
 		retryDelay: defaultRetryDelay,
-		version:    defaultVersion,
-		logLevel:   logging.LogLevelError,
+
+		backoffMultiplier: defaultBackoffMultiplier,
+		maxRetryDelay:     defaultMaxRetryDelay,
+		jitter:            defaultJitter,
+
+		version:  defaultVersion,
+		logLevel: logging.LogLevelError,
 	}
 
 	for _, opt := range opts {
@@ -99,18 +133,64 @@ func NewClient(opts ...ClientOption) *Client {
 
 // UserLocation represents the response from Mullvad's location API
 type UserLocation struct {
-	IP            string  `json:"ip"`
-	Latitude      float64 `json:"latitude"`
-	Longitude     float64 `json:"longitude"`
-	Country       string  `json:"country"`
-	City          string  `json:"city"`
-	MullvadExitIP bool    `json:"mullvad_exit_ip"`
+	IP                    string      `json:"ip"`
+	Latitude              float64     `json:"latitude"`
+	Longitude             float64     `json:"longitude"`
+	Country               string      `json:"country"`
+	City                  string      `json:"city"`
+	MullvadExitIP         bool        `json:"mullvad_exit_ip"`
+	MullvadExitIPHostname string      `json:"mullvad_exit_ip_hostname"`
+	MullvadServerType     string      `json:"mullvad_server_type"`
+	Organization          string      `json:"organization"`
+	Blacklisted           Blacklisted `json:"blacklisted"`
+}
+
+// Blacklisted represents the blacklist status of the exit IP
+type Blacklisted struct {
+	Blacklisted bool              `json:"blacklisted"`
+	Results     []BlacklistResult `json:"results"`
+}
+
+// BlacklistResult represents the status of the exit IP on a single blacklist
+type BlacklistResult struct {
+	Name        string `json:"name"`
+	Link        string `json:"link"`
+	Blacklisted bool   `json:"blacklisted"`
+}
+
+// DNSServer represents a DNS server reported by Mullvad's DNS leak endpoint
+type DNSServer struct {
+	IP                 string `json:"ip"`
+	Country            string `json:"country"`
+	Organization       string `json:"organization"`
+	MullvadDNS         bool   `json:"mullvad_dns"`
+	MullvadDNSHostname string `json:"mullvad_dns_hostname"`
+}
+
+// ConnectionCheck combines the exit IP details with the DNS servers in use
+type ConnectionCheck struct {
+	Location   UserLocation
+	DNSServers []DNSServer
+}
+
+// DNSLeak reports whether DNS queries bypass Mullvad's DNS servers while connected to Mullvad
+func (c *ConnectionCheck) DNSLeak() bool {
+	if !c.Location.MullvadExitIP {
+		return false
+	}
+	for _, server := range c.DNSServers {
+		if !server.MullvadDNS {
+			return true
+		}
+	}
+	return false
 }
 
 // Error represents a structured error from the API client
 type Error struct {
 	StatusCode int
 	Retriable  bool
+	RetryAfter time.Duration // Delay requested by the server with Retry-After, 0 if none
 	Err        error
 }
 
@@ -135,44 +215,112 @@ func isRetriableStatusCode(statusCode int) bool {
 
 // GetUserLocation fetches the user's current geographic location from Mullvad API
 func (c *Client) GetUserLocation(ctx context.Context) (*UserLocation, error) {
+	location, err := doJSON[UserLocation](ctx, c, c.url, "user location")
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errs.ErrAPILocation, err)
+	}
+
+	if c.logLevel <= logging.LogLevelInfo {
+		log.Printf(
+			"Successfully fetched user location: %s, %s (%.4f, %.4f)",
+			location.City,
+			location.Country,
+			location.Latitude,
+			location.Longitude,
+		)
+	}
+
+	return location, nil
+}
+
+// GetDNSServers fetches the DNS servers that resolved a unique hostname on Mullvad's DNS leak endpoint. The hostname
+// is always looked up with the system resolver, which is the one being checked for leaks.
+func (c *Client) GetDNSServers(ctx context.Context) ([]DNSServer, error) {
+	ctx = resolve.WithSystemResolver(ctx)
+	url := c.dnsLeakURL
+	if url == "" {
+		url = fmt.Sprintf(defaultDNSLeakURLFormat, randomLabel())
+	}
+
+	servers, err := doJSON[[]DNSServer](ctx, c, url, "DNS servers")
+	if err != nil {
+		return nil, err
+	}
+
+	if c.logLevel <= logging.LogLevelInfo {
+		log.Printf("Successfully fetched %d DNS servers", len(*servers))
+	}
+
+	return *servers, nil
+}
+
+// CheckConnection fetches the exit IP details and the DNS servers in use
+func (c *Client) CheckConnection(ctx context.Context) (*ConnectionCheck, error) {
+	location, err := c.GetUserLocation(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	servers, err := c.GetDNSServers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ConnectionCheck{Location: *location, DNSServers: servers}, nil
+}
+
+// doJSON fetches the JSON document at url and decodes it into a T, retrying transient failures with exponential
+// backoff. Every endpoint goes through it, so that they share the retry policy, User-Agent, and content-type checks.
+// what names the document in logs and errors.
+func doJSON[T any](ctx context.Context, c *Client, url, what string) (*T, error) {
+	var v *T
+	err := c.withRetries(ctx, url, what, func() error {
+		// A fresh value for every attempt, so that a partial decode does not leak into the next one
+		v = new(T)
+		return c.doGetJSON(ctx, url, what, v)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// withRetries runs attempt until it succeeds, fails with a non-retriable error, or runs out of retries
+func (c *Client) withRetries(ctx context.Context, url, what string, attempt func() error) error {
 	var lastErr error
 
 	if c.logLevel <= logging.LogLevelDebug {
-		log.Printf("Fetching user location from %s (max retries: %d)", c.url, c.maxRetries)
+		log.Printf("Fetching %s from %s (max retries: %d)", what, url, c.maxRetries)
 	}
 
-	for attempt := 0; attempt <= c.maxRetries; attempt++ {
-		if attempt > 0 {
-			delay := c.retryDelay * time.Duration(1<<uint(attempt-1))
+	for i := 0; i <= c.maxRetries; i++ {
+		if i > 0 {
+			delay := c.retryDelayFor(i, lastErr)
 			if c.logLevel <= logging.LogLevelWarning {
-				log.Printf("Retrying API request (attempt %d/%d) after %v delay", attempt+1, c.maxRetries+1, delay)
+				log.Printf("Retrying API request (attempt %d/%d) after %v delay", i+1, c.maxRetries+1, delay)
 			}
 			select {
-			case <-time.After(delay):
+			case <-clock.FromContext(ctx).After(delay):
 			case <-ctx.Done():
 				if c.logLevel <= logging.LogLevelError {
 					log.Printf("API request cancelled: %v", ctx.Err())
 				}
-				return nil, ctx.Err()
+				return ctx.Err()
 			}
 		}
 
-		location, err := c.doGetUserLocation(ctx)
+		err := attempt()
 		if err == nil {
-			if c.logLevel <= logging.LogLevelInfo {
-				log.Printf(
-					"Successfully fetched user location: %s, %s (%.4f, %.4f)",
-					location.City,
-					location.Country,
-					location.Latitude,
-					location.Longitude,
-				)
-			}
-			return location, nil
+			return nil
 		}
 
 		lastErr = err
 
+		// Network access is forbidden for the whole run, retrying cannot help
+		if errors.Is(err, errs.ErrOffline) {
+			break
+		}
+
 		// Check if it's a structured APIError
 		var apiErr *Error
 		if errors.As(err, &apiErr) {
@@ -183,42 +331,63 @@ func (c *Client) GetUserLocation(ctx context.Context) (*UserLocation, error) {
 				break
 			}
 			if c.logLevel <= logging.LogLevelWarning {
-				log.Printf("Retriable API error on attempt %d: %v", attempt+1, apiErr)
+				log.Printf("Retriable API error on attempt %d: %v", i+1, apiErr)
 			}
 			continue
 		}
 
 		// For non-APIError errors (like network errors), retry
 		if c.logLevel <= logging.LogLevelWarning {
-			log.Printf("Network error on attempt %d: %v", attempt+1, err)
+			log.Printf("Network error on attempt %d: %v", i+1, err)
 		}
 		continue
 	}
 
 	if c.logLevel <= logging.LogLevelError {
-		log.Printf("Failed to fetch user location after %d attempts: %v", c.maxRetries+1, lastErr)
+		log.Printf("Failed to fetch %s after %d attempts: %v", what, c.maxRetries+1, lastErr)
+	}
+	return fmt.Errorf("failed after %d attempts: %w", c.maxRetries+1, lastErr)
+}
+
+// userAgentHeader returns the User-Agent header sent with every request
+func (c *Client) userAgentHeader() string {
+	if c.userAgent != "" {
+		return c.userAgent
+	}
+	return fmt.Sprintf("mullvad-compass/%s", c.version)
+}
+
+// statusError returns the error for an unexpected HTTP status, with the delay requested by Retry-After on 429 and 503
+func statusError(resp *http.Response, now time.Time) *Error {
+	apiErr := &Error{
+		StatusCode: resp.StatusCode,
+		Retriable:  isRetriableStatusCode(resp.StatusCode),
+		Err:        fmt.Errorf("unexpected status code %d", resp.StatusCode),
 	}
-	return nil, fmt.Errorf("failed after %d attempts: %w", c.maxRetries+1, lastErr)
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+		apiErr.RetryAfter = parseRetryAfter(resp.Header.Get("Retry-After"), now)
+	}
+	return apiErr
 }
 
-// doGetUserLocation performs a single attempt to fetch the user location
-func (c *Client) doGetUserLocation(ctx context.Context) (*UserLocation, error) {
-	req, err := http.NewRequest(http.MethodGet, c.url, nil)
+// doGetJSON performs a single attempt to fetch a JSON document into v
+func (c *Client) doGetJSON(ctx context.Context, url, what string, v any) error {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
 	if err != nil {
 		if c.logLevel <= logging.LogLevelError {
 			log.Printf("Failed to create HTTP request: %v", err)
 		}
-		return nil, &Error{
+		return &Error{
 			Retriable: false,
 			Err:       fmt.Errorf("failed to create request: %w", err),
 		}
 	}
 
 	req = req.WithContext(ctx)
-	req.Header.Set("User-Agent", fmt.Sprintf("mullvad-compass/%s", c.version))
+	req.Header.Set("User-Agent", c.userAgentHeader())
 
 	if c.logLevel <= logging.LogLevelDebug {
-		log.Printf("Sending GET request to %s", c.url)
+		log.Printf("Sending GET request to %s", url)
 	}
 
 	resp, err := c.httpClient.Do(req)
@@ -226,7 +395,7 @@ func (c *Client) doGetUserLocation(ctx context.Context) (*UserLocation, error) {
 		if c.logLevel <= logging.LogLevelError {
 			log.Printf("HTTP request failed: %v", err)
 		}
-		return nil, fmt.Errorf("failed to fetch user location: %w", err)
+		return fmt.Errorf("failed to fetch %s: %w", what, err)
 	}
 	defer func() { _ = resp.Body.Close() }()
 
@@ -240,11 +409,7 @@ func (c *Client) doGetUserLocation(ctx context.Context) (*UserLocation, error) {
 		if c.logLevel <= logging.LogLevelWarning {
 			log.Printf("Unexpected HTTP status code: %d (retriable: %v)", resp.StatusCode, retriable)
 		}
-		return nil, &Error{
-			StatusCode: resp.StatusCode,
-			Retriable:  retriable,
-			Err:        fmt.Errorf("unexpected status code %d", resp.StatusCode),
-		}
+		return statusError(resp, clock.FromContext(ctx).Now())
 	}
 
 	// Check content type
@@ -253,24 +418,30 @@ func (c *Client) doGetUserLocation(ctx context.Context) (*UserLocation, error) {
 		if c.logLevel <= logging.LogLevelError {
 			log.Printf("Unexpected content type: %s (expected application/json)", contentType)
 		}
-		return nil, &Error{
+		return &Error{
 			Retriable: false,
 			Err:       fmt.Errorf("unexpected content-type: %s (expected application/json)", contentType),
 		}
 	}
 
-	var location UserLocation
-	if err := json.NewDecoder(resp.Body).Decode(&location); err != nil {
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
 		if c.logLevel <= logging.LogLevelError {
 			log.Printf("Failed to parse JSON response: %v", err)
 		}
-		return nil, &Error{
+		return &Error{
 			Retriable: false,
 			Err:       fmt.Errorf("failed to parse API response: %w", err),
 		}
 	}
 
-	return &location, nil
+	return nil
+}
+
+// randomLabel returns a random DNS label so that every DNS leak check triggers a fresh lookup
+func randomLabel() string {
+	buf := make([]byte, 16)
+	_, _ = rand.Read(buf)
+	return hex.EncodeToString(buf)
 }
 
 // GetUserLocation is a convenience function that uses the default client
@@ -3,11 +3,16 @@ package api
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"slices"
 	"strings"
 	"testing"
 	"time"
+
+	"github.com/Ch00k/mullvad-compass/internal/clock"
+	"github.com/Ch00k/mullvad-compass/internal/errs"
 )
 
 func TestClient_GetUserLocation_Success(t *testing.T) {
@@ -119,15 +124,20 @@ func TestClient_GetUserLocation_RetryOnTransientFailure(t *testing.T) {
 	}))
 	defer server.Close()
 
+	clk := clock.NewFake(time.Now())
 	client := NewClient(
 		WithURL(server.URL),
 		WithMaxRetries(3),
-		WithRetryDelay(1*time.Millisecond),
+		WithRetryDelay(time.Second),
+		WithJitter(0),
 	)
-	location, err := client.GetUserLocation(context.Background())
+	location, err := client.GetUserLocation(clock.WithClock(context.Background(), clk))
 	if err != nil {
 		t.Fatalf("Expected success after retries, got error: %v", err)
 	}
+	if waits := clk.Waits(); !slices.Equal(waits, []time.Duration{time.Second, 2 * time.Second}) {
+		t.Errorf("Expected the retry delay to double, waited %v", waits)
+	}
 	if location.IP != "1.2.3.4" {
 		t.Errorf("Expected IP 1.2.3.4, got %s", location.IP)
 	}
@@ -144,19 +154,30 @@ func TestClient_GetUserLocation_ExhaustedRetries(t *testing.T) {
 	}))
 	defer server.Close()
 
+	clk := clock.NewFake(time.Now())
 	client := NewClient(
 		WithURL(server.URL),
 		WithMaxRetries(2),
-		WithRetryDelay(1*time.Millisecond),
+		WithJitter(0),
 	)
-	_, err := client.GetUserLocation(context.Background())
+	_, err := client.GetUserLocation(clock.WithClock(context.Background(), clk))
 
 	if err == nil {
 		t.Fatal("Expected error after exhausting retries, got nil")
 	}
+	if !errors.Is(err, errs.ErrAPILocation) {
+		t.Errorf("Expected errs.ErrAPILocation, got: %v", err)
+	}
+	var apiErr *Error
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Expected the API error to be wrapped, got: %v", err)
+	}
 	if attemptCount != 3 { // Initial attempt + 2 retries
 		t.Errorf("Expected 3 attempts (1 initial + 2 retries), got %d", attemptCount)
 	}
+	if waits := clk.Waits(); !slices.Equal(waits, []time.Duration{defaultRetryDelay, 2 * defaultRetryDelay}) {
+		t.Errorf("Expected the default backoff, waited %v", waits)
+	}
 }
 
 func TestClient_GetUserLocation_InvalidJSON(t *testing.T) {
@@ -194,6 +215,24 @@ func TestClient_GetUserLocation_Timeout(t *testing.T) {
 	}
 }
 
+func TestClient_GetUserLocation_CustomUserAgent(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if userAgent := r.Header.Get("User-Agent"); userAgent != "curl/8.5.0" {
+			t.Errorf("Expected User-Agent 'curl/8.5.0', got: %s", userAgent)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(w).Encode(UserLocation{IP: "1.2.3.4"})
+	}))
+	defer server.Close()
+
+	client := NewClient(WithURL(server.URL), WithVersion("1.2.3"), WithUserAgent("curl/8.5.0"))
+	if _, err := client.GetUserLocation(context.Background()); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+}
+
 func TestClient_GetUserLocation_CustomVersion(t *testing.T) {
 	customVersion := "1.2.3"
 	expectedUserAgent := "mullvad-compass/1.2.3"
@@ -222,3 +261,174 @@ func TestClient_GetUserLocation_CustomVersion(t *testing.T) {
 		t.Fatalf("Expected no error, got: %v", err)
 	}
 }
+
+func TestClient_GetDNSServers_Success(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`[
+			{"ip": "10.64.0.1", "country": "Germany", "organization": "31173 Services AB",
+			 "mullvad_dns": true, "mullvad_dns_hostname": "de-ber-dns-001"},
+			{"ip": "203.0.113.53", "country": "Germany", "organization": "Example ISP", "mullvad_dns": false}
+		]`))
+	}))
+	defer server.Close()
+
+	client := NewClient(WithDNSLeakURL(server.URL))
+	servers, err := client.GetDNSServers(context.Background())
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	if len(servers) != 2 {
+		t.Fatalf("Expected 2 DNS servers, got %d", len(servers))
+	}
+	if !servers[0].MullvadDNS || servers[0].MullvadDNSHostname != "de-ber-dns-001" {
+		t.Errorf("Unexpected first DNS server: %+v", servers[0])
+	}
+	if servers[1].MullvadDNS || servers[1].Organization != "Example ISP" {
+		t.Errorf("Unexpected second DNS server: %+v", servers[1])
+	}
+}
+
+func TestClient_CheckConnection(t *testing.T) {
+	locationServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{
+			"ip": "193.32.248.66", "country": "Germany", "city": "Berlin",
+			"mullvad_exit_ip": true, "mullvad_exit_ip_hostname": "de-ber-wg-001",
+			"mullvad_server_type": "WireGuard", "organization": "31173 Services AB",
+			"blacklisted": {"blacklisted": true, "results": [{"name": "Spamhaus", "link": "https://example.com", "blacklisted": true}]}
+		}`))
+	}))
+	defer locationServer.Close()
+
+	dnsServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`[{"ip": "203.0.113.53", "mullvad_dns": false}]`))
+	}))
+	defer dnsServer.Close()
+
+	client := NewClient(WithURL(locationServer.URL), WithDNSLeakURL(dnsServer.URL))
+	check, err := client.CheckConnection(context.Background())
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	if check.Location.MullvadExitIPHostname != "de-ber-wg-001" {
+		t.Errorf("Expected exit hostname de-ber-wg-001, got %s", check.Location.MullvadExitIPHostname)
+	}
+	if !check.Location.Blacklisted.Blacklisted || len(check.Location.Blacklisted.Results) != 1 {
+		t.Errorf("Unexpected blacklist status: %+v", check.Location.Blacklisted)
+	}
+	if !check.DNSLeak() {
+		t.Error("Expected DNS leak when connected and using a non-Mullvad DNS server")
+	}
+}
+
+func TestDoJSON(t *testing.T) {
+	type status struct {
+		Version string   `json:"version"`
+		Regions []string `json:"regions"`
+	}
+
+	attemptCount := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attemptCount++
+		if r.Header.Get("User-Agent") != "mullvad-compass/1.0.0" {
+			t.Errorf("Expected User-Agent 'mullvad-compass/1.0.0', got: %s", r.Header.Get("User-Agent"))
+		}
+		if attemptCount == 1 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		_, _ = w.Write([]byte(`{"version": "2", "regions": ["eu", "us"]}`))
+	}))
+	defer server.Close()
+
+	client := NewClient(WithVersion("1.0.0"), WithRetryDelay(time.Millisecond))
+	got, err := doJSON[status](context.Background(), client, server.URL, "status")
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if got.Version != "2" || len(got.Regions) != 2 || got.Regions[1] != "us" {
+		t.Errorf("Unexpected status: %+v", got)
+	}
+	if attemptCount != 2 {
+		t.Errorf("Expected 2 attempts, got %d", attemptCount)
+	}
+}
+
+func TestDoJSON_NonRetriable(t *testing.T) {
+	attemptCount := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		attemptCount++
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = w.Write([]byte("<html></html>"))
+	}))
+	defer server.Close()
+
+	client := NewClient(WithRetryDelay(time.Millisecond))
+	got, err := doJSON[map[string]int](context.Background(), client, server.URL, "counts")
+	if err == nil || !strings.Contains(err.Error(), "unexpected content-type") {
+		t.Errorf("Expected a content-type error, got: %v", err)
+	}
+	if got != nil {
+		t.Errorf("Expected no value on error, got: %v", *got)
+	}
+	if attemptCount != 1 {
+		t.Errorf("Expected a single attempt, got %d", attemptCount)
+	}
+}
+
+func TestConnectionCheck_DNSLeak(t *testing.T) {
+	testCases := []struct {
+		name     string
+		check    ConnectionCheck
+		expected bool
+	}{
+		{
+			name:     "Not connected to Mullvad",
+			check:    ConnectionCheck{DNSServers: []DNSServer{{IP: "203.0.113.53"}}},
+			expected: false,
+		},
+		{
+			name: "Connected with Mullvad DNS only",
+			check: ConnectionCheck{
+				Location:   UserLocation{MullvadExitIP: true},
+				DNSServers: []DNSServer{{IP: "10.64.0.1", MullvadDNS: true}},
+			},
+			expected: false,
+		},
+		{
+			name: "Connected with third-party DNS",
+			check: ConnectionCheck{
+				Location:   UserLocation{MullvadExitIP: true},
+				DNSServers: []DNSServer{{IP: "10.64.0.1", MullvadDNS: true}, {IP: "203.0.113.53"}},
+			},
+			expected: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.check.DNSLeak(); got != tc.expected {
+				t.Errorf("Expected DNSLeak() = %v, got %v", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestRandomLabel(t *testing.T) {
+	a := randomLabel()
+	b := randomLabel()
+	if len(a) != 32 {
+		t.Errorf("Expected 32 hex characters, got %q", a)
+	}
+	if a == b {
+		t.Error("Expected random labels to differ")
+	}
+}
@@ -0,0 +1,70 @@
+package api
+
+import (
+	"errors"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultBackoffMultiplier = 2.0
+	defaultMaxRetryDelay     = 30 * time.Second
+	defaultJitter            = 0.2
+)
+
+// WithBackoffMultiplier sets the factor the retry delay grows by after every attempt
+func WithBackoffMultiplier(multiplier float64) ClientOption {
+	return func(c *Client) {
+		c.backoffMultiplier = multiplier
+	}
+}
+
+// WithMaxRetryDelay caps the delay between retries, including delays requested with Retry-After
+func WithMaxRetryDelay(delay time.Duration) ClientOption {
+	return func(c *Client) {
+		c.maxRetryDelay = delay
+	}
+}
+
+// WithJitter randomizes every retry delay by up to the given fraction in either direction (0 disables jitter), so
+// that clients failing together do not retry together
+func WithJitter(fraction float64) ClientOption {
+	return func(c *Client) {
+		c.jitter = fraction
+	}
+}
+
+// retryDelayFor returns the delay before retry number retry (starting at 1) after err. A Retry-After delay sent
+// with err is honored instead of the backoff, without jitter. Either is capped at the maximum retry delay.
+func (c *Client) retryDelayFor(retry int, err error) time.Duration {
+	var apiErr *Error
+	if errors.As(err, &apiErr) && apiErr.RetryAfter > 0 {
+		return min(apiErr.RetryAfter, c.maxRetryDelay)
+	}
+
+	delay := float64(c.retryDelay) * math.Pow(c.backoffMultiplier, float64(retry-1))
+	if c.jitter > 0 {
+		delay *= 1 + c.jitter*(2*rand.Float64()-1)
+	}
+	return min(time.Duration(delay), c.maxRetryDelay)
+}
+
+// parseRetryAfter returns the delay requested by a Retry-After header, given in seconds or as an HTTP date.
+// Returns 0 when the header is missing, malformed, or in the past.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return max(time.Duration(seconds)*time.Second, 0)
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(date.Sub(now), 0)
+	}
+	return 0
+}
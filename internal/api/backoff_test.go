@@ -0,0 +1,107 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Ch00k/mullvad-compass/internal/clock"
+)
+
+func TestRetryDelayFor(t *testing.T) {
+	client := NewClient(
+		WithRetryDelay(100*time.Millisecond),
+		WithBackoffMultiplier(3),
+		WithMaxRetryDelay(time.Second),
+		WithJitter(0),
+	)
+	networkErr := errors.New("connection reset")
+
+	tests := []struct {
+		name  string
+		retry int
+		err   error
+		want  time.Duration
+	}{
+		{"First retry", 1, networkErr, 100 * time.Millisecond},
+		{"Grows by the multiplier", 3, networkErr, 900 * time.Millisecond},
+		{"Capped at the maximum", 4, networkErr, time.Second},
+		{"Retry-After", 1, &Error{RetryAfter: 500 * time.Millisecond}, 500 * time.Millisecond},
+		{"Retry-After capped at the maximum", 1, &Error{RetryAfter: time.Minute}, time.Second},
+		{"Status error without Retry-After", 2, &Error{StatusCode: 503}, 300 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := client.retryDelayFor(tt.retry, tt.err); got != tt.want {
+				t.Errorf("retryDelayFor(%d) = %v, want %v", tt.retry, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryDelayForJitter(t *testing.T) {
+	client := NewClient(WithRetryDelay(time.Second), WithJitter(0.5))
+
+	seen := make(map[time.Duration]bool)
+	for range 100 {
+		delay := client.retryDelayFor(1, nil)
+		if delay < 500*time.Millisecond || delay > 1500*time.Millisecond {
+			t.Fatalf("Expected a delay within 50%% of 1s, got %v", delay)
+		}
+		seen[delay] = true
+	}
+	if len(seen) < 2 {
+		t.Error("Expected jitter to vary the delay")
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"", 0},
+		{"7", 7 * time.Second},
+		{"-3", 0},
+		{now.Add(90 * time.Second).Format(http.TimeFormat), 90 * time.Second},
+		{now.Add(-time.Hour).Format(http.TimeFormat), 0},
+		{"soon", 0},
+	}
+	for _, tt := range tests {
+		if got := parseRetryAfter(tt.value, now); got != tt.want {
+			t.Errorf("parseRetryAfter(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestClient_GetUserLocation_HonorsRetryAfter(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	var attempts []time.Time
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		attempts = append(attempts, clk.Now())
+		if len(attempts) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"ip": "1.2.3.4"}`))
+	}))
+	defer server.Close()
+
+	client := NewClient(WithURL(server.URL), WithRetryDelay(time.Millisecond))
+	if _, err := client.GetUserLocation(clock.WithClock(context.Background(), clk)); err != nil {
+		t.Fatalf("Expected success after retrying, got: %v", err)
+	}
+	if len(attempts) != 2 {
+		t.Fatalf("Expected 2 attempts, got %d", len(attempts))
+	}
+	if waited := attempts[1].Sub(attempts[0]); waited != time.Second {
+		t.Errorf("Expected the retry to wait for Retry-After (1s), waited %v", waited)
+	}
+}
@@ -0,0 +1,187 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Ch00k/mullvad-compass/internal/clock"
+	"github.com/Ch00k/mullvad-compass/internal/logging"
+	"github.com/Ch00k/mullvad-compass/internal/relays"
+)
+
+const (
+	defaultRelaysURL = "https://api.mullvad.net/app/v1/relays"
+	// maxRelaysSize bounds the download, matching the size limit of the relays parser
+	maxRelaysSize = 32 << 20
+)
+
+// WithRelaysURL sets a custom relay list URL
+func WithRelaysURL(url string) ClientOption {
+	return func(c *Client) {
+		c.relaysURL = url
+	}
+}
+
+// cacheValidators are the response headers a cached download is revalidated with
+type cacheValidators struct {
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+}
+
+// validatorsPath returns the path of the file holding the validators of a cached download
+func validatorsPath(path string) string {
+	return path + ".validators"
+}
+
+// DownloadRelays downloads Mullvad's relay list to path and returns whether the file changed. An existing file
+// is revalidated with the ETag and Last-Modified of its download, so an unchanged relay list is not downloaded
+// again.
+func (c *Client) DownloadRelays(ctx context.Context, path string) (bool, error) {
+	validators := readValidators(path)
+
+	var updated bool
+	err := c.withRetries(ctx, c.relaysURL, "relay list", func() error {
+		var err error
+		updated, err = c.doDownload(ctx, path, validators)
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+
+	if c.logLevel <= logging.LogLevelInfo {
+		if updated {
+			log.Printf("Downloaded relay list to %s", path)
+		} else {
+			log.Printf("Relay list at %s is up to date", path)
+		}
+	}
+	return updated, nil
+}
+
+// readValidators returns the validators of the download at path, none when the file or its validators are missing
+func readValidators(path string) cacheValidators {
+	if _, err := os.Stat(path); err != nil {
+		return cacheValidators{}
+	}
+	data, err := os.ReadFile(validatorsPath(path))
+	if err != nil {
+		return cacheValidators{}
+	}
+	var validators cacheValidators
+	if err := json.Unmarshal(data, &validators); err != nil {
+		return cacheValidators{}
+	}
+	return validators
+}
+
+// doDownload performs a single conditional request for the relay list
+func (c *Client) doDownload(ctx context.Context, path string, validators cacheValidators) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.relaysURL, nil)
+	if err != nil {
+		return false, &Error{Retriable: false, Err: fmt.Errorf("failed to create request: %w", err)}
+	}
+	req.Header.Set("User-Agent", c.userAgentHeader())
+	if validators.ETag != "" {
+		req.Header.Set("If-None-Match", validators.ETag)
+	}
+	if validators.LastModified != "" {
+		req.Header.Set("If-Modified-Since", validators.LastModified)
+	}
+
+	if c.logLevel <= logging.LogLevelDebug {
+		log.Printf("Sending GET request to %s (ETag: %q, Last-Modified: %q)",
+			c.relaysURL, validators.ETag, validators.LastModified)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("failed to fetch relay list: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if c.logLevel <= logging.LogLevelDebug {
+		log.Printf("Received HTTP %d response", resp.StatusCode)
+	}
+
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		return false, nil
+	case http.StatusOK:
+	default:
+		return false, statusError(resp, clock.FromContext(ctx).Now())
+	}
+
+	// An error page served with status 200, e.g. by a captive portal, must not replace the cached relay list
+	contentType := resp.Header.Get("Content-Type")
+	if !strings.Contains(contentType, "application/json") {
+		return false, &Error{
+			Retriable: false,
+			Err:       fmt.Errorf("unexpected content-type: %s (expected application/json)", contentType),
+		}
+	}
+
+	if err := writeDownload(ctx, path, resp.Body, c.logLevel); err != nil {
+		return false, err
+	}
+
+	fresh := cacheValidators{ETag: resp.Header.Get("ETag"), LastModified: resp.Header.Get("Last-Modified")}
+	data, err := json.Marshal(fresh)
+	if err != nil {
+		return false, &Error{Err: fmt.Errorf("failed to encode cache validators: %w", err)}
+	}
+	if err := os.WriteFile(validatorsPath(path), data, 0o644); err != nil {
+		// Without validators the next run downloads the relay list again, which is only slower
+		if c.logLevel <= logging.LogLevelWarning {
+			log.Printf("Warning: failed to save cache validators: %v", err)
+		}
+	}
+	return true, nil
+}
+
+// writeDownload replaces the file at path with the body, through a temporary file so that an interrupted
+// download never leaves a truncated relay list behind. The body must parse as a relay list with active WireGuard
+// relays.
+func writeDownload(ctx context.Context, path string, body io.Reader, logLevel logging.LogLevel) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return &Error{Err: fmt.Errorf("failed to create relay cache directory: %w", err)}
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return &Error{Err: fmt.Errorf("failed to create relay cache file: %w", err)}
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+
+	n, err := io.Copy(tmp, io.LimitReader(body, maxRelaysSize+1))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		// A broken connection is worth retrying
+		return fmt.Errorf("failed to download relay list: %w", err)
+	}
+	if n > maxRelaysSize {
+		return &Error{Err: errors.New("relay list exceeds the maximum size")}
+	}
+
+	file, err := relays.ParseRelaysFileContext(ctx, tmp.Name(), logLevel)
+	if err != nil {
+		return &Error{Err: fmt.Errorf("downloaded relay list is invalid: %w", err)}
+	}
+	if len(file.WireGuard.Relays) == 0 {
+		return &Error{Err: errors.New("downloaded relay list holds no active WireGuard relays")}
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return &Error{Err: fmt.Errorf("failed to save relay list: %w", err)}
+	}
+	return nil
+}
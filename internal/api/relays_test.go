@@ -0,0 +1,155 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClient_DownloadRelays_Revalidates(t *testing.T) {
+	const lastModified = "Wed, 01 Jan 2025 00:00:00 GMT"
+	body := `{"wireguard": {"relays": [{"hostname": "se-got-wg-001", "active": true, "include_in_country": true}]}}`
+	etag := `"v1"`
+	var requests []*http.Request
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r)
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Last-Modified", lastModified)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(body))
+	}))
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "cache", "relays.json")
+	client := NewClient(WithRelaysURL(server.URL), WithRetryDelay(time.Millisecond))
+
+	download := func(wantUpdated bool) {
+		t.Helper()
+		updated, err := client.DownloadRelays(context.Background(), path)
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if updated != wantUpdated {
+			t.Errorf("Expected updated %v, got %v", wantUpdated, updated)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil || string(data) != body {
+			t.Errorf("Expected the cached relay list %q, got %q (%v)", body, data, err)
+		}
+	}
+
+	download(true)
+	if r := requests[0]; r.Header.Get("If-None-Match") != "" || r.Header.Get("If-Modified-Since") != "" {
+		t.Errorf("Expected an unconditional first request, got %v", r.Header)
+	}
+
+	download(false)
+	if r := requests[1]; r.Header.Get("If-None-Match") != etag || r.Header.Get("If-Modified-Since") != lastModified {
+		t.Errorf("Expected a conditional request, got %v", r.Header)
+	}
+
+	body = `{"wireguard": {"relays": [{"hostname": "se-got-wg-002", "active": true, "include_in_country": true}]}}`
+	etag = `"v2"`
+	download(true)
+
+	// Validators are ignored once the cached file is gone
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	download(true)
+	if r := requests[3]; r.Header.Get("If-None-Match") != "" {
+		t.Errorf("Expected an unconditional request without a cached file, got %v", r.Header)
+	}
+}
+
+func TestClient_DownloadRelays_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		wantErr string
+	}{
+		{
+			"Not found",
+			func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusNotFound) },
+			"status 404",
+		},
+		{
+			"Too large",
+			func(w http.ResponseWriter, _ *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				_, _ = w.Write([]byte(strings.Repeat(" ", maxRelaysSize+1)))
+			},
+			"exceeds the maximum size",
+		},
+		{
+			"Not JSON",
+			func(w http.ResponseWriter, _ *http.Request) {
+				w.Header().Set("Content-Type", "text/html")
+				_, _ = w.Write([]byte("<html>Log in to continue</html>"))
+			},
+			"unexpected content-type",
+		},
+		{
+			"Malformed",
+			func(w http.ResponseWriter, _ *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				_, _ = w.Write([]byte(`{"wireguard": {"relays": [`))
+			},
+			"relay list is invalid",
+		},
+		{
+			"No relays",
+			func(w http.ResponseWriter, _ *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				_, _ = w.Write([]byte(`{"error": "maintenance"}`))
+			},
+			"holds no active WireGuard relays",
+		},
+		{
+			"No active relays",
+			func(w http.ResponseWriter, _ *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				_, _ = w.Write([]byte(`{"wireguard": {"relays": [{"hostname": "se-got-wg-001", "active": false}]}}`))
+			},
+			"holds no active WireGuard relays",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(tt.handler)
+			defer server.Close()
+
+			dir := t.TempDir()
+			path := filepath.Join(dir, "relays.json")
+			const cached = `{"wireguard": {"relays": [` +
+				`{"hostname": "se-got-wg-001", "active": true, "include_in_country": true}]}}`
+			if err := os.WriteFile(path, []byte(cached), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			client := NewClient(WithRelaysURL(server.URL), WithMaxRetries(0))
+			_, err := client.DownloadRelays(context.Background(), path)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Expected error containing %q, got: %v", tt.wantErr, err)
+			}
+
+			if data, err := os.ReadFile(path); err != nil || string(data) != cached {
+				t.Errorf("Expected the cached relay list to be kept, got %q (%v)", data, err)
+			}
+			entries, _ := os.ReadDir(dir)
+			if len(entries) != 1 {
+				t.Errorf("Expected no files left behind, got %d", len(entries))
+			}
+		})
+	}
+}
@@ -0,0 +1,355 @@
+// Package appsettings reads relay constraints and the cached device location from the Mullvad VPN app.
+package appsettings
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+
+	"github.com/Ch00k/mullvad-compass/internal/logging"
+	"github.com/Ch00k/mullvad-compass/internal/relays"
+)
+
+// Ownership restricts relays by whether Mullvad owns the hardware
+type Ownership int
+
+// Ownership constants
+const (
+	OwnershipAny Ownership = iota // Owned and rented relays
+	MullvadOwned                  // Only relays owned by Mullvad
+	Rented                        // Only rented relays
+)
+
+// Location is a location selected in the app: a country, a city, or a single relay
+type Location struct {
+	CountryCode string
+	CityCode    string // Empty selects the whole country
+	Hostname    string // Empty selects the whole city or country
+}
+
+// Matches reports whether a relay lies within the location
+func (l Location) Matches(loc relays.Location) bool {
+	if l.CountryCode != loc.CountryCode {
+		return false
+	}
+	if l.CityCode != "" && l.CityCode != loc.CityCode {
+		return false
+	}
+	if l.Hostname != "" && l.Hostname != loc.Hostname {
+		return false
+	}
+	return true
+}
+
+// Constraints are the relay constraints for one kind of relay. Empty fields do not constrain.
+type Constraints struct {
+	Locations []Location // Relays matching any of the locations are allowed
+	Providers []string
+	Ownership Ownership
+}
+
+// Filter returns the locations allowed by the constraints
+func (c Constraints) Filter(locations []relays.Location) []relays.Location {
+	var filtered []relays.Location
+	for _, loc := range locations {
+		if c.allows(loc) {
+			filtered = append(filtered, loc)
+		}
+	}
+	return filtered
+}
+
+// allows reports whether a single relay satisfies the constraints
+func (c Constraints) allows(loc relays.Location) bool {
+	switch c.Ownership {
+	case MullvadOwned:
+		if !loc.IsMullvadOwned {
+			return false
+		}
+	case Rented:
+		if loc.IsMullvadOwned {
+			return false
+		}
+	}
+
+	if len(c.Providers) > 0 && !slices.Contains(c.Providers, loc.Provider) {
+		return false
+	}
+
+	if len(c.Locations) == 0 {
+		return true
+	}
+	for _, l := range c.Locations {
+		if l.Matches(loc) {
+			return true
+		}
+	}
+	return false
+}
+
+// Settings are the relay constraints imported from the app
+type Settings struct {
+	Relay          Constraints // WireGuard relay constraints
+	Bridge         Constraints // Bridge relay constraints
+	AntiCensorship relays.AntiCensorship
+}
+
+// GetSettingsFilePath returns the platform-specific path to the app's settings.json
+func GetSettingsFilePath() (string, error) {
+	return GetSettingsFilePathWithLogLevel(logging.LogLevelError)
+}
+
+// GetSettingsFilePathWithLogLevel returns the platform-specific path to the app's settings.json with logging support
+func GetSettingsFilePathWithLogLevel(logLevel logging.LogLevel) (string, error) {
+	var path string
+
+	switch {
+	case os.Getenv("MULLVAD_COMPASS_SETTINGS_FILE") != "":
+		path = os.Getenv("MULLVAD_COMPASS_SETTINGS_FILE")
+	case runtime.GOOS == "linux" || runtime.GOOS == "darwin":
+		path = filepath.Join("/etc/mullvad-vpn", "settings.json")
+	case runtime.GOOS == "windows":
+		systemRoot := os.Getenv("SystemRoot")
+		if systemRoot == "" {
+			systemRoot = "C:\\Windows"
+		}
+		// The daemon runs as SYSTEM and keeps its settings in that account's local app data
+		path = filepath.Join(
+			systemRoot, "System32", "config", "systemprofile", "AppData", "Local", "Mullvad VPN", "settings.json",
+		)
+	default:
+		return "", fmt.Errorf("unsupported platform: %s", runtime.GOOS)
+	}
+
+	if logLevel <= logging.LogLevelDebug {
+		log.Printf("Looking for Mullvad app settings at: %s", path)
+	}
+
+	if _, err := os.Stat(path); err != nil {
+		return "", fmt.Errorf("app settings not readable at %s: %w", path, err)
+	}
+
+	return path, nil
+}
+
+// Load finds and parses the app's settings.json
+func Load(logLevel logging.LogLevel) (*Settings, error) {
+	path, err := GetSettingsFilePathWithLogLevel(logLevel)
+	if err != nil {
+		return nil, err
+	}
+	return ParseSettingsFile(path)
+}
+
+// ParseSettingsFile reads and parses the app's settings.json
+func ParseSettingsFile(path string) (*Settings, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read Mullvad app settings: %w", err)
+	}
+
+	settings, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse Mullvad app settings: %w", err)
+	}
+	return settings, nil
+}
+
+// settingsFile is the subset of the settings.json schema holding relay constraints
+type settingsFile struct {
+	RelaySettings struct {
+		Normal *relayConstraints `json:"normal"`
+	} `json:"relay_settings"`
+	BridgeSettings struct {
+		Normal *relayConstraints `json:"normal"`
+	} `json:"bridge_settings"`
+	ObfuscationSettings struct {
+		SelectedObfuscation string `json:"selected_obfuscation"`
+	} `json:"obfuscation_settings"`
+	CustomLists struct {
+		CustomLists []customList `json:"custom_lists"`
+	} `json:"custom_lists"`
+}
+
+// relayConstraints holds the constraints of the normal relay or bridge settings.
+// Each is either the string "any" or an object with an "only" key.
+type relayConstraints struct {
+	Location  json.RawMessage `json:"location"`
+	Providers json.RawMessage `json:"providers"`
+	Ownership json.RawMessage `json:"ownership"`
+}
+
+// customList is a named list of locations defined in the app
+type customList struct {
+	ID        string               `json:"id"`
+	Name      string               `json:"name"`
+	Locations []geographicLocation `json:"locations"`
+}
+
+// geographicLocation is encoded as {"country": "se"}, {"city": ["se", "got"]},
+// or {"hostname": ["se", "got", "se-got-wg-001"]}
+type geographicLocation struct {
+	Country  string   `json:"country"`
+	City     []string `json:"city"`
+	Hostname []string `json:"hostname"`
+}
+
+// locationConstraint is a geographic location, either nested under "location" (current app versions)
+// or inline (older versions), or a reference to a custom list
+type locationConstraint struct {
+	geographicLocation
+	Location   *geographicLocation `json:"location"`
+	CustomList *struct {
+		ListID string `json:"list_id"`
+	} `json:"custom_list"`
+}
+
+// Parse parses the contents of the app's settings.json
+func Parse(data []byte) (*Settings, error) {
+	var file settingsFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+
+	settings := &Settings{}
+
+	if file.RelaySettings.Normal != nil {
+		c, err := file.RelaySettings.Normal.constraints(file.CustomLists.CustomLists)
+		if err != nil {
+			return nil, fmt.Errorf("relay settings: %w", err)
+		}
+		settings.Relay = c
+	}
+
+	if file.BridgeSettings.Normal != nil {
+		c, err := file.BridgeSettings.Normal.constraints(file.CustomLists.CustomLists)
+		if err != nil {
+			return nil, fmt.Errorf("bridge settings: %w", err)
+		}
+		settings.Bridge = c
+	}
+
+	switch file.ObfuscationSettings.SelectedObfuscation {
+	case "shadowsocks":
+		settings.AntiCensorship = relays.Shadowsocks
+	case "quic":
+		settings.AntiCensorship = relays.QUIC
+	case "lwo":
+		settings.AntiCensorship = relays.LWO
+	}
+
+	return settings, nil
+}
+
+// constraints converts the raw constraints, resolving custom lists
+func (r *relayConstraints) constraints(lists []customList) (Constraints, error) {
+	var c Constraints
+
+	var loc locationConstraint
+	ok, err := decodeOnly(r.Location, &loc)
+	if err != nil {
+		return c, fmt.Errorf("location: %w", err)
+	}
+	if ok {
+		c.Locations, err = loc.resolve(lists)
+		if err != nil {
+			return c, err
+		}
+	}
+
+	var providers struct {
+		Providers []string `json:"providers"`
+	}
+	if _, err := decodeOnly(r.Providers, &providers); err != nil {
+		return c, fmt.Errorf("providers: %w", err)
+	}
+	c.Providers = providers.Providers
+
+	var ownership string
+	if _, err := decodeOnly(r.Ownership, &ownership); err != nil {
+		return c, fmt.Errorf("ownership: %w", err)
+	}
+	switch ownership {
+	case "":
+	case "MullvadOwned":
+		c.Ownership = MullvadOwned
+	case "Rented", "RentedOnly":
+		c.Ownership = Rented
+	default:
+		return c, fmt.Errorf("unknown ownership: %s", ownership)
+	}
+
+	return c, nil
+}
+
+// resolve returns the locations selected by the constraint
+func (l locationConstraint) resolve(lists []customList) ([]Location, error) {
+	if l.CustomList != nil {
+		for _, list := range lists {
+			if list.ID == l.CustomList.ListID {
+				locations := make([]Location, 0, len(list.Locations))
+				for _, geo := range list.Locations {
+					loc, err := geo.location()
+					if err != nil {
+						return nil, fmt.Errorf("custom list %q: %w", list.Name, err)
+					}
+					locations = append(locations, loc)
+				}
+				return locations, nil
+			}
+		}
+		return nil, fmt.Errorf("unknown custom list: %s", l.CustomList.ListID)
+	}
+
+	geo := l.geographicLocation
+	if l.Location != nil {
+		geo = *l.Location
+	}
+	loc, err := geo.location()
+	if err != nil {
+		return nil, err
+	}
+	return []Location{loc}, nil
+}
+
+// location converts the encoded geographic location
+func (g geographicLocation) location() (Location, error) {
+	switch {
+	case len(g.Hostname) == 3:
+		return Location{CountryCode: g.Hostname[0], CityCode: g.Hostname[1], Hostname: g.Hostname[2]}, nil
+	case len(g.City) == 2:
+		return Location{CountryCode: g.City[0], CityCode: g.City[1]}, nil
+	case g.Country != "":
+		return Location{CountryCode: g.Country}, nil
+	default:
+		return Location{}, fmt.Errorf("unrecognized location")
+	}
+}
+
+// decodeOnly decodes a constraint encoded as "any" or {"only": value} into v.
+// Returns false if the constraint is absent or "any".
+func decodeOnly(raw json.RawMessage, v any) (bool, error) {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 || bytes.Equal(raw, []byte("null")) || bytes.Equal(raw, []byte(`"any"`)) {
+		return false, nil
+	}
+
+	var only struct {
+		Only json.RawMessage `json:"only"`
+	}
+	if err := json.Unmarshal(raw, &only); err != nil {
+		return false, err
+	}
+	if only.Only == nil {
+		return false, fmt.Errorf("expected \"any\" or an \"only\" constraint")
+	}
+	if err := json.Unmarshal(only.Only, v); err != nil {
+		return false, err
+	}
+	return true, nil
+}
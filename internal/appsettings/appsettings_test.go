@@ -0,0 +1,227 @@
+package appsettings
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Ch00k/mullvad-compass/internal/relays"
+)
+
+const sampleSettings = `{
+	"relay_settings": {
+		"normal": {
+			"location": {"only": {"location": {"city": ["se", "got"]}}},
+			"providers": {"only": {"providers": ["31173", "M247"]}},
+			"ownership": {"only": "MullvadOwned"},
+			"tunnel_protocol": "wireguard"
+		}
+	},
+	"bridge_settings": {
+		"bridge_type": "normal",
+		"normal": {
+			"location": {"only": {"custom_list": {"list_id": "4c1d"}}},
+			"providers": "any",
+			"ownership": "any"
+		}
+	},
+	"obfuscation_settings": {"selected_obfuscation": "quic"},
+	"custom_lists": {
+		"custom_lists": [
+			{
+				"id": "4c1d",
+				"name": "Nordics",
+				"locations": [{"country": "no"}, {"hostname": ["fi", "hel", "fi-hel-br-001"]}]
+			}
+		]
+	}
+}`
+
+func TestParse(t *testing.T) {
+	settings, err := Parse([]byte(sampleSettings))
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	wantRelay := Constraints{
+		Locations: []Location{{CountryCode: "se", CityCode: "got"}},
+		Providers: []string{"31173", "M247"},
+		Ownership: MullvadOwned,
+	}
+	if !reflect.DeepEqual(settings.Relay, wantRelay) {
+		t.Errorf("Relay constraints = %+v, want %+v", settings.Relay, wantRelay)
+	}
+
+	wantBridge := Constraints{
+		Locations: []Location{
+			{CountryCode: "no"},
+			{CountryCode: "fi", CityCode: "hel", Hostname: "fi-hel-br-001"},
+		},
+	}
+	if !reflect.DeepEqual(settings.Bridge, wantBridge) {
+		t.Errorf("Bridge constraints = %+v, want %+v", settings.Bridge, wantBridge)
+	}
+
+	if settings.AntiCensorship != relays.QUIC {
+		t.Errorf("Expected QUIC anti-censorship, got %v", settings.AntiCensorship)
+	}
+}
+
+func TestParseVariants(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want Settings
+	}{
+		{
+			name: "Unconstrained",
+			data: `{"relay_settings": {"normal": {"location": "any", "providers": "any", "ownership": "any"}},
+				"obfuscation_settings": {"selected_obfuscation": "auto"}}`,
+			want: Settings{},
+		},
+		{
+			name: "Legacy inline country location",
+			data: `{"relay_settings": {"normal": {"location": {"only": {"country": "de"}}}}}`,
+			want: Settings{Relay: Constraints{Locations: []Location{{CountryCode: "de"}}}},
+		},
+		{
+			name: "Rented ownership",
+			data: `{"relay_settings": {"normal": {"ownership": {"only": "Rented"}}}}`,
+			want: Settings{Relay: Constraints{Ownership: Rented}},
+		},
+		{
+			name: "Custom tunnel endpoint has no relay constraints",
+			data: `{"relay_settings": {"custom_tunnel_endpoint": {"host": "10.0.0.1"}},
+				"obfuscation_settings": {"selected_obfuscation": "udp2_tcp"}}`,
+			want: Settings{},
+		},
+		{
+			name: "Shadowsocks obfuscation",
+			data: `{"obfuscation_settings": {"selected_obfuscation": "shadowsocks"}}`,
+			want: Settings{AntiCensorship: relays.Shadowsocks},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse([]byte(tt.data))
+			if err != nil {
+				t.Fatalf("Expected no error, got: %v", err)
+			}
+			if !reflect.DeepEqual(*got, tt.want) {
+				t.Errorf("Parse() = %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{"Invalid JSON", `{`, "unexpected end"},
+		{"Unknown custom list", `{"relay_settings": {"normal": {"location": {"only": {"custom_list": {"list_id": "x"}}}}}}`, "unknown custom list"},
+		{"Unknown ownership", `{"relay_settings": {"normal": {"ownership": {"only": "Leased"}}}}`, "unknown ownership"},
+		{"Empty location", `{"relay_settings": {"normal": {"location": {"only": {}}}}}`, "unrecognized location"},
+		{"Malformed constraint", `{"relay_settings": {"normal": {"providers": {"providers": []}}}}`, "only"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse([]byte(tt.data))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Expected error containing %q, got: %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestConstraintsFilter(t *testing.T) {
+	locations := []relays.Location{
+		{Hostname: "se-got-wg-001", CountryCode: "se", CityCode: "got", Provider: "31173", IsMullvadOwned: true},
+		{Hostname: "se-got-wg-101", CountryCode: "se", CityCode: "got", Provider: "M247"},
+		{Hostname: "se-sto-wg-001", CountryCode: "se", CityCode: "sto", Provider: "31173", IsMullvadOwned: true},
+		{Hostname: "de-ber-wg-001", CountryCode: "de", CityCode: "ber", Provider: "31173", IsMullvadOwned: true},
+	}
+
+	hostnames := func(locs []relays.Location) []string {
+		var names []string
+		for _, loc := range locs {
+			names = append(names, loc.Hostname)
+		}
+		return names
+	}
+
+	tests := []struct {
+		name        string
+		constraints Constraints
+		want        []string
+	}{
+		{"No constraints", Constraints{}, []string{"se-got-wg-001", "se-got-wg-101", "se-sto-wg-001", "de-ber-wg-001"}},
+		{"Country", Constraints{Locations: []Location{{CountryCode: "se"}}}, []string{"se-got-wg-001", "se-got-wg-101", "se-sto-wg-001"}},
+		{"City", Constraints{Locations: []Location{{CountryCode: "se", CityCode: "got"}}}, []string{"se-got-wg-001", "se-got-wg-101"}},
+		{"Hostname", Constraints{Locations: []Location{{CountryCode: "se", CityCode: "sto", Hostname: "se-sto-wg-001"}}}, []string{"se-sto-wg-001"}},
+		{"Several locations", Constraints{Locations: []Location{{CountryCode: "de"}, {CountryCode: "se", CityCode: "sto"}}}, []string{"se-sto-wg-001", "de-ber-wg-001"}},
+		{"Provider", Constraints{Providers: []string{"M247"}}, []string{"se-got-wg-101"}},
+		{"Mullvad owned", Constraints{Ownership: MullvadOwned, Locations: []Location{{CountryCode: "se", CityCode: "got"}}}, []string{"se-got-wg-001"}},
+		{"Rented", Constraints{Ownership: Rented}, []string{"se-got-wg-101"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := hostnames(tt.constraints.Filter(locations))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Filter() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetSettingsFilePath(t *testing.T) {
+	t.Run("Honors env override", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "settings.json")
+		if err := os.WriteFile(path, []byte(sampleSettings), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		t.Setenv("MULLVAD_COMPASS_SETTINGS_FILE", path)
+
+		got, err := GetSettingsFilePath()
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if got != path {
+			t.Errorf("Expected %s, got %s", path, got)
+		}
+	})
+
+	t.Run("Reports missing file", func(t *testing.T) {
+		t.Setenv("MULLVAD_COMPASS_SETTINGS_FILE", filepath.Join(t.TempDir(), "absent.json"))
+
+		if _, err := GetSettingsFilePath(); err == nil {
+			t.Error("Expected error for missing file, got nil")
+		}
+	})
+}
+
+func TestParseSettingsFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "settings.json")
+	if err := os.WriteFile(path, []byte(sampleSettings), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	settings, err := ParseSettingsFile(path)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if len(settings.Relay.Locations) != 1 {
+		t.Errorf("Expected relay location constraint, got %+v", settings.Relay)
+	}
+
+	if _, err := ParseSettingsFile(filepath.Join(t.TempDir(), "absent.json")); err == nil {
+		t.Error("Expected error for missing file, got nil")
+	}
+}
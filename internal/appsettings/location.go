@@ -0,0 +1,80 @@
+package appsettings
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os/exec"
+
+	"github.com/Ch00k/mullvad-compass/internal/api"
+	"github.com/Ch00k/mullvad-compass/internal/logging"
+)
+
+// statusOutput is the subset of "mullvad status --json" holding the location the daemon last looked up.
+// Details is an object in most tunnel states, but a plain string while disconnecting.
+type statusOutput struct {
+	State   string          `json:"state"`
+	Details json.RawMessage `json:"details"`
+}
+
+// geoIPLocation is the daemon's record of the device location
+type geoIPLocation struct {
+	IPv4          *string `json:"ipv4"`
+	IPv6          *string `json:"ipv6"`
+	Country       string  `json:"country"`
+	City          *string `json:"city"`
+	Latitude      float64 `json:"latitude"`
+	Longitude     float64 `json:"longitude"`
+	MullvadExitIP bool    `json:"mullvad_exit_ip"`
+	Hostname      *string `json:"hostname"`
+}
+
+// CachedLocation returns the location the Mullvad daemon last looked up, without a request to the Mullvad API.
+// It is read with the app's "mullvad" command line tool.
+func CachedLocation(ctx context.Context, logLevel logging.LogLevel) (*api.UserLocation, error) {
+	if logLevel <= logging.LogLevelDebug {
+		log.Println("Reading the cached location from mullvad status...")
+	}
+	out, err := exec.CommandContext(ctx, "mullvad", "status", "--location", "--json").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to query the Mullvad app: %w", err)
+	}
+	return ParseStatus(out)
+}
+
+// ParseStatus extracts the device location from the output of "mullvad status --json"
+func ParseStatus(data []byte) (*api.UserLocation, error) {
+	var status statusOutput
+	if err := json.Unmarshal(data, &status); err != nil {
+		return nil, fmt.Errorf("failed to parse Mullvad app status: %w", err)
+	}
+
+	var details struct {
+		Location *geoIPLocation `json:"location"`
+	}
+	if err := json.Unmarshal(status.Details, &details); err != nil || details.Location == nil {
+		return nil, fmt.Errorf("the Mullvad app has no cached location (state: %s)", status.State)
+	}
+
+	geo := details.Location
+	loc := &api.UserLocation{
+		Latitude:      geo.Latitude,
+		Longitude:     geo.Longitude,
+		Country:       geo.Country,
+		MullvadExitIP: geo.MullvadExitIP,
+	}
+	switch {
+	case geo.IPv4 != nil:
+		loc.IP = *geo.IPv4
+	case geo.IPv6 != nil:
+		loc.IP = *geo.IPv6
+	}
+	if geo.City != nil {
+		loc.City = *geo.City
+	}
+	if geo.Hostname != nil {
+		loc.MullvadExitIPHostname = *geo.Hostname
+	}
+	return loc, nil
+}
@@ -0,0 +1,79 @@
+package appsettings
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Ch00k/mullvad-compass/internal/api"
+)
+
+func TestParseStatus(t *testing.T) {
+	tests := []struct {
+		name   string
+		status string
+		want   api.UserLocation
+	}{
+		{
+			name: "Disconnected",
+			status: `{"state": "disconnected", "details": {"location": {
+				"ipv4": "203.0.113.42", "ipv6": null, "country": "Germany", "city": "Dresden",
+				"latitude": 51.0514, "longitude": 13.7341, "mullvad_exit_ip": false, "hostname": null
+			}, "locked_down": false}}`,
+			want: api.UserLocation{
+				IP:        "203.0.113.42",
+				Latitude:  51.0514,
+				Longitude: 13.7341,
+				Country:   "Germany",
+				City:      "Dresden",
+			},
+		},
+		{
+			name: "Connected",
+			status: `{"state": "connected", "details": {"endpoint": {}, "location": {
+				"ipv4": null, "ipv6": "2a03:1b20:5:f011::a01f", "country": "Sweden", "city": "Gothenburg",
+				"latitude": 57.70887, "longitude": 11.97456, "mullvad_exit_ip": true, "hostname": "se-got-wg-001"
+			}}}`,
+			want: api.UserLocation{
+				IP:                    "2a03:1b20:5:f011::a01f",
+				Latitude:              57.70887,
+				Longitude:             11.97456,
+				Country:               "Sweden",
+				City:                  "Gothenburg",
+				MullvadExitIP:         true,
+				MullvadExitIPHostname: "se-got-wg-001",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseStatus([]byte(tt.status))
+			if err != nil {
+				t.Fatalf("Expected no error, got: %v", err)
+			}
+			if !reflect.DeepEqual(*got, tt.want) {
+				t.Errorf("ParseStatus() = %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseStatusErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  string
+		wantErr string
+	}{
+		{"Not JSON", "Disconnected", "failed to parse"},
+		{"No location yet", `{"state": "disconnected", "details": {"location": null}}`, "state: disconnected"},
+		{"Disconnecting", `{"state": "disconnecting", "details": "nothing"}`, "state: disconnecting"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseStatus([]byte(tt.status))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
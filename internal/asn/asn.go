@@ -0,0 +1,66 @@
+// Package asn maps relay addresses to the autonomous system (network operator) announcing them, using a
+// MaxMind DB such as GeoLite2-ASN.
+package asn
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/oschwald/maxminddb-golang"
+
+	"github.com/Ch00k/mullvad-compass/internal/relays"
+)
+
+// Info is the autonomous system an address belongs to
+type Info struct {
+	Number       uint   `maxminddb:"autonomous_system_number"`
+	Organization string `maxminddb:"autonomous_system_organization"`
+}
+
+// DB looks up autonomous systems in a MaxMind DB file
+type DB struct {
+	reader *maxminddb.Reader
+}
+
+// Open opens a MaxMind DB file with autonomous system records, such as GeoLite2-ASN.mmdb
+func Open(path string) (*DB, error) {
+	reader, err := maxminddb.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open ASN database: %w", err)
+	}
+	return &DB{reader: reader}, nil
+}
+
+// Close releases the database file
+func (db *DB) Close() error {
+	return db.reader.Close()
+}
+
+// Lookup returns the autonomous system of an address, or false if the database has no record of it
+func (db *DB) Lookup(addr string) (Info, bool) {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return Info{}, false
+	}
+	var info Info
+	if err := db.reader.Lookup(ip, &info); err != nil || info.Number == 0 {
+		return Info{}, false
+	}
+	return info, true
+}
+
+// Annotate sets the autonomous system of every location from its address for the IP version and returns the
+// number of locations the database had a record for
+func (db *DB) Annotate(locations []relays.Location, ipVersion relays.IPVersion) int {
+	var found int
+	for i := range locations {
+		info, ok := db.Lookup(locations[i].Address(ipVersion))
+		if !ok {
+			continue
+		}
+		locations[i].ASN = info.Number
+		locations[i].ASNOrganization = info.Organization
+		found++
+	}
+	return found
+}
@@ -0,0 +1,182 @@
+package asn
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Ch00k/mullvad-compass/internal/relays"
+)
+
+// mmdbWriter builds a minimal IPv4 MaxMind DB with 24 bit records, enough to exercise lookups
+type mmdbWriter struct {
+	nodes [][2]int // Node index, or -1 for no record, or -2-offset for a data offset
+	data  bytes.Buffer
+}
+
+func newMMDBWriter() *mmdbWriter {
+	return &mmdbWriter{nodes: [][2]int{{-1, -1}}}
+}
+
+// insert maps an IPv4 network to an autonomous system record
+func (w *mmdbWriter) insert(cidr string, number uint32, organization string) {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		panic(err)
+	}
+	prefixLen, _ := network.Mask.Size()
+	ip := network.IP.To4()
+
+	offset := w.data.Len()
+	w.data.WriteByte(7<<5 | 2) // Map with two pairs
+	writeString(&w.data, "autonomous_system_number")
+	writeUint32(&w.data, number)
+	writeString(&w.data, "autonomous_system_organization")
+	writeString(&w.data, organization)
+
+	node := 0
+	for depth := range prefixLen {
+		bit := (ip[depth/8] >> (7 - depth%8)) & 1
+		if depth == prefixLen-1 {
+			w.nodes[node][bit] = -2 - offset
+			break
+		}
+		if w.nodes[node][bit] < 0 {
+			w.nodes = append(w.nodes, [2]int{-1, -1})
+			w.nodes[node][bit] = len(w.nodes) - 1
+		}
+		node = w.nodes[node][bit]
+	}
+}
+
+// writeString encodes a UTF-8 string shorter than 285 bytes
+func writeString(buf *bytes.Buffer, s string) {
+	if len(s) < 29 {
+		buf.WriteByte(2<<5 | byte(len(s)))
+	} else {
+		buf.WriteByte(2<<5 | 29)
+		buf.WriteByte(byte(len(s) - 29))
+	}
+	buf.WriteString(s)
+}
+
+// writeUint16 encodes a uint16
+func writeUint16(buf *bytes.Buffer, v uint16) {
+	buf.WriteByte(5<<5 | 2)
+	_ = binary.Write(buf, binary.BigEndian, v)
+}
+
+// writeUint32 encodes a uint32
+func writeUint32(buf *bytes.Buffer, v uint32) {
+	buf.WriteByte(6<<5 | 4)
+	_ = binary.Write(buf, binary.BigEndian, v)
+}
+
+func (w *mmdbWriter) write(t *testing.T, path string) {
+	t.Helper()
+	nodeCount := len(w.nodes)
+
+	var out bytes.Buffer
+	for _, node := range w.nodes {
+		for _, record := range node {
+			value := record
+			switch {
+			case record == -1:
+				value = nodeCount
+			case record < -1:
+				value = nodeCount + 16 + (-2 - record)
+			}
+			out.Write([]byte{byte(value >> 16), byte(value >> 8), byte(value)})
+		}
+	}
+	out.Write(make([]byte, 16))
+	out.Write(w.data.Bytes())
+
+	out.WriteString("\xab\xcd\xefMaxMind.com")
+	out.WriteByte(7<<5 | 5) // Map with five pairs
+	writeString(&out, "node_count")
+	writeUint32(&out, uint32(nodeCount))
+	writeString(&out, "record_size")
+	writeUint16(&out, 24)
+	writeString(&out, "ip_version")
+	writeUint16(&out, 4)
+	writeString(&out, "binary_format_major_version")
+	writeUint16(&out, 2)
+	writeString(&out, "database_type")
+	writeString(&out, "Test-ASN")
+
+	if err := os.WriteFile(path, out.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func openTestDB(t *testing.T) *DB {
+	t.Helper()
+	w := newMMDBWriter()
+	w.insert("193.32.248.0/24", 39351, "31173 Services AB")
+	w.insert("146.70.0.0/16", 9009, "M247 Europe SRL")
+
+	path := filepath.Join(t.TempDir(), "asn.mmdb")
+	w.write(t, path)
+
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open the test database: %v", err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	return db
+}
+
+func TestLookup(t *testing.T) {
+	db := openTestDB(t)
+
+	tests := []struct {
+		addr   string
+		want   Info
+		wantOK bool
+	}{
+		{"193.32.248.66", Info{39351, "31173 Services AB"}, true},
+		{"146.70.129.130", Info{9009, "M247 Europe SRL"}, true},
+		{"178.249.209.162", Info{}, false},
+		{"not an address", Info{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.addr, func(t *testing.T) {
+			got, ok := db.Lookup(tt.addr)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("Lookup(%q) = %+v, %v, want %+v, %v", tt.addr, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestAnnotate(t *testing.T) {
+	db := openTestDB(t)
+	locations := []relays.Location{
+		{Hostname: "de-ber-wg-001", IPv4Address: "193.32.248.66"},
+		{Hostname: "cz-prg-wg-201", IPv4Address: "178.249.209.162"},
+	}
+
+	if found := db.Annotate(locations, relays.IPv4); found != 1 {
+		t.Errorf("Expected 1 annotated location, got %d", found)
+	}
+	if locations[0].ASN != 39351 || locations[0].ASNOrganization != "31173 Services AB" {
+		t.Errorf("Expected AS39351, got %d %q", locations[0].ASN, locations[0].ASNOrganization)
+	}
+	if locations[1].ASN != 0 {
+		t.Errorf("Expected no ASN for an unknown address, got %d", locations[1].ASN)
+	}
+}
+
+func TestOpenInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "garbage.mmdb")
+	if err := os.WriteFile(path, []byte("not a database"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(path); err == nil {
+		t.Error("Expected an error for an invalid database")
+	}
+}
@@ -0,0 +1,77 @@
+// Package buildinfo describes the build of the running binary, for --version --verbose and the metadata of JSON
+// reports, so that bug reports about platform-specific ping behavior name the exact build.
+package buildinfo
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"strings"
+)
+
+// Info describes a build
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`   // VCS revision, empty when unknown
+	Date      string `json:"date,omitempty"`     // Commit time in RFC 3339, empty when unknown
+	Modified  bool   `json:"modified,omitempty"` // Built from a working tree with uncommitted changes
+	GoVersion string `json:"go_version"`
+	Platform  string `json:"platform"` // GOOS/GOARCH
+}
+
+// readBuildInfo is a variable so tests can substitute the embedded build information
+var readBuildInfo = debug.ReadBuildInfo
+
+// Read returns the build information of the running binary. commit and date are those set with -ldflags -X, if
+// any; empty ones are taken from the VCS information go build embeds when building from a checkout.
+func Read(version, commit, date string) Info {
+	info := Info{
+		Version:   version,
+		Commit:    commit,
+		Date:      date,
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+	}
+
+	bi, ok := readBuildInfo()
+	if !ok {
+		return info
+	}
+	if bi.GoVersion != "" {
+		info.GoVersion = bi.GoVersion
+	}
+	for _, s := range bi.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			if info.Commit == "" {
+				info.Commit = s.Value
+			}
+		case "vcs.time":
+			if info.Date == "" {
+				info.Date = s.Value
+			}
+		case "vcs.modified":
+			info.Modified = s.Value == "true"
+		}
+	}
+	return info
+}
+
+// String formats the build information as labeled lines, leaving out what is unknown
+func (i Info) String() string {
+	var output strings.Builder
+	fmt.Fprintf(&output, "mullvad-compass %s\n", i.Version)
+	if i.Commit != "" {
+		commit := i.Commit
+		if i.Modified {
+			commit += " (modified)"
+		}
+		fmt.Fprintf(&output, "Commit:   %s\n", commit)
+	}
+	if i.Date != "" {
+		fmt.Fprintf(&output, "Date:     %s\n", i.Date)
+	}
+	fmt.Fprintf(&output, "Go:       %s\n", i.GoVersion)
+	fmt.Fprintf(&output, "Platform: %s\n", i.Platform)
+	return output.String()
+}
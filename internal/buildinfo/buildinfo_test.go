@@ -0,0 +1,76 @@
+package buildinfo
+
+import (
+	"runtime"
+	"runtime/debug"
+	"testing"
+)
+
+// withBuildInfo substitutes the embedded build information for the duration of the test
+func withBuildInfo(t *testing.T, bi *debug.BuildInfo) {
+	t.Helper()
+	orig := readBuildInfo
+	readBuildInfo = func() (*debug.BuildInfo, bool) { return bi, bi != nil }
+	t.Cleanup(func() { readBuildInfo = orig })
+}
+
+func TestRead(t *testing.T) {
+	vcs := &debug.BuildInfo{
+		GoVersion: "go1.24.2",
+		Settings: []debug.BuildSetting{
+			{Key: "vcs", Value: "git"},
+			{Key: "vcs.revision", Value: "0123456789abcdef"},
+			{Key: "vcs.time", Value: "2025-03-01T12:00:00Z"},
+			{Key: "vcs.modified", Value: "true"},
+		},
+	}
+
+	t.Run("VCS information", func(t *testing.T) {
+		withBuildInfo(t, vcs)
+		info := Read("1.2.3", "", "")
+		want := Info{
+			Version:   "1.2.3",
+			Commit:    "0123456789abcdef",
+			Date:      "2025-03-01T12:00:00Z",
+			Modified:  true,
+			GoVersion: "go1.24.2",
+			Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+		}
+		if info != want {
+			t.Errorf("Expected %+v, got %+v", want, info)
+		}
+	})
+
+	t.Run("Linker flags take precedence", func(t *testing.T) {
+		withBuildInfo(t, vcs)
+		info := Read("1.2.3", "fedcba", "2025-04-01T00:00:00Z")
+		if info.Commit != "fedcba" || info.Date != "2025-04-01T00:00:00Z" {
+			t.Errorf("Expected the linker flag values, got %+v", info)
+		}
+	})
+
+	t.Run("No build information", func(t *testing.T) {
+		withBuildInfo(t, nil)
+		info := Read("dev", "", "")
+		if info.Commit != "" || info.Date != "" || info.GoVersion != runtime.Version() {
+			t.Errorf("Expected only the runtime information, got %+v", info)
+		}
+	})
+}
+
+func TestInfoString(t *testing.T) {
+	info := Info{
+		Version:   "1.2.3",
+		Commit:    "0123456789abcdef",
+		Modified:  true,
+		GoVersion: "go1.24.2",
+		Platform:  "linux/amd64",
+	}
+	want := "mullvad-compass 1.2.3\n" +
+		"Commit:   0123456789abcdef (modified)\n" +
+		"Go:       go1.24.2\n" +
+		"Platform: linux/amd64\n"
+	if got := info.String(); got != want {
+		t.Errorf("Expected:\n%s\ngot:\n%s", want, got)
+	}
+}
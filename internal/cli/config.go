@@ -4,38 +4,224 @@ package cli
 import (
 	"fmt"
 	"io"
+	"net"
+	"net/url"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
+	"unicode"
 
+	"github.com/Ch00k/mullvad-compass/internal/elevate"
+	"github.com/Ch00k/mullvad-compass/internal/hooks"
 	"github.com/Ch00k/mullvad-compass/internal/logging"
+	"github.com/Ch00k/mullvad-compass/internal/ping"
 	"github.com/Ch00k/mullvad-compass/internal/relays"
+	"github.com/Ch00k/mullvad-compass/internal/resolve"
+)
+
+// Subcommands
+const (
+	CommandCheck        = "check"        // Report exit IP, ownership, blacklist, and DNS leak status
+	CommandPorts        = "ports"        // List WireGuard and Shadowsocks ports
+	CommandCapabilities = "capabilities" // Show the capabilities available in each city
+	CommandCompare      = "compare"      // Compare two recorded runs
+	CommandFavorite     = "favorite"     // Manage favorite relays
+	CommandIgnore       = "ignore"       // Manage ignored relays
+	CommandTunnel       = "tunnel"       // Measure latency through the Mullvad tunnel
+	CommandPlan         = "plan"         // List the relays nearest to cities the user is traveling to
+	CommandValidate     = "validate"     // Check a relays.json for problems
+	CommandService      = "service"      // Install or remove the Windows scheduled task running searches
+	CommandPaths        = "paths"        // Print the config, cache and relays paths in use
+	CommandApply        = "apply"        // Point a wg-quick configuration at the best server
+)
+
+// CommandBench benchmarks the pipeline on synthetic relays. It is meant for development and not listed in the usage.
+const CommandBench = "bench-internal"
+
+// Actions of the favorite, ignore and service commands
+const (
+	ActionAdd     = "add"
+	ActionRemove  = "remove"
+	ActionList    = "list"
+	ActionInstall = "install" // Service only
+)
+
+// Output layouts of Table Mode
+const (
+	LayoutTable   = "table"   // One row per server
+	LayoutGrouped = "grouped" // Servers under country and city headers
+)
+
+// Output formats of the server search
+const (
+	OutputText    = "text"    // The best server, or a table of servers
+	OutputGeoJSON = "geojson" // A GeoJSON FeatureCollection of the ranked servers and the user's location
+	OutputHTML    = "html"    // The regular output, and a standalone HTML report written to OutputFile
+)
+
+// Rankings of the servers that responded
+const (
+	RankLatency  = "latency"  // Lowest latency first
+	RankCombined = "combined" // Lowest weighted sum of latency and distance first
+)
+
+// Presets of --preset, each a bundle of filters
+const (
+	PresetPrivacy    = "privacy"    // Mullvad-owned, stboot and DAITA relays
+	PresetSpeed      = "speed"      // No anti-censorship, and only the highest-weighted relays of each city
+	PresetCensorship = "censorship" // Relays offering QUIC or Shadowsocks
+)
+
+// DefaultDistanceWeight is the share of the distance in the combined ranking unless --distance-weight is given
+const DefaultDistanceWeight = 0.3
+
+// Address columns of Table Mode
+const (
+	ShowIPsV4   = "v4"   // IPv4 address
+	ShowIPsV6   = "v6"   // IPv6 address
+	ShowIPsBoth = "both" // IPv4 and IPv6 addresses in separate columns
 )
 
 // Config holds all command-line configuration options for the application.
 type Config struct {
+	Command             string // Empty for the default server search
+	ServerType          relays.ServerType
+	Countries           []string // Names, aliases, or ISO 3166-1 alpha-2 codes
+	Cities              []string // Destinations of the plan command, as city names or Mullvad city codes
+	BestIn              string   // Country or city the best server is searched in, empty searches by distance
 	AntiCensorship      relays.AntiCensorship
 	Daita               bool
+	Exclude             relays.Feature  // Capabilities excluded with --no-daita, --no-lwo, --no-quic, --no-shadowsocks
+	AnyFeature          relays.Feature  // Capabilities of which relays must offer at least one, 0 disables
+	OwnedOnly           bool            // Only relays on hardware Mullvad owns
+	StbootOnly          bool            // Drop relays the relay list says do not boot with stboot
+	TopWeight           bool            // Only the highest-weighted relays of each city
+	Preset              string          // Name of the --preset the filters above came from, empty if none
+	TieBreak            relays.TieBreak // Picks the best server among those within a millisecond of each other
 	IPVersion           relays.IPVersion
+	AutoIPVersion       bool // Pick IPv4 or IPv6 by probing, IPVersion is IPv4 until then
+	DualStack           bool // Ping every relay over both IPv4 and IPv6, IPVersion is IPv4
 	MaxDistance         float64
 	ShowHelp            bool
 	ShowVersion         bool
+	VerboseVersion      bool // Show the build information with --version
 	Timeout             int
-	Workers             int
+	TimeoutPerKm        float64 // Milliseconds added to the timeout of a server per km of its distance, 0 disables
+	Workers             int     // 0 picks a count from the addresses to ping, the CPUs and the open file limit
 	BestServerMode      bool
 	LogLevel            logging.LogLevel
 	DeterministicOutput bool
+	NoSummary           bool
+	SummaryOnly         bool // Print a single summary line instead of the results
+	PerCity             bool
+	Plain               bool
+	Pretty              bool    // Prefix countries with flag emoji and use their display names
+	Layout              string  // LayoutTable or LayoutGrouped
+	ShowIPs             string  // ShowIPsV4, ShowIPsV6 or ShowIPsBoth, empty shows both unless there is no IPv6 route
+	Rank                string  // RankLatency or RankCombined
+	DistanceWeight      float64 // Share of the distance in the combined ranking, 0-1
+	Precision           int     // Decimal places of latencies
+	Microseconds        bool    // Show latencies in microseconds instead of milliseconds
+	ShowFeatures        bool    // Show the capabilities of each relay as compact flags
+	ASNDatabase         string  // MaxMind DB to look up the autonomous system of each relay in, empty disables
+	Calibrate           string  // Reference host pinged alongside the relays, empty disables
+	Baseline            bool    // Ping well-known anycast hosts alongside the relays and list them below
+	Asymmetry           bool    // Experimental: estimate one-way delays of the best servers from ICMP timestamps
+	AllowPrivate        bool    // Probe loopback, link-local and multicast addresses from --calibrate and relays.json
+	DoHURL              string  // DNS-over-HTTPS endpoint resolving hostnames, empty uses the system resolver
+	Sample              int     // 0 disables sampling
+	Seed                int64
+	SeedSet             bool
+	SampleFullCity      bool
+	FallbackDistance    bool
+	PreRunHook          string
+	PostRunHook         string
+	BestChangeHook      string
+	SwitchThreshold     hooks.SwitchThreshold // Zero runs the on_best_change hook on any change
+	Strict              bool
+	VerifyCoordinates   bool // List relays placed far from the city their hostname names, instead of searching
+	UseAppSettings      bool
+	ConstraintCost      bool     // Compare the best server with the best one the app's relay constraints allow
+	AppLocation         bool     // Read the user location cached by the Mullvad app instead of asking the API
+	Offline             bool     // Forbid network access other than the probes
+	UserAgent           string   // User-Agent of Mullvad API requests, empty for the default
+	Interface           string   // Network interface to send probes from
+	SourceIP            string   // Source address to send probes from
+	Netns               string   // Named network namespace to send probes in (Linux)
+	Sudo                bool     // Offer to run again as root when the ICMP sockets are not permitted (Linux)
+	PcapFile            string   // File the ICMP packets of the pings are recorded to, empty disables
+	WGConfig            string   // wg-quick configuration the apply command rewrites
+	Restart             bool     // Restart the wg-quick interface after the apply command rewrote its configuration
+	Args                []string // Positional arguments of the compare, favorite, ignore and tunnel commands
+	Share               string   // Anonymized report format ("markdown" or "json"), empty for regular output
+	Output              string   // OutputText, OutputGeoJSON or OutputHTML
+	OutputFile          string   // Path of the HTML report
+	LatencyUnder        int      // Hide servers at or above this latency in ms, 0 disables
+	GoodEnough          int      // Stop pinging once a server responds in less than this many ms, 0 disables
+	Runs                int      // Rounds of the ping phase, ranked by their median latency
+	VerifyOutliers      bool     // Ping relays whose latency stands out from their city again
+	MinCityRelays       int      // Also show the best server in a city with this many relays, 0 disables
+	FavoritesOnly       bool
+	MaxWidths           map[string]int // Most characters shown of the Table Mode columns, by column name
+	IncludeIgnored      bool
+	IncludeUnlocated    bool          // Also search relays without coordinates, whose distance is unknown
+	Timings             bool          // Print the duration of each phase after the results
+	Stability           int           // Seconds to re-probe the best servers for, 0 disables
+	NoLock              bool          // Run even if another run holds the lock
+	UpdateRelays        bool          // Download the relay list from the Mullvad API instead of reading the app's cache
+	Every               time.Duration // Interval between scheduled runs, 0 runs once
+	Retain              time.Duration // Age of the oldest run kept in the history store by scheduled runs
 }
 
+// DefaultRetain is how long scheduled runs are kept in the history store unless --retain is given
+const DefaultRetain = 7 * 24 * time.Hour
+
+// minTimeout is the shortest ping timeout in milliseconds
+const minTimeout = 100
+
+// minEvery is the shortest interval between scheduled runs, sparing the Mullvad API and the relays
+const minEvery = time.Minute
+
 // ParseFlags parses command-line arguments manually to support GNU-style long flags
 func ParseFlags(args []string, version string) (*Config, error) {
 	cfg := &Config{
-		MaxDistance:    500.0,
-		Timeout:        500,
-		Workers:        25,
-		BestServerMode: true,
-		LogLevel:       logging.LogLevelError,
+		MaxDistance:      500.0,
+		Timeout:          500,
+		BestServerMode:   true,
+		LogLevel:         logging.LogLevelError,
+		FallbackDistance: true,
+		Layout:           LayoutTable,
+		Output:           OutputText,
+		Precision:        2,
+		Runs:             1,
+		Rank:             RankLatency,
+		DistanceWeight:   DefaultDistanceWeight,
+		Retain:           DefaultRetain,
 	}
 
+	if len(args) > 0 {
+		switch args[0] {
+		case CommandCheck,
+			CommandPorts,
+			CommandCapabilities,
+			CommandCompare,
+			CommandFavorite,
+			CommandIgnore,
+			CommandTunnel,
+			CommandPlan,
+			CommandValidate,
+			CommandService,
+			CommandPaths,
+			CommandApply,
+			CommandBench:
+			cfg.Command = args[0]
+			args = args[1:]
+		}
+	}
+
+	var maxDistanceSet, retainSet, distanceWeightSet, timeoutSet, familySet bool
+
 	for i := 0; i < len(args); i++ {
 		arg := args[i]
 
@@ -46,8 +232,12 @@ func ParseFlags(args []string, version string) (*Config, error) {
 
 		case arg == "-v" || arg == "--version":
 			cfg.ShowVersion = true
+			cfg.VerboseVersion = cfg.VerboseVersion || slices.Contains(args[i+1:], "--verbose")
 			return cfg, nil
 
+		case arg == "--verbose":
+			cfg.VerboseVersion = true
+
 		case arg == "-a" || arg == "--anti-censorship":
 			cfg.BestServerMode = false
 			if i+1 >= len(args) {
@@ -60,13 +250,112 @@ func ParseFlags(args []string, version string) (*Config, error) {
 			}
 			cfg.AntiCensorship = antiCensorship
 
+		case arg == "-s" || arg == "--server-type":
+			cfg.BestServerMode = false
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("%s requires an argument", arg)
+			}
+			i++
+			serverType, err := relays.ParseServerType(args[i])
+			if err != nil {
+				return nil, err
+			}
+			cfg.ServerType = serverType
+
+		case arg == "-c" || arg == "--country":
+			cfg.BestServerMode = false
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("%s requires an argument", arg)
+			}
+			i++
+			for _, country := range strings.Split(args[i], ",") {
+				country = strings.TrimSpace(country)
+				if country == "" {
+					return nil, fmt.Errorf("invalid country value: %s", args[i])
+				}
+				cfg.Countries = append(cfg.Countries, country)
+			}
+
+		case arg == "--cities":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("%s requires an argument", arg)
+			}
+			i++
+			for _, city := range strings.Split(args[i], ",") {
+				city = strings.TrimSpace(city)
+				if city == "" {
+					return nil, fmt.Errorf("invalid cities value: %s", args[i])
+				}
+				cfg.Cities = append(cfg.Cities, city)
+			}
+
+		case arg == "--best-in":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("%s requires an argument", arg)
+			}
+			i++
+			if strings.TrimSpace(args[i]) == "" {
+				return nil, fmt.Errorf("invalid best-in value: %s", args[i])
+			}
+			cfg.BestIn = args[i]
+
 		case arg == "-d" || arg == "--daita":
 			cfg.BestServerMode = false
 			cfg.Daita = true
 
+		case arg == "--preset":
+			cfg.BestServerMode = false
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("%s requires an argument", arg)
+			}
+			i++
+			if err := applyPreset(cfg, args[i]); err != nil {
+				return nil, err
+			}
+
+		case arg == "--no-daita":
+			cfg.BestServerMode = false
+			cfg.Exclude |= relays.FeatureDAITA
+
+		case arg == "--no-lwo":
+			cfg.BestServerMode = false
+			cfg.Exclude |= relays.FeatureLWO
+
+		case arg == "--no-quic":
+			cfg.BestServerMode = false
+			cfg.Exclude |= relays.FeatureQUIC
+
+		case arg == "--no-shadowsocks":
+			cfg.BestServerMode = false
+			cfg.Exclude |= relays.FeatureShadowsocks
+
 		case arg == "-6" || arg == "--ipv6":
 			cfg.BestServerMode = false
 			cfg.IPVersion = relays.IPv6
+			cfg.AutoIPVersion = false
+			cfg.DualStack = false
+			familySet = true
+
+		case arg == "--ip-version":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("%s requires an argument", arg)
+			}
+			i++
+			cfg.IPVersion, cfg.AutoIPVersion, cfg.DualStack = relays.IPv4, false, false
+			familySet = true
+			switch args[i] {
+			case "4":
+			case "6":
+				cfg.IPVersion = relays.IPv6
+			case "auto":
+				cfg.AutoIPVersion = true
+				familySet = false
+			case "both":
+				cfg.BestServerMode = false
+				cfg.DualStack = true
+			default:
+				return nil, fmt.Errorf("invalid ip-version value: %s (must be 4, 6, auto or both)", args[i])
+			}
 
 		case arg == "-m" || arg == "--max-distance":
 			cfg.BestServerMode = false
@@ -85,21 +374,134 @@ func ParseFlags(args []string, version string) (*Config, error) {
 				return nil, fmt.Errorf("max-distance must be at most 20000 km")
 			}
 			cfg.MaxDistance = distance
+			maxDistanceSet = true
+
+		case arg == "--latency-under":
+			cfg.BestServerMode = false
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("%s requires an argument", arg)
+			}
+			i++
+			latency, err := strconv.Atoi(args[i])
+			if err != nil {
+				return nil, fmt.Errorf("invalid latency-under value: %s", args[i])
+			}
+			if latency < 1 || latency > 5000 {
+				return nil, fmt.Errorf("latency-under must be between 1 and 5000")
+			}
+			cfg.LatencyUnder = latency
+
+		case arg == "--good-enough":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("%s requires an argument", arg)
+			}
+			i++
+			latency, err := strconv.Atoi(args[i])
+			if err != nil || latency < 1 || latency > 5000 {
+				return nil, fmt.Errorf("invalid good-enough value: %s (range: 1-5000)", args[i])
+			}
+			cfg.GoodEnough = latency
+
+		case arg == "--runs":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("%s requires an argument", arg)
+			}
+			i++
+			runs, err := strconv.Atoi(args[i])
+			if err != nil || runs < 1 || runs > 10 {
+				return nil, fmt.Errorf("invalid runs value: %s (range: 1-10)", args[i])
+			}
+			cfg.Runs = runs
+
+		case arg == "--verify-outliers":
+			cfg.VerifyOutliers = true
+
+		case arg == "--min-city-relays":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("%s requires an argument", arg)
+			}
+			i++
+			count, err := strconv.Atoi(args[i])
+			if err != nil || count < 2 || count > 100 {
+				return nil, fmt.Errorf("invalid min-city-relays value: %s (range: 2-100)", args[i])
+			}
+			cfg.MinCityRelays = count
+
+		case arg == "--tie-break":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("%s requires an argument", arg)
+			}
+			i++
+			tieBreak, err := relays.ParseTieBreak(args[i])
+			if err != nil {
+				return nil, err
+			}
+			cfg.TieBreak = tieBreak
+
+		case arg == "--stability":
+			cfg.BestServerMode = false
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("%s requires an argument", arg)
+			}
+			i++
+			seconds, err := strconv.Atoi(args[i])
+			if err != nil {
+				return nil, fmt.Errorf("invalid stability value: %s", args[i])
+			}
+			if seconds < 1 || seconds > 600 {
+				return nil, fmt.Errorf("stability must be between 1 and 600")
+			}
+			cfg.Stability = seconds
+
+		case arg == "--favorites-only":
+			cfg.BestServerMode = false
+			cfg.FavoritesOnly = true
+
+		case arg == "--include-ignored":
+			cfg.IncludeIgnored = true
+
+		case arg == "--include-unlocated":
+			cfg.IncludeUnlocated = true
+
+		case arg == "--timings":
+			cfg.Timings = true
+
+		case arg == "--no-lock":
+			cfg.NoLock = true
+
+		case arg == "--update-relays":
+			cfg.UpdateRelays = true
 
 		case arg == "-t" || arg == "--timeout":
 			if i+1 >= len(args) {
 				return nil, fmt.Errorf("%s requires an argument", arg)
 			}
 			i++
+			timeoutSet = true
+			if args[i] == "auto" {
+				cfg.Timeout = ping.AutoTimeout
+				continue
+			}
 			timeout, err := strconv.Atoi(args[i])
 			if err != nil {
 				return nil, fmt.Errorf("invalid timeout value: %s", args[i])
 			}
-			if timeout < 100 || timeout > 5000 {
+			if timeout < minTimeout || timeout > 5000 {
 				return nil, fmt.Errorf("timeout must be between 100 and 5000")
 			}
 			cfg.Timeout = timeout
 
+		case arg == "--timeout-per-km":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("%s requires an argument", arg)
+			}
+			i++
+			perKm, err := strconv.ParseFloat(args[i], 64)
+			if err != nil || perKm <= 0 || perKm > 1 {
+				return nil, fmt.Errorf("invalid timeout-per-km value: %s (range: 0-1)", args[i])
+			}
+			cfg.TimeoutPerKm = perKm
+
 		case arg == "-w" || arg == "--workers":
 			if i+1 >= len(args) {
 				return nil, fmt.Errorf("%s requires an argument", arg)
@@ -109,11 +511,40 @@ func ParseFlags(args []string, version string) (*Config, error) {
 			if err != nil {
 				return nil, fmt.Errorf("invalid workers value: %s", args[i])
 			}
-			if workers < 1 || workers > 200 {
-				return nil, fmt.Errorf("workers must be between 1 and 200")
+			if workers < 1 || workers > ping.MaxWorkers {
+				return nil, fmt.Errorf("workers must be between 1 and %d", ping.MaxWorkers)
 			}
 			cfg.Workers = workers
 
+		case arg == "--sample":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("%s requires an argument", arg)
+			}
+			i++
+			sample, err := strconv.Atoi(args[i])
+			if err != nil {
+				return nil, fmt.Errorf("invalid sample value: %s", args[i])
+			}
+			if sample < 1 || sample > 1000 {
+				return nil, fmt.Errorf("sample must be between 1 and 1000")
+			}
+			cfg.Sample = sample
+
+		case arg == "--seed":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("%s requires an argument", arg)
+			}
+			i++
+			seed, err := strconv.ParseInt(args[i], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid seed value: %s", args[i])
+			}
+			cfg.Seed = seed
+			cfg.SeedSet = true
+
+		case arg == "--sample-full-city":
+			cfg.SampleFullCity = true
+
 		case arg == "-l" || arg == "--log-level":
 			if i+1 >= len(args) {
 				return nil, fmt.Errorf("%s requires an argument", arg)
@@ -125,6 +556,298 @@ func ParseFlags(args []string, version string) (*Config, error) {
 			}
 			cfg.LogLevel = level
 
+		case arg == "--no-summary":
+			cfg.NoSummary = true
+
+		case arg == "--summary-only":
+			cfg.SummaryOnly = true
+
+		case arg == "--plain":
+			cfg.Plain = true
+
+		case arg == "--pretty":
+			cfg.Pretty = true
+
+		case arg == "--asn-db":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("%s requires an argument", arg)
+			}
+			i++
+			if args[i] == "" {
+				return nil, fmt.Errorf("%s requires a non-empty path", arg)
+			}
+			cfg.ASNDatabase = args[i]
+
+		case arg == "--calibrate":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("%s requires an argument", arg)
+			}
+			i++
+			if args[i] == "" {
+				return nil, fmt.Errorf("%s requires a non-empty host", arg)
+			}
+			cfg.Calibrate = args[i]
+
+		case arg == "--baseline":
+			cfg.BestServerMode = false
+			cfg.Baseline = true
+
+		case arg == "--asymmetry":
+			cfg.Asymmetry = true
+
+		case arg == "--allow-private":
+			cfg.AllowPrivate = true
+
+		case arg == "--doh":
+			if cfg.DoHURL == "" {
+				cfg.DoHURL = resolve.DefaultDoHURL
+			}
+
+		case arg == "--doh-url":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("%s requires an argument", arg)
+			}
+			i++
+			if u, err := url.Parse(args[i]); err != nil || u.Scheme != "https" || u.Host == "" {
+				return nil, fmt.Errorf("invalid DNS-over-HTTPS URL: %s (must be an https:// URL)", args[i])
+			}
+			cfg.DoHURL = args[i]
+
+		case arg == "--share":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("%s requires an argument", arg)
+			}
+			i++
+			if args[i] != "markdown" && args[i] != "json" {
+				return nil, fmt.Errorf("invalid share format: %s (must be 'markdown' or 'json')", args[i])
+			}
+			cfg.Share = args[i]
+
+		case arg == "--output":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("%s requires an argument", arg)
+			}
+			i++
+			if args[i] != OutputText && args[i] != OutputGeoJSON && args[i] != OutputHTML {
+				return nil, fmt.Errorf("invalid output format: %s (must be 'text', 'geojson' or 'html')", args[i])
+			}
+			cfg.Output = args[i]
+			if cfg.Output == OutputHTML {
+				if i+1 >= len(args) || args[i+1] == "" {
+					return nil, fmt.Errorf("%s html requires a report file", arg)
+				}
+				i++
+				cfg.OutputFile = args[i]
+			}
+
+		case arg == "--layout":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("%s requires an argument", arg)
+			}
+			i++
+			if args[i] != LayoutTable && args[i] != LayoutGrouped {
+				return nil, fmt.Errorf("invalid layout: %s (must be 'table' or 'grouped')", args[i])
+			}
+			cfg.Layout = args[i]
+			if cfg.Layout == LayoutGrouped {
+				cfg.BestServerMode = false
+			}
+
+		case arg == "--show-ips":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("%s requires an argument", arg)
+			}
+			i++
+			if args[i] != ShowIPsV4 && args[i] != ShowIPsV6 && args[i] != ShowIPsBoth {
+				return nil, fmt.Errorf("invalid IP columns: %s (must be 'both', 'v4' or 'v6')", args[i])
+			}
+			cfg.ShowIPs = args[i]
+
+		case arg == "--max-width":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("%s requires an argument", arg)
+			}
+			i++
+			maxWidths, err := parseMaxWidths(args[i])
+			if err != nil {
+				return nil, err
+			}
+			cfg.MaxWidths = maxWidths
+
+		case arg == "--precision":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("%s requires an argument", arg)
+			}
+			i++
+			precision, err := strconv.Atoi(args[i])
+			if err != nil || precision < 0 || precision > 6 {
+				return nil, fmt.Errorf("invalid precision value: %s (range: 0-6)", args[i])
+			}
+			cfg.Precision = precision
+
+		case arg == "--us":
+			cfg.Microseconds = true
+
+		case arg == "--features":
+			cfg.ShowFeatures = true
+
+		case arg == "--rank":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("%s requires an argument", arg)
+			}
+			i++
+			if args[i] != RankLatency && args[i] != RankCombined {
+				return nil, fmt.Errorf("invalid rank: %s (must be 'latency' or 'combined')", args[i])
+			}
+			cfg.Rank = args[i]
+
+		case arg == "--distance-weight":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("%s requires an argument", arg)
+			}
+			i++
+			weight, err := strconv.ParseFloat(args[i], 64)
+			if err != nil || weight < 0 || weight > 1 {
+				return nil, fmt.Errorf("invalid distance weight: %s (range: 0-1)", args[i])
+			}
+			cfg.DistanceWeight = weight
+			distanceWeightSet = true
+
+		case arg == "--every" || arg == "--retain":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("%s requires an argument", arg)
+			}
+			i++
+			d, err := parseDuration(args[i])
+			if err != nil || d <= 0 {
+				name := strings.TrimPrefix(arg, "--")
+				return nil, fmt.Errorf("invalid %s value: %s (e.g. 30m, 6h, 7d)", name, args[i])
+			}
+			if arg == "--every" {
+				if d < minEvery {
+					return nil, fmt.Errorf("invalid every value: %s (minimum: 1m)", args[i])
+				}
+				cfg.Every = d
+			} else {
+				cfg.Retain = d
+				retainSet = true
+			}
+
+		case arg == "--per-city":
+			cfg.BestServerMode = false
+			cfg.PerCity = true
+
+		case arg == "--strict":
+			cfg.Strict = true
+
+		case arg == "--verify-coordinates":
+			cfg.VerifyCoordinates = true
+
+		case arg == "--use-app-settings":
+			cfg.UseAppSettings = true
+
+		case arg == "--constraint-cost":
+			cfg.UseAppSettings = true
+			cfg.ConstraintCost = true
+
+		case arg == "--app-location":
+			cfg.AppLocation = true
+
+		case arg == "--offline":
+			cfg.Offline = true
+			cfg.AppLocation = true
+
+		case arg == "--user-agent":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("%s requires an argument", arg)
+			}
+			i++
+			if args[i] == "" || strings.ContainsFunc(args[i], unicode.IsControl) {
+				return nil, fmt.Errorf("invalid user agent: %q", args[i])
+			}
+			cfg.UserAgent = args[i]
+
+		case arg == "--interface" || arg == "--source-ip":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("%s requires an argument", arg)
+			}
+			i++
+			if args[i] == "" {
+				return nil, fmt.Errorf("%s requires a non-empty value", arg)
+			}
+			if arg == "--interface" {
+				cfg.Interface = args[i]
+			} else {
+				cfg.SourceIP = args[i]
+			}
+
+		case arg == "--netns":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("%s requires an argument", arg)
+			}
+			i++
+			if args[i] == "" || strings.ContainsRune(args[i], '/') {
+				return nil, fmt.Errorf("invalid network namespace: %q", args[i])
+			}
+			cfg.Netns = args[i]
+
+		case arg == "--sudo":
+			cfg.Sudo = true
+
+		case arg == "--wg-config":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("%s requires an argument", arg)
+			}
+			i++
+			cfg.WGConfig = args[i]
+
+		case arg == "--restart":
+			cfg.Restart = true
+
+		case arg == "--pcap":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("%s requires an argument", arg)
+			}
+			i++
+			if args[i] == "" {
+				return nil, fmt.Errorf("%s requires a non-empty value", arg)
+			}
+			cfg.PcapFile = args[i]
+
+		case arg == "--fallback-distance":
+			cfg.FallbackDistance = true
+
+		case arg == "--no-fallback-distance":
+			cfg.FallbackDistance = false
+
+		case arg == "--pre-run" || arg == "--post-run" || arg == "--on-best-change":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("%s requires an argument", arg)
+			}
+			i++
+			if args[i] == "" {
+				return nil, fmt.Errorf("%s requires a non-empty command", arg)
+			}
+			switch arg {
+			case "--pre-run":
+				cfg.PreRunHook = args[i]
+			case "--post-run":
+				cfg.PostRunHook = args[i]
+			default:
+				cfg.BestChangeHook = args[i]
+			}
+
+		case arg == "--switch-threshold":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("%s requires an argument", arg)
+			}
+			i++
+			threshold, err := hooks.ParseSwitchThreshold(args[i])
+			if err != nil {
+				return nil, err
+			}
+			cfg.SwitchThreshold = threshold
+
 		case arg == "--deterministic-output":
 			// Only enable in dev builds, silently ignore otherwise
 			if version == "dev" {
@@ -134,14 +857,357 @@ func ParseFlags(args []string, version string) (*Config, error) {
 		case strings.HasPrefix(arg, "-"):
 			return nil, fmt.Errorf("unknown flag: %s", arg)
 
+		case cfg.Command == CommandCompare ||
+			cfg.Command == CommandFavorite ||
+			cfg.Command == CommandIgnore ||
+			cfg.Command == CommandTunnel ||
+			cfg.Command == CommandValidate ||
+			cfg.Command == CommandService ||
+			cfg.Command == CommandPaths ||
+			cfg.Command == CommandBench:
+			cfg.Args = append(cfg.Args, arg)
+
 		default:
 			return nil, fmt.Errorf("unexpected argument: %s", arg)
 		}
 	}
 
+	if cfg.VerboseVersion {
+		return nil, fmt.Errorf("--verbose only applies to --version")
+	}
+
+	// Scaling the timeout by distance starts from the shortest timeout unless a base is given explicitly, so that
+	// nearby servers fail fast
+	if cfg.TimeoutPerKm > 0 {
+		if cfg.Timeout == ping.AutoTimeout {
+			return nil, fmt.Errorf("--timeout-per-km cannot be combined with --timeout auto")
+		}
+		if !timeoutSet {
+			cfg.Timeout = minTimeout
+		}
+	}
+
+	// A country filter searches the whole country unless a distance limit is given explicitly
+	if len(cfg.Countries) > 0 && !maxDistanceSet {
+		cfg.MaxDistance = 20000
+	}
+
+	// Searching a single country or city replaces the distance search, and keeps best server mode for the
+	// filters that would otherwise switch to a table
+	if cfg.BestIn != "" {
+		if len(cfg.Countries) > 0 || maxDistanceSet || cfg.PerCity || cfg.LatencyUnder > 0 || cfg.Stability > 0 ||
+			cfg.Layout == LayoutGrouped || cfg.DualStack {
+			return nil, fmt.Errorf(
+				"best-in cannot be combined with -c, -m, --per-city, --latency-under, --stability, --layout grouped " +
+					"or --ip-version both",
+			)
+		}
+		cfg.BestServerMode = true
+	}
+
+	if cfg.Command == CommandCompare && len(cfg.Args) != 2 {
+		return nil, fmt.Errorf("compare requires exactly two run files")
+	}
+
+	if cfg.Command == CommandValidate && len(cfg.Args) != 1 {
+		return nil, fmt.Errorf("validate requires exactly one relays file")
+	}
+
+	if cfg.Command == CommandPaths && len(cfg.Args) > 1 {
+		return nil, fmt.Errorf("paths accepts at most one path name")
+	}
+
+	if cfg.Command == CommandService &&
+		(len(cfg.Args) != 1 || (cfg.Args[0] != ActionInstall && cfg.Args[0] != ActionRemove)) {
+		return nil, fmt.Errorf("service requires an action (%s, %s)", ActionInstall, ActionRemove)
+	}
+	serviceInstall := cfg.Command == CommandService && cfg.Args[0] == ActionInstall
+
+	if cfg.Command == CommandFavorite || cfg.Command == CommandIgnore {
+		if err := validateListArgs(cfg.Command, cfg.Args); err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.Command == CommandPlan && len(cfg.Cities) == 0 {
+		return nil, fmt.Errorf("plan requires --cities")
+	}
+	if cfg.Command != CommandPlan && len(cfg.Cities) > 0 {
+		return nil, fmt.Errorf("--cities only applies to the plan command")
+	}
+
+	if cfg.Command == CommandTunnel {
+		for _, dest := range cfg.Args {
+			if _, port, err := net.SplitHostPort(dest); err != nil || port == "" {
+				return nil, fmt.Errorf("invalid tunnel destination: %s (must be HOST:PORT)", dest)
+			}
+		}
+	}
+
+	if cfg.Command == CommandBench {
+		if len(cfg.Args) > 1 {
+			return nil, fmt.Errorf("bench-internal takes at most one relay count")
+		}
+		if len(cfg.Args) == 1 {
+			if n, err := strconv.Atoi(cfg.Args[0]); err != nil || n < 1 {
+				return nil, fmt.Errorf("invalid relay count: %s (must be a positive integer)", cfg.Args[0])
+			}
+		}
+	}
+
+	if cfg.Command == CommandApply {
+		if cfg.WGConfig == "" {
+			return nil, fmt.Errorf("apply requires --wg-config")
+		}
+		if !cfg.BestServerMode || cfg.ServerType != relays.WireGuardServer || cfg.Share != "" {
+			return nil, fmt.Errorf("apply only searches WireGuard servers in Best Server Mode, without --share")
+		}
+	} else if cfg.WGConfig != "" || cfg.Restart {
+		return nil, fmt.Errorf("--wg-config and --restart only apply to the apply command")
+	}
+
+	// These reach the network beyond the probes, which --offline forbids
+	if cfg.Offline && (cfg.UpdateRelays || cfg.DoHURL != "" || cfg.Command == CommandCheck ||
+		cfg.Command == CommandTunnel) {
+		return nil, fmt.Errorf(
+			"--offline cannot be combined with --update-relays, --doh or the check and tunnel commands",
+		)
+	}
+
+	// The comparison needs the best server and the best allowed one, both of which a good enough result cuts short
+	if cfg.ConstraintCost && (!cfg.BestServerMode || cfg.BestIn != "" || cfg.Share != "" || cfg.GoodEnough > 0) {
+		return nil, fmt.Errorf(
+			"--constraint-cost only applies to Best Server Mode without --best-in, --share or --good-enough",
+		)
+	}
+
+	// The baseline rows follow the server list, which these replace or regroup
+	if cfg.Baseline && (cfg.BestIn != "" || cfg.PerCity || cfg.Layout == LayoutGrouped || cfg.Stability > 0) {
+		return nil, fmt.Errorf(
+			"--baseline only applies to the Table Mode server list, without --best-in, --per-city, " +
+				"--layout grouped or --stability",
+		)
+	}
+
+	if cfg.MinCityRelays > 0 && (!cfg.BestServerMode || cfg.ConstraintCost) {
+		return nil, fmt.Errorf("--min-city-relays only applies to Best Server Mode without --constraint-cost")
+	}
+
+	if cfg.TieBreak != relays.TieBreakFirst && (!cfg.BestServerMode || cfg.ConstraintCost) {
+		return nil, fmt.Errorf("--tie-break only applies to Best Server Mode without --constraint-cost")
+	}
+
+	// Both rank a complete set of servers, which a good enough result cuts short
+	// Stability repeats the pings itself, and a good enough result is a single reply rather than a median
+	if cfg.Runs > 1 && (cfg.Stability > 0 || cfg.GoodEnough > 0) {
+		return nil, fmt.Errorf("--runs cannot be combined with --stability or --good-enough")
+	}
+
+	if cfg.GoodEnough > 0 && (cfg.Stability > 0 || cfg.DualStack) {
+		return nil, fmt.Errorf("--good-enough cannot be combined with --stability or --ip-version both")
+	}
+
+	if cfg.DualStack {
+		if cfg.SourceIP != "" {
+			return nil, fmt.Errorf("--ip-version both cannot be combined with --source-ip")
+		}
+		if cfg.ShowIPs == "" {
+			cfg.ShowIPs = ShowIPsBoth
+		}
+	}
+	// A family picked with -6 or --ip-version shows the addresses that are pinged
+	if cfg.ShowIPs == "" && familySet {
+		cfg.ShowIPs = ShowIPsV4
+		if cfg.IPVersion.IsIPv6() {
+			cfg.ShowIPs = ShowIPsV6
+		}
+	}
+
+	if cfg.SourceIP != "" {
+		ip := net.ParseIP(cfg.SourceIP)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid source-ip value: %s", cfg.SourceIP)
+		}
+		// The source address settles the IP version
+		if cfg.AutoIPVersion {
+			cfg.AutoIPVersion = false
+			if ip.To4() == nil {
+				cfg.IPVersion = relays.IPv6
+			}
+		}
+		if (ip.To4() == nil) != cfg.IPVersion.IsIPv6() {
+			return nil, fmt.Errorf(
+				"source-ip %s does not match the IP version used for pinging (%s)",
+				cfg.SourceIP,
+				cfg.IPVersion,
+			)
+		}
+	}
+
+	if cfg.Netns != "" && !ping.NetnsSupported {
+		return nil, fmt.Errorf("--netns is only supported on Linux")
+	}
+	if cfg.Sudo && !elevate.Supported {
+		return nil, fmt.Errorf("--sudo is only supported on Linux")
+	}
+
+	if cfg.Asymmetry {
+		if !ping.TimestampSupported {
+			return nil, fmt.Errorf("--asymmetry is not supported on Windows")
+		}
+		if cfg.IPVersion.IsIPv6() || cfg.AutoIPVersion || cfg.DualStack {
+			return nil, fmt.Errorf("--asymmetry only supports IPv4, as ICMPv6 has no timestamp messages")
+		}
+	}
+
+	if cfg.Plain && cfg.Pretty {
+		return nil, fmt.Errorf("--plain and --pretty cannot be combined")
+	}
+	if !cfg.SwitchThreshold.IsZero() && cfg.BestChangeHook == "" {
+		return nil, fmt.Errorf("--switch-threshold requires --on-best-change")
+	}
+	if cfg.Output != OutputText && (cfg.Command != "" || cfg.Share != "" || cfg.Stability > 0) {
+		return nil, fmt.Errorf(
+			"--output %s only applies to the server search, without --share or --stability",
+			cfg.Output,
+		)
+	}
+
+	if cfg.SummaryOnly {
+		if cfg.Command != "" {
+			return nil, fmt.Errorf("--summary-only only applies to the server search")
+		}
+		if cfg.Share != "" || cfg.Output != OutputText || cfg.Stability > 0 || cfg.NoSummary || cfg.Timings {
+			return nil, fmt.Errorf(
+				"--summary-only cannot be combined with --share, --output, --stability, --no-summary or --timings",
+			)
+		}
+	}
+
+	if cfg.VerifyCoordinates && cfg.Command != "" {
+		return nil, fmt.Errorf("--verify-coordinates only applies to the server search")
+	}
+
+	if cfg.Plain && cfg.Layout == LayoutGrouped {
+		return nil, fmt.Errorf("--plain and --layout grouped cannot be combined")
+	}
+
+	if distanceWeightSet && cfg.Rank != RankCombined {
+		return nil, fmt.Errorf("--distance-weight requires --rank combined")
+	}
+
+	if retainSet && cfg.Every == 0 && !serviceInstall {
+		return nil, fmt.Errorf("--retain requires --every")
+	}
+	if cfg.Every > 0 && !serviceInstall && (cfg.Command != "" || cfg.DeterministicOutput) {
+		return nil, fmt.Errorf("--every only applies to the server search and service install")
+	}
+
+	if cfg.ServerType == relays.BridgeServer &&
+		(cfg.AntiCensorship != relays.ACNone || cfg.Daita || cfg.Exclude != 0 || cfg.AnyFeature != 0) {
+		return nil, fmt.Errorf("anti-censorship and DAITA filters only apply to wireguard servers")
+	}
+	if (cfg.Daita && cfg.Exclude.Has(relays.FeatureDAITA)) ||
+		(cfg.AntiCensorship != relays.ACNone && cfg.Exclude.Has(cfg.AntiCensorship.Feature())) ||
+		(cfg.AnyFeature != 0 && cfg.AnyFeature&^cfg.Exclude == 0) {
+		return nil, fmt.Errorf("a capability cannot be both required and excluded")
+	}
+
+	if cfg.Preset == PresetSpeed && cfg.AntiCensorship != relays.ACNone {
+		return nil, fmt.Errorf("--preset speed cannot be combined with -a")
+	}
+
 	return cfg, nil
 }
 
+// applyPreset sets the filters a --preset stands for, on top of those given by other flags
+func applyPreset(cfg *Config, name string) error {
+	switch name {
+	case PresetPrivacy:
+		cfg.OwnedOnly = true
+		cfg.StbootOnly = true
+		cfg.Daita = true
+	case PresetSpeed:
+		cfg.TopWeight = true
+	case PresetCensorship:
+		cfg.AnyFeature = relays.FeatureQUIC | relays.FeatureShadowsocks
+	default:
+		return fmt.Errorf(
+			"invalid preset: %s (must be '%s', '%s', or '%s')",
+			name,
+			PresetPrivacy,
+			PresetSpeed,
+			PresetCensorship,
+		)
+	}
+	cfg.Preset = name
+	return nil
+}
+
+// maxWidthColumns are the Table Mode columns whose width --max-width limits
+var maxWidthColumns = []string{"country", "city", "hostname", "ip", "ipv4", "ipv6", "asn"}
+
+// parseMaxWidths parses a comma-separated list of COLUMN=N pairs, e.g. "ipv6=20,asn=24"
+func parseMaxWidths(s string) (map[string]int, error) {
+	maxWidths := make(map[string]int)
+	for _, pair := range strings.Split(s, ",") {
+		column, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		column = strings.ToLower(column)
+		if !ok || !slices.Contains(maxWidthColumns, column) {
+			return nil, fmt.Errorf(
+				"invalid max-width: %s (must be COLUMN=N with COLUMN one of %s)",
+				pair,
+				strings.Join(maxWidthColumns, ", "),
+			)
+		}
+		width, err := strconv.Atoi(value)
+		if err != nil || width < 4 || width > 100 {
+			return nil, fmt.Errorf("invalid max-width value: %s (range: 4-100)", value)
+		}
+		maxWidths[column] = width
+	}
+	return maxWidths, nil
+}
+
+// parseDuration parses a Go duration such as "90m", or a whole number of days such as "7d"
+func parseDuration(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration: %s", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// validateListArgs checks the action and hostnames given to the favorite or ignore command
+func validateListArgs(command string, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("%s requires an action (%s, %s, %s)", command, ActionAdd, ActionRemove, ActionList)
+	}
+	switch args[0] {
+	case ActionAdd, ActionRemove:
+		if len(args) < 2 {
+			return fmt.Errorf("%s %s requires at least one hostname", command, args[0])
+		}
+	case ActionList:
+		if len(args) > 1 {
+			return fmt.Errorf("%s %s takes no arguments", command, args[0])
+		}
+	default:
+		return fmt.Errorf(
+			"invalid %s action: %s (must be '%s', '%s' or '%s')",
+			command,
+			args[0],
+			ActionAdd,
+			ActionRemove,
+			ActionList,
+		)
+	}
+	return nil
+}
+
 // PrintUsage outputs the usage information and command-line options to the writer.
 func PrintUsage(w io.Writer, version string) {
 	_, _ = fmt.Fprintf(w, `mullvad-compass %s
@@ -150,27 +1216,179 @@ Find Mullvad VPN servers with the lowest latency at your current location.
 
 USAGE:
     mullvad-compass [OPTIONS]
+    mullvad-compass COMMAND [OPTIONS]
+    mullvad-compass compare RUN1.json RUN2.json
+    mullvad-compass favorite|ignore add|remove HOSTNAME...
+    mullvad-compass favorite|ignore list
+    mullvad-compass tunnel [HOST:PORT...]
+    mullvad-compass plan --cities CITY[,CITY...]
+    mullvad-compass validate FILE
+    mullvad-compass service install|remove
+    mullvad-compass paths [NAME]
+
+COMMANDS:
+    check                         Show exit IP, Mullvad connection, blacklist, and DNS leak status
+    ports                         List WireGuard and Shadowsocks ports (honors -c, -a, -d, -6)
+    capabilities                  Show which DAITA, LWO, QUIC, Shadowsocks, and IPv6 combinations each city
+                                  offers, without pinging (honors -c, -a, -d, -6, --plain)
+    compare RUN1 RUN2             Show per-relay latency and rank changes between two runs
+                                  recorded with --post-run 'cat > RUN.json'
+    favorite ACTION [HOSTNAME...] Add, remove, or list favorite relays (marked with ★ in output)
+    ignore ACTION [HOSTNAME...]   Add, remove, or list ignored relays (excluded from searches)
+    tunnel [HOST:PORT...]         Measure latency to destinations through the connected relay's SOCKS5 proxy
+                                  (default: 1.1.1.1:443, 8.8.8.8:443, 9.9.9.9:443)
+    plan --cities CITIES          List the 3 relays nearest to each city you are traveling to, by distance and
+                                  without pinging (e.g. "Lisbon,Tokyo,NYC"; honors -c, -a, -d, -6, --show-ips)
+    validate FILE                 Check a relays.json for structural problems, unknown endpoint formats, missing
+                                  coordinates, and duplicate hostnames or addresses
+    service ACTION                Install or remove a Windows scheduled task that searches with the given flags every
+                                  15 minutes (or --every) from system startup, recording the history store (needs an
+                                  elevated prompt)
+    paths [NAME]                  Print the config, history, cache, relays and app settings paths in use, honoring
+                                  environment overrides, or only the path named NAME (e.g. "config", "relays")
+    apply --wg-config FILE        Find the best server and point the [Peer] of a wg-quick configuration at it,
+                                  keeping a .bak copy (--restart also takes the interface down and up again)
 
 MODES:
     Best Server Mode (default):   Shows your location and the single best server.
-                                  Activated when running without filter options.
+                                  Activated when running without filter options, or with --best-in.
 
     Table Mode:                   Shows all matching servers in a table, sorted by latency.
-                                  Activated by using any filter option (-m, -a, -d, -6).
+                                  Activated by using any filter option (-m, -c, -s, -a, -d, -6, --preset,
+                                  --latency-under, --favorites-only), --per-city or --layout grouped.
 
 FILTER OPTIONS (Table Mode):
     -m, --max-distance KM         Maximum distance in km from your location (default: 500, range: 1-20000)
+    -c, --country COUNTRY         Filter servers by country name or code, comma-separated (e.g. "Czechia,DE")
+                                  Searches the whole country unless -m is given
+    -s, --server-type TYPE        Server type to search (wireguard, bridge; default: wireguard)
     -a, --anti-censorship TYPE    Filter servers by anti-censorship type (lwo, quic, shadowsocks)
     -d, --daita                   Filter servers with DAITA enabled
+        --preset NAME             Apply a bundle of filters:
+                                    privacy     Mullvad-owned servers running stboot, with DAITA (-d)
+                                    speed       No anti-censorship, and only the highest-weighted servers of
+                                                each city, the ones the Mullvad app prefers
+                                    censorship  Servers offering QUIC or Shadowsocks
+        --no-daita                Exclude servers with DAITA enabled
+        --no-lwo, --no-quic, --no-shadowsocks
+                                  Exclude servers supporting the anti-censorship protocol
     -6, --ipv6                    Use IPv6 addresses for pinging
+        --latency-under MS        Only show servers that responded in less than MS milliseconds (range: 1-5000)
+        --favorites-only          Only search favorite relays
+        --best-in PLACE           Show the best server in a country or city (e.g. "US", "Gothenburg"), pinging all
+                                  of its servers instead of searching by distance (Best Server Mode)
+        --min-city-relays N       If the best server's city has fewer than N relays to fail over to, also show the
+                                  best server in a city with at least N (Best Server Mode, range: 2-100)
+        --tie-break POLICY        Pick the best server among those within 1 ms of it (first, random, weight,
+                                  alphabetical; default: first). weight picks at random by relay weight, like the
+                                  Mullvad app (Best Server Mode)
 
 PERFORMANCE OPTIONS:
-    -t, --timeout MS              Ping timeout in milliseconds (default: 500, range: 100-5000)
-    -w, --workers COUNT           Number of concurrent ping workers (default: 25, range: 1-200)
+    -t, --timeout MS              Ping timeout in milliseconds, or "auto" to tune it (default: 500, range: 100-5000)
+        --timeout-per-km MS       Add MS milliseconds to the timeout of each server per km of its distance, so that
+                                  nearby servers fail fast and distant ones get enough headroom (e.g. 0.03; the
+                                  timeout starts from 100 unless -t is given, and is capped at 5000)
+    -w, --workers COUNT           Number of concurrent ping workers (default: up to 8 per CPU, range: 1-200)
+        --sample N                Ping at most N randomly chosen servers per city (range: 1-1000)
+        --seed N                  Random seed for the probe order and --sample (default: random)
+        --good-enough MS          Stop pinging as soon as a server responds in less than MS milliseconds and show
+                                  the best server found so far instead of a complete ranking (range: 1-5000)
+        --sample-full-city        After sampling, ping all servers in the best city
+        --stability SECONDS       Ping the 10 best servers every second for SECONDS and rank them by loss and
+                                  latency deviation (enables Table Mode, range: 1-600)
+        --runs N                  Ping the servers N times, a second apart, and rank them by their median latency,
+                                  so that a passing congestion spike does not decide the ranking (range: 1-10)
+        --verify-outliers         Ping servers that time out or respond much slower than the other servers in their
+                                  city once more, keeping the better latency
+
+NETWORK OPTIONS:
+        --ip-version VERSION      IP version to ping over (4, 6, auto, both; default: 4). auto pings the nearest
+                                  servers over both and uses the one with the lower median latency. both pings every
+                                  server over IPv4 and IPv6, showing both latencies (enables Table Mode)
+        --interface NAME          Send pings from a network interface (e.g. eth0)
+        --source-ip ADDR          Send pings from a source address (must match -6)
+        --netns NAME              Send pings from a named network namespace, e.g. one per uplink (Linux only)
+        --sudo                    When ICMP sockets are not permitted, offer to run again as root with sudo or
+                                  pkexec instead of failing (Linux only)
+        --calibrate HOST          Also ping a reference host (e.g. your router or 1.1.1.1) and show latencies
+                                  relative to it
+        --baseline                Also ping anycast hosts (Cloudflare, Google DNS) and list them below the servers
+                                  as a baseline of what good latency looks like from your connection (enables
+                                  Table Mode)
+        --asymmetry               Experimental: estimate the upload and download delays of the 5 best servers from
+                                  ICMP timestamp replies (IPv4 only, needs root or CAP_NET_RAW)
+        --allow-private           Allow pinging loopback, link-local and multicast addresses, which are refused as
+                                  the --calibrate host and skipped in relays.json
+        --doh                     Resolve the API, relay list and --calibrate hosts over DNS-over-HTTPS with
+                                  Mullvad's resolver (dns.mullvad.net) instead of the system's, which may be
+                                  tampered with. The DNS leak test of check still uses the system's.
+        --doh-url URL             Resolve hostnames over a different DNS-over-HTTPS endpoint (implies --doh)
+
+OUTPUT OPTIONS:
+        --no-summary              Do not print the summary line after the table (Table Mode)
+        --summary-only            Print only one line of key=value pairs: the best server, its latency, the number
+                                  of servers that responded and were pinged, and the run time (for cron and logs)
+        --per-city                Show only the best server of each city (enables Table Mode)
+        --layout LAYOUT           Table Mode layout (table, grouped; default: table). grouped lists servers under
+                                  country and city headers (enables Table Mode)
+        --show-ips FAMILY         Addresses shown (both, v4, v6; default: both, or the family given with -6 or
+                                  --ip-version)
+        --max-width COLUMN=N      Cut the cells of a column to at most N characters, replacing their middle with
+                                  an ellipsis; comma-separated for several columns (e.g. "ipv6=20,asn=24"; country,
+                                  city, hostname, ip, ipv4, ipv6, asn; range: 4-100)
+        --precision N             Decimal places of latencies (default: 2, range: 0-6)
+        --us                      Show latencies in microseconds instead of milliseconds
+        --features                Show a Features column flagging the capabilities of each server: D (DAITA),
+                                  L (LWO), Q (QUIC), S (Shadowsocks), 6 (IPv6) (Table Mode)
+        --plain                   Print one labeled line per server instead of aligned columns (screen readers)
+        --pretty                  Show country flags and full country names (e.g. "🇺🇸 United States")
+        --asn-db FILE             Show the network operator (ASN) of each server from a MaxMind DB such as
+                                  GeoLite2-ASN.mmdb
+        --share FORMAT            Print an anonymized report for posting publicly (markdown, json)
+        --output FORMAT           Output format (text, geojson, html FILE; default: text). geojson prints the
+                                  ranked servers and your location as a GeoJSON FeatureCollection for map tools.
+                                  html also writes a standalone report with a sortable table and a map to FILE
+        --timings                 Print how long each phase took (parse, filter, geoip, ping, sort, format)
+        --rank MODE               Rank responding servers by latency, or by a combined score of latency and
+                                  distance for when latencies are within noise (latency, combined; default: latency)
+        --distance-weight W       Share of the distance in the combined score (default: 0.3, range: 0-1)
+        --fallback-distance       Rank servers by distance when none respond to ping (default)
+        --no-fallback-distance    Show timeouts instead of ranking by distance when none respond
+
+HOOK OPTIONS:
+        --pre-run COMMAND         Run a shell command before pinging servers
+        --post-run COMMAND        Run a shell command after printing results
+        --on-best-change COMMAND  Run a shell command when the best server differs from the last run
+        --switch-threshold VALUE  Only run --on-best-change when the new best server is faster than the last one
+                                  by more than VALUE milliseconds or percent (e.g. 10 or 20%%)
+                                  Hooks receive results as JSON on stdin and in MULLVAD_COMPASS_* variables
+
+SCHEDULE OPTIONS:
+        --every INTERVAL          Keep running, searching every INTERVAL (e.g. 15m, 1h; minimum: 1m), and record
+                                  the results in the history store. An alternative to cron or Task Scheduler
+        --retain AGE              Drop runs older than AGE from the history store (e.g. 12h, 30d; default: 7d)
 
 OTHER OPTIONS:
     -l, --log-level LEVEL         Set log level (debug, info, warning, error; default: error)
+        --use-app-settings        Apply the Mullvad app's relay constraints (location, provider, ownership, obfuscation)
+        --constraint-cost         Show the best server with and without the app's relay constraints, and what they cost
+        --app-location            Use the location cached by the Mullvad app instead of asking the Mullvad API
+        --offline                 Make no network calls other than the pings, taking the location from the Mullvad app
+        --user-agent UA           Send UA as the User-Agent of Mullvad API requests
+        --update-relays           Download the relay list from the Mullvad API instead of using the app's cache
+        --include-ignored         Also search relays on the ignore list
+        --include-unlocated       Also search relays that relays.json lists without coordinates (at 0°, 0°),
+                                  regardless of distance
+        --strict                  Fail on malformed relay addresses in relays.json instead of skipping them
+        --verify-coordinates      List relays that relays.json places more than 100 km from the city their hostname
+                                  names, for reporting to Mullvad, and exit without pinging
+        --no-lock                 Ping even if another run is in progress (by default, concurrent runs fail)
+        --wg-config FILE          wg-quick configuration the apply command points at the best server
+        --restart                 Run wg-quick down and up after apply changed the configuration
+        --pcap FILE               Record the ICMP packets sent and received while pinging to FILE, for
+                                  Wireshark or tcpdump (not supported on Windows)
     -h, --help                    Show this help message
     -v, --version                 Show version information
+        --verbose                 With --version, also show the commit, build date, Go version and platform
 `, version)
 }
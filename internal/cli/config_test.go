@@ -2,10 +2,18 @@ package cli
 
 import (
 	"bytes"
+	"maps"
+	"slices"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/Ch00k/mullvad-compass/internal/elevate"
+	"github.com/Ch00k/mullvad-compass/internal/hooks"
+	"github.com/Ch00k/mullvad-compass/internal/logging"
+	"github.com/Ch00k/mullvad-compass/internal/ping"
 	"github.com/Ch00k/mullvad-compass/internal/relays"
+	"github.com/Ch00k/mullvad-compass/internal/resolve"
 )
 
 func TestParseFlagsDefaults(t *testing.T) {
@@ -20,8 +28,8 @@ func TestParseFlagsDefaults(t *testing.T) {
 		if cfg.Timeout != 500 {
 			t.Errorf("Expected timeout to be 500, got %d", cfg.Timeout)
 		}
-		if cfg.Workers != 25 {
-			t.Errorf("Expected workers to be 25, got %d", cfg.Workers)
+		if cfg.Workers != 0 {
+			t.Errorf("Expected workers to be 0 (automatic), got %d", cfg.Workers)
 		}
 		if cfg.AntiCensorship != relays.ACNone {
 			t.Errorf("Expected antiCensorship to be relays.ACNone, got %v", cfg.AntiCensorship)
@@ -83,6 +91,23 @@ func TestParseFlagsVersion(t *testing.T) {
 			t.Error("Expected showVersion to be true, got false")
 		}
 	})
+
+	t.Run("Verbose", func(t *testing.T) {
+		for _, args := range [][]string{{"--version", "--verbose"}, {"--verbose", "-v"}} {
+			cfg, err := ParseFlags(args, "dev")
+			if err != nil {
+				t.Fatalf("Failed to parse flags: %v", err)
+			}
+			if !cfg.ShowVersion || !cfg.VerboseVersion {
+				t.Errorf("Expected the verbose version for %q, got %v, %v", args, cfg.ShowVersion, cfg.VerboseVersion)
+			}
+		}
+
+		_, err := ParseFlags([]string{"--verbose"}, "dev")
+		if err == nil || !strings.Contains(err.Error(), "only applies to --version") {
+			t.Errorf("Expected an error for --verbose alone, got %v", err)
+		}
+	})
 }
 
 func TestParseFlagsAntiCensorship(t *testing.T) {
@@ -131,6 +156,96 @@ func TestParseFlagsAntiCensorship(t *testing.T) {
 	})
 }
 
+func TestParseFlagsCountry(t *testing.T) {
+	t.Run("Single country removes distance limit", func(t *testing.T) {
+		cfg, err := ParseFlags([]string{"--country", "Czechia"}, "dev")
+		if err != nil {
+			t.Fatalf("Failed to parse flags: %v", err)
+		}
+		if len(cfg.Countries) != 1 || cfg.Countries[0] != "Czechia" {
+			t.Errorf("Expected countries [Czechia], got %v", cfg.Countries)
+		}
+		if cfg.MaxDistance != 20000 {
+			t.Errorf("Expected max distance 20000, got %f", cfg.MaxDistance)
+		}
+		if cfg.BestServerMode {
+			t.Error("Expected country filter to disable best server mode")
+		}
+	})
+
+	t.Run("Comma-separated and repeated", func(t *testing.T) {
+		cfg, err := ParseFlags([]string{"-c", "de, cz", "-c", "Sweden"}, "dev")
+		if err != nil {
+			t.Fatalf("Failed to parse flags: %v", err)
+		}
+		expected := []string{"de", "cz", "Sweden"}
+		if strings.Join(cfg.Countries, "|") != strings.Join(expected, "|") {
+			t.Errorf("Expected countries %v, got %v", expected, cfg.Countries)
+		}
+	})
+
+	t.Run("Explicit max distance is kept", func(t *testing.T) {
+		cfg, err := ParseFlags([]string{"-m", "300", "-c", "de"}, "dev")
+		if err != nil {
+			t.Fatalf("Failed to parse flags: %v", err)
+		}
+		if cfg.MaxDistance != 300 {
+			t.Errorf("Expected max distance 300, got %f", cfg.MaxDistance)
+		}
+	})
+
+	t.Run("Empty country", func(t *testing.T) {
+		_, err := ParseFlags([]string{"-c", "de,,cz"}, "dev")
+		if err == nil || !strings.Contains(err.Error(), "invalid country value") {
+			t.Errorf("Expected invalid country error, got %v", err)
+		}
+	})
+}
+
+func TestParseFlagsServerType(t *testing.T) {
+	t.Run("Default is wireguard", func(t *testing.T) {
+		cfg, err := ParseFlags([]string{}, "dev")
+		if err != nil {
+			t.Fatalf("Failed to parse flags: %v", err)
+		}
+		if cfg.ServerType != relays.WireGuardServer {
+			t.Errorf("Expected wireguard server type, got %v", cfg.ServerType)
+		}
+	})
+
+	t.Run("Bridge server type", func(t *testing.T) {
+		cfg, err := ParseFlags([]string{"--server-type", "bridge"}, "dev")
+		if err != nil {
+			t.Fatalf("Failed to parse flags: %v", err)
+		}
+		if cfg.ServerType != relays.BridgeServer {
+			t.Errorf("Expected bridge server type, got %v", cfg.ServerType)
+		}
+		if cfg.BestServerMode {
+			t.Error("Expected server type to disable best server mode")
+		}
+	})
+
+	t.Run("Invalid server type", func(t *testing.T) {
+		_, err := ParseFlags([]string{"-s", "openvpn"}, "dev")
+		if err == nil || !strings.Contains(err.Error(), "invalid server type") {
+			t.Errorf("Expected invalid server type error, got %v", err)
+		}
+	})
+
+	t.Run("Bridge rejects WireGuard-only filters", func(t *testing.T) {
+		for _, args := range [][]string{
+			{"-s", "bridge", "-d"},
+			{"-a", "quic", "-s", "bridge"},
+		} {
+			_, err := ParseFlags(args, "dev")
+			if err == nil || !strings.Contains(err.Error(), "only apply to wireguard servers") {
+				t.Errorf("Expected filter conflict error for %v, got %v", args, err)
+			}
+		}
+	})
+}
+
 func TestParseFlagsDAITA(t *testing.T) {
 	t.Run("DAITA short flag", func(t *testing.T) {
 		cfg, err := ParseFlags([]string{"-d"}, "dev")
@@ -153,6 +268,43 @@ func TestParseFlagsDAITA(t *testing.T) {
 	})
 }
 
+func TestParseFlagsExclude(t *testing.T) {
+	t.Run("Excluded capabilities combine", func(t *testing.T) {
+		cfg, err := ParseFlags([]string{"--no-daita", "--no-quic"}, "dev")
+		if err != nil {
+			t.Fatalf("Failed to parse flags: %v", err)
+		}
+		if cfg.Exclude != relays.FeatureDAITA|relays.FeatureQUIC {
+			t.Errorf("Exclude = %v, want DAITA+QUIC", cfg.Exclude)
+		}
+		if cfg.BestServerMode {
+			t.Error("Expected the exclusions to enable Table Mode")
+		}
+	})
+
+	t.Run("Rejected combinations", func(t *testing.T) {
+		for _, args := range [][]string{
+			{"--daita", "--no-daita"},
+			{"-a", "lwo", "--no-lwo"},
+			{"-s", "bridge", "--no-shadowsocks"},
+		} {
+			if _, err := ParseFlags(args, "dev"); err == nil {
+				t.Errorf("Expected an error for %q", args)
+			}
+		}
+	})
+
+	t.Run("Other protocols can be excluded with an anti-censorship filter", func(t *testing.T) {
+		cfg, err := ParseFlags([]string{"-a", "quic", "--no-daita", "--no-shadowsocks"}, "dev")
+		if err != nil {
+			t.Fatalf("Failed to parse flags: %v", err)
+		}
+		if cfg.Exclude != relays.FeatureDAITA|relays.FeatureShadowsocks {
+			t.Errorf("Exclude = %v, want DAITA+Shadowsocks", cfg.Exclude)
+		}
+	})
+}
+
 func TestParseFlagsIPv6(t *testing.T) {
 	t.Run("IPv6 short flag", func(t *testing.T) {
 		cfg, err := ParseFlags([]string{"-6"}, "dev")
@@ -310,6 +462,16 @@ func TestParseFlagsTimeout(t *testing.T) {
 		}
 	})
 
+	t.Run("Timeout auto", func(t *testing.T) {
+		cfg, err := ParseFlags([]string{"--timeout", "auto"}, "dev")
+		if err != nil {
+			t.Fatalf("Failed to parse flags: %v", err)
+		}
+		if cfg.Timeout != ping.AutoTimeout {
+			t.Errorf("Expected timeout to be auto, got %d", cfg.Timeout)
+		}
+	})
+
 	t.Run("Timeout below minimum", func(t *testing.T) {
 		_, err := ParseFlags([]string{"-t", "50"}, "dev")
 		if err == nil {
@@ -339,6 +501,40 @@ func TestParseFlagsTimeout(t *testing.T) {
 	})
 }
 
+func TestParseFlagsTimeoutPerKm(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		timeout int
+	}{
+		{"Base timeout defaults to the shortest", []string{"--timeout-per-km", "0.03"}, 100},
+		{"Explicit base timeout", []string{"--timeout-per-km", "0.03", "-t", "250"}, 250},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := ParseFlags(tt.args, "dev")
+			if err != nil {
+				t.Fatalf("Failed to parse flags: %v", err)
+			}
+			if cfg.TimeoutPerKm != 0.03 || cfg.Timeout != tt.timeout {
+				t.Errorf("TimeoutPerKm = %g, Timeout = %d, want 0.03, %d", cfg.TimeoutPerKm, cfg.Timeout, tt.timeout)
+			}
+		})
+	}
+
+	for _, args := range [][]string{
+		{"--timeout-per-km"},
+		{"--timeout-per-km", "0"},
+		{"--timeout-per-km", "2"},
+		{"--timeout-per-km", "fast"},
+		{"--timeout-per-km", "0.03", "-t", "auto"},
+	} {
+		if _, err := ParseFlags(args, "dev"); err == nil {
+			t.Errorf("Expected an error for %q", args)
+		}
+	}
+}
+
 func TestParseFlagsWorkers(t *testing.T) {
 	t.Run("Workers short flag", func(t *testing.T) {
 		cfg, err := ParseFlags([]string{"-w", "50"}, "dev")
@@ -540,42 +736,1714 @@ func TestParseFlagsBestServerMode(t *testing.T) {
 	})
 }
 
-func TestPrintUsage(t *testing.T) {
-	var buf bytes.Buffer
-	PrintUsage(&buf, "1.2.3")
-	got := buf.String()
+func TestParseFlagsNoSummary(t *testing.T) {
+	t.Run("Summary enabled by default", func(t *testing.T) {
+		cfg, err := ParseFlags([]string{"-m", "100"}, "dev")
+		if err != nil {
+			t.Fatalf("Failed to parse flags: %v", err)
+		}
+		if cfg.NoSummary {
+			t.Error("Expected NoSummary to be false by default")
+		}
+	})
 
-	expected := `mullvad-compass 1.2.3
+	t.Run("No summary flag", func(t *testing.T) {
+		cfg, err := ParseFlags([]string{"-m", "100", "--no-summary"}, "dev")
+		if err != nil {
+			t.Fatalf("Failed to parse flags: %v", err)
+		}
+		if !cfg.NoSummary {
+			t.Error("Expected NoSummary to be true")
+		}
+	})
+}
 
-Find Mullvad VPN servers with the lowest latency at your current location.
+func TestParseFlagsPerCity(t *testing.T) {
+	cfg, err := ParseFlags([]string{}, "dev")
+	if err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+	if cfg.PerCity {
+		t.Error("Expected PerCity to be false by default")
+	}
 
-USAGE:
-    mullvad-compass [OPTIONS]
+	cfg, err = ParseFlags([]string{"--per-city"}, "dev")
+	if err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+	if !cfg.PerCity {
+		t.Error("Expected PerCity to be true")
+	}
+	if cfg.BestServerMode {
+		t.Error("Expected --per-city to enable table mode")
+	}
+}
 
-MODES:
-    Best Server Mode (default):   Shows your location and the single best server.
-                                  Activated when running without filter options.
+func TestParseFlagsLayout(t *testing.T) {
+	cfg, err := ParseFlags([]string{}, "dev")
+	if err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+	if cfg.Layout != LayoutTable {
+		t.Errorf("Expected the %q layout by default, got %q", LayoutTable, cfg.Layout)
+	}
 
-    Table Mode:                   Shows all matching servers in a table, sorted by latency.
-                                  Activated by using any filter option (-m, -a, -d, -6).
+	cfg, err = ParseFlags([]string{"--layout", "table"}, "dev")
+	if err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+	if !cfg.BestServerMode {
+		t.Error("Expected --layout table to keep best server mode")
+	}
 
-FILTER OPTIONS (Table Mode):
-    -m, --max-distance KM         Maximum distance in km from your location (default: 500, range: 1-20000)
-    -a, --anti-censorship TYPE    Filter servers by anti-censorship type (lwo, quic, shadowsocks)
-    -d, --daita                   Filter servers with DAITA enabled
-    -6, --ipv6                    Use IPv6 addresses for pinging
+	cfg, err = ParseFlags([]string{"--layout", "grouped"}, "dev")
+	if err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+	if cfg.Layout != LayoutGrouped {
+		t.Errorf("Layout = %q, want %q", cfg.Layout, LayoutGrouped)
+	}
+	if cfg.BestServerMode {
+		t.Error("Expected --layout grouped to enable table mode")
+	}
 
-PERFORMANCE OPTIONS:
-    -t, --timeout MS              Ping timeout in milliseconds (default: 500, range: 100-5000)
-    -w, --workers COUNT           Number of concurrent ping workers (default: 25, range: 1-200)
+	for _, args := range [][]string{
+		{"--layout"},
+		{"--layout", "tree"},
+		{"--layout", "grouped", "--plain"},
+		{"--layout", "grouped", "--best-in", "SE"},
+	} {
+		if _, err := ParseFlags(args, "dev"); err == nil {
+			t.Errorf("Expected an error for %q", args)
+		}
+	}
+}
 
-OTHER OPTIONS:
-    -l, --log-level LEVEL         Set log level (debug, info, warning, error; default: error)
-    -h, --help                    Show this help message
-    -v, --version                 Show version information
-`
+func TestParseFlagsShowIPs(t *testing.T) {
+	cfg, err := ParseFlags([]string{}, "dev")
+	if err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+	if cfg.ShowIPs != "" {
+		t.Errorf("Expected no IP columns by default, got %q", cfg.ShowIPs)
+	}
 
-	if got != expected {
-		t.Errorf("PrintUsage output mismatch:\nGot:\n%s\nExpected:\n%s", got, expected)
+	for _, value := range []string{ShowIPsBoth, ShowIPsV4, ShowIPsV6} {
+		cfg, err := ParseFlags([]string{"--show-ips", value}, "dev")
+		if err != nil {
+			t.Fatalf("Failed to parse flags: %v", err)
+		}
+		if cfg.ShowIPs != value {
+			t.Errorf("ShowIPs = %q, want %q", cfg.ShowIPs, value)
+		}
+	}
+
+	for _, tt := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"-6"}, ShowIPsV6},
+		{[]string{"--ip-version", "4"}, ShowIPsV4},
+		{[]string{"--ip-version", "auto"}, ""},
+		{[]string{"--ip-version", "both"}, ShowIPsBoth},
+		{[]string{"-6", "--show-ips", "both"}, ShowIPsBoth},
+	} {
+		cfg, err := ParseFlags(tt.args, "dev")
+		if err != nil {
+			t.Fatalf("Failed to parse flags: %v", err)
+		}
+		if cfg.ShowIPs != tt.want {
+			t.Errorf("ShowIPs for %q = %q, want %q", tt.args, cfg.ShowIPs, tt.want)
+		}
+	}
+
+	for _, args := range [][]string{{"--show-ips"}, {"--show-ips", "v5"}} {
+		if _, err := ParseFlags(args, "dev"); err == nil {
+			t.Errorf("Expected an error for %q", args)
+		}
+	}
+}
+
+func TestParseFlagsPcap(t *testing.T) {
+	cfg, err := ParseFlags([]string{"--pcap", "pings.pcap"}, "dev")
+	if err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+	if cfg.PcapFile != "pings.pcap" {
+		t.Errorf("PcapFile = %q, want %q", cfg.PcapFile, "pings.pcap")
+	}
+
+	for _, args := range [][]string{{"--pcap"}, {"--pcap", ""}} {
+		if _, err := ParseFlags(args, "dev"); err == nil {
+			t.Errorf("Expected an error for %q", args)
+		}
+	}
+}
+
+func TestParseFlagsRank(t *testing.T) {
+	cfg, err := ParseFlags([]string{}, "dev")
+	if err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+	if cfg.Rank != RankLatency || cfg.DistanceWeight != DefaultDistanceWeight {
+		t.Errorf("Expected latency ranking by default, got %q with weight %v", cfg.Rank, cfg.DistanceWeight)
+	}
+
+	cfg, err = ParseFlags([]string{"--rank", "combined", "--distance-weight", "0.5"}, "dev")
+	if err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+	if cfg.Rank != RankCombined || cfg.DistanceWeight != 0.5 {
+		t.Errorf("Expected combined ranking with weight 0.5, got %q with weight %v", cfg.Rank, cfg.DistanceWeight)
+	}
+
+	for _, args := range [][]string{
+		{"--rank"},
+		{"--rank", "distance"},
+		{"--rank", "combined", "--distance-weight", "1.5"},
+		{"--rank", "combined", "--distance-weight", "heavy"},
+		{"--distance-weight", "0.5"},
+	} {
+		if _, err := ParseFlags(args, "dev"); err == nil {
+			t.Errorf("Expected an error for %q", args)
+		}
+	}
+}
+
+func TestParseFlagsService(t *testing.T) {
+	cfg, err := ParseFlags([]string{"service", "install", "--every", "1h", "--retain", "30d"}, "dev")
+	if err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+	if cfg.Command != CommandService || cfg.Args[0] != ActionInstall || cfg.Every != time.Hour {
+		t.Errorf("Expected service install every 1h, got %q %v every %v", cfg.Command, cfg.Args, cfg.Every)
+	}
+
+	for _, args := range [][]string{
+		{"service", "install"},
+		{"service", "install", "--retain", "7d"},
+		{"service", "remove"},
+	} {
+		if _, err := ParseFlags(args, "dev"); err != nil {
+			t.Errorf("Unexpected error for %q: %v", args, err)
+		}
+	}
+
+	for _, args := range [][]string{
+		{"service"},
+		{"service", "start"},
+		{"service", "install", "remove"},
+		{"service", "remove", "--every", "1h"},
+	} {
+		if _, err := ParseFlags(args, "dev"); err == nil {
+			t.Errorf("Expected an error for %q", args)
+		}
+	}
+}
+
+func TestParseFlagsPaths(t *testing.T) {
+	for _, args := range [][]string{{"paths"}, {"paths", "config"}} {
+		cfg, err := ParseFlags(args, "dev")
+		if err != nil {
+			t.Fatalf("Unexpected error for %q: %v", args, err)
+		}
+		if cfg.Command != CommandPaths || len(cfg.Args) != len(args)-1 {
+			t.Errorf("Expected the paths command with %v, got %q %v", args[1:], cfg.Command, cfg.Args)
+		}
+	}
+
+	if _, err := ParseFlags([]string{"paths", "config", "cache"}, "dev"); err == nil {
+		t.Error("Expected an error for two path names")
+	}
+}
+
+func TestParseFlagsApply(t *testing.T) {
+	cfg, err := ParseFlags([]string{"apply", "--wg-config", "/etc/wireguard/mullvad.conf", "--restart"}, "dev")
+	if err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+	if cfg.Command != CommandApply || cfg.WGConfig != "/etc/wireguard/mullvad.conf" || !cfg.Restart ||
+		!cfg.BestServerMode {
+		t.Errorf("Expected apply to rewrite and restart in best server mode, got %q %q %v %v",
+			cfg.Command, cfg.WGConfig, cfg.Restart, cfg.BestServerMode)
+	}
+
+	for _, args := range [][]string{
+		{"apply"},
+		{"apply", "--wg-config"},
+		{"apply", "--wg-config", "mullvad.conf", "-m", "1000"},
+		{"apply", "--wg-config", "mullvad.conf", "-s", "bridge"},
+		{"--wg-config", "mullvad.conf"},
+		{"--restart"},
+	} {
+		if _, err := ParseFlags(args, "dev"); err == nil {
+			t.Errorf("Expected an error for %q", args)
+		}
+	}
+}
+
+func TestParseFlagsGoodEnough(t *testing.T) {
+	cfg, err := ParseFlags([]string{"--good-enough", "15"}, "dev")
+	if err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+	if cfg.GoodEnough != 15 || !cfg.BestServerMode {
+		t.Errorf("Expected a 15 ms threshold in best server mode, got %d (best server: %v)",
+			cfg.GoodEnough, cfg.BestServerMode)
+	}
+
+	for _, args := range [][]string{
+		{"--good-enough"},
+		{"--good-enough", "0"},
+		{"--good-enough", "fast"},
+		{"--good-enough", "15", "--stability", "10"},
+		{"--good-enough", "15", "--ip-version", "both"},
+	} {
+		if _, err := ParseFlags(args, "dev"); err == nil {
+			t.Errorf("Expected an error for %q", args)
+		}
+	}
+}
+
+func TestParseFlagsConstraintCost(t *testing.T) {
+	cfg, err := ParseFlags([]string{"--constraint-cost"}, "dev")
+	if err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+	if !cfg.ConstraintCost || !cfg.UseAppSettings {
+		t.Errorf("Expected --constraint-cost to use the app settings, got %v (app settings: %v)",
+			cfg.ConstraintCost, cfg.UseAppSettings)
+	}
+
+	for _, args := range [][]string{
+		{"--constraint-cost", "-m", "1000"},
+		{"--constraint-cost", "--best-in", "se"},
+		{"--constraint-cost", "--share", "json"},
+		{"--constraint-cost", "--good-enough", "15"},
+	} {
+		if _, err := ParseFlags(args, "dev"); err == nil {
+			t.Errorf("Expected an error for %q", args)
+		}
+	}
+}
+
+func TestParseFlagsOffline(t *testing.T) {
+	cfg, err := ParseFlags([]string{"--offline", "--user-agent", "compass-test/1.0"}, "dev")
+	if err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+	if !cfg.Offline || !cfg.AppLocation || cfg.UserAgent != "compass-test/1.0" {
+		t.Errorf("Expected offline with the app location and a custom user agent, got %v, %v, %q",
+			cfg.Offline, cfg.AppLocation, cfg.UserAgent)
+	}
+
+	for _, args := range [][]string{
+		{"--user-agent"},
+		{"--user-agent", ""},
+		{"--user-agent", "compass\r\nX-Injected: 1"},
+		{"--offline", "--update-relays"},
+		{"--offline", "--doh"},
+		{"--offline", "check"},
+		{"--offline", "tunnel", "1.1.1.1:443"},
+	} {
+		if _, err := ParseFlags(args, "dev"); err == nil {
+			t.Errorf("Expected an error for %q", args)
+		}
+	}
+}
+
+func TestParseFlagsPrecision(t *testing.T) {
+	cfg, err := ParseFlags([]string{}, "dev")
+	if err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+	if cfg.Precision != 2 || cfg.Microseconds {
+		t.Errorf("Expected 2 decimals in milliseconds by default, got %d (microseconds: %v)",
+			cfg.Precision, cfg.Microseconds)
+	}
+
+	cfg, err = ParseFlags([]string{"--precision", "0", "--us"}, "dev")
+	if err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+	if cfg.Precision != 0 || !cfg.Microseconds {
+		t.Errorf("Expected 0 decimals in microseconds, got %d (microseconds: %v)", cfg.Precision, cfg.Microseconds)
+	}
+
+	for _, args := range [][]string{
+		{"--precision"},
+		{"--precision", "-1"},
+		{"--precision", "7"},
+		{"--precision", "x"},
+	} {
+		if _, err := ParseFlags(args, "dev"); err == nil {
+			t.Errorf("Expected an error for %q", args)
+		}
+	}
+}
+
+func TestParseFlagsEvery(t *testing.T) {
+	cfg, err := ParseFlags([]string{}, "dev")
+	if err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+	if cfg.Every != 0 || cfg.Retain != DefaultRetain {
+		t.Errorf("Expected a single run with the default retention, got every %v, retain %v", cfg.Every, cfg.Retain)
+	}
+
+	cfg, err = ParseFlags([]string{"--every", "15m", "--retain", "30d"}, "dev")
+	if err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+	if cfg.Every != 15*time.Minute || cfg.Retain != 30*24*time.Hour {
+		t.Errorf("Expected every 15m, retain 30d, got every %v, retain %v", cfg.Every, cfg.Retain)
+	}
+
+	for _, args := range [][]string{
+		{"--every"},
+		{"--every", "30s"},
+		{"--every", "often"},
+		{"--every", "1h", "--retain", "-1d"},
+		{"--every", "1h", "--retain", "xd"},
+		{"--retain", "7d"},
+		{"ports", "--every", "1h"},
+	} {
+		if _, err := ParseFlags(args, "dev"); err == nil {
+			t.Errorf("Expected an error for %q", args)
+		}
+	}
+}
+
+func TestParseFlagsPlan(t *testing.T) {
+	cfg, err := ParseFlags([]string{"plan", "--cities", "Lisbon, Tokyo,NYC", "-d"}, "dev")
+	if err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+	if cfg.Command != CommandPlan {
+		t.Errorf("Expected the plan command, got %q", cfg.Command)
+	}
+	if want := []string{"Lisbon", "Tokyo", "NYC"}; !slices.Equal(cfg.Cities, want) {
+		t.Errorf("Cities = %q, want %q", cfg.Cities, want)
+	}
+
+	for _, args := range [][]string{
+		{"plan"},
+		{"plan", "--cities"},
+		{"plan", "--cities", "Lisbon,,Tokyo"},
+		{"--cities", "Lisbon"},
+	} {
+		if _, err := ParseFlags(args, "dev"); err == nil {
+			t.Errorf("Expected an error for %q", args)
+		}
+	}
+}
+
+func TestParseFlagsValidate(t *testing.T) {
+	cfg, err := ParseFlags([]string{"validate", "dump.json"}, "dev")
+	if err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+	if cfg.Command != CommandValidate || !slices.Equal(cfg.Args, []string{"dump.json"}) {
+		t.Errorf("Expected validate with dump.json, got %q %q", cfg.Command, cfg.Args)
+	}
+
+	for _, args := range [][]string{{"validate"}, {"validate", "a.json", "b.json"}} {
+		if _, err := ParseFlags(args, "dev"); err == nil {
+			t.Errorf("Expected an error for %q", args)
+		}
+	}
+}
+
+func TestParseFlagsUseAppSettings(t *testing.T) {
+	cfg, err := ParseFlags([]string{}, "dev")
+	if err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+	if cfg.UseAppSettings {
+		t.Error("Expected UseAppSettings to be false by default")
+	}
+
+	cfg, err = ParseFlags([]string{"--use-app-settings"}, "dev")
+	if err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+	if !cfg.UseAppSettings {
+		t.Error("Expected UseAppSettings to be true")
+	}
+	if !cfg.BestServerMode {
+		t.Error("Expected --use-app-settings to keep best server mode")
+	}
+}
+
+func TestParseFlagsAppLocation(t *testing.T) {
+	cfg, err := ParseFlags([]string{"--app-location"}, "dev")
+	if err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+	if !cfg.AppLocation || !cfg.BestServerMode {
+		t.Error("Expected --app-location to be set and to keep best server mode")
+	}
+}
+
+func TestParseFlagsPlain(t *testing.T) {
+	cfg, err := ParseFlags([]string{}, "dev")
+	if err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+	if cfg.Plain {
+		t.Error("Expected Plain to be false by default")
+	}
+
+	cfg, err = ParseFlags([]string{"--plain"}, "dev")
+	if err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+	if !cfg.Plain {
+		t.Error("Expected Plain to be true")
+	}
+	if !cfg.BestServerMode {
+		t.Error("Expected --plain to keep best server mode")
+	}
+}
+
+func TestParseFlagsPretty(t *testing.T) {
+	cfg, err := ParseFlags([]string{}, "dev")
+	if err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+	if cfg.Pretty {
+		t.Error("Expected Pretty to be false by default")
+	}
+
+	cfg, err = ParseFlags([]string{"--pretty"}, "dev")
+	if err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+	if !cfg.Pretty {
+		t.Error("Expected Pretty to be true")
+	}
+
+	if _, err := ParseFlags([]string{"--pretty", "--plain"}, "dev"); err == nil {
+		t.Error("Expected an error for --pretty with --plain")
+	}
+}
+
+func TestParseFlagsASNDatabase(t *testing.T) {
+	cfg, err := ParseFlags([]string{"--asn-db", "/tmp/GeoLite2-ASN.mmdb"}, "dev")
+	if err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+	if cfg.ASNDatabase != "/tmp/GeoLite2-ASN.mmdb" {
+		t.Errorf("ASNDatabase = %q, want %q", cfg.ASNDatabase, "/tmp/GeoLite2-ASN.mmdb")
+	}
+
+	for _, args := range [][]string{{"--asn-db"}, {"--asn-db", ""}} {
+		if _, err := ParseFlags(args, "dev"); err == nil {
+			t.Errorf("Expected an error for %q", args)
+		}
+	}
+}
+
+func TestParseFlagsCalibrate(t *testing.T) {
+	cfg, err := ParseFlags([]string{"--calibrate", "1.1.1.1"}, "dev")
+	if err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+	if cfg.Calibrate != "1.1.1.1" {
+		t.Errorf("Calibrate = %q, want %q", cfg.Calibrate, "1.1.1.1")
+	}
+
+	for _, args := range [][]string{{"--calibrate"}, {"--calibrate", ""}} {
+		if _, err := ParseFlags(args, "dev"); err == nil {
+			t.Errorf("Expected an error for %q", args)
+		}
+	}
+}
+
+func TestParseFlagsBaseline(t *testing.T) {
+	cfg, err := ParseFlags([]string{"--baseline"}, "dev")
+	if err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+	if !cfg.Baseline || cfg.BestServerMode {
+		t.Errorf("Baseline = %v, BestServerMode = %v, want true, false", cfg.Baseline, cfg.BestServerMode)
+	}
+
+	for _, args := range [][]string{
+		{"--baseline", "--best-in", "se"},
+		{"--baseline", "--per-city"},
+		{"--baseline", "--layout", "grouped"},
+		{"--baseline", "--stability", "10"},
+	} {
+		if _, err := ParseFlags(args, "dev"); err == nil {
+			t.Errorf("Expected an error for %q", args)
+		}
+	}
+}
+
+func TestParseFlagsAsymmetry(t *testing.T) {
+	cfg, err := ParseFlags([]string{"--asymmetry"}, "dev")
+	if !ping.TimestampSupported {
+		if err == nil {
+			t.Error("Expected an error where timestamp requests are not supported")
+		}
+		return
+	}
+	if err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+	if !cfg.Asymmetry || !cfg.BestServerMode {
+		t.Errorf("Asymmetry = %v, BestServerMode = %v, want true, true", cfg.Asymmetry, cfg.BestServerMode)
+	}
+
+	for _, args := range [][]string{
+		{"--asymmetry", "-6"},
+		{"--asymmetry", "--ip-version", "auto"},
+		{"--asymmetry", "--ip-version", "both"},
+	} {
+		if _, err := ParseFlags(args, "dev"); err == nil || !strings.Contains(err.Error(), "only supports IPv4") {
+			t.Errorf("Expected an IPv4 only error for %q, got %v", args, err)
+		}
+	}
+}
+
+func TestParseFlagsAllowPrivate(t *testing.T) {
+	cfg, err := ParseFlags([]string{}, "dev")
+	if err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+	if cfg.AllowPrivate {
+		t.Error("Expected restricted addresses to be refused by default")
+	}
+
+	cfg, err = ParseFlags([]string{"--allow-private", "--calibrate", "127.0.0.1"}, "dev")
+	if err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+	if !cfg.AllowPrivate {
+		t.Error("Expected --allow-private to be set")
+	}
+}
+
+func TestParseFlagsRuns(t *testing.T) {
+	cfg, err := ParseFlags([]string{}, "dev")
+	if err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+	if cfg.Runs != 1 {
+		t.Errorf("Expected a single run by default, got %d", cfg.Runs)
+	}
+
+	cfg, err = ParseFlags([]string{"--runs", "5"}, "dev")
+	if err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+	if cfg.Runs != 5 || !cfg.BestServerMode {
+		t.Errorf("Runs = %d, BestServerMode = %v, want 5, true", cfg.Runs, cfg.BestServerMode)
+	}
+
+	for _, args := range [][]string{
+		{"--runs"},
+		{"--runs", "0"},
+		{"--runs", "11"},
+		{"--runs", "three"},
+		{"--runs", "3", "--stability", "10"},
+		{"--runs", "3", "--good-enough", "20"},
+	} {
+		if _, err := ParseFlags(args, "dev"); err == nil {
+			t.Errorf("Expected an error for %q", args)
+		}
+	}
+}
+
+func TestParseFlagsVerifyOutliers(t *testing.T) {
+	cfg, err := ParseFlags([]string{"--verify-outliers"}, "dev")
+	if err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+	if !cfg.VerifyOutliers || !cfg.BestServerMode {
+		t.Errorf("VerifyOutliers = %v, BestServerMode = %v, want true, true", cfg.VerifyOutliers, cfg.BestServerMode)
+	}
+}
+
+func TestParseFlagsSummaryOnly(t *testing.T) {
+	cfg, err := ParseFlags([]string{"--summary-only"}, "dev")
+	if err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+	if !cfg.SummaryOnly || !cfg.BestServerMode {
+		t.Errorf("SummaryOnly = %v, BestServerMode = %v, want true, true", cfg.SummaryOnly, cfg.BestServerMode)
+	}
+
+	for _, args := range [][]string{
+		{"ports", "--summary-only"},
+		{"--summary-only", "--share", "json"},
+		{"--summary-only", "--output", "geojson"},
+		{"--summary-only", "--stability", "10"},
+		{"--summary-only", "--no-summary"},
+		{"--summary-only", "--timings"},
+	} {
+		if _, err := ParseFlags(args, "dev"); err == nil {
+			t.Errorf("Expected an error for %q", args)
+		}
+	}
+}
+
+func TestParseFlagsMaxWidth(t *testing.T) {
+	cfg, err := ParseFlags([]string{"--max-width", "ipv6=20, ASN=24"}, "dev")
+	if err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+	if !maps.Equal(cfg.MaxWidths, map[string]int{"ipv6": 20, "asn": 24}) {
+		t.Errorf("MaxWidths = %v, want ipv6=20, asn=24", cfg.MaxWidths)
+	}
+
+	for _, args := range [][]string{
+		{"--max-width"},
+		{"--max-width", "ipv6"},
+		{"--max-width", "latency=10"},
+		{"--max-width", "ipv6=3"},
+		{"--max-width", "ipv6=101"},
+		{"--max-width", "ipv6=20,asn"},
+	} {
+		if _, err := ParseFlags(args, "dev"); err == nil {
+			t.Errorf("Expected an error for %q", args)
+		}
+	}
+}
+
+func TestParseFlagsPreset(t *testing.T) {
+	cfg, err := ParseFlags([]string{"--preset", "privacy"}, "dev")
+	if err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+	if !cfg.OwnedOnly || !cfg.StbootOnly || !cfg.Daita || cfg.BestServerMode || cfg.Preset != PresetPrivacy {
+		t.Errorf("Unexpected privacy preset: %+v", cfg)
+	}
+
+	cfg, err = ParseFlags([]string{"--preset", "speed", "-d"}, "dev")
+	if err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+	if !cfg.TopWeight || !cfg.Daita || cfg.OwnedOnly {
+		t.Errorf("Unexpected speed preset: %+v", cfg)
+	}
+
+	cfg, err = ParseFlags([]string{"--preset", "censorship", "--no-quic"}, "dev")
+	if err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+	if cfg.AnyFeature != relays.FeatureQUIC|relays.FeatureShadowsocks {
+		t.Errorf("AnyFeature = %v, want QUIC+Shadowsocks", cfg.AnyFeature)
+	}
+
+	for _, args := range [][]string{
+		{"--preset"},
+		{"--preset", "fast"},
+		{"--preset", "speed", "-a", "quic"},
+		{"--preset", "privacy", "--no-daita"},
+		{"--preset", "censorship", "--no-quic", "--no-shadowsocks"},
+		{"--preset", "censorship", "-s", "bridge"},
+	} {
+		if _, err := ParseFlags(args, "dev"); err == nil {
+			t.Errorf("Expected an error for %q", args)
+		}
+	}
+}
+
+func TestParseFlagsVerifyCoordinates(t *testing.T) {
+	cfg, err := ParseFlags([]string{"--verify-coordinates"}, "dev")
+	if err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+	if !cfg.VerifyCoordinates {
+		t.Error("Expected VerifyCoordinates to be set")
+	}
+
+	if _, err := ParseFlags([]string{"ports", "--verify-coordinates"}, "dev"); err == nil {
+		t.Error("Expected an error for --verify-coordinates with a command")
+	}
+}
+
+func TestParseFlagsMinCityRelays(t *testing.T) {
+	cfg, err := ParseFlags([]string{"--min-city-relays", "3"}, "dev")
+	if err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+	if cfg.MinCityRelays != 3 || !cfg.BestServerMode {
+		t.Errorf("MinCityRelays = %d, BestServerMode = %v, want 3, true", cfg.MinCityRelays, cfg.BestServerMode)
+	}
+
+	for _, args := range [][]string{
+		{"--min-city-relays"},
+		{"--min-city-relays", "1"},
+		{"--min-city-relays", "101"},
+		{"--min-city-relays", "3", "-m", "100"},
+		{"--min-city-relays", "3", "--constraint-cost"},
+	} {
+		if _, err := ParseFlags(args, "dev"); err == nil {
+			t.Errorf("Expected an error for %q", args)
+		}
+	}
+}
+
+func TestParseFlagsTieBreak(t *testing.T) {
+	cfg, err := ParseFlags([]string{"--tie-break", "weight"}, "dev")
+	if err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+	if cfg.TieBreak != relays.TieBreakWeight || !cfg.BestServerMode {
+		t.Errorf("TieBreak = %v, BestServerMode = %v, want weight, true", cfg.TieBreak, cfg.BestServerMode)
+	}
+
+	for _, args := range [][]string{
+		{"--tie-break"},
+		{"--tie-break", "fastest"},
+		{"--tie-break", "random", "-m", "100"},
+		{"--tie-break", "random", "--constraint-cost"},
+	} {
+		if _, err := ParseFlags(args, "dev"); err == nil {
+			t.Errorf("Expected an error for %q", args)
+		}
+	}
+}
+
+func TestParseFlagsOutput(t *testing.T) {
+	cfg, err := ParseFlags(nil, "dev")
+	if err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+	if cfg.Output != OutputText {
+		t.Errorf("Output = %q, want %q", cfg.Output, OutputText)
+	}
+
+	cfg, err = ParseFlags([]string{"--output", "geojson", "-m", "250"}, "dev")
+	if err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+	if cfg.Output != OutputGeoJSON {
+		t.Errorf("Output = %q, want %q", cfg.Output, OutputGeoJSON)
+	}
+
+	cfg, err = ParseFlags([]string{"--output", "html", "report.html"}, "dev")
+	if err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+	if cfg.Output != OutputHTML || cfg.OutputFile != "report.html" || !cfg.BestServerMode {
+		t.Errorf("Output = %q, OutputFile = %q, want %q, %q", cfg.Output, cfg.OutputFile, OutputHTML, "report.html")
+	}
+
+	for _, args := range [][]string{
+		{"--output"},
+		{"--output", "kml"},
+		{"--output", "html"},
+		{"--output", "html", ""},
+		{"--output", "html", "report.html", "--share", "markdown"},
+		{"--output", "geojson", "--share", "json"},
+		{"--output", "geojson", "--stability", "10"},
+		{"check", "--output", "geojson"},
+	} {
+		if _, err := ParseFlags(args, "dev"); err == nil {
+			t.Errorf("Expected an error for %q", args)
+		}
+	}
+}
+
+func TestParseFlagsDoH(t *testing.T) {
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{nil, ""},
+		{[]string{"--doh"}, resolve.DefaultDoHURL},
+		{[]string{"--doh-url", "https://dns.example/dns-query"}, "https://dns.example/dns-query"},
+		{[]string{"--doh-url", "https://dns.example/dns-query", "--doh"}, "https://dns.example/dns-query"},
+	}
+	for _, tt := range tests {
+		cfg, err := ParseFlags(tt.args, "dev")
+		if err != nil {
+			t.Fatalf("Failed to parse flags %q: %v", tt.args, err)
+		}
+		if cfg.DoHURL != tt.want {
+			t.Errorf("DoHURL for %q = %q, want %q", tt.args, cfg.DoHURL, tt.want)
+		}
+	}
+
+	for _, args := range [][]string{
+		{"--doh-url"},
+		{"--doh-url", "http://dns.example/dns-query"},
+		{"--doh-url", "dns.example"},
+	} {
+		if _, err := ParseFlags(args, "dev"); err == nil {
+			t.Errorf("Expected an error for %q", args)
+		}
+	}
+}
+
+func TestParseFlagsSwitchThreshold(t *testing.T) {
+	cfg, err := ParseFlags([]string{"--on-best-change", "true", "--switch-threshold", "20%"}, "dev")
+	if err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+	if cfg.SwitchThreshold != (hooks.SwitchThreshold{Percent: 20}) {
+		t.Errorf("SwitchThreshold = %+v, want 20%%", cfg.SwitchThreshold)
+	}
+
+	for _, args := range [][]string{
+		{"--switch-threshold", "10"},
+		{"--on-best-change", "true", "--switch-threshold"},
+		{"--on-best-change", "true", "--switch-threshold", "fast"},
+	} {
+		if _, err := ParseFlags(args, "dev"); err == nil {
+			t.Errorf("Expected an error for %q", args)
+		}
+	}
+}
+
+func TestParseFlagsLatencyUnder(t *testing.T) {
+	cfg, err := ParseFlags([]string{"--latency-under", "40"}, "dev")
+	if err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+	if cfg.LatencyUnder != 40 {
+		t.Errorf("Expected latency threshold 40, got %d", cfg.LatencyUnder)
+	}
+	if cfg.BestServerMode {
+		t.Error("Expected --latency-under to disable best server mode")
+	}
+
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr string
+	}{
+		{"Missing value", []string{"--latency-under"}, "requires an argument"},
+		{"Not a number", []string{"--latency-under", "fast"}, "invalid latency-under value"},
+		{"Zero", []string{"--latency-under", "0"}, "between 1 and 5000"},
+		{"Too large", []string{"--latency-under", "5001"}, "between 1 and 5000"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseFlags(tt.args, "dev")
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestParseFlagsShare(t *testing.T) {
+	for _, format := range []string{"markdown", "json"} {
+		cfg, err := ParseFlags([]string{"--share", format}, "dev")
+		if err != nil {
+			t.Fatalf("Failed to parse flags: %v", err)
+		}
+		if cfg.Share != format {
+			t.Errorf("Expected share format %q, got %q", format, cfg.Share)
+		}
+		if !cfg.BestServerMode {
+			t.Error("Expected --share to keep best server mode")
+		}
+	}
+
+	_, err := ParseFlags([]string{"--share", "html"}, "dev")
+	if err == nil || !strings.Contains(err.Error(), "invalid share format") {
+		t.Errorf("Expected invalid share format error, got %v", err)
+	}
+
+	_, err = ParseFlags([]string{"--share"}, "dev")
+	if err == nil || !strings.Contains(err.Error(), "requires an argument") {
+		t.Errorf("Expected missing argument error, got %v", err)
+	}
+}
+
+func TestParseFlagsStrict(t *testing.T) {
+	cfg, err := ParseFlags([]string{}, "dev")
+	if err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+	if cfg.Strict {
+		t.Error("Expected Strict to be false by default")
+	}
+
+	cfg, err = ParseFlags([]string{"--strict"}, "dev")
+	if err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+	if !cfg.Strict {
+		t.Error("Expected Strict to be true")
+	}
+}
+
+func TestParseFlagsSample(t *testing.T) {
+	t.Run("Sampling disabled by default", func(t *testing.T) {
+		cfg, err := ParseFlags([]string{}, "dev")
+		if err != nil {
+			t.Fatalf("Failed to parse flags: %v", err)
+		}
+		if cfg.Sample != 0 {
+			t.Errorf("Expected sample to be 0, got %d", cfg.Sample)
+		}
+		if cfg.SeedSet {
+			t.Error("Expected SeedSet to be false by default")
+		}
+	})
+
+	t.Run("Sample with seed and full city", func(t *testing.T) {
+		cfg, err := ParseFlags([]string{"--sample", "3", "--seed", "-42", "--sample-full-city"}, "dev")
+		if err != nil {
+			t.Fatalf("Failed to parse flags: %v", err)
+		}
+		if cfg.Sample != 3 {
+			t.Errorf("Expected sample to be 3, got %d", cfg.Sample)
+		}
+		if !cfg.SeedSet || cfg.Seed != -42 {
+			t.Errorf("Expected seed -42 to be set, got %d (set: %v)", cfg.Seed, cfg.SeedSet)
+		}
+		if !cfg.SampleFullCity {
+			t.Error("Expected SampleFullCity to be true")
+		}
+		if !cfg.BestServerMode {
+			t.Error("Expected sampling options to keep best server mode")
+		}
+	})
+
+	t.Run("Invalid values", func(t *testing.T) {
+		tests := []struct {
+			args []string
+			want string
+		}{
+			{[]string{"--sample", "0"}, "sample must be between 1 and 1000"},
+			{[]string{"--sample", "1001"}, "sample must be between 1 and 1000"},
+			{[]string{"--sample", "abc"}, "invalid sample value"},
+			{[]string{"--sample"}, "requires an argument"},
+			{[]string{"--seed", "x"}, "invalid seed value"},
+			{[]string{"--seed"}, "requires an argument"},
+		}
+		for _, tt := range tests {
+			_, err := ParseFlags(tt.args, "dev")
+			if err == nil {
+				t.Errorf("Expected error for %v", tt.args)
+				continue
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Expected error containing %q for %v, got %v", tt.want, tt.args, err)
+			}
+		}
+	})
+}
+
+func TestParseFlagsFallbackDistance(t *testing.T) {
+	t.Run("Enabled by default", func(t *testing.T) {
+		cfg, err := ParseFlags([]string{}, "dev")
+		if err != nil {
+			t.Fatalf("Failed to parse flags: %v", err)
+		}
+		if !cfg.FallbackDistance {
+			t.Error("Expected FallbackDistance to be true by default")
+		}
+	})
+
+	t.Run("Disabled with flag", func(t *testing.T) {
+		cfg, err := ParseFlags([]string{"--no-fallback-distance"}, "dev")
+		if err != nil {
+			t.Fatalf("Failed to parse flags: %v", err)
+		}
+		if cfg.FallbackDistance {
+			t.Error("Expected FallbackDistance to be false")
+		}
+	})
+
+	t.Run("Last flag wins", func(t *testing.T) {
+		cfg, err := ParseFlags([]string{"--no-fallback-distance", "--fallback-distance"}, "dev")
+		if err != nil {
+			t.Fatalf("Failed to parse flags: %v", err)
+		}
+		if !cfg.FallbackDistance {
+			t.Error("Expected FallbackDistance to be true")
+		}
+	})
+}
+
+func TestParseFlagsHooks(t *testing.T) {
+	t.Run("Hook commands", func(t *testing.T) {
+		cfg, err := ParseFlags([]string{
+			"--pre-run", "echo pre",
+			"--post-run", "echo post",
+			"--on-best-change", "echo change",
+		}, "dev")
+		if err != nil {
+			t.Fatalf("Failed to parse flags: %v", err)
+		}
+		if cfg.PreRunHook != "echo pre" {
+			t.Errorf("Expected pre-run hook 'echo pre', got %q", cfg.PreRunHook)
+		}
+		if cfg.PostRunHook != "echo post" {
+			t.Errorf("Expected post-run hook 'echo post', got %q", cfg.PostRunHook)
+		}
+		if cfg.BestChangeHook != "echo change" {
+			t.Errorf("Expected on-best-change hook 'echo change', got %q", cfg.BestChangeHook)
+		}
+		if !cfg.BestServerMode {
+			t.Error("Expected hooks to keep best server mode")
+		}
+	})
+
+	t.Run("Missing command", func(t *testing.T) {
+		_, err := ParseFlags([]string{"--post-run"}, "dev")
+		if err == nil || !strings.Contains(err.Error(), "requires an argument") {
+			t.Errorf("Expected missing argument error, got %v", err)
+		}
+	})
+
+	t.Run("Empty command", func(t *testing.T) {
+		_, err := ParseFlags([]string{"--pre-run", ""}, "dev")
+		if err == nil || !strings.Contains(err.Error(), "requires a non-empty command") {
+			t.Errorf("Expected empty command error, got %v", err)
+		}
+	})
+}
+
+func TestParseFlagsProbeSource(t *testing.T) {
+	t.Run("Interface and source address", func(t *testing.T) {
+		cfg, err := ParseFlags([]string{"--interface", "eth0", "--source-ip", "192.0.2.10"}, "dev")
+		if err != nil {
+			t.Fatalf("Failed to parse flags: %v", err)
+		}
+		if cfg.Interface != "eth0" {
+			t.Errorf("Expected interface 'eth0', got %q", cfg.Interface)
+		}
+		if cfg.SourceIP != "192.0.2.10" {
+			t.Errorf("Expected source IP '192.0.2.10', got %q", cfg.SourceIP)
+		}
+		if !cfg.BestServerMode {
+			t.Error("Expected probe source options to keep best server mode")
+		}
+	})
+
+	t.Run("IPv6 source address", func(t *testing.T) {
+		cfg, err := ParseFlags([]string{"--source-ip", "2001:db8::10", "-6"}, "dev")
+		if err != nil {
+			t.Fatalf("Failed to parse flags: %v", err)
+		}
+		if cfg.SourceIP != "2001:db8::10" {
+			t.Errorf("Expected source IP '2001:db8::10', got %q", cfg.SourceIP)
+		}
+	})
+
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr string
+	}{
+		{"Missing interface", []string{"--interface"}, "requires an argument"},
+		{"Empty interface", []string{"--interface", ""}, "requires a non-empty value"},
+		{"Invalid source address", []string{"--source-ip", "not-an-ip"}, "invalid source-ip value"},
+		{"IPv6 source without -6", []string{"--source-ip", "2001:db8::10"}, "does not match"},
+		{"IPv4 source with -6", []string{"-6", "--source-ip", "192.0.2.10"}, "does not match"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseFlags(tt.args, "dev")
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestParseFlagsNetns(t *testing.T) {
+	cfg, err := ParseFlags([]string{"--netns", "lte"}, "dev")
+	if !ping.NetnsSupported {
+		if err == nil || !strings.Contains(err.Error(), "only supported on Linux") {
+			t.Errorf("Expected a Linux only error, got %v", err)
+		}
+		return
+	}
+	if err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+	if cfg.Netns != "lte" {
+		t.Errorf("Expected network namespace 'lte', got %q", cfg.Netns)
+	}
+
+	for _, args := range [][]string{{"--netns"}, {"--netns", ""}, {"--netns", "../lte"}} {
+		if _, err := ParseFlags(args, "dev"); err == nil {
+			t.Errorf("Expected an error for %q", args)
+		}
+	}
+}
+
+func TestParseFlagsSudo(t *testing.T) {
+	cfg, err := ParseFlags([]string{"--sudo", "--asymmetry"}, "dev")
+	if !elevate.Supported {
+		if err == nil || !strings.Contains(err.Error(), "only supported on Linux") {
+			t.Errorf("Expected a Linux only error, got %v", err)
+		}
+		return
+	}
+	if err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+	if !cfg.Sudo {
+		t.Error("Expected Sudo to be set")
+	}
+}
+
+func TestParseFlagsCommand(t *testing.T) {
+	t.Run("No command by default", func(t *testing.T) {
+		cfg, err := ParseFlags([]string{"-m", "100"}, "dev")
+		if err != nil {
+			t.Fatalf("Failed to parse flags: %v", err)
+		}
+		if cfg.Command != "" {
+			t.Errorf("Expected empty command, got %q", cfg.Command)
+		}
+	})
+
+	t.Run("Check command with options", func(t *testing.T) {
+		cfg, err := ParseFlags([]string{"check", "-l", "debug"}, "dev")
+		if err != nil {
+			t.Fatalf("Failed to parse flags: %v", err)
+		}
+		if cfg.Command != CommandCheck {
+			t.Errorf("Expected command %q, got %q", CommandCheck, cfg.Command)
+		}
+		if cfg.LogLevel != logging.LogLevelDebug {
+			t.Errorf("Expected debug log level, got %v", cfg.LogLevel)
+		}
+	})
+
+	t.Run("Bench command", func(t *testing.T) {
+		cfg, err := ParseFlags([]string{"bench-internal", "2000"}, "dev")
+		if err != nil {
+			t.Fatalf("Failed to parse flags: %v", err)
+		}
+		if cfg.Command != CommandBench || len(cfg.Args) != 1 || cfg.Args[0] != "2000" {
+			t.Errorf("Expected bench-internal with a relay count, got %q %q", cfg.Command, cfg.Args)
+		}
+
+		for _, args := range [][]string{
+			{"bench-internal", "0"},
+			{"bench-internal", "many"},
+			{"bench-internal", "10", "20"},
+		} {
+			if _, err := ParseFlags(args, "dev"); err == nil {
+				t.Errorf("Expected an error for %q", args)
+			}
+		}
+	})
+
+	t.Run("Ports command", func(t *testing.T) {
+		cfg, err := ParseFlags([]string{"ports", "-a", "shadowsocks"}, "dev")
+		if err != nil {
+			t.Fatalf("Failed to parse flags: %v", err)
+		}
+		if cfg.Command != CommandPorts {
+			t.Errorf("Expected command %q, got %q", CommandPorts, cfg.Command)
+		}
+		if cfg.AntiCensorship != relays.Shadowsocks {
+			t.Errorf("Expected shadowsocks filter, got %v", cfg.AntiCensorship)
+		}
+	})
+
+	t.Run("Capabilities command with filters", func(t *testing.T) {
+		cfg, err := ParseFlags([]string{"capabilities", "-d", "--plain"}, "dev")
+		if err != nil {
+			t.Fatalf("Failed to parse flags: %v", err)
+		}
+		if cfg.Command != CommandCapabilities {
+			t.Errorf("Expected command %q, got %q", CommandCapabilities, cfg.Command)
+		}
+		if !cfg.Daita || !cfg.Plain {
+			t.Errorf("Expected DAITA filter and plain output, got %+v", cfg)
+		}
+	})
+
+	t.Run("Compare command with run files", func(t *testing.T) {
+		cfg, err := ParseFlags([]string{"compare", "before.json", "after.json"}, "dev")
+		if err != nil {
+			t.Fatalf("Failed to parse flags: %v", err)
+		}
+		if cfg.Command != CommandCompare {
+			t.Errorf("Expected command %q, got %q", CommandCompare, cfg.Command)
+		}
+		if strings.Join(cfg.Args, "|") != "before.json|after.json" {
+			t.Errorf("Expected run files [before.json after.json], got %v", cfg.Args)
+		}
+	})
+
+	t.Run("Tunnel command with destinations", func(t *testing.T) {
+		cfg, err := ParseFlags([]string{"tunnel", "1.1.1.1:443", "example.com:80", "-t", "1000"}, "dev")
+		if err != nil {
+			t.Fatalf("Failed to parse flags: %v", err)
+		}
+		if cfg.Command != CommandTunnel {
+			t.Errorf("Expected command %q, got %q", CommandTunnel, cfg.Command)
+		}
+		if strings.Join(cfg.Args, "|") != "1.1.1.1:443|example.com:80" || cfg.Timeout != 1000 {
+			t.Errorf("Expected two destinations and a 1000 ms timeout, got %v and %d", cfg.Args, cfg.Timeout)
+		}
+
+		if _, err := ParseFlags([]string{"tunnel", "1.1.1.1"}, "dev"); err == nil {
+			t.Error("Expected an error for a destination without a port")
+		}
+	})
+
+	t.Run("Compare requires two run files", func(t *testing.T) {
+		for _, args := range [][]string{
+			{"compare"},
+			{"compare", "before.json"},
+			{"compare", "a.json", "b.json", "c.json"},
+		} {
+			_, err := ParseFlags(args, "dev")
+			if err == nil || !strings.Contains(err.Error(), "exactly two run files") {
+				t.Errorf("Expected run file count error for %v, got %v", args, err)
+			}
+		}
+	})
+
+	t.Run("Run files are only accepted by compare", func(t *testing.T) {
+		_, err := ParseFlags([]string{"ports", "before.json"}, "dev")
+		if err == nil || !strings.Contains(err.Error(), "unexpected argument: before.json") {
+			t.Errorf("Expected unexpected argument error, got %v", err)
+		}
+	})
+
+	t.Run("Command must come first", func(t *testing.T) {
+		_, err := ParseFlags([]string{"-l", "debug", "check"}, "dev")
+		if err == nil || !strings.Contains(err.Error(), "unexpected argument: check") {
+			t.Errorf("Expected unexpected argument error, got %v", err)
+		}
+	})
+
+	t.Run("Unknown command", func(t *testing.T) {
+		_, err := ParseFlags([]string{"frobnicate"}, "dev")
+		if err == nil || !strings.Contains(err.Error(), "unexpected argument: frobnicate") {
+			t.Errorf("Expected unexpected argument error, got %v", err)
+		}
+	})
+}
+
+func TestPrintUsage(t *testing.T) {
+	var buf bytes.Buffer
+	PrintUsage(&buf, "1.2.3")
+	got := buf.String()
+
+	expected := `mullvad-compass 1.2.3
+
+Find Mullvad VPN servers with the lowest latency at your current location.
+
+USAGE:
+    mullvad-compass [OPTIONS]
+    mullvad-compass COMMAND [OPTIONS]
+    mullvad-compass compare RUN1.json RUN2.json
+    mullvad-compass favorite|ignore add|remove HOSTNAME...
+    mullvad-compass favorite|ignore list
+    mullvad-compass tunnel [HOST:PORT...]
+    mullvad-compass plan --cities CITY[,CITY...]
+    mullvad-compass validate FILE
+    mullvad-compass service install|remove
+    mullvad-compass paths [NAME]
+
+COMMANDS:
+    check                         Show exit IP, Mullvad connection, blacklist, and DNS leak status
+    ports                         List WireGuard and Shadowsocks ports (honors -c, -a, -d, -6)
+    capabilities                  Show which DAITA, LWO, QUIC, Shadowsocks, and IPv6 combinations each city
+                                  offers, without pinging (honors -c, -a, -d, -6, --plain)
+    compare RUN1 RUN2             Show per-relay latency and rank changes between two runs
+                                  recorded with --post-run 'cat > RUN.json'
+    favorite ACTION [HOSTNAME...] Add, remove, or list favorite relays (marked with ★ in output)
+    ignore ACTION [HOSTNAME...]   Add, remove, or list ignored relays (excluded from searches)
+    tunnel [HOST:PORT...]         Measure latency to destinations through the connected relay's SOCKS5 proxy
+                                  (default: 1.1.1.1:443, 8.8.8.8:443, 9.9.9.9:443)
+    plan --cities CITIES          List the 3 relays nearest to each city you are traveling to, by distance and
+                                  without pinging (e.g. "Lisbon,Tokyo,NYC"; honors -c, -a, -d, -6, --show-ips)
+    validate FILE                 Check a relays.json for structural problems, unknown endpoint formats, missing
+                                  coordinates, and duplicate hostnames or addresses
+    service ACTION                Install or remove a Windows scheduled task that searches with the given flags every
+                                  15 minutes (or --every) from system startup, recording the history store (needs an
+                                  elevated prompt)
+    paths [NAME]                  Print the config, history, cache, relays and app settings paths in use, honoring
+                                  environment overrides, or only the path named NAME (e.g. "config", "relays")
+    apply --wg-config FILE        Find the best server and point the [Peer] of a wg-quick configuration at it,
+                                  keeping a .bak copy (--restart also takes the interface down and up again)
+
+MODES:
+    Best Server Mode (default):   Shows your location and the single best server.
+                                  Activated when running without filter options, or with --best-in.
+
+    Table Mode:                   Shows all matching servers in a table, sorted by latency.
+                                  Activated by using any filter option (-m, -c, -s, -a, -d, -6, --preset,
+                                  --latency-under, --favorites-only), --per-city or --layout grouped.
+
+FILTER OPTIONS (Table Mode):
+    -m, --max-distance KM         Maximum distance in km from your location (default: 500, range: 1-20000)
+    -c, --country COUNTRY         Filter servers by country name or code, comma-separated (e.g. "Czechia,DE")
+                                  Searches the whole country unless -m is given
+    -s, --server-type TYPE        Server type to search (wireguard, bridge; default: wireguard)
+    -a, --anti-censorship TYPE    Filter servers by anti-censorship type (lwo, quic, shadowsocks)
+    -d, --daita                   Filter servers with DAITA enabled
+        --preset NAME             Apply a bundle of filters:
+                                    privacy     Mullvad-owned servers running stboot, with DAITA (-d)
+                                    speed       No anti-censorship, and only the highest-weighted servers of
+                                                each city, the ones the Mullvad app prefers
+                                    censorship  Servers offering QUIC or Shadowsocks
+        --no-daita                Exclude servers with DAITA enabled
+        --no-lwo, --no-quic, --no-shadowsocks
+                                  Exclude servers supporting the anti-censorship protocol
+    -6, --ipv6                    Use IPv6 addresses for pinging
+        --latency-under MS        Only show servers that responded in less than MS milliseconds (range: 1-5000)
+        --favorites-only          Only search favorite relays
+        --best-in PLACE           Show the best server in a country or city (e.g. "US", "Gothenburg"), pinging all
+                                  of its servers instead of searching by distance (Best Server Mode)
+        --min-city-relays N       If the best server's city has fewer than N relays to fail over to, also show the
+                                  best server in a city with at least N (Best Server Mode, range: 2-100)
+        --tie-break POLICY        Pick the best server among those within 1 ms of it (first, random, weight,
+                                  alphabetical; default: first). weight picks at random by relay weight, like the
+                                  Mullvad app (Best Server Mode)
+
+PERFORMANCE OPTIONS:
+    -t, --timeout MS              Ping timeout in milliseconds, or "auto" to tune it (default: 500, range: 100-5000)
+        --timeout-per-km MS       Add MS milliseconds to the timeout of each server per km of its distance, so that
+                                  nearby servers fail fast and distant ones get enough headroom (e.g. 0.03; the
+                                  timeout starts from 100 unless -t is given, and is capped at 5000)
+    -w, --workers COUNT           Number of concurrent ping workers (default: up to 8 per CPU, range: 1-200)
+        --sample N                Ping at most N randomly chosen servers per city (range: 1-1000)
+        --seed N                  Random seed for the probe order and --sample (default: random)
+        --good-enough MS          Stop pinging as soon as a server responds in less than MS milliseconds and show
+                                  the best server found so far instead of a complete ranking (range: 1-5000)
+        --sample-full-city        After sampling, ping all servers in the best city
+        --stability SECONDS       Ping the 10 best servers every second for SECONDS and rank them by loss and
+                                  latency deviation (enables Table Mode, range: 1-600)
+        --runs N                  Ping the servers N times, a second apart, and rank them by their median latency,
+                                  so that a passing congestion spike does not decide the ranking (range: 1-10)
+        --verify-outliers         Ping servers that time out or respond much slower than the other servers in their
+                                  city once more, keeping the better latency
+
+NETWORK OPTIONS:
+        --ip-version VERSION      IP version to ping over (4, 6, auto, both; default: 4). auto pings the nearest
+                                  servers over both and uses the one with the lower median latency. both pings every
+                                  server over IPv4 and IPv6, showing both latencies (enables Table Mode)
+        --interface NAME          Send pings from a network interface (e.g. eth0)
+        --source-ip ADDR          Send pings from a source address (must match -6)
+        --netns NAME              Send pings from a named network namespace, e.g. one per uplink (Linux only)
+        --sudo                    When ICMP sockets are not permitted, offer to run again as root with sudo or
+                                  pkexec instead of failing (Linux only)
+        --calibrate HOST          Also ping a reference host (e.g. your router or 1.1.1.1) and show latencies
+                                  relative to it
+        --baseline                Also ping anycast hosts (Cloudflare, Google DNS) and list them below the servers
+                                  as a baseline of what good latency looks like from your connection (enables
+                                  Table Mode)
+        --asymmetry               Experimental: estimate the upload and download delays of the 5 best servers from
+                                  ICMP timestamp replies (IPv4 only, needs root or CAP_NET_RAW)
+        --allow-private           Allow pinging loopback, link-local and multicast addresses, which are refused as
+                                  the --calibrate host and skipped in relays.json
+        --doh                     Resolve the API, relay list and --calibrate hosts over DNS-over-HTTPS with
+                                  Mullvad's resolver (dns.mullvad.net) instead of the system's, which may be
+                                  tampered with. The DNS leak test of check still uses the system's.
+        --doh-url URL             Resolve hostnames over a different DNS-over-HTTPS endpoint (implies --doh)
+
+OUTPUT OPTIONS:
+        --no-summary              Do not print the summary line after the table (Table Mode)
+        --summary-only            Print only one line of key=value pairs: the best server, its latency, the number
+                                  of servers that responded and were pinged, and the run time (for cron and logs)
+        --per-city                Show only the best server of each city (enables Table Mode)
+        --layout LAYOUT           Table Mode layout (table, grouped; default: table). grouped lists servers under
+                                  country and city headers (enables Table Mode)
+        --show-ips FAMILY         Addresses shown (both, v4, v6; default: both, or the family given with -6 or
+                                  --ip-version)
+        --max-width COLUMN=N      Cut the cells of a column to at most N characters, replacing their middle with
+                                  an ellipsis; comma-separated for several columns (e.g. "ipv6=20,asn=24"; country,
+                                  city, hostname, ip, ipv4, ipv6, asn; range: 4-100)
+        --precision N             Decimal places of latencies (default: 2, range: 0-6)
+        --us                      Show latencies in microseconds instead of milliseconds
+        --features                Show a Features column flagging the capabilities of each server: D (DAITA),
+                                  L (LWO), Q (QUIC), S (Shadowsocks), 6 (IPv6) (Table Mode)
+        --plain                   Print one labeled line per server instead of aligned columns (screen readers)
+        --pretty                  Show country flags and full country names (e.g. "🇺🇸 United States")
+        --asn-db FILE             Show the network operator (ASN) of each server from a MaxMind DB such as
+                                  GeoLite2-ASN.mmdb
+        --share FORMAT            Print an anonymized report for posting publicly (markdown, json)
+        --output FORMAT           Output format (text, geojson, html FILE; default: text). geojson prints the
+                                  ranked servers and your location as a GeoJSON FeatureCollection for map tools.
+                                  html also writes a standalone report with a sortable table and a map to FILE
+        --timings                 Print how long each phase took (parse, filter, geoip, ping, sort, format)
+        --rank MODE               Rank responding servers by latency, or by a combined score of latency and
+                                  distance for when latencies are within noise (latency, combined; default: latency)
+        --distance-weight W       Share of the distance in the combined score (default: 0.3, range: 0-1)
+        --fallback-distance       Rank servers by distance when none respond to ping (default)
+        --no-fallback-distance    Show timeouts instead of ranking by distance when none respond
+
+HOOK OPTIONS:
+        --pre-run COMMAND         Run a shell command before pinging servers
+        --post-run COMMAND        Run a shell command after printing results
+        --on-best-change COMMAND  Run a shell command when the best server differs from the last run
+        --switch-threshold VALUE  Only run --on-best-change when the new best server is faster than the last one
+                                  by more than VALUE milliseconds or percent (e.g. 10 or 20%)
+                                  Hooks receive results as JSON on stdin and in MULLVAD_COMPASS_* variables
+
+SCHEDULE OPTIONS:
+        --every INTERVAL          Keep running, searching every INTERVAL (e.g. 15m, 1h; minimum: 1m), and record
+                                  the results in the history store. An alternative to cron or Task Scheduler
+        --retain AGE              Drop runs older than AGE from the history store (e.g. 12h, 30d; default: 7d)
+
+OTHER OPTIONS:
+    -l, --log-level LEVEL         Set log level (debug, info, warning, error; default: error)
+        --use-app-settings        Apply the Mullvad app's relay constraints (location, provider, ownership, obfuscation)
+        --constraint-cost         Show the best server with and without the app's relay constraints, and what they cost
+        --app-location            Use the location cached by the Mullvad app instead of asking the Mullvad API
+        --offline                 Make no network calls other than the pings, taking the location from the Mullvad app
+        --user-agent UA           Send UA as the User-Agent of Mullvad API requests
+        --update-relays           Download the relay list from the Mullvad API instead of using the app's cache
+        --include-ignored         Also search relays on the ignore list
+        --include-unlocated       Also search relays that relays.json lists without coordinates (at 0°, 0°),
+                                  regardless of distance
+        --strict                  Fail on malformed relay addresses in relays.json instead of skipping them
+        --verify-coordinates      List relays that relays.json places more than 100 km from the city their hostname
+                                  names, for reporting to Mullvad, and exit without pinging
+        --no-lock                 Ping even if another run is in progress (by default, concurrent runs fail)
+        --wg-config FILE          wg-quick configuration the apply command points at the best server
+        --restart                 Run wg-quick down and up after apply changed the configuration
+        --pcap FILE               Record the ICMP packets sent and received while pinging to FILE, for
+                                  Wireshark or tcpdump (not supported on Windows)
+    -h, --help                    Show this help message
+    -v, --version                 Show version information
+        --verbose                 With --version, also show the commit, build date, Go version and platform
+`
+
+	if got != expected {
+		t.Errorf("PrintUsage output mismatch:\nGot:\n%s\nExpected:\n%s", got, expected)
+	}
+}
+
+func TestParseFlagsHostLists(t *testing.T) {
+	cfg, err := ParseFlags([]string{"favorite", "add", "de-ber-wg-001", "se-got-wg-101"}, "dev")
+	if err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+	if cfg.Command != CommandFavorite {
+		t.Errorf("Expected command %q, got %q", CommandFavorite, cfg.Command)
+	}
+	if strings.Join(cfg.Args, "|") != "add|de-ber-wg-001|se-got-wg-101" {
+		t.Errorf("Expected arguments [add de-ber-wg-001 se-got-wg-101], got %v", cfg.Args)
+	}
+
+	cfg, err = ParseFlags([]string{"--favorites-only"}, "dev")
+	if err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+	if !cfg.FavoritesOnly || cfg.BestServerMode {
+		t.Error("Expected --favorites-only to be set and to disable best server mode")
+	}
+
+	cfg, err = ParseFlags([]string{"ignore", "list"}, "dev")
+	if err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+	if cfg.Command != CommandIgnore || strings.Join(cfg.Args, "|") != "list" {
+		t.Errorf("Expected ignore list, got command %q with arguments %v", cfg.Command, cfg.Args)
+	}
+
+	cfg, err = ParseFlags([]string{"--include-ignored"}, "dev")
+	if err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+	if !cfg.IncludeIgnored || !cfg.BestServerMode {
+		t.Error("Expected --include-ignored to be set and to keep best server mode")
+	}
+
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr string
+	}{
+		{"Missing action", []string{"favorite"}, "requires an action"},
+		{"Unknown action", []string{"favorite", "pin"}, "invalid favorite action"},
+		{"Add without hostname", []string{"favorite", "add"}, "requires at least one hostname"},
+		{"Remove without hostname", []string{"favorite", "remove"}, "requires at least one hostname"},
+		{"List with arguments", []string{"favorite", "list", "de-ber-wg-001"}, "takes no arguments"},
+		{"Ignore without action", []string{"ignore"}, "ignore requires an action"},
+		{"Unknown ignore action", []string{"ignore", "clear"}, "invalid ignore action"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseFlags(tt.args, "dev")
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestParseFlagsTimings(t *testing.T) {
+	cfg, err := ParseFlags([]string{"--timings"}, "dev")
+	if err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+	if !cfg.Timings || !cfg.BestServerMode {
+		t.Error("Expected --timings to be set and to keep best server mode")
+	}
+}
+
+func TestParseFlagsNoLock(t *testing.T) {
+	cfg, err := ParseFlags([]string{}, "dev")
+	if err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+	if cfg.NoLock {
+		t.Error("Expected the run lock to be enabled by default")
+	}
+
+	cfg, err = ParseFlags([]string{"--no-lock"}, "dev")
+	if err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+	if !cfg.NoLock {
+		t.Error("Expected --no-lock to disable the run lock")
+	}
+}
+
+func TestParseFlagsUpdateRelays(t *testing.T) {
+	cfg, err := ParseFlags([]string{"--update-relays"}, "dev")
+	if err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+	if !cfg.UpdateRelays {
+		t.Error("Expected --update-relays to be set")
+	}
+	if !cfg.BestServerMode {
+		t.Error("Expected --update-relays to keep best server mode")
+	}
+}
+
+func TestParseFlagsStability(t *testing.T) {
+	cfg, err := ParseFlags([]string{"--stability", "30"}, "dev")
+	if err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+	if cfg.Stability != 30 {
+		t.Errorf("Expected a 30 second stability window, got %d", cfg.Stability)
+	}
+	if cfg.BestServerMode {
+		t.Error("Expected --stability to disable best server mode")
+	}
+
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr string
+	}{
+		{"Missing value", []string{"--stability"}, "requires an argument"},
+		{"Not a number", []string{"--stability", "long"}, "invalid stability value"},
+		{"Zero", []string{"--stability", "0"}, "between 1 and 600"},
+		{"Too large", []string{"--stability", "601"}, "between 1 and 600"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseFlags(tt.args, "dev")
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestParseFlagsBestIn(t *testing.T) {
+	cfg, err := ParseFlags([]string{"--best-in", "US", "-d"}, "dev")
+	if err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+	if cfg.BestIn != "US" {
+		t.Errorf("Expected best-in US, got %q", cfg.BestIn)
+	}
+	if !cfg.BestServerMode {
+		t.Error("Expected --best-in to keep best server mode")
+	}
+
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr string
+	}{
+		{"Missing value", []string{"--best-in"}, "requires an argument"},
+		{"Empty value", []string{"--best-in", " "}, "invalid best-in value"},
+		{"With country", []string{"--best-in", "US", "-c", "DE"}, "cannot be combined"},
+		{"With max distance", []string{"-m", "100", "--best-in", "US"}, "cannot be combined"},
+		{"With per city", []string{"--best-in", "US", "--per-city"}, "cannot be combined"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseFlags(tt.args, "dev")
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestParseFlagsIPVersion(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		want     relays.IPVersion
+		wantAuto bool
+	}{
+		{"Default", []string{}, relays.IPv4, false},
+		{"IPv4", []string{"--ip-version", "4"}, relays.IPv4, false},
+		{"IPv6", []string{"--ip-version", "6"}, relays.IPv6, false},
+		{"Auto", []string{"--ip-version", "auto"}, relays.IPv4, true},
+		{"Short flag overrides auto", []string{"--ip-version", "auto", "-6"}, relays.IPv6, false},
+		{"IPv6 source", []string{"--ip-version", "auto", "--source-ip", "2001:db8::1"}, relays.IPv6, false},
+		{"IPv4 source", []string{"--ip-version", "auto", "--source-ip", "192.0.2.1"}, relays.IPv4, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := ParseFlags(tt.args, "dev")
+			if err != nil {
+				t.Fatalf("Failed to parse flags: %v", err)
+			}
+			if cfg.IPVersion != tt.want || cfg.AutoIPVersion != tt.wantAuto {
+				t.Errorf("Expected %s (auto: %v), got %s (auto: %v)",
+					tt.want, tt.wantAuto, cfg.IPVersion, cfg.AutoIPVersion)
+			}
+		})
+	}
+
+	cfg, err := ParseFlags([]string{"--ip-version", "auto"}, "dev")
+	if err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+	if !cfg.BestServerMode {
+		t.Error("Expected --ip-version to keep best server mode")
+	}
+
+	cfg, err = ParseFlags([]string{"--ip-version", "both"}, "dev")
+	if err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+	if !cfg.DualStack || cfg.IPVersion != relays.IPv4 || cfg.BestServerMode || cfg.ShowIPs != ShowIPsBoth {
+		t.Errorf("Expected dual-stack Table Mode showing both addresses, got dual stack %v, %s, best server %v, "+
+			"show-ips %q", cfg.DualStack, cfg.IPVersion, cfg.BestServerMode, cfg.ShowIPs)
+	}
+
+	cfg, err = ParseFlags([]string{"--ip-version", "both", "-6"}, "dev")
+	if err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+	if cfg.DualStack {
+		t.Error("Expected -6 to override --ip-version both")
+	}
+
+	for _, args := range [][]string{
+		{"--ip-version"},
+		{"--ip-version", "5"},
+		{"--ip-version", "both", "--source-ip", "192.0.2.1"},
+		{"--ip-version", "both", "--best-in", "Berlin"},
+	} {
+		if _, err := ParseFlags(args, "dev"); err == nil {
+			t.Errorf("Expected an error for %v", args)
+		}
 	}
 }
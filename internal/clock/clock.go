@@ -0,0 +1,47 @@
+// Package clock abstracts the passing of time for retries, backoff and schedules, so that tests can run them on
+// virtual time instead of sleeping. Code reads the clock carried by its context, which is the system clock unless a
+// test put another one there.
+package clock
+
+import "time"
+
+// Clock tells the time and waits
+type Clock interface {
+	Now() time.Time
+	// After returns a channel receiving the time once d has passed
+	After(d time.Duration) <-chan time.Time
+	// NewTicker returns a ticker sending the time every d, dropping ticks a slow receiver misses
+	NewTicker(d time.Duration) Ticker
+}
+
+// Ticker delivers ticks at intervals
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// Real is the system clock
+var Real Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+// realTicker adapts a time.Ticker to Ticker
+type realTicker struct {
+	*time.Ticker
+}
+
+func (t realTicker) C() <-chan time.Time {
+	return t.Ticker.C
+}
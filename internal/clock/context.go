@@ -0,0 +1,18 @@
+package clock
+
+import "context"
+
+type contextKey struct{}
+
+// WithClock returns a context carrying the clock, for the waits deep in the call chain to use
+func WithClock(ctx context.Context, c Clock) context.Context {
+	return context.WithValue(ctx, contextKey{}, c)
+}
+
+// FromContext returns the clock carried by the context, or Real if there is none
+func FromContext(ctx context.Context) Clock {
+	if c, ok := ctx.Value(contextKey{}).(Clock); ok {
+		return c
+	}
+	return Real
+}
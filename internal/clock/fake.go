@@ -0,0 +1,100 @@
+package clock
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// Fake is a virtual clock for tests of sequential code. Waiting with After moves the clock forward at once, as if
+// the wait had passed, and records the wait. Tickers tick whenever the clock passes their next tick, through After
+// or Advance.
+type Fake struct {
+	mu      sync.Mutex
+	now     time.Time
+	waits   []time.Duration
+	tickers []*fakeTicker
+}
+
+// NewFake returns a virtual clock starting at start
+func NewFake(start time.Time) *Fake {
+	return &Fake{now: start}
+}
+
+// Now returns the virtual time
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+// After moves the clock forward by d and returns a channel holding the new time
+func (f *Fake) After(d time.Duration) <-chan time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.waits = append(f.waits, d)
+	f.advance(d)
+
+	ch := make(chan time.Time, 1)
+	ch <- f.now
+	return ch
+}
+
+// NewTicker returns a ticker ticking every d of virtual time
+func (f *Fake) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("clock: non-positive interval for NewTicker")
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	t := &fakeTicker{clock: f, c: make(chan time.Time, 1), interval: d, next: f.now.Add(d)}
+	f.tickers = append(f.tickers, t)
+	return t
+}
+
+// Advance moves the clock forward by d, ticking the tickers it passes
+func (f *Fake) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.advance(d)
+}
+
+// Waits returns the durations waited with After, in order
+func (f *Fake) Waits() []time.Duration {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return slices.Clone(f.waits)
+}
+
+// advance moves the clock forward by d, with the lock held
+func (f *Fake) advance(d time.Duration) {
+	f.now = f.now.Add(d)
+	for _, t := range f.tickers {
+		for !t.next.After(f.now) {
+			// Like a time.Ticker, a tick the receiver is not ready for is dropped
+			select {
+			case t.c <- t.next:
+			default:
+			}
+			t.next = t.next.Add(t.interval)
+		}
+	}
+}
+
+// fakeTicker is a ticker of a Fake clock
+type fakeTicker struct {
+	clock    *Fake
+	c        chan time.Time
+	interval time.Duration
+	next     time.Time
+}
+
+func (t *fakeTicker) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTicker) Stop() {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	t.clock.tickers = slices.DeleteFunc(t.clock.tickers, func(other *fakeTicker) bool { return other == t })
+}
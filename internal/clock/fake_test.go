@@ -0,0 +1,60 @@
+package clock
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestFake(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	clk := NewFake(start)
+
+	if got := <-clk.After(3 * time.Second); !got.Equal(start.Add(3 * time.Second)) {
+		t.Errorf("Expected After to deliver the time after the wait, got %v", got)
+	}
+	ticker := clk.NewTicker(time.Minute)
+	clk.Advance(150 * time.Second)
+
+	if got := clk.Now(); !got.Equal(start.Add(153 * time.Second)) {
+		t.Errorf("Expected the waits and advances to add up, got %v", got)
+	}
+	// Two ticks were passed, the second is dropped as nobody received the first
+	select {
+	case tick := <-ticker.C():
+		if !tick.Equal(start.Add(63 * time.Second)) {
+			t.Errorf("Expected the first tick a minute after the ticker started, got %v", tick)
+		}
+	default:
+		t.Fatal("Expected a tick")
+	}
+	select {
+	case tick := <-ticker.C():
+		t.Errorf("Expected the missed tick to be dropped, got %v", tick)
+	default:
+	}
+
+	ticker.Stop()
+	clk.Advance(time.Hour)
+	select {
+	case tick := <-ticker.C():
+		t.Errorf("Expected no ticks after Stop, got %v", tick)
+	default:
+	}
+
+	_ = clk.After(time.Millisecond)
+	if want := []time.Duration{3 * time.Second, time.Millisecond}; !slices.Equal(clk.Waits(), want) {
+		t.Errorf("Expected waits %v, got %v", want, clk.Waits())
+	}
+}
+
+func TestFromContext(t *testing.T) {
+	if FromContext(context.Background()) != Real {
+		t.Error("Expected the system clock without a clock in the context")
+	}
+	clk := NewFake(time.Time{})
+	if FromContext(WithClock(context.Background(), clk)) != clk {
+		t.Error("Expected the clock carried by the context")
+	}
+}
@@ -0,0 +1,139 @@
+// Package compare compares the results of two mullvad-compass runs.
+package compare
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/Ch00k/mullvad-compass/internal/hooks"
+)
+
+// RegressionThreshold is the relative latency increase above which a relay counts as regressed
+const RegressionThreshold = 0.10
+
+// Status classifies how a relay changed between two runs
+type Status int
+
+// Status constants
+const (
+	Unchanged Status = iota // Latency within the regression threshold
+	Improved                // Latency decreased beyond the threshold, or the relay started responding
+	Regressed               // Latency increased beyond the threshold, or the relay stopped responding
+	Added                   // Only present in the second run
+	Removed                 // Only present in the first run
+)
+
+func (s Status) String() string {
+	switch s {
+	case Improved:
+		return "improved"
+	case Regressed:
+		return "regressed"
+	case Added:
+		return "new"
+	case Removed:
+		return "gone"
+	default:
+		return ""
+	}
+}
+
+// Delta is the change of a single relay between two runs
+type Delta struct {
+	Server        hooks.Server // From the second run, or the first if the relay is gone
+	BeforeLatency *float64     // nil indicates timeout or absence
+	AfterLatency  *float64     // nil indicates timeout or absence
+	BeforeRank    int          // 1-based, 0 when absent
+	AfterRank     int          // 1-based, 0 when absent
+	Status        Status
+}
+
+// Change returns the latency difference in milliseconds, or nil unless both runs have a latency
+func (d Delta) Change() *float64 {
+	if d.BeforeLatency == nil || d.AfterLatency == nil {
+		return nil
+	}
+	change := *d.AfterLatency - *d.BeforeLatency
+	return &change
+}
+
+// Load reads the servers of a run recorded from the post_run hook, e.g. with --post-run 'cat > run.json'
+func Load(path string) ([]hooks.Server, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read run file: %w", err)
+	}
+
+	var payload hooks.Payload
+	if err := json.Unmarshal(data, &payload); err != nil {
+		return nil, fmt.Errorf("failed to parse run file %s: %w", path, err)
+	}
+	if len(payload.Servers) == 0 {
+		return nil, fmt.Errorf("run file %s contains no servers (record it with --post-run)", path)
+	}
+
+	return payload.Servers, nil
+}
+
+// Compare matches relays of two runs by hostname. Servers are expected best first, as recorded.
+// Deltas are ordered by rank in the second run, followed by relays that are gone.
+func Compare(before, after []hooks.Server) []Delta {
+	beforeRank := make(map[string]int, len(before))
+	for i, s := range before {
+		beforeRank[s.Hostname] = i + 1
+	}
+	afterHosts := make(map[string]bool, len(after))
+
+	deltas := make([]Delta, 0, len(after))
+	for i, s := range after {
+		afterHosts[s.Hostname] = true
+		d := Delta{Server: s, AfterLatency: s.Latency, AfterRank: i + 1}
+		if rank, ok := beforeRank[s.Hostname]; ok {
+			d.BeforeRank = rank
+			d.BeforeLatency = before[rank-1].Latency
+			d.Status = classify(d.BeforeLatency, d.AfterLatency)
+		} else {
+			d.Status = Added
+		}
+		deltas = append(deltas, d)
+	}
+
+	var removed []Delta
+	for i, s := range before {
+		if !afterHosts[s.Hostname] {
+			removed = append(removed, Delta{Server: s, BeforeLatency: s.Latency, BeforeRank: i + 1, Status: Removed})
+		}
+	}
+
+	return append(deltas, removed...)
+}
+
+// classify compares the latencies of a relay present in both runs
+func classify(before, after *float64) Status {
+	switch {
+	case before == nil && after == nil:
+		return Unchanged
+	case before == nil:
+		return Improved
+	case after == nil:
+		return Regressed
+	case *after > *before*(1+RegressionThreshold):
+		return Regressed
+	case *after < *before*(1-RegressionThreshold):
+		return Improved
+	default:
+		return Unchanged
+	}
+}
+
+// Count returns the number of deltas with the given status
+func Count(deltas []Delta, status Status) int {
+	count := 0
+	for _, d := range deltas {
+		if d.Status == status {
+			count++
+		}
+	}
+	return count
+}
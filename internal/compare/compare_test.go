@@ -0,0 +1,139 @@
+package compare
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Ch00k/mullvad-compass/internal/hooks"
+)
+
+func server(hostname string, latency *float64) hooks.Server {
+	return hooks.Server{Hostname: hostname, Country: "Sweden", City: "Gothenburg", Latency: latency}
+}
+
+func ms(v float64) *float64 {
+	return &v
+}
+
+func TestCompare(t *testing.T) {
+	before := []hooks.Server{
+		server("se-got-wg-001", ms(10)),
+		server("se-got-wg-002", ms(12)),
+		server("se-got-wg-003", ms(20)),
+		server("se-got-wg-004", ms(25)),
+		server("se-got-wg-005", nil),
+	}
+	after := []hooks.Server{
+		server("se-got-wg-003", ms(11)),
+		server("se-got-wg-001", ms(10.5)),
+		server("se-got-wg-005", ms(14)),
+		server("se-got-wg-006", ms(15)),
+		server("se-got-wg-002", ms(30)),
+		server("se-got-wg-004", nil),
+	}
+
+	deltas := Compare(before, after)
+
+	expected := []struct {
+		hostname   string
+		beforeRank int
+		afterRank  int
+		status     Status
+	}{
+		{"se-got-wg-003", 3, 1, Improved},
+		{"se-got-wg-001", 1, 2, Unchanged},
+		{"se-got-wg-005", 5, 3, Improved},
+		{"se-got-wg-006", 0, 4, Added},
+		{"se-got-wg-002", 2, 5, Regressed},
+		{"se-got-wg-004", 4, 6, Regressed},
+	}
+
+	if len(deltas) != len(expected) {
+		t.Fatalf("Expected %d deltas, got %d", len(expected), len(deltas))
+	}
+	for i, e := range expected {
+		d := deltas[i]
+		if d.Server.Hostname != e.hostname || d.BeforeRank != e.beforeRank || d.AfterRank != e.afterRank {
+			t.Errorf("Delta %d: expected %s %d -> %d, got %s %d -> %d",
+				i, e.hostname, e.beforeRank, e.afterRank, d.Server.Hostname, d.BeforeRank, d.AfterRank)
+		}
+		if d.Status != e.status {
+			t.Errorf("Delta %d (%s): expected status %q, got %q", i, e.hostname, e.status, d.Status)
+		}
+	}
+
+	if change := deltas[4].Change(); change == nil || *change != 18 {
+		t.Errorf("Expected change +18 for se-got-wg-002, got %v", change)
+	}
+	if change := deltas[5].Change(); change != nil {
+		t.Errorf("Expected no change for a timed out relay, got %v", *change)
+	}
+}
+
+func TestCompareRemoved(t *testing.T) {
+	before := []hooks.Server{server("se-got-wg-001", ms(10)), server("se-got-wg-002", ms(12))}
+	after := []hooks.Server{server("se-got-wg-002", ms(12))}
+
+	deltas := Compare(before, after)
+	if len(deltas) != 2 {
+		t.Fatalf("Expected 2 deltas, got %d", len(deltas))
+	}
+	gone := deltas[1]
+	if gone.Server.Hostname != "se-got-wg-001" || gone.Status != Removed || gone.AfterRank != 0 {
+		t.Errorf("Expected se-got-wg-001 to be gone, got %+v", gone)
+	}
+	if Count(deltas, Removed) != 1 || Count(deltas, Unchanged) != 1 {
+		t.Errorf("Unexpected counts: %d removed, %d unchanged", Count(deltas, Removed), Count(deltas, Unchanged))
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	t.Run("Post-run payload", func(t *testing.T) {
+		path := write("run.json", `{"event":"post_run","servers":[
+			{"hostname":"se-got-wg-001","country":"Sweden","city":"Gothenburg","latency_ms":10.5},
+			{"hostname":"se-got-wg-002","country":"Sweden","city":"Gothenburg","latency_ms":null}
+		]}`)
+		servers, err := Load(path)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if len(servers) != 2 || servers[0].Latency == nil || *servers[0].Latency != 10.5 || servers[1].Latency != nil {
+			t.Errorf("Unexpected servers: %+v", servers)
+		}
+	})
+
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{"Invalid JSON", "not json", "failed to parse run file"},
+		{"No servers", `{"event":"pre_run"}`, "contains no servers"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Load(write(tt.name+".json", tt.content))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+
+	t.Run("Missing file", func(t *testing.T) {
+		_, err := Load(filepath.Join(dir, "missing.json"))
+		if err == nil || !strings.Contains(err.Error(), "failed to read run file") {
+			t.Errorf("Expected read error, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,344 @@
+// Package compass provides a reusable session for ranking Mullvad servers across repeated runs.
+package compass
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/Ch00k/mullvad-compass/internal/api"
+	"github.com/Ch00k/mullvad-compass/internal/distance"
+	"github.com/Ch00k/mullvad-compass/internal/formatter"
+	"github.com/Ch00k/mullvad-compass/internal/logging"
+	"github.com/Ch00k/mullvad-compass/internal/ping"
+	"github.com/Ch00k/mullvad-compass/internal/relays"
+	"github.com/Ch00k/mullvad-compass/internal/warnings"
+)
+
+// ErrClosed is returned by Session methods called after Close
+var ErrClosed = errors.New("session is closed")
+
+// ErrNoLocation is returned by Rank on a session that does not locate the user
+var ErrNoLocation = errors.New("session has no user location")
+
+// defaultTimeout is applied to a zero Filters.Timeout, matching the CLI default
+const defaultTimeout = 500
+
+// Filters selects and ranks servers in Session.Rank
+type Filters struct {
+	ServerType     relays.ServerType
+	AntiCensorship relays.AntiCensorship
+	Daita          bool
+	Exclude        relays.Feature // Capabilities a relay must not have, combined with bitwise OR
+	Countries      []string
+	MaxDistance    float64 // Kilometres; 0 means no distance limit
+	Timeout        int     // Ping timeout in milliseconds; 0 means 500
+	Workers        int     // Concurrent pings; 0 picks a count from the servers and the system
+}
+
+// Session holds the parsed relay set, the user's geolocation and an open pinger so that
+// repeated rankings reuse them instead of re-parsing relays.json and re-creating sockets.
+// A Session is safe for concurrent use.
+type Session struct {
+	ipVersion     relays.IPVersion
+	logLevel      logging.LogLevel
+	loadRelays    func(context.Context) (*relays.File, error)
+	locate        func(context.Context) (*api.UserLocation, error)
+	pingerFactory ping.PingerFactory
+
+	mu        sync.RWMutex
+	relays    *relays.File
+	userLoc   *api.UserLocation
+	refreshed time.Time
+	pinger    ping.Pinger
+	closed    bool
+	rankings  sync.WaitGroup
+}
+
+// Option configures a Session
+type Option func(*Session)
+
+// WithIPVersion sets the IP version used for pinging (default IPv4)
+func WithIPVersion(ipVersion relays.IPVersion) Option {
+	return func(s *Session) {
+		s.ipVersion = ipVersion
+	}
+}
+
+// WithLogLevel sets the logging level
+func WithLogLevel(logLevel logging.LogLevel) Option {
+	return func(s *Session) {
+		s.logLevel = logLevel
+	}
+}
+
+// WithRelaysLoader sets the function used to load the relay set (default: the platform relays.json)
+func WithRelaysLoader(load func(context.Context) (*relays.File, error)) Option {
+	return func(s *Session) {
+		s.loadRelays = load
+	}
+}
+
+// WithLocator sets the function used to geolocate the user (default: the Mullvad API). A nil function skips
+// geolocation, for callers that locate the user themselves and only Ping through the session.
+func WithLocator(locate func(context.Context) (*api.UserLocation, error)) Option {
+	return func(s *Session) {
+		s.locate = locate
+	}
+}
+
+// WithPingerFactory sets the factory used to create the session's pinger
+func WithPingerFactory(factory ping.PingerFactory) Option {
+	return func(s *Session) {
+		s.pingerFactory = factory
+	}
+}
+
+// NewSession creates a session, opening its pinger and loading the relay set and geolocation.
+// The caller must Close the session to release the pinger.
+func NewSession(ctx context.Context, opts ...Option) (*Session, error) {
+	s := &Session{
+		ipVersion:     relays.IPv4,
+		logLevel:      logging.LogLevelError,
+		pingerFactory: ping.NewDefaultPingerFactory(),
+	}
+	s.loadRelays = s.loadDefaultRelays
+	s.locate = s.locateWithAPI
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	pinger, err := s.pingerFactory.CreatePinger(s.ipVersion)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create pinger: %w", err)
+	}
+	s.pinger = pinger
+
+	if err := s.Refresh(ctx); err != nil {
+		_ = pinger.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// loadDefaultRelays parses the platform relays.json, skipping relays with malformed or restricted addresses
+func (s *Session) loadDefaultRelays(ctx context.Context) (*relays.File, error) {
+	path, err := relays.GetRelaysFilePathWithLogLevel(s.logLevel)
+	if err != nil {
+		return nil, err
+	}
+	file, err := relays.ParseRelaysFileContext(ctx, path, s.logLevel)
+	if err != nil {
+		return nil, err
+	}
+	skipped, err := relays.ValidateEndpoints(file, false)
+	if err != nil {
+		return nil, err
+	}
+	if skipped > 0 {
+		warnings.FromContext(ctx).Add(
+			warnings.SkippedRelays,
+			"%d relay endpoint(s) skipped due to malformed address",
+			skipped,
+		)
+	}
+	if removed := relays.RemoveRestricted(file); removed > 0 {
+		warnings.FromContext(ctx).Add(
+			warnings.SkippedRelays,
+			"%d relay(s) skipped due to a loopback, link-local or multicast address",
+			removed,
+		)
+	}
+	return file, nil
+}
+
+// locateWithAPI asks the Mullvad API for the user's location
+func (s *Session) locateWithAPI(ctx context.Context) (*api.UserLocation, error) {
+	return api.NewClient(api.WithLogLevel(s.logLevel)).GetUserLocation(ctx)
+}
+
+// Refresh reloads the relay set and geolocation. On error the previous state is kept. Relays skipped for
+// malformed data are reported to the warnings collector of ctx, if any.
+func (s *Session) Refresh(ctx context.Context) error {
+	// Taken before loading, so that a file changed while it is read is not mistaken for the loaded one
+	started := time.Now()
+	file, err := s.loadRelays(ctx)
+	if err != nil {
+		return err
+	}
+	var userLoc *api.UserLocation
+	if s.locate != nil {
+		if userLoc, err = s.locate(ctx); err != nil {
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+	s.relays = file
+	s.userLoc = userLoc
+	s.refreshed = started
+
+	if s.logLevel <= logging.LogLevelDebug {
+		log.Printf("Session refreshed: %d WireGuard relays, %d bridge relays",
+			len(file.WireGuard.Relays), len(file.Bridge.Relays))
+	}
+
+	return nil
+}
+
+// Refreshed returns the time the last Refresh started loading the relay set
+func (s *Session) Refreshed() time.Time {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.refreshed
+}
+
+// IPVersion returns the IP version the session pings with
+func (s *Session) IPVersion() relays.IPVersion {
+	return s.ipVersion
+}
+
+// UserLocation returns the geolocation from the last Refresh, nil if the session does not locate the user
+func (s *Session) UserLocation() *api.UserLocation {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.userLoc
+}
+
+// Relays returns the relay set from the last Refresh. It must not be modified.
+func (s *Session) Relays() *relays.File {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.relays
+}
+
+// Rank pings the servers matching filters and returns them sorted by latency, then distance. Relays skipped for
+// malformed data are reported to the warnings collector of ctx, if any.
+func (s *Session) Rank(ctx context.Context, filters Filters) ([]relays.Location, error) {
+	s.mu.RLock()
+	if s.closed {
+		s.mu.RUnlock()
+		return nil, ErrClosed
+	}
+	file, userLoc, pinger := s.relays, s.userLoc, s.pinger
+	s.rankings.Add(1)
+	s.mu.RUnlock()
+	defer s.rankings.Done()
+
+	if userLoc == nil {
+		return nil, ErrNoLocation
+	}
+
+	var locations []relays.Location
+	var skipped int
+	var err error
+	if filters.ServerType == relays.BridgeServer {
+		locations, skipped, err = relays.GetBridgeLocationsContext(ctx, file, s.ipVersion)
+	} else {
+		locations, skipped, err = relays.GetLocationsContext(
+			ctx,
+			file,
+			filters.AntiCensorship,
+			filters.Daita,
+			filters.Exclude,
+			s.ipVersion,
+		)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if skipped > 0 {
+		warnings.FromContext(ctx).Add(
+			warnings.SkippedRelays,
+			"%d relay(s) skipped due to unresolvable location key",
+			skipped,
+		)
+	}
+
+	if len(filters.Countries) > 0 {
+		locations = relays.FilterByCountry(locations, filters.Countries)
+	}
+
+	if filters.MaxDistance > 0 {
+		locations, err = distance.FilterByDistanceContext(
+			ctx,
+			locations,
+			userLoc.Latitude,
+			userLoc.Longitude,
+			filters.MaxDistance,
+			s.logLevel,
+		)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		distance.AnnotateDistances(locations, userLoc.Latitude, userLoc.Longitude)
+	}
+
+	timeout := filters.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	locations, err = ping.LocationsWithPinger(
+		ctx,
+		locations,
+		timeout,
+		filters.Workers,
+		s.ipVersion,
+		pinger,
+		s.logLevel,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	formatter.SortLocationsByLatency(locations)
+	return locations, nil
+}
+
+// Ping pings the locations with the session's pinger and returns them with their latencies, as
+// ping.LocationsWithPinger does. It is the part of Rank that callers filtering servers themselves need.
+func (s *Session) Ping(
+	ctx context.Context,
+	locations []relays.Location,
+	timeout, workers int,
+	opts ...ping.Option,
+) ([]relays.Location, error) {
+	s.mu.RLock()
+	if s.closed {
+		s.mu.RUnlock()
+		return nil, ErrClosed
+	}
+	pinger := s.pinger
+	s.rankings.Add(1)
+	s.mu.RUnlock()
+	defer s.rankings.Done()
+
+	if s.logLevel <= logging.LogLevelInfo {
+		log.Printf("Pinging %d locations with the session's pinger (IP version: %s)", len(locations), s.ipVersion)
+	}
+	return ping.LocationsWithPinger(ctx, locations, timeout, workers, s.ipVersion, pinger, s.logLevel, opts...)
+}
+
+// Close waits for in-flight rankings to finish and releases the session's pinger.
+// Calling Close more than once is a no-op.
+func (s *Session) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	s.mu.Unlock()
+
+	s.rankings.Wait()
+	return s.pinger.Close()
+}
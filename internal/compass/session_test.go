@@ -0,0 +1,346 @@
+package compass
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Ch00k/mullvad-compass/internal/api"
+	"github.com/Ch00k/mullvad-compass/internal/ping"
+	"github.com/Ch00k/mullvad-compass/internal/relays"
+	"github.com/Ch00k/mullvad-compass/internal/warnings"
+)
+
+// newTestSession creates a session backed by testdata, a fixed location in Berlin and mock pingers
+func newTestSession(t *testing.T, opts ...Option) (*Session, *ping.MockPingerFactory, *int) {
+	t.Helper()
+
+	factory := ping.NewMockPingerFactory()
+	loads := 0
+	defaults := []Option{
+		WithPingerFactory(factory),
+		WithRelaysLoader(func(context.Context) (*relays.File, error) {
+			loads++
+			return relays.ParseRelaysFile("../../testdata/relays.json")
+		}),
+		WithLocator(func(context.Context) (*api.UserLocation, error) {
+			return &api.UserLocation{Latitude: 52.52, Longitude: 13.405}, nil
+		}),
+	}
+
+	s, err := NewSession(context.Background(), append(defaults, opts...)...)
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	t.Cleanup(func() { _ = s.Close() })
+
+	return s, factory, &loads
+}
+
+func TestNewSessionLoadsState(t *testing.T) {
+	s, factory, loads := newTestSession(t)
+
+	if *loads != 1 {
+		t.Errorf("Expected relays to be loaded once, got %d", *loads)
+	}
+	if s.Relays() == nil || len(s.Relays().WireGuard.Relays) == 0 {
+		t.Error("Expected relays to be loaded")
+	}
+	if loc := s.UserLocation(); loc == nil || loc.Latitude != 52.52 {
+		t.Errorf("Unexpected user location: %+v", loc)
+	}
+	if calls := factory.GetCreatePingerCalls(); len(calls) != 1 {
+		t.Errorf("Expected 1 pinger to be created, got %d", len(calls))
+	}
+}
+
+func TestNewSessionErrors(t *testing.T) {
+	t.Run("Relay load failure closes pinger", func(t *testing.T) {
+		factory := ping.NewMockPingerFactory()
+		_, err := NewSession(context.Background(),
+			WithPingerFactory(factory),
+			WithRelaysLoader(func(context.Context) (*relays.File, error) { return nil, errors.New("no relays") }),
+			WithLocator(func(context.Context) (*api.UserLocation, error) { return &api.UserLocation{}, nil }),
+		)
+		if err == nil {
+			t.Fatal("Expected error, got nil")
+		}
+		if pingers := factory.GetCreatedPingers(); len(pingers) != 1 || !pingers[0].IsClosed() {
+			t.Error("Expected pinger to be closed after failed initialisation")
+		}
+	})
+
+	t.Run("Pinger creation failure", func(t *testing.T) {
+		factory := ping.NewMockPingerFactory()
+		factory.CreatePingerFunc = func(relays.IPVersion) (ping.Pinger, error) {
+			return nil, errors.New("permission denied")
+		}
+		_, err := NewSession(context.Background(), WithPingerFactory(factory))
+		if err == nil {
+			t.Fatal("Expected error, got nil")
+		}
+	})
+}
+
+func TestSessionRank(t *testing.T) {
+	s, factory, _ := newTestSession(t)
+
+	results, err := s.Rank(context.Background(), Filters{MaxDistance: 500})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if len(results) == 0 {
+		t.Fatal("Expected ranked servers")
+	}
+	for _, loc := range results {
+		if loc.DistanceFromMyLocation == nil || *loc.DistanceFromMyLocation > 500 {
+			t.Errorf("Expected %s within 500 km", loc.Hostname)
+		}
+	}
+	for i := 1; i < len(results); i++ {
+		if *results[i].DistanceFromMyLocation < *results[i-1].DistanceFromMyLocation {
+			t.Fatalf("Expected equal-latency servers ordered by distance at index %d", i)
+		}
+	}
+
+	// A second ranking reuses the session's pinger and relay set
+	results, err = s.Rank(context.Background(), Filters{Countries: []string{"se"}})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	for _, loc := range results {
+		if loc.DistanceFromMyLocation == nil {
+			t.Errorf("Expected %s to have a distance without a distance limit", loc.Hostname)
+		}
+	}
+	if calls := factory.GetCreatePingerCalls(); len(calls) != 1 {
+		t.Errorf("Expected pinger to be reused, got %d creations", len(calls))
+	}
+}
+
+func TestSessionRankFilters(t *testing.T) {
+	s, _, _ := newTestSession(t)
+
+	t.Run("Country without distance limit", func(t *testing.T) {
+		results, err := s.Rank(context.Background(), Filters{Countries: []string{"au"}})
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if len(results) == 0 {
+			t.Fatal("Expected Australian servers")
+		}
+		for _, loc := range results {
+			if loc.Country != "Australia" {
+				t.Errorf("Expected only Australian servers, got %s", loc.Country)
+			}
+		}
+	})
+
+	t.Run("Bridges", func(t *testing.T) {
+		results, err := s.Rank(context.Background(), Filters{ServerType: relays.BridgeServer})
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if len(results) == 0 {
+			t.Fatal("Expected bridge servers")
+		}
+		for _, loc := range results {
+			if loc.Type != "bridge" {
+				t.Errorf("Expected only bridges, got %s", loc.Type)
+			}
+		}
+	})
+}
+
+func TestSessionRankWarnings(t *testing.T) {
+	s, _, _ := newTestSession(t, WithRelaysLoader(func(context.Context) (*relays.File, error) {
+		file, err := relays.ParseRelaysFile("../../testdata/relays.json")
+		if err != nil {
+			return nil, err
+		}
+		file.WireGuard.Relays = append(file.WireGuard.Relays, relays.WireGuardRelay{
+			Hostname:   "xx-nowhere-wg-001",
+			Active:     true,
+			Location:   "xx-nowhere",
+			IPv4AddrIn: "192.0.2.1",
+		})
+		return file, nil
+	}))
+
+	collector := warnings.New()
+	ctx := warnings.WithCollector(context.Background(), collector)
+	if _, err := s.Rank(ctx, Filters{Countries: []string{"au"}}); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	want := []warnings.Warning{
+		{Kind: warnings.SkippedRelays, Message: "1 relay(s) skipped due to unresolvable location key"},
+	}
+	if got := collector.Warnings(); !slices.Equal(got, want) {
+		t.Errorf("Warnings() = %v, want %v", got, want)
+	}
+}
+
+func TestSessionRefresh(t *testing.T) {
+	lat := 52.52
+	s, _, loads := newTestSession(t, WithLocator(func(context.Context) (*api.UserLocation, error) {
+		return &api.UserLocation{Latitude: lat, Longitude: 13.405}, nil
+	}))
+
+	lat = 48.85
+	if err := s.Refresh(context.Background()); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if *loads != 2 {
+		t.Errorf("Expected relays to be reloaded, got %d loads", *loads)
+	}
+	if s.UserLocation().Latitude != 48.85 {
+		t.Errorf("Expected refreshed location, got %+v", s.UserLocation())
+	}
+}
+
+func TestSessionRefreshKeepsStateOnError(t *testing.T) {
+	fail := false
+	s, _, _ := newTestSession(t, WithLocator(func(context.Context) (*api.UserLocation, error) {
+		if fail {
+			return nil, errors.New("offline")
+		}
+		return &api.UserLocation{Latitude: 52.52, Longitude: 13.405}, nil
+	}))
+
+	fail = true
+	if err := s.Refresh(context.Background()); err == nil {
+		t.Fatal("Expected error, got nil")
+	}
+	if s.UserLocation() == nil || s.UserLocation().Latitude != 52.52 {
+		t.Errorf("Expected previous location to be kept, got %+v", s.UserLocation())
+	}
+}
+
+func TestSessionConcurrentRank(t *testing.T) {
+	s, _, _ := newTestSession(t)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := s.Rank(context.Background(), Filters{MaxDistance: 1000}); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := s.Refresh(context.Background()); err != nil {
+			errs <- err
+		}
+	}()
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestSessionClose(t *testing.T) {
+	s, factory, _ := newTestSession(t)
+
+	if err := s.Close(); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if !factory.GetCreatedPingers()[0].IsClosed() {
+		t.Error("Expected pinger to be closed")
+	}
+	if err := s.Close(); err != nil {
+		t.Errorf("Expected second Close to be a no-op, got: %v", err)
+	}
+
+	if _, err := s.Rank(context.Background(), Filters{}); !errors.Is(err, ErrClosed) {
+		t.Errorf("Expected ErrClosed from Rank, got: %v", err)
+	}
+	if err := s.Refresh(context.Background()); !errors.Is(err, ErrClosed) {
+		t.Errorf("Expected ErrClosed from Refresh, got: %v", err)
+	}
+}
+
+func TestSessionCloseWaitsForRank(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{}, 1)
+
+	s, factory, _ := newTestSession(t)
+	pinger := factory.GetCreatedPingers()[0]
+	pinger.PingFunc = func(context.Context, string, time.Duration) *float64 {
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		<-release
+		latency := 10.0
+		return &latency
+	}
+
+	done := make(chan struct{})
+	go func() {
+		_, _ = s.Rank(context.Background(), Filters{Countries: []string{"de"}, Workers: 1})
+		close(done)
+	}()
+	<-started
+
+	closed := make(chan struct{})
+	go func() {
+		_ = s.Close()
+		close(closed)
+	}()
+
+	select {
+	case <-closed:
+		t.Fatal("Expected Close to wait for the in-flight ranking")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	<-done
+	<-closed
+	if !pinger.IsClosed() {
+		t.Error("Expected pinger to be closed")
+	}
+}
+
+func TestSessionPingWithoutLocation(t *testing.T) {
+	s, factory, _ := newTestSession(t, WithLocator(nil))
+
+	if s.UserLocation() != nil {
+		t.Errorf("Expected no user location, got %+v", s.UserLocation())
+	}
+	if s.Refreshed().IsZero() {
+		t.Error("Expected the time of the initial refresh")
+	}
+	if _, err := s.Rank(context.Background(), Filters{}); !errors.Is(err, ErrNoLocation) {
+		t.Errorf("Expected ErrNoLocation from Rank, got: %v", err)
+	}
+
+	locations, _, err := relays.GetLocations(s.Relays(), relays.ACNone, false, relays.IPv4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	locations = relays.FilterByCountry(locations, []string{"de"})
+	for range 2 {
+		results, err := s.Ping(context.Background(), locations, 500, 4)
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if len(results) != len(locations) || results[0].Latency == nil {
+			t.Errorf("Expected every location pinged, got %d of %d", len(results), len(locations))
+		}
+	}
+	if calls := factory.GetCreatePingerCalls(); len(calls) != 1 {
+		t.Errorf("Expected both pings to reuse the session's pinger, got %d pingers", len(calls))
+	}
+}
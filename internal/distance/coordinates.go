@@ -0,0 +1,81 @@
+package distance
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+
+	"github.com/Ch00k/mullvad-compass/internal/relays"
+)
+
+// MismatchThreshold is the distance in km between the location of a relay and the city its hostname names beyond
+// which the two are reported as a mismatch, well above the spread of the datacenters around a city
+const MismatchThreshold = 100.0
+
+// CoordinateMismatch is a relay placed far from the city its hostname names, e.g. de-ber-wg-001 at the coordinates of
+// Frankfurt, which is more likely an error in relays.json than a relay that moved
+type CoordinateMismatch struct {
+	Hostname   string
+	Location   string  // Location key the relay is listed at
+	Advertised string  // Location key the hostname names
+	Distance   float64 // Between the two locations, in km
+}
+
+// CoordinateMismatches returns the WireGuard and bridge relays whose location is more than MismatchThreshold km from
+// the location their hostname names, sorted by hostname. Relays whose hostname does not name a known location, and
+// locations without coordinates, are not judged.
+func CoordinateMismatches(file *relays.File) []CoordinateMismatch {
+	var mismatches []CoordinateMismatch
+	check := func(hostname, location string) {
+		advertised := advertisedLocation(hostname)
+		if advertised == location {
+			return
+		}
+		listed, ok := file.Locations[location]
+		if !ok {
+			return
+		}
+		named, ok := file.Locations[advertised]
+		if !ok {
+			return
+		}
+		if !hasCoordinates(listed) || !hasCoordinates(named) {
+			return
+		}
+		d := CalculateDistance(listed.Latitude, listed.Longitude, named.Latitude, named.Longitude)
+		if d > MismatchThreshold {
+			mismatches = append(mismatches, CoordinateMismatch{
+				Hostname:   hostname,
+				Location:   location,
+				Advertised: advertised,
+				Distance:   d,
+			})
+		}
+	}
+
+	for _, relay := range file.WireGuard.Relays {
+		check(relay.Hostname, relay.Location)
+	}
+	for _, relay := range file.Bridge.Relays {
+		check(relay.Hostname, relay.Location)
+	}
+
+	slices.SortFunc(mismatches, func(a, b CoordinateMismatch) int {
+		return cmp.Compare(a.Hostname, b.Hostname)
+	})
+	return mismatches
+}
+
+// advertisedLocation returns the location key a hostname starts with, e.g. "de-ber" for de-ber-wg-001
+func advertisedLocation(hostname string) string {
+	parts := strings.SplitN(hostname, "-", 3)
+	if len(parts) < 2 {
+		return ""
+	}
+	return parts[0] + "-" + parts[1]
+}
+
+// hasCoordinates reports whether a location of relays.json has a position, see relays.Location.HasCoordinates
+func hasCoordinates(loc relays.LocationEntry) bool {
+	return loc.Latitude != 0 || loc.Longitude != 0
+}
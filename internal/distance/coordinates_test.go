@@ -0,0 +1,44 @@
+package distance
+
+import (
+	"math"
+	"testing"
+
+	"github.com/Ch00k/mullvad-compass/internal/relays"
+)
+
+func TestCoordinateMismatches(t *testing.T) {
+	file := &relays.File{
+		Locations: map[string]relays.LocationEntry{
+			"de-ber": {City: "Berlin", Country: "Germany", Latitude: 52.52, Longitude: 13.405},
+			"de-fra": {City: "Frankfurt", Country: "Germany", Latitude: 50.1109, Longitude: 8.6821},
+			"de-pot": {City: "Potsdam", Country: "Germany", Latitude: 52.3906, Longitude: 13.0645},
+			"se-sto": {City: "Stockholm", Country: "Sweden"},
+		},
+	}
+	file.WireGuard.Relays = []relays.WireGuardRelay{
+		{Hostname: "de-ber-wg-001", Location: "de-ber"},
+		{Hostname: "de-ber-wg-002", Location: "de-fra"}, // Berlin at the coordinates of Frankfurt
+		{Hostname: "de-ber-wg-003", Location: "de-pot"}, // Within the threshold
+		{Hostname: "se-sto-wg-001", Location: "de-ber"}, // Stockholm has no coordinates
+		{Hostname: "nl-ams-wg-001", Location: "de-ber"}, // Amsterdam is not listed
+	}
+	file.Bridge.Relays = []relays.BridgeRelay{
+		{Hostname: "de-fra-br-001", Location: "de-ber"},
+	}
+
+	got := CoordinateMismatches(file)
+	if len(got) != 2 {
+		t.Fatalf("Expected 2 mismatches, got %+v", got)
+	}
+
+	if got[0].Hostname != "de-ber-wg-002" || got[0].Location != "de-fra" || got[0].Advertised != "de-ber" {
+		t.Errorf("Unexpected first mismatch: %+v", got[0])
+	}
+	if got[1].Hostname != "de-fra-br-001" || got[1].Location != "de-ber" || got[1].Advertised != "de-fra" {
+		t.Errorf("Unexpected second mismatch: %+v", got[1])
+	}
+	if math.Abs(got[0].Distance-424) > 5 {
+		t.Errorf("Expected a distance of about 424 km, got %.1f", got[0].Distance)
+	}
+}
@@ -2,8 +2,11 @@
 package distance
 
 import (
+	"context"
 	"log"
 	"math"
+	"slices"
+	"sort"
 
 	"github.com/Ch00k/mullvad-compass/internal/logging"
 	"github.com/Ch00k/mullvad-compass/internal/relays"
@@ -46,6 +49,17 @@ func FilterByDistanceWithLogLevel(
 	userLat, userLon, maxDistance float64,
 	logLevel logging.LogLevel,
 ) []relays.Location {
+	filtered, _ := FilterByDistanceContext(context.Background(), locations, userLat, userLon, maxDistance, logLevel)
+	return filtered
+}
+
+// FilterByDistanceContext is FilterByDistanceWithLogLevel, stopping with the context's error once it is cancelled
+func FilterByDistanceContext(
+	ctx context.Context,
+	locations []relays.Location,
+	userLat, userLon, maxDistance float64,
+	logLevel logging.LogLevel,
+) ([]relays.Location, error) {
 	if logLevel <= logging.LogLevelDebug {
 		log.Printf(
 			"Filtering %d locations within %.1f km of user location (%.4f, %.4f)",
@@ -59,10 +73,11 @@ func FilterByDistanceWithLogLevel(
 	var filtered []relays.Location
 
 	for _, loc := range locations {
-		distance := CalculateDistance(userLat, userLon, loc.Latitude, loc.Longitude)
-		if distance <= maxDistance {
-			d := distance // Allocate new variable to avoid pointer aliasing
-			loc.DistanceFromMyLocation = &d
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		annotate(&loc, userLat, userLon)
+		if *loc.DistanceFromMyLocation <= maxDistance {
 			filtered = append(filtered, loc)
 		}
 	}
@@ -76,5 +91,89 @@ func FilterByDistanceWithLogLevel(
 		)
 	}
 
+	return filtered, nil
+}
+
+// AnnotateDistances sets the distance from the user's position on every location, without filtering
+func AnnotateDistances(locations []relays.Location, userLat, userLon float64) {
+	for i := range locations {
+		annotate(&locations[i], userLat, userLon)
+	}
+}
+
+// annotate sets the distance from the user's position on a single location
+func annotate(loc *relays.Location, userLat, userLon float64) {
+	d := CalculateDistance(userLat, userLon, loc.Latitude, loc.Longitude)
+	loc.DistanceFromMyLocation = &d
+}
+
+// Index holds locations sorted by their distance from a point, for repeated radius queries
+type Index struct {
+	locations []relays.Location // Sorted by distance, each with DistanceFromMyLocation set
+	order     []int             // Position of each sorted location in the input
+}
+
+// NewIndex computes the distance of every location from the point once and sorts them by it, keeping the input
+// order of locations at the same distance
+func NewIndex(locations []relays.Location, userLat, userLon float64) *Index {
+	sorted := make([]relays.Location, len(locations))
+	order := make([]int, len(locations))
+	copy(sorted, locations)
+	AnnotateDistances(sorted, userLat, userLon)
+	for i := range order {
+		order[i] = i
+	}
+
+	sort.Stable(byDistance{sorted, order})
+
+	return &Index{locations: sorted, order: order}
+}
+
+// Within returns copies of the locations within maxDistance, in their input order
+func (x *Index) Within(maxDistance float64) []relays.Location {
+	n := sort.Search(len(x.locations), func(i int) bool {
+		return *x.locations[i].DistanceFromMyLocation > maxDistance
+	})
+
+	within := make([]int, n)
+	for i := range within {
+		within[i] = i
+	}
+	sort.Slice(within, func(a, b int) bool { return x.order[within[a]] < x.order[within[b]] })
+
+	filtered := make([]relays.Location, n)
+	for i, j := range within {
+		filtered[i] = x.locations[j]
+	}
 	return filtered
 }
+
+// Nearest returns the distance in km to the nearest location, or false if the index is empty
+func (x *Index) Nearest() (float64, bool) {
+	if len(x.locations) == 0 {
+		return 0, false
+	}
+	return *x.locations[0].DistanceFromMyLocation, true
+}
+
+// Closest returns copies of the n locations nearest to the point, nearest first
+func (x *Index) Closest(n int) []relays.Location {
+	return slices.Clone(x.locations[:min(n, len(x.locations))])
+}
+
+// byDistance sorts locations by distance, keeping their input positions in step
+type byDistance struct {
+	locations []relays.Location
+	order     []int
+}
+
+func (s byDistance) Len() int { return len(s.locations) }
+
+func (s byDistance) Less(i, j int) bool {
+	return *s.locations[i].DistanceFromMyLocation < *s.locations[j].DistanceFromMyLocation
+}
+
+func (s byDistance) Swap(i, j int) {
+	s.locations[i], s.locations[j] = s.locations[j], s.locations[i]
+	s.order[i], s.order[j] = s.order[j], s.order[i]
+}
@@ -1,9 +1,13 @@
 package distance
 
 import (
+	"context"
+	"errors"
 	"math"
+	"slices"
 	"testing"
 
+	"github.com/Ch00k/mullvad-compass/internal/logging"
 	"github.com/Ch00k/mullvad-compass/internal/relays"
 )
 
@@ -94,6 +98,17 @@ func TestFilterByDistance(t *testing.T) {
 	}
 }
 
+func TestFilterByDistanceContextCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	locations := []relays.Location{{Hostname: "close", Latitude: 50.1, Longitude: 10.1}}
+	_, err := FilterByDistanceContext(ctx, locations, 50.0, 10.0, 200.0, logging.LogLevelError)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+}
+
 func TestDegreesToRadians(t *testing.T) {
 	tests := []struct {
 		degrees  float64
@@ -112,3 +127,101 @@ func TestDegreesToRadians(t *testing.T) {
 		}
 	}
 }
+
+func TestIndex(t *testing.T) {
+	userLat := 50.0
+	userLon := 10.0
+
+	locations := []relays.Location{
+		{Hostname: "far", Latitude: 60.0, Longitude: 20.0},
+		{Hostname: "close", Latitude: 50.1, Longitude: 10.1},
+		{Hostname: "medium", Latitude: 51.0, Longitude: 11.0},
+	}
+
+	index := NewIndex(locations, userLat, userLon)
+
+	nearest, ok := index.Nearest()
+	if !ok {
+		t.Fatal("Expected a nearest location")
+	}
+	expectedNearest := CalculateDistance(userLat, userLon, 50.1, 10.1)
+	if math.Abs(nearest-expectedNearest) > 0.001 {
+		t.Errorf("Expected nearest distance %.2f, got %.2f", expectedNearest, nearest)
+	}
+
+	t.Run("Matches FilterByDistance", func(t *testing.T) {
+		for _, maxDistance := range []float64{0, 10, 200, 1000, 20000} {
+			expected := FilterByDistance(locations, userLat, userLon, maxDistance)
+			got := index.Within(maxDistance)
+			if len(got) != len(expected) {
+				t.Fatalf("Within(%.0f): expected %d locations, got %d", maxDistance, len(expected), len(got))
+			}
+			for i := range got {
+				if got[i].Hostname != expected[i].Hostname {
+					t.Errorf("Within(%.0f)[%d]: expected %s, got %s",
+						maxDistance, i, expected[i].Hostname, got[i].Hostname)
+				}
+				if math.Abs(*got[i].DistanceFromMyLocation-*expected[i].DistanceFromMyLocation) > 0.001 {
+					t.Errorf("Within(%.0f)[%d]: distance mismatch", maxDistance, i)
+				}
+			}
+		}
+	})
+
+	t.Run("Returned locations are copies", func(t *testing.T) {
+		latency := 5.0
+		within := index.Within(20000)
+		within[0].Latency = &latency
+		if index.Within(20000)[0].Latency != nil {
+			t.Error("Expected modifying a result to leave the index untouched")
+		}
+		if locations[0].DistanceFromMyLocation != nil {
+			t.Error("Expected the input locations to be left untouched")
+		}
+	})
+
+	t.Run("Closest", func(t *testing.T) {
+		var got []string
+		for _, loc := range index.Closest(2) {
+			got = append(got, loc.Hostname)
+		}
+		if !slices.Equal(got, []string{"close", "medium"}) {
+			t.Errorf("Expected the 2 closest locations nearest first, got %v", got)
+		}
+		if len(index.Closest(10)) != len(locations) {
+			t.Errorf("Expected at most %d locations", len(locations))
+		}
+	})
+
+	t.Run("Empty index", func(t *testing.T) {
+		empty := NewIndex(nil, userLat, userLon)
+		if _, ok := empty.Nearest(); ok {
+			t.Error("Expected no nearest location in an empty index")
+		}
+		if len(empty.Within(20000)) != 0 {
+			t.Error("Expected no locations in an empty index")
+		}
+	})
+}
+
+func TestAnnotateDistances(t *testing.T) {
+	locations := []relays.Location{
+		{Hostname: "close", Latitude: 50.1, Longitude: 10.1},
+		{Hostname: "antipode", Latitude: -50.0, Longitude: -170.0},
+	}
+
+	AnnotateDistances(locations, 50.0, 10.0)
+
+	for _, loc := range locations {
+		if loc.DistanceFromMyLocation == nil {
+			t.Fatalf("Location %s missing distance value", loc.Hostname)
+		}
+	}
+	if d := *locations[0].DistanceFromMyLocation; d > 20 {
+		t.Errorf("Expected close location within 20 km, got %.2f", d)
+	}
+	// Nothing is filtered out, not even beyond the maximum --max-distance
+	if d := *locations[1].DistanceFromMyLocation; d < 20000 {
+		t.Errorf("Expected antipodal location beyond 20000 km, got %.2f", d)
+	}
+}
@@ -0,0 +1,22 @@
+// Package elevate re-executes mullvad-compass with root privileges for --sudo, on systems where the ICMP sockets a
+// run needs are only open to root.
+package elevate
+
+import "os/exec"
+
+// reexecScript changes to the directory given as its first argument and runs the rest, as pkexec starts commands in
+// the home directory of root, where the relative paths of the arguments would not resolve
+const reexecScript = `cd "$1" && shift && exec "$@"`
+
+// command returns the argv running exe with args as root: under sudo, which keeps the working directory, or under
+// pkexec when sudo is not installed
+func command(exe string, args []string, dir string, lookPath func(string) (string, error)) ([]string, error) {
+	if sudo, err := lookPath("sudo"); err == nil {
+		return append([]string{sudo, "--", exe}, args...), nil
+	}
+	pkexec, err := lookPath("pkexec")
+	if err != nil {
+		return nil, exec.ErrNotFound
+	}
+	return append([]string{pkexec, "/bin/sh", "-c", reexecScript, "sh", dir, exe}, args...), nil
+}
@@ -0,0 +1,76 @@
+//go:build linux
+
+package elevate
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+
+	"github.com/Ch00k/mullvad-compass/internal/errs"
+	"github.com/Ch00k/mullvad-compass/internal/relays"
+	"golang.org/x/net/icmp"
+)
+
+// Supported reports whether Reexec can run mullvad-compass again as root
+const Supported = true
+
+// Check opens and closes the sockets a run needs: an ICMP datagram socket of the IP version, and with raw a raw ICMP
+// socket for timestamp requests. It returns an error wrapping errs.ErrPermission for the first socket that is not
+// permitted, and nil when running as root, as running again would not gain anything. Other failures are left for
+// the run to report.
+func Check(ipVersion relays.IPVersion, raw bool) error {
+	if os.Geteuid() == 0 {
+		return nil
+	}
+
+	network, addr := "udp4", "0.0.0.0"
+	if ipVersion.IsIPv6() {
+		network, addr = "udp6", "::"
+	}
+	if err := tryListen(network, addr); err != nil {
+		return fmt.Errorf("%w: %w", errs.ErrPermission, err)
+	}
+	if raw {
+		if err := tryListen("ip4:icmp", "0.0.0.0"); err != nil {
+			return fmt.Errorf("%w for timestamp requests: %w", errs.ErrPermission, err)
+		}
+	}
+	return nil
+}
+
+// tryListen opens and closes an ICMP socket, returning only a permission error
+func tryListen(network, addr string) error {
+	conn, err := icmp.ListenPacket(network, addr)
+	if err != nil {
+		if errors.Is(err, os.ErrPermission) {
+			return err
+		}
+		return nil
+	}
+	_ = conn.Close()
+	return nil
+}
+
+// Reexec replaces the process with the same binary run with args as root, under sudo or pkexec. It only returns
+// on failure.
+func Reexec(args []string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to locate the executable: %w", err)
+	}
+	dir, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("failed to get the working directory: %w", err)
+	}
+	argv, err := command(exe, args, dir, exec.LookPath)
+	if err != nil {
+		return fmt.Errorf("neither sudo nor pkexec is installed: %w", err)
+	}
+	if err := syscall.Exec(argv[0], argv, os.Environ()); err != nil {
+		return fmt.Errorf("failed to run %s: %w", argv[0], err)
+	}
+	return nil
+}
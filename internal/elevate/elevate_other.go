@@ -0,0 +1,22 @@
+//go:build !linux
+
+package elevate
+
+import (
+	"errors"
+
+	"github.com/Ch00k/mullvad-compass/internal/relays"
+)
+
+// Supported reports whether Reexec can run mullvad-compass again as root
+const Supported = false
+
+// Check reports no missing privileges, as --sudo is Linux only
+func Check(_ relays.IPVersion, _ bool) error {
+	return nil
+}
+
+// Reexec is not supported outside Linux
+func Reexec(_ []string) error {
+	return errors.New("running again as root is only supported on Linux")
+}
@@ -0,0 +1,58 @@
+package elevate
+
+import (
+	"errors"
+	"os/exec"
+	"slices"
+	"testing"
+)
+
+func TestCommand(t *testing.T) {
+	installed := func(names ...string) func(string) (string, error) {
+		return func(name string) (string, error) {
+			if slices.Contains(names, name) {
+				return "/usr/bin/" + name, nil
+			}
+			return "", exec.ErrNotFound
+		}
+	}
+	args := []string{"--asymmetry", "--pcap", "out.pcap"}
+
+	tests := []struct {
+		name     string
+		lookPath func(string) (string, error)
+		want     []string
+	}{
+		{
+			name:     "sudo",
+			lookPath: installed("sudo", "pkexec"),
+			want:     []string{"/usr/bin/sudo", "--", "/opt/mullvad-compass", "--asymmetry", "--pcap", "out.pcap"},
+		},
+		{
+			name:     "pkexec",
+			lookPath: installed("pkexec"),
+			want: []string{
+				"/usr/bin/pkexec", "/bin/sh", "-c", reexecScript, "sh", "/home/user",
+				"/opt/mullvad-compass", "--asymmetry", "--pcap", "out.pcap",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := command("/opt/mullvad-compass", args, "/home/user", tt.lookPath)
+			if err != nil {
+				t.Fatalf("Expected no error, got: %v", err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("Expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+
+	t.Run("Neither", func(t *testing.T) {
+		if _, err := command("/opt/mullvad-compass", args, "/home/user", installed()); !errors.Is(err, exec.ErrNotFound) {
+			t.Errorf("Expected exec.ErrNotFound, got: %v", err)
+		}
+	})
+}
@@ -0,0 +1,29 @@
+// Package errs defines the sentinel errors shared across packages, so that callers classify failures with
+// errors.Is instead of matching error messages.
+package errs
+
+import "errors"
+
+var (
+	// ErrNoServers indicates that no server matched the filters
+	ErrNoServers = errors.New("no servers found")
+
+	// ErrRelaysNotFound indicates that relays.json is in none of the locations searched
+	ErrRelaysNotFound = errors.New("relays.json not found")
+
+	// ErrAPILocation indicates that the Mullvad API did not return the user's location
+	ErrAPILocation = errors.New("location lookup failed")
+
+	// ErrAllTimeouts indicates that none of the pinged servers responded
+	ErrAllTimeouts = errors.New("no servers responded to ping")
+
+	// ErrPermission indicates that the operating system refused to open an ICMP socket
+	ErrPermission = errors.New("not permitted to open an ICMP socket")
+
+	// ErrOffline indicates a network call other than a probe in a run that forbids them with --offline
+	ErrOffline = errors.New("network access disabled by --offline")
+
+	// ErrRestrictedTarget indicates a probe target that is loopback, link-local, multicast or otherwise not a host on
+	// the internet, which is refused unless --allow-private is given
+	ErrRestrictedTarget = errors.New("not an internet address")
+)
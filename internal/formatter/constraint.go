@@ -0,0 +1,51 @@
+package formatter
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Ch00k/mullvad-compass/internal/relays"
+)
+
+// FormatConstraintCost formats the best server the Mullvad app's relay constraints allow, to follow the best
+// server overall, with the latency the constraints cost
+func FormatConstraintCost(best, allowed relays.Location, opts Options) string {
+	if allowed.Hostname == best.Hostname {
+		return "\n" + constraintCostLine(best, allowed, opts)
+	}
+
+	const indent = "                 " // Length of "Your location: "
+
+	var output strings.Builder
+	fmt.Fprintf(&output, "\nApp allows:      %s, %s\n", allowed.City, allowed.Country)
+	fmt.Fprintf(&output, "%s%s (%s)\n", indent, allowed.Hostname, strings.Join(opts.IPs.addresses(allowed), ", "))
+	fmt.Fprintf(&output, "%s%s, %s km away\n",
+		indent,
+		formatLatencyWithUnit(allowed.Latency, opts),
+		formatDistance(allowed.DistanceFromMyLocation))
+	output.WriteString("\n" + constraintCostLine(best, allowed, opts))
+	return output.String()
+}
+
+// FormatPlainConstraintCost formats the best server the app's relay constraints allow as one labeled line,
+// followed by the latency the constraints cost
+func FormatPlainConstraintCost(best, allowed relays.Location, opts Options) string {
+	if allowed.Hostname == best.Hostname {
+		return constraintCostLine(best, allowed, opts)
+	}
+	return fmt.Sprintf("App allows: %s\n%s", plainLocationLine(allowed, opts), constraintCostLine(best, allowed, opts))
+}
+
+// constraintCostLine states how much slower the best allowed server is than the best server overall
+func constraintCostLine(best, allowed relays.Location, opts Options) string {
+	switch {
+	case allowed.Hostname == best.Hostname:
+		return "The best server is allowed by the Mullvad app's relay constraints\n"
+	case allowed.Latency == nil:
+		return "No server allowed by the Mullvad app's relay constraints responded\n"
+	case best.Latency == nil:
+		return "The Mullvad app's relay constraints cost nothing\n"
+	}
+	cost := max(*allowed.Latency-*best.Latency, 0)
+	return fmt.Sprintf("The Mullvad app's relay constraints cost %s\n", formatLatencyWithUnit(&cost, opts))
+}
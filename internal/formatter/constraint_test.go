@@ -0,0 +1,53 @@
+package formatter
+
+import (
+	"testing"
+
+	"github.com/Ch00k/mullvad-compass/internal/relays"
+)
+
+func TestFormatConstraintCost(t *testing.T) {
+	best := relays.Location{Country: "Germany", City: "Berlin", Hostname: "de-ber-wg-001", IPv4Address: "193.32.248.66",
+		Latency: ptr(12.0), DistanceFromMyLocation: ptr(160.0)}
+	allowed := relays.Location{Country: "Sweden", City: "Malmö", Hostname: "se-mma-wg-001",
+		IPv4Address: "193.138.218.220", Latency: ptr(35.5), DistanceFromMyLocation: ptr(410.0)}
+	timedOut := allowed
+	timedOut.Latency = nil
+
+	tests := []struct {
+		name    string
+		format  func(best, allowed relays.Location, opts Options) string
+		allowed relays.Location
+		want    string
+	}{
+		{
+			"Costlier allowed server",
+			FormatConstraintCost,
+			allowed,
+			"\nApp allows:      Malmö, Sweden\n" +
+				"                 se-mma-wg-001 (193.138.218.220)\n" +
+				"                 35.50 ms, 410 km away\n" +
+				"\nThe Mullvad app's relay constraints cost 23.50 ms\n",
+		},
+		{
+			"Best server allowed",
+			FormatConstraintCost,
+			best,
+			"\nThe best server is allowed by the Mullvad app's relay constraints\n",
+		},
+		{
+			"Allowed servers timed out",
+			FormatPlainConstraintCost,
+			timedOut,
+			"App allows: se-mma-wg-001: timeout, 410 km, Malmö, Sweden, 193.138.218.220\n" +
+				"No server allowed by the Mullvad app's relay constraints responded\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.format(best, tt.allowed, DefaultOptions()); got != tt.want {
+				t.Errorf("Got:\n%q\nwant:\n%q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,42 @@
+package formatter
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Ch00k/mullvad-compass/internal/relays"
+)
+
+// FormatCityAlternative formats the best server in a city with at least minRelays relays, to follow a best server
+// in a smaller city, which has too few relays to fail over to. A nil alternative means that no server in such a
+// city responded.
+func FormatCityAlternative(alternative *relays.Location, relayCount, minRelays int, opts Options) string {
+	if alternative == nil {
+		return fmt.Sprintf("\nNo server in a city with at least %d relays responded\n", minRelays)
+	}
+
+	const indent = "                 " // Length of "Your location: "
+
+	var output strings.Builder
+	fmt.Fprintf(&output, "\nAlternative:     %s, %s (%s)\n",
+		alternative.City,
+		alternative.Country,
+		formatRelayCount(relayCount))
+	fmt.Fprintf(&output, "%s%s (%s)\n",
+		indent,
+		alternative.Hostname,
+		strings.Join(opts.IPs.addresses(*alternative), ", "))
+	fmt.Fprintf(&output, "%s%s, %s km away\n",
+		indent,
+		formatLatencyWithUnit(alternative.Latency, opts),
+		formatDistance(alternative.DistanceFromMyLocation))
+	return output.String()
+}
+
+// FormatPlainCityAlternative formats the best server in a city with at least minRelays relays as one labeled line
+func FormatPlainCityAlternative(alternative *relays.Location, relayCount, minRelays int, opts Options) string {
+	if alternative == nil {
+		return fmt.Sprintf("No server in a city with at least %d relays responded\n", minRelays)
+	}
+	return fmt.Sprintf("Alternative: %s, %s\n", plainLocationLine(*alternative, opts), formatRelayCount(relayCount))
+}
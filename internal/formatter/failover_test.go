@@ -0,0 +1,53 @@
+package formatter
+
+import (
+	"testing"
+
+	"github.com/Ch00k/mullvad-compass/internal/relays"
+)
+
+func TestFormatCityAlternative(t *testing.T) {
+	alternative := relays.Location{Country: "Germany", City: "Berlin", Hostname: "de-ber-wg-001",
+		IPv4Address: "193.32.248.66", Latency: ptr(12.0), DistanceFromMyLocation: ptr(160.0)}
+
+	tests := []struct {
+		name        string
+		format      func(alternative *relays.Location, relayCount, minRelays int, opts Options) string
+		alternative *relays.Location
+		want        string
+	}{
+		{
+			"Alternative",
+			FormatCityAlternative,
+			&alternative,
+			"\nAlternative:     Berlin, Germany (8 relays)\n" +
+				"                 de-ber-wg-001 (193.32.248.66)\n" +
+				"                 12.00 ms, 160 km away\n",
+		},
+		{
+			"No alternative",
+			FormatCityAlternative,
+			nil,
+			"\nNo server in a city with at least 3 relays responded\n",
+		},
+		{
+			"Plain alternative",
+			FormatPlainCityAlternative,
+			&alternative,
+			"Alternative: de-ber-wg-001: 12.00 ms, 160 km, Berlin, Germany, 193.32.248.66, 8 relays\n",
+		},
+		{
+			"Plain without alternative",
+			FormatPlainCityAlternative,
+			nil,
+			"No server in a city with at least 3 relays responded\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.format(tt.alternative, 8, 3, DefaultOptions()); got != tt.want {
+				t.Errorf("Got:\n%q\nwant:\n%q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,40 @@
+package formatter
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Counts tells how many of the servers matching the filters are shown, and how many each display limit hid
+type Counts struct {
+	Matching       int `json:"matching"`
+	Shown          int `json:"shown"`
+	BeyondDistance int `json:"beyond_distance,omitempty"` // Farther than --max-distance
+	AboveLatency   int `json:"above_latency,omitempty"`   // Not faster than --latency-under
+}
+
+// FormatFootnote formats a note on the servers hidden by the display limits, e.g.
+// "Showing 12 of 87 matching servers (75 beyond 500 km)". Empty if no server is hidden.
+func FormatFootnote(counts Counts, maxDistance float64, latencyUnder int) string {
+	if counts.Shown >= counts.Matching {
+		return ""
+	}
+
+	var reasons []string
+	if counts.BeyondDistance > 0 {
+		reasons = append(reasons, fmt.Sprintf("%d beyond %.0f km", counts.BeyondDistance, maxDistance))
+	}
+	if counts.AboveLatency > 0 {
+		reasons = append(reasons, fmt.Sprintf("%d not under %d ms", counts.AboveLatency, latencyUnder))
+	}
+
+	serverWord := "servers"
+	if counts.Matching == 1 {
+		serverWord = "server"
+	}
+	note := fmt.Sprintf("Showing %d of %d matching %s", counts.Shown, counts.Matching, serverWord)
+	if len(reasons) > 0 {
+		note += " (" + strings.Join(reasons, ", ") + ")"
+	}
+	return note + "\n"
+}
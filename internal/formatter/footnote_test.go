@@ -0,0 +1,30 @@
+package formatter
+
+import "testing"
+
+func TestFormatFootnote(t *testing.T) {
+	tests := []struct {
+		name   string
+		counts Counts
+		want   string
+	}{
+		{name: "Nothing hidden", counts: Counts{Matching: 11, Shown: 11}},
+		{
+			name:   "Beyond distance",
+			counts: Counts{Matching: 87, Shown: 12, BeyondDistance: 75},
+			want:   "Showing 12 of 87 matching servers (75 beyond 250 km)\n",
+		},
+		{
+			name:   "Beyond distance and above latency",
+			counts: Counts{Matching: 87, Shown: 4, BeyondDistance: 75, AboveLatency: 8},
+			want:   "Showing 4 of 87 matching servers (75 beyond 250 km, 8 not under 20 ms)\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := FormatFootnote(tt.counts, 250, 20); got != tt.want {
+				t.Errorf("FormatFootnote() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
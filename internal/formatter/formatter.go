@@ -4,14 +4,23 @@ package formatter
 import (
 	"cmp"
 	"fmt"
+	"math"
 	"slices"
+	"strconv"
 	"strings"
+	"time"
 	"unicode/utf8"
 
 	"github.com/Ch00k/mullvad-compass/internal/api"
+	"github.com/Ch00k/mullvad-compass/internal/compare"
 	"github.com/Ch00k/mullvad-compass/internal/relays"
+	"github.com/Ch00k/mullvad-compass/internal/stability"
+	"github.com/Ch00k/mullvad-compass/internal/timing"
 )
 
+// favoriteMarker marks favorite relays in table and best server output
+const favoriteMarker = "★"
+
 // SortLocationsByLatency sorts locations by latency (nil values last), with stable tie-breakers
 func SortLocationsByLatency(locations []relays.Location) {
 	slices.SortStableFunc(locations, func(a, b relays.Location) int {
@@ -43,74 +52,192 @@ func SortLocationsByLatency(locations []relays.Location) {
 	})
 }
 
+// SortLocationsByDistance sorts locations by distance from the user (nil values last), with stable tie-breakers
+func SortLocationsByDistance(locations []relays.Location) {
+	slices.SortStableFunc(locations, func(a, b relays.Location) int {
+		// Primary: Distance (nil last)
+		if a.DistanceFromMyLocation == nil && b.DistanceFromMyLocation != nil {
+			return 1
+		}
+		if a.DistanceFromMyLocation != nil && b.DistanceFromMyLocation == nil {
+			return -1
+		}
+		if a.DistanceFromMyLocation != nil && b.DistanceFromMyLocation != nil {
+			if c := cmp.Compare(*a.DistanceFromMyLocation, *b.DistanceFromMyLocation); c != 0 {
+				return c
+			}
+		}
+
+		// Tie-breaker: Country, City, then Hostname
+		if c := cmp.Compare(a.Country, b.Country); c != 0 {
+			return c
+		}
+		if c := cmp.Compare(a.City, b.City); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Hostname, b.Hostname)
+	})
+}
+
 // FormatTable formats locations as a table string
-func FormatTable(locations []relays.Location, useIPv6 bool) string {
+func FormatTable(locations []relays.Location, opts Options) string {
+	return formatTable(locations, opts, nil)
+}
+
+// FormatCityTable formats the best relay of each city as a table, with the number of relays in the city
+func FormatCityTable(cities []relays.CityBest, opts Options) string {
+	return formatCityTable(cities, opts, nil)
+}
+
+// formatTable formats locations as a table, with their latency relative to the reference if there is one
+func formatTable(locations []relays.Location, opts Options, ref *Reference) string {
 	if len(locations) == 0 {
 		return ""
 	}
 
 	// Build table data
-	headers := []string{"Country", "City", "Distance (km)", "Hostname", "IP", "Latency (ms)"}
+	headers := locationHeaders(opts)
 	rows := make([][]string, len(locations))
 
 	for i, loc := range locations {
-		ipAddr := loc.IPv4Address
-		if useIPv6 {
-			ipAddr = loc.IPv6Address
-		}
-		rows[i] = []string{
-			loc.Country,
-			loc.City,
-			formatDistance(loc.DistanceFromMyLocation),
-			loc.Hostname,
-			ipAddr,
-			formatLatency(loc.Latency),
-		}
+		rows[i] = locationRow(loc, opts)
 	}
 
-	// Calculate column widths
-	widths := make([]int, len(headers))
-	for i, header := range headers {
-		widths[i] = utf8.RuneCountInString(header)
+	headers, rows = withRelativeColumn(headers, rows, locations, ref, opts.Latency)
+	headers, rows = withASNColumn(headers, rows, locations)
+	headers, rows = withFavoriteColumn(headers, rows, locations)
+	return renderLimitedTable(headers, rows, opts.MaxWidths)
+}
+
+// formatCityTable formats the best relay of each city as a table, with their latency relative to the reference
+// if there is one
+func formatCityTable(cities []relays.CityBest, opts Options, ref *Reference) string {
+	if len(cities) == 0 {
+		return ""
 	}
-	for _, row := range rows {
-		for i, cell := range row {
-			cellWidth := utf8.RuneCountInString(cell)
-			if cellWidth > widths[i] {
-				widths[i] = cellWidth
-			}
+
+	headers := locationHeaders(opts)
+	rows := make([][]string, len(cities))
+
+	best := make([]relays.Location, len(cities))
+	for i, city := range cities {
+		rows[i] = locationRow(city.Best, opts)
+		best[i] = city.Best
+	}
+
+	headers, rows = withRelativeColumn(headers, rows, best, ref, opts.Latency)
+	headers = append(headers, "Relays")
+	for i, city := range cities {
+		rows[i] = append(rows[i], formatRelayCount(city.Count))
+	}
+
+	headers, rows = withASNColumn(headers, rows, best)
+	headers, rows = withFavoriteColumn(headers, rows, best)
+	return renderLimitedTable(headers, rows, opts.MaxWidths)
+}
+
+// withFavoriteColumn prepends a column marking favorite relays, if any of the locations is a favorite
+func withFavoriteColumn(headers []string, rows [][]string, locations []relays.Location) ([]string, [][]string) {
+	if !slices.ContainsFunc(locations, func(loc relays.Location) bool { return loc.Favorite }) {
+		return headers, rows
+	}
+
+	marked := make([][]string, len(rows))
+	for i, row := range rows {
+		marker := ""
+		if locations[i].Favorite {
+			marker = favoriteMarker
 		}
+		marked[i] = append([]string{marker}, row...)
 	}
+	return append([]string{""}, headers...), marked
+}
 
-	// Build table output
-	var output strings.Builder
+// withASNColumn appends a column with the autonomous system of each relay, if any of the locations has one
+func withASNColumn(headers []string, rows [][]string, locations []relays.Location) ([]string, [][]string) {
+	if !slices.ContainsFunc(locations, func(loc relays.Location) bool { return loc.ASN != 0 }) {
+		return headers, rows
+	}
 
-	// Header row
-	headerParts := make([]string, len(headers))
-	for i, header := range headers {
-		headerParts[i] = padRight(header, widths[i])
+	annotated := make([][]string, len(rows))
+	for i, row := range rows {
+		annotated[i] = append(slices.Clone(row), formatASN(locations[i]))
 	}
-	output.WriteString(strings.Join(headerParts, "   "))
-	output.WriteString("\n")
+	return append(slices.Clone(headers), "ASN"), annotated
+}
 
-	// Separator row
-	separators := make([]string, len(headers))
-	for i, width := range widths {
-		separators[i] = strings.Repeat("-", width)
+// formatASN formats the autonomous system of a location, e.g. "AS39351 31173 Services AB"
+func formatASN(loc relays.Location) string {
+	if loc.ASN == 0 {
+		return ""
 	}
-	output.WriteString(strings.Join(separators, "   "))
-	output.WriteString("\n")
+	return strings.TrimSpace(fmt.Sprintf("AS%d %s", loc.ASN, loc.ASNOrganization))
+}
 
-	// Data rows
-	for _, row := range rows {
-		rowParts := make([]string, len(row))
-		for i, cell := range row {
-			rowParts[i] = padRight(cell, widths[i])
+// locationHeaders returns the table headers matching locationRow
+func locationHeaders(opts Options) []string {
+	headers := []string{"Country", "City", "Distance (km)", "Hostname"}
+	headers = append(headers, opts.IPs.headers()...)
+	if opts.Features {
+		headers = append(headers, "Features")
+	}
+	if opts.IPv6 {
+		return append(headers, "IPv4 Latency ("+opts.Latency.Unit()+")", "IPv6 Latency ("+opts.Latency.Unit()+")")
+	}
+	return append(headers, "Latency ("+opts.Latency.Unit()+")")
+}
+
+// locationRow returns the table cells describing a single location
+func locationRow(loc relays.Location, opts Options) []string {
+	row := []string{
+		loc.Country,
+		loc.City,
+		formatDistance(loc.DistanceFromMyLocation),
+		loc.Hostname,
+	}
+	row = append(row, opts.IPs.cells(loc)...)
+	if opts.Features {
+		row = append(row, loc.Features.Flags())
+	}
+	row = append(row, opts.Latency.Format(loc.Latency))
+	if opts.IPv6 {
+		ipv6 := ""
+		if loc.IPv6Address != "" {
+			ipv6 = opts.Latency.Format(loc.LatencyIPv6)
 		}
-		output.WriteString(strings.Join(rowParts, "   "))
-		output.WriteString("\n")
+		row = append(row, ipv6)
+	}
+	return row
+}
+
+// formatRelayCount formats a number of relays, e.g. "1 relay" or "32 relays"
+func formatRelayCount(count int) string {
+	if count == 1 {
+		return "1 relay"
+	}
+	return fmt.Sprintf("%d relays", count)
+}
+
+// renderTable renders headers and rows as a left-aligned table with a dashed separator row
+func renderTable(headers []string, rows [][]string) string {
+	return renderLimitedTable(headers, rows, nil)
+}
+
+// renderLimitedTable renders a table like renderTable, cutting the cells of the columns named in maxWidths to at
+// most that many characters
+func renderLimitedTable(headers []string, rows [][]string, maxWidths map[string]int) string {
+	w := newTableWriter(headers, maxWidths)
+	w.fit(headers)
+	for _, row := range rows {
+		w.fit(row)
 	}
 
+	var output strings.Builder
+	output.WriteString(w.format(headers) + "\n")
+	output.WriteString(w.separator() + "\n")
+	for _, row := range rows {
+		output.WriteString(w.format(row) + "\n")
+	}
 	return output.String()
 }
 
@@ -151,11 +278,8 @@ func formatUserLocationLines(loc api.UserLocation) string {
 }
 
 // FormatBestServer formats user location and best server in a compact 2-line format
-func FormatBestServer(userLoc api.UserLocation, serverLoc relays.Location, useIPv6 bool) string {
-	serverIP := serverLoc.IPv4Address
-	if useIPv6 {
-		serverIP = serverLoc.IPv6Address
-	}
+func FormatBestServer(userLoc api.UserLocation, serverLoc relays.Location, opts Options) string {
+	serverIP := strings.Join(opts.IPs.addresses(serverLoc), ", ")
 
 	const indent = "                 " // Length of "Your location: "
 
@@ -167,16 +291,478 @@ func FormatBestServer(userLoc api.UserLocation, serverLoc relays.Location, useIP
 
 	// Best server
 	fmt.Fprintf(&output, "Best server:     %s, %s\n", serverLoc.City, serverLoc.Country)
-	fmt.Fprintf(&output, "%s%s (%s)\n", indent, serverLoc.Hostname, serverIP)
-	fmt.Fprintf(&output, "%s%s ms, %s km away\n",
+	fmt.Fprintf(&output, "%s%s (%s)", indent, serverLoc.Hostname, serverIP)
+	if serverLoc.Favorite {
+		output.WriteString(" " + favoriteMarker)
+	}
+	output.WriteString("\n")
+	fmt.Fprintf(&output, "%s%s %s, %s km away\n",
 		indent,
-		formatLatency(serverLoc.Latency),
+		opts.Latency.Format(serverLoc.Latency),
+		opts.Latency.Unit(),
 		formatDistance(serverLoc.DistanceFromMyLocation))
 
 	return output.String()
 }
 
+// FormatPlainList formats locations one labeled line per server, without table alignment, for screen readers
+// and line-oriented tools
+func FormatPlainList(locations []relays.Location, opts Options) string {
+	var output strings.Builder
+	for _, loc := range locations {
+		output.WriteString(plainLocationLine(loc, opts))
+		output.WriteString("\n")
+	}
+	return output.String()
+}
+
+// FormatPlainCityList formats the best server of each city one labeled line per city, with the city's relay count
+func FormatPlainCityList(cities []relays.CityBest, opts Options) string {
+	var output strings.Builder
+	for _, city := range cities {
+		fmt.Fprintf(&output, "%s, %s\n", plainLocationLine(city.Best, opts), formatRelayCount(city.Count))
+	}
+	return output.String()
+}
+
+// FormatPlainBestServer formats user location and best server as one labeled line each
+func FormatPlainBestServer(userLoc api.UserLocation, serverLoc relays.Location, opts Options) string {
+	return fmt.Sprintf("Your location: %s, %s, %s\nBest server: %s\n",
+		userLoc.City, userLoc.Country, userLoc.IP, plainLocationLine(serverLoc, opts))
+}
+
+// plainLocationLine formats a location as "hostname: latency, distance, city, country, IP", with the IPv6 latency
+// of dual-stack pings after the latency, followed by its capabilities if shown, its autonomous system when known
+// and "favorite" for favorite relays
+func plainLocationLine(loc relays.Location, opts Options) string {
+	parts := make([]string, 0, 5)
+
+	parts = append(parts, formatLatencyWithUnit(loc.Latency, opts))
+	if opts.IPv6 && loc.IPv6Address != "" {
+		parts = append(parts, "IPv6 "+formatLatencyWithUnit(loc.LatencyIPv6, opts))
+	}
+	if loc.DistanceFromMyLocation != nil {
+		parts = append(parts, formatDistance(loc.DistanceFromMyLocation)+" km")
+	}
+	parts = append(parts, loc.City, loc.Country)
+
+	parts = append(parts, opts.IPs.addresses(loc)...)
+	if opts.Features {
+		parts = append(parts, "features "+loc.Features.String())
+	}
+	if loc.ASN != 0 {
+		parts = append(parts, formatASN(loc))
+	}
+	if loc.Favorite {
+		parts = append(parts, "favorite")
+	}
+
+	return loc.Hostname + ": " + strings.Join(parts, ", ")
+}
+
+// formatLatencyWithUnit formats a latency followed by its unit, or "timeout" for nil
+func formatLatencyWithUnit(latency *float64, opts Options) string {
+	if latency == nil {
+		return "timeout"
+	}
+	return opts.Latency.Format(latency) + " " + opts.Latency.Unit()
+}
+
 // FormatUserLocation formats user location information
 func FormatUserLocation(loc api.UserLocation) string {
 	return formatUserLocationLines(loc)
 }
+
+// Summary holds aggregate statistics for a set of pinged locations
+type Summary struct {
+	Count        int      `json:"count"`
+	Reachable    int      `json:"reachable"`
+	ReachablePct float64  `json:"reachable_pct"`
+	P50Latency   *float64 `json:"p50_latency_ms"` // nil when no location is reachable
+	P90Latency   *float64 `json:"p90_latency_ms"` // nil when no location is reachable
+	BestHostname string   `json:"best_hostname"`
+	BestLatency  *float64 `json:"best_latency_ms"` // nil when no location is reachable
+}
+
+// Summarize computes count, reachability, latency percentiles, and the best host for the given locations
+func Summarize(locations []relays.Location) Summary {
+	summary := Summary{Count: len(locations)}
+
+	latencies := make([]float64, 0, len(locations))
+	var best *relays.Location
+	for i := range locations {
+		if locations[i].Latency == nil {
+			continue
+		}
+		latencies = append(latencies, *locations[i].Latency)
+		if best == nil || *locations[i].Latency < *best.Latency {
+			best = &locations[i]
+		}
+	}
+
+	summary.Reachable = len(latencies)
+	if summary.Count > 0 {
+		summary.ReachablePct = float64(summary.Reachable) / float64(summary.Count) * 100
+	}
+	if len(latencies) == 0 {
+		return summary
+	}
+
+	slices.Sort(latencies)
+	p50 := percentile(latencies, 50)
+	p90 := percentile(latencies, 90)
+	summary.P50Latency = &p50
+	summary.P90Latency = &p90
+	summary.BestHostname = best.Hostname
+	summary.BestLatency = best.Latency
+
+	return summary
+}
+
+// percentile returns the nearest-rank percentile p (0-100] of an ascending, non-empty slice
+func percentile(sorted []float64, p float64) float64 {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// FormatSummary formats a summary as a single line
+func FormatSummary(s Summary) string {
+	serverWord := "servers"
+	if s.Count == 1 {
+		serverWord = "server"
+	}
+
+	if s.Reachable == 0 {
+		return fmt.Sprintf("%d %s, 0%% reachable\n", s.Count, serverWord)
+	}
+
+	return fmt.Sprintf(
+		"%d %s, %.0f%% reachable, p50 %s ms, p90 %s ms, best %s\n",
+		s.Count,
+		serverWord,
+		s.ReachablePct,
+		formatLatency(s.P50Latency),
+		formatLatency(s.P90Latency),
+		s.BestHostname,
+	)
+}
+
+// FormatSummaryLine formats a summary and the run time as a single line of key=value pairs for --summary-only, with
+// "-" as the best server and latency when no server responded
+func FormatSummaryLine(s Summary, duration time.Duration) string {
+	best, latency := "-", "-"
+	if s.BestLatency != nil {
+		best, latency = s.BestHostname, formatLatency(s.BestLatency)
+	}
+	return fmt.Sprintf(
+		"best=%s latency_ms=%s reachable=%d total=%d duration_ms=%d\n",
+		best,
+		latency,
+		s.Reachable,
+		s.Count,
+		duration.Milliseconds(),
+	)
+}
+
+// FormatConnectionCheck formats the exit IP, ownership, blacklist, and DNS leak status of the connection
+func FormatConnectionCheck(check api.ConnectionCheck) string {
+	const indent = "                 " // Length of "Your location: "
+
+	loc := check.Location
+	var output strings.Builder
+
+	fmt.Fprintf(&output, "Exit IP:         %s\n", loc.IP)
+	fmt.Fprintf(&output, "Location:        %s, %s\n", loc.City, loc.Country)
+	if loc.Organization != "" {
+		fmt.Fprintf(&output, "Organization:    %s\n", loc.Organization)
+	}
+
+	if loc.MullvadExitIP {
+		fmt.Fprintf(&output, "Mullvad VPN:     connected via %s", loc.MullvadExitIPHostname)
+		if loc.MullvadServerType != "" {
+			fmt.Fprintf(&output, " (%s)", loc.MullvadServerType)
+		}
+		output.WriteString("\n")
+	} else {
+		output.WriteString("Mullvad VPN:     not connected\n")
+	}
+
+	if loc.Blacklisted.Blacklisted {
+		var lists []string
+		for _, result := range loc.Blacklisted.Results {
+			if result.Blacklisted {
+				lists = append(lists, result.Name)
+			}
+		}
+		fmt.Fprintf(&output, "Blacklisted:     yes (%s)\n", strings.Join(lists, ", "))
+	} else {
+		output.WriteString("Blacklisted:     no\n")
+	}
+
+	for i, server := range check.DNSServers {
+		label := indent
+		if i == 0 {
+			label = "DNS servers:     "
+		}
+		owner := server.Organization
+		if server.MullvadDNS {
+			owner = "Mullvad, " + server.MullvadDNSHostname
+		}
+		fmt.Fprintf(&output, "%s%s (%s, %s)\n", label, server.IP, owner, server.Country)
+	}
+
+	switch {
+	case !loc.MullvadExitIP:
+		output.WriteString("DNS leak:        not applicable (not connected to Mullvad VPN)\n")
+	case check.DNSLeak():
+		output.WriteString("DNS leak:        yes, DNS queries bypass Mullvad's DNS servers\n")
+	default:
+		output.WriteString("DNS leak:        no\n")
+	}
+
+	return output.String()
+}
+
+// FormatPorts formats the WireGuard and Shadowsocks port ranges, followed by a table of the relays
+// that accept Shadowsocks on any port of their extra addresses
+func FormatPorts(wireGuardPorts, shadowsocksPorts []relays.PortRange, locations []relays.Location) string {
+	var output strings.Builder
+
+	fmt.Fprintf(&output, "WireGuard ports:     %s\n", formatPortRanges(wireGuardPorts))
+	fmt.Fprintf(&output, "Shadowsocks ports:   %s\n", formatPortRanges(shadowsocksPorts))
+
+	headers := []string{"Country", "City", "Hostname", "Shadowsocks extra addresses (any port)"}
+	var rows [][]string
+	for _, loc := range locations {
+		if len(loc.ShadowsocksExtraAddresses) == 0 {
+			continue
+		}
+		rows = append(rows, []string{
+			loc.Country,
+			loc.City,
+			loc.Hostname,
+			strings.Join(loc.ShadowsocksExtraAddresses, ", "),
+		})
+	}
+
+	if len(rows) > 0 {
+		output.WriteString("\n")
+		output.WriteString(renderTable(headers, rows))
+	}
+
+	return output.String()
+}
+
+// FormatCapabilities formats a capability matrix with one row per city, marking the capabilities offered by at least
+// one relay and listing the combinations offered together by a single relay
+func FormatCapabilities(cities []relays.CityCapabilities) string {
+	if len(cities) == 0 {
+		return ""
+	}
+
+	headers := []string{"Country", "City", "Relays"}
+	for _, f := range relays.AllFeatures {
+		headers = append(headers, f.String())
+	}
+	headers = append(headers, "Combinations")
+
+	rows := make([][]string, len(cities))
+	for i, city := range cities {
+		row := []string{city.Country, city.City, strconv.Itoa(city.Relays)}
+		for _, f := range relays.AllFeatures {
+			if city.Available.Has(f) {
+				row = append(row, "✓")
+			} else {
+				row = append(row, "-")
+			}
+		}
+		rows[i] = append(row, formatCombinations(city.Combinations))
+	}
+
+	return renderTable(headers, rows)
+}
+
+// FormatPlainCapabilities formats a capability matrix one labeled line per city, for screen readers and
+// line-oriented tools
+func FormatPlainCapabilities(cities []relays.CityCapabilities) string {
+	var output strings.Builder
+	for _, city := range cities {
+		fmt.Fprintf(
+			&output,
+			"%s, %s: %s, %s\n",
+			city.City,
+			city.Country,
+			formatRelayCount(city.Relays),
+			formatCombinations(city.Combinations),
+		)
+	}
+	return output.String()
+}
+
+// formatCombinations formats capability combinations as a comma-separated list
+func formatCombinations(combinations []relays.Feature) string {
+	parts := make([]string, len(combinations))
+	for i, c := range combinations {
+		parts[i] = c.String()
+	}
+	return strings.Join(parts, ", ")
+}
+
+// FormatTimings formats the duration of each phase as a table, followed by the total time
+func FormatTimings(report timing.Report) string {
+	headers := []string{"Phase", "Steps", "Time (ms)"}
+	rows := make([][]string, 0, len(report.Phases)+1)
+	for _, p := range report.Phases {
+		rows = append(rows, []string{string(p.Phase), strconv.Itoa(p.Steps), fmt.Sprintf("%.2f", p.DurationMs)})
+	}
+	rows = append(rows, []string{"total", "", fmt.Sprintf("%.2f", report.TotalMs)})
+
+	return renderTable(headers, rows)
+}
+
+// FormatStabilityTable formats the stability of servers as a table, most stable first
+func FormatStabilityTable(stats []stability.Stats, useIPv6 bool) string {
+	if len(stats) == 0 {
+		return ""
+	}
+
+	headers := []string{"Country", "City", "Hostname", "IP", "Mean (ms)", "Std dev (ms)", "Loss"}
+	rows := make([][]string, len(stats))
+	for i, s := range stats {
+		ipAddr := s.Location.IPv4Address
+		if useIPv6 {
+			ipAddr = s.Location.IPv6Address
+		}
+		mean, stdDev := formatStabilityLatency(s)
+		rows[i] = []string{
+			s.Location.Country,
+			s.Location.City,
+			s.Location.Hostname,
+			ipAddr,
+			mean,
+			stdDev,
+			formatLoss(s),
+		}
+	}
+	return renderTable(headers, rows)
+}
+
+// FormatPlainStabilityList formats the stability of servers one labeled line per server, as
+// "hostname: mean, std dev, loss, city, country"
+func FormatPlainStabilityList(stats []stability.Stats) string {
+	var output strings.Builder
+	for _, s := range stats {
+		mean, stdDev := formatStabilityLatency(s)
+		if s.Received == 0 {
+			fmt.Fprintf(&output, "%s: timeout", s.Location.Hostname)
+		} else {
+			fmt.Fprintf(&output, "%s: %s ms mean, %s ms std dev", s.Location.Hostname, mean, stdDev)
+		}
+		fmt.Fprintf(&output, ", %s loss, %s, %s\n", formatLoss(s), s.Location.City, s.Location.Country)
+	}
+	return output.String()
+}
+
+// formatStabilityLatency formats the mean and standard deviation of the latency, or "timeout" and an empty
+// string when no ping was answered
+func formatStabilityLatency(s stability.Stats) (string, string) {
+	if s.Received == 0 {
+		return "timeout", ""
+	}
+	return fmt.Sprintf("%.2f", s.Mean), fmt.Sprintf("%.2f", s.StdDev)
+}
+
+// formatLoss formats the packet loss of a server as a percentage
+func formatLoss(s stability.Stats) string {
+	return fmt.Sprintf("%.0f%%", s.Loss())
+}
+
+// formatPortRanges formats port ranges as a comma-separated list
+func formatPortRanges(ranges []relays.PortRange) string {
+	if len(ranges) == 0 {
+		return "none"
+	}
+	parts := make([]string, len(ranges))
+	for i, r := range ranges {
+		parts[i] = r.String()
+	}
+	return strings.Join(parts, ", ")
+}
+
+// FormatShadowsocksEndpoints formats the Shadowsocks ports offered by bridge relays as a single line
+func FormatShadowsocksEndpoints(endpoints []relays.ShadowsocksEndpoint) string {
+	if len(endpoints) == 0 {
+		return ""
+	}
+	parts := make([]string, len(endpoints))
+	for i, e := range endpoints {
+		parts[i] = fmt.Sprintf("%d/%s (%s)", e.Port, e.Protocol, e.Cipher)
+	}
+	return fmt.Sprintf("Shadowsocks ports: %s\n", strings.Join(parts, ", "))
+}
+
+// FormatComparison formats the per-relay changes between two runs as a table
+func FormatComparison(deltas []compare.Delta) string {
+	if len(deltas) == 0 {
+		return ""
+	}
+
+	headers := []string{"Country", "City", "Hostname", "Before (ms)", "After (ms)", "Change (ms)", "Rank", "Status"}
+	rows := make([][]string, len(deltas))
+
+	for i, d := range deltas {
+		rows[i] = []string{
+			d.Server.Country,
+			d.Server.City,
+			d.Server.Hostname,
+			formatRunLatency(d.BeforeLatency, d.BeforeRank),
+			formatRunLatency(d.AfterLatency, d.AfterRank),
+			formatChange(d.Change()),
+			formatRankChange(d.BeforeRank, d.AfterRank),
+			d.Status.String(),
+		}
+	}
+
+	return renderTable(headers, rows)
+}
+
+// FormatComparisonSummary formats the number of relays per status as a single line
+func FormatComparisonSummary(deltas []compare.Delta) string {
+	return fmt.Sprintf(
+		"%d regressed, %d improved, %d new, %d gone\n",
+		compare.Count(deltas, compare.Regressed),
+		compare.Count(deltas, compare.Improved),
+		compare.Count(deltas, compare.Added),
+		compare.Count(deltas, compare.Removed),
+	)
+}
+
+// formatRunLatency formats the latency of a relay in one run, "-" if the relay is absent from it
+func formatRunLatency(latency *float64, rank int) string {
+	if rank == 0 {
+		return "-"
+	}
+	return formatLatency(latency)
+}
+
+// formatChange formats a latency difference with an explicit sign
+func formatChange(change *float64) string {
+	if change == nil {
+		return ""
+	}
+	return fmt.Sprintf("%+.2f", *change)
+}
+
+// formatRankChange formats the rank of a relay in both runs, e.g. "5 -> 2"
+func formatRankChange(before, after int) string {
+	rank := func(r int) string {
+		if r == 0 {
+			return "-"
+		}
+		return strconv.Itoa(r)
+	}
+	return rank(before) + " -> " + rank(after)
+}
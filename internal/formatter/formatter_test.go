@@ -1,15 +1,22 @@
 package formatter
 
 import (
+	"slices"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/Ch00k/mullvad-compass/internal/api"
+	"github.com/Ch00k/mullvad-compass/internal/compare"
+	"github.com/Ch00k/mullvad-compass/internal/hooks"
 	"github.com/Ch00k/mullvad-compass/internal/relays"
+	"github.com/Ch00k/mullvad-compass/internal/stability"
+	"github.com/Ch00k/mullvad-compass/internal/timing"
 )
 
 func TestFormatTable(t *testing.T) {
 	t.Run("Empty locations", func(t *testing.T) {
-		result := FormatTable([]relays.Location{}, false)
+		result := FormatTable([]relays.Location{}, DefaultOptions())
 		if result != "" {
 			t.Errorf("Expected empty string for empty locations, got %q", result)
 		}
@@ -30,7 +37,7 @@ func TestFormatTable(t *testing.T) {
 			},
 		}
 
-		result := FormatTable(locations, false)
+		result := FormatTable(locations, DefaultOptions())
 		lines := strings.Split(strings.TrimSpace(result), "\n")
 
 		if len(lines) != 3 {
@@ -102,7 +109,7 @@ func TestFormatTable(t *testing.T) {
 		}
 
 		SortLocationsByLatency(locations)
-		result := FormatTable(locations, false)
+		result := FormatTable(locations, DefaultOptions())
 		lines := strings.Split(strings.TrimSpace(result), "\n")
 
 		// Should have header + separator + 3 data lines
@@ -136,7 +143,7 @@ func TestFormatTable(t *testing.T) {
 			},
 		}
 
-		result := FormatTable(locations, false)
+		result := FormatTable(locations, DefaultOptions())
 		if !strings.Contains(result, "timeout") {
 			t.Error("Expected 'timeout' for nil latency")
 		}
@@ -168,7 +175,7 @@ func TestFormatTable(t *testing.T) {
 		}
 
 		SortLocationsByLatency(locations)
-		result := FormatTable(locations, false)
+		result := FormatTable(locations, DefaultOptions())
 		lines := strings.Split(strings.TrimSpace(result), "\n")
 
 		// First data line should have latency value
@@ -203,7 +210,7 @@ func TestFormatTable(t *testing.T) {
 			},
 		}
 
-		result := FormatTable(locations, false)
+		result := FormatTable(locations, DefaultOptions())
 		lines := strings.Split(strings.TrimSpace(result), "\n")
 
 		// All lines should have same structure (multiple spaces between columns)
@@ -368,8 +375,428 @@ func TestFormatLatency(t *testing.T) {
 	}
 }
 
+func TestFormatCityTable(t *testing.T) {
+	t.Run("Empty cities", func(t *testing.T) {
+		if result := FormatCityTable(nil, DefaultOptions()); result != "" {
+			t.Errorf("Expected empty string for no cities, got %q", result)
+		}
+	})
+
+	t.Run("Rows include relay count", func(t *testing.T) {
+		latency := 12.34
+		distance := 123.45
+		cities := []relays.CityBest{
+			{
+				Best: relays.Location{
+					Country:                "Germany",
+					City:                   "Berlin",
+					IPv4Address:            "185.65.134.1",
+					IPv6Address:            "2a03:1b20:5:f011::a01f",
+					Hostname:               "de-ber-wg-001",
+					DistanceFromMyLocation: &distance,
+					Latency:                &latency,
+				},
+				Count: 32,
+			},
+			{
+				Best:  relays.Location{Country: "Germany", City: "Frankfurt", Hostname: "de-fra-wg-001"},
+				Count: 1,
+			},
+		}
+
+		result := FormatCityTable(cities, ipOptions(IPColumnsIPv6))
+		lines := strings.Split(strings.TrimSpace(result), "\n")
+		if len(lines) != 4 {
+			t.Fatalf("Expected 4 lines (header, separator, 2 cities), got %d:\n%s", len(lines), result)
+		}
+		if !strings.HasSuffix(strings.TrimSpace(lines[0]), "Relays") {
+			t.Errorf("Expected Relays column last in header, got %q", lines[0])
+		}
+		if !strings.HasSuffix(lines[2], "32 relays") || !strings.Contains(lines[2], "2a03:1b20:5:f011::a01f") {
+			t.Errorf("Unexpected Berlin row: %q", lines[2])
+		}
+		if !strings.HasSuffix(lines[3], "1 relay") || !strings.Contains(lines[3], "timeout") {
+			t.Errorf("Unexpected Frankfurt row: %q", lines[3])
+		}
+	})
+}
+
+func TestFormatPlainList(t *testing.T) {
+	latency := 15.86
+	distance := 238.4
+	locations := []relays.Location{
+		{
+			Country:                "Germany",
+			City:                   "Berlin",
+			IPv4Address:            "193.32.248.75",
+			IPv6Address:            "2a03:1b20:3:f011::a07f",
+			Hostname:               "de-ber-wg-007",
+			DistanceFromMyLocation: &distance,
+			Latency:                &latency,
+		},
+		{
+			Country:     "Germany",
+			City:        "Frankfurt",
+			IPv4Address: "185.213.155.74",
+			Hostname:    "de-fra-wg-001",
+		},
+	}
+
+	t.Run("One labeled line per server", func(t *testing.T) {
+		want := "de-ber-wg-007: 15.86 ms, 238 km, Berlin, Germany, 193.32.248.75\n" +
+			"de-fra-wg-001: timeout, Frankfurt, Germany, 185.213.155.74\n"
+		if got := FormatPlainList(locations, DefaultOptions()); got != want {
+			t.Errorf("FormatPlainList() = %q, want %q", got, want)
+		}
+	})
+
+	t.Run("IPv6 address", func(t *testing.T) {
+		got := FormatPlainList(locations[:1], ipOptions(IPColumnsIPv6))
+		if !strings.HasSuffix(got, ", 2a03:1b20:3:f011::a07f\n") {
+			t.Errorf("Expected IPv6 address, got %q", got)
+		}
+	})
+
+	t.Run("Empty", func(t *testing.T) {
+		if got := FormatPlainList(nil, DefaultOptions()); got != "" {
+			t.Errorf("Expected empty output, got %q", got)
+		}
+	})
+
+	t.Run("Per city", func(t *testing.T) {
+		cities := []relays.CityBest{{Best: locations[0], Count: 8}, {Best: locations[1], Count: 1}}
+		want := "de-ber-wg-007: 15.86 ms, 238 km, Berlin, Germany, 193.32.248.75, 8 relays\n" +
+			"de-fra-wg-001: timeout, Frankfurt, Germany, 185.213.155.74, 1 relay\n"
+		if got := FormatPlainCityList(cities, DefaultOptions()); got != want {
+			t.Errorf("FormatPlainCityList() = %q, want %q", got, want)
+		}
+	})
+
+	t.Run("Best server", func(t *testing.T) {
+		userLoc := api.UserLocation{City: "Dresden", Country: "Germany", IP: "203.0.113.42"}
+		want := "Your location: Dresden, Germany, 203.0.113.42\n" +
+			"Best server: de-ber-wg-007: 15.86 ms, 238 km, Berlin, Germany, 193.32.248.75\n"
+		if got := FormatPlainBestServer(userLoc, locations[0], DefaultOptions()); got != want {
+			t.Errorf("FormatPlainBestServer() = %q, want %q", got, want)
+		}
+	})
+}
+
+func TestFormatFavorites(t *testing.T) {
+	latency := 15.86
+	distance := 238.4
+	locations := []relays.Location{
+		{
+			Country:                "Germany",
+			City:                   "Berlin",
+			IPv4Address:            "193.32.248.75",
+			Hostname:               "de-ber-wg-007",
+			DistanceFromMyLocation: &distance,
+			Latency:                &latency,
+			Favorite:               true,
+		},
+		{Country: "Germany", City: "Frankfurt", IPv4Address: "185.213.155.74", Hostname: "de-fra-wg-001"},
+	}
+
+	t.Run("Marker column only with favorites", func(t *testing.T) {
+		lines := strings.Split(FormatTable(locations, DefaultOptions()), "\n")
+		if !strings.HasPrefix(lines[0], "    Country") || !strings.HasPrefix(lines[1], "-   ---") {
+			t.Errorf("Expected an unlabeled marker column first, got:\n%s\n%s", lines[0], lines[1])
+		}
+		if !strings.HasPrefix(lines[2], "★   Germany") || !strings.HasPrefix(lines[3], "    Germany") {
+			t.Errorf("Expected only the favorite to be marked, got:\n%s\n%s", lines[2], lines[3])
+		}
+
+		plain := FormatTable(locations[1:], DefaultOptions())
+		if strings.Contains(plain, "★") || !strings.HasPrefix(plain, "Country") {
+			t.Errorf("Expected no marker column without favorites, got:\n%s", plain)
+		}
+	})
+
+	t.Run("Per city", func(t *testing.T) {
+		cities := []relays.CityBest{{Best: locations[1], Count: 1}, {Best: locations[0], Count: 8}}
+		lines := strings.Split(FormatCityTable(cities, DefaultOptions()), "\n")
+		if !strings.HasPrefix(lines[2], "    Germany") || !strings.HasPrefix(lines[3], "★   Germany") {
+			t.Errorf("Expected the Berlin row to be marked, got:\n%s\n%s", lines[2], lines[3])
+		}
+	})
+
+	t.Run("Plain", func(t *testing.T) {
+		want := "de-ber-wg-007: 15.86 ms, 238 km, Berlin, Germany, 193.32.248.75, favorite\n" +
+			"de-fra-wg-001: timeout, Frankfurt, Germany, 185.213.155.74\n"
+		if got := FormatPlainList(locations, DefaultOptions()); got != want {
+			t.Errorf("FormatPlainList() = %q, want %q", got, want)
+		}
+	})
+
+	t.Run("Best server", func(t *testing.T) {
+		got := FormatBestServer(api.UserLocation{City: "Dresden", Country: "Germany"}, locations[0], DefaultOptions())
+		if !strings.Contains(got, "de-ber-wg-007 (193.32.248.75) ★\n") {
+			t.Errorf("Expected a marked best server, got:\n%s", got)
+		}
+	})
+}
+
+func TestFormatASN(t *testing.T) {
+	locations := []relays.Location{
+		{
+			Country:         "Sweden",
+			City:            "Stockholm",
+			IPv4Address:     "185.195.233.76",
+			Hostname:        "se-sto-wg-001",
+			ASN:             39351,
+			ASNOrganization: "31173 Services AB",
+		},
+		{Country: "Sweden", City: "Malmö", IPv4Address: "193.138.218.220", Hostname: "se-mma-wg-001"},
+	}
+
+	t.Run("Column only with ASNs", func(t *testing.T) {
+		lines := strings.Split(FormatTable(locations, DefaultOptions()), "\n")
+		if !strings.HasSuffix(strings.TrimSpace(lines[0]), "ASN") {
+			t.Errorf("Expected an ASN column last, got:\n%s", lines[0])
+		}
+		if !strings.HasSuffix(lines[2], "AS39351 31173 Services AB") {
+			t.Errorf("Expected the ASN of the Stockholm server, got:\n%s", lines[2])
+		}
+
+		if strings.Contains(FormatTable(locations[1:], DefaultOptions()), "ASN") {
+			t.Error("Expected no ASN column without ASNs")
+		}
+	})
+
+	t.Run("Plain", func(t *testing.T) {
+		want := "se-sto-wg-001: timeout, Stockholm, Sweden, 185.195.233.76, AS39351 31173 Services AB\n" +
+			"se-mma-wg-001: timeout, Malmö, Sweden, 193.138.218.220\n"
+		if got := FormatPlainList(locations, DefaultOptions()); got != want {
+			t.Errorf("FormatPlainList() = %q, want %q", got, want)
+		}
+	})
+}
+
+func TestFormatCapabilities(t *testing.T) {
+	cities := []relays.CityCapabilities{
+		{
+			Country:      "Sweden",
+			City:         "Malmö",
+			Relays:       10,
+			Available:    relays.FeatureDAITA | relays.FeatureLWO | relays.FeatureIPv6,
+			Combinations: []relays.Feature{relays.FeatureDAITA | relays.FeatureIPv6, relays.FeatureLWO},
+		},
+		{Country: "Sweden", City: "Umeå", Relays: 1, Combinations: []relays.Feature{0}},
+	}
+
+	t.Run("Table", func(t *testing.T) {
+		lines := strings.Split(strings.TrimSpace(FormatCapabilities(cities)), "\n")
+		if len(lines) != 4 {
+			t.Fatalf("Expected 4 lines (header, separator, 2 cities), got %d", len(lines))
+		}
+		if fields := strings.Fields(lines[0]); !slices.Equal(fields, []string{
+			"Country", "City", "Relays", "DAITA", "LWO", "QUIC", "Shadowsocks", "IPv6", "Combinations",
+		}) {
+			t.Errorf("Unexpected header: %q", lines[0])
+		}
+		if fields := strings.Fields(lines[2]); !slices.Equal(fields, []string{
+			"Sweden", "Malmö", "10", "✓", "✓", "-", "-", "✓", "DAITA+IPv6,", "LWO",
+		}) {
+			t.Errorf("Unexpected Malmö row: %q", lines[2])
+		}
+		if fields := strings.Fields(lines[3]); fields[len(fields)-1] != "-" {
+			t.Errorf("Expected no combinations for Umeå, got %q", lines[3])
+		}
+	})
+
+	t.Run("Plain", func(t *testing.T) {
+		want := "Malmö, Sweden: 10 relays, DAITA+IPv6, LWO\nUmeå, Sweden: 1 relay, -\n"
+		if got := FormatPlainCapabilities(cities); got != want {
+			t.Errorf("FormatPlainCapabilities() = %q, want %q", got, want)
+		}
+	})
+
+	t.Run("Empty", func(t *testing.T) {
+		if got := FormatCapabilities(nil); got != "" {
+			t.Errorf("Expected empty output, got %q", got)
+		}
+	})
+}
+
+func TestFormatTimings(t *testing.T) {
+	report := timing.Report{
+		Phases: []timing.ReportPhase{
+			{Phase: timing.PhaseParse, Steps: 1, DurationMs: 3.456},
+			{Phase: timing.PhasePing, Steps: 2, DurationMs: 512},
+		},
+		TotalMs: 530.1,
+	}
+
+	want := "Phase   Steps   Time (ms)\n" +
+		"-----   -----   ---------\n" +
+		"parse   1       3.46     \n" +
+		"ping    2       512.00   \n" +
+		"total           530.10   \n"
+	if got := FormatTimings(report); got != want {
+		t.Errorf("FormatTimings() = %q, want %q", got, want)
+	}
+}
+
+func TestFormatStability(t *testing.T) {
+	stats := []stability.Stats{
+		{
+			Location: relays.Location{
+				Country:     "Sweden",
+				City:        "Gothenburg",
+				Hostname:    "se-got-wg-001",
+				IPv4Address: "185.213.154.68",
+			},
+			Sent:     10,
+			Received: 10,
+			Mean:     12.345,
+			StdDev:   0.8,
+		},
+		{
+			Location: relays.Location{
+				Country:     "Germany",
+				City:        "Berlin",
+				Hostname:    "de-ber-wg-001",
+				IPv4Address: "193.32.248.66",
+			},
+			Sent:     10,
+			Received: 9,
+			Mean:     20,
+			StdDev:   3.25,
+		},
+		{
+			Location: relays.Location{
+				Country:     "Germany",
+				City:        "Berlin",
+				Hostname:    "de-ber-wg-002",
+				IPv4Address: "193.32.248.67",
+			},
+			Sent: 10,
+		},
+	}
+
+	t.Run("Table", func(t *testing.T) {
+		want := "Country   City         Hostname        IP               Mean (ms)   Std dev (ms)   Loss\n" +
+			"-------   ----------   -------------   --------------   ---------   ------------   ----\n" +
+			"Sweden    Gothenburg   se-got-wg-001   185.213.154.68   12.35       0.80           0%  \n" +
+			"Germany   Berlin       de-ber-wg-001   193.32.248.66    20.00       3.25           10% \n" +
+			"Germany   Berlin       de-ber-wg-002   193.32.248.67    timeout                    100%\n"
+		if got := FormatStabilityTable(stats, false); got != want {
+			t.Errorf("FormatStabilityTable() = %q, want %q", got, want)
+		}
+	})
+
+	t.Run("Plain", func(t *testing.T) {
+		want := "se-got-wg-001: 12.35 ms mean, 0.80 ms std dev, 0% loss, Gothenburg, Sweden\n" +
+			"de-ber-wg-001: 20.00 ms mean, 3.25 ms std dev, 10% loss, Berlin, Germany\n" +
+			"de-ber-wg-002: timeout, 100% loss, Berlin, Germany\n"
+		if got := FormatPlainStabilityList(stats); got != want {
+			t.Errorf("FormatPlainStabilityList() = %q, want %q", got, want)
+		}
+	})
+
+	t.Run("Empty", func(t *testing.T) {
+		if got := FormatStabilityTable(nil, false); got != "" {
+			t.Errorf("Expected empty output, got %q", got)
+		}
+	})
+}
+
+func TestFormatTableFeatures(t *testing.T) {
+	latency := 12.34
+	locations := []relays.Location{
+		{
+			Country:     "Germany",
+			City:        "Berlin",
+			IPv4Address: "185.65.134.1",
+			Hostname:    "de-ber-wg-001",
+			Latency:     &latency,
+			Features:    relays.FeatureDAITA | relays.FeatureQUIC | relays.FeatureIPv6,
+		},
+		{
+			Country:     "Germany",
+			City:        "Berlin",
+			IPv4Address: "185.65.134.2",
+			Hostname:    "de-ber-wg-002",
+		},
+	}
+	opts := DefaultOptions()
+	opts.Features = true
+
+	lines := strings.Split(FormatTable(locations, opts), "\n")
+	if !strings.Contains(lines[0], "IP             Features   Latency (ms)") {
+		t.Errorf("Expected a Features column before the latency, got %q", lines[0])
+	}
+	if !strings.Contains(lines[2], "185.65.134.1   D-Q-6      12.34") {
+		t.Errorf("Expected the flags of the first relay, got %q", lines[2])
+	}
+	if !strings.Contains(lines[3], "185.65.134.2   -----      timeout") {
+		t.Errorf("Expected empty flags for the second relay, got %q", lines[3])
+	}
+
+	if strings.Contains(FormatTable(locations, DefaultOptions()), "Features") {
+		t.Error("Expected no Features column by default")
+	}
+
+	plain := FormatPlainList(locations[:1], opts)
+	if want := "de-ber-wg-001: 12.34 ms, Berlin, Germany, 185.65.134.1, features DAITA+QUIC+IPv6\n"; plain != want {
+		t.Errorf("FormatPlainList() = %q, want %q", plain, want)
+	}
+}
+
+func TestFormatTableDualStack(t *testing.T) {
+	v4, v6 := 12.34, 15.5
+	locations := []relays.Location{
+		{
+			Country:     "Germany",
+			City:        "Berlin",
+			IPv4Address: "185.65.134.1",
+			IPv6Address: "2a03:1b20:5:f011::a01f",
+			Hostname:    "de-ber-wg-001",
+			Latency:     &v4,
+			LatencyIPv6: &v6,
+		},
+		{
+			Country:     "Germany",
+			City:        "Berlin",
+			IPv4Address: "185.65.134.2",
+			IPv6Address: "2a03:1b20:5:f011::a02f",
+			Hostname:    "de-ber-wg-002",
+			Latency:     &v4,
+		},
+		{
+			Country:     "Germany",
+			City:        "Berlin",
+			IPv4Address: "185.65.134.3",
+			Hostname:    "de-ber-wg-003",
+			Latency:     &v4,
+		},
+	}
+	opts := DefaultOptions()
+	opts.IPv6 = true
+
+	lines := strings.Split(FormatTable(locations, opts), "\n")
+	if !strings.HasSuffix(strings.TrimSpace(lines[0]), "IPv4 Latency (ms)   IPv6 Latency (ms)") {
+		t.Errorf("Expected an IPv4 and an IPv6 latency column, got %q", lines[0])
+	}
+	for i, want := range []string{"12.34               15.50", "12.34               timeout", "12.34"} {
+		if got := strings.TrimSpace(lines[i+2]); !strings.HasSuffix(got, want) {
+			t.Errorf("Expected row %d to end with %q, got %q", i+1, want, got)
+		}
+	}
+
+	plain := FormatPlainList(locations, opts)
+	want := "de-ber-wg-001: 12.34 ms, IPv6 15.50 ms, Berlin, Germany, 185.65.134.1\n"
+	if !strings.HasPrefix(plain, want) {
+		t.Errorf("Expected %q first, got %q", want, plain)
+	}
+	if !strings.Contains(plain, "de-ber-wg-003: 12.34 ms, Berlin, Germany, 185.65.134.3\n") {
+		t.Errorf("Expected no IPv6 latency for a relay without an IPv6 address, got %q", plain)
+	}
+}
+
 func TestFormatTableWithIPv6(t *testing.T) {
-	t.Run("Display IPv6 address when useIPv6 is true", func(t *testing.T) {
+	t.Run("Display IPv6 address with IPColumnsIPv6", func(t *testing.T) {
 		latency := 12.34
 		distance := 123.45
 		locations := []relays.Location{
@@ -385,16 +812,16 @@ func TestFormatTableWithIPv6(t *testing.T) {
 			},
 		}
 
-		result := FormatTable(locations, true)
+		result := FormatTable(locations, ipOptions(IPColumnsIPv6))
 		if !strings.Contains(result, "2a03:1b20:5:f011::a01f") {
-			t.Error("Expected IPv6 address in output when useIPv6 is true")
+			t.Error("Expected IPv6 address in output with IPColumnsIPv6")
 		}
 		if strings.Contains(result, "185.65.134.1") {
-			t.Error("Should not contain IPv4 address when useIPv6 is true")
+			t.Error("Should not contain IPv4 address with IPColumnsIPv6")
 		}
 	})
 
-	t.Run("Display IPv4 address when useIPv6 is false", func(t *testing.T) {
+	t.Run("Display IPv4 address with IPColumnsIPv4", func(t *testing.T) {
 		latency := 12.34
 		distance := 123.45
 		locations := []relays.Location{
@@ -410,17 +837,350 @@ func TestFormatTableWithIPv6(t *testing.T) {
 			},
 		}
 
-		result := FormatTable(locations, false)
+		result := FormatTable(locations, DefaultOptions())
 		if !strings.Contains(result, "185.65.134.1") {
-			t.Error("Expected IPv4 address in output when useIPv6 is false")
+			t.Error("Expected IPv4 address in output with IPColumnsIPv4")
 		}
 		if strings.Contains(result, "2a03:1b20:5:f011::a01f") {
-			t.Error("Should not contain IPv6 address when useIPv6 is false")
+			t.Error("Should not contain IPv6 address with IPColumnsIPv4")
+		}
+	})
+
+	t.Run("Display both addresses with IPColumnsBoth", func(t *testing.T) {
+		locations := []relays.Location{
+			{
+				Country:                "Germany",
+				City:                   "Berlin",
+				IPv4Address:            "185.65.134.1",
+				IPv6Address:            "2a03:1b20:5:f011::a01f",
+				Hostname:               "de-ber-wg-001",
+				Latency:                ptr(12.34),
+				DistanceFromMyLocation: ptr(162.3),
+			},
+			{
+				Country:                "Germany",
+				City:                   "Berlin",
+				IPv4Address:            "185.65.134.2",
+				Hostname:               "de-ber-br-001",
+				Latency:                ptr(15.67),
+				DistanceFromMyLocation: ptr(162.3),
+			},
+		}
+
+		lines := trimmedLines(FormatTable(locations, ipOptions(IPColumnsBoth)))
+		want := []string{
+			"Country   City     Distance (km)   Hostname        IPv4           IPv6                     Latency (ms)",
+			"-------   ------   -------------   -------------   ------------   ----------------------   ------------",
+			"Germany   Berlin   162             de-ber-wg-001   185.65.134.1   2a03:1b20:5:f011::a01f   12.34",
+			"Germany   Berlin   162             de-ber-br-001   185.65.134.2                            15.67",
+			"",
+		}
+		if !slices.Equal(lines, want) {
+			t.Errorf("FormatTable() =\n%s\nwant:\n%s", strings.Join(lines, "\n"), strings.Join(want, "\n"))
 		}
 	})
 }
 
+// ipOptions returns the default options with the given address columns
+func ipOptions(ips IPColumns) Options {
+	opts := DefaultOptions()
+	opts.IPs = ips
+	return opts
+}
+
 // Helper function to create pointer to float64
 func ptr(f float64) *float64 {
 	return &f
 }
+
+func TestSummarize(t *testing.T) {
+	t.Run("Empty locations", func(t *testing.T) {
+		s := Summarize([]relays.Location{})
+		if s.Count != 0 || s.Reachable != 0 {
+			t.Errorf("Expected zero counts, got %+v", s)
+		}
+		if s.P50Latency != nil || s.P90Latency != nil {
+			t.Error("Expected nil percentiles for empty locations")
+		}
+	})
+
+	t.Run("Mixed reachable and timeouts", func(t *testing.T) {
+		latencies := []float64{30, 10, 20, 40}
+		locations := []relays.Location{
+			{Hostname: "a", Latency: &latencies[0]},
+			{Hostname: "b", Latency: &latencies[1]},
+			{Hostname: "c", Latency: nil},
+			{Hostname: "d", Latency: &latencies[2]},
+			{Hostname: "e", Latency: &latencies[3]},
+		}
+
+		s := Summarize(locations)
+		if s.Count != 5 {
+			t.Errorf("Expected count 5, got %d", s.Count)
+		}
+		if s.Reachable != 4 {
+			t.Errorf("Expected 4 reachable, got %d", s.Reachable)
+		}
+		if s.ReachablePct != 80 {
+			t.Errorf("Expected 80%% reachable, got %f", s.ReachablePct)
+		}
+		if s.P50Latency == nil || *s.P50Latency != 20 {
+			t.Errorf("Expected p50 of 20, got %v", s.P50Latency)
+		}
+		if s.P90Latency == nil || *s.P90Latency != 40 {
+			t.Errorf("Expected p90 of 40, got %v", s.P90Latency)
+		}
+		if s.BestHostname != "b" {
+			t.Errorf("Expected best hostname 'b', got %q", s.BestHostname)
+		}
+	})
+
+	t.Run("All timeouts", func(t *testing.T) {
+		locations := []relays.Location{{Hostname: "a"}, {Hostname: "b"}}
+		s := Summarize(locations)
+		if s.Reachable != 0 || s.ReachablePct != 0 {
+			t.Errorf("Expected nothing reachable, got %+v", s)
+		}
+		if s.BestHostname != "" {
+			t.Errorf("Expected empty best hostname, got %q", s.BestHostname)
+		}
+	})
+}
+
+func TestFormatSummary(t *testing.T) {
+	t.Run("With reachable servers", func(t *testing.T) {
+		p50 := 13.01
+		p90 := 15.95
+		s := Summary{
+			Count:        11,
+			Reachable:    11,
+			ReachablePct: 100,
+			P50Latency:   &p50,
+			P90Latency:   &p90,
+			BestHostname: "cz-prg-wg-201",
+		}
+		expected := "11 servers, 100% reachable, p50 13.01 ms, p90 15.95 ms, best cz-prg-wg-201\n"
+		if got := FormatSummary(s); got != expected {
+			t.Errorf("Expected %q, got %q", expected, got)
+		}
+	})
+
+	t.Run("Single unreachable server", func(t *testing.T) {
+		s := Summary{Count: 1}
+		expected := "1 server, 0% reachable\n"
+		if got := FormatSummary(s); got != expected {
+			t.Errorf("Expected %q, got %q", expected, got)
+		}
+	})
+}
+
+func TestFormatSummaryLine(t *testing.T) {
+	best := 12.5
+	s := Summary{Count: 12, Reachable: 11, BestHostname: "de-ber-wg-001", BestLatency: &best}
+	expected := "best=de-ber-wg-001 latency_ms=12.50 reachable=11 total=12 duration_ms=1532\n"
+	if got := FormatSummaryLine(s, 1532*time.Millisecond); got != expected {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+
+	expected = "best=- latency_ms=- reachable=0 total=3 duration_ms=2000\n"
+	if got := FormatSummaryLine(Summary{Count: 3}, 2*time.Second); got != expected {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+}
+
+func TestFormatComparison(t *testing.T) {
+	before := 12.0
+	after := 30.5
+	deltas := []compare.Delta{
+		{
+			Server:        hooks.Server{Hostname: "se-got-wg-002", Country: "Sweden", City: "Gothenburg"},
+			BeforeLatency: &before,
+			AfterLatency:  &after,
+			BeforeRank:    2,
+			AfterRank:     5,
+			Status:        compare.Regressed,
+		},
+		{
+			Server:       hooks.Server{Hostname: "se-got-wg-006", Country: "Sweden", City: "Gothenburg"},
+			AfterLatency: &after,
+			AfterRank:    6,
+			Status:       compare.Added,
+		},
+		{
+			Server:        hooks.Server{Hostname: "se-got-wg-001", Country: "Sweden", City: "Gothenburg"},
+			BeforeLatency: &before,
+			BeforeRank:    1,
+			Status:        compare.Removed,
+		},
+	}
+
+	lines := strings.Split(strings.TrimRight(FormatComparison(deltas), "\n"), "\n")
+	if len(lines) != 5 {
+		t.Fatalf("Expected 5 lines, got %d:\n%s", len(lines), strings.Join(lines, "\n"))
+	}
+
+	expectedRows := [][]string{
+		{"se-got-wg-002", "12.00", "30.50", "+18.50", "2 -> 5", "regressed"},
+		{"se-got-wg-006", "-", "30.50", "- -> 6", "new"},
+		{"se-got-wg-001", "12.00", "-", "1 -> -", "gone"},
+	}
+	for i, cells := range expectedRows {
+		for _, cell := range cells {
+			if !strings.Contains(lines[i+2], cell) {
+				t.Errorf("Expected row %d to contain %q, got %q", i, cell, lines[i+2])
+			}
+		}
+	}
+
+	if FormatComparison(nil) != "" {
+		t.Error("Expected empty output for no deltas")
+	}
+
+	expected := "1 regressed, 0 improved, 1 new, 1 gone\n"
+	if got := FormatComparisonSummary(deltas); got != expected {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+}
+
+func TestSortLocationsByDistance(t *testing.T) {
+	near := 100.0
+	far := 900.0
+	locations := []relays.Location{
+		{Hostname: "no-distance"},
+		{Hostname: "far", DistanceFromMyLocation: &far},
+		{Hostname: "near-b", Country: "Germany", DistanceFromMyLocation: &near},
+		{Hostname: "near-a", Country: "Czech Republic", DistanceFromMyLocation: &near},
+	}
+
+	SortLocationsByDistance(locations)
+
+	expected := []string{"near-a", "near-b", "far", "no-distance"}
+	for i, hostname := range expected {
+		if locations[i].Hostname != hostname {
+			t.Errorf("Position %d: expected %s, got %s", i, hostname, locations[i].Hostname)
+		}
+	}
+}
+
+func TestFormatConnectionCheck(t *testing.T) {
+	t.Run("Connected without leaks", func(t *testing.T) {
+		check := api.ConnectionCheck{
+			Location: api.UserLocation{
+				IP:                    "193.32.248.66",
+				Country:               "Germany",
+				City:                  "Berlin",
+				MullvadExitIP:         true,
+				MullvadExitIPHostname: "de-ber-wg-001",
+				MullvadServerType:     "WireGuard",
+				Organization:          "31173 Services AB",
+			},
+			DNSServers: []api.DNSServer{
+				{IP: "10.64.0.1", Country: "Germany", MullvadDNS: true, MullvadDNSHostname: "de-ber-dns-001"},
+			},
+		}
+
+		expected := `Exit IP:         193.32.248.66
+Location:        Berlin, Germany
+Organization:    31173 Services AB
+Mullvad VPN:     connected via de-ber-wg-001 (WireGuard)
+Blacklisted:     no
+DNS servers:     10.64.0.1 (Mullvad, de-ber-dns-001, Germany)
+DNS leak:        no
+`
+		if got := FormatConnectionCheck(check); got != expected {
+			t.Errorf("Expected:\n%s\nGot:\n%s", expected, got)
+		}
+	})
+
+	t.Run("Connected with leak and blacklisted", func(t *testing.T) {
+		check := api.ConnectionCheck{
+			Location: api.UserLocation{
+				IP:                    "193.32.248.66",
+				MullvadExitIP:         true,
+				MullvadExitIPHostname: "de-ber-wg-001",
+				Blacklisted: api.Blacklisted{
+					Blacklisted: true,
+					Results: []api.BlacklistResult{
+						{Name: "Spamhaus", Blacklisted: true},
+						{Name: "Other", Blacklisted: false},
+					},
+				},
+			},
+			DNSServers: []api.DNSServer{
+				{IP: "10.64.0.1", MullvadDNS: true},
+				{IP: "203.0.113.53", Organization: "Example ISP", Country: "Germany"},
+			},
+		}
+
+		result := FormatConnectionCheck(check)
+		if !strings.Contains(result, "Blacklisted:     yes (Spamhaus)\n") {
+			t.Errorf("Expected blacklist line, got:\n%s", result)
+		}
+		if !strings.Contains(result, "                 203.0.113.53 (Example ISP, Germany)\n") {
+			t.Errorf("Expected second DNS server on indented line, got:\n%s", result)
+		}
+		if !strings.Contains(result, "DNS leak:        yes") {
+			t.Errorf("Expected DNS leak, got:\n%s", result)
+		}
+	})
+
+	t.Run("Not connected", func(t *testing.T) {
+		check := api.ConnectionCheck{Location: api.UserLocation{IP: "203.0.113.42"}}
+
+		result := FormatConnectionCheck(check)
+		if !strings.Contains(result, "Mullvad VPN:     not connected\n") {
+			t.Errorf("Expected not connected line, got:\n%s", result)
+		}
+		if !strings.Contains(result, "DNS leak:        not applicable") {
+			t.Errorf("Expected DNS leak not applicable, got:\n%s", result)
+		}
+	})
+}
+
+func TestFormatPorts(t *testing.T) {
+	t.Run("Ranges and relays with extra addresses", func(t *testing.T) {
+		wireGuard := []relays.PortRange{{53, 53}, {4000, 33433}}
+		shadowsocks := []relays.PortRange{{51900, 51949}}
+		locations := []relays.Location{
+			{Country: "Albania", City: "Tirana", Hostname: "al-tia-wg-003", ShadowsocksExtraAddresses: []string{"103.204.123.136"}},
+			{Country: "Germany", City: "Berlin", Hostname: "de-ber-wg-001"},
+		}
+
+		expected := `WireGuard ports:     53, 4000-33433
+Shadowsocks ports:   51900-51949
+
+Country   City     Hostname        Shadowsocks extra addresses (any port)
+-------   ------   -------------   --------------------------------------
+Albania   Tirana   al-tia-wg-003   103.204.123.136                       
+`
+		if got := FormatPorts(wireGuard, shadowsocks, locations); got != expected {
+			t.Errorf("Expected:\n%q\nGot:\n%q", expected, got)
+		}
+	})
+
+	t.Run("No ranges and no extra addresses", func(t *testing.T) {
+		expected := "WireGuard ports:     none\nShadowsocks ports:   none\n"
+		if got := FormatPorts(nil, nil, nil); got != expected {
+			t.Errorf("Expected %q, got %q", expected, got)
+		}
+	})
+}
+
+func TestFormatShadowsocksEndpoints(t *testing.T) {
+	t.Run("Multiple endpoints", func(t *testing.T) {
+		endpoints := []relays.ShadowsocksEndpoint{
+			{Port: 443, Protocol: "tcp", Cipher: "aes-256-gcm"},
+			{Port: 1234, Protocol: "udp", Cipher: "aes-256-cfb"},
+		}
+		expected := "Shadowsocks ports: 443/tcp (aes-256-gcm), 1234/udp (aes-256-cfb)\n"
+		if got := FormatShadowsocksEndpoints(endpoints); got != expected {
+			t.Errorf("Expected %q, got %q", expected, got)
+		}
+	})
+
+	t.Run("No endpoints", func(t *testing.T) {
+		if got := FormatShadowsocksEndpoints(nil); got != "" {
+			t.Errorf("Expected empty string, got %q", got)
+		}
+	})
+}
@@ -0,0 +1,99 @@
+package formatter
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/Ch00k/mullvad-compass/internal/api"
+	"github.com/Ch00k/mullvad-compass/internal/relays"
+)
+
+// geoJSONCollection is a GeoJSON FeatureCollection (RFC 7946)
+type geoJSONCollection struct {
+	Type     string           `json:"type"`
+	Features []geoJSONFeature `json:"features"`
+}
+
+// geoJSONFeature is a GeoJSON Feature with point geometry
+type geoJSONFeature struct {
+	Type       string        `json:"type"`
+	Geometry   *geoJSONPoint `json:"geometry"` // nil for relays without coordinates
+	Properties any           `json:"properties"`
+}
+
+// geoJSONPoint is a GeoJSON Point, with coordinates in longitude, latitude order
+type geoJSONPoint struct {
+	Type        string     `json:"type"`
+	Coordinates [2]float64 `json:"coordinates"`
+}
+
+// geoJSONUser describes the user's location
+type geoJSONUser struct {
+	Kind    string `json:"kind"`
+	City    string `json:"city"`
+	Country string `json:"country"`
+}
+
+// geoJSONServer describes a ranked server and its measurements
+type geoJSONServer struct {
+	Kind        string   `json:"kind"`
+	Rank        int      `json:"rank"`
+	Hostname    string   `json:"hostname"`
+	Country     string   `json:"country"`
+	City        string   `json:"city"`
+	IP          string   `json:"ip"`
+	Latency     *float64 `json:"latency_ms"`                // nil indicates timeout
+	LatencyIPv6 *float64 `json:"latency_ipv6_ms,omitempty"` // Set when both IP versions are pinged
+	Distance    *float64 `json:"distance_km"`               // nil when unknown
+}
+
+// FormatGeoJSON renders the user's location and the ranked servers as an indented GeoJSON FeatureCollection, to be
+// dropped onto a map such as geojson.io or Grafana's Geomap. Servers keep their rank, and relays without
+// coordinates have no geometry.
+func FormatGeoJSON(userLoc api.UserLocation, locations []relays.Location, useIPv6 bool) (string, error) {
+	collection := geoJSONCollection{
+		Type:     "FeatureCollection",
+		Features: make([]geoJSONFeature, 0, len(locations)+1),
+	}
+	collection.Features = append(collection.Features, geoJSONFeature{
+		Type:       "Feature",
+		Geometry:   newGeoJSONPoint(userLoc.Latitude, userLoc.Longitude),
+		Properties: geoJSONUser{Kind: "user", City: userLoc.City, Country: userLoc.Country},
+	})
+
+	for i, loc := range locations {
+		ip := loc.IPv4Address
+		if useIPv6 {
+			ip = loc.IPv6Address
+		}
+		feature := geoJSONFeature{
+			Type: "Feature",
+			Properties: geoJSONServer{
+				Kind:        "server",
+				Rank:        i + 1,
+				Hostname:    loc.Hostname,
+				Country:     loc.Country,
+				City:        loc.City,
+				IP:          ip,
+				Latency:     loc.Latency,
+				LatencyIPv6: loc.LatencyIPv6,
+				Distance:    loc.DistanceFromMyLocation,
+			},
+		}
+		if loc.HasCoordinates() {
+			feature.Geometry = newGeoJSONPoint(loc.Latitude, loc.Longitude)
+		}
+		collection.Features = append(collection.Features, feature)
+	}
+
+	data, err := json.MarshalIndent(collection, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to encode GeoJSON: %w", err)
+	}
+	return string(data) + "\n", nil
+}
+
+// newGeoJSONPoint returns the point at the given latitude and longitude
+func newGeoJSONPoint(latitude, longitude float64) *geoJSONPoint {
+	return &geoJSONPoint{Type: "Point", Coordinates: [2]float64{longitude, latitude}}
+}
@@ -0,0 +1,72 @@
+package formatter
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/Ch00k/mullvad-compass/internal/api"
+	"github.com/Ch00k/mullvad-compass/internal/relays"
+)
+
+func TestFormatGeoJSON(t *testing.T) {
+	userLoc := api.UserLocation{City: "Dresden", Country: "Germany", IP: "203.0.113.42", Latitude: 51.05,
+		Longitude: 13.73}
+	locations := []relays.Location{
+		{Hostname: "cz-prg-wg-201", Country: "Czech Republic", City: "Prague", IPv4Address: "178.249.209.162",
+			IPv6Address: "2a02:6ea0:c201::f001", Latitude: 50.08, Longitude: 14.42, Latency: ptr(9.78),
+			DistanceFromMyLocation: ptr(121.0)},
+		{Hostname: "xx-unk-wg-001", Country: "Unknown", City: "Unknown", IPv4Address: "192.0.2.1"},
+	}
+
+	output, err := FormatGeoJSON(userLoc, locations, false)
+	if err != nil {
+		t.Fatalf("FormatGeoJSON() error = %v", err)
+	}
+
+	var collection struct {
+		Type     string `json:"type"`
+		Features []struct {
+			Type     string `json:"type"`
+			Geometry *struct {
+				Type        string     `json:"type"`
+				Coordinates [2]float64 `json:"coordinates"`
+			} `json:"geometry"`
+			Properties map[string]any `json:"properties"`
+		} `json:"features"`
+	}
+	if err := json.Unmarshal([]byte(output), &collection); err != nil {
+		t.Fatalf("Output is not valid JSON: %v\n%s", err, output)
+	}
+	if collection.Type != "FeatureCollection" || len(collection.Features) != 3 {
+		t.Fatalf("Expected a FeatureCollection of 3 features, got:\n%s", output)
+	}
+
+	user := collection.Features[0]
+	if user.Properties["kind"] != "user" || user.Geometry.Coordinates != [2]float64{13.73, 51.05} {
+		t.Errorf("Expected the user at longitude, latitude 13.73, 51.05, got %+v %+v", user.Geometry, user.Properties)
+	}
+	if _, ok := user.Properties["ip"]; ok {
+		t.Error("Expected the user's IP address to be left out")
+	}
+
+	server := collection.Features[1]
+	if server.Geometry == nil || server.Geometry.Type != "Point" ||
+		server.Geometry.Coordinates != [2]float64{14.42, 50.08} {
+		t.Errorf("Expected a point at 14.42, 50.08, got %+v", server.Geometry)
+	}
+	want := map[string]any{"kind": "server", "rank": 1.0, "hostname": "cz-prg-wg-201", "ip": "178.249.209.162",
+		"latency_ms": 9.78, "distance_km": 121.0}
+	for key, value := range want {
+		if server.Properties[key] != value {
+			t.Errorf("Property %s = %v, want %v", key, server.Properties[key], value)
+		}
+	}
+
+	unlocated := collection.Features[2]
+	if unlocated.Geometry != nil {
+		t.Errorf("Expected no geometry for a relay without coordinates, got %+v", unlocated.Geometry)
+	}
+	if latency, ok := unlocated.Properties["latency_ms"]; !ok || latency != nil {
+		t.Errorf("Expected a null latency for a timeout, got %v", latency)
+	}
+}
@@ -0,0 +1,150 @@
+package formatter
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/Ch00k/mullvad-compass/internal/relays"
+)
+
+// countryGroup holds the cities of a country in the order they were first seen
+type countryGroup struct {
+	country string
+	cities  []cityGroup
+}
+
+// cityGroup holds the relays of a city in their original order
+type cityGroup struct {
+	city      string
+	locations []relays.Location
+}
+
+// FormatGroupedTable formats locations under country and city headers, each group keeping the order of the
+// locations. For locations sorted by latency, countries and cities are ordered by their best relay.
+func FormatGroupedTable(locations []relays.Location, opts Options) string {
+	return formatGrouped(locations, opts, nil)
+}
+
+// FormatGroupedCityTable formats the best relay of each city under country and city headers, with the number of
+// relays in the city
+func FormatGroupedCityTable(cities []relays.CityBest, opts Options) string {
+	best := make([]relays.Location, len(cities))
+	counts := make(map[[2]string]int, len(cities))
+	for i, city := range cities {
+		best[i] = city.Best
+		counts[[2]string{city.Best.Country, city.Best.City}] = city.Count
+	}
+	return formatGrouped(best, opts, counts)
+}
+
+// formatGrouped formats locations under country and city headers. City headers show the number of relays in the
+// city if counts are given.
+func formatGrouped(locations []relays.Location, opts Options, counts map[[2]string]int) string {
+	if len(locations) == 0 {
+		return ""
+	}
+
+	favorites := slices.ContainsFunc(locations, func(loc relays.Location) bool { return loc.Favorite })
+
+	// Align the relay columns across all groups
+	w := newTableWriter(groupedColumns(opts, favorites), opts.MaxWidths)
+	for _, loc := range locations {
+		w.fit(groupedRow(loc, opts, favorites))
+	}
+
+	var output strings.Builder
+	for _, country := range groupByCountryAndCity(locations) {
+		output.WriteString(country.country + "\n")
+		for _, city := range country.cities {
+			output.WriteString("    " + city.city)
+			if d := city.locations[0].DistanceFromMyLocation; d != nil {
+				output.WriteString(", " + formatDistance(d) + " km")
+			}
+			if count, ok := counts[[2]string{country.country, city.city}]; ok {
+				output.WriteString(", " + formatRelayCount(count))
+			}
+			output.WriteString("\n")
+
+			for _, loc := range city.locations {
+				row := w.format(groupedRow(loc, opts, favorites))
+				output.WriteString("        " + strings.TrimRight(row, " ") + "\n")
+			}
+		}
+	}
+	return output.String()
+}
+
+// groupedColumns returns the names of the columns of groupedRow, as the table headers of the same cells
+func groupedColumns(opts Options, favorites bool) []string {
+	var columns []string
+	if favorites {
+		columns = append(columns, "")
+	}
+	columns = append(columns, "Hostname")
+	columns = append(columns, opts.IPs.headers()...)
+	if opts.Features {
+		columns = append(columns, "Features")
+	}
+	columns = append(columns, "Latency")
+	if opts.IPv6 {
+		columns = append(columns, "IPv6 Latency")
+	}
+	return append(columns, "ASN")
+}
+
+// groupedRow returns the cells describing a relay under its city header
+func groupedRow(loc relays.Location, opts Options, favorites bool) []string {
+	var row []string
+	if favorites {
+		marker := ""
+		if loc.Favorite {
+			marker = favoriteMarker
+		}
+		row = append(row, marker)
+	}
+
+	row = append(row, loc.Hostname)
+	row = append(row, opts.IPs.cells(loc)...)
+	if opts.Features {
+		row = append(row, loc.Features.Flags())
+	}
+	row = append(row, formatLatencyWithUnit(loc.Latency, opts))
+	if opts.IPv6 {
+		ipv6 := ""
+		if loc.IPv6Address != "" {
+			ipv6 = "IPv6 " + formatLatencyWithUnit(loc.LatencyIPv6, opts)
+		}
+		row = append(row, ipv6)
+	}
+
+	if loc.ASN != 0 {
+		row = append(row, formatASN(loc))
+	}
+	return row
+}
+
+// groupByCountryAndCity groups locations by country, then by city, in the order they are first seen
+func groupByCountryAndCity(locations []relays.Location) []countryGroup {
+	var groups []countryGroup
+	countryIndex := make(map[string]int)
+	cityIndex := make(map[[2]string]int)
+
+	for _, loc := range locations {
+		ci, ok := countryIndex[loc.Country]
+		if !ok {
+			ci = len(groups)
+			countryIndex[loc.Country] = ci
+			groups = append(groups, countryGroup{country: loc.Country})
+		}
+
+		key := [2]string{loc.Country, loc.City}
+		ti, ok := cityIndex[key]
+		if !ok {
+			ti = len(groups[ci].cities)
+			cityIndex[key] = ti
+			groups[ci].cities = append(groups[ci].cities, cityGroup{city: loc.City})
+		}
+		groups[ci].cities[ti].locations = append(groups[ci].cities[ti].locations, loc)
+	}
+	return groups
+}
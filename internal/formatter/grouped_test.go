@@ -0,0 +1,74 @@
+package formatter
+
+import (
+	"testing"
+
+	"github.com/Ch00k/mullvad-compass/internal/relays"
+)
+
+func TestFormatGroupedTable(t *testing.T) {
+	latencies := []float64{9.78, 13.01, 15.86}
+	prague, berlin := 156.0, 238.0
+	locations := []relays.Location{
+		{
+			Country:                "Czech Republic",
+			City:                   "Prague",
+			Hostname:               "cz-prg-wg-201",
+			IPv4Address:            "178.249.209.162",
+			DistanceFromMyLocation: &prague,
+			Latency:                &latencies[0],
+		},
+		{
+			Country:                "Germany",
+			City:                   "Berlin",
+			Hostname:               "de-ber-wg-007",
+			IPv4Address:            "193.32.248.75",
+			DistanceFromMyLocation: &berlin,
+			Latency:                &latencies[2],
+			Favorite:               true,
+		},
+		{
+			Country:                "Czech Republic",
+			City:                   "Prague",
+			Hostname:               "cz-prg-wg-202",
+			IPv4Address:            "178.249.209.175",
+			DistanceFromMyLocation: &prague,
+			Latency:                &latencies[1],
+		},
+		{Country: "Germany", City: "Frankfurt", Hostname: "de-fra-wg-001", IPv4Address: "185.213.155.74"},
+	}
+
+	t.Run("Locations", func(t *testing.T) {
+		want := "Czech Republic\n" +
+			"    Prague, 156 km\n" +
+			"            cz-prg-wg-201   178.249.209.162   9.78 ms\n" +
+			"            cz-prg-wg-202   178.249.209.175   13.01 ms\n" +
+			"Germany\n" +
+			"    Berlin, 238 km\n" +
+			"        ★   de-ber-wg-007   193.32.248.75     15.86 ms\n" +
+			"    Frankfurt\n" +
+			"            de-fra-wg-001   185.213.155.74    timeout\n"
+		if got := FormatGroupedTable(locations, DefaultOptions()); got != want {
+			t.Errorf("FormatGroupedTable() =\n%s\nwant:\n%s", got, want)
+		}
+	})
+
+	t.Run("Cities", func(t *testing.T) {
+		cities := []relays.CityBest{{Best: locations[0], Count: 3}, {Best: locations[3], Count: 1}}
+		want := "Czech Republic\n" +
+			"    Prague, 156 km, 3 relays\n" +
+			"        cz-prg-wg-201   178.249.209.162   9.78 ms\n" +
+			"Germany\n" +
+			"    Frankfurt, 1 relay\n" +
+			"        de-fra-wg-001   185.213.155.74    timeout\n"
+		if got := FormatGroupedCityTable(cities, DefaultOptions()); got != want {
+			t.Errorf("FormatGroupedCityTable() =\n%s\nwant:\n%s", got, want)
+		}
+	})
+
+	t.Run("Empty", func(t *testing.T) {
+		if got := FormatGroupedTable(nil, DefaultOptions()); got != "" {
+			t.Errorf("Expected no output without locations, got %q", got)
+		}
+	})
+}
@@ -0,0 +1,76 @@
+package formatter
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Ch00k/mullvad-compass/internal/distance"
+	"github.com/Ch00k/mullvad-compass/internal/relays"
+)
+
+// FormatInspection lists the problems found in a relays file, one per line, followed by a summary of its contents
+func FormatInspection(path string, inspection relays.Inspection) string {
+	var output strings.Builder
+	for _, p := range inspection.Problems {
+		fmt.Fprintf(&output, "%s: %s\n", p.Severity, p.Message)
+	}
+	if len(inspection.Problems) > 0 {
+		output.WriteString("\n")
+	}
+
+	fmt.Fprintf(
+		&output,
+		"%s: %d locations, %d WireGuard relays, %d bridge relays (%d inactive)\n",
+		path,
+		inspection.Locations,
+		inspection.WireGuardRelays,
+		inspection.BridgeRelays,
+		inspection.InactiveRelays,
+	)
+
+	errorCount := inspection.Errors()
+	warnings := len(inspection.Problems) - errorCount
+	if errorCount == 0 && warnings == 0 {
+		output.WriteString("No problems found\n")
+	} else {
+		fmt.Fprintf(&output, "%s, %s\n", pluralize(errorCount, "error"), pluralize(warnings, "warning"))
+	}
+	return output.String()
+}
+
+// FormatCoordinateMismatches lists the relays placed far from the city their hostname names, one per line with the
+// cities of both locations, followed by their count
+func FormatCoordinateMismatches(
+	locations map[string]relays.LocationEntry,
+	mismatches []distance.CoordinateMismatch,
+) string {
+	if len(mismatches) == 0 {
+		return fmt.Sprintf("No relays are more than %.0f km from the city their hostname names\n",
+			distance.MismatchThreshold)
+	}
+
+	var output strings.Builder
+	for _, m := range mismatches {
+		fmt.Fprintf(
+			&output,
+			"%s is listed at %s (%s), %.0f km from %s (%s)\n",
+			m.Hostname,
+			m.Location,
+			locations[m.Location].City,
+			m.Distance,
+			m.Advertised,
+			locations[m.Advertised].City,
+		)
+	}
+	fmt.Fprintf(&output, "\n%s more than %.0f km from the city named by the hostname\n",
+		pluralize(len(mismatches), "relay"), distance.MismatchThreshold)
+	return output.String()
+}
+
+// pluralize formats a count with a noun, adding an "s" unless the count is 1
+func pluralize(count int, noun string) string {
+	if count == 1 {
+		return "1 " + noun
+	}
+	return fmt.Sprintf("%d %ss", count, noun)
+}
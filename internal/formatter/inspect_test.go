@@ -0,0 +1,56 @@
+package formatter
+
+import (
+	"testing"
+
+	"github.com/Ch00k/mullvad-compass/internal/distance"
+	"github.com/Ch00k/mullvad-compass/internal/relays"
+)
+
+func TestFormatInspection(t *testing.T) {
+	clean := relays.Inspection{Locations: 90, WireGuardRelays: 563, BridgeRelays: 15, InactiveRelays: 39}
+	want := "relays.json: 90 locations, 563 WireGuard relays, 15 bridge relays (39 inactive)\nNo problems found\n"
+	if got := FormatInspection("relays.json", clean); got != want {
+		t.Errorf("Clean file:\n got: %q\nwant: %q", got, want)
+	}
+
+	broken := relays.Inspection{
+		Locations:       1,
+		WireGuardRelays: 2,
+		Problems: []relays.Problem{
+			{Severity: relays.Warning, Message: "duplicate hostname se-got-wg-001 (also a WireGuard relay)"},
+			{Severity: relays.Error, Message: "missing locations section"},
+			{Severity: relays.Warning, Message: "relay se-got-wg-002 has no entry address"},
+		},
+	}
+	want = "warning: duplicate hostname se-got-wg-001 (also a WireGuard relay)\n" +
+		"error: missing locations section\n" +
+		"warning: relay se-got-wg-002 has no entry address\n" +
+		"\n" +
+		"dump.json: 1 locations, 2 WireGuard relays, 0 bridge relays (0 inactive)\n" +
+		"1 error, 2 warnings\n"
+	if got := FormatInspection("dump.json", broken); got != want {
+		t.Errorf("File with problems:\n got: %q\nwant: %q", got, want)
+	}
+}
+
+func TestFormatCoordinateMismatches(t *testing.T) {
+	want := "No relays are more than 100 km from the city their hostname names\n"
+	if got := FormatCoordinateMismatches(nil, nil); got != want {
+		t.Errorf("No mismatches:\n got: %q\nwant: %q", got, want)
+	}
+
+	locations := map[string]relays.LocationEntry{
+		"de-ber": {City: "Berlin", Country: "Germany"},
+		"de-fra": {City: "Frankfurt", Country: "Germany"},
+	}
+	mismatches := []distance.CoordinateMismatch{
+		{Hostname: "de-ber-wg-002", Location: "de-fra", Advertised: "de-ber", Distance: 423.6},
+	}
+	want = "de-ber-wg-002 is listed at de-fra (Frankfurt), 424 km from de-ber (Berlin)\n" +
+		"\n" +
+		"1 relay more than 100 km from the city named by the hostname\n"
+	if got := FormatCoordinateMismatches(locations, mismatches); got != want {
+		t.Errorf("One mismatch:\n got: %q\nwant: %q", got, want)
+	}
+}
@@ -0,0 +1,43 @@
+package formatter
+
+import "github.com/Ch00k/mullvad-compass/internal/relays"
+
+// IPColumns selects which relay addresses a table shows
+type IPColumns int
+
+const (
+	IPColumnsIPv4 IPColumns = iota // A single "IP" column with the IPv4 address
+	IPColumnsIPv6                  // A single "IP" column with the IPv6 address
+	IPColumnsBoth                  // "IPv4" and "IPv6" columns
+)
+
+// headers returns the headers of the address columns
+func (c IPColumns) headers() []string {
+	if c == IPColumnsBoth {
+		return []string{"IPv4", "IPv6"}
+	}
+	return []string{"IP"}
+}
+
+// cells returns the address cells of a location, empty for an address family the relay does not have
+func (c IPColumns) cells(loc relays.Location) []string {
+	switch c {
+	case IPColumnsBoth:
+		return []string{loc.IPv4Address, loc.IPv6Address}
+	case IPColumnsIPv6:
+		return []string{loc.IPv6Address}
+	default:
+		return []string{loc.IPv4Address}
+	}
+}
+
+// addresses returns the addresses of a location shown in the columns, leaving out missing ones
+func (c IPColumns) addresses(loc relays.Location) []string {
+	var addrs []string
+	for _, addr := range c.cells(loc) {
+		if addr != "" {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
@@ -0,0 +1,62 @@
+package formatter
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Options controls how servers are displayed
+type Options struct {
+	IPs      IPColumns     // Addresses shown
+	Latency  LatencyFormat // Unit and precision of latencies
+	Features bool          // Show the capabilities of each relay as compact flags
+	IPv6     bool          // Show the IPv6 latency of dual-stack pings next to the IPv4 one
+
+	// MaxWidths limits columns, by their ColumnName, to at most this many characters, cutting longer cells in the
+	// middle. Columns not listed are not limited.
+	MaxWidths map[string]int
+}
+
+// DefaultOptions returns the options showing IPv4 addresses and latencies in milliseconds with two decimals
+func DefaultOptions() Options {
+	return Options{IPs: IPColumnsIPv4, Latency: LatencyFormat{Decimals: 2}}
+}
+
+// LatencyFormat is the unit and precision latencies are displayed in
+type LatencyFormat struct {
+	Microseconds bool // Microseconds instead of milliseconds
+	Decimals     int  // Decimal places
+}
+
+// Unit returns the abbreviated unit of the format, "ms" or "µs"
+func (f LatencyFormat) Unit() string {
+	if f.Microseconds {
+		return "µs"
+	}
+	return "ms"
+}
+
+// Format formats a latency in milliseconds in the unit and precision of the format, or "timeout" for nil
+func (f LatencyFormat) Format(latency *float64) string {
+	if latency == nil {
+		return "timeout"
+	}
+	return strconv.FormatFloat(f.scale(*latency), 'f', f.Decimals, 64)
+}
+
+// formatRelative formats the difference between a latency and the reference latency with its sign,
+// e.g. "+12.34". Returns an empty string if either timed out.
+func (f LatencyFormat) formatRelative(latency, reference *float64) string {
+	if latency == nil || reference == nil {
+		return ""
+	}
+	return fmt.Sprintf("%+.*f", f.Decimals, f.scale(*latency-*reference))
+}
+
+// scale converts milliseconds to the unit of the format
+func (f LatencyFormat) scale(ms float64) float64 {
+	if f.Microseconds {
+		return ms * 1000
+	}
+	return ms
+}
@@ -0,0 +1,65 @@
+package formatter
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Ch00k/mullvad-compass/internal/relays"
+)
+
+func TestLatencyFormat(t *testing.T) {
+	tests := []struct {
+		name     string
+		format   LatencyFormat
+		latency  *float64
+		want     string
+		wantUnit string
+	}{
+		{"default", DefaultOptions().Latency, ptr(12.3456), "12.35", "ms"},
+		{"whole milliseconds", LatencyFormat{}, ptr(12.5678), "13", "ms"},
+		{"more decimals", LatencyFormat{Decimals: 4}, ptr(0.12345), "0.1235", "ms"},
+		{"microseconds", LatencyFormat{Microseconds: true}, ptr(0.4567), "457", "µs"},
+		{"microseconds with decimals", LatencyFormat{Microseconds: true, Decimals: 1}, ptr(1.23456), "1234.6", "µs"},
+		{"timeout", LatencyFormat{Decimals: 2}, nil, "timeout", "ms"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.format.Format(tt.latency); got != tt.want {
+				t.Errorf("Format() = %q, want %q", got, tt.want)
+			}
+			if got := tt.format.Unit(); got != tt.wantUnit {
+				t.Errorf("Unit() = %q, want %q", got, tt.wantUnit)
+			}
+		})
+	}
+}
+
+func TestFormatTableLatencyFormat(t *testing.T) {
+	locations := []relays.Location{
+		{Country: "Sweden", City: "Gothenburg", Hostname: "se-got-wg-001", IPv4Address: "185.213.154.66",
+			Latency: ptr(0.4123)},
+		{Country: "Sweden", City: "Gothenburg", Hostname: "se-got-wg-002", IPv4Address: "185.213.154.67",
+			Latency: ptr(0.4167)},
+	}
+	ref := Reference{Host: "192.168.1.1", Address: "192.168.1.1", Latency: ptr(0.2)}
+
+	opts := DefaultOptions()
+	opts.Latency = LatencyFormat{Microseconds: true}
+	lines := trimmedLines(FormatCalibratedTable(locations, opts, ref))
+
+	if !strings.Contains(lines[0], "Latency (µs)") || !strings.Contains(lines[0], "vs Reference (µs)") {
+		t.Errorf("Expected microsecond headers, got %q", lines[0])
+	}
+	for i, want := range []string{"412 +212", "417 +217"} {
+		fields := strings.Fields(lines[i+2])
+		if got := strings.Join(fields[len(fields)-2:], " "); got != want {
+			t.Errorf("Expected row %d to end in %q, got %q", i, want, got)
+		}
+	}
+
+	plain := FormatPlainList(locations[:1], opts)
+	if !strings.HasPrefix(plain, "se-got-wg-001: 412 µs,") {
+		t.Errorf("Expected a microsecond latency in the plain line, got %q", plain)
+	}
+}
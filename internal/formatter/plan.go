@@ -0,0 +1,35 @@
+package formatter
+
+import "github.com/Ch00k/mullvad-compass/internal/relays"
+
+// PlannedCity is a destination of the plan command with the relays nearest to it
+type PlannedCity struct {
+	Name   string            // Mullvad city the destination was matched to
+	Relays []relays.Location // Nearest first, with their distance from the city
+}
+
+// FormatPlan formats the relays nearest to each destination as a table, with their distance from the destination
+func FormatPlan(cities []PlannedCity, opts Options) string {
+	headers := []string{"Destination", "Country", "City", "Hostname"}
+	headers = append(headers, opts.IPs.headers()...)
+	if opts.Features {
+		headers = append(headers, "Features")
+	}
+	headers = append(headers, "Distance (km)")
+
+	var rows [][]string
+	for _, city := range cities {
+		for _, loc := range city.Relays {
+			row := []string{city.Name, loc.Country, loc.City, loc.Hostname}
+			row = append(row, opts.IPs.cells(loc)...)
+			if opts.Features {
+				row = append(row, loc.Features.Flags())
+			}
+			rows = append(rows, append(row, formatDistance(loc.DistanceFromMyLocation)))
+		}
+	}
+	if len(rows) == 0 {
+		return ""
+	}
+	return renderTable(headers, rows)
+}
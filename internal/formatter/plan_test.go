@@ -0,0 +1,44 @@
+package formatter
+
+import (
+	"testing"
+
+	"github.com/Ch00k/mullvad-compass/internal/relays"
+)
+
+func TestFormatPlan(t *testing.T) {
+	cities := []PlannedCity{
+		{Name: "Lisbon", Relays: []relays.Location{
+			{Country: "Portugal", City: "Lisbon", Hostname: "pt-lis-wg-201", IPv4Address: "149.88.20.206",
+				DistanceFromMyLocation: ptr(0.0)},
+			{Country: "Spain", City: "Madrid", Hostname: "es-mad-wg-101", IPv4Address: "45.134.213.194",
+				DistanceFromMyLocation: ptr(502.6)},
+		}},
+		{Name: "Tokyo", Relays: []relays.Location{
+			{Country: "Japan", City: "Tokyo", Hostname: "jp-tyo-wg-001", IPv4Address: "138.199.21.239",
+				DistanceFromMyLocation: ptr(0.0)},
+		}},
+	}
+
+	want := []string{
+		"Destination   Country    City     Hostname        IP               Distance (km)",
+		"-----------   --------   ------   -------------   --------------   -------------",
+		"Lisbon        Portugal   Lisbon   pt-lis-wg-201   149.88.20.206    0",
+		"Lisbon        Spain      Madrid   es-mad-wg-101   45.134.213.194   503",
+		"Tokyo         Japan      Tokyo    jp-tyo-wg-001   138.199.21.239   0",
+		"",
+	}
+	got := trimmedLines(FormatPlan(cities, DefaultOptions()))
+	if len(got) != len(want) {
+		t.Fatalf("Expected %d lines, got %d:\n%s", len(want), len(got), FormatPlan(cities, DefaultOptions()))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Line %d:\n got: %q\nwant: %q", i, got[i], want[i])
+		}
+	}
+
+	if FormatPlan(nil, DefaultOptions()) != "" {
+		t.Error("Expected no output without relays")
+	}
+}
@@ -0,0 +1,56 @@
+package formatter
+
+import (
+	"strings"
+
+	"github.com/Ch00k/mullvad-compass/internal/relays"
+)
+
+// countryDisplayNames maps ISO 3166-1 alpha-2 codes to display names for countries whose relays.json name is an
+// abbreviation or an older name
+var countryDisplayNames = map[string]string{
+	"ae": "United Arab Emirates",
+	"cz": "Czechia",
+	"gb": "United Kingdom",
+	"tr": "Türkiye",
+	"us": "United States",
+}
+
+// CountryFlag returns the flag emoji of an ISO 3166-1 alpha-2 country code, or an empty string for anything else
+func CountryFlag(code string) string {
+	code = strings.ToLower(code)
+	if len(code) != 2 {
+		return ""
+	}
+	var flag strings.Builder
+	for _, r := range code {
+		if r < 'a' || r > 'z' {
+			return ""
+		}
+		// Regional indicator symbols start at U+1F1E6 for "a"
+		flag.WriteRune(0x1F1E6 + r - 'a')
+	}
+	return flag.String()
+}
+
+// PrettyCountry returns the display name of the location's country, prefixed with its flag
+func PrettyCountry(loc relays.Location) string {
+	name := loc.Country
+	if display, ok := countryDisplayNames[strings.ToLower(loc.CountryCode)]; ok {
+		name = display
+	}
+	if flag := CountryFlag(loc.CountryCode); flag != "" {
+		return flag + " " + name
+	}
+	return name
+}
+
+// Prettify returns copies of the locations with their country replaced by PrettyCountry, for display only
+func Prettify(locations []relays.Location) []relays.Location {
+	pretty := make([]relays.Location, len(locations))
+	for i, loc := range locations {
+		loc.Country = PrettyCountry(loc)
+		pretty[i] = loc
+	}
+	return pretty
+}
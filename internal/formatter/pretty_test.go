@@ -0,0 +1,46 @@
+package formatter
+
+import (
+	"testing"
+
+	"github.com/Ch00k/mullvad-compass/internal/relays"
+)
+
+func TestCountryFlag(t *testing.T) {
+	tests := []struct {
+		code string
+		want string
+	}{
+		{"se", "🇸🇪"},
+		{"GB", "🇬🇧"},
+		{"", ""},
+		{"usa", ""},
+		{"1a", ""},
+	}
+	for _, tt := range tests {
+		if got := CountryFlag(tt.code); got != tt.want {
+			t.Errorf("CountryFlag(%q) = %q, want %q", tt.code, got, tt.want)
+		}
+	}
+}
+
+func TestPrettify(t *testing.T) {
+	locations := []relays.Location{
+		{Hostname: "se-got-wg-001", Country: "Sweden", CountryCode: "se"},
+		{Hostname: "us-nyc-wg-001", Country: "USA", CountryCode: "us"},
+		{Hostname: "gb-lon-wg-001", Country: "UK", CountryCode: "gb"},
+		{Hostname: "xx-unknown", Country: "Atlantis"},
+	}
+
+	pretty := Prettify(locations)
+
+	want := []string{"🇸🇪 Sweden", "🇺🇸 United States", "🇬🇧 United Kingdom", "Atlantis"}
+	for i, country := range want {
+		if pretty[i].Country != country {
+			t.Errorf("Location %d: expected %q, got %q", i, country, pretty[i].Country)
+		}
+	}
+	if locations[1].Country != "USA" {
+		t.Errorf("Expected the input to be left unchanged, got %q", locations[1].Country)
+	}
+}
@@ -0,0 +1,59 @@
+package formatter
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/Ch00k/mullvad-compass/internal/relays"
+)
+
+// Reference is a host pinged alongside the relays with --calibrate. Latencies relative to it factor out the
+// congestion of the user's own network at the time of measurement.
+type Reference struct {
+	Host    string
+	Address string
+	Latency *float64
+}
+
+// FormatCalibratedTable formats locations as a table like FormatTable, with their latency relative to the reference
+func FormatCalibratedTable(locations []relays.Location, opts Options, ref Reference) string {
+	return formatTable(locations, opts, &ref)
+}
+
+// FormatCalibratedCityTable formats the best relay of each city like FormatCityTable, with their latency relative
+// to the reference
+func FormatCalibratedCityTable(cities []relays.CityBest, opts Options, ref Reference) string {
+	return formatCityTable(cities, opts, &ref)
+}
+
+// withRelativeColumn appends a column with the latency of each relay minus the latency of the reference, if there
+// is a reference
+func withRelativeColumn(
+	headers []string,
+	rows [][]string,
+	locations []relays.Location,
+	ref *Reference,
+	latencyFormat LatencyFormat,
+) ([]string, [][]string) {
+	if ref == nil {
+		return headers, rows
+	}
+
+	annotated := make([][]string, len(rows))
+	for i, row := range rows {
+		annotated[i] = append(slices.Clone(row), latencyFormat.formatRelative(locations[i].Latency, ref.Latency))
+	}
+	return append(slices.Clone(headers), "vs Reference ("+latencyFormat.Unit()+")"), annotated
+}
+
+// FormatReference formats the latency of the reference host, e.g. "Reference: router.lan (192.168.1.1), 1.23 ms"
+func FormatReference(ref Reference) string {
+	latency := "timeout"
+	if ref.Latency != nil {
+		latency = fmt.Sprintf("%.2f ms", *ref.Latency)
+	}
+	if ref.Host == ref.Address {
+		return fmt.Sprintf("Reference: %s, %s\n", ref.Address, latency)
+	}
+	return fmt.Sprintf("Reference: %s (%s), %s\n", ref.Host, ref.Address, latency)
+}
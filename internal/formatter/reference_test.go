@@ -0,0 +1,81 @@
+package formatter
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Ch00k/mullvad-compass/internal/relays"
+)
+
+// trimmedLines splits a table into lines without the padding of their last column
+func trimmedLines(table string) []string {
+	lines := strings.Split(table, "\n")
+	for i := range lines {
+		lines[i] = strings.TrimRight(lines[i], " ")
+	}
+	return lines
+}
+
+func TestFormatCalibratedTable(t *testing.T) {
+	latency := 25.5
+	locations := []relays.Location{
+		{
+			Country:     "Germany",
+			City:        "Berlin",
+			IPv4Address: "193.32.248.75",
+			Hostname:    "de-ber-wg-007",
+			Latency:     &latency,
+		},
+		{Country: "Germany", City: "Frankfurt", IPv4Address: "185.213.155.74", Hostname: "de-fra-wg-001"},
+	}
+	refLatency := 4.25
+	ref := Reference{Host: "1.1.1.1", Address: "1.1.1.1", Latency: &refLatency}
+
+	lines := trimmedLines(FormatCalibratedTable(locations, DefaultOptions(), ref))
+	if !strings.HasSuffix(lines[0], "vs Reference (ms)") {
+		t.Errorf("Expected a relative latency column, got:\n%s", lines[0])
+	}
+	if !strings.HasSuffix(lines[2], "25.50          +21.25") {
+		t.Errorf("Expected the latency relative to the reference, got:\n%s", lines[2])
+	}
+	if !strings.HasSuffix(lines[3], "timeout") {
+		t.Errorf("Expected no relative latency for a timeout, got:\n%s", lines[3])
+	}
+
+	ref.Latency = nil
+	lines = trimmedLines(FormatCalibratedTable(locations, DefaultOptions(), ref))
+	if !strings.HasSuffix(lines[2], "25.50") {
+		t.Errorf("Expected no relative latency without a reference latency, got:\n%s", lines[2])
+	}
+
+	cities := []relays.CityBest{{Best: locations[0], Count: 3}}
+	lines = trimmedLines(FormatCalibratedCityTable(cities, DefaultOptions(), Reference{Latency: &refLatency}))
+	if !strings.HasSuffix(lines[0], "vs Reference (ms)   Relays") ||
+		!strings.HasSuffix(lines[2], "+21.25              3 relays") {
+		t.Errorf("Expected the relative latency before the relay count, got:\n%s\n%s", lines[0], lines[2])
+	}
+}
+
+func TestFormatReference(t *testing.T) {
+	latency := 1.234
+	tests := []struct {
+		name string
+		ref  Reference
+		want string
+	}{
+		{"Address", Reference{Host: "1.1.1.1", Address: "1.1.1.1", Latency: &latency}, "Reference: 1.1.1.1, 1.23 ms\n"},
+		{
+			"Hostname",
+			Reference{Host: "router.lan", Address: "192.168.1.1", Latency: &latency},
+			"Reference: router.lan (192.168.1.1), 1.23 ms\n",
+		},
+		{"Timeout", Reference{Host: "1.1.1.1", Address: "1.1.1.1"}, "Reference: 1.1.1.1, timeout\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := FormatReference(tt.ref); got != tt.want {
+				t.Errorf("FormatReference() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
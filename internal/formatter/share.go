@@ -0,0 +1,222 @@
+package formatter
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/Ch00k/mullvad-compass/internal/api"
+	"github.com/Ch00k/mullvad-compass/internal/buildinfo"
+	"github.com/Ch00k/mullvad-compass/internal/ping"
+	"github.com/Ch00k/mullvad-compass/internal/relays"
+	"github.com/Ch00k/mullvad-compass/internal/timing"
+	"github.com/Ch00k/mullvad-compass/internal/warnings"
+)
+
+// Share report formats
+const (
+	ShareMarkdown = "markdown"
+	ShareJSON     = "json"
+)
+
+// Redaction precision of shared reports. Rounding distances as well as coordinates keeps the user's
+// position from being recovered by trilateration from several relays.
+const (
+	shareCoordinateDecimals = 1  // About 11 km
+	shareDistanceStep       = 10 // km
+)
+
+// ShareReport is an anonymized report of a run, suitable for posting publicly
+type ShareReport struct {
+	Version          string               `json:"version"`
+	Build            *buildinfo.Info      `json:"build,omitempty"` // The build of the binary, for bug reports
+	Location         ShareLocation        `json:"location"`
+	IPVersion        string               `json:"ip_version"`
+	RankedByDistance bool                 `json:"ranked_by_distance"`
+	Runs             int                  `json:"runs,omitempty"`        // Ping runs combined, if more than one
+	Aggregation      string               `json:"aggregation,omitempty"` // How the runs are combined, e.g. "median"
+	Servers          []ShareServer        `json:"servers"`
+	Summary          Summary              `json:"summary"`
+	Counts           *Counts              `json:"counts,omitempty"`             // Set in Table Mode
+	Timings          *timing.Report       `json:"timings,omitempty"`            // Set with --timings
+	HostIPv6         *bool                `json:"host_ipv6,omitempty"`          // Whether IPv6 is reachable, if checked
+	Warnings         []warnings.Warning   `json:"warnings,omitempty"`           // Non-fatal problems of the run
+	TimedOutPrefixes []ping.PrefixTimeout `json:"timed_out_prefixes,omitempty"` // Prefixes where every server timed out
+}
+
+// ShareLocation is the user's location with the IP address removed and coordinates rounded
+type ShareLocation struct {
+	City      string  `json:"city"`
+	Country   string  `json:"country"`
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// ShareServer is a ranked server in a shared report
+type ShareServer struct {
+	Hostname string   `json:"hostname"`
+	Country  string   `json:"country"`
+	City     string   `json:"city"`
+	IP       string   `json:"ip"`
+	Latency  *float64 `json:"latency_ms"`  // nil indicates timeout
+	Distance *float64 `json:"distance_km"` // Rounded, nil when unknown
+}
+
+// NewShareReport builds a report of ranked locations and applies the redaction pass
+func NewShareReport(
+	version string,
+	userLoc api.UserLocation,
+	locations []relays.Location,
+	useIPv6 bool,
+	rankedByDistance bool,
+) ShareReport {
+	ipVersion := relays.IPv4
+	if useIPv6 {
+		ipVersion = relays.IPv6
+	}
+
+	report := ShareReport{
+		Version: version,
+		Location: ShareLocation{
+			City:      userLoc.City,
+			Country:   userLoc.Country,
+			Latitude:  userLoc.Latitude,
+			Longitude: userLoc.Longitude,
+		},
+		IPVersion:        ipVersion.String(),
+		RankedByDistance: rankedByDistance,
+		Servers:          make([]ShareServer, len(locations)),
+		Summary:          Summarize(locations),
+	}
+
+	for i, loc := range locations {
+		ip := loc.IPv4Address
+		if useIPv6 {
+			ip = loc.IPv6Address
+		}
+		report.Servers[i] = ShareServer{
+			Hostname: loc.Hostname,
+			Country:  loc.Country,
+			City:     loc.City,
+			IP:       ip,
+			Latency:  loc.Latency,
+			Distance: loc.DistanceFromMyLocation,
+		}
+	}
+
+	return redact(report, userLoc.IP)
+}
+
+// redact rounds coordinates and distances, and scrubs the user's IP address from every text field
+func redact(report ShareReport, userIP string) ShareReport {
+	scale := math.Pow(10, shareCoordinateDecimals)
+	report.Location.Latitude = math.Round(report.Location.Latitude*scale) / scale
+	report.Location.Longitude = math.Round(report.Location.Longitude*scale) / scale
+
+	scrub := func(s string) string {
+		if userIP == "" {
+			return s
+		}
+		return strings.ReplaceAll(s, userIP, "[redacted]")
+	}
+	report.Location.City = scrub(report.Location.City)
+	report.Location.Country = scrub(report.Location.Country)
+
+	servers := make([]ShareServer, len(report.Servers))
+	for i, s := range report.Servers {
+		if s.Distance != nil {
+			rounded := math.Round(*s.Distance/shareDistanceStep) * shareDistanceStep
+			s.Distance = &rounded
+		}
+		s.Hostname = scrub(s.Hostname)
+		s.IP = scrub(s.IP)
+		servers[i] = s
+	}
+	report.Servers = servers
+
+	return report
+}
+
+// FormatShareReport renders a shared report as markdown or indented JSON
+func FormatShareReport(report ShareReport, format string) (string, error) {
+	switch format {
+	case ShareJSON:
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return "", err
+		}
+		return string(data) + "\n", nil
+	case ShareMarkdown:
+		return formatShareMarkdown(report), nil
+	default:
+		return "", fmt.Errorf("invalid share format: %s (must be '%s' or '%s')", format, ShareMarkdown, ShareJSON)
+	}
+}
+
+// formatBuild formats the commit, Go version and platform of a build on one line
+func formatBuild(build buildinfo.Info) string {
+	parts := make([]string, 0, 3)
+	if build.Commit != "" {
+		commit := build.Commit
+		if build.Modified {
+			commit += " (modified)"
+		}
+		parts = append(parts, commit)
+	}
+	parts = append(parts, build.GoVersion, build.Platform)
+	return strings.Join(parts, ", ")
+}
+
+// formatShareMarkdown renders a shared report as a markdown list and table
+func formatShareMarkdown(report ShareReport) string {
+	var output strings.Builder
+
+	rankedBy := "latency"
+	if report.RankedByDistance {
+		rankedBy = "distance (no server responded to ping)"
+	}
+
+	output.WriteString("### mullvad-compass results\n\n")
+	fmt.Fprintf(&output, "- Version: %s\n", report.Version)
+	if report.Build != nil {
+		fmt.Fprintf(&output, "- Build: %s\n", formatBuild(*report.Build))
+	}
+	fmt.Fprintf(
+		&output,
+		"- Location: %s, %s (%.1f, %.1f), IP redacted\n",
+		report.Location.City,
+		report.Location.Country,
+		report.Location.Latitude,
+		report.Location.Longitude,
+	)
+	fmt.Fprintf(&output, "- IP version: %s\n", report.IPVersion)
+	fmt.Fprintf(&output, "- Ranked by: %s\n", rankedBy)
+	if report.Runs > 1 {
+		fmt.Fprintf(&output, "- Runs: %d (%s latency)\n", report.Runs, report.Aggregation)
+	}
+	for _, w := range report.Warnings {
+		fmt.Fprintf(&output, "- Warning: %s\n", w.Message)
+	}
+	output.WriteString("\n")
+
+	output.WriteString("| Country | City | Distance (km) | Hostname | IP | Latency (ms) |\n")
+	output.WriteString("|---|---|---|---|---|---|\n")
+	for _, s := range report.Servers {
+		fmt.Fprintf(
+			&output,
+			"| %s | %s | %s | %s | %s | %s |\n",
+			s.Country,
+			s.City,
+			formatDistance(s.Distance),
+			s.Hostname,
+			s.IP,
+			formatLatency(s.Latency),
+		)
+	}
+
+	output.WriteString("\n")
+	output.WriteString(FormatSummary(report.Summary))
+
+	return output.String()
+}
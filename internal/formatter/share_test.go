@@ -0,0 +1,203 @@
+package formatter
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/Ch00k/mullvad-compass/internal/api"
+	"github.com/Ch00k/mullvad-compass/internal/buildinfo"
+	"github.com/Ch00k/mullvad-compass/internal/relays"
+	"github.com/Ch00k/mullvad-compass/internal/warnings"
+)
+
+func shareFixture() (api.UserLocation, []relays.Location) {
+	userLoc := api.UserLocation{
+		IP:        "203.0.113.7",
+		Latitude:  52.5234,
+		Longitude: 13.4114,
+		City:      "Berlin",
+		Country:   "Germany",
+	}
+	latency := 10.5
+	near := 4.2
+	far := 286.7
+	locations := []relays.Location{
+		{
+			Hostname:               "de-ber-wg-001",
+			Country:                "Germany",
+			City:                   "Berlin",
+			IPv4Address:            "193.32.248.66",
+			IPv6Address:            "2a03:1b20:8:f011::a01f",
+			Latency:                &latency,
+			DistanceFromMyLocation: &near,
+		},
+		{
+			Hostname:               "pl-waw-wg-001",
+			Country:                "Poland",
+			City:                   "Warsaw",
+			IPv4Address:            "45.128.38.226",
+			DistanceFromMyLocation: &far,
+		},
+	}
+	return userLoc, locations
+}
+
+func TestNewShareReport(t *testing.T) {
+	userLoc, locations := shareFixture()
+	report := NewShareReport("1.2.3", userLoc, locations, false, false)
+
+	if report.Location.Latitude != 52.5 || report.Location.Longitude != 13.4 {
+		t.Errorf("Expected coordinates rounded to (52.5, 13.4), got (%v, %v)",
+			report.Location.Latitude, report.Location.Longitude)
+	}
+	if len(report.Servers) != 2 {
+		t.Fatalf("Expected 2 servers, got %d", len(report.Servers))
+	}
+	if d := report.Servers[0].Distance; d == nil || *d != 0 {
+		t.Errorf("Expected distance rounded to 0, got %v", d)
+	}
+	if d := report.Servers[1].Distance; d == nil || *d != 290 {
+		t.Errorf("Expected distance rounded to 290, got %v", d)
+	}
+	if report.Servers[0].IP != "193.32.248.66" {
+		t.Errorf("Expected relay IPv4 address, got %s", report.Servers[0].IP)
+	}
+	if *locations[1].DistanceFromMyLocation != 286.7 {
+		t.Error("Expected the redaction pass to leave the input locations untouched")
+	}
+	if report.Summary.Count != 2 || report.Summary.BestHostname != "de-ber-wg-001" {
+		t.Errorf("Unexpected summary: %+v", report.Summary)
+	}
+
+	t.Run("IPv6", func(t *testing.T) {
+		report := NewShareReport("1.2.3", userLoc, locations, true, false)
+		if report.IPVersion != "ipv6" || report.Servers[0].IP != "2a03:1b20:8:f011::a01f" {
+			t.Errorf("Expected IPv6 report, got %s with %s", report.IPVersion, report.Servers[0].IP)
+		}
+	})
+
+	t.Run("User IP is scrubbed from text fields", func(t *testing.T) {
+		loc := userLoc
+		loc.City = "203.0.113.7"
+		report := NewShareReport("1.2.3", loc, locations, false, false)
+		if report.Location.City != "[redacted]" {
+			t.Errorf("Expected redacted city, got %q", report.Location.City)
+		}
+	})
+}
+
+func TestFormatShareReport(t *testing.T) {
+	userLoc, locations := shareFixture()
+	report := NewShareReport("1.2.3", userLoc, locations, false, true)
+
+	t.Run("Markdown", func(t *testing.T) {
+		output, err := FormatShareReport(report, ShareMarkdown)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		for _, want := range []string{
+			"- Location: Berlin, Germany (52.5, 13.4), IP redacted",
+			"- Ranked by: distance",
+			"| Germany | Berlin | 0 | de-ber-wg-001 | 193.32.248.66 | 10.50 |",
+			"| Poland | Warsaw | 290 | pl-waw-wg-001 | 45.128.38.226 | timeout |",
+			"2 servers, 50% reachable",
+		} {
+			if !strings.Contains(output, want) {
+				t.Errorf("Expected markdown to contain %q, got:\n%s", want, output)
+			}
+		}
+		if strings.Contains(output, userLoc.IP) || strings.Contains(output, "52.52") {
+			t.Errorf("Expected user IP and precise coordinates to be redacted, got:\n%s", output)
+		}
+	})
+
+	t.Run("JSON", func(t *testing.T) {
+		output, err := FormatShareReport(report, ShareJSON)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if strings.Contains(output, userLoc.IP) {
+			t.Errorf("Expected user IP to be redacted, got:\n%s", output)
+		}
+		var decoded ShareReport
+		if err := json.Unmarshal([]byte(output), &decoded); err != nil {
+			t.Fatalf("Expected valid JSON, got %v", err)
+		}
+		if !decoded.RankedByDistance || len(decoded.Servers) != 2 || decoded.Servers[1].Latency != nil {
+			t.Errorf("Unexpected decoded report: %+v", decoded)
+		}
+	})
+
+	t.Run("Warnings", func(t *testing.T) {
+		report := report
+		report.Warnings = []warnings.Warning{
+			{Kind: warnings.SkippedRelays, Message: "2 relay(s) skipped due to missing coordinates"},
+		}
+
+		output, err := FormatShareReport(report, ShareMarkdown)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if !strings.Contains(output, "- Warning: 2 relay(s) skipped due to missing coordinates\n\n|") {
+			t.Errorf("Expected the warning before the table, got:\n%s", output)
+		}
+
+		output, err = FormatShareReport(report, ShareJSON)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if !strings.Contains(output, `"kind": "skipped_relays"`) {
+			t.Errorf("Expected a warnings array, got:\n%s", output)
+		}
+	})
+
+	t.Run("Build", func(t *testing.T) {
+		report := report
+		report.Build = &buildinfo.Info{
+			Version:   "1.2.3",
+			Commit:    "0123456789abcdef",
+			Modified:  true,
+			GoVersion: "go1.24.2",
+			Platform:  "linux/amd64",
+		}
+
+		output, err := FormatShareReport(report, ShareMarkdown)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if !strings.Contains(output, "- Build: 0123456789abcdef (modified), go1.24.2, linux/amd64\n") {
+			t.Errorf("Expected the build line, got:\n%s", output)
+		}
+
+		output, err = FormatShareReport(report, ShareJSON)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if !strings.Contains(output, `"go_version": "go1.24.2"`) ||
+			!strings.Contains(output, `"platform": "linux/amd64"`) {
+			t.Errorf("Expected the build metadata, got:\n%s", output)
+		}
+	})
+
+	t.Run("Runs", func(t *testing.T) {
+		report := report
+		report.Runs = 3
+		report.Aggregation = "median"
+
+		output, err := FormatShareReport(report, ShareMarkdown)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if !strings.Contains(output, "- Runs: 3 (median latency)\n") {
+			t.Errorf("Expected the runs line, got:\n%s", output)
+		}
+	})
+
+	t.Run("Invalid format", func(t *testing.T) {
+		_, err := FormatShareReport(report, "html")
+		if err == nil || !strings.Contains(err.Error(), "invalid share format") {
+			t.Errorf("Expected invalid format error, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,93 @@
+package formatter
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// ellipsis stands in for the middle of a cell cut to the maximum width of its column
+const ellipsis = "…"
+
+// columnSeparator separates the columns of tables and grouped layouts
+const columnSeparator = "   "
+
+// tableWriter aligns rows of cells in columns. A column with a maximum width has its longer cells cut in the
+// middle, keeping their start and end, which is what tells IPv6 addresses and operator names apart.
+type tableWriter struct {
+	limits []int // Maximum width of each column, 0 for no limit
+	widths []int // Width of each column, grown by fit
+}
+
+// newTableWriter returns a writer for columns with the given names, limited to the widths in maxWidths by name
+func newTableWriter(columns []string, maxWidths map[string]int) *tableWriter {
+	w := &tableWriter{limits: make([]int, len(columns)), widths: make([]int, len(columns))}
+	for i, column := range columns {
+		w.limits[i] = maxWidths[ColumnName(column)]
+	}
+	return w
+}
+
+// ColumnName returns the name a table header is limited by in Options.MaxWidths: lowercase and without a unit, e.g.
+// "latency" for "Latency (ms)"
+func ColumnName(header string) string {
+	name, _, _ := strings.Cut(header, " (")
+	return strings.ToLower(name)
+}
+
+// fit widens the columns to hold the cells of a row, as they are shown
+func (w *tableWriter) fit(row []string) {
+	for i, cell := range row {
+		w.grow(i)
+		w.widths[i] = max(w.widths[i], utf8.RuneCountInString(w.cell(i, cell)))
+	}
+}
+
+// format returns a row with its cells cut to their column's maximum width and padded to its width. Rows may hold
+// more cells than there are named columns; those are not limited.
+func (w *tableWriter) format(row []string) string {
+	parts := make([]string, len(row))
+	for i, cell := range row {
+		w.grow(i)
+		parts[i] = padRight(w.cell(i, cell), w.widths[i])
+	}
+	return strings.Join(parts, columnSeparator)
+}
+
+// separator returns a row of dashes as wide as each column
+func (w *tableWriter) separator() string {
+	parts := make([]string, len(w.widths))
+	for i, width := range w.widths {
+		parts[i] = strings.Repeat("-", width)
+	}
+	return strings.Join(parts, columnSeparator)
+}
+
+// cell returns a cell cut to the maximum width of column i
+func (w *tableWriter) cell(i int, s string) string {
+	if i < len(w.limits) && w.limits[i] > 0 {
+		return truncateMiddle(s, w.limits[i])
+	}
+	return s
+}
+
+// grow adds unlimited columns up to column i
+func (w *tableWriter) grow(i int) {
+	for len(w.widths) <= i {
+		w.widths = append(w.widths, 0)
+	}
+}
+
+// truncateMiddle cuts a string longer than width runes to width, replacing its middle with an ellipsis, e.g.
+// "2a03:1b2…1::a01f" for an IPv6 address. Strings that fit are returned unchanged.
+func truncateMiddle(s string, width int) string {
+	runes := []rune(s)
+	if len(runes) <= width {
+		return s
+	}
+	if width < 3 {
+		return string(runes[:width])
+	}
+	tail := (width - 1) / 2
+	head := width - 1 - tail
+	return string(runes[:head]) + ellipsis + string(runes[len(runes)-tail:])
+}
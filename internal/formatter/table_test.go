@@ -0,0 +1,75 @@
+package formatter
+
+import (
+	"testing"
+
+	"github.com/Ch00k/mullvad-compass/internal/relays"
+)
+
+func TestTruncateMiddle(t *testing.T) {
+	tests := []struct {
+		input string
+		width int
+		want  string
+	}{
+		{"de-ber-wg-001", 20, "de-ber-wg-001"},
+		{"de-ber-wg-001", 13, "de-ber-wg-001"},
+		{"2a03:1b20:3:f011::a01f", 16, "2a03:1b2…1::a01f"},
+		{"31173 Services AB", 10, "31173…s AB"},
+		{"Zürich", 5, "Zü…ch"},
+		{"Zürich", 2, "Zü"},
+	}
+	for _, tt := range tests {
+		if got := truncateMiddle(tt.input, tt.width); got != tt.want {
+			t.Errorf("truncateMiddle(%q, %d) = %q, want %q", tt.input, tt.width, got, tt.want)
+		}
+	}
+}
+
+func TestColumnName(t *testing.T) {
+	for header, want := range map[string]string{
+		"Hostname":          "hostname",
+		"IPv6":              "ipv6",
+		"Latency (ms)":      "latency",
+		"IPv4 Latency (µs)": "ipv4 latency",
+		"":                  "",
+	} {
+		if got := ColumnName(header); got != want {
+			t.Errorf("ColumnName(%q) = %q, want %q", header, got, want)
+		}
+	}
+}
+
+func TestFormatTableMaxWidths(t *testing.T) {
+	latency := 12.5
+	locations := []relays.Location{
+		{
+			Country:         "Switzerland",
+			City:            "Zurich",
+			Hostname:        "ch-zrh-wg-001",
+			IPv6Address:     "2a03:1b20:3:f011::a01f",
+			Latency:         &latency,
+			ASN:             39351,
+			ASNOrganization: "31173 Services AB",
+		},
+	}
+	opts := DefaultOptions()
+	opts.IPs = IPColumnsIPv6
+	opts.MaxWidths = map[string]int{"ip": 16, "asn": 12, "country": 6}
+
+	want := "" +
+		"Cou…ry   City     Distance (km)   Hostname        IP                 Latency (ms)   ASN         \n" +
+		"------   ------   -------------   -------------   ----------------   ------------   ------------\n" +
+		"Swi…nd   Zurich                   ch-zrh-wg-001   2a03:1b2…1::a01f   12.50          AS3935…es AB\n"
+	if got := FormatTable(locations, opts); got != want {
+		t.Errorf("Table:\n got: %q\nwant: %q", got, want)
+	}
+
+	want = "" +
+		"Switzerland\n" +
+		"    Zurich\n" +
+		"        ch-zrh-wg-001   2a03:1b2…1::a01f   12.50 ms   AS3935…es AB\n"
+	if got := FormatGroupedTable(locations, opts); got != want {
+		t.Errorf("Grouped:\n got: %q\nwant: %q", got, want)
+	}
+}
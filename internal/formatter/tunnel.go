@@ -0,0 +1,32 @@
+package formatter
+
+import (
+	"fmt"
+
+	"github.com/Ch00k/mullvad-compass/internal/api"
+	"github.com/Ch00k/mullvad-compass/internal/tunnel"
+)
+
+// FormatTunnel formats the relay the user is connected through, followed by a table of the in-tunnel latency to
+// each destination, split into the time to the relay and onward from it
+func FormatTunnel(loc api.UserLocation, results []tunnel.Result) string {
+	header := fmt.Sprintf("Connected via:   %s (%s, %s)\n\n", loc.MullvadExitIPHostname, loc.City, loc.Country)
+
+	headers := []string{"Destination", "Relay (ms)", "Onward (ms)", "Total (ms)"}
+	rows := make([][]string, len(results))
+	for i, result := range results {
+		if result.Total == nil {
+			rows[i] = []string{result.Destination, "failed", "", ""}
+			continue
+		}
+		onward := *result.Total - *result.Relay
+		rows[i] = []string{
+			result.Destination,
+			formatLatency(result.Relay),
+			formatLatency(&onward),
+			formatLatency(result.Total),
+		}
+	}
+
+	return header + renderTable(headers, rows)
+}
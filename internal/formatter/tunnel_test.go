@@ -0,0 +1,26 @@
+package formatter
+
+import (
+	"testing"
+
+	"github.com/Ch00k/mullvad-compass/internal/api"
+	"github.com/Ch00k/mullvad-compass/internal/tunnel"
+)
+
+func TestFormatTunnel(t *testing.T) {
+	relay, total := 12.5, 20.25
+	loc := api.UserLocation{City: "Stockholm", Country: "Sweden", MullvadExitIPHostname: "se-sto-wg-001"}
+	results := []tunnel.Result{
+		{Destination: "1.1.1.1:443", Relay: &relay, Total: &total},
+		{Destination: "example.com:443"},
+	}
+
+	want := "Connected via:   se-sto-wg-001 (Stockholm, Sweden)\n\n" +
+		"Destination       Relay (ms)   Onward (ms)   Total (ms)\n" +
+		"---------------   ----------   -----------   ----------\n" +
+		"1.1.1.1:443       12.50        7.75          20.25     \n" +
+		"example.com:443   failed                               \n"
+	if got := FormatTunnel(loc, results); got != want {
+		t.Errorf("FormatTunnel() =\n%q\nwant:\n%q", got, want)
+	}
+}
@@ -0,0 +1,92 @@
+// Package history stores the results of scheduled runs.
+package history
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Ch00k/mullvad-compass/internal/hooks"
+)
+
+// File is the name of the history store in the config directory
+const File = "history.jsonl"
+
+// Run is the result of a single run, stored as one line of JSON
+type Run struct {
+	Time    time.Time      `json:"time"`
+	Servers []hooks.Server `json:"servers"` // Best first, as ranked
+}
+
+// Load reads the runs in the store, oldest first. A missing store holds no runs.
+func Load(path string) ([]Run, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	var runs []Run
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, len(data)+1)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var run Run
+		if err := json.Unmarshal(scanner.Bytes(), &run); err != nil {
+			return nil, fmt.Errorf("failed to parse %s line %d: %w", path, line, err)
+		}
+		runs = append(runs, run)
+	}
+	return runs, nil
+}
+
+// Append adds a run to the store and drops the runs recorded more than retain before it, creating the store's
+// directory if needed. Zero retain keeps every run.
+func Append(path string, run Run, retain time.Duration) error {
+	runs, err := Load(path)
+	if err != nil {
+		return err
+	}
+	if retain > 0 {
+		runs = recordedSince(runs, run.Time.Add(-retain))
+	}
+	runs = append(runs, run)
+
+	var data bytes.Buffer
+	for _, r := range runs {
+		line, err := json.Marshal(r)
+		if err != nil {
+			return fmt.Errorf("failed to encode run: %w", err)
+		}
+		data.Write(line)
+		data.WriteByte('\n')
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("failed to create directory for %s: %w", path, err)
+	}
+	if err := os.WriteFile(path, data.Bytes(), 0o644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
+
+// recordedSince returns the runs recorded at or after cutoff
+func recordedSince(runs []Run, cutoff time.Time) []Run {
+	kept := runs[:0]
+	for _, run := range runs {
+		if !run.Time.Before(cutoff) {
+			kept = append(kept, run)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,84 @@
+package history
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Ch00k/mullvad-compass/internal/hooks"
+)
+
+func TestAppendLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mullvad-compass", File)
+
+	runs, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() of a missing store failed: %v", err)
+	}
+	if len(runs) != 0 {
+		t.Fatalf("Expected no runs in a missing store, got %d", len(runs))
+	}
+
+	latency := 12.5
+	start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	for i := range 3 {
+		run := Run{
+			Time:    start.Add(time.Duration(i) * time.Hour),
+			Servers: []hooks.Server{{Hostname: "se-got-wg-001", Latency: &latency}, {Hostname: "se-got-wg-002"}},
+		}
+		if err := Append(path, run, 0); err != nil {
+			t.Fatalf("Append() failed: %v", err)
+		}
+	}
+
+	runs, err = Load(path)
+	if err != nil {
+		t.Fatalf("Load() failed: %v", err)
+	}
+	if len(runs) != 3 {
+		t.Fatalf("Expected 3 runs, got %d", len(runs))
+	}
+	if !runs[2].Time.Equal(start.Add(2 * time.Hour)) {
+		t.Errorf("Expected the latest run last, got %v", runs[2].Time)
+	}
+	if got := runs[0].Servers; len(got) != 2 || got[0].Latency == nil || *got[0].Latency != latency ||
+		got[1].Latency != nil {
+		t.Errorf("Servers were not stored as recorded: %+v", got)
+	}
+}
+
+func TestAppendRetain(t *testing.T) {
+	path := filepath.Join(t.TempDir(), File)
+
+	start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	for _, offset := range []time.Duration{0, 12 * time.Hour, 24 * time.Hour, 36 * time.Hour} {
+		if err := Append(path, Run{Time: start.Add(offset)}, 24*time.Hour); err != nil {
+			t.Fatalf("Append() failed: %v", err)
+		}
+	}
+
+	runs, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() failed: %v", err)
+	}
+	want := []time.Time{start.Add(12 * time.Hour), start.Add(24 * time.Hour), start.Add(36 * time.Hour)}
+	if len(runs) != len(want) {
+		t.Fatalf("Expected %d runs, got %d", len(want), len(runs))
+	}
+	for i, run := range runs {
+		if !run.Time.Equal(want[i]) {
+			t.Errorf("Run %d recorded at %v, want %v", i, run.Time, want[i])
+		}
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), File)
+	if err := os.WriteFile(path, []byte("{\"time\":\"2025-01-01T12:00:00Z\"}\nnot json\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(path); err == nil {
+		t.Error("Expected an error for a malformed line")
+	}
+}
@@ -0,0 +1,290 @@
+// Package hooks runs user-provided commands at points of a mullvad-compass run.
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/Ch00k/mullvad-compass/internal/logging"
+	"github.com/Ch00k/mullvad-compass/internal/relays"
+)
+
+// Event identifies the point of a run at which a hook is executed
+type Event string
+
+// Hook events
+const (
+	PreRun     Event = "pre_run"        // Before servers are pinged
+	PostRun    Event = "post_run"       // After results are printed
+	BestChange Event = "on_best_change" // After results are printed, when the best server differs from the last run
+)
+
+// Server is the representation of a server passed to hooks
+type Server struct {
+	Hostname string   `json:"hostname"`
+	Country  string   `json:"country"`
+	City     string   `json:"city"`
+	IP       string   `json:"ip"`
+	Latency  *float64 `json:"latency_ms"`  // nil indicates timeout
+	Distance *float64 `json:"distance_km"` // nil when unknown
+}
+
+// Payload is written as JSON to the hook's standard input
+type Payload struct {
+	Event        Event    `json:"event"`
+	Best         *Server  `json:"best,omitempty"`
+	PreviousBest string   `json:"previous_best,omitempty"`
+	Reason       string   `json:"reason,omitempty"` // Why on_best_change ran, with a switch threshold
+	Servers      []Server `json:"servers,omitempty"`
+}
+
+// NewServer converts a location into its hook representation
+func NewServer(loc relays.Location, useIPv6 bool) Server {
+	ip := loc.IPv4Address
+	if useIPv6 {
+		ip = loc.IPv6Address
+	}
+	return Server{
+		Hostname: loc.Hostname,
+		Country:  loc.Country,
+		City:     loc.City,
+		IP:       ip,
+		Latency:  loc.Latency,
+		Distance: loc.DistanceFromMyLocation,
+	}
+}
+
+// Runner executes configured hook commands
+type Runner struct {
+	commands  map[Event]string
+	statePath string
+	threshold SwitchThreshold
+	stdout    io.Writer
+	stderr    io.Writer
+	logLevel  logging.LogLevel
+}
+
+// Option is a function that configures a Runner
+type Option func(*Runner)
+
+// WithCommand sets the shell command executed for an event
+func WithCommand(event Event, command string) Option {
+	return func(r *Runner) {
+		if command != "" {
+			r.commands[event] = command
+		}
+	}
+}
+
+// WithStatePath sets the file used to remember the best server between runs
+func WithStatePath(path string) Option {
+	return func(r *Runner) {
+		r.statePath = path
+	}
+}
+
+// WithSwitchThreshold sets the improvement a new best server must exceed before on_best_change runs
+func WithSwitchThreshold(threshold SwitchThreshold) Option {
+	return func(r *Runner) {
+		r.threshold = threshold
+	}
+}
+
+// WithOutput sets where hook commands write their standard output and error
+func WithOutput(stdout, stderr io.Writer) Option {
+	return func(r *Runner) {
+		r.stdout = stdout
+		r.stderr = stderr
+	}
+}
+
+// WithLogLevel sets the log level for the runner
+func WithLogLevel(logLevel logging.LogLevel) Option {
+	return func(r *Runner) {
+		r.logLevel = logLevel
+	}
+}
+
+// NewRunner creates a new hook runner with the given options
+func NewRunner(opts ...Option) *Runner {
+	runner := &Runner{
+		commands: make(map[Event]string),
+		stdout:   os.Stderr,
+		stderr:   os.Stderr,
+		logLevel: logging.LogLevelError,
+	}
+
+	for _, opt := range opts {
+		opt(runner)
+	}
+
+	return runner
+}
+
+// Has reports whether a command is configured for the event
+func (r *Runner) Has(event Event) bool {
+	_, ok := r.commands[event]
+	return ok
+}
+
+// Run executes the command configured for the payload's event, if any.
+// The payload is passed as JSON on standard input and summarized in MULLVAD_COMPASS_* environment variables.
+func (r *Runner) Run(ctx context.Context, payload Payload) error {
+	command, ok := r.commands[payload.Event]
+	if !ok {
+		return nil
+	}
+
+	input, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s hook payload: %w", payload.Event, err)
+	}
+
+	if r.logLevel <= logging.LogLevelDebug {
+		log.Printf("Running %s hook: %s", payload.Event, command)
+	}
+
+	cmd := shellCommand(ctx, command)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = r.stdout
+	cmd.Stderr = r.stderr
+	cmd.Env = append(os.Environ(), environment(payload)...)
+
+	if err := cmd.Run(); err != nil {
+		if r.logLevel <= logging.LogLevelError {
+			log.Printf("%s hook failed: %v", payload.Event, err)
+		}
+		return fmt.Errorf("%s hook failed: %w", payload.Event, err)
+	}
+
+	return nil
+}
+
+// RunBestChange executes the on_best_change hook when the best server differs from the one recorded
+// by the previous run, then records the current best server. With a switch threshold, the hook only runs when
+// the best server improves on the previous one by more than the threshold, and the reason for the decision is
+// returned.
+func (r *Runner) RunBestChange(ctx context.Context, best Server, servers []Server) (string, error) {
+	if !r.Has(BestChange) {
+		return "", nil
+	}
+	if r.statePath == "" {
+		return "", errors.New("on_best_change hook requires a state file path")
+	}
+
+	previous, err := readLastBest(r.statePath)
+	if err != nil {
+		return "", err
+	}
+	if previous == best.Hostname {
+		if r.logLevel <= logging.LogLevelDebug {
+			log.Printf("Best server unchanged (%s), skipping %s hook", best.Hostname, BestChange)
+		}
+		return "", nil
+	}
+
+	var reason string
+	if previous != "" && !r.threshold.IsZero() {
+		var switchServer bool
+		switchServer, reason = r.threshold.Decide(findServer(servers, previous), best)
+		if !switchServer {
+			if r.logLevel <= logging.LogLevelDebug {
+				log.Printf("Skipping %s hook: %s", BestChange, reason)
+			}
+			return reason, nil
+		}
+	}
+
+	if err := r.Run(ctx, Payload{
+		Event:        BestChange,
+		Best:         &best,
+		PreviousBest: previous,
+		Reason:       reason,
+		Servers:      servers,
+	}); err != nil {
+		return "", err
+	}
+
+	return reason, writeLastBest(r.statePath, best.Hostname)
+}
+
+// findServer returns the server with the hostname, or nil if it is not among the servers
+func findServer(servers []Server, hostname string) *Server {
+	for i := range servers {
+		if servers[i].Hostname == hostname {
+			return &servers[i]
+		}
+	}
+	return nil
+}
+
+// DefaultStatePath returns the per-user file in which the last best server is recorded
+func DefaultStatePath() (string, error) {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to determine cache directory: %w", err)
+	}
+	return filepath.Join(cacheDir, "mullvad-compass", "last-best-server"), nil
+}
+
+// readLastBest returns the hostname recorded by the previous run, or an empty string if there is none
+func readLastBest(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read hook state: %w", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// writeLastBest records the hostname of the current best server
+func writeLastBest(path, hostname string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("failed to create hook state directory: %w", err)
+	}
+	if err := os.WriteFile(path, []byte(hostname+"\n"), 0o644); err != nil {
+		return fmt.Errorf("failed to write hook state: %w", err)
+	}
+	return nil
+}
+
+// shellCommand builds a command that runs the given string through the platform shell
+func shellCommand(ctx context.Context, command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.CommandContext(ctx, "cmd", "/C", command)
+	}
+	return exec.CommandContext(ctx, "sh", "-c", command)
+}
+
+// environment returns the MULLVAD_COMPASS_* variables describing the payload
+func environment(payload Payload) []string {
+	env := []string{"MULLVAD_COMPASS_EVENT=" + string(payload.Event)}
+	if payload.PreviousBest != "" {
+		env = append(env, "MULLVAD_COMPASS_PREVIOUS_BEST="+payload.PreviousBest)
+	}
+	if payload.Best != nil {
+		env = append(env,
+			"MULLVAD_COMPASS_BEST_HOSTNAME="+payload.Best.Hostname,
+			"MULLVAD_COMPASS_BEST_COUNTRY="+payload.Best.Country,
+			"MULLVAD_COMPASS_BEST_CITY="+payload.Best.City,
+			"MULLVAD_COMPASS_BEST_IP="+payload.Best.IP,
+		)
+		if payload.Best.Latency != nil {
+			env = append(env, "MULLVAD_COMPASS_BEST_LATENCY="+strconv.FormatFloat(*payload.Best.Latency, 'f', 2, 64))
+		}
+	}
+	return env
+}
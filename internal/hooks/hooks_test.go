@@ -0,0 +1,137 @@
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/Ch00k/mullvad-compass/internal/relays"
+)
+
+func skipOnWindows(t *testing.T) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("hook tests use POSIX shell commands")
+	}
+}
+
+func TestNewServer(t *testing.T) {
+	latency := 12.5
+	loc := relays.Location{
+		Hostname:    "de-ber-wg-001",
+		Country:     "Germany",
+		City:        "Berlin",
+		IPv4Address: "193.32.248.66",
+		IPv6Address: "2a03:1b20:3:f011::a01f",
+		Latency:     &latency,
+	}
+
+	if got := NewServer(loc, false).IP; got != "193.32.248.66" {
+		t.Errorf("Expected IPv4 address, got %s", got)
+	}
+	if got := NewServer(loc, true).IP; got != "2a03:1b20:3:f011::a01f" {
+		t.Errorf("Expected IPv6 address, got %s", got)
+	}
+}
+
+func TestRunnerRun(t *testing.T) {
+	skipOnWindows(t)
+
+	t.Run("No command configured is a no-op", func(t *testing.T) {
+		runner := NewRunner()
+		if err := runner.Run(context.Background(), Payload{Event: PreRun}); err != nil {
+			t.Errorf("Expected no error, got %v", err)
+		}
+	})
+
+	t.Run("Passes payload on stdin and in environment", func(t *testing.T) {
+		var stdout bytes.Buffer
+		runner := NewRunner(
+			WithCommand(PostRun, `echo "$MULLVAD_COMPASS_EVENT $MULLVAD_COMPASS_BEST_HOSTNAME $MULLVAD_COMPASS_BEST_LATENCY"; cat`),
+			WithOutput(&stdout, &stdout),
+		)
+
+		latency := 9.78
+		best := Server{Hostname: "cz-prg-wg-201", Latency: &latency}
+		err := runner.Run(context.Background(), Payload{Event: PostRun, Best: &best, Servers: []Server{best}})
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		lines := strings.SplitN(stdout.String(), "\n", 2)
+		if lines[0] != "post_run cz-prg-wg-201 9.78" {
+			t.Errorf("Unexpected environment output: %q", lines[0])
+		}
+
+		var payload Payload
+		if err := json.Unmarshal([]byte(lines[1]), &payload); err != nil {
+			t.Fatalf("Expected JSON payload on stdin, got %q: %v", lines[1], err)
+		}
+		if payload.Best == nil || payload.Best.Hostname != "cz-prg-wg-201" {
+			t.Errorf("Unexpected payload: %+v", payload)
+		}
+		if len(payload.Servers) != 1 {
+			t.Errorf("Expected 1 server in payload, got %d", len(payload.Servers))
+		}
+	})
+
+	t.Run("Returns error when command fails", func(t *testing.T) {
+		runner := NewRunner(WithCommand(PreRun, "exit 3"), WithOutput(&bytes.Buffer{}, &bytes.Buffer{}))
+
+		err := runner.Run(context.Background(), Payload{Event: PreRun})
+		if err == nil {
+			t.Fatal("Expected error from failing hook")
+		}
+		if !strings.Contains(err.Error(), "pre_run hook failed") {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	})
+}
+
+func TestRunnerRunBestChange(t *testing.T) {
+	skipOnWindows(t)
+
+	statePath := filepath.Join(t.TempDir(), "state", "last-best-server")
+	var stdout bytes.Buffer
+	runner := NewRunner(
+		WithCommand(BestChange, `echo "$MULLVAD_COMPASS_PREVIOUS_BEST->$MULLVAD_COMPASS_BEST_HOSTNAME"`),
+		WithStatePath(statePath),
+		WithOutput(&stdout, &stdout),
+	)
+
+	run := func(hostname string) {
+		t.Helper()
+		if _, err := runner.RunBestChange(context.Background(), Server{Hostname: hostname}, nil); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	}
+
+	run("de-ber-wg-001")
+	run("de-ber-wg-001")
+	run("cz-prg-wg-201")
+
+	expected := "->de-ber-wg-001\nde-ber-wg-001->cz-prg-wg-201\n"
+	if stdout.String() != expected {
+		t.Errorf("Expected hook to run only on changes, got %q", stdout.String())
+	}
+
+	data, err := os.ReadFile(statePath)
+	if err != nil {
+		t.Fatalf("Expected state file to exist: %v", err)
+	}
+	if strings.TrimSpace(string(data)) != "cz-prg-wg-201" {
+		t.Errorf("Expected state to record latest best server, got %q", data)
+	}
+}
+
+func TestRunnerRunBestChangeWithoutCommand(t *testing.T) {
+	runner := NewRunner()
+	if _, err := runner.RunBestChange(context.Background(), Server{Hostname: "x"}, nil); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+}
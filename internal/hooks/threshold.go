@@ -0,0 +1,79 @@
+package hooks
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// SwitchThreshold is the improvement over the previous best server a new best server must exceed before
+// on_best_change runs, either in milliseconds or as a percentage of the previous latency. The zero value
+// switches on any change.
+type SwitchThreshold struct {
+	Latency float64 // Milliseconds
+	Percent float64
+}
+
+// ParseSwitchThreshold parses a threshold in milliseconds ("10") or percent ("20%")
+func ParseSwitchThreshold(s string) (SwitchThreshold, error) {
+	number, isPercent := strings.CutSuffix(s, "%")
+	value, err := strconv.ParseFloat(number, 64)
+	if err != nil || value <= 0 {
+		return SwitchThreshold{}, fmt.Errorf(
+			"invalid switch threshold: %s (must be a positive number of milliseconds or a percentage, e.g. 10 or 20%%)",
+			s,
+		)
+	}
+	if isPercent {
+		if value >= 100 {
+			return SwitchThreshold{}, fmt.Errorf("invalid switch threshold: %s (must be below 100%%)", s)
+		}
+		return SwitchThreshold{Percent: value}, nil
+	}
+	return SwitchThreshold{Latency: value}, nil
+}
+
+// IsZero reports whether the threshold switches on any change
+func (t SwitchThreshold) IsZero() bool {
+	return t.Latency == 0 && t.Percent == 0
+}
+
+// String formats the threshold as it is given on the command line, e.g. "10 ms" or "20%"
+func (t SwitchThreshold) String() string {
+	if t.Percent > 0 {
+		return strconv.FormatFloat(t.Percent, 'f', -1, 64) + "%"
+	}
+	return strconv.FormatFloat(t.Latency, 'f', -1, 64) + " ms"
+}
+
+// Decide reports whether to switch from the previous best server to the new one, and why. The previous server
+// is nil if it was not pinged in this run.
+func (t SwitchThreshold) Decide(previous *Server, best Server) (bool, string) {
+	switch {
+	case previous == nil:
+		return true, fmt.Sprintf("switching to %s, the previous best server was not pinged", best.Hostname)
+	case previous.Latency == nil:
+		return true, fmt.Sprintf("switching to %s, %s did not respond", best.Hostname, previous.Hostname)
+	case best.Latency == nil:
+		return false, fmt.Sprintf("keeping %s, %s did not respond", previous.Hostname, best.Hostname)
+	}
+
+	gain := *previous.Latency - *best.Latency
+	percent := 100 * gain / *previous.Latency
+	improvement := fmt.Sprintf("%.2f ms (%.0f%%)", gain, percent)
+
+	exceeds := gain > t.Latency
+	if t.Percent > 0 {
+		exceeds = percent > t.Percent
+	}
+	if exceeds {
+		return true, fmt.Sprintf(
+			"switching to %s, %s faster than %s exceeds the %s switch threshold",
+			best.Hostname, improvement, previous.Hostname, t,
+		)
+	}
+	return false, fmt.Sprintf(
+		"keeping %s, %s is only %s faster, within the %s switch threshold",
+		previous.Hostname, best.Hostname, improvement, t,
+	)
+}
@@ -0,0 +1,143 @@
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseSwitchThreshold(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    SwitchThreshold
+		wantErr bool
+	}{
+		{input: "10", want: SwitchThreshold{Latency: 10}},
+		{input: "2.5", want: SwitchThreshold{Latency: 2.5}},
+		{input: "20%", want: SwitchThreshold{Percent: 20}},
+		{input: "0", wantErr: true},
+		{input: "-5", wantErr: true},
+		{input: "100%", wantErr: true},
+		{input: "ten", wantErr: true},
+		{input: "%", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseSwitchThreshold(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseSwitchThreshold(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseSwitchThreshold(%q) = %+v, want %+v", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSwitchThresholdDecide(t *testing.T) {
+	latency := func(ms float64) *float64 { return &ms }
+	previous := &Server{Hostname: "de-ber-wg-001", Latency: latency(20)}
+
+	tests := []struct {
+		name       string
+		threshold  SwitchThreshold
+		previous   *Server
+		best       Server
+		wantSwitch bool
+		wantReason string
+	}{
+		{
+			name:       "Above latency threshold",
+			threshold:  SwitchThreshold{Latency: 5},
+			previous:   previous,
+			best:       Server{Hostname: "cz-prg-wg-201", Latency: latency(10)},
+			wantSwitch: true,
+			wantReason: "switching to cz-prg-wg-201, 10.00 ms (50%) faster than de-ber-wg-001 " +
+				"exceeds the 5 ms switch threshold",
+		},
+		{
+			name:      "Within latency threshold",
+			threshold: SwitchThreshold{Latency: 5},
+			previous:  previous,
+			best:      Server{Hostname: "cz-prg-wg-201", Latency: latency(17)},
+			wantReason: "keeping de-ber-wg-001, cz-prg-wg-201 is only 3.00 ms (15%) faster, " +
+				"within the 5 ms switch threshold",
+		},
+		{
+			name:      "Within percent threshold",
+			threshold: SwitchThreshold{Percent: 20},
+			previous:  previous,
+			best:      Server{Hostname: "cz-prg-wg-201", Latency: latency(17)},
+			wantReason: "keeping de-ber-wg-001, cz-prg-wg-201 is only 3.00 ms (15%) faster, " +
+				"within the 20% switch threshold",
+		},
+		{
+			name:       "Previous not pinged",
+			threshold:  SwitchThreshold{Latency: 5},
+			best:       Server{Hostname: "cz-prg-wg-201", Latency: latency(19)},
+			wantSwitch: true,
+			wantReason: "switching to cz-prg-wg-201, the previous best server was not pinged",
+		},
+		{
+			name:       "Previous timed out",
+			threshold:  SwitchThreshold{Latency: 5},
+			previous:   &Server{Hostname: "de-ber-wg-001"},
+			best:       Server{Hostname: "cz-prg-wg-201", Latency: latency(19)},
+			wantSwitch: true,
+			wantReason: "switching to cz-prg-wg-201, de-ber-wg-001 did not respond",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotSwitch, gotReason := tt.threshold.Decide(tt.previous, tt.best)
+			if gotSwitch != tt.wantSwitch || gotReason != tt.wantReason {
+				t.Errorf("Decide() = %v, %q, want %v, %q", gotSwitch, gotReason, tt.wantSwitch, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestRunnerRunBestChangeWithThreshold(t *testing.T) {
+	skipOnWindows(t)
+
+	statePath := filepath.Join(t.TempDir(), "last-best-server")
+	var stdout bytes.Buffer
+	runner := NewRunner(
+		WithCommand(BestChange, `echo "$MULLVAD_COMPASS_BEST_HOSTNAME"`),
+		WithStatePath(statePath),
+		WithSwitchThreshold(SwitchThreshold{Latency: 5}),
+		WithOutput(&stdout, &stdout),
+	)
+
+	run := func(best string, latencies map[string]float64) string {
+		t.Helper()
+		var servers []Server
+		for hostname, latency := range latencies {
+			servers = append(servers, Server{Hostname: hostname, Latency: &latency})
+		}
+		l := latencies[best]
+		reason, err := runner.RunBestChange(context.Background(), Server{Hostname: best, Latency: &l}, servers)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		return reason
+	}
+
+	if reason := run("de-ber-wg-001", map[string]float64{"de-ber-wg-001": 20}); reason != "" {
+		t.Errorf("Expected no reason for the first best server, got %q", reason)
+	}
+	reason := run("cz-prg-wg-201", map[string]float64{"de-ber-wg-001": 20, "cz-prg-wg-201": 18})
+	if !strings.HasPrefix(reason, "keeping de-ber-wg-001") {
+		t.Errorf("Expected to keep the previous best server, got %q", reason)
+	}
+	reason = run("cz-prg-wg-201", map[string]float64{"de-ber-wg-001": 20, "cz-prg-wg-201": 12})
+	if !strings.HasPrefix(reason, "switching to cz-prg-wg-201") {
+		t.Errorf("Expected to switch to the new best server, got %q", reason)
+	}
+
+	if want := "de-ber-wg-001\ncz-prg-wg-201\n"; stdout.String() != want {
+		t.Errorf("Expected the hook to run only above the threshold, got %q", stdout.String())
+	}
+}
@@ -0,0 +1,53 @@
+package hostlist
+
+import (
+	"slices"
+	"strings"
+)
+
+// RecordTimeouts appends the hostnames that timed out in a run to the history file, keeping the last keep runs.
+// Returns the recorded runs, oldest first.
+func RecordTimeouts(path string, timedOut []string, keep int) ([][]string, error) {
+	lines, err := readLines(path)
+	if err != nil {
+		return nil, err
+	}
+
+	lines = append(lines, strings.Join(timedOut, " "))
+	if len(lines) > keep {
+		lines = lines[len(lines)-keep:]
+	}
+	if err := writeLines(path, lines); err != nil {
+		return nil, err
+	}
+
+	runs := make([][]string, len(lines))
+	for i, line := range lines {
+		runs[i] = strings.Fields(line)
+	}
+	return runs, nil
+}
+
+// Persistent returns the hostnames that timed out in each of the last n runs, in the order of the latest run.
+// Returns nothing until n runs are recorded.
+func Persistent(runs [][]string, n int) []string {
+	if n <= 0 || len(runs) < n {
+		return nil
+	}
+
+	recent := runs[len(runs)-n:]
+	var persistent []string
+	for _, hostname := range recent[len(recent)-1] {
+		inAll := true
+		for _, run := range recent[:len(recent)-1] {
+			if !slices.Contains(run, hostname) {
+				inAll = false
+				break
+			}
+		}
+		if inAll {
+			persistent = append(persistent, hostname)
+		}
+	}
+	return persistent
+}
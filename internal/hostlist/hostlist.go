@@ -0,0 +1,128 @@
+// Package hostlist stores lists of relay hostnames kept across runs, such as favorites and ignored relays.
+package hostlist
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/Ch00k/mullvad-compass/internal/relays"
+)
+
+// File names of the lists in the config directory
+const (
+	Favorites = "favorites"
+	Ignore    = "ignore"
+	Timeouts  = "timeouts" // Relays that timed out, one line per run
+)
+
+// ConfigPath returns the path of a file in the user's mullvad-compass config directory
+func ConfigPath(name string) (string, error) {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to determine config directory: %w", err)
+	}
+	return filepath.Join(configDir, "mullvad-compass", name), nil
+}
+
+// Load reads hostnames, one per line. A missing file holds no hostnames.
+func Load(path string) ([]string, error) {
+	lines, err := readLines(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var hostnames []string
+	for _, line := range lines {
+		if hostname := strings.TrimSpace(line); hostname != "" {
+			hostnames = append(hostnames, hostname)
+		}
+	}
+	return hostnames, nil
+}
+
+// Save writes hostnames, one per line
+func Save(path string, hostnames []string) error {
+	return writeLines(path, hostnames)
+}
+
+// readLines reads the lines of a file, without the trailing newline. A missing file has no lines.
+func readLines(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	if len(data) == 0 {
+		return nil, nil
+	}
+	return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n"), nil
+}
+
+// writeLines writes lines to a file, creating its directory if needed
+func writeLines(path string, lines []string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("failed to create directory for %s: %w", path, err)
+	}
+
+	var data strings.Builder
+	for _, line := range lines {
+		data.WriteString(line)
+		data.WriteString("\n")
+	}
+	if err := os.WriteFile(path, []byte(data.String()), 0o644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
+
+// Add returns the list with the hostnames appended, skipping those already present
+func Add(list []string, hostnames ...string) []string {
+	for _, hostname := range hostnames {
+		if !slices.Contains(list, hostname) {
+			list = append(list, hostname)
+		}
+	}
+	return list
+}
+
+// Remove returns a copy of the list without the hostnames
+func Remove(list []string, hostnames ...string) []string {
+	return slices.DeleteFunc(slices.Clone(list), func(h string) bool {
+		return slices.Contains(hostnames, h)
+	})
+}
+
+// MarkFavorites flags the locations whose hostname is a favorite
+func MarkFavorites(locations []relays.Location, favorites []string) {
+	for i := range locations {
+		locations[i].Favorite = slices.Contains(favorites, locations[i].Hostname)
+	}
+}
+
+// OnlyFavorites returns the locations that are favorites
+func OnlyFavorites(locations []relays.Location) []relays.Location {
+	var filtered []relays.Location
+	for _, loc := range locations {
+		if loc.Favorite {
+			filtered = append(filtered, loc)
+		}
+	}
+	return filtered
+}
+
+// Exclude returns the locations whose hostname is not in the list
+func Exclude(locations []relays.Location, hostnames []string) []relays.Location {
+	var filtered []relays.Location
+	for _, loc := range locations {
+		if !slices.Contains(hostnames, loc.Hostname) {
+			filtered = append(filtered, loc)
+		}
+	}
+	return filtered
+}
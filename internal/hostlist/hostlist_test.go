@@ -0,0 +1,139 @@
+package hostlist
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/Ch00k/mullvad-compass/internal/relays"
+)
+
+func TestLoadSave(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mullvad-compass", Favorites)
+
+	hostnames, err := Load(path)
+	if err != nil {
+		t.Fatalf("Expected a missing file to load without error, got: %v", err)
+	}
+	if len(hostnames) != 0 {
+		t.Errorf("Expected no hostnames, got %v", hostnames)
+	}
+
+	want := []string{"de-ber-wg-001", "se-got-wg-101"}
+	if err := Save(path, want); err != nil {
+		t.Fatalf("Failed to save hostnames: %v", err)
+	}
+	got, err := Load(path)
+	if err != nil {
+		t.Fatalf("Failed to load hostnames: %v", err)
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+}
+
+func TestAdd(t *testing.T) {
+	got := Add([]string{"de-ber-wg-001"}, "se-got-wg-101", "de-ber-wg-001", "se-got-wg-101")
+	want := []string{"de-ber-wg-001", "se-got-wg-101"}
+	if !slices.Equal(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+}
+
+func TestRemove(t *testing.T) {
+	list := []string{"de-ber-wg-001", "se-got-wg-101", "cz-prg-wg-201"}
+
+	got := Remove(list, "se-got-wg-101", "fi-hel-wg-001")
+	if want := []string{"de-ber-wg-001", "cz-prg-wg-201"}; !slices.Equal(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+	if len(list) != 3 || list[1] != "se-got-wg-101" {
+		t.Errorf("Expected the input to be left intact, got %v", list)
+	}
+}
+
+func TestFavorites(t *testing.T) {
+	locations := []relays.Location{
+		{Hostname: "de-ber-wg-001"},
+		{Hostname: "de-ber-wg-002", Favorite: true},
+		{Hostname: "se-got-wg-101"},
+	}
+
+	MarkFavorites(locations, []string{"se-got-wg-101"})
+	if locations[0].Favorite || locations[1].Favorite || !locations[2].Favorite {
+		t.Errorf("Expected only se-got-wg-101 to be marked, got %+v", locations)
+	}
+
+	only := OnlyFavorites(locations)
+	if len(only) != 1 || only[0].Hostname != "se-got-wg-101" {
+		t.Errorf("Expected only se-got-wg-101, got %+v", only)
+	}
+}
+
+func TestExclude(t *testing.T) {
+	locations := []relays.Location{
+		{Hostname: "de-ber-wg-001"},
+		{Hostname: "de-ber-wg-002"},
+		{Hostname: "se-got-wg-101"},
+	}
+
+	kept := Exclude(locations, []string{"de-ber-wg-002", "fi-hel-wg-001"})
+	if len(kept) != 2 || kept[0].Hostname != "de-ber-wg-001" || kept[1].Hostname != "se-got-wg-101" {
+		t.Errorf("Expected de-ber-wg-002 to be excluded, got %+v", kept)
+	}
+	if len(Exclude(locations, nil)) != 3 {
+		t.Error("Expected an empty list to exclude nothing")
+	}
+}
+
+func TestRecordTimeouts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), Timeouts)
+
+	record := func(timedOut ...string) [][]string {
+		t.Helper()
+		runs, err := RecordTimeouts(path, timedOut, 3)
+		if err != nil {
+			t.Fatalf("Failed to record timeouts: %v", err)
+		}
+		return runs
+	}
+
+	record("de-ber-wg-001", "de-ber-wg-002")
+	record() // A run without timeouts still counts
+	record("de-ber-wg-001")
+	runs := record("de-ber-wg-001", "se-got-wg-101")
+
+	if len(runs) != 3 {
+		t.Fatalf("Expected the last 3 runs to be kept, got %d: %v", len(runs), runs)
+	}
+	if len(runs[0]) != 0 || !slices.Equal(runs[2], []string{"de-ber-wg-001", "se-got-wg-101"}) {
+		t.Errorf("Unexpected runs: %v", runs)
+	}
+}
+
+func TestPersistent(t *testing.T) {
+	runs := [][]string{
+		{"de-ber-wg-001", "de-ber-wg-002"},
+		{"de-ber-wg-002", "de-ber-wg-001", "se-got-wg-101"},
+		{"se-got-wg-101", "de-ber-wg-001", "de-ber-wg-002"},
+	}
+
+	tests := []struct {
+		name string
+		runs [][]string
+		n    int
+		want []string
+	}{
+		{"In every run", runs, 3, []string{"de-ber-wg-001", "de-ber-wg-002"}},
+		{"Last two runs", runs, 2, []string{"se-got-wg-101", "de-ber-wg-001", "de-ber-wg-002"}},
+		{"Too few runs", runs[:2], 3, nil},
+		{"Clean last run", append(slices.Clone(runs), nil), 3, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Persistent(tt.runs, tt.n); !slices.Equal(got, tt.want) {
+				t.Errorf("Persistent() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
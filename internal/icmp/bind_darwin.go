@@ -0,0 +1,18 @@
+//go:build darwin
+
+package icmp
+
+import (
+	"net"
+
+	"github.com/Ch00k/mullvad-compass/internal/relays"
+	"golang.org/x/sys/unix"
+)
+
+// bindToInterface restricts the socket to the interface with IP_BOUND_IF or IPV6_BOUND_IF
+func bindToInterface(fd int, ifi *net.Interface, ipVersion relays.IPVersion) error {
+	if ipVersion.IsIPv6() {
+		return unix.SetsockoptInt(fd, unix.IPPROTO_IPV6, unix.IPV6_BOUND_IF, ifi.Index)
+	}
+	return unix.SetsockoptInt(fd, unix.IPPROTO_IP, unix.IP_BOUND_IF, ifi.Index)
+}
@@ -0,0 +1,16 @@
+//go:build linux
+
+package icmp
+
+import (
+	"net"
+
+	"github.com/Ch00k/mullvad-compass/internal/relays"
+	"golang.org/x/sys/unix"
+)
+
+// bindToInterface restricts the socket to the interface with SO_BINDTODEVICE.
+// Kernels before 5.7 require CAP_NET_RAW for this.
+func bindToInterface(fd int, ifi *net.Interface, _ relays.IPVersion) error {
+	return unix.SetsockoptString(fd, unix.SOL_SOCKET, unix.SO_BINDTODEVICE, ifi.Name)
+}
@@ -0,0 +1,15 @@
+//go:build !linux && !darwin && !windows
+
+package icmp
+
+import (
+	"errors"
+	"net"
+
+	"github.com/Ch00k/mullvad-compass/internal/relays"
+)
+
+// bindToInterface is not supported on this platform; use a source address instead
+func bindToInterface(_ int, _ *net.Interface, _ relays.IPVersion) error {
+	return errors.New("binding to an interface is not supported on this platform")
+}
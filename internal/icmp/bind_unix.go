@@ -0,0 +1,84 @@
+//go:build !windows
+
+package icmp
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"syscall"
+
+	"github.com/Ch00k/mullvad-compass/internal/logging"
+	"github.com/Ch00k/mullvad-compass/internal/relays"
+)
+
+// ListenBound creates an unprivileged ICMP datagram socket bound to a source address and, if iface is
+// not empty, to a network interface. A nil addr leaves the source address to the kernel.
+func ListenBound(
+	ipVersion relays.IPVersion,
+	addr net.IP,
+	iface string,
+	logLevel logging.LogLevel,
+) (net.PacketConn, string, error) {
+	family, proto, network := syscall.AF_INET, syscall.IPPROTO_ICMP, NetworkIPv4
+	if ipVersion.IsIPv6() {
+		family, proto, network = syscall.AF_INET6, syscall.IPPROTO_ICMPV6, NetworkIPv6
+	}
+
+	if logLevel <= logging.LogLevelDebug {
+		log.Printf("Attempting to create ICMP datagram socket (%s, source: %v, interface: %q)", network, addr, iface)
+	}
+
+	fd, err := syscall.Socket(family, syscall.SOCK_DGRAM, proto)
+	if err != nil {
+		if logLevel <= logging.LogLevelError {
+			log.Printf("Failed to create ICMP socket: %v", err)
+		}
+		return nil, "", os.NewSyscallError("socket", err)
+	}
+
+	if iface != "" {
+		ifi, err := net.InterfaceByName(iface)
+		if err != nil {
+			_ = syscall.Close(fd)
+			return nil, "", err
+		}
+		if err := bindToInterface(fd, ifi, ipVersion); err != nil {
+			_ = syscall.Close(fd)
+			return nil, "", fmt.Errorf("failed to bind to interface %s: %w", iface, err)
+		}
+	}
+
+	if addr != nil {
+		if err := syscall.Bind(fd, sockaddr(addr, ipVersion)); err != nil {
+			_ = syscall.Close(fd)
+			return nil, "", fmt.Errorf("failed to bind to source address %s: %w", addr, err)
+		}
+	}
+
+	// FilePacketConn duplicates the descriptor, so the file is closed either way
+	f := os.NewFile(uintptr(fd), "icmp")
+	c, err := net.FilePacketConn(f)
+	_ = f.Close()
+	if err != nil {
+		return nil, "", err
+	}
+
+	if logLevel <= logging.LogLevelDebug {
+		log.Printf("Successfully created ICMP datagram socket")
+	}
+	return c, network, nil
+}
+
+// sockaddr converts an IP address to a socket address for the given IP version
+func sockaddr(ip net.IP, ipVersion relays.IPVersion) syscall.Sockaddr {
+	if ipVersion.IsIPv6() {
+		sa := &syscall.SockaddrInet6{}
+		copy(sa.Addr[:], ip.To16())
+		return sa
+	}
+	sa := &syscall.SockaddrInet4{}
+	copy(sa.Addr[:], ip.To4())
+	return sa
+}
@@ -0,0 +1,41 @@
+//go:build !windows
+
+package icmp
+
+import (
+	"net"
+	"testing"
+
+	"github.com/Ch00k/mullvad-compass/internal/logging"
+	"github.com/Ch00k/mullvad-compass/internal/relays"
+)
+
+// TestListenBound_SourceAddress tests that the socket is bound to the requested source address
+func TestListenBound_SourceAddress(t *testing.T) {
+	conn, network, err := ListenBound(relays.IPv4, net.ParseIP("127.0.0.1"), "", logging.LogLevelError)
+	if err != nil {
+		t.Skipf("Skipping ICMP test: %v (requires ping_group_range configuration)", err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	if network != NetworkIPv4 {
+		t.Errorf("Expected udp4, got %s", network)
+	}
+
+	addr, ok := conn.LocalAddr().(*net.UDPAddr)
+	if !ok {
+		t.Fatalf("Expected *net.UDPAddr, got %T", conn.LocalAddr())
+	}
+	if !addr.IP.Equal(net.ParseIP("127.0.0.1")) {
+		t.Errorf("Expected local address 127.0.0.1, got %s", addr.IP)
+	}
+}
+
+// TestListenBound_UnknownInterface tests that an unknown interface is rejected
+func TestListenBound_UnknownInterface(t *testing.T) {
+	conn, _, err := ListenBound(relays.IPv4, nil, "mullvad-compass-none", logging.LogLevelError)
+	if err == nil {
+		_ = conn.Close()
+		t.Fatal("Expected error for unknown interface")
+	}
+}
@@ -0,0 +1,37 @@
+//go:build !windows
+
+package icmp
+
+import (
+	"log"
+	"net"
+
+	"github.com/Ch00k/mullvad-compass/internal/logging"
+	"golang.org/x/net/icmp"
+)
+
+// ListenRaw creates a raw ICMPv4 socket, which unlike a datagram socket can send messages other than echo
+// requests. It needs root or CAP_NET_RAW. A nil addr listens on all interfaces.
+func ListenRaw(addr net.IP, logLevel logging.LogLevel) (*icmp.PacketConn, error) {
+	listen := addrIPv4All
+	if addr != nil {
+		listen = addr.String()
+	}
+
+	if logLevel <= logging.LogLevelDebug {
+		log.Printf("Attempting to create raw ICMP socket (ip4:icmp on %s)", listen)
+	}
+
+	c, err := icmp.ListenPacket("ip4:icmp", listen)
+	if err != nil {
+		if logLevel <= logging.LogLevelError {
+			log.Printf("Failed to create raw ICMP socket: %v", err)
+		}
+		return nil, err
+	}
+
+	if logLevel <= logging.LogLevelDebug {
+		log.Printf("Successfully created raw ICMP socket")
+	}
+	return c, nil
+}
@@ -17,6 +17,7 @@ var (
 
 	procIcmpCreateFile  = iphlpapi.NewProc("IcmpCreateFile")
 	procIcmpSendEcho    = iphlpapi.NewProc("IcmpSendEcho")
+	procIcmpSendEcho2Ex = iphlpapi.NewProc("IcmpSendEcho2Ex")
 	procIcmpCloseHandle = iphlpapi.NewProc("IcmpCloseHandle")
 	procIcmp6CreateFile = iphlpapi.NewProc("Icmp6CreateFile")
 	procIcmp6SendEcho2  = iphlpapi.NewProc("Icmp6SendEcho2")
@@ -177,18 +178,74 @@ func IcmpSendEcho(handle Handle, destAddr uint32, requestData []byte, timeout ti
 	return reply, nil
 }
 
+// IcmpSendEchoFrom sends an IPv4 ICMP echo request from the given source address and waits for reply
+func IcmpSendEchoFrom(
+	handle Handle,
+	srcAddr, destAddr uint32,
+	requestData []byte,
+	timeout time.Duration,
+) (*IcmpEchoReply, error) {
+	if len(requestData) == 0 {
+		requestData = []byte("mullvad-compass")
+	}
+
+	timeoutMs := uint32(timeout.Milliseconds())
+
+	replyBufSize := unsafe.Sizeof(IcmpEchoReply{}) + uintptr(len(requestData)) + 8
+	replyBuf := make([]byte, replyBufSize)
+
+	ret, _, err := procIcmpSendEcho2Ex.Call(
+		uintptr(handle),
+		0, // Event (NULL for synchronous)
+		0, // ApcRoutine (NULL)
+		0, // ApcContext (NULL)
+		uintptr(srcAddr),
+		uintptr(destAddr),
+		uintptr(unsafe.Pointer(&requestData[0])),
+		uintptr(len(requestData)),
+		0, // IP options (NULL)
+		uintptr(unsafe.Pointer(&replyBuf[0])),
+		uintptr(len(replyBuf)),
+		uintptr(timeoutMs),
+	)
+
+	if ret == 0 {
+		reply := (*IcmpEchoReply)(unsafe.Pointer(&replyBuf[0]))
+		if reply.Status != IPSuccess && reply.Status != 0 {
+			return nil, fmt.Errorf("IcmpSendEcho2Ex failed with status: %s (syscall error: %w)", IPStatusToString(reply.Status), err)
+		}
+		return nil, fmt.Errorf("IcmpSendEcho2Ex failed: %w", err)
+	}
+
+	reply := (*IcmpEchoReply)(unsafe.Pointer(&replyBuf[0]))
+	return reply, nil
+}
+
 // Icmp6SendEcho2 sends an IPv6 ICMP echo request and waits for reply
 func Icmp6SendEcho2(handle Handle, destAddr net.IP, requestData []byte, timeout time.Duration) (*Icmp6EchoReply, error) {
+	return Icmp6SendEcho2From(handle, nil, destAddr, requestData, timeout)
+}
+
+// Icmp6SendEcho2From sends an IPv6 ICMP echo request from the given source address and waits for reply.
+// A nil source lets Windows select the source address.
+func Icmp6SendEcho2From(
+	handle Handle,
+	srcAddr, destAddr net.IP,
+	requestData []byte,
+	timeout time.Duration,
+) (*Icmp6EchoReply, error) {
 	if len(requestData) == 0 {
 		requestData = []byte("mullvad-compass")
 	}
 
 	timeoutMs := uint32(timeout.Milliseconds())
 
-	// Source address - use unspecified address (::) to let system choose
+	// Source address - the unspecified address (::) lets the system choose
 	var srcSockAddr SockAddrIn6
 	srcSockAddr.Family = afInet6
-	// Leave Addr as zero (::) to let Windows select source address
+	if srcAddr != nil {
+		copy(srcSockAddr.Addr[:], srcAddr.To16())
+	}
 
 	// Convert destination IP to SOCKADDR_IN6
 	var destSockAddr SockAddrIn6
@@ -0,0 +1,37 @@
+// Package netcheck detects whether the host can reach servers before they are pinged.
+package netcheck
+
+import (
+	"errors"
+	"fmt"
+	"net"
+)
+
+// ErrNoIPv6Route indicates that the host has no usable route to the IPv6 internet
+var ErrNoIPv6Route = errors.New("no IPv6 route")
+
+// dial connects sockets; replaced in tests
+var dial = net.Dial
+
+// CheckIPv6Route returns an error wrapping ErrNoIPv6Route if the host cannot route to the given IPv6 address.
+// Connecting a UDP socket only performs a route lookup, so no packets are sent.
+func CheckIPv6Route(addr string) error {
+	ip := net.ParseIP(addr)
+	if ip == nil || ip.To4() != nil {
+		return fmt.Errorf("not an IPv6 address: %q", addr)
+	}
+
+	conn, err := dial("udp6", net.JoinHostPort(addr, "53"))
+	if err != nil {
+		return fmt.Errorf("%w to %s: %v", ErrNoIPv6Route, addr, err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	// A route that only offers a loopback or link-local source address cannot reach the internet
+	local, ok := conn.LocalAddr().(*net.UDPAddr)
+	if !ok || !local.IP.IsGlobalUnicast() {
+		return fmt.Errorf("%w to %s: no global IPv6 address on this host", ErrNoIPv6Route, addr)
+	}
+
+	return nil
+}
@@ -0,0 +1,77 @@
+package netcheck
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+// fakeConn is a connected socket reporting a fixed local address
+type fakeConn struct {
+	net.Conn
+	local net.Addr
+}
+
+func (c fakeConn) LocalAddr() net.Addr { return c.local }
+func (c fakeConn) Close() error        { return nil }
+
+func withDial(t *testing.T, fn func(network, address string) (net.Conn, error)) {
+	t.Helper()
+	orig := dial
+	dial = fn
+	t.Cleanup(func() { dial = orig })
+}
+
+func TestCheckIPv6Route(t *testing.T) {
+	const target = "2a03:1b20:3:f011::a07f"
+
+	t.Run("Global source address", func(t *testing.T) {
+		withDial(t, func(network, address string) (net.Conn, error) {
+			if network != "udp6" || address != "["+target+"]:53" {
+				t.Errorf("Unexpected dial %s %s", network, address)
+			}
+			return fakeConn{local: &net.UDPAddr{IP: net.ParseIP("2001:db8::10")}}, nil
+		})
+
+		if err := CheckIPv6Route(target); err != nil {
+			t.Errorf("Expected no error, got: %v", err)
+		}
+	})
+
+	t.Run("Dial failure", func(t *testing.T) {
+		withDial(t, func(string, string) (net.Conn, error) {
+			return nil, errors.New("connect: network is unreachable")
+		})
+
+		err := CheckIPv6Route(target)
+		if !errors.Is(err, ErrNoIPv6Route) {
+			t.Errorf("Expected ErrNoIPv6Route, got: %v", err)
+		}
+	})
+
+	t.Run("Link-local source address", func(t *testing.T) {
+		withDial(t, func(string, string) (net.Conn, error) {
+			return fakeConn{local: &net.UDPAddr{IP: net.ParseIP("fe80::1")}}, nil
+		})
+
+		err := CheckIPv6Route(target)
+		if !errors.Is(err, ErrNoIPv6Route) {
+			t.Errorf("Expected ErrNoIPv6Route, got: %v", err)
+		}
+	})
+
+	t.Run("Loopback target", func(t *testing.T) {
+		// Whether or not the host has IPv6, the loopback source is never a global route
+		if err := CheckIPv6Route("::1"); !errors.Is(err, ErrNoIPv6Route) {
+			t.Errorf("Expected ErrNoIPv6Route, got: %v", err)
+		}
+	})
+}
+
+func TestCheckIPv6RouteRejectsNonIPv6(t *testing.T) {
+	for _, addr := range []string{"", "example.com", "192.0.2.1"} {
+		if err := CheckIPv6Route(addr); err == nil || errors.Is(err, ErrNoIPv6Route) {
+			t.Errorf("Expected invalid address error for %q, got: %v", addr, err)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+// Package netguard enforces --offline and restricts the probe targets. Contexts marked offline forbid every network
+// call other than the probes, and the HTTP transport installed by Install refuses requests made with them, so that a
+// feature reaching the network fails instead of silently contacting a server. CheckTarget keeps the probes to hosts
+// on the internet.
+package netguard
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/Ch00k/mullvad-compass/internal/errs"
+)
+
+type offlineKey struct{}
+
+// WithOffline returns a context that forbids network access other than the probes
+func WithOffline(ctx context.Context) context.Context {
+	return context.WithValue(ctx, offlineKey{}, true)
+}
+
+// Offline reports whether the context forbids network access other than the probes
+func Offline(ctx context.Context) bool {
+	offline, _ := ctx.Value(offlineKey{}).(bool)
+	return offline
+}
+
+// Check returns an error wrapping errs.ErrOffline when the context forbids network access, naming the host that
+// was about to be contacted
+func Check(ctx context.Context, host string) error {
+	if Offline(ctx) {
+		return fmt.Errorf("cannot contact %s: %w", host, errs.ErrOffline)
+	}
+	return nil
+}
+
+var installOnce sync.Once
+
+// Install wraps http.DefaultTransport, which every HTTP client of mullvad-compass uses, so that requests are
+// checked against their context. It is safe to call more than once.
+func Install() {
+	installOnce.Do(func() {
+		http.DefaultTransport = guardedTransport{base: http.DefaultTransport}
+	})
+}
+
+// guardedTransport refuses requests whose context forbids network access
+type guardedTransport struct {
+	base http.RoundTripper
+}
+
+// RoundTrip sends the request through the base transport unless its context forbids network access
+func (t guardedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := Check(req.Context(), req.URL.Host); err != nil {
+		return nil, err
+	}
+	return t.base.RoundTrip(req)
+}
+
+// CheckTarget returns an error wrapping errs.ErrRestrictedTarget when ip is loopback, link-local, multicast,
+// unspecified or the broadcast address, none of which a latency probe has a reason to reach. Private networks such
+// as a home router are allowed. Hostnames must be resolved once and the checked address probed, so that a DNS answer
+// cannot change between the check and the probe.
+func CheckTarget(ip net.IP) error {
+	var kind string
+	switch {
+	case ip.IsLoopback():
+		kind = "loopback"
+	case ip.IsLinkLocalUnicast(), ip.IsLinkLocalMulticast():
+		kind = "link-local"
+	case ip.IsMulticast():
+		kind = "multicast"
+	case ip.IsUnspecified():
+		kind = "unspecified"
+	case ip.Equal(net.IPv4bcast):
+		kind = "broadcast"
+	default:
+		return nil
+	}
+	return fmt.Errorf("%s is a %s address, %w", ip, kind, errs.ErrRestrictedTarget)
+}
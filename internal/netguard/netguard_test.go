@@ -0,0 +1,79 @@
+package netguard
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Ch00k/mullvad-compass/internal/errs"
+)
+
+func TestInstall(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		requests++
+	}))
+	defer server.Close()
+
+	Install()
+	Install()
+
+	get := func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err == nil {
+			_ = resp.Body.Close()
+		}
+		return err
+	}
+
+	if err := get(WithOffline(context.Background())); !errors.Is(err, errs.ErrOffline) {
+		t.Errorf("Expected an offline error, got %v", err)
+	}
+	if requests != 0 {
+		t.Errorf("Expected no request to reach the server offline, got %d", requests)
+	}
+
+	if err := get(context.Background()); err != nil {
+		t.Errorf("Expected the request to succeed online, got %v", err)
+	}
+	if requests != 1 {
+		t.Errorf("Expected 1 request to reach the server, got %d", requests)
+	}
+}
+
+func TestCheckTarget(t *testing.T) {
+	tests := []struct {
+		addr       string
+		restricted bool
+	}{
+		{"185.213.154.66", false},
+		{"2a03:1b20:1:f011::a01f", false},
+		{"192.168.1.1", false},
+		{"10.64.0.1", false},
+		{"127.0.0.1", true},
+		{"::1", true},
+		{"169.254.169.254", true},
+		{"fe80::1", true},
+		{"224.0.0.1", true},
+		{"ff02::1", true},
+		{"0.0.0.0", true},
+		{"::", true},
+		{"255.255.255.255", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.addr, func(t *testing.T) {
+			err := CheckTarget(net.ParseIP(tt.addr))
+			if got := errors.Is(err, errs.ErrRestrictedTarget); got != tt.restricted {
+				t.Errorf("Expected restricted = %v, got %v", tt.restricted, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+// Package pcap writes packets in the libpcap capture file format, for offline analysis with tcpdump or Wireshark.
+package pcap
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// File header fields of a capture of raw IP packets with microsecond timestamps
+const (
+	magic        = 0xa1b2c3d4
+	versionMajor = 2
+	versionMinor = 4
+	snapLen      = 65535
+	linkTypeRaw  = 101 // Packets start with an IPv4 or IPv6 header
+)
+
+// IP header fields of the packets wrapped around ICMP messages
+const (
+	protocolICMP   = 1
+	protocolICMPv6 = 58
+	hopLimit       = 64
+)
+
+// Writer writes packets to a capture file. It is safe for concurrent use.
+type Writer struct {
+	mu  sync.Mutex
+	w   io.Writer
+	err error
+}
+
+// NewWriter writes the file header to w and returns a Writer appending packets to it
+func NewWriter(w io.Writer) (*Writer, error) {
+	header := make([]byte, 24)
+	binary.LittleEndian.PutUint32(header[0:], magic)
+	binary.LittleEndian.PutUint16(header[4:], versionMajor)
+	binary.LittleEndian.PutUint16(header[6:], versionMinor)
+	// Bytes 8-15 are the time zone offset and timestamp accuracy, always zero
+	binary.LittleEndian.PutUint32(header[16:], snapLen)
+	binary.LittleEndian.PutUint32(header[20:], linkTypeRaw)
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+	return &Writer{w: w}, nil
+}
+
+// WritePacket appends a raw IP packet captured at t. After a failed write, every later write is skipped and
+// returns the same error.
+func (w *Writer) WritePacket(t time.Time, packet []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.err != nil {
+		return w.err
+	}
+
+	record := make([]byte, 16, 16+len(packet))
+	binary.LittleEndian.PutUint32(record[0:], uint32(t.Unix()))
+	binary.LittleEndian.PutUint32(record[4:], uint32(t.Nanosecond()/1000))
+	binary.LittleEndian.PutUint32(record[8:], uint32(len(packet)))
+	binary.LittleEndian.PutUint32(record[12:], uint32(len(packet)))
+	record = append(record, packet...)
+
+	_, w.err = w.w.Write(record)
+	return w.err
+}
+
+// WriteICMP appends an ICMP or ICMPv6 message sent from src to dst, wrapped in the IP header the kernel would have
+// added. Datagram ICMP sockets hand over messages without their IP header, so the header is synthesized; a nil or
+// unspecified src is recorded as the unspecified address.
+func (w *Writer) WriteICMP(t time.Time, src, dst net.IP, message []byte) error {
+	if dst.To4() != nil {
+		return w.WritePacket(t, ipv4Packet(src, dst, message))
+	}
+	return w.WritePacket(t, ipv6Packet(src, dst, message))
+}
+
+// Err returns the error of the first failed write, if any
+func (w *Writer) Err() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
+
+// ipv4Packet wraps an ICMP message in an IPv4 header
+func ipv4Packet(src, dst net.IP, message []byte) []byte {
+	src4 := src.To4()
+	if src4 == nil {
+		src4 = net.IPv4zero.To4()
+	}
+
+	packet := make([]byte, 20, 20+len(message))
+	packet[0] = 0x45 // Version 4, 5 words of header
+	binary.BigEndian.PutUint16(packet[2:], uint16(len(packet)+len(message)))
+	packet[8] = hopLimit
+	packet[9] = protocolICMP
+	copy(packet[12:16], src4)
+	copy(packet[16:20], dst.To4())
+	binary.BigEndian.PutUint16(packet[10:], checksum(packet))
+	return append(packet, message...)
+}
+
+// ipv6Packet wraps an ICMPv6 message in an IPv6 header
+func ipv6Packet(src, dst net.IP, message []byte) []byte {
+	src16 := src.To16()
+	if src16 == nil {
+		src16 = net.IPv6unspecified
+	}
+
+	packet := make([]byte, 40, 40+len(message))
+	packet[0] = 0x60 // Version 6
+	binary.BigEndian.PutUint16(packet[4:], uint16(len(message)))
+	packet[6] = protocolICMPv6
+	packet[7] = hopLimit
+	copy(packet[8:24], src16)
+	copy(packet[24:40], dst.To16())
+	return append(packet, message...)
+}
+
+// checksum computes the Internet checksum of an IPv4 header
+func checksum(header []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(header); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(header[i:]))
+	}
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	return ^uint16(sum)
+}
@@ -0,0 +1,120 @@
+package pcap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter failed: %v", err)
+	}
+
+	header := buf.Bytes()
+	if len(header) != 24 {
+		t.Fatalf("Expected a 24 byte file header, got %d bytes", len(header))
+	}
+	if got := binary.LittleEndian.Uint32(header[0:]); got != magic {
+		t.Errorf("Magic = %#x, want %#x", got, magic)
+	}
+	if got := binary.LittleEndian.Uint32(header[20:]); got != linkTypeRaw {
+		t.Errorf("Link type = %d, want %d", got, linkTypeRaw)
+	}
+
+	at := time.Unix(1700000000, 123456000)
+	message := []byte{8, 0, 0, 0, 0, 1, 0, 1}
+	if err := w.WriteICMP(at, nil, net.ParseIP("10.0.0.1"), message); err != nil {
+		t.Fatalf("WriteICMP failed: %v", err)
+	}
+
+	record := buf.Bytes()[24:]
+	if got := binary.LittleEndian.Uint32(record[0:]); got != 1700000000 {
+		t.Errorf("Seconds = %d, want 1700000000", got)
+	}
+	if got := binary.LittleEndian.Uint32(record[4:]); got != 123456 {
+		t.Errorf("Microseconds = %d, want 123456", got)
+	}
+	if got := binary.LittleEndian.Uint32(record[8:]); got != 28 {
+		t.Errorf("Captured length = %d, want 28", got)
+	}
+
+	packet := record[16:]
+	if packet[0] != 0x45 || packet[9] != protocolICMP {
+		t.Errorf("Expected an IPv4 ICMP header, got % x", packet[:20])
+	}
+	if !net.IP(packet[16:20]).Equal(net.ParseIP("10.0.0.1")) {
+		t.Errorf("Destination = %v, want 10.0.0.1", net.IP(packet[16:20]))
+	}
+	if checksum(packet[:20]) != 0 {
+		t.Error("Expected a valid IPv4 header checksum")
+	}
+	if !bytes.Equal(packet[20:], message) {
+		t.Errorf("Payload = % x, want % x", packet[20:], message)
+	}
+}
+
+func TestWriterIPv6(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter failed: %v", err)
+	}
+
+	message := []byte{128, 0, 0, 0, 0, 1, 0, 1}
+	if err := w.WriteICMP(time.Now(), net.ParseIP("2001:db8::1"), net.ParseIP("2001:db8::2"), message); err != nil {
+		t.Fatalf("WriteICMP failed: %v", err)
+	}
+
+	packet := buf.Bytes()[24+16:]
+	if len(packet) != 40+len(message) {
+		t.Fatalf("Expected a %d byte packet, got %d", 40+len(message), len(packet))
+	}
+	if packet[0]>>4 != 6 || packet[6] != protocolICMPv6 {
+		t.Errorf("Expected an IPv6 ICMPv6 header, got % x", packet[:40])
+	}
+	if got := binary.BigEndian.Uint16(packet[4:]); got != uint16(len(message)) {
+		t.Errorf("Payload length = %d, want %d", got, len(message))
+	}
+	if !net.IP(packet[8:24]).Equal(net.ParseIP("2001:db8::1")) {
+		t.Errorf("Source = %v, want 2001:db8::1", net.IP(packet[8:24]))
+	}
+}
+
+// failingWriter accepts the file header and fails every later write
+type failingWriter struct {
+	writes int
+}
+
+func (f *failingWriter) Write(p []byte) (int, error) {
+	f.writes++
+	if f.writes > 1 {
+		return 0, errors.New("disk full")
+	}
+	return len(p), nil
+}
+
+func TestWriterKeepsFirstError(t *testing.T) {
+	fw := &failingWriter{}
+	w, err := NewWriter(fw)
+	if err != nil {
+		t.Fatalf("NewWriter failed: %v", err)
+	}
+
+	for range 3 {
+		if err := w.WritePacket(time.Now(), []byte{0x45}); err == nil {
+			t.Fatal("Expected a write error")
+		}
+	}
+	if fw.writes != 2 {
+		t.Errorf("Expected writes to stop after the first failure, got %d writes", fw.writes)
+	}
+	if w.Err() == nil {
+		t.Error("Expected Err to report the failed write")
+	}
+}
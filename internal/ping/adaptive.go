@@ -0,0 +1,114 @@
+package ping
+
+import (
+	"context"
+	"log"
+	"math"
+	"slices"
+
+	"github.com/Ch00k/mullvad-compass/internal/logging"
+	"github.com/Ch00k/mullvad-compass/internal/relays"
+)
+
+// AutoTimeout is a timeout that is tuned to the network: a first wave of addresses is pinged with a generous
+// timeout, and the rest with twice the p99 latency of the first wave
+const AutoTimeout = 0
+
+const (
+	autoWaveSize    = 32   // Addresses pinged in the first wave
+	autoWaveTimeout = 2000 // Timeout of the first wave in milliseconds
+	minTimeout      = 100  // Shortest tuned timeout in milliseconds
+	maxTimeout      = 5000 // Longest tuned timeout in milliseconds
+)
+
+// locationsAdaptive pings a first wave of addresses with a generous timeout, then the rest with a timeout derived
+// from the latencies of the first wave
+func locationsAdaptive(
+	ctx context.Context,
+	locations []relays.Location,
+	workers int,
+	ipVersion relays.IPVersion,
+	pinger Pinger,
+	logLevel logging.LogLevel,
+	o options,
+) ([]relays.Location, error) {
+	whole := o.offset(0, len(locations))
+	groups := relays.GroupByAddress(locations, ipVersion)
+	if len(groups) <= autoWaveSize {
+		return LocationsWithPinger(ctx, locations, autoWaveTimeout, workers, ipVersion, pinger, logLevel, whole)
+	}
+
+	// Draw the first wave in queue order, so that a shuffled order samples the whole list
+	inWave := make([]bool, len(locations))
+	for _, g := range queueOrder(ctx, len(groups))[:autoWaveSize] {
+		for _, idx := range groups[g] {
+			inWave[idx] = true
+		}
+	}
+	var wave, rest []relays.Location
+	for i, loc := range locations {
+		if inWave[i] {
+			wave = append(wave, loc)
+		} else {
+			rest = append(rest, loc)
+		}
+	}
+
+	waveResults, err := LocationsWithPinger(
+		ctx,
+		wave,
+		autoWaveTimeout,
+		workers,
+		ipVersion,
+		pinger,
+		logLevel,
+		whole,
+	)
+	if err != nil || foundGoodEnough(ctx, waveResults) {
+		return waveResults, err
+	}
+
+	timeout := tuneTimeout(waveResults)
+	if logLevel <= logging.LogLevelInfo {
+		log.Printf("Tuned timeout to %dms from %d servers of the first wave", timeout, len(waveResults))
+	}
+
+	// A first wave of nearby servers must not cut off the distant ones
+	if expected := int(math.Ceil(FarthestExpectedRTT(rest))); timeout < expected {
+		timeout = min(expected, maxTimeout)
+		if logLevel <= logging.LogLevelInfo {
+			log.Printf("Raised timeout to %dms for the most distant servers", timeout)
+		}
+	}
+
+	restResults, err := LocationsWithPinger(
+		ctx,
+		rest,
+		timeout,
+		workers,
+		ipVersion,
+		pinger,
+		logLevel,
+		o.offset(len(waveResults), len(locations)),
+	)
+	return append(waveResults, restResults...), err
+}
+
+// tuneTimeout returns twice the p99 latency of the reachable locations in milliseconds, within the allowed
+// timeout range. Without any reachable location, the first wave timeout is kept.
+func tuneTimeout(locations []relays.Location) int {
+	var latencies []float64
+	for _, loc := range locations {
+		if loc.Latency != nil {
+			latencies = append(latencies, *loc.Latency)
+		}
+	}
+	if len(latencies) == 0 {
+		return autoWaveTimeout
+	}
+
+	slices.Sort(latencies)
+	rank := int(math.Ceil(0.99 * float64(len(latencies))))
+	timeout := int(math.Ceil(2 * latencies[rank-1]))
+	return min(max(timeout, minTimeout), maxTimeout)
+}
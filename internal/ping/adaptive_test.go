@@ -0,0 +1,143 @@
+package ping
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Ch00k/mullvad-compass/internal/logging"
+	"github.com/Ch00k/mullvad-compass/internal/relays"
+)
+
+func TestTuneTimeout(t *testing.T) {
+	latency := func(ms float64) relays.Location {
+		return relays.Location{Latency: &ms}
+	}
+
+	tests := []struct {
+		name      string
+		locations []relays.Location
+		want      int
+	}{
+		{"Twice the p99", []relays.Location{latency(40), latency(90.2), {}, latency(60)}, 181},
+		{"Raised to the minimum", []relays.Location{latency(5), latency(12)}, minTimeout},
+		{"Capped at the maximum", []relays.Location{latency(3000)}, maxTimeout},
+		{"Nothing reachable", []relays.Location{{}, {}}, autoWaveTimeout},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tuneTimeout(tt.locations); got != tt.want {
+				t.Errorf("tuneTimeout() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPingLocationsWithPinger_AutoTimeout(t *testing.T) {
+	var mu sync.Mutex
+	timeouts := make(map[time.Duration]int)
+
+	pinger := NewMockPinger()
+	pinger.PingFunc = func(_ context.Context, _ string, timeout time.Duration) *float64 {
+		mu.Lock()
+		timeouts[timeout]++
+		mu.Unlock()
+		latency := 75.0
+		return &latency
+	}
+
+	locations := make([]relays.Location, autoWaveSize+10)
+	for i := range locations {
+		locations[i] = relays.Location{IPv4Address: fmt.Sprintf("10.0.0.%d", i+1), Hostname: fmt.Sprintf("s%d", i+1)}
+	}
+
+	result, err := LocationsWithPinger(context.Background(),
+		locations,
+		AutoTimeout,
+		4,
+		relays.IPv4,
+		pinger, logging.LogLevelError,
+	)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if len(result) != len(locations) {
+		t.Fatalf("Expected %d results, got %d", len(locations), len(result))
+	}
+
+	wave := time.Duration(autoWaveTimeout) * time.Millisecond
+	tuned := 150 * time.Millisecond
+	if timeouts[wave] != autoWaveSize || timeouts[tuned] != 10 {
+		t.Errorf("Expected %d pings with %v and 10 with %v, got %v", autoWaveSize, wave, tuned, timeouts)
+	}
+}
+
+func TestPingLocationsWithPinger_AutoTimeoutRaisedForDistantServers(t *testing.T) {
+	var mu sync.Mutex
+	timeouts := make(map[time.Duration]int)
+
+	pinger := NewMockPinger()
+	pinger.PingFunc = func(_ context.Context, _ string, timeout time.Duration) *float64 {
+		mu.Lock()
+		timeouts[timeout]++
+		mu.Unlock()
+		latency := 20.0
+		return &latency
+	}
+
+	// The first wave is nearby, the rest is 15000 km away
+	locations := make([]relays.Location, autoWaveSize+10)
+	for i := range locations {
+		km := 300.0
+		if i >= autoWaveSize {
+			km = 15000
+		}
+		locations[i] = relays.Location{
+			IPv4Address:            fmt.Sprintf("10.0.0.%d", i+1),
+			Hostname:               fmt.Sprintf("s%d", i+1),
+			DistanceFromMyLocation: &km,
+		}
+	}
+
+	_, err := LocationsWithPinger(context.Background(),
+		locations,
+		AutoTimeout,
+		4,
+		relays.IPv4,
+		pinger, logging.LogLevelError,
+	)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	raised := 300 * time.Millisecond
+	if timeouts[raised] != 10 {
+		t.Errorf("Expected the 10 distant servers to be pinged with %v, got %v", raised, timeouts)
+	}
+}
+
+func TestPingLocationsWithPinger_AutoTimeoutSmallList(t *testing.T) {
+	pinger := NewMockPinger()
+	locations := []relays.Location{
+		{IPv4Address: "1.1.1.1", Hostname: "server1"},
+		{IPv4Address: "2.2.2.2", Hostname: "server2"},
+	}
+
+	if _, err := LocationsWithPinger(context.Background(),
+		locations,
+		AutoTimeout,
+		25,
+		relays.IPv4,
+		pinger, logging.LogLevelError,
+	); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	for _, call := range pinger.GetPingCalls() {
+		if call.Timeout != time.Duration(autoWaveTimeout)*time.Millisecond {
+			t.Errorf("Expected a list smaller than the first wave to use the wave timeout, got %v", call.Timeout)
+		}
+	}
+}
@@ -0,0 +1,22 @@
+package ping
+
+import (
+	"context"
+
+	"github.com/Ch00k/mullvad-compass/internal/pcap"
+)
+
+// captureKey is the context key for the packet capture
+type captureKey struct{}
+
+// WithCapture returns a context carrying a packet capture that pingers record their ICMP packets to.
+// Capturing is only supported where CaptureSupported is true.
+func WithCapture(ctx context.Context, capture *pcap.Writer) context.Context {
+	return context.WithValue(ctx, captureKey{}, capture)
+}
+
+// CaptureFromContext returns the packet capture carried by the context, or nil
+func CaptureFromContext(ctx context.Context) *pcap.Writer {
+	capture, _ := ctx.Value(captureKey{}).(*pcap.Writer)
+	return capture
+}
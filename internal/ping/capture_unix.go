@@ -0,0 +1,45 @@
+//go:build !windows
+
+package ping
+
+import (
+	"net"
+	"time"
+
+	"github.com/Ch00k/mullvad-compass/internal/pcap"
+)
+
+// captureConn records the ICMP messages sent and received on a socket to a packet capture
+type captureConn struct {
+	net.PacketConn
+	capture *pcap.Writer
+}
+
+// WriteTo sends a message and records it if it was sent
+func (c *captureConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	n, err := c.PacketConn.WriteTo(b, addr)
+	if err == nil {
+		_ = c.capture.WriteICMP(time.Now(), addrIP(c.LocalAddr()), addrIP(addr), b[:n])
+	}
+	return n, err
+}
+
+// ReadFrom receives a message and records it, including messages the socket manager discards
+func (c *captureConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, addr, err := c.PacketConn.ReadFrom(b)
+	if err == nil {
+		_ = c.capture.WriteICMP(time.Now(), addrIP(addr), addrIP(c.LocalAddr()), b[:n])
+	}
+	return n, addr, err
+}
+
+// addrIP returns the IP address of a socket address, or nil
+func addrIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		return a.IP
+	case *net.IPAddr:
+		return a.IP
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+//go:build !windows
+
+package ping
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+
+	"github.com/Ch00k/mullvad-compass/internal/pcap"
+)
+
+// loopbackConn is a PacketConn that hands every written message back to the next read
+type loopbackConn struct {
+	net.PacketConn
+	pending chan []byte
+}
+
+func (c *loopbackConn) WriteTo(b []byte, _ net.Addr) (int, error) {
+	c.pending <- bytes.Clone(b)
+	return len(b), nil
+}
+
+func (c *loopbackConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	msg := <-c.pending
+	return copy(b, msg), &net.UDPAddr{IP: net.ParseIP("10.0.0.1")}, nil
+}
+
+func (c *loopbackConn) LocalAddr() net.Addr {
+	return &net.UDPAddr{IP: net.IPv4zero}
+}
+
+func TestCaptureConn(t *testing.T) {
+	var buf bytes.Buffer
+	capture, err := pcap.NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter failed: %v", err)
+	}
+	conn := &captureConn{PacketConn: &loopbackConn{pending: make(chan []byte, 1)}, capture: capture}
+
+	message := []byte{8, 0, 0, 0, 0, 1, 0, 1}
+	if _, err := conn.WriteTo(message, &net.UDPAddr{IP: net.ParseIP("10.0.0.1")}); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+	if _, _, err := conn.ReadFrom(make([]byte, 1500)); err != nil {
+		t.Fatalf("ReadFrom failed: %v", err)
+	}
+
+	// File header, then two records of a 16 byte record header, a 20 byte IPv4 header and the message
+	if want := 24 + 2*(16+20+len(message)); buf.Len() != want {
+		t.Fatalf("Expected a %d byte capture, got %d bytes", want, buf.Len())
+	}
+	sent := buf.Bytes()[24+16:]
+	if !net.IP(sent[16:20]).Equal(net.ParseIP("10.0.0.1")) {
+		t.Errorf("Expected the request to be addressed to 10.0.0.1, got %v", net.IP(sent[16:20]))
+	}
+	received := buf.Bytes()[24+2*16+20+len(message):]
+	if !net.IP(received[12:16]).Equal(net.ParseIP("10.0.0.1")) {
+		t.Errorf("Expected the reply to come from 10.0.0.1, got %v", net.IP(received[12:16]))
+	}
+}
+
+func TestCaptureFromContext(t *testing.T) {
+	if CaptureFromContext(context.Background()) != nil {
+		t.Error("Expected no capture in a plain context")
+	}
+
+	capture, err := pcap.NewWriter(&bytes.Buffer{})
+	if err != nil {
+		t.Fatalf("NewWriter failed: %v", err)
+	}
+	if CaptureFromContext(WithCapture(context.Background(), capture)) != capture {
+		t.Error("Expected the capture carried by the context")
+	}
+}
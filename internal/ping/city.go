@@ -0,0 +1,66 @@
+package ping
+
+import (
+	"context"
+	"sync"
+
+	"github.com/Ch00k/mullvad-compass/internal/relays"
+	"github.com/Ch00k/mullvad-compass/internal/workpool"
+)
+
+// DefaultCityConcurrency is the number of addresses of a single city that are pinged at the same time. Relays of a
+// city usually share the uplink of one datacenter, so probing all of them at once would inflate their latencies
+// compared to cities with few relays.
+const DefaultCityConcurrency = 5
+
+// WithCityConcurrency limits the number of addresses of a single city that are pinged at the same time to n.
+// A limit of 0 or less pings any number of them at once.
+func WithCityConcurrency(n int) Option {
+	return func(o *options) {
+		o.cityConcurrency = n
+	}
+}
+
+// cityLimiter hands out a limited number of in-flight probes per city
+type cityLimiter struct {
+	limit int
+	mu    sync.Mutex
+	slots map[string]workpool.Semaphore
+}
+
+// newCityLimiter returns a limiter allowing limit probes per city, or nil for no limit
+func newCityLimiter(limit int) *cityLimiter {
+	if limit <= 0 {
+		return nil
+	}
+	return &cityLimiter{limit: limit, slots: make(map[string]workpool.Semaphore)}
+}
+
+// acquire waits for a free slot in the city of loc. Returns false if the context is done first.
+func (l *cityLimiter) acquire(ctx context.Context, loc *relays.Location) bool {
+	if l == nil {
+		return true
+	}
+	return l.citySlots(loc).Acquire(ctx)
+}
+
+// release frees the slot taken by acquire
+func (l *cityLimiter) release(loc *relays.Location) {
+	if l == nil {
+		return
+	}
+	l.citySlots(loc).Release()
+}
+
+// citySlots returns the slots of the city of loc, creating them on first use
+func (l *cityLimiter) citySlots(loc *relays.Location) workpool.Semaphore {
+	key := relays.CityKey(*loc)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	slots, ok := l.slots[key]
+	if !ok {
+		slots = workpool.NewSemaphore(l.limit)
+		l.slots[key] = slots
+	}
+	return slots
+}
@@ -0,0 +1,123 @@
+package ping
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Ch00k/mullvad-compass/internal/logging"
+	"github.com/Ch00k/mullvad-compass/internal/relays"
+)
+
+// inFlightPinger records the highest number of concurrent pings per city
+type inFlightPinger struct {
+	mu       sync.Mutex
+	cities   map[string]string // address -> city
+	inFlight map[string]int
+	peak     map[string]int
+}
+
+func (p *inFlightPinger) Ping(_ context.Context, ipAddr string, _ time.Duration) *float64 {
+	city := p.cities[ipAddr]
+	p.mu.Lock()
+	p.inFlight[city]++
+	p.peak[city] = max(p.peak[city], p.inFlight[city])
+	p.mu.Unlock()
+
+	time.Sleep(20 * time.Millisecond)
+
+	p.mu.Lock()
+	p.inFlight[city]--
+	p.mu.Unlock()
+	latency := 10.0
+	return &latency
+}
+
+func (p *inFlightPinger) Close() error {
+	return nil
+}
+
+func TestPingLocationsWithPinger_CityConcurrency(t *testing.T) {
+	cities := map[string]string{}
+	var locations []relays.Location
+	for _, city := range []string{"Stockholm", "Malmö"} {
+		for i := range 12 {
+			loc := relays.Location{
+				Country:     "Sweden",
+				City:        city,
+				IPv4Address: fmt.Sprintf("10.%d.0.%d", len(city), i+1),
+				Hostname:    fmt.Sprintf("%s%d", city, i+1),
+			}
+			cities[loc.IPv4Address] = city
+			locations = append(locations, loc)
+		}
+	}
+
+	tests := []struct {
+		name string
+		opts []Option
+		want int
+	}{
+		{name: "Default", want: DefaultCityConcurrency},
+		{name: "Custom", opts: []Option{WithCityConcurrency(2)}, want: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pinger := &inFlightPinger{cities: cities, inFlight: map[string]int{}, peak: map[string]int{}}
+			result, err := LocationsWithPinger(
+				context.Background(),
+				locations,
+				500,
+				25,
+				relays.IPv4,
+				pinger,
+				logging.LogLevelError,
+				tt.opts...,
+			)
+			if err != nil {
+				t.Fatalf("Expected no error, got: %v", err)
+			}
+			if len(result) != len(locations) {
+				t.Fatalf("Expected %d results, got %d", len(locations), len(result))
+			}
+			for city, peak := range pinger.peak {
+				if peak > tt.want {
+					t.Errorf("Expected at most %d pings in flight in %s, got %d", tt.want, city, peak)
+				}
+			}
+		})
+	}
+
+	t.Run("Unlimited", func(t *testing.T) {
+		pinger := &inFlightPinger{cities: cities, inFlight: map[string]int{}, peak: map[string]int{}}
+		_, err := LocationsWithPinger(
+			context.Background(),
+			locations,
+			500,
+			25,
+			relays.IPv4,
+			pinger,
+			logging.LogLevelError,
+			WithCityConcurrency(0),
+		)
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if pinger.peak["Stockholm"] <= DefaultCityConcurrency {
+			t.Errorf("Expected more than %d pings in flight without a limit, got %d",
+				DefaultCityConcurrency, pinger.peak["Stockholm"])
+		}
+	})
+}
+
+func TestCityLimiter_CountrySpellings(t *testing.T) {
+	limiter := newCityLimiter(2)
+	a := relays.Location{Country: "Czech Republic", City: "Prague"}
+	b := relays.Location{Country: "czech  republic", City: "Prague"}
+	if limiter.citySlots(&a) != limiter.citySlots(&b) {
+		t.Error("Expected spellings of the same country to share the city's slots")
+	}
+}
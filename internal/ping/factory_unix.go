@@ -2,11 +2,17 @@
 
 package ping
 
-import "github.com/Ch00k/mullvad-compass/internal/relays"
+import (
+	"github.com/Ch00k/mullvad-compass/internal/pcap"
+	"github.com/Ch00k/mullvad-compass/internal/relays"
+)
+
+// CaptureSupported reports whether pingers can record their packets with WithCapture
+const CaptureSupported = true
 
 // createPlatformPinger creates a Unix-specific socket manager
-func createPlatformPinger(ipVersion relays.IPVersion) (Pinger, error) {
-	return newSocketManager(ipVersion)
+func createPlatformPinger(ipVersion relays.IPVersion, source Source, capture *pcap.Writer) (Pinger, error) {
+	return newSocketManagerWithCapture(ipVersion, source, capture)
 }
 
 // Ensure socketManager implements Pinger
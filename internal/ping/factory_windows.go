@@ -2,9 +2,17 @@
 
 package ping
 
-import "github.com/Ch00k/mullvad-compass/internal/relays"
+import (
+	"github.com/Ch00k/mullvad-compass/internal/logging"
+	"github.com/Ch00k/mullvad-compass/internal/pcap"
+	"github.com/Ch00k/mullvad-compass/internal/relays"
+)
 
-// createPlatformPinger creates a Windows-specific socket manager
-func createPlatformPinger(ipVersion relays.IPVersion) (Pinger, error) {
-	return newWindowsSocketManager(ipVersion)
+// CaptureSupported reports whether pingers can record their packets with WithCapture. The IP Helper API never
+// exposes the packets it sends and receives.
+const CaptureSupported = false
+
+// createPlatformPinger creates a Windows-specific socket manager. The capture is not supported and ignored.
+func createPlatformPinger(ipVersion relays.IPVersion, source Source, _ *pcap.Writer) (Pinger, error) {
+	return newWindowsSocketManagerWithSource(ipVersion, source, logging.LogLevelError)
 }
@@ -0,0 +1,38 @@
+package ping
+
+import (
+	"context"
+
+	"github.com/Ch00k/mullvad-compass/internal/relays"
+)
+
+type goodEnoughKey struct{}
+
+// WithGoodEnough returns a context that makes pings stop as soon as a location responds in less than threshold
+// milliseconds, cancelling the probes still outstanding. The locations answered until then are returned without
+// an error, so the caller ranks a partial result holding at least one good enough location.
+func WithGoodEnough(ctx context.Context, threshold float64) context.Context {
+	return context.WithValue(ctx, goodEnoughKey{}, threshold)
+}
+
+// goodEnoughThreshold returns the threshold carried by the context, if any
+func goodEnoughThreshold(ctx context.Context) (float64, bool) {
+	threshold, ok := ctx.Value(goodEnoughKey{}).(float64)
+	return threshold, ok
+}
+
+// isGoodEnough reports whether a latency is below the threshold carried by the context
+func isGoodEnough(ctx context.Context, latency *float64) bool {
+	threshold, ok := goodEnoughThreshold(ctx)
+	return ok && latency != nil && *latency < threshold
+}
+
+// foundGoodEnough reports whether any of the locations responded below the threshold carried by the context
+func foundGoodEnough(ctx context.Context, locations []relays.Location) bool {
+	for _, loc := range locations {
+		if isGoodEnough(ctx, loc.Latency) {
+			return true
+		}
+	}
+	return false
+}
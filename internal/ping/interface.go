@@ -4,6 +4,7 @@ import (
 	"context"
 	"time"
 
+	"github.com/Ch00k/mullvad-compass/internal/pcap"
 	"github.com/Ch00k/mullvad-compass/internal/relays"
 )
 
@@ -24,15 +25,29 @@ type PingerFactory interface {
 }
 
 // defaultPingerFactory is the production implementation
-type defaultPingerFactory struct{}
+type defaultPingerFactory struct {
+	source  Source
+	capture *pcap.Writer
+}
 
 // NewDefaultPingerFactory creates a new default pinger factory
 func NewDefaultPingerFactory() PingerFactory {
 	return &defaultPingerFactory{}
 }
 
+// NewPingerFactoryWithSource creates a pinger factory whose pingers send from the given interface or address
+func NewPingerFactoryWithSource(source Source) PingerFactory {
+	return &defaultPingerFactory{source: source}
+}
+
+// NewPingerFactoryWithCapture creates a pinger factory whose pingers send from the given interface or address and
+// record their packets to capture, unless it is nil
+func NewPingerFactoryWithCapture(source Source, capture *pcap.Writer) PingerFactory {
+	return &defaultPingerFactory{source: source, capture: capture}
+}
+
 // CreatePinger creates a platform-specific socket manager
 // Implementation is in platform-specific files (factory_*.go)
 func (f *defaultPingerFactory) CreatePinger(ipVersion relays.IPVersion) (Pinger, error) {
-	return createPlatformPinger(ipVersion)
+	return createPlatformPinger(ipVersion, f.source, f.capture)
 }
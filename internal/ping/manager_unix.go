@@ -4,12 +4,18 @@ package ping
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"net"
+	"os"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/Ch00k/mullvad-compass/internal/errs"
 	"github.com/Ch00k/mullvad-compass/internal/icmp"
+	"github.com/Ch00k/mullvad-compass/internal/logging"
+	"github.com/Ch00k/mullvad-compass/internal/pcap"
 	"github.com/Ch00k/mullvad-compass/internal/relays"
 	xicmp "golang.org/x/net/icmp"
 	"golang.org/x/net/ipv4"
@@ -24,11 +30,12 @@ type pingResponse struct {
 
 // socketManager manages shared ICMP sockets for IPv4 and IPv6
 type socketManager struct {
-	conn       *xicmp.PacketConn
+	conn       net.PacketConn
 	network    string
 	protocol   int
 	seqCounter atomic.Int32
-	inFlight   sync.Map // map[int]chan *pingResponse
+	inFlight   sync.Map  // map[int]chan *pingResponse
+	epoch      time.Time // Monotonic reference for send timestamps embedded in payloads
 	ctx        context.Context
 	cancel     context.CancelFunc
 	wg         sync.WaitGroup
@@ -36,10 +43,49 @@ type socketManager struct {
 
 // newSocketManager creates a new socket manager for the given IP version
 func newSocketManager(ipVersion relays.IPVersion) (*socketManager, error) {
-	conn, network, err := icmp.Listen(ipVersion)
+	return newSocketManagerWithSource(ipVersion, Source{})
+}
+
+// newSocketManagerWithSource creates a new socket manager sending from the given interface or address
+func newSocketManagerWithSource(ipVersion relays.IPVersion, source Source) (*socketManager, error) {
+	return newSocketManagerWithCapture(ipVersion, source, nil)
+}
+
+// newSocketManagerWithCapture creates a new socket manager sending from the given interface or address and
+// recording its packets to capture, unless it is nil
+func newSocketManagerWithCapture(
+	ipVersion relays.IPVersion,
+	source Source,
+	capture *pcap.Writer,
+) (*socketManager, error) {
+	var conn net.PacketConn
+	var network string
+	// A socket keeps the network namespace it was created in
+	err := inNetns(source.Netns, func() error {
+		var err error
+		if source.IsZero() {
+			conn, network, err = icmp.Listen(ipVersion)
+			return err
+		}
+		var addr net.IP
+		if source.Address != "" {
+			// An interface alone is bound with a socket option, leaving address selection to the kernel
+			if addr, err = source.resolve(ipVersion); err != nil {
+				return err
+			}
+		}
+		conn, network, err = icmp.ListenBound(ipVersion, addr, source.Interface, logging.LogLevelError)
+		return err
+	})
+	if errors.Is(err, os.ErrPermission) {
+		return nil, fmt.Errorf("%w: %w", errs.ErrPermission, err)
+	}
 	if err != nil {
 		return nil, err
 	}
+	if capture != nil {
+		conn = &captureConn{PacketConn: conn, capture: capture}
+	}
 
 	var protocol int
 	if ipVersion.IsIPv6() {
@@ -53,6 +99,7 @@ func newSocketManager(ipVersion relays.IPVersion) (*socketManager, error) {
 		conn:     conn,
 		network:  network,
 		protocol: protocol,
+		epoch:    time.Now(),
 		ctx:      ctx,
 		cancel:   cancel,
 	}
@@ -120,6 +167,14 @@ func (m *socketManager) reader() {
 			continue
 		}
 
+		// Only accept replies echoing our own payload for this sequence number, and measure RTT from the
+		// send timestamp it carries so that scheduling delays between reader and sender do not skew it
+		payloadSeq, sent, ok := parsePayload(echo.Data)
+		if !ok || payloadSeq != uint16(echo.Seq) {
+			continue
+		}
+		rtt := time.Since(m.epoch) - sent
+
 		// Extract peer IP
 		var peerIP net.IP
 		switch addr := peer.(type) {
@@ -135,7 +190,7 @@ func (m *socketManager) reader() {
 		if chInterface, ok := m.inFlight.LoadAndDelete(echo.Seq); ok {
 			ch := chInterface.(chan *pingResponse)
 			select {
-			case ch <- &pingResponse{peerIP: peerIP, rtt: 0}: // RTT will be calculated by sender
+			case ch <- &pingResponse{peerIP: peerIP, rtt: rtt}:
 			default:
 				// Channel full or closed, ignore
 			}
@@ -165,6 +220,8 @@ func (m *socketManager) Ping(ctx context.Context, ipAddr string, timeout time.Du
 	}()
 
 	// Build ICMP message
+	sent := time.Since(m.epoch)
+	payload := buildPayload(seq, sent)
 	var msg xicmp.Message
 	if m.protocol == protocolICMPv6 {
 		msg = xicmp.Message{
@@ -173,7 +230,7 @@ func (m *socketManager) Ping(ctx context.Context, ipAddr string, timeout time.Du
 			Body: &xicmp.Echo{
 				ID:   1,
 				Seq:  seq,
-				Data: []byte("mullvad-compass"),
+				Data: payload,
 			},
 		}
 	} else {
@@ -183,7 +240,7 @@ func (m *socketManager) Ping(ctx context.Context, ipAddr string, timeout time.Du
 			Body: &xicmp.Echo{
 				ID:   1,
 				Seq:  seq,
-				Data: []byte("mullvad-compass"),
+				Data: payload,
 			},
 		}
 	}
@@ -210,7 +267,6 @@ func (m *socketManager) Ping(ctx context.Context, ipAddr string, timeout time.Du
 	}
 
 	// Send ping
-	start := time.Now()
 	_, err = m.conn.WriteTo(msgBytes, dst)
 	if err != nil {
 		return nil
@@ -229,7 +285,7 @@ func (m *socketManager) Ping(ctx context.Context, ipAddr string, timeout time.Du
 		if !resp.peerIP.Equal(ip) {
 			return nil
 		}
-		latencyMs := float64(time.Since(start).Microseconds()) / 1000.0
+		latencyMs := float64(resp.rtt.Microseconds()) / 1000.0
 		return &latencyMs
 	case <-timer.C:
 		return nil
@@ -8,6 +8,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/Ch00k/mullvad-compass/internal/logging"
 	"github.com/Ch00k/mullvad-compass/internal/relays"
 )
 
@@ -227,71 +228,43 @@ func TestSocketManager(t *testing.T) {
 	})
 }
 
-func TestPingWorker(t *testing.T) {
-	t.Run("Worker processes work channel", func(t *testing.T) {
-		mgr, err := newSocketManager(relays.IPv4)
-		skipIfNoPermissions(t, err)
-		if err != nil {
-			t.Fatalf("Cannot create socket manager: %v", err)
-		}
-		defer func() { _ = mgr.Close() }()
-
-		workChan := make(chan *relays.Location, 2)
-		resultChan := make(chan Result, 2)
-
-		loc1 := &relays.Location{
-			IPv4Address: "127.0.0.1",
-			Hostname:    "test1",
-		}
-		loc2 := &relays.Location{
-			IPv4Address: "127.0.0.2",
-			Hostname:    "test2",
-		}
-
-		workChan <- loc1
-		workChan <- loc2
-		close(workChan)
-
-		pingTimeout := 500 * time.Millisecond
-		go pingWorker(context.Background(), workChan, resultChan, pingTimeout, mgr, relays.IPv4)
-
-		// Collect results with timeout
-		timeout := time.After(5 * time.Second)
-		results := make([]Result, 0, 2)
-
-	collect:
-		for {
-			select {
-			case result, ok := <-resultChan:
-				if !ok {
-					break collect
-				}
-				results = append(results, result)
-				if len(results) == 2 {
-					break collect
-				}
-			case <-timeout:
-				t.Fatal("Timeout waiting for ping results")
-			}
-		}
-
-		if len(results) != 2 {
-			t.Errorf("Expected 2 results, got %d", len(results))
-		}
-
-		// Verify results reference original locations
-		found1, found2 := false, false
-		for _, result := range results {
-			if result.Location == loc1 {
-				found1 = true
-			}
-			if result.Location == loc2 {
-				found2 = true
-			}
-		}
-
-		if !found1 || !found2 {
-			t.Error("Results should reference original locations")
-		}
-	})
+func TestLocationsWithSocketManager(t *testing.T) {
+	mgr, err := newSocketManager(relays.IPv4)
+	skipIfNoPermissions(t, err)
+	if err != nil {
+		t.Fatalf("Cannot create socket manager: %v", err)
+	}
+	defer func() { _ = mgr.Close() }()
+
+	locations := []relays.Location{
+		{IPv4Address: "127.0.0.1", Hostname: "test1"},
+		{IPv4Address: "127.0.0.2", Hostname: "test2"},
+	}
+
+	results, err := LocationsWithPinger(
+		context.Background(),
+		locations,
+		500,
+		2,
+		relays.IPv4,
+		mgr,
+		logging.LogLevelError,
+	)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("Expected 2 results, got %d", len(results))
+	}
+
+	// Results arrive in completion order, and the latencies are also set on the input locations
+	hostnames := map[string]bool{results[0].Hostname: true, results[1].Hostname: true}
+	if !hostnames["test1"] || !hostnames["test2"] {
+		t.Errorf("Expected a result for each location, got %v", hostnames)
+	}
+	for _, loc := range locations {
+		if loc.Latency == nil {
+			t.Errorf("Expected %s to respond on loopback", loc.Hostname)
+		}
+	}
 }
@@ -19,6 +19,7 @@ import (
 type windowsSocketManager struct {
 	handle    icmp.Handle
 	ipVersion relays.IPVersion
+	source    net.IP // nil lets Windows select the source address
 	closed    bool
 	mu        sync.Mutex
 }
@@ -30,8 +31,25 @@ func newWindowsSocketManager(ipVersion relays.IPVersion) (*windowsSocketManager,
 
 // newWindowsSocketManagerWithLogLevel creates a new Windows socket manager with logging
 func newWindowsSocketManagerWithLogLevel(ipVersion relays.IPVersion, logLevel logging.LogLevel) (*windowsSocketManager, error) {
+	return newWindowsSocketManagerWithSource(ipVersion, Source{}, logLevel)
+}
+
+// newWindowsSocketManagerWithSource creates a new Windows socket manager sending from the given
+// interface or address. The ICMP API cannot bind to an interface, so an interface is resolved to its address.
+func newWindowsSocketManagerWithSource(
+	ipVersion relays.IPVersion,
+	source Source,
+	logLevel logging.LogLevel,
+) (*windowsSocketManager, error) {
+	srcAddr, err := source.Resolve(ipVersion)
+	if err != nil {
+		return nil, err
+	}
+	if srcAddr != nil && logLevel <= logging.LogLevelDebug {
+		log.Printf("Sending ICMP echo requests from %s", srcAddr)
+	}
+
 	var handle icmp.Handle
-	var err error
 
 	if ipVersion.IsIPv6() {
 		if logLevel <= logging.LogLevelDebug {
@@ -59,6 +77,7 @@ func newWindowsSocketManagerWithLogLevel(ipVersion relays.IPVersion, logLevel lo
 	return &windowsSocketManager{
 		handle:    handle,
 		ipVersion: ipVersion,
+		source:    srcAddr,
 	}, nil
 }
 
@@ -117,7 +136,13 @@ func (m *windowsSocketManager) pingIPv4(ip net.IP, timeout time.Duration) (*floa
 	}
 
 	// Send echo request
-	reply, err := icmp.IcmpSendEcho(m.handle, destAddr, nil, timeout)
+	var reply *icmp.IcmpEchoReply
+	var err error
+	if m.source != nil {
+		reply, err = icmp.IcmpSendEchoFrom(m.handle, icmp.IPv4ToUint32(m.source), destAddr, nil, timeout)
+	} else {
+		reply, err = icmp.IcmpSendEcho(m.handle, destAddr, nil, timeout)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -141,7 +166,7 @@ func (m *windowsSocketManager) pingIPv6(ip net.IP, timeout time.Duration) (*floa
 	}
 
 	// Send echo request
-	reply, err := icmp.Icmp6SendEcho2(m.handle, ipv6, nil, timeout)
+	reply, err := icmp.Icmp6SendEcho2From(m.handle, m.source, ipv6, nil, timeout)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,55 @@
+//go:build linux
+
+package ping
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"runtime"
+
+	"golang.org/x/sys/unix"
+)
+
+// NetnsSupported reports whether probes can be sent from a named network namespace
+const NetnsSupported = true
+
+// netnsDir is where "ip netns add" creates named network namespaces
+const netnsDir = "/run/netns"
+
+// inNetns runs fn on a thread that has entered the named network namespace, so that the sockets fn creates send
+// and receive in it for their whole lifetime. An empty name runs fn in the current namespace.
+func inNetns(name string, fn func() error) error {
+	if name == "" {
+		return fn()
+	}
+
+	target, err := unix.Open(filepath.Join(netnsDir, name), unix.O_RDONLY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return fmt.Errorf("unknown network namespace %s: %w", name, err)
+	}
+	defer func() { _ = unix.Close(target) }()
+
+	// The namespace belongs to the thread, which must not run other goroutines until it is restored
+	runtime.LockOSThread()
+	self := fmt.Sprintf("/proc/self/task/%d/ns/net", unix.Gettid())
+	origin, err := unix.Open(self, unix.O_RDONLY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		runtime.UnlockOSThread()
+		return fmt.Errorf("failed to open the current network namespace: %w", err)
+	}
+	defer func() { _ = unix.Close(origin) }()
+
+	if err := unix.Setns(target, unix.CLONE_NEWNET); err != nil {
+		runtime.UnlockOSThread()
+		return fmt.Errorf("failed to enter network namespace %s: %w", name, err)
+	}
+
+	fnErr := fn()
+	if err := unix.Setns(origin, unix.CLONE_NEWNET); err != nil {
+		// The thread stays locked, so the runtime retires it with its goroutine instead of reusing it
+		return errors.Join(fnErr, fmt.Errorf("failed to leave network namespace %s: %w", name, err))
+	}
+	runtime.UnlockOSThread()
+	return fnErr
+}
@@ -0,0 +1,16 @@
+//go:build !linux
+
+package ping
+
+import "fmt"
+
+// NetnsSupported reports whether probes can be sent from a named network namespace
+const NetnsSupported = false
+
+// inNetns runs fn, as network namespaces are Linux only. A non-empty name is an error.
+func inNetns(name string, fn func() error) error {
+	if name != "" {
+		return fmt.Errorf("network namespace %s: network namespaces are only supported on Linux", name)
+	}
+	return fn()
+}
@@ -0,0 +1,29 @@
+package ping
+
+import (
+	"context"
+	"math/rand/v2"
+)
+
+type orderSeedKey struct{}
+
+// WithShuffledOrder returns a context that makes pings queue their targets in an order shuffled with the seed.
+// Probing in relays.json order would concentrate losses from ICMP rate limiting on the countries listed first.
+func WithShuffledOrder(ctx context.Context, seed int64) context.Context {
+	return context.WithValue(ctx, orderSeedKey{}, seed)
+}
+
+// queueOrder returns the order in which n targets are queued: shuffled when the context carries a seed,
+// in input order otherwise
+func queueOrder(ctx context.Context, n int) []int {
+	seed, ok := ctx.Value(orderSeedKey{}).(int64)
+	if !ok {
+		order := make([]int, n)
+		for i := range order {
+			order[i] = i
+		}
+		return order
+	}
+	// A separate stream from sampling, so the probe order does not mirror which servers were sampled
+	return rand.New(rand.NewPCG(uint64(seed), 1)).Perm(n)
+}
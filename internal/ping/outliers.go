@@ -0,0 +1,67 @@
+package ping
+
+import (
+	"math"
+	"slices"
+
+	"github.com/Ch00k/mullvad-compass/internal/relays"
+)
+
+const (
+	// minOutlierPeers is the number of responding relays a city needs for its median to judge a relay by
+	minOutlierPeers = 3
+	// outlierFloor is the smallest excess over the city median in ms that counts as an outlier, as relays of a
+	// datacenter that answer within a millisecond of each other would otherwise flag normal jitter
+	outlierFloor = 5.0
+	// outlierDeviations is how many scaled median absolute deviations above the city median make an outlier
+	outlierDeviations = 3.0
+	// madScale makes the median absolute deviation comparable to a standard deviation for normal distributions
+	madScale = 1.4826
+)
+
+// CityOutliers returns the indices of the locations whose latency stands out from the relays of the same city: a
+// timeout, or a latency more than three scaled median absolute deviations and at least 5 ms above the city median.
+// Relays of a city share a datacenter, so such a result is more likely a lost or delayed packet than a slow relay.
+// Cities with fewer than three responding relays are not judged.
+func CityOutliers(locations []relays.Location) []int {
+	cities := make(map[string][]float64)
+	for _, loc := range locations {
+		if loc.Latency != nil {
+			key := relays.CityKey(loc)
+			cities[key] = append(cities[key], *loc.Latency)
+		}
+	}
+
+	thresholds := make(map[string]float64, len(cities))
+	for key, latencies := range cities {
+		if len(latencies) < minOutlierPeers {
+			continue
+		}
+		median := medianOf(latencies)
+		deviations := make([]float64, len(latencies))
+		for i, l := range latencies {
+			deviations[i] = math.Abs(l - median)
+		}
+		mad := medianOf(deviations) * madScale
+		thresholds[key] = median + math.Max(outlierDeviations*mad, outlierFloor)
+	}
+
+	var outliers []int
+	for i, loc := range locations {
+		threshold, ok := thresholds[relays.CityKey(loc)]
+		if ok && (loc.Latency == nil || *loc.Latency > threshold) {
+			outliers = append(outliers, i)
+		}
+	}
+	return outliers
+}
+
+// medianOf returns the median of a non-empty slice, averaging the middle two of an even count
+func medianOf(values []float64) float64 {
+	sorted := slices.Sorted(slices.Values(values))
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
@@ -0,0 +1,79 @@
+package ping
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/Ch00k/mullvad-compass/internal/relays"
+)
+
+func TestCityOutliers(t *testing.T) {
+	loc := func(city string, latency float64) relays.Location {
+		l := relays.Location{Country: "Germany", City: city}
+		if latency >= 0 {
+			l.Latency = &latency
+		}
+		return l
+	}
+	const timeout = -1
+	respelled := loc("Berlin", 10.2)
+	respelled.Country = "germany"
+
+	tests := []struct {
+		name      string
+		locations []relays.Location
+		want      []int
+	}{
+		{
+			name: "Delayed reply",
+			locations: []relays.Location{
+				loc("Berlin", 10), loc("Berlin", 10.5), loc("Berlin", 11), loc("Berlin", 48), loc("Berlin", 10.2),
+			},
+			want: []int{3},
+		},
+		{
+			name: "Lost reply",
+			locations: []relays.Location{
+				loc("Berlin", 10), loc("Berlin", timeout), loc("Berlin", 11), loc("Berlin", 10.2),
+			},
+			want: []int{1},
+		},
+		{
+			name: "Jitter within the floor",
+			locations: []relays.Location{
+				loc("Berlin", 10), loc("Berlin", 10.1), loc("Berlin", 10.2), loc("Berlin", 14),
+			},
+			want: nil,
+		},
+		{
+			name: "Too few peers",
+			locations: []relays.Location{
+				loc("Frankfurt", 10), loc("Frankfurt", 60), loc("Frankfurt", timeout),
+			},
+			want: nil,
+		},
+		{
+			name: "Cities judged separately",
+			locations: []relays.Location{
+				loc("Berlin", 10), loc("Berlin", 11), loc("Berlin", 12),
+				loc("Frankfurt", 40), loc("Frankfurt", 41), loc("Frankfurt", 42),
+			},
+			want: nil,
+		},
+		{
+			name: "Country spellings share a city",
+			locations: []relays.Location{
+				loc("Berlin", 10), respelled, loc("Berlin", 48),
+			},
+			want: []int{2},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := CityOutliers(tt.locations); !slices.Equal(got, tt.want) {
+				t.Errorf("Expected outliers %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,35 @@
+package ping
+
+import (
+	"bytes"
+	"encoding/binary"
+	"time"
+)
+
+// payloadMagic prefixes every echo request payload sent by mullvad-compass
+var payloadMagic = []byte("mullvad-compass")
+
+// payloadSize is the length of magic, 16-bit sequence number, and 64-bit send timestamp
+var payloadSize = len(payloadMagic) + 2 + 8
+
+// buildPayload encodes the sequence number and the monotonic send time (relative to the socket
+// manager's epoch) into an echo request payload
+func buildPayload(seq int, sent time.Duration) []byte {
+	payload := make([]byte, payloadSize)
+	n := copy(payload, payloadMagic)
+	binary.BigEndian.PutUint16(payload[n:], uint16(seq))
+	binary.BigEndian.PutUint64(payload[n+2:], uint64(sent))
+	return payload
+}
+
+// parsePayload decodes an echoed payload built by buildPayload.
+// Returns false if the payload was not produced by mullvad-compass.
+func parsePayload(payload []byte) (uint16, time.Duration, bool) {
+	if len(payload) != payloadSize || !bytes.HasPrefix(payload, payloadMagic) {
+		return 0, 0, false
+	}
+	n := len(payloadMagic)
+	seq := binary.BigEndian.Uint16(payload[n:])
+	sent := time.Duration(binary.BigEndian.Uint64(payload[n+2:]))
+	return seq, sent, true
+}
@@ -0,0 +1,52 @@
+package ping
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPayloadRoundTrip(t *testing.T) {
+	sent := 1234567 * time.Microsecond
+	payload := buildPayload(42, sent)
+
+	seq, gotSent, ok := parsePayload(payload)
+	if !ok {
+		t.Fatal("Expected payload to parse")
+	}
+	if seq != 42 {
+		t.Errorf("Expected sequence 42, got %d", seq)
+	}
+	if gotSent != sent {
+		t.Errorf("Expected send time %v, got %v", sent, gotSent)
+	}
+}
+
+func TestPayloadSequenceWraps(t *testing.T) {
+	seq, _, ok := parsePayload(buildPayload(65536+7, 0))
+	if !ok {
+		t.Fatal("Expected payload to parse")
+	}
+	if seq != 7 {
+		t.Errorf("Expected sequence to wrap to 7 like the ICMP header field, got %d", seq)
+	}
+}
+
+func TestParsePayloadRejectsForeignData(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload []byte
+	}{
+		{"Empty", nil},
+		{"Legacy payload without timestamp", []byte("mullvad-compass")},
+		{"Wrong magic", append([]byte("other-programme"), make([]byte, 10)...)},
+		{"Too long", append(buildPayload(1, 0), 0)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, ok := parsePayload(tt.payload); ok {
+				t.Error("Expected payload to be rejected")
+			}
+		})
+	}
+}
@@ -3,12 +3,16 @@ package ping
 
 import (
 	"context"
+	"fmt"
 	"log"
+	"runtime"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/Ch00k/mullvad-compass/internal/logging"
 	"github.com/Ch00k/mullvad-compass/internal/relays"
+	"github.com/Ch00k/mullvad-compass/internal/workpool"
 )
 
 const (
@@ -28,6 +32,7 @@ func Locations(
 	locations []relays.Location,
 	timeout, workers int,
 	ipVersion relays.IPVersion,
+	opts ...Option,
 ) ([]relays.Location, error) {
 	return LocationsWithFactory(
 		ctx,
@@ -37,6 +42,7 @@ func Locations(
 		ipVersion,
 		NewDefaultPingerFactory(),
 		logging.LogLevelError,
+		opts...,
 	)
 }
 
@@ -48,6 +54,7 @@ func LocationsWithFactory(
 	ipVersion relays.IPVersion,
 	factory PingerFactory,
 	logLevel logging.LogLevel,
+	opts ...Option,
 ) ([]relays.Location, error) {
 	if len(locations) == 0 {
 		return []relays.Location{}, nil
@@ -55,10 +62,10 @@ func LocationsWithFactory(
 
 	if logLevel <= logging.LogLevelInfo {
 		log.Printf(
-			"Starting to ping %d locations with %d workers (timeout: %dms, IP version: %s)",
+			"Starting to ping %d locations with %s workers (timeout: %s, IP version: %s)",
 			len(locations),
-			workers,
-			timeout,
+			formatWorkers(workers),
+			formatTimeout(timeout),
 			ipVersion,
 		)
 	}
@@ -77,63 +84,90 @@ func LocationsWithFactory(
 		log.Printf("Socket creation completed in %v", time.Since(start))
 	}
 
-	workChan := make(chan *relays.Location, len(locations))
-	resultChan := make(chan Result, len(locations))
-
-	to := time.Duration(timeout) * time.Millisecond
+	return LocationsWithPinger(ctx, locations, timeout, workers, ipVersion, pinger, logLevel, opts...)
+}
 
-	// Start worker pool (don't spin up more workers than locations)
-	numWorkers := workers
-	if numWorkers > len(locations) {
-		numWorkers = len(locations)
-	}
-	workerStart := time.Now()
-	var wg sync.WaitGroup
-	for i := 0; i < numWorkers; i++ {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			pingWorker(ctx, workChan, resultChan, to, pinger, ipVersion)
-		}()
+// LocationsWithPinger pings all locations using an existing pinger, which is left open for reuse
+func LocationsWithPinger(
+	ctx context.Context,
+	locations []relays.Location,
+	timeout, workers int,
+	ipVersion relays.IPVersion,
+	pinger Pinger,
+	logLevel logging.LogLevel,
+	opts ...Option,
+) ([]relays.Location, error) {
+	if len(locations) == 0 {
+		return []relays.Location{}, nil
 	}
-	if logLevel <= logging.LogLevelDebug {
-		log.Printf("Worker pool startup (%d workers) completed in %v", numWorkers, time.Since(workerStart))
+	o := newOptions(opts)
+	if timeout == AutoTimeout {
+		return locationsAdaptive(ctx, locations, workers, ipVersion, pinger, logLevel, o)
 	}
 
-	// Send locations to workers in a separate goroutine
-	go func() {
-		for i := range locations {
-			select {
-			case <-ctx.Done():
-				close(workChan)
-				return
-			case workChan <- &locations[i]:
-			}
+	// Relays sharing an address are pinged once and the result is given to all of them
+	groups := relays.GroupByAddress(locations, ipVersion)
+	if logLevel <= logging.LogLevelDebug && len(groups) < len(locations) {
+		logSharedAddresses(locations, groups, ipVersion)
+	}
+	if workers <= 0 {
+		workers = AutoWorkers(len(groups))
+		if logLevel <= logging.LogLevelDebug {
+			log.Printf(
+				"Using %d workers for %d addresses (%d CPUs, open file limit: %d)",
+				workers,
+				len(groups),
+				runtime.NumCPU(),
+				openFileLimit(),
+			)
 		}
-		close(workChan)
-	}()
+	}
 
-	// Wait for all workers to finish, then close results channel
-	go func() {
-		wg.Wait()
-		close(resultChan)
-	}()
+	limiter := newCityLimiter(o.cityConcurrency)
 
-	// Collect results
-	collectStart := time.Now()
+	// Each address is written to its own locations, so only the results in completion order need a lock
+	var mu sync.Mutex
 	results := make([]relays.Location, 0, len(locations))
 	var successCount, failCount int
-	for result := range resultChan {
-		result.Location.Latency = result.Latency
-		results = append(results, *result.Location)
-		if result.Latency != nil {
-			successCount++
-		} else {
-			failCount++
+
+	// A good enough result cancels the probes still outstanding, whose results are then dropped
+	pingCtx, stop := context.WithCancel(ctx)
+	defer stop()
+	var stopped bool
+
+	pingStart := time.Now()
+	order := queueOrder(ctx, len(groups))
+	_ = workpool.ForEach(pingCtx, len(order), workers, func(pingCtx context.Context, i int) error {
+		group := groups[order[i]]
+		to := locationTimeout(ctx, locations[group[0]], timeout)
+		latency, ok := pingAddress(pingCtx, &locations[group[0]], to, pinger, ipVersion, limiter)
+		if !ok {
+			return nil
 		}
-	}
+
+		mu.Lock()
+		defer mu.Unlock()
+		if stopped {
+			return nil
+		}
+		if isGoodEnough(ctx, latency) {
+			stopped = true
+			stop()
+		}
+		for _, idx := range group {
+			locations[idx].Latency = latency
+			results = append(results, locations[idx])
+			if latency != nil {
+				successCount++
+			} else {
+				failCount++
+			}
+			o.report(len(results), len(locations), Result{Location: &locations[idx], Latency: latency})
+		}
+		return nil
+	})
 	if logLevel <= logging.LogLevelDebug {
-		log.Printf("Result collection completed in %v", time.Since(collectStart))
+		log.Printf("Pinged %d addresses with up to %d workers in %v", len(groups), workers, time.Since(pingStart))
 	}
 
 	if logLevel <= logging.LogLevelInfo {
@@ -147,42 +181,49 @@ func LocationsWithFactory(
 		}
 		return results, ctx.Err()
 	}
+	if stopped && logLevel <= logging.LogLevelInfo {
+		log.Printf("Stopped at a good enough result after pinging %d of %d locations", len(results), len(locations))
+	}
 
 	return results, nil
 }
 
-// pingWorker processes locations from the work channel
-func pingWorker(
+// formatTimeout formats a timeout in milliseconds for logging
+func formatTimeout(timeout int) string {
+	if timeout == AutoTimeout {
+		return "auto"
+	}
+	return fmt.Sprintf("%dms", timeout)
+}
+
+// logSharedAddresses logs the hostnames behind every address shared by more than one location
+func logSharedAddresses(locations []relays.Location, groups [][]int, ipVersion relays.IPVersion) {
+	log.Printf("Pinging %d unique addresses for %d locations", len(groups), len(locations))
+	for _, group := range groups {
+		if len(group) < 2 {
+			continue
+		}
+		hostnames := make([]string, len(group))
+		for i, idx := range group {
+			hostnames[i] = locations[idx].Hostname
+		}
+		log.Printf("%s is shared by %s", locations[group[0]].Address(ipVersion), strings.Join(hostnames, ", "))
+	}
+}
+
+// pingAddress pings the address of a location, waiting for the limiter to allow another probe in its city.
+// Returns false if the context is done before the probe is sent.
+func pingAddress(
 	ctx context.Context,
-	workChan <-chan *relays.Location,
-	resultChan chan<- Result,
+	loc *relays.Location,
 	timeout time.Duration,
 	pinger Pinger,
 	ipVersion relays.IPVersion,
-) {
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case loc, ok := <-workChan:
-			if !ok {
-				return
-			}
-			var ipAddr string
-			if ipVersion.IsIPv6() {
-				ipAddr = loc.IPv6Address
-			} else {
-				ipAddr = loc.IPv4Address
-			}
-			latency := pinger.Ping(ctx, ipAddr, timeout)
-			select {
-			case <-ctx.Done():
-				return
-			case resultChan <- Result{
-				Location: loc,
-				Latency:  latency,
-			}:
-			}
-		}
+	limiter *cityLimiter,
+) (*float64, bool) {
+	if !limiter.acquire(ctx, loc) {
+		return nil, false
 	}
+	defer limiter.release(loc)
+	return pinger.Ping(ctx, loc.Address(ipVersion), timeout), true
 }
@@ -2,17 +2,18 @@ package ping
 
 import (
 	"context"
-	"strings"
+	"errors"
 	"testing"
 	"time"
 
+	"github.com/Ch00k/mullvad-compass/internal/errs"
 	"github.com/Ch00k/mullvad-compass/internal/relays"
 )
 
 // skipIfNoPermissions skips the test if the error indicates insufficient permissions
 func skipIfNoPermissions(t *testing.T, err error) {
 	t.Helper()
-	if err != nil && strings.Contains(err.Error(), "permission denied") {
+	if errors.Is(err, errs.ErrPermission) {
 		t.Skipf("Skipping test due to insufficient permissions: %v", err)
 	}
 }
@@ -3,6 +3,7 @@ package ping
 import (
 	"context"
 	"fmt"
+	"slices"
 	"testing"
 	"time"
 
@@ -345,3 +346,188 @@ func TestPingLocationsWithFactory_PingerClosed(t *testing.T) {
 		t.Error("Expected pinger to be closed")
 	}
 }
+
+func TestPingLocationsWithPinger_LeavesPingerOpen(t *testing.T) {
+	pinger := NewMockPinger()
+
+	locations := []relays.Location{
+		{IPv4Address: "1.1.1.1", Hostname: "server1"},
+		{IPv4Address: "2.2.2.2", Hostname: "server2"},
+	}
+
+	for i := 0; i < 2; i++ {
+		result, err := LocationsWithPinger(context.Background(),
+			locations,
+			500,
+			25,
+			relays.IPv4,
+			pinger, logging.LogLevelError,
+		)
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if len(result) != 2 {
+			t.Fatalf("Expected 2 results, got %d", len(result))
+		}
+	}
+
+	if pinger.IsClosed() {
+		t.Error("Expected pinger to stay open for reuse")
+	}
+	if got := pinger.GetPingCallCount(); got != 4 {
+		t.Errorf("Expected 4 pings across both runs, got %d", got)
+	}
+}
+
+func TestPingLocationsWithPinger_QueueOrder(t *testing.T) {
+	locations := make([]relays.Location, 8)
+	for i := range locations {
+		locations[i] = relays.Location{
+			IPv4Address: fmt.Sprintf("10.0.0.%d", i+1),
+			Hostname:    fmt.Sprintf("server%d", i+1),
+		}
+	}
+
+	pingOrder := func(ctx context.Context) []string {
+		t.Helper()
+		pinger := NewMockPinger()
+		_, err := LocationsWithPinger(ctx, locations, 500, 1, relays.IPv4, pinger, logging.LogLevelError)
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		var addrs []string
+		for _, call := range pinger.GetPingCalls() {
+			addrs = append(addrs, call.IPAddr)
+		}
+		return addrs
+	}
+
+	inOrder := pingOrder(context.Background())
+	for i, addr := range inOrder {
+		if addr != locations[i].IPv4Address {
+			t.Fatalf("Expected input order without a seed, got %v", inOrder)
+		}
+	}
+
+	shuffled := pingOrder(WithShuffledOrder(context.Background(), 42))
+	if again := pingOrder(WithShuffledOrder(context.Background(), 42)); !slices.Equal(shuffled, again) {
+		t.Errorf("Expected the same seed to give the same order, got %v and %v", shuffled, again)
+	}
+	if slices.Equal(shuffled, inOrder) {
+		t.Errorf("Expected seed 42 to shuffle the order, got %v", shuffled)
+	}
+	sorted := slices.Clone(shuffled)
+	slices.Sort(sorted)
+	if !slices.Equal(sorted, inOrder) {
+		t.Errorf("Expected every server to be pinged once, got %v", shuffled)
+	}
+}
+
+func TestPingLocationsWithPinger_TimeoutPerKm(t *testing.T) {
+	near, far := 100.0, 10000.0
+	locations := []relays.Location{
+		{IPv4Address: "10.0.0.1", Hostname: "near", DistanceFromMyLocation: &near},
+		{IPv4Address: "10.0.0.2", Hostname: "far", DistanceFromMyLocation: &far},
+		{IPv4Address: "10.0.0.3", Hostname: "unlocated"},
+	}
+
+	pinger := NewMockPinger()
+	ctx := WithTimeoutPerKm(context.Background(), 0.5)
+	if _, err := LocationsWithPinger(ctx, locations, 100, 1, relays.IPv4, pinger, logging.LogLevelError); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	want := map[string]time.Duration{
+		"10.0.0.1": 150 * time.Millisecond,
+		"10.0.0.2": 5000 * time.Millisecond, // Capped at the longest timeout
+		"10.0.0.3": 100 * time.Millisecond,
+	}
+	for _, call := range pinger.GetPingCalls() {
+		if call.Timeout != want[call.IPAddr] {
+			t.Errorf("Expected %s to be pinged with a %v timeout, got %v", call.IPAddr, want[call.IPAddr], call.Timeout)
+		}
+	}
+}
+
+func TestPingLocationsWithPinger_SharedAddress(t *testing.T) {
+	pinger := NewMockPinger()
+	pinger.PingFunc = func(_ context.Context, ipAddr string, _ time.Duration) *float64 {
+		latency := 12.5
+		if ipAddr == "2.2.2.2" {
+			latency = 20.0
+		}
+		return &latency
+	}
+
+	locations := []relays.Location{
+		{IPv4Address: "1.1.1.1", Hostname: "server1"},
+		{IPv4Address: "2.2.2.2", Hostname: "server2"},
+		{IPv4Address: "1.1.1.1", Hostname: "server3"},
+	}
+
+	result, err := LocationsWithPinger(context.Background(),
+		locations,
+		500,
+		25,
+		relays.IPv4,
+		pinger, logging.LogLevelError,
+	)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if len(result) != 3 {
+		t.Fatalf("Expected 3 results, got %d", len(result))
+	}
+	if got := pinger.GetPingCallCount(); got != 2 {
+		t.Errorf("Expected each unique address to be pinged once, got %d pings", got)
+	}
+
+	for _, loc := range result {
+		want := 12.5
+		if loc.IPv4Address == "2.2.2.2" {
+			want = 20.0
+		}
+		if loc.Latency == nil || *loc.Latency != want {
+			t.Errorf("Expected %s to have latency %v, got %v", loc.Hostname, want, loc.Latency)
+		}
+	}
+}
+
+func TestPingLocationsWithPinger_GoodEnough(t *testing.T) {
+	locations := make([]relays.Location, 8)
+	for i := range locations {
+		locations[i] = relays.Location{
+			IPv4Address: fmt.Sprintf("10.0.0.%d", i+1),
+			Hostname:    fmt.Sprintf("server%d", i+1),
+		}
+	}
+
+	// The third server is the first one under the threshold
+	pinger := NewMockPinger()
+	pinger.PingFunc = func(_ context.Context, ipAddr string, _ time.Duration) *float64 {
+		latency := 40.0
+		if ipAddr == "10.0.0.3" {
+			latency = 8
+		}
+		return &latency
+	}
+
+	ctx := WithGoodEnough(context.Background(), 20)
+	results, err := LocationsWithPinger(ctx, locations, 500, 1, relays.IPv4, pinger, logging.LogLevelError)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if len(results) != 3 || results[2].Hostname != "server3" {
+		t.Errorf("Expected the run to stop at server3, got %d results", len(results))
+	}
+	if calls := len(pinger.GetPingCalls()); calls != 3 {
+		t.Errorf("Expected no probes after the good enough result, got %d", calls)
+	}
+
+	// Without a result under the threshold every server is pinged
+	ctx = WithGoodEnough(context.Background(), 5)
+	results, err = LocationsWithPinger(ctx, locations, 500, 1, relays.IPv4, NewMockPinger(), logging.LogLevelError)
+	if err != nil || len(results) != len(locations) {
+		t.Errorf("Expected all %d servers to be pinged, got %d (error: %v)", len(locations), len(results), err)
+	}
+}
@@ -0,0 +1,82 @@
+package ping
+
+import (
+	"net/netip"
+	"slices"
+
+	"github.com/Ch00k/mullvad-compass/internal/relays"
+)
+
+const (
+	ipv4PrefixBits = 24
+	ipv6PrefixBits = 48
+)
+
+// PrefixTimeout describes a network prefix in which every pinged server timed out
+type PrefixTimeout struct {
+	Prefix  string `json:"prefix"`
+	Servers int    `json:"servers"`
+}
+
+// TimedOutPrefixes groups pinged locations by /24 (IPv4) or /48 (IPv6) prefix and returns the prefixes
+// with at least two servers where all of them timed out. Such a pattern suggests a block further up the
+// network path rather than individual servers being down.
+func TimedOutPrefixes(locations []relays.Location, ipVersion relays.IPVersion) []PrefixTimeout {
+	bits := ipv4PrefixBits
+	if ipVersion.IsIPv6() {
+		bits = ipv6PrefixBits
+	}
+
+	type group struct {
+		servers   int
+		reachable bool
+	}
+	groups := make(map[netip.Prefix]*group)
+
+	for _, loc := range locations {
+		ipAddr := loc.IPv4Address
+		if ipVersion.IsIPv6() {
+			ipAddr = loc.IPv6Address
+		}
+		addr, err := netip.ParseAddr(ipAddr)
+		if err != nil {
+			continue
+		}
+		prefix, err := addr.Prefix(bits)
+		if err != nil {
+			continue
+		}
+
+		g, ok := groups[prefix]
+		if !ok {
+			g = &group{}
+			groups[prefix] = g
+		}
+		g.servers++
+		if loc.Latency != nil {
+			g.reachable = true
+		}
+	}
+
+	var timedOut []PrefixTimeout
+	for prefix, g := range groups {
+		if g.servers >= 2 && !g.reachable {
+			timedOut = append(timedOut, PrefixTimeout{Prefix: prefix.String(), Servers: g.servers})
+		}
+	}
+
+	slices.SortFunc(timedOut, func(a, b PrefixTimeout) int {
+		if a.Servers != b.Servers {
+			return b.Servers - a.Servers
+		}
+		if a.Prefix < b.Prefix {
+			return -1
+		}
+		if a.Prefix > b.Prefix {
+			return 1
+		}
+		return 0
+	})
+
+	return timedOut
+}
@@ -0,0 +1,71 @@
+package ping
+
+import (
+	"testing"
+
+	"github.com/Ch00k/mullvad-compass/internal/relays"
+)
+
+func TestTimedOutPrefixes(t *testing.T) {
+	latency := 10.0
+
+	t.Run("Reports IPv4 /24 where every server timed out", func(t *testing.T) {
+		locations := []relays.Location{
+			{IPv4Address: "193.32.248.66"},
+			{IPv4Address: "193.32.248.67"},
+			{IPv4Address: "193.32.248.68"},
+			{IPv4Address: "178.249.209.162", Latency: &latency},
+			{IPv4Address: "178.249.209.175"},
+		}
+
+		got := TimedOutPrefixes(locations, relays.IPv4)
+		if len(got) != 1 {
+			t.Fatalf("Expected 1 timed out prefix, got %v", got)
+		}
+		if got[0].Prefix != "193.32.248.0/24" {
+			t.Errorf("Expected prefix 193.32.248.0/24, got %s", got[0].Prefix)
+		}
+		if got[0].Servers != 3 {
+			t.Errorf("Expected 3 servers, got %d", got[0].Servers)
+		}
+	})
+
+	t.Run("Ignores prefixes with a single server", func(t *testing.T) {
+		locations := []relays.Location{
+			{IPv4Address: "193.32.248.66"},
+			{IPv4Address: "178.249.209.162"},
+		}
+
+		if got := TimedOutPrefixes(locations, relays.IPv4); len(got) != 0 {
+			t.Errorf("Expected no prefixes, got %v", got)
+		}
+	})
+
+	t.Run("Groups IPv6 addresses by /48", func(t *testing.T) {
+		locations := []relays.Location{
+			{IPv6Address: "2a03:1b20:1:f011::a01f"},
+			{IPv6Address: "2a03:1b20:1:f410::a02f"},
+			{IPv6Address: "2a04:27c0:0:c::f001", Latency: &latency},
+			{IPv6Address: "2a04:27c0:0:d::f001"},
+		}
+
+		got := TimedOutPrefixes(locations, relays.IPv6)
+		if len(got) != 1 {
+			t.Fatalf("Expected 1 timed out prefix, got %v", got)
+		}
+		if got[0].Prefix != "2a03:1b20:1::/48" {
+			t.Errorf("Expected prefix 2a03:1b20:1::/48, got %s", got[0].Prefix)
+		}
+	})
+
+	t.Run("Skips unparseable addresses", func(t *testing.T) {
+		locations := []relays.Location{
+			{IPv4Address: "invalid"},
+			{IPv4Address: ""},
+		}
+
+		if got := TimedOutPrefixes(locations, relays.IPv4); len(got) != 0 {
+			t.Errorf("Expected no prefixes, got %v", got)
+		}
+	})
+}
@@ -0,0 +1,51 @@
+package ping
+
+// ProgressFunc receives the number of locations pinged so far, the total number of locations and the result of
+// the last location. It is called once per location from the goroutine that started the pings, so it must not
+// block for long.
+type ProgressFunc func(done, total int, last Result)
+
+// Option configures a ping run
+type Option func(*options)
+
+type options struct {
+	progress        ProgressFunc
+	cityConcurrency int
+}
+
+// WithProgress reports the progress of the run to fn after every result
+func WithProgress(fn ProgressFunc) Option {
+	return func(o *options) {
+		o.progress = fn
+	}
+}
+
+// newOptions applies opts to the defaults
+func newOptions(opts []Option) options {
+	o := options{cityConcurrency: DefaultCityConcurrency}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// report calls the progress function, if any
+func (o options) report(done, total int, last Result) {
+	if o.progress != nil {
+		o.progress(done, total, last)
+	}
+}
+
+// offset returns an Option that carries the options over to a part of a larger run and reports its progress
+// as starting after done of total locations
+func (o options) offset(done, total int) Option {
+	return func(part *options) {
+		*part = o
+		if o.progress == nil {
+			return
+		}
+		part.progress = func(partDone, _ int, last Result) {
+			o.progress(done+partDone, total, last)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package ping
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/Ch00k/mullvad-compass/internal/logging"
+	"github.com/Ch00k/mullvad-compass/internal/relays"
+)
+
+// recordProgress returns a ProgressFunc that checks every call and records its done count
+func recordProgress(t *testing.T, total int) (ProgressFunc, *[]int) {
+	t.Helper()
+	var done []int
+	return func(d, tot int, last Result) {
+		if tot != total {
+			t.Errorf("Expected total %d, got %d", total, tot)
+		}
+		if last.Location == nil || last.Latency == nil || last.Location.Latency != last.Latency {
+			t.Errorf("Expected the last result to carry its location and latency, got %+v", last)
+		}
+		done = append(done, d)
+	}, &done
+}
+
+func TestPingLocationsWithFactory_Progress(t *testing.T) {
+	locations := []relays.Location{
+		{IPv4Address: "1.1.1.1", Hostname: "server1"},
+		{IPv4Address: "2.2.2.2", Hostname: "server2"},
+		{IPv4Address: "1.1.1.1", Hostname: "server3"},
+	}
+	progress, done := recordProgress(t, len(locations))
+
+	_, err := LocationsWithFactory(context.Background(),
+		locations,
+		500,
+		25,
+		relays.IPv4,
+		NewMockPingerFactory(), logging.LogLevelError,
+		WithProgress(progress),
+	)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if want := []int{1, 2, 3}; !slices.Equal(*done, want) {
+		t.Errorf("Expected progress %v, got %v", want, *done)
+	}
+}
+
+func TestPingLocationsWithPinger_ProgressAutoTimeout(t *testing.T) {
+	locations := make([]relays.Location, autoWaveSize+5)
+	for i := range locations {
+		locations[i] = relays.Location{IPv4Address: fmt.Sprintf("10.0.0.%d", i+1), Hostname: fmt.Sprintf("s%d", i+1)}
+	}
+	progress, done := recordProgress(t, len(locations))
+
+	_, err := LocationsWithPinger(context.Background(),
+		locations,
+		AutoTimeout,
+		4,
+		relays.IPv4,
+		NewMockPinger(), logging.LogLevelError,
+		WithProgress(progress),
+	)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	if len(*done) != len(locations) {
+		t.Fatalf("Expected %d progress calls, got %d", len(locations), len(*done))
+	}
+	for i, d := range *done {
+		if d != i+1 {
+			t.Fatalf("Expected progress to count up across both waves, got %v", *done)
+		}
+	}
+}
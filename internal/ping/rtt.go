@@ -0,0 +1,43 @@
+package ping
+
+import (
+	"math"
+
+	"github.com/Ch00k/mullvad-compass/internal/relays"
+)
+
+const (
+	fiberKmPerMs = 200.0 // Light covers about 200 km per millisecond in optical fiber
+	routeStretch = 2.0   // Internet routes are typically about twice as long as the great circle
+)
+
+// ExpectedRTT returns the round trip time in milliseconds that a relay distanceKm away can plausibly answer in.
+// Replies from farther away than the timeout allows are likely lost to configuration rather than the relay.
+func ExpectedRTT(distanceKm float64) float64 {
+	return 2 * distanceKm * routeStretch / fiberKmPerMs
+}
+
+// FarthestExpectedRTT returns the expected round trip time of the farthest location, 0 when no location has a
+// distance set
+func FarthestExpectedRTT(locations []relays.Location) float64 {
+	var farthest float64
+	for _, loc := range locations {
+		if loc.DistanceFromMyLocation != nil {
+			farthest = math.Max(farthest, *loc.DistanceFromMyLocation)
+		}
+	}
+	return ExpectedRTT(farthest)
+}
+
+// TooFarForTimeout returns the number of locations whose expected round trip time exceeds their timeout: timeout
+// milliseconds, plus perKm for every km of their distance
+func TooFarForTimeout(locations []relays.Location, timeout int, perKm float64) int {
+	var count int
+	for _, loc := range locations {
+		if loc.DistanceFromMyLocation != nil &&
+			ExpectedRTT(*loc.DistanceFromMyLocation) > ScaledTimeout(loc, timeout, perKm) {
+			count++
+		}
+	}
+	return count
+}
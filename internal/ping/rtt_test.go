@@ -0,0 +1,54 @@
+package ping
+
+import (
+	"testing"
+
+	"github.com/Ch00k/mullvad-compass/internal/relays"
+)
+
+func TestExpectedRTT(t *testing.T) {
+	tests := []struct {
+		distance float64
+		want     float64
+	}{
+		{0, 0},
+		{500, 10},
+		{10000, 200},
+	}
+	for _, tt := range tests {
+		if got := ExpectedRTT(tt.distance); got != tt.want {
+			t.Errorf("ExpectedRTT(%.0f) = %.2f, want %.2f", tt.distance, got, tt.want)
+		}
+	}
+}
+
+func TestTooFarForTimeout(t *testing.T) {
+	at := func(km float64) relays.Location {
+		return relays.Location{DistanceFromMyLocation: &km}
+	}
+	locations := []relays.Location{at(100), at(9000), at(16000), {}}
+
+	if got := FarthestExpectedRTT(locations); got != 320 {
+		t.Errorf("FarthestExpectedRTT() = %.2f, want 320", got)
+	}
+	if got := FarthestExpectedRTT([]relays.Location{{}}); got != 0 {
+		t.Errorf("FarthestExpectedRTT() without distances = %.2f, want 0", got)
+	}
+
+	tests := []struct {
+		timeout int
+		perKm   float64
+		want    int
+	}{
+		{500, 0, 0},
+		{200, 0, 1},
+		{100, 0, 2},
+		{100, 0.01, 1},
+		{100, 0.02, 0},
+	}
+	for _, tt := range tests {
+		if got := TooFarForTimeout(locations, tt.timeout, tt.perKm); got != tt.want {
+			t.Errorf("TooFarForTimeout(%d, %g) = %d, want %d", tt.timeout, tt.perKm, got, tt.want)
+		}
+	}
+}
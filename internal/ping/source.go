@@ -0,0 +1,113 @@
+package ping
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/Ch00k/mullvad-compass/internal/relays"
+)
+
+// Source selects the interface and/or source address that probes are sent from, and on Linux the network
+// namespace they are sent in. The zero value leaves all of them to the operating system.
+type Source struct {
+	Interface string // Network interface name, e.g. "eth0"
+	Address   string // Source IP address
+	Netns     string // Named network namespace, e.g. "lte", in which the interface and address are looked up
+}
+
+// IsZero returns true if no interface or source address is selected
+func (s Source) IsZero() bool {
+	return s.Interface == "" && s.Address == ""
+}
+
+// Resolve returns the source address for the IP version. If only an interface is given, its first
+// global unicast address of that version is used. Returns nil for the zero Source, after checking that its
+// network namespace, if any, can be entered.
+func (s Source) Resolve(ipVersion relays.IPVersion) (net.IP, error) {
+	var ip net.IP
+	err := inNetns(s.Netns, func() error {
+		var err error
+		ip, err = s.resolve(ipVersion)
+		return err
+	})
+	return ip, err
+}
+
+// resolve is Resolve in the current network namespace
+func (s Source) resolve(ipVersion relays.IPVersion) (net.IP, error) {
+	if s.IsZero() {
+		return nil, nil
+	}
+
+	var addrs []net.Addr
+	if s.Interface != "" {
+		ifi, err := interfaceByName(s.Interface)
+		if err != nil {
+			return nil, fmt.Errorf("unknown interface %s: %w", s.Interface, err)
+		}
+		addrs, err = ifi.Addrs()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list addresses of interface %s: %w", s.Interface, err)
+		}
+	}
+
+	if s.Address != "" {
+		ip := net.ParseIP(s.Address)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid source address: %s", s.Address)
+		}
+		if (ip.To4() == nil) != ipVersion.IsIPv6() {
+			return nil, fmt.Errorf("source address %s is not an %s address", s.Address, ipVersion)
+		}
+		if s.Interface != "" && !hasAddr(addrs, ip) {
+			return nil, fmt.Errorf("source address %s is not assigned to interface %s", s.Address, s.Interface)
+		}
+		return ip, nil
+	}
+
+	for _, a := range addrs {
+		ipNet, ok := a.(*net.IPNet)
+		if !ok || !ipNet.IP.IsGlobalUnicast() {
+			continue
+		}
+		if (ipNet.IP.To4() == nil) == ipVersion.IsIPv6() {
+			return ipNet.IP, nil
+		}
+	}
+	return nil, fmt.Errorf("interface %s has no %s address", s.Interface, ipVersion)
+}
+
+// interfaceByName is a variable so tests can substitute fake interfaces
+var interfaceByName = func(name string) (addrLister, error) {
+	return net.InterfaceByName(name)
+}
+
+// addrLister is the part of net.Interface used to resolve a source address
+type addrLister interface {
+	Addrs() ([]net.Addr, error)
+}
+
+// hasAddr reports whether ip is among the interface addresses
+func hasAddr(addrs []net.Addr, ip net.IP) bool {
+	for _, a := range addrs {
+		if ipNet, ok := a.(*net.IPNet); ok && ipNet.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// sourceKey is the context key for the probe source
+type sourceKey struct{}
+
+// WithSource returns a context carrying the probe source
+func WithSource(ctx context.Context, source Source) context.Context {
+	return context.WithValue(ctx, sourceKey{}, source)
+}
+
+// SourceFromContext returns the probe source carried by the context, or the zero Source
+func SourceFromContext(ctx context.Context) Source {
+	source, _ := ctx.Value(sourceKey{}).(Source)
+	return source
+}
@@ -0,0 +1,148 @@
+package ping
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/Ch00k/mullvad-compass/internal/relays"
+)
+
+// fakeInterface returns fixed addresses
+type fakeInterface []net.Addr
+
+func (f fakeInterface) Addrs() ([]net.Addr, error) {
+	return f, nil
+}
+
+// withInterfaces substitutes interface lookup for the duration of the test
+func withInterfaces(t *testing.T, ifaces map[string]fakeInterface) {
+	t.Helper()
+	orig := interfaceByName
+	interfaceByName = func(name string) (addrLister, error) {
+		ifi, ok := ifaces[name]
+		if !ok {
+			return nil, errors.New("no such network interface")
+		}
+		return ifi, nil
+	}
+	t.Cleanup(func() { interfaceByName = orig })
+}
+
+func ipNet(s string) *net.IPNet {
+	ip, n, _ := net.ParseCIDR(s)
+	n.IP = ip
+	return n
+}
+
+func TestSourceResolve(t *testing.T) {
+	withInterfaces(t, map[string]fakeInterface{
+		"eth0": {ipNet("fe80::1/64"), ipNet("192.0.2.10/24"), ipNet("2001:db8::10/64")},
+		"lo":   {ipNet("127.0.0.1/8"), ipNet("::1/128")},
+	})
+
+	tests := []struct {
+		name      string
+		source    Source
+		ipVersion relays.IPVersion
+		want      string
+		wantErr   string
+	}{
+		{name: "Zero source", source: Source{}, ipVersion: relays.IPv4},
+		{name: "Address", source: Source{Address: "198.51.100.1"}, ipVersion: relays.IPv4, want: "198.51.100.1"},
+		{name: "Interface IPv4", source: Source{Interface: "eth0"}, ipVersion: relays.IPv4, want: "192.0.2.10"},
+		{
+			name:      "Interface IPv6 skips link-local",
+			source:    Source{Interface: "eth0"},
+			ipVersion: relays.IPv6,
+			want:      "2001:db8::10",
+		},
+		{
+			name:      "Interface and matching address",
+			source:    Source{Interface: "eth0", Address: "2001:db8::10"},
+			ipVersion: relays.IPv6,
+			want:      "2001:db8::10",
+		},
+		{
+			name:      "Address not on interface",
+			source:    Source{Interface: "eth0", Address: "198.51.100.1"},
+			ipVersion: relays.IPv4,
+			wantErr:   "not assigned to interface eth0",
+		},
+		{
+			name:      "Unknown interface",
+			source:    Source{Interface: "wg9"},
+			ipVersion: relays.IPv4,
+			wantErr:   "unknown interface wg9",
+		},
+		{
+			name:      "No global address",
+			source:    Source{Interface: "lo"},
+			ipVersion: relays.IPv4,
+			wantErr:   "has no ipv4 address",
+		},
+		{
+			name:      "Invalid address",
+			source:    Source{Address: "bogus"},
+			ipVersion: relays.IPv4,
+			wantErr:   "invalid source address",
+		},
+		{
+			name:      "Address family mismatch",
+			source:    Source{Address: "192.0.2.10"},
+			ipVersion: relays.IPv6,
+			wantErr:   "is not an ipv6 address",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.source.Resolve(tt.ipVersion)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if tt.want == "" {
+				if got != nil {
+					t.Errorf("Expected nil address, got %s", got)
+				}
+				return
+			}
+			if !got.Equal(net.ParseIP(tt.want)) {
+				t.Errorf("Expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestSourceResolveNetns(t *testing.T) {
+	// Neither platform can enter a namespace that does not exist; elsewhere than Linux none can be entered
+	_, err := Source{Netns: "mullvad-compass-missing"}.Resolve(relays.IPv4)
+	if err == nil || !strings.Contains(err.Error(), "mullvad-compass-missing") {
+		t.Errorf("Expected an error naming the namespace, got %v", err)
+	}
+
+	ran := false
+	if err := inNetns("", func() error { ran = true; return nil }); err != nil || !ran {
+		t.Errorf("Expected the empty namespace to run fn in place, got ran=%v, err=%v", ran, err)
+	}
+}
+
+func TestSourceContext(t *testing.T) {
+	ctx := context.Background()
+	if !SourceFromContext(ctx).IsZero() {
+		t.Error("Expected zero source from a bare context")
+	}
+
+	source := Source{Interface: "eth0", Address: "192.0.2.10"}
+	if got := SourceFromContext(WithSource(ctx, source)); got != source {
+		t.Errorf("Expected %+v, got %+v", source, got)
+	}
+}
@@ -0,0 +1,38 @@
+package ping
+
+import (
+	"context"
+	"time"
+
+	"github.com/Ch00k/mullvad-compass/internal/relays"
+)
+
+type timeoutPerKmKey struct{}
+
+// WithTimeoutPerKm returns a context that lengthens the timeout of every location by perKm milliseconds per km of
+// its distance, so that nearby relays fail fast while distant ones get the headroom their round trip needs.
+// Locations without a distance keep the base timeout.
+func WithTimeoutPerKm(ctx context.Context, perKm float64) context.Context {
+	return context.WithValue(ctx, timeoutPerKmKey{}, perKm)
+}
+
+// timeoutPerKm returns the timeout per km carried by the context, 0 if none
+func timeoutPerKm(ctx context.Context) float64 {
+	perKm, _ := ctx.Value(timeoutPerKmKey{}).(float64)
+	return perKm
+}
+
+// ScaledTimeout returns the timeout in milliseconds of a location: timeout plus perKm for every km of its distance,
+// up to the longest timeout allowed
+func ScaledTimeout(loc relays.Location, timeout int, perKm float64) float64 {
+	scaled := float64(timeout)
+	if loc.DistanceFromMyLocation != nil {
+		scaled += perKm * *loc.DistanceFromMyLocation
+	}
+	return min(scaled, maxTimeout)
+}
+
+// locationTimeout returns the timeout a location is pinged with
+func locationTimeout(ctx context.Context, loc relays.Location, timeout int) time.Duration {
+	return time.Duration(ScaledTimeout(loc, timeout, timeoutPerKm(ctx)) * float64(time.Millisecond))
+}
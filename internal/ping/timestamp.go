@@ -0,0 +1,106 @@
+package ping
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"github.com/Ch00k/mullvad-compass/internal/relays"
+)
+
+// Asymmetry estimates the one-way delays of a server from ICMP timestamp replies. The estimates assume that the
+// server's clock agrees with ours, so an offset between the two shifts delay from one direction to the other; their
+// sum is the round trip time regardless.
+type Asymmetry struct {
+	Location relays.Location
+	Outbound *float64 // Milliseconds to the server, the upload path; nil without a usable reply
+	Return   *float64 // Milliseconds back from the server, the download path; nil without a usable reply
+}
+
+const (
+	msPerDay = 24 * 60 * 60 * 1000
+	// nonStandardTimestamp marks a timestamp that is not milliseconds since midnight UTC (RFC 792)
+	nonStandardTimestamp = 1 << 31
+	// timestampBodyLen is the length of the identifier, sequence number and three timestamps of a timestamp message
+	timestampBodyLen = 16
+)
+
+var errNonStandardTimestamp = errors.New("timestamp is not milliseconds since midnight UTC")
+
+// timestampReply is the body of an ICMP timestamp reply
+type timestampReply struct {
+	id        int
+	seq       int
+	originate uint32 // Our send time, echoed back
+	receive   uint32 // When the server received the request
+	transmit  uint32 // When the server sent the reply
+}
+
+// msSinceMidnight returns t as the fractional milliseconds since midnight UTC that ICMP timestamps count
+func msSinceMidnight(t time.Time) float64 {
+	t = t.UTC()
+	y, m, d := t.Date()
+	return float64(t.Sub(time.Date(y, m, d, 0, 0, 0, 0, time.UTC))) / float64(time.Millisecond)
+}
+
+// marshalTimestampRequest returns the body of a timestamp request sent at t
+func marshalTimestampRequest(id, seq int, t time.Time) []byte {
+	b := make([]byte, timestampBodyLen)
+	binary.BigEndian.PutUint16(b[0:], uint16(id))
+	binary.BigEndian.PutUint16(b[2:], uint16(seq))
+	binary.BigEndian.PutUint32(b[4:], uint32(msSinceMidnight(t)))
+	return b
+}
+
+// parseTimestampReply parses the body of a timestamp reply
+func parseTimestampReply(b []byte) (timestampReply, error) {
+	if len(b) < timestampBodyLen {
+		return timestampReply{}, errors.New("timestamp reply too short")
+	}
+	return timestampReply{
+		id:        int(binary.BigEndian.Uint16(b[0:])),
+		seq:       int(binary.BigEndian.Uint16(b[2:])),
+		originate: binary.BigEndian.Uint32(b[4:]),
+		receive:   binary.BigEndian.Uint32(b[8:]),
+		transmit:  binary.BigEndian.Uint32(b[12:]),
+	}, nil
+}
+
+// oneWayDelays returns the outbound and return delays in milliseconds of a reply to a request sent at sent and
+// received at received
+func oneWayDelays(reply timestampReply, sent, received time.Time) (float64, float64, error) {
+	if reply.receive&nonStandardTimestamp != 0 || reply.transmit&nonStandardTimestamp != 0 {
+		return 0, 0, errNonStandardTimestamp
+	}
+	// The server's timestamps are truncated to the millisecond, so the middle of that millisecond is the best guess
+	outbound := sinceMidnightDiff(float64(reply.receive)+0.5, msSinceMidnight(sent))
+	back := sinceMidnightDiff(msSinceMidnight(received), float64(reply.transmit)+0.5)
+	return outbound, back, nil
+}
+
+// sinceMidnightDiff returns a - b for times of day in milliseconds, taking the shorter way around midnight
+func sinceMidnightDiff(a, b float64) float64 {
+	diff := a - b
+	switch {
+	case diff > msPerDay/2:
+		diff -= msPerDay
+	case diff <= -msPerDay/2:
+		diff += msPerDay
+	}
+	return diff
+}
+
+// minDelays accumulates the smallest delays of each direction over several samples, as queueing only adds delay
+type minDelays struct {
+	outbound *float64
+	back     *float64
+}
+
+func (m *minDelays) add(outbound, back float64) {
+	if m.outbound == nil || outbound < *m.outbound {
+		m.outbound = &outbound
+	}
+	if m.back == nil || back < *m.back {
+		m.back = &back
+	}
+}
@@ -0,0 +1,107 @@
+package ping
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestTimestampRequestRoundTrip(t *testing.T) {
+	sent := time.Date(2025, 3, 1, 12, 30, 15, 250*int(time.Millisecond), time.UTC)
+	b := marshalTimestampRequest(0x1234, 7, sent)
+	if len(b) != timestampBodyLen {
+		t.Fatalf("Expected a %d byte body, got %d", timestampBodyLen, len(b))
+	}
+
+	reply, err := parseTimestampReply(b)
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+	if reply.id != 0x1234 || reply.seq != 7 {
+		t.Errorf("Expected id 0x1234 and seq 7, got %#x and %d", reply.id, reply.seq)
+	}
+	want := uint32((12*3600+30*60+15)*1000 + 250)
+	if reply.originate != want {
+		t.Errorf("Expected originate %d, got %d", want, reply.originate)
+	}
+
+	if _, err := parseTimestampReply(b[:12]); err == nil {
+		t.Error("Expected an error for a short reply")
+	}
+}
+
+func TestOneWayDelays(t *testing.T) {
+	day := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name         string
+		sent         time.Time
+		receive      uint32
+		transmit     uint32
+		received     time.Time
+		wantOutbound float64
+		wantReturn   float64
+	}{
+		{
+			name:         "Slower upload",
+			sent:         day.Add(10 * time.Second),
+			receive:      10_030,
+			transmit:     10_031,
+			received:     day.Add(10*time.Second + 41*time.Millisecond),
+			wantOutbound: 30.5,
+			wantReturn:   9.5,
+		},
+		{
+			name:         "Across midnight",
+			sent:         day.Add(-5 * time.Millisecond),
+			receive:      5,
+			transmit:     6,
+			received:     day.Add(16 * time.Millisecond),
+			wantOutbound: 10.5,
+			wantReturn:   9.5,
+		},
+		{
+			name:         "Server clock behind",
+			sent:         day.Add(time.Second),
+			receive:      990,
+			transmit:     991,
+			received:     day.Add(time.Second + 21*time.Millisecond),
+			wantOutbound: -9.5,
+			wantReturn:   29.5,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reply := timestampReply{receive: tt.receive, transmit: tt.transmit}
+			outbound, back, err := oneWayDelays(reply, tt.sent, tt.received)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if math.Abs(outbound-tt.wantOutbound) > 1e-6 || math.Abs(back-tt.wantReturn) > 1e-6 {
+				t.Errorf("Expected %v/%v ms, got %v/%v ms", tt.wantOutbound, tt.wantReturn, outbound, back)
+			}
+		})
+	}
+
+	t.Run("Non-standard timestamp", func(t *testing.T) {
+		reply := timestampReply{receive: nonStandardTimestamp | 5, transmit: 6}
+		if _, _, err := oneWayDelays(reply, day, day); !errors.Is(err, errNonStandardTimestamp) {
+			t.Errorf("Expected errNonStandardTimestamp, got %v", err)
+		}
+	})
+}
+
+func TestMinDelays(t *testing.T) {
+	var m minDelays
+	if m.outbound != nil || m.back != nil {
+		t.Fatal("Expected no delays before the first sample")
+	}
+	m.add(12, 8)
+	m.add(10, 9)
+	m.add(11, 7)
+	if *m.outbound != 10 || *m.back != 7 {
+		t.Errorf("Expected the minimum of each direction (10/7), got %v/%v", *m.outbound, *m.back)
+	}
+}
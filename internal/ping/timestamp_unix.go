@@ -0,0 +1,158 @@
+//go:build !windows
+
+package ping
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"time"
+
+	"github.com/Ch00k/mullvad-compass/internal/icmp"
+	"github.com/Ch00k/mullvad-compass/internal/logging"
+	"github.com/Ch00k/mullvad-compass/internal/relays"
+	xicmp "golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+)
+
+// TimestampSupported reports whether ProbeAsymmetry can send ICMP timestamp requests
+const TimestampSupported = true
+
+// ProbeAsymmetry sends rounds of ICMP timestamp requests to the IPv4 addresses of locations, waiting up to timeout for
+// the replies of each round, and estimates the one-way delays of each server from the fastest replies. Servers that
+// ignore timestamp requests, as many do, are returned without estimates. Timestamp requests need a raw socket, so
+// this fails without root or CAP_NET_RAW.
+func ProbeAsymmetry(
+	ctx context.Context,
+	locations []relays.Location,
+	rounds int,
+	timeout time.Duration,
+	source Source,
+	logLevel logging.LogLevel,
+) ([]Asymmetry, error) {
+	var conn *xicmp.PacketConn
+	err := inNetns(source.Netns, func() error {
+		addr, err := source.resolve(relays.IPv4)
+		if err != nil {
+			return err
+		}
+		conn, err = icmp.ListenRaw(addr, logLevel)
+		return err
+	})
+	if errors.Is(err, os.ErrPermission) {
+		return nil, fmt.Errorf("ICMP timestamp requests need a raw socket, run as root or grant CAP_NET_RAW: %w", err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = conn.Close() }()
+
+	// Expire the read deadline on cancellation to unblock the reads
+	stop := context.AfterFunc(ctx, func() { _ = conn.SetReadDeadline(time.Now()) })
+	defer stop()
+
+	id := os.Getpid() & 0xffff
+	delays := make([]minDelays, len(locations))
+	seq := 0
+	for round := 0; round < rounds; round++ {
+		// Sequence numbers of the round, mapped to the index of their location
+		pending := make(map[int]int, len(locations))
+		sent := make(map[int]time.Time, len(locations))
+		for i, loc := range locations {
+			ip := net.ParseIP(loc.IPv4Address)
+			if ip == nil {
+				continue
+			}
+			seq++
+			now := time.Now()
+			msg := xicmp.Message{
+				Type: ipv4.ICMPTypeTimestamp,
+				Body: &xicmp.RawBody{Data: marshalTimestampRequest(id, seq, now)},
+			}
+			b, err := msg.Marshal(nil)
+			if err != nil {
+				return nil, err
+			}
+			if _, err := conn.WriteTo(b, &net.IPAddr{IP: ip}); err != nil {
+				if logLevel <= logging.LogLevelDebug {
+					log.Printf("Failed to send timestamp request to %s: %v", loc.Hostname, err)
+				}
+				continue
+			}
+			pending[seq&0xffff] = i
+			sent[seq&0xffff] = now
+		}
+
+		if err := readTimestampReplies(conn, id, pending, sent, delays, time.Now().Add(timeout), logLevel); err != nil {
+			return nil, err
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	results := make([]Asymmetry, len(locations))
+	for i, loc := range locations {
+		results[i] = Asymmetry{Location: loc, Outbound: delays[i].outbound, Return: delays[i].back}
+	}
+	return results, nil
+}
+
+// readTimestampReplies reads the replies to the pending requests until all arrived or the deadline passes. The raw
+// socket receives every ICMP message to the host, so anything but a reply to a pending request is skipped.
+func readTimestampReplies(
+	conn *xicmp.PacketConn,
+	id int,
+	pending map[int]int,
+	sent map[int]time.Time,
+	delays []minDelays,
+	deadline time.Time,
+	logLevel logging.LogLevel,
+) error {
+	if err := conn.SetReadDeadline(deadline); err != nil {
+		return err
+	}
+	buf := make([]byte, 1500)
+	for len(pending) > 0 {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				return nil
+			}
+			return err
+		}
+		received := time.Now()
+
+		msg, err := xicmp.ParseMessage(1, buf[:n])
+		if err != nil || msg.Type != ipv4.ICMPTypeTimestampReply {
+			continue
+		}
+		body, ok := msg.Body.(*xicmp.RawBody)
+		if !ok {
+			continue
+		}
+		reply, err := parseTimestampReply(body.Data)
+		if err != nil || reply.id != id {
+			continue
+		}
+		i, ok := pending[reply.seq]
+		if !ok {
+			continue
+		}
+		delete(pending, reply.seq)
+
+		outbound, back, err := oneWayDelays(reply, sent[reply.seq], received)
+		if err != nil {
+			if logLevel <= logging.LogLevelDebug {
+				log.Printf("Skipping timestamp reply %d: %v", reply.seq, err)
+			}
+			continue
+		}
+		delays[i].add(outbound, back)
+	}
+	return nil
+}
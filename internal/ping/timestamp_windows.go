@@ -0,0 +1,28 @@
+//go:build windows
+
+package ping
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/Ch00k/mullvad-compass/internal/logging"
+	"github.com/Ch00k/mullvad-compass/internal/relays"
+)
+
+// TimestampSupported reports whether ProbeAsymmetry can send ICMP timestamp requests. The IP Helper API only sends
+// echo requests.
+const TimestampSupported = false
+
+// ProbeAsymmetry is not supported on Windows
+func ProbeAsymmetry(
+	_ context.Context,
+	_ []relays.Location,
+	_ int,
+	_ time.Duration,
+	_ Source,
+	_ logging.LogLevel,
+) ([]Asymmetry, error) {
+	return nil, errors.New("ICMP timestamp requests are not supported on Windows")
+}
@@ -0,0 +1,32 @@
+package ping
+
+import (
+	"runtime"
+	"strconv"
+)
+
+// MaxWorkers is the largest number of concurrent pings a run may use
+const MaxWorkers = 200
+
+// workersPerCPU is how many concurrent pings each CPU gets by default. A ping mostly waits on the network, so a
+// CPU keeps several of them busy.
+const workersPerCPU = 8
+
+// AutoWorkers returns the default number of concurrent pings for the given number of addresses: one per address,
+// at most workersPerCPU per CPU and MaxWorkers in total, and no more than half the open file limit so that the
+// probes never run out of descriptors
+func AutoWorkers(addresses int) int {
+	workers := min(addresses, runtime.NumCPU()*workersPerCPU, MaxWorkers)
+	if limit := openFileLimit(); limit > 0 {
+		workers = min(workers, limit/2)
+	}
+	return max(workers, 1)
+}
+
+// formatWorkers renders a worker count for the logs, where 0 stands for the automatic default
+func formatWorkers(workers int) string {
+	if workers <= 0 {
+		return "auto"
+	}
+	return strconv.Itoa(workers)
+}
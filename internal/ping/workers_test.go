@@ -0,0 +1,39 @@
+package ping
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestAutoWorkers(t *testing.T) {
+	t.Run("One worker per address for small scans", func(t *testing.T) {
+		if got := AutoWorkers(1); got != 1 {
+			t.Errorf("AutoWorkers(1) = %d, want 1", got)
+		}
+	})
+
+	t.Run("At least one worker", func(t *testing.T) {
+		if got := AutoWorkers(0); got != 1 {
+			t.Errorf("AutoWorkers(0) = %d, want 1", got)
+		}
+	})
+
+	t.Run("Capped for large scans", func(t *testing.T) {
+		got := AutoWorkers(100000)
+		if got > MaxWorkers || got > runtime.NumCPU()*workersPerCPU {
+			t.Errorf("AutoWorkers(100000) = %d, want at most %d and %d per CPU", got, MaxWorkers, workersPerCPU)
+		}
+		if limit := openFileLimit(); limit > 0 && got > limit/2 {
+			t.Errorf("AutoWorkers(100000) = %d, want at most half the open file limit %d", got, limit)
+		}
+	})
+}
+
+func TestFormatWorkers(t *testing.T) {
+	if got := formatWorkers(0); got != "auto" {
+		t.Errorf("formatWorkers(0) = %q, want %q", got, "auto")
+	}
+	if got := formatWorkers(25); got != "25" {
+		t.Errorf("formatWorkers(25) = %q, want %q", got, "25")
+	}
+}
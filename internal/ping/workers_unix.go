@@ -0,0 +1,20 @@
+//go:build !windows
+
+package ping
+
+import (
+	"math"
+	"syscall"
+)
+
+// openFileLimit returns the soft limit on open file descriptors, or 0 if it is unknown or unlimited
+func openFileLimit() int {
+	var rlimit syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rlimit); err != nil {
+		return 0
+	}
+	if rlimit.Cur == 0 || rlimit.Cur >= math.MaxInt32 {
+		return 0
+	}
+	return int(rlimit.Cur)
+}
@@ -0,0 +1,8 @@
+//go:build windows
+
+package ping
+
+// openFileLimit returns 0, as the ICMP helper handles on Windows are not bound by a descriptor limit
+func openFileLimit() int {
+	return 0
+}
@@ -0,0 +1,116 @@
+// Package rank orders servers by a score computed from their measurements, lowest score first.
+package rank
+
+import (
+	"cmp"
+	"slices"
+
+	"github.com/Ch00k/mullvad-compass/internal/relays"
+)
+
+// Scorer scores each of the locations, returning the scores in the same order. A lower score ranks higher, and
+// a nil score ranks last. Scores may depend on the whole set, e.g. to normalize a measurement across it.
+type Scorer func(locations []relays.Location) []*float64
+
+// Latency scores locations by their latency, leaving timeouts unscored
+func Latency(locations []relays.Location) []*float64 {
+	scores := make([]*float64, len(locations))
+	for i, loc := range locations {
+		scores[i] = loc.Latency
+	}
+	return scores
+}
+
+// Combined scores locations by a weighted sum of their latency and distance, each scaled to 0-1 across the
+// locations that responded. distanceWeight is the share of the distance in the score, from 0 (latency only) to
+// 1 (distance only). A location of unknown distance counts as the farthest. Timeouts are left unscored.
+func Combined(distanceWeight float64) Scorer {
+	return func(locations []relays.Location) []*float64 {
+		var latencies, distances []float64
+		for _, loc := range locations {
+			if loc.Latency == nil {
+				continue
+			}
+			latencies = append(latencies, *loc.Latency)
+			if loc.DistanceFromMyLocation != nil {
+				distances = append(distances, *loc.DistanceFromMyLocation)
+			}
+		}
+		scaleLatency := normalizer(latencies)
+		scaleDistance := normalizer(distances)
+
+		scores := make([]*float64, len(locations))
+		for i, loc := range locations {
+			if loc.Latency == nil {
+				continue
+			}
+			distance := 1.0
+			if loc.DistanceFromMyLocation != nil {
+				distance = scaleDistance(*loc.DistanceFromMyLocation)
+			}
+			score := (1-distanceWeight)*scaleLatency(*loc.Latency) + distanceWeight*distance
+			scores[i] = &score
+		}
+		return scores
+	}
+}
+
+// normalizer returns a function scaling values from the range of the given ones to 0-1. All values map to 0 when
+// they are equal, so that a measurement that does not tell the locations apart does not affect the score.
+func normalizer(values []float64) func(float64) float64 {
+	if len(values) == 0 {
+		return func(float64) float64 { return 0 }
+	}
+	lowest, highest := slices.Min(values), slices.Max(values)
+	if highest == lowest {
+		return func(float64) float64 { return 0 }
+	}
+	return func(v float64) float64 {
+		return (v - lowest) / (highest - lowest)
+	}
+}
+
+// Sort sorts locations by the scores of scorer, lowest first and unscored last. Ties are broken by distance, then
+// country and city, as in the latency ranking.
+func Sort(locations []relays.Location, scorer Scorer) {
+	scores := scorer(locations)
+	order := make([]int, len(locations))
+	for i := range order {
+		order[i] = i
+	}
+
+	slices.SortStableFunc(order, func(i, j int) int {
+		a, b := locations[i], locations[j]
+		if c := compareNilLast(scores[i], scores[j]); c != 0 {
+			return c
+		}
+		if a.DistanceFromMyLocation != nil && b.DistanceFromMyLocation != nil {
+			if c := cmp.Compare(*a.DistanceFromMyLocation, *b.DistanceFromMyLocation); c != 0 {
+				return c
+			}
+		}
+		if c := cmp.Compare(a.Country, b.Country); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.City, b.City)
+	})
+
+	sorted := make([]relays.Location, len(locations))
+	for i, j := range order {
+		sorted[i] = locations[j]
+	}
+	copy(locations, sorted)
+}
+
+// compareNilLast compares two optional values, ordering nil after any value
+func compareNilLast(a, b *float64) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return 1
+	case b == nil:
+		return -1
+	}
+	return cmp.Compare(*a, *b)
+}
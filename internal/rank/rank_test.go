@@ -0,0 +1,99 @@
+package rank
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/Ch00k/mullvad-compass/internal/relays"
+)
+
+func ptr(v float64) *float64 {
+	return &v
+}
+
+func hostnames(locations []relays.Location) []string {
+	names := make([]string, len(locations))
+	for i, loc := range locations {
+		names[i] = loc.Hostname
+	}
+	return names
+}
+
+func TestSortLatency(t *testing.T) {
+	locations := []relays.Location{
+		{Hostname: "timeout", DistanceFromMyLocation: ptr(10)},
+		{Hostname: "slow", Latency: ptr(30), DistanceFromMyLocation: ptr(10)},
+		{Hostname: "fast-far", Latency: ptr(10), DistanceFromMyLocation: ptr(900)},
+		{Hostname: "fast-near", Latency: ptr(10), DistanceFromMyLocation: ptr(100)},
+	}
+
+	Sort(locations, Latency)
+
+	want := []string{"fast-near", "fast-far", "slow", "timeout"}
+	if got := hostnames(locations); !slices.Equal(got, want) {
+		t.Errorf("Sort(Latency) = %v, want %v", got, want)
+	}
+}
+
+func TestSortCombined(t *testing.T) {
+	// Latencies within noise of each other, at very different distances
+	newLocations := func() []relays.Location {
+		return []relays.Location{
+			{Hostname: "far", Latency: ptr(20.0), DistanceFromMyLocation: ptr(1000)},
+			{Hostname: "near", Latency: ptr(20.4), DistanceFromMyLocation: ptr(100)},
+			{Hostname: "middle", Latency: ptr(20.2), DistanceFromMyLocation: ptr(500)},
+			{Hostname: "timeout", DistanceFromMyLocation: ptr(50)},
+		}
+	}
+
+	tests := []struct {
+		name   string
+		weight float64
+		want   []string
+	}{
+		{"Latency only", 0, []string{"far", "middle", "near", "timeout"}},
+		{"Weighted", 0.6, []string{"near", "middle", "far", "timeout"}},
+		{"Distance only", 1, []string{"near", "middle", "far", "timeout"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			locations := newLocations()
+			Sort(locations, Combined(tt.weight))
+			if got := hostnames(locations); !slices.Equal(got, tt.want) {
+				t.Errorf("Sort(Combined(%v)) = %v, want %v", tt.weight, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCombinedScores(t *testing.T) {
+	locations := []relays.Location{
+		{Hostname: "a", Latency: ptr(10), DistanceFromMyLocation: ptr(100)},
+		{Hostname: "b", Latency: ptr(30), DistanceFromMyLocation: ptr(300)},
+		{Hostname: "unknown-distance", Latency: ptr(10)},
+		{Hostname: "timeout", DistanceFromMyLocation: ptr(100)},
+	}
+
+	scores := Combined(0.5)(locations)
+
+	want := []*float64{ptr(0), ptr(1), ptr(0.5), nil}
+	for i := range want {
+		if (scores[i] == nil) != (want[i] == nil) || (scores[i] != nil && *scores[i] != *want[i]) {
+			t.Errorf("Score of %s = %v, want %v", locations[i].Hostname, scores[i], want[i])
+		}
+	}
+}
+
+func TestCombinedEqualMeasurements(t *testing.T) {
+	locations := []relays.Location{
+		{Hostname: "a", Latency: ptr(10), DistanceFromMyLocation: ptr(100)},
+		{Hostname: "b", Latency: ptr(10), DistanceFromMyLocation: ptr(300)},
+	}
+
+	scores := Combined(0.3)(locations)
+
+	// Equal latencies do not tell the servers apart, leaving the distance to decide
+	if *scores[0] != 0 || *scores[1] != 0.3 {
+		t.Errorf("Scores = %v, %v, want 0, 0.3", *scores[0], *scores[1])
+	}
+}
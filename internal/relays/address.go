@@ -0,0 +1,27 @@
+package relays
+
+// Address returns the address of the location that is pinged for the IP version
+func (l Location) Address(ipVersion IPVersion) string {
+	if ipVersion.IsIPv6() {
+		return l.IPv6Address
+	}
+	return l.IPv4Address
+}
+
+// GroupByAddress groups the indexes of locations that share an address for the IP version, so that each address
+// only needs to be pinged once. Groups are in the order their address first appears.
+func GroupByAddress(locations []Location, ipVersion IPVersion) [][]int {
+	index := make(map[string]int)
+	var groups [][]int
+	for i, loc := range locations {
+		addr := loc.Address(ipVersion)
+		g, ok := index[addr]
+		if !ok {
+			g = len(groups)
+			index[addr] = g
+			groups = append(groups, nil)
+		}
+		groups[g] = append(groups[g], i)
+	}
+	return groups
+}
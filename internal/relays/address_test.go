@@ -0,0 +1,47 @@
+package relays
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestAddress(t *testing.T) {
+	loc := Location{IPv4Address: "185.213.154.68", IPv6Address: "2a03:1b20:5:f011::a01f"}
+
+	if got := loc.Address(IPv4); got != "185.213.154.68" {
+		t.Errorf("Address(IPv4) = %q, want %q", got, "185.213.154.68")
+	}
+	if got := loc.Address(IPv6); got != "2a03:1b20:5:f011::a01f" {
+		t.Errorf("Address(IPv6) = %q, want %q", got, "2a03:1b20:5:f011::a01f")
+	}
+}
+
+func TestGroupByAddress(t *testing.T) {
+	locations := []Location{
+		{Hostname: "se-got-wg-001", IPv4Address: "185.213.154.68", IPv6Address: "2a03:1b20:5:f011::a01f"},
+		{Hostname: "se-got-wg-002", IPv4Address: "185.213.154.69", IPv6Address: "2a03:1b20:5:f011::a02f"},
+		{Hostname: "se-got-br-001", IPv4Address: "185.213.154.68", IPv6Address: "2a03:1b20:5:f011::a03f"},
+		{Hostname: "se-got-wg-003", IPv4Address: "185.213.154.70", IPv6Address: "2a03:1b20:5:f011::a02f"},
+	}
+
+	tests := []struct {
+		name      string
+		ipVersion IPVersion
+		want      [][]int
+	}{
+		{"IPv4", IPv4, [][]int{{0, 2}, {1}, {3}}},
+		{"IPv6", IPv6, [][]int{{0}, {1, 3}, {2}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := GroupByAddress(locations, tt.ipVersion)
+			if !slices.EqualFunc(got, tt.want, slices.Equal) {
+				t.Errorf("GroupByAddress() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if got := GroupByAddress(nil, IPv4); len(got) != 0 {
+		t.Errorf("Expected no groups for no locations, got %v", got)
+	}
+}
@@ -0,0 +1,170 @@
+package relays
+
+import (
+	"cmp"
+	"math/bits"
+	"slices"
+	"strings"
+)
+
+// Feature is a set of relay capabilities, combined with bitwise OR
+type Feature int
+
+// Relay capabilities, in the order they are displayed
+const (
+	FeatureDAITA Feature = 1 << iota
+	FeatureLWO
+	FeatureQUIC
+	FeatureShadowsocks
+	FeatureIPv6
+)
+
+// AllFeatures lists the individual capabilities in display order
+var AllFeatures = []Feature{FeatureDAITA, FeatureLWO, FeatureQUIC, FeatureShadowsocks, FeatureIPv6}
+
+// String returns the capabilities in the set joined with "+", or "-" for the empty set
+func (f Feature) String() string {
+	var names []string
+	for _, single := range AllFeatures {
+		if f.Has(single) {
+			names = append(names, single.name())
+		}
+	}
+	if len(names) == 0 {
+		return "-"
+	}
+	return strings.Join(names, "+")
+}
+
+// Flags returns the capabilities in the set as one letter each in display order, D (DAITA), L (LWO), Q (QUIC),
+// S (Shadowsocks) and 6 (IPv6), with "-" in place of a missing one, e.g. "D-QS6"
+func (f Feature) Flags() string {
+	flags := make([]byte, len(AllFeatures))
+	for i, single := range AllFeatures {
+		flags[i] = '-'
+		if f.Has(single) {
+			flags[i] = single.flag()
+		}
+	}
+	return string(flags)
+}
+
+// name returns the display name of a single capability
+func (f Feature) name() string {
+	switch f {
+	case FeatureDAITA:
+		return "DAITA"
+	case FeatureLWO:
+		return "LWO"
+	case FeatureQUIC:
+		return "QUIC"
+	case FeatureShadowsocks:
+		return "Shadowsocks"
+	case FeatureIPv6:
+		return "IPv6"
+	default:
+		return ""
+	}
+}
+
+// flag returns the letter of a single capability in Flags
+func (f Feature) flag() byte {
+	if f == FeatureIPv6 {
+		return '6'
+	}
+	return f.name()[0]
+}
+
+// Has returns true if the set contains every capability of other
+func (f Feature) Has(other Feature) bool {
+	return f&other == other
+}
+
+// relayFeatures returns the capabilities of a WireGuard relay
+func relayFeatures(relay WireGuardRelay) Feature {
+	var f Feature
+	if relay.Daita {
+		f |= FeatureDAITA
+	}
+	if matchesAntiCensorshipFeatures(relay, LWO) {
+		f |= FeatureLWO
+	}
+	if matchesAntiCensorshipFeatures(relay, QUIC) {
+		f |= FeatureQUIC
+	}
+	if matchesAntiCensorshipFeatures(relay, Shadowsocks) {
+		f |= FeatureShadowsocks
+	}
+	if relay.IPv6AddrIn != "" {
+		f |= FeatureIPv6
+	}
+	return f
+}
+
+// CityCapabilities describes the capabilities available in a city
+type CityCapabilities struct {
+	Country      string
+	City         string
+	Relays       int
+	Available    Feature   // Capabilities offered by at least one relay
+	Combinations []Feature // Largest capability sets offered together by a single relay, largest first
+}
+
+// CapabilityMatrix groups locations by city and collects the capability combinations each city offers.
+// Cities are sorted by country and city name.
+func CapabilityMatrix(locations []Location) []CityCapabilities {
+	index := make(map[string]int)
+	var cities []CityCapabilities
+	var sets [][]Feature
+
+	for _, loc := range locations {
+		key := CityKey(loc)
+		i, ok := index[key]
+		if !ok {
+			i = len(cities)
+			index[key] = i
+			cities = append(cities, CityCapabilities{Country: loc.Country, City: loc.City})
+			sets = append(sets, nil)
+		}
+		cities[i].Relays++
+		cities[i].Available |= loc.Features
+		if !slices.Contains(sets[i], loc.Features) {
+			sets[i] = append(sets[i], loc.Features)
+		}
+	}
+
+	for i := range cities {
+		cities[i].Combinations = maximalSets(sets[i])
+	}
+
+	slices.SortStableFunc(cities, func(a, b CityCapabilities) int {
+		if c := cmp.Compare(a.Country, b.Country); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.City, b.City)
+	})
+
+	return cities
+}
+
+// maximalSets drops the sets contained in another set, since a relay offering a combination offers
+// every part of it too. The rest are sorted largest first.
+func maximalSets(sets []Feature) []Feature {
+	var maximal []Feature
+	for _, s := range sets {
+		contained := slices.ContainsFunc(sets, func(other Feature) bool {
+			return other != s && other.Has(s)
+		})
+		if !contained {
+			maximal = append(maximal, s)
+		}
+	}
+
+	slices.SortFunc(maximal, func(a, b Feature) int {
+		if c := cmp.Compare(bits.OnesCount(uint(b)), bits.OnesCount(uint(a))); c != 0 {
+			return c
+		}
+		return cmp.Compare(a, b)
+	})
+	return maximal
+}
@@ -0,0 +1,108 @@
+package relays
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+)
+
+func TestFeatureString(t *testing.T) {
+	tests := []struct {
+		features Feature
+		want     string
+	}{
+		{0, "-"},
+		{FeatureQUIC, "QUIC"},
+		{FeatureIPv6 | FeatureDAITA | FeatureShadowsocks, "DAITA+Shadowsocks+IPv6"},
+		{FeatureDAITA | FeatureLWO | FeatureQUIC | FeatureShadowsocks | FeatureIPv6, "DAITA+LWO+QUIC+Shadowsocks+IPv6"},
+	}
+	for _, tt := range tests {
+		if got := tt.features.String(); got != tt.want {
+			t.Errorf("Feature(%d).String() = %q, want %q", tt.features, got, tt.want)
+		}
+	}
+}
+
+func TestFeatureFlags(t *testing.T) {
+	tests := []struct {
+		features Feature
+		want     string
+	}{
+		{0, "-----"},
+		{FeatureDAITA | FeatureQUIC | FeatureIPv6, "D-Q-6"},
+		{FeatureDAITA | FeatureLWO | FeatureQUIC | FeatureShadowsocks | FeatureIPv6, "DLQS6"},
+	}
+	for _, tt := range tests {
+		if got := tt.features.Flags(); got != tt.want {
+			t.Errorf("Feature(%d).Flags() = %q, want %q", tt.features, got, tt.want)
+		}
+	}
+}
+
+func TestRelayFeatures(t *testing.T) {
+	present := json.RawMessage("{}")
+	relay := WireGuardRelay{
+		Daita:                  true,
+		IPv6AddrIn:             "2a03:1b20:3:f011::a01f",
+		ShadowsocksExtraAddrIn: []string{"185.213.154.69"},
+		Features:               RelayFeatures{QUIC: &present},
+	}
+	if got, want := relayFeatures(relay), FeatureDAITA|FeatureQUIC|FeatureShadowsocks|FeatureIPv6; got != want {
+		t.Errorf("relayFeatures() = %s, want %s", got, want)
+	}
+	if got := relayFeatures(WireGuardRelay{}); got != 0 {
+		t.Errorf("Expected no features, got %s", got)
+	}
+}
+
+func TestCapabilityMatrix(t *testing.T) {
+	locations := []Location{
+		{Country: "Sweden", City: "Stockholm", Features: FeatureDAITA | FeatureIPv6},
+		{Country: "Germany", City: "Berlin", Features: FeatureLWO},
+		{Country: "Sweden", City: "Stockholm", Features: FeatureQUIC | FeatureIPv6},
+		{Country: "Sweden", City: "Stockholm", Features: FeatureIPv6},
+		{Country: "Sweden", City: "Stockholm", Features: FeatureDAITA | FeatureIPv6},
+		{Country: "Germany", City: "Berlin", Features: 0},
+	}
+
+	cities := CapabilityMatrix(locations)
+	if len(cities) != 2 || cities[0].City != "Berlin" || cities[1].City != "Stockholm" {
+		t.Fatalf("Expected Berlin and Stockholm sorted by country, got %+v", cities)
+	}
+
+	berlin, stockholm := cities[0], cities[1]
+	if berlin.Relays != 2 || berlin.Available != FeatureLWO {
+		t.Errorf("Unexpected Berlin capabilities: %+v", berlin)
+	}
+	if !slices.Equal(berlin.Combinations, []Feature{FeatureLWO}) {
+		t.Errorf("Expected the empty set to be dropped in favor of LWO, got %v", berlin.Combinations)
+	}
+
+	if stockholm.Relays != 4 || stockholm.Available != FeatureDAITA|FeatureQUIC|FeatureIPv6 {
+		t.Errorf("Unexpected Stockholm capabilities: %+v", stockholm)
+	}
+	want := []Feature{FeatureDAITA | FeatureIPv6, FeatureQUIC | FeatureIPv6}
+	if !slices.Equal(stockholm.Combinations, want) {
+		t.Errorf("Expected combinations %v, got %v", want, stockholm.Combinations)
+	}
+}
+
+func TestGetLocationsSetsFeatures(t *testing.T) {
+	file, err := ParseRelaysFile("../../testdata/relays.json")
+	if err != nil {
+		t.Fatalf("Failed to parse relays.json: %v", err)
+	}
+
+	locations, _, err := GetLocations(file, QUIC, true, IPv4)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(locations) == 0 {
+		t.Fatal("Expected DAITA relays with QUIC")
+	}
+	for _, loc := range locations {
+		if !loc.Features.Has(FeatureDAITA | FeatureQUIC) {
+			t.Errorf("Expected DAITA and QUIC on %s, got %s", loc.Hostname, loc.Features)
+		}
+	}
+}
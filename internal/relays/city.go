@@ -0,0 +1,98 @@
+package relays
+
+import (
+	"maps"
+	"slices"
+	"strings"
+)
+
+// CityKey returns a key identifying the city of a location, the same for every spelling of its country
+func CityKey(loc Location) string {
+	return NormalizeCountry(loc.Country) + "\x00" + loc.City
+}
+
+// CityBest is the best location of a city together with the number of locations in that city
+type CityBest struct {
+	Best  Location
+	Count int
+}
+
+// BestPerCity collapses ranked locations to one entry per city, keeping the first (best ranked) location
+// of each city. Cities appear in the order of their best location.
+func BestPerCity(locations []Location) []CityBest {
+	index := make(map[string]int)
+	var cities []CityBest
+
+	for _, loc := range locations {
+		key := CityKey(loc)
+		if i, ok := index[key]; ok {
+			cities[i].Count++
+			continue
+		}
+		index[key] = len(cities)
+		cities = append(cities, CityBest{Best: loc, Count: 1})
+	}
+
+	return cities
+}
+
+// CityCounts holds the number of relays in each city
+type CityCounts map[string]int
+
+// CountPerCity counts the locations in each city
+func CountPerCity(locations []Location) CityCounts {
+	counts := make(CityCounts)
+	for _, loc := range locations {
+		counts[CityKey(loc)]++
+	}
+	return counts
+}
+
+// Of returns the number of relays in the city of the location
+func (c CityCounts) Of(loc Location) int {
+	return c[CityKey(loc)]
+}
+
+// MatchesCity returns true if the location is in the given city, specified by name or Mullvad city code
+func MatchesCity(loc Location, city string) bool {
+	key := NormalizeCountry(city)
+	if key == "" {
+		return false
+	}
+	if key == NormalizeCountry(loc.City) {
+		return true
+	}
+	return loc.CityCode != "" && key == strings.ToLower(loc.CityCode)
+}
+
+// FilterByPlace returns the locations in the given country or city
+func FilterByPlace(locations []Location, place string) []Location {
+	var filtered []Location
+	for _, loc := range locations {
+		if MatchesCountry(loc, place) || MatchesCity(loc, place) {
+			filtered = append(filtered, loc)
+		}
+	}
+	return filtered
+}
+
+// LookupCity returns the key and entry of the Mullvad location matching a city name, Mullvad city code, or location
+// key such as "pt-lis". The state or province after a comma may be left out ("New York" for "New York, NY").
+// Cities sharing a name are resolved to the first one in key order.
+func (f *File) LookupCity(city string) (string, LocationEntry, bool) {
+	name := NormalizeCountry(city)
+	if name == "" {
+		return "", LocationEntry{}, false
+	}
+
+	code := strings.ToLower(strings.TrimSpace(city))
+	for _, key := range slices.Sorted(maps.Keys(f.Locations)) {
+		entry := f.Locations[key]
+		short, _, _ := strings.Cut(entry.City, ",")
+		if name == NormalizeCountry(entry.City) || name == NormalizeCountry(short) ||
+			code == key || code == cityCodeFromLocationKey(key) {
+			return key, entry, true
+		}
+	}
+	return "", LocationEntry{}, false
+}
@@ -0,0 +1,125 @@
+package relays
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestBestPerCity(t *testing.T) {
+	locations := []Location{
+		{Hostname: "de-ber-1", Country: "Germany", City: "Berlin"},
+		{Hostname: "de-fra-1", Country: "Germany", City: "Frankfurt"},
+		{Hostname: "de-ber-2", Country: "Germany", City: "Berlin"},
+		{Hostname: "de-ber-3", Country: "Germany", City: "Berlin"},
+		{Hostname: "us-ber-1", Country: "USA", City: "Berlin"},
+	}
+
+	cities := BestPerCity(locations)
+
+	expected := []struct {
+		hostname string
+		count    int
+	}{
+		{"de-ber-1", 3},
+		{"de-fra-1", 1},
+		{"us-ber-1", 1},
+	}
+	if len(cities) != len(expected) {
+		t.Fatalf("Expected %d cities, got %d: %+v", len(expected), len(cities), cities)
+	}
+	for i, want := range expected {
+		if cities[i].Best.Hostname != want.hostname || cities[i].Count != want.count {
+			t.Errorf("City %d: expected %s (%d), got %s (%d)",
+				i, want.hostname, want.count, cities[i].Best.Hostname, cities[i].Count)
+		}
+	}
+}
+
+func TestBestPerCityEmpty(t *testing.T) {
+	if cities := BestPerCity(nil); len(cities) != 0 {
+		t.Errorf("Expected no cities, got %+v", cities)
+	}
+}
+
+func TestCountPerCity(t *testing.T) {
+	counts := CountPerCity([]Location{
+		{Country: "Germany", City: "Berlin", Hostname: "de-ber-wg-001"},
+		{Country: "Germany", City: "Berlin", Hostname: "de-ber-wg-002"},
+		{Country: "Germany", City: "Frankfurt", Hostname: "de-fra-wg-001"},
+	})
+	if got := counts.Of(Location{Country: "Germany", City: "Berlin"}); got != 2 {
+		t.Errorf("Berlin count = %d, want 2", got)
+	}
+	if got := counts.Of(Location{Country: "Germany", City: "Frankfurt"}); got != 1 {
+		t.Errorf("Frankfurt count = %d, want 1", got)
+	}
+	if got := counts.Of(Location{Country: "Sweden", City: "Malmö"}); got != 0 {
+		t.Errorf("Malmö count = %d, want 0", got)
+	}
+}
+
+func TestFilterByPlace(t *testing.T) {
+	locations := []Location{
+		{Hostname: "us-nyc-1", Country: "USA", CountryCode: "us", City: "New York, NY", CityCode: "nyc"},
+		{Hostname: "us-lax-1", Country: "USA", CountryCode: "us", City: "Los Angeles, CA", CityCode: "lax"},
+		{Hostname: "se-got-1", Country: "Sweden", CountryCode: "se", City: "Gothenburg", CityCode: "got"},
+		{Hostname: "se-sto-1", Country: "Sweden", CountryCode: "se", City: "Stockholm", CityCode: "sto"},
+	}
+
+	tests := []struct {
+		place string
+		want  []string
+	}{
+		{"United States", []string{"us-nyc-1", "us-lax-1"}},
+		{"se", []string{"se-got-1", "se-sto-1"}},
+		{"gothenburg", []string{"se-got-1"}},
+		{"New York NY", []string{"us-nyc-1"}},
+		{"LAX", []string{"us-lax-1"}},
+		{"Atlantis", nil},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.place, func(t *testing.T) {
+			var got []string
+			for _, loc := range FilterByPlace(locations, tt.place) {
+				got = append(got, loc.Hostname)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestLookupCity(t *testing.T) {
+	file := &File{Locations: map[string]LocationEntry{
+		"us-nyc": {City: "New York, NY", Country: "USA", Latitude: 40.73, Longitude: -73.99},
+		"pt-lis": {City: "Lisbon", Country: "Portugal", Latitude: 38.72, Longitude: -9.14},
+		"jp-tyo": {City: "Tokyo", Country: "Japan", Latitude: 35.69, Longitude: 139.69},
+	}}
+
+	tests := []struct {
+		city    string
+		wantKey string
+	}{
+		{"Lisbon", "pt-lis"},
+		{"lisbon", "pt-lis"},
+		{"NYC", "us-nyc"},
+		{"New York", "us-nyc"},
+		{"New York NY", "us-nyc"},
+		{"jp-tyo", "jp-tyo"},
+		{"Atlantis", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.city, func(t *testing.T) {
+			key, entry, ok := file.LookupCity(tt.city)
+			if ok != (tt.wantKey != "") || key != tt.wantKey {
+				t.Fatalf("LookupCity(%q) = %q, %v, want %q", tt.city, key, ok, tt.wantKey)
+			}
+			if ok && entry != file.Locations[key] {
+				t.Errorf("Expected the entry of %s, got %+v", key, entry)
+			}
+		})
+	}
+}
@@ -0,0 +1,20 @@
+package relays
+
+// HasCoordinates reports whether the location has a position. relays.json occasionally lists a location at 0°, 0°
+// (Null Island, in the Gulf of Guinea), which only ever means its coordinates are missing.
+func (l Location) HasCoordinates() bool {
+	return l.Latitude != 0 || l.Longitude != 0
+}
+
+// SplitUnlocated separates the locations without coordinates from the rest, keeping the order of both
+func SplitUnlocated(locations []Location) (located, unlocated []Location) {
+	located = make([]Location, 0, len(locations))
+	for _, loc := range locations {
+		if loc.HasCoordinates() {
+			located = append(located, loc)
+		} else {
+			unlocated = append(unlocated, loc)
+		}
+	}
+	return located, unlocated
+}
@@ -0,0 +1,31 @@
+package relays
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestSplitUnlocated(t *testing.T) {
+	locations := []Location{
+		{Hostname: "de-fra-wg-001", Latitude: 50.11, Longitude: 8.68},
+		{Hostname: "xx-nul-wg-001"},
+		{Hostname: "gh-acc-wg-001", Latitude: 5.6, Longitude: 0},
+		{Hostname: "xx-nul-wg-002"},
+	}
+
+	located, unlocated := SplitUnlocated(locations)
+
+	hostnames := func(locs []Location) []string {
+		var names []string
+		for _, loc := range locs {
+			names = append(names, loc.Hostname)
+		}
+		return names
+	}
+	if got, want := hostnames(located), []string{"de-fra-wg-001", "gh-acc-wg-001"}; !slices.Equal(got, want) {
+		t.Errorf("located = %v, want %v", got, want)
+	}
+	if got, want := hostnames(unlocated), []string{"xx-nul-wg-001", "xx-nul-wg-002"}; !slices.Equal(got, want) {
+		t.Errorf("unlocated = %v, want %v", got, want)
+	}
+}
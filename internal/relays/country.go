@@ -0,0 +1,112 @@
+package relays
+
+import (
+	"strings"
+	"unicode"
+)
+
+// countryAliases maps normalized alternative country names to the normalized name used in relays.json
+var countryAliases = map[string]string{
+	"czechia":                  "czech republic",
+	"united kingdom":           "uk",
+	"great britain":            "uk",
+	"britain":                  "uk",
+	"england":                  "uk",
+	"united states":            "usa",
+	"united states of america": "usa",
+	"america":                  "usa",
+	"us":                       "usa",
+	"the netherlands":          "netherlands",
+	"holland":                  "netherlands",
+	"turkiye":                  "turkey",
+	"hongkong":                 "hong kong",
+}
+
+// diacriticFolds maps accented Latin letters to their unaccented base letter
+var diacriticFolds = map[rune]rune{
+	'á': 'a', 'à': 'a', 'â': 'a', 'ä': 'a', 'ã': 'a', 'å': 'a', 'ā': 'a', 'ą': 'a',
+	'ç': 'c', 'č': 'c', 'ć': 'c',
+	'ď': 'd', 'đ': 'd',
+	'é': 'e', 'è': 'e', 'ê': 'e', 'ë': 'e', 'ě': 'e', 'ē': 'e', 'ę': 'e',
+	'í': 'i', 'ì': 'i', 'î': 'i', 'ï': 'i', 'ī': 'i',
+	'ł': 'l',
+	'ñ': 'n', 'ń': 'n', 'ň': 'n',
+	'ó': 'o', 'ò': 'o', 'ô': 'o', 'ö': 'o', 'õ': 'o', 'ø': 'o', 'ō': 'o',
+	'ř': 'r',
+	'š': 's', 'ś': 's', 'ş': 's',
+	'ť': 't', 'ţ': 't',
+	'ú': 'u', 'ù': 'u', 'û': 'u', 'ü': 'u', 'ů': 'u', 'ū': 'u',
+	'ý': 'y', 'ÿ': 'y',
+	'ž': 'z', 'ź': 'z', 'ż': 'z',
+}
+
+// NormalizeCountry returns a canonical key for a country name or ISO 3166-1 alpha-2 code that is insensitive
+// to case, diacritics, punctuation, and well-known aliases (e.g. "Czechia" and "Czech Republic").
+// Two-letter codes are returned lowercased and are not resolved to names.
+func NormalizeCountry(s string) string {
+	var b strings.Builder
+	space := false
+	for _, r := range strings.ToLower(s) {
+		if folded, ok := diacriticFolds[r]; ok {
+			r = folded
+		}
+		switch {
+		case r == 'ß':
+			b.WriteString("ss")
+			space = false
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			if space && b.Len() > 0 {
+				b.WriteByte(' ')
+			}
+			b.WriteRune(r)
+			space = false
+		default:
+			space = true
+		}
+	}
+
+	key := b.String()
+	if alias, ok := countryAliases[key]; ok {
+		return alias
+	}
+	return key
+}
+
+// MatchesCountry returns true if the location is in the given country, specified by name, alias, or
+// ISO 3166-1 alpha-2 code
+func MatchesCountry(loc Location, country string) bool {
+	key := NormalizeCountry(country)
+	if key == "" {
+		return false
+	}
+	if key == NormalizeCountry(loc.Country) {
+		return true
+	}
+	return loc.CountryCode != "" && key == strings.ToLower(loc.CountryCode)
+}
+
+// FilterByCountry returns the locations in any of the given countries
+func FilterByCountry(locations []Location, countries []string) []Location {
+	var filtered []Location
+	for _, loc := range locations {
+		for _, country := range countries {
+			if MatchesCountry(loc, country) {
+				filtered = append(filtered, loc)
+				break
+			}
+		}
+	}
+	return filtered
+}
+
+// countryCodeFromLocationKey extracts the ISO 3166-1 alpha-2 country code from a location key such as "se-sto"
+func countryCodeFromLocationKey(key string) string {
+	code, _, _ := strings.Cut(key, "-")
+	return code
+}
+
+// cityCodeFromLocationKey extracts the Mullvad city code from a location key such as "se-sto"
+func cityCodeFromLocationKey(key string) string {
+	_, code, _ := strings.Cut(key, "-")
+	return code
+}
@@ -0,0 +1,114 @@
+package relays
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNormalizeCountry(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"Germany", "germany"},
+		{"  GERMANY ", "germany"},
+		{"Czech Republic", "czech republic"},
+		{"Czechia", "czech republic"},
+		{"czech-republic", "czech republic"},
+		{"Türkiye", "turkey"},
+		{"United States", "usa"},
+		{"United Kingdom", "uk"},
+		{"Curaçao", "curacao"},
+		{"Österreich", "osterreich"},
+		{"DE", "de"},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			if got := NormalizeCountry(tt.input); got != tt.want {
+				t.Errorf("NormalizeCountry(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchesCountry(t *testing.T) {
+	czech := Location{Country: "Czech Republic", CountryCode: "cz"}
+	uk := Location{Country: "UK", CountryCode: "gb"}
+
+	tests := []struct {
+		name    string
+		loc     Location
+		country string
+		want    bool
+	}{
+		{"Exact name", czech, "Czech Republic", true},
+		{"Alias", czech, "czechia", true},
+		{"Alpha-2 code", czech, "CZ", true},
+		{"Other country", czech, "Germany", false},
+		{"UK by code", uk, "gb", true},
+		{"UK by alias", uk, "Great Britain", true},
+		{"Empty query", czech, "", false},
+		{"No country code", Location{Country: "Sweden"}, "se", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MatchesCountry(tt.loc, tt.country); got != tt.want {
+				t.Errorf("MatchesCountry(%v, %q) = %v, want %v", tt.loc.Country, tt.country, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilterByCountry(t *testing.T) {
+	locations := []Location{
+		{Hostname: "cz-prg-wg-101", Country: "Czech Republic", CountryCode: "cz"},
+		{Hostname: "de-ber-wg-001", Country: "Germany", CountryCode: "de"},
+		{Hostname: "se-sto-wg-001", Country: "Sweden", CountryCode: "se"},
+	}
+
+	filtered := FilterByCountry(locations, []string{"czechia", "SE"})
+	if len(filtered) != 2 {
+		t.Fatalf("Expected 2 locations, got %d", len(filtered))
+	}
+	if filtered[0].Hostname != "cz-prg-wg-101" || filtered[1].Hostname != "se-sto-wg-001" {
+		t.Errorf("Unexpected filtered locations: %v", filtered)
+	}
+}
+
+func TestGetLocationsSetsCountryCode(t *testing.T) {
+	relays, err := ParseRelaysFile("../../testdata/relays.json")
+	if err != nil {
+		t.Fatalf("Failed to parse relays.json: %v", err)
+	}
+
+	locations, _, err := GetLocations(relays, ACNone, false, IPv4)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	for _, loc := range locations {
+		if len(loc.CountryCode) != 2 || loc.Hostname[:2] != loc.CountryCode {
+			t.Errorf("Expected country code matching hostname prefix for %s, got %q", loc.Hostname, loc.CountryCode)
+		}
+	}
+}
+
+func TestGetLocationsSetsCityCode(t *testing.T) {
+	relays, err := ParseRelaysFile("../../testdata/relays.json")
+	if err != nil {
+		t.Fatalf("Failed to parse relays.json: %v", err)
+	}
+
+	locations, _, err := GetLocations(relays, ACNone, false, IPv4)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	for _, loc := range locations {
+		prefix := loc.CountryCode + "-" + loc.CityCode + "-"
+		if loc.CityCode == "" || !strings.HasPrefix(loc.Hostname, prefix) {
+			t.Errorf("Expected city code matching hostname prefix for %s, got %q", loc.Hostname, loc.CityCode)
+		}
+	}
+}
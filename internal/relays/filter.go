@@ -0,0 +1,49 @@
+package relays
+
+// FilterOwned returns the locations of relays on hardware Mullvad owns
+func FilterOwned(locations []Location) []Location {
+	return filter(locations, func(loc Location) bool {
+		return loc.IsMullvadOwned
+	})
+}
+
+// FilterStboot drops the locations of relays the relay list says do not boot with stboot. Relays it says nothing
+// about are kept, as the Mullvad app's relays.json does not list stboot at all.
+func FilterStboot(locations []Location) []Location {
+	return filter(locations, func(loc Location) bool {
+		return loc.Stboot == nil || *loc.Stboot
+	})
+}
+
+// FilterAnyFeature returns the locations of relays offering at least one of the capabilities in features
+func FilterAnyFeature(locations []Location, features Feature) []Location {
+	return filter(locations, func(loc Location) bool {
+		return loc.Features&features != 0
+	})
+}
+
+// TopWeightPerCity returns the locations of the relays with the highest weight in their city, the ones the Mullvad
+// app prefers there, keeping the order of the locations
+func TopWeightPerCity(locations []Location) []Location {
+	top := make(map[string]int)
+	for _, loc := range locations {
+		key := CityKey(loc)
+		if weight, ok := top[key]; !ok || loc.Weight > weight {
+			top[key] = loc.Weight
+		}
+	}
+	return filter(locations, func(loc Location) bool {
+		return loc.Weight == top[CityKey(loc)]
+	})
+}
+
+// filter returns the locations keep reports true for, in their order
+func filter(locations []Location, keep func(Location) bool) []Location {
+	var filtered []Location
+	for _, loc := range locations {
+		if keep(loc) {
+			filtered = append(filtered, loc)
+		}
+	}
+	return filtered
+}
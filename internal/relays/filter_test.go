@@ -0,0 +1,45 @@
+package relays
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestFilters(t *testing.T) {
+	yes, no := true, false
+	locations := []Location{
+		{Hostname: "de-ber-1", Country: "Germany", City: "Berlin", IsMullvadOwned: true, Stboot: &yes, Weight: 100},
+		{Hostname: "de-ber-2", Country: "Germany", City: "Berlin", Weight: 200, Features: FeatureQUIC},
+		{Hostname: "de-ber-3", Country: "Germany", City: "Berlin", Stboot: &no, Weight: 200},
+		{Hostname: "de-fra-1", Country: "Germany", City: "Frankfurt", IsMullvadOwned: true, Weight: 50,
+			Features: FeatureShadowsocks | FeatureDAITA},
+		{Hostname: "de-fra-2", Country: "Germany", City: "Frankfurt", Weight: 10, Features: FeatureLWO},
+	}
+
+	tests := []struct {
+		name string
+		got  []Location
+		want []string
+	}{
+		{"Owned", FilterOwned(locations), []string{"de-ber-1", "de-fra-1"}},
+		{"Stboot", FilterStboot(locations), []string{"de-ber-1", "de-ber-2", "de-fra-1", "de-fra-2"}},
+		{
+			"Any feature",
+			FilterAnyFeature(locations, FeatureQUIC|FeatureShadowsocks),
+			[]string{"de-ber-2", "de-fra-1"},
+		},
+		{"Top weight per city", TopWeightPerCity(locations), []string{"de-ber-2", "de-ber-3", "de-fra-1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, loc := range tt.got {
+				got = append(got, loc.Hostname)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
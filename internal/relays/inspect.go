@@ -0,0 +1,252 @@
+package relays
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+)
+
+// Severity classifies a problem found in a relays file
+type Severity int
+
+// Severity constants
+const (
+	Warning Severity = iota // The affected entries are skipped or may be misplaced, the rest of the file is usable
+	Error                   // The file does not have the layout mullvad-compass expects
+)
+
+func (s Severity) String() string {
+	if s == Error {
+		return "error"
+	}
+	return "warning"
+}
+
+// Problem is an issue found in a relays file
+type Problem struct {
+	Severity Severity
+	Message  string
+}
+
+// Inspection summarizes a relays file checked by Inspect
+type Inspection struct {
+	Locations       int
+	WireGuardRelays int
+	BridgeRelays    int
+	InactiveRelays  int // Inactive or excluded from country selection, never selected
+	Problems        []Problem
+}
+
+// Errors returns the number of problems with Error severity
+func (in *Inspection) Errors() int {
+	count := 0
+	for _, p := range in.Problems {
+		if p.Severity == Error {
+			count++
+		}
+	}
+	return count
+}
+
+// knownEndpointData lists the endpoint_data formats of relay lists published by Mullvad
+var knownEndpointData = []string{"wireguard", "openvpn", "bridge"}
+
+// relaySection is one of the relay lists of a relays file
+type relaySection struct {
+	name  string // Section key, "wireguard" or "bridge"
+	label string // Name used in messages
+}
+
+// inspector collects the problems found while walking a relays file
+type inspector struct {
+	inspection Inspection
+	locations  map[string]LocationEntry
+	hostnames  map[string]string // Hostname to the section it was first seen in
+	addresses  map[string]string // Entry address to the first relay using it
+}
+
+// Inspect checks a relays file against the layout mullvad-compass expects, without dropping any relay as parsing
+// does. It reports missing sections and fields of the wrong type as errors, and unknown endpoint_data formats,
+// locations without coordinates, unknown location keys, malformed addresses and duplicate hostnames and addresses
+// as warnings. An error is only returned if the file cannot be read.
+func Inspect(path string) (*Inspection, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read relays file: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	x := &inspector{hostnames: make(map[string]string), addresses: make(map[string]string)}
+
+	data, err := io.ReadAll(newGuardReader(f, MaxFileSize, MaxNestingDepth))
+	if err != nil {
+		var limitErr *limitError
+		if !errors.As(err, &limitErr) {
+			return nil, fmt.Errorf("failed to read relays file: %w", err)
+		}
+		x.errorf("%v", err)
+		return &x.inspection, nil
+	}
+
+	var top map[string]json.RawMessage
+	if err := json.Unmarshal(data, &top); err != nil {
+		x.errorf("not a JSON object: %v", err)
+		return &x.inspection, nil
+	}
+
+	x.inspectLocations(top["locations"])
+	for _, section := range []relaySection{{name: "wireguard", label: "WireGuard"}, {name: "bridge", label: "bridge"}} {
+		x.inspectSection(section, top[section.name])
+	}
+	return &x.inspection, nil
+}
+
+// inspectLocations checks the locations map that relays refer to by key
+func (x *inspector) inspectLocations(raw json.RawMessage) {
+	if isNull(raw) {
+		x.errorf("missing locations section")
+		return
+	}
+
+	var entries map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &entries); err != nil {
+		x.errorf("locations: %v", err)
+		return
+	}
+
+	x.locations = make(map[string]LocationEntry, len(entries))
+	for _, key := range slices.Sorted(maps.Keys(entries)) {
+		var entry LocationEntry
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(entries[key], &entry); err != nil {
+			x.errorf("location %s: %v", key, err)
+			continue
+		}
+		_ = json.Unmarshal(entries[key], &fields)
+		if isNull(fields["latitude"]) || isNull(fields["longitude"]) || (entry.Latitude == 0 && entry.Longitude == 0) {
+			x.warnf("location %s has no coordinates, its relays are skipped unless --include-unlocated is given", key)
+		}
+		if entry.City == "" || entry.Country == "" {
+			x.warnf("location %s has no city or country name", key)
+		}
+		x.locations[key] = entry
+	}
+	x.inspection.Locations = len(x.locations)
+}
+
+// inspectSection checks the relays of a WireGuard or bridge section
+func (x *inspector) inspectSection(section relaySection, raw json.RawMessage) {
+	if isNull(raw) {
+		if section.name == "wireguard" {
+			x.errorf("missing wireguard section")
+		}
+		return
+	}
+
+	var fields struct {
+		Relays json.RawMessage `json:"relays"`
+	}
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		x.errorf("%s section: %v", section.name, err)
+		return
+	}
+	var relays []json.RawMessage
+	if err := json.Unmarshal(fields.Relays, &relays); err != nil && !isNull(fields.Relays) {
+		x.errorf("%s relays: %v", section.name, err)
+		return
+	}
+
+	for i, relay := range relays {
+		x.inspectRelay(section, i, relay)
+	}
+}
+
+// inspectRelay checks a single relay, counting it in its section
+func (x *inspector) inspectRelay(section relaySection, index int, raw json.RawMessage) {
+	// Bridge relays have a subset of the WireGuard relay fields
+	var relay WireGuardRelay
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &relay); err != nil {
+		x.errorf("%s relay %d: %v", section.name, index+1, err)
+		return
+	}
+	_ = json.Unmarshal(raw, &fields)
+
+	if relay.Hostname == "" {
+		x.errorf("%s relay %d has no hostname", section.name, index+1)
+		return
+	}
+	if section.name == "wireguard" {
+		x.inspection.WireGuardRelays++
+	} else {
+		x.inspection.BridgeRelays++
+	}
+	if !relay.Active || !relay.IncludeInCountry {
+		x.inspection.InactiveRelays++
+	}
+
+	if first, ok := x.hostnames[relay.Hostname]; ok {
+		x.warnf("duplicate hostname %s (also a %s relay)", relay.Hostname, first)
+	} else {
+		x.hostnames[relay.Hostname] = section.label
+	}
+
+	if _, ok := x.locations[relay.Location]; !ok && x.locations != nil {
+		x.warnf("relay %s has unknown location %q and is skipped", relay.Hostname, relay.Location)
+	}
+	if err := checkRelayAddresses(relay.Hostname, relay.IPv4AddrIn, relay.IPv6AddrIn); err != nil {
+		x.warnf("%v and is skipped", err)
+	}
+	if relay.IPv4AddrIn == "" && relay.IPv6AddrIn == "" {
+		x.warnf("relay %s has no entry address", relay.Hostname)
+	}
+	for _, addr := range []string{relay.IPv4AddrIn, relay.IPv6AddrIn} {
+		if addr == "" {
+			continue
+		}
+		if first, ok := x.addresses[addr]; ok {
+			x.warnf("relays %s and %s share the address %s", first, relay.Hostname, addr)
+		} else {
+			x.addresses[addr] = relay.Hostname
+		}
+	}
+
+	if endpoint, ok := fields["endpoint_data"]; ok && !knownEndpointFormat(endpoint) {
+		x.warnf("relay %s has unknown endpoint_data format %s", relay.Hostname, strings.TrimSpace(string(endpoint)))
+	}
+}
+
+// knownEndpointFormat reports whether endpoint_data is one of the known formats, either a plain string such as
+// "openvpn" or an object keyed by the format, such as {"wireguard": {...}}
+func knownEndpointFormat(raw json.RawMessage) bool {
+	var name string
+	if err := json.Unmarshal(raw, &name); err == nil {
+		return slices.Contains(knownEndpointData, name)
+	}
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &object); err != nil || len(object) != 1 {
+		return false
+	}
+	for name := range object {
+		return slices.Contains(knownEndpointData, name)
+	}
+	return false
+}
+
+func (x *inspector) errorf(format string, args ...any) {
+	x.inspection.Problems = append(x.inspection.Problems, Problem{Error, fmt.Sprintf(format, args...)})
+}
+
+func (x *inspector) warnf(format string, args ...any) {
+	x.inspection.Problems = append(x.inspection.Problems, Problem{Warning, fmt.Sprintf(format, args...)})
+}
+
+// isNull reports whether a JSON value is absent or null
+func isNull(raw json.RawMessage) bool {
+	return len(raw) == 0 || string(raw) == "null"
+}
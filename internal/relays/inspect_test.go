@@ -0,0 +1,111 @@
+package relays
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func writeInspectFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "relays.json")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestInspect(t *testing.T) {
+	t.Run("Shipped relay list", func(t *testing.T) {
+		inspection, err := Inspect("../../testdata/relays.json")
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if len(inspection.Problems) != 0 {
+			t.Errorf("Expected no problems, got %v", inspection.Problems)
+		}
+		if inspection.Locations == 0 || inspection.WireGuardRelays == 0 || inspection.BridgeRelays == 0 {
+			t.Errorf("Expected locations and relays to be counted, got %+v", inspection)
+		}
+	})
+
+	t.Run("Warnings", func(t *testing.T) {
+		path := writeInspectFile(t, `{
+			"locations": {
+				"se-got": {"city": "Gothenburg", "country": "Sweden", "latitude": 57.7, "longitude": 11.97},
+				"xx-nowhere": {"city": "Nowhere", "country": "Atlantis"}
+			},
+			"wireguard": {"relays": [
+				{"hostname": "se-got-wg-001", "active": true, "include_in_country": true, "location": "se-got",
+					"ipv4_addr_in": "10.0.0.1"},
+				{"hostname": "se-got-wg-001", "active": false, "location": "se-got", "ipv4_addr_in": "10.0.0.2"},
+				{"hostname": "se-got-wg-002", "active": true, "location": "se-got", "ipv4_addr_in": "10.0.0.1",
+					"endpoint_data": {"wireguard": {}}},
+				{"hostname": "se-got-wg-003", "location": "se-sto", "ipv4_addr_in": "10.0.0.300",
+					"endpoint_data": "ikev2"}
+			]}
+		}`)
+
+		inspection, err := Inspect(path)
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if inspection.Errors() != 0 {
+			t.Errorf("Expected only warnings, got %v", inspection.Problems)
+		}
+		if inspection.WireGuardRelays != 4 || inspection.InactiveRelays != 3 || inspection.BridgeRelays != 0 {
+			t.Errorf("Unexpected counts: %+v", inspection)
+		}
+
+		var messages []string
+		for _, p := range inspection.Problems {
+			messages = append(messages, p.Message)
+		}
+		want := []string{
+			"location xx-nowhere has no coordinates, its relays are skipped unless --include-unlocated is given",
+			"duplicate hostname se-got-wg-001 (also a WireGuard relay)",
+			"relays se-got-wg-001 and se-got-wg-002 share the address 10.0.0.1",
+			`relay se-got-wg-003 has unknown location "se-sto" and is skipped`,
+			`relay se-got-wg-003 has invalid IPv4 address "10.0.0.300" and is skipped`,
+			`relay se-got-wg-003 has unknown endpoint_data format "ikev2"`,
+		}
+		if !slices.Equal(messages, want) {
+			t.Errorf("Unexpected problems:\n%s\nwant:\n%s", strings.Join(messages, "\n"), strings.Join(want, "\n"))
+		}
+	})
+
+	t.Run("Structural errors", func(t *testing.T) {
+		tests := []struct {
+			name    string
+			content string
+			want    string
+		}{
+			{"Not an object", `[]`, "not a JSON object"},
+			{"Missing locations", `{"wireguard": {"relays": []}}`, "missing locations section"},
+			{"Missing wireguard", `{"locations": {}}`, "missing wireguard section"},
+			{"Relays not a list", `{"locations": {}, "wireguard": {"relays": {}}}`, "wireguard relays:"},
+			{"Wrong field type", `{"locations": {}, "wireguard": {"relays": [{"hostname": 1}]}}`, "wireguard relay 1:"},
+			{"Missing hostname", `{"locations": {}, "wireguard": {"relays": [{}]}}`, "wireguard relay 1 has no hostname"},
+			{"Bad coordinates", `{"locations": {"se-got": {"latitude": "57.7"}}, "wireguard": {}}`, "location se-got:"},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				inspection, err := Inspect(writeInspectFile(t, tt.content))
+				if err != nil {
+					t.Fatalf("Expected no error, got: %v", err)
+				}
+				if inspection.Errors() != 1 || !strings.HasPrefix(inspection.Problems[0].Message, tt.want) {
+					t.Errorf("Expected a single error starting with %q, got %v", tt.want, inspection.Problems)
+				}
+			})
+		}
+	})
+
+	t.Run("Missing file", func(t *testing.T) {
+		if _, err := Inspect(filepath.Join(t.TempDir(), "relays.json")); err == nil {
+			t.Error("Expected an error for a missing file")
+		}
+	})
+}
@@ -2,13 +2,18 @@
 package relays
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
 	"runtime"
+	"slices"
+	"strings"
 
+	"github.com/Ch00k/mullvad-compass/internal/errs"
 	"github.com/Ch00k/mullvad-compass/internal/logging"
 )
 
@@ -17,6 +22,10 @@ type File struct {
 	Locations map[string]LocationEntry `json:"locations"`
 	WireGuard WireGuardSection         `json:"wireguard"`
 	Bridge    BridgeSection            `json:"bridge"`
+
+	// Unselectable holds the hostnames of the relays that are inactive or excluded from country selection. The
+	// parser keeps nothing else of them, so that they can still be named, e.g. in the host lists.
+	Unselectable []string `json:"-"`
 }
 
 // LocationEntry represents a location in the locations map
@@ -29,7 +38,9 @@ type LocationEntry struct {
 
 // WireGuardSection represents the wireguard section of the relays file
 type WireGuardSection struct {
-	Relays []WireGuardRelay `json:"relays"`
+	PortRanges            []PortRange      `json:"port_ranges"`
+	ShadowsocksPortRanges []PortRange      `json:"shadowsocks_port_ranges"`
+	Relays                []WireGuardRelay `json:"relays"`
 }
 
 // WireGuardRelay represents a single WireGuard relay
@@ -41,15 +52,20 @@ type WireGuardRelay struct {
 	Provider               string        `json:"provider"`
 	IPv4AddrIn             string        `json:"ipv4_addr_in"`
 	IPv6AddrIn             string        `json:"ipv6_addr_in"`
+	Weight                 int           `json:"weight"`
 	IncludeInCountry       bool          `json:"include_in_country"`
 	PublicKey              string        `json:"public_key"`
 	Daita                  bool          `json:"daita"`
 	ShadowsocksExtraAddrIn []string      `json:"shadowsocks_extra_addr_in"`
 	Features               RelayFeatures `json:"features"`
+	Stboot                 *bool         `json:"stboot"` // nil when absent, as in the Mullvad app's relays.json
+
+	EndpointData json.RawMessage `json:"endpoint_data,omitempty"` // Only kept by the parser in an unknown format
 }
 
 // RelayFeatures represents anti-censorship capabilities on a WireGuard relay.
-// Each field is null when absent or a JSON object when present.
+// Each field is null when absent or a JSON object when present. Only their presence is read, so the parser does not
+// keep the objects' contents.
 type RelayFeatures struct {
 	Daita *json.RawMessage `json:"daita"`
 	QUIC  *json.RawMessage `json:"quic"`
@@ -58,7 +74,16 @@ type RelayFeatures struct {
 
 // BridgeSection represents the bridge section of the relays file
 type BridgeSection struct {
-	Relays []BridgeRelay `json:"relays"`
+	Shadowsocks []ShadowsocksEndpoint `json:"shadowsocks"`
+	Relays      []BridgeRelay         `json:"relays"`
+}
+
+// ShadowsocksEndpoint represents a Shadowsocks port offered by every bridge relay
+type ShadowsocksEndpoint struct {
+	Port     int    `json:"port"`
+	Cipher   string `json:"cipher"`
+	Password string `json:"password"`
+	Protocol string `json:"protocol"`
 }
 
 // BridgeRelay represents a single bridge relay
@@ -69,7 +94,29 @@ type BridgeRelay struct {
 	Location         string `json:"location"`
 	Provider         string `json:"provider"`
 	IPv4AddrIn       string `json:"ipv4_addr_in"`
+	IPv6AddrIn       string `json:"ipv6_addr_in"`
+	Weight           int    `json:"weight"`
 	IncludeInCountry bool   `json:"include_in_country"`
+
+	EndpointData json.RawMessage `json:"endpoint_data,omitempty"` // Only kept by the parser in an unknown format
+}
+
+// HasRelay returns true if a WireGuard or bridge relay with the hostname is listed, whether it can be selected or not
+func (f *File) HasRelay(hostname string) bool {
+	if slices.Contains(f.Unselectable, hostname) {
+		return true
+	}
+	for _, r := range f.WireGuard.Relays {
+		if r.Hostname == hostname {
+			return true
+		}
+	}
+	for _, r := range f.Bridge.Relays {
+		if r.Hostname == hostname {
+			return true
+		}
+	}
+	return false
 }
 
 // GetRelaysFilePath returns the platform-specific path to relays.json
@@ -77,46 +124,166 @@ func GetRelaysFilePath() (string, error) {
 	return GetRelaysFilePathWithLogLevel(logging.LogLevelError)
 }
 
-// GetRelaysFilePathWithLogLevel returns the platform-specific path to relays.json with logging support
+// RelaysSearchPathEnv names the environment variable with extra locations to look for relays.json in.
+// Entries are separated by the platform's list separator (":" on Linux and macOS, ";" on Windows) and may be
+// either files or directories containing relays.json. They are checked in order, before the default locations.
+const RelaysSearchPathEnv = "MULLVAD_COMPASS_RELAYS_PATH"
+
+// flatpakAppID is the application ID of the Mullvad VPN flatpak
+const flatpakAppID = "net.mullvad.MullvadVPN"
+
+// GetRelaysFilePathWithLogLevel returns the platform-specific path to relays.json with logging support.
+// MULLVAD_COMPASS_RELAYS_FILE pins a single file; otherwise the search path from RelaysSearchPathEnv and the
+// locations used by the official packages, snap, flatpak and Homebrew installs are checked in turn. The per-user
+// copy downloaded with --update-relays is preferred when it is newer than the file found, or when none was found.
 func GetRelaysFilePathWithLogLevel(logLevel logging.LogLevel) (string, error) {
-	var basePath string
-
-	switch {
-	case os.Getenv("MULLVAD_COMPASS_RELAYS_FILE") != "":
-		basePath = os.Getenv("MULLVAD_COMPASS_RELAYS_FILE")
-	case runtime.GOOS == "linux":
-		basePath = filepath.Join("/var/cache/mullvad-vpn", "relays.json")
-	case runtime.GOOS == "darwin":
-		basePath = filepath.Join("/Library/Caches/mullvad-vpn", "relays.json")
-	case runtime.GOOS == "windows":
+	if override := os.Getenv("MULLVAD_COMPASS_RELAYS_FILE"); override != "" {
+		if path, ok := findRelaysFile([]string{override}, logLevel); ok {
+			return path, nil
+		}
+		return "", relaysFileNotFound([]string{override}, logLevel)
+	}
+
+	searchPath := searchPathCandidates(os.Getenv(RelaysSearchPathEnv))
+	defaults, supported := defaultRelaysFileCandidates(runtime.GOOS)
+	candidates := make([]string, 0, len(searchPath)+len(defaults))
+	candidates = append(candidates, searchPath...)
+	candidates = append(candidates, defaults...)
+
+	path, found := findRelaysFile(candidates, logLevel)
+	if cachePath, ok := newerUserCache(path, logLevel); ok {
+		return cachePath, nil
+	}
+	if found {
+		return path, nil
+	}
+
+	if !supported && len(searchPath) == 0 {
+		if logLevel <= logging.LogLevelError {
+			log.Printf("Unsupported platform: %s", runtime.GOOS)
+		}
+		return "", fmt.Errorf("unsupported platform: %s", runtime.GOOS)
+	}
+	return "", relaysFileNotFound(candidates, logLevel)
+}
+
+// searchPathCandidates splits a search path into relays.json candidates, expanding directories
+func searchPathCandidates(searchPath string) []string {
+	var candidates []string
+	for _, entry := range filepath.SplitList(searchPath) {
+		if entry == "" {
+			continue
+		}
+		if info, err := os.Stat(entry); err == nil && info.IsDir() {
+			entry = filepath.Join(entry, "relays.json")
+		}
+		candidates = append(candidates, entry)
+	}
+	return candidates
+}
+
+// defaultRelaysFileCandidates returns the locations the Mullvad app keeps relays.json in on goos,
+// and false if the platform is not supported
+func defaultRelaysFileCandidates(goos string) ([]string, bool) {
+	switch goos {
+	case "linux":
+		candidates := []string{
+			filepath.Join("/var/cache/mullvad-vpn", "relays.json"),
+			filepath.Join("/var/snap/mullvad-vpn/common/cache", "relays.json"),
+		}
+		if home, err := os.UserHomeDir(); err == nil {
+			candidates = append(candidates,
+				filepath.Join(home, ".var", "app", flatpakAppID, "cache", "mullvad-vpn", "relays.json"))
+		}
+		return candidates, true
+	case "darwin":
+		return []string{
+			filepath.Join("/Library/Caches/mullvad-vpn", "relays.json"),
+			filepath.Join("/opt/homebrew/var/cache/mullvad-vpn", "relays.json"),
+			filepath.Join("/usr/local/var/cache/mullvad-vpn", "relays.json"),
+		}, true
+	case "windows":
 		programData := os.Getenv("ProgramData")
 		if programData == "" {
 			programData = "C:\\ProgramData"
 		}
-		basePath = filepath.Join(programData, "Mullvad VPN", "cache", "relays.json")
+		candidates := []string{filepath.Join(programData, "Mullvad VPN", "cache", "relays.json")}
+		// Per-user installs keep the cache under the local application data directory
+		if localAppData := os.Getenv("LOCALAPPDATA"); localAppData != "" {
+			candidates = append(candidates, filepath.Join(localAppData, "Mullvad VPN", "cache", "relays.json"))
+		}
+		// Portable installs have no cache, but ship the relay list in the app's resources directory
+		programFiles := os.Getenv("ProgramFiles")
+		if programFiles == "" {
+			programFiles = "C:\\Program Files"
+		}
+		candidates = append(candidates, filepath.Join(programFiles, "Mullvad VPN", "resources", "relays.json"))
+		return candidates, true
 	default:
-		if logLevel <= logging.LogLevelError {
-			log.Printf("Unsupported platform: %s", runtime.GOOS)
+		return nil, false
+	}
+}
+
+// findRelaysFile returns the first of candidates that exists, and false if none does
+func findRelaysFile(candidates []string, logLevel logging.LogLevel) (string, bool) {
+	for _, path := range candidates {
+		if logLevel <= logging.LogLevelDebug {
+			log.Printf("Looking for relays.json at: %s", path)
 		}
-		return "", fmt.Errorf("unsupported platform: %s", runtime.GOOS)
+
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			continue
+		}
+
+		if logLevel <= logging.LogLevelDebug {
+			log.Printf("Found relays.json at: %s", path)
+		}
+		return path, true
 	}
+	return "", false
+}
 
-	if logLevel <= logging.LogLevelDebug {
-		log.Printf("Looking for relays.json at: %s", basePath)
+// relaysFileNotFound reports that relays.json is at none of candidates
+func relaysFileNotFound(candidates []string, logLevel logging.LogLevel) error {
+	where := strings.Join(candidates, ", ")
+	if logLevel <= logging.LogLevelError {
+		log.Printf("relays.json not found at %s", where)
 	}
+	return fmt.Errorf("%w at %s", errs.ErrRelaysNotFound, where)
+}
 
-	if _, err := os.Stat(basePath); os.IsNotExist(err) {
-		if logLevel <= logging.LogLevelError {
-			log.Printf("relays.json not found at %s", basePath)
+// newerUserCache returns the per-user relay list if it exists and is newer than systemPath.
+// An empty systemPath means no other relay list was found.
+func newerUserCache(systemPath string, logLevel logging.LogLevel) (string, bool) {
+	cachePath, err := UserCacheFilePath()
+	if err != nil {
+		return "", false
+	}
+	cacheInfo, err := os.Stat(cachePath)
+	if err != nil {
+		return "", false
+	}
+
+	if systemPath != "" {
+		systemInfo, err := os.Stat(systemPath)
+		if err == nil && !cacheInfo.ModTime().After(systemInfo.ModTime()) {
+			return "", false
 		}
-		return "", fmt.Errorf("relays.json not found at %s", basePath)
 	}
 
 	if logLevel <= logging.LogLevelDebug {
-		log.Printf("Found relays.json at: %s", basePath)
+		log.Printf("Using per-user relay list at: %s", cachePath)
 	}
+	return cachePath, true
+}
 
-	return basePath, nil
+// UserCacheFilePath returns the path of the relay list downloaded with --update-relays, in the user's cache directory
+func UserCacheFilePath() (string, error) {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to determine cache directory: %w", err)
+	}
+	return filepath.Join(cacheDir, "mullvad-compass", "relays.json"), nil
 }
 
 // ParseRelaysFile reads and parses the relays.json file
@@ -124,26 +291,41 @@ func ParseRelaysFile(path string) (*File, error) {
 	return ParseRelaysFileWithLogLevel(path, logging.LogLevelError)
 }
 
-// ParseRelaysFileWithLogLevel reads and parses the relays.json file with logging support
+// ParseRelaysFileWithLogLevel reads and parses the relays.json file with logging support.
+// The file is decoded as a stream, keeping only the hostnames of relays which cannot be selected and dropping the
+// contents of features and endpoint_data that nothing reads.
 func ParseRelaysFileWithLogLevel(path string, logLevel logging.LogLevel) (*File, error) {
+	return ParseRelaysFileContext(context.Background(), path, logLevel)
+}
+
+// ParseRelaysFileContext is ParseRelaysFileWithLogLevel, stopping with the context's error once it is cancelled
+func ParseRelaysFileContext(ctx context.Context, path string, logLevel logging.LogLevel) (*File, error) {
 	if logLevel <= logging.LogLevelDebug {
 		log.Printf("Reading relays file from: %s", path)
 	}
 
-	data, err := os.ReadFile(path)
+	f, err := os.Open(path)
 	if err != nil {
 		if logLevel <= logging.LogLevelError {
 			log.Printf("Failed to read relays file at %s: %v", path, err)
 		}
 		return nil, fmt.Errorf("failed to read relays file: %w", err)
 	}
+	defer func() { _ = f.Close() }()
 
-	if logLevel <= logging.LogLevelInfo {
-		log.Printf("Read %d bytes from relays file", len(data))
-	}
-
-	var relays File
-	if err := json.Unmarshal(data, &relays); err != nil {
+	guard := newGuardReader(f, MaxFileSize, MaxNestingDepth)
+	relays, err := decodeFile(ctx, guard)
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		var limitErr *limitError
+		if errors.As(err, &limitErr) {
+			if logLevel <= logging.LogLevelError {
+				log.Printf("Rejected relays file: %v", err)
+			}
+			return nil, fmt.Errorf("invalid relays file: %w", err)
+		}
 		if logLevel <= logging.LogLevelError {
 			log.Printf("Failed to parse JSON from relays file: %v", err)
 		}
@@ -155,11 +337,11 @@ func ParseRelaysFileWithLogLevel(path string, logLevel logging.LogLevel) (*File,
 	bridgeRelayCount := len(relays.Bridge.Relays)
 
 	if logLevel <= logging.LogLevelInfo {
-		log.Printf("Parsed relays file: %d locations, %d WireGuard relays, %d bridge relays",
-			locationCount, wgRelayCount, bridgeRelayCount)
+		log.Printf("Parsed %d bytes from relays file: %d locations, %d WireGuard relays, %d bridge relays",
+			guard.maxSize-guard.remaining, locationCount, wgRelayCount, bridgeRelayCount)
 	}
 
-	return &relays, nil
+	return relays, nil
 }
 
 // shouldIncludeWireGuardRelay determines if a WireGuard relay should be included based on filter criteria
@@ -167,6 +349,7 @@ func shouldIncludeWireGuardRelay(
 	relay WireGuardRelay,
 	antiCensorship AntiCensorship,
 	daita bool,
+	exclude Feature,
 	ipVersion IPVersion,
 ) bool {
 	if !relay.Active {
@@ -181,6 +364,9 @@ func shouldIncludeWireGuardRelay(
 	if antiCensorship != ACNone && !matchesAntiCensorshipFeatures(relay, antiCensorship) {
 		return false
 	}
+	if exclude != 0 && relayFeatures(relay)&exclude != 0 {
+		return false
+	}
 	if ipVersion.IsIPv6() && relay.IPv6AddrIn == "" {
 		return false
 	}
@@ -197,18 +383,35 @@ func GetLocations(
 	antiCensorship AntiCensorship,
 	daita bool,
 	ipVersion IPVersion,
+) ([]Location, int, error) {
+	return GetLocationsContext(context.Background(), file, antiCensorship, daita, 0, ipVersion)
+}
+
+// GetLocationsContext is GetLocations, stopping with the context's error once it is cancelled, and leaving out the
+// relays with any of the excluded capabilities
+func GetLocationsContext(
+	ctx context.Context,
+	file *File,
+	antiCensorship AntiCensorship,
+	daita bool,
+	exclude Feature,
+	ipVersion IPVersion,
 ) ([]Location, int, error) {
 	locations := make([]Location, 0, len(file.WireGuard.Relays))
 	var skipped int
 
 	for _, relay := range file.WireGuard.Relays {
+		if err := ctx.Err(); err != nil {
+			return nil, 0, err
+		}
+
 		locEntry, ok := file.Locations[relay.Location]
 		if !ok {
 			skipped++
 			continue
 		}
 
-		if !shouldIncludeWireGuardRelay(relay, antiCensorship, daita, ipVersion) {
+		if !shouldIncludeWireGuardRelay(relay, antiCensorship, daita, exclude, ipVersion) {
 			continue
 		}
 
@@ -216,6 +419,8 @@ func GetLocations(
 			IPv4Address:    relay.IPv4AddrIn,
 			IPv6Address:    relay.IPv6AddrIn,
 			Country:        locEntry.Country,
+			CountryCode:    countryCodeFromLocationKey(relay.Location),
+			CityCode:       cityCodeFromLocationKey(relay.Location),
 			Latitude:       locEntry.Latitude,
 			Longitude:      locEntry.Longitude,
 			Hostname:       relay.Hostname,
@@ -224,6 +429,12 @@ func GetLocations(
 			IsActive:       relay.Active,
 			IsMullvadOwned: relay.Owned,
 			Provider:       relay.Provider,
+			PublicKey:      relay.PublicKey,
+			Weight:         relay.Weight,
+			Features:       relayFeatures(relay),
+			Stboot:         relay.Stboot,
+
+			ShadowsocksExtraAddresses: relay.ShadowsocksExtraAddrIn,
 		}
 
 		locations = append(locations, loc)
@@ -232,6 +443,59 @@ func GetLocations(
 	return locations, skipped, nil
 }
 
+// GetBridgeLocations extracts Location objects for the bridge relays in the relays file.
+// Returns the locations and the count of relays skipped due to unresolvable location keys.
+func GetBridgeLocations(file *File, ipVersion IPVersion) ([]Location, int, error) {
+	return GetBridgeLocationsContext(context.Background(), file, ipVersion)
+}
+
+// GetBridgeLocationsContext is GetBridgeLocations, stopping with the context's error once it is cancelled
+func GetBridgeLocationsContext(ctx context.Context, file *File, ipVersion IPVersion) ([]Location, int, error) {
+	locations := make([]Location, 0, len(file.Bridge.Relays))
+	var skipped int
+
+	for _, relay := range file.Bridge.Relays {
+		if err := ctx.Err(); err != nil {
+			return nil, 0, err
+		}
+
+		locEntry, ok := file.Locations[relay.Location]
+		if !ok {
+			skipped++
+			continue
+		}
+
+		if !relay.Active || !relay.IncludeInCountry {
+			continue
+		}
+		if ipVersion.IsIPv6() && relay.IPv6AddrIn == "" {
+			continue
+		}
+		if !ipVersion.IsIPv6() && relay.IPv4AddrIn == "" {
+			continue
+		}
+
+		locations = append(locations, Location{
+			IPv4Address:    relay.IPv4AddrIn,
+			IPv6Address:    relay.IPv6AddrIn,
+			Country:        locEntry.Country,
+			CountryCode:    countryCodeFromLocationKey(relay.Location),
+			CityCode:       cityCodeFromLocationKey(relay.Location),
+			Latitude:       locEntry.Latitude,
+			Longitude:      locEntry.Longitude,
+			Hostname:       relay.Hostname,
+			Type:           "bridge",
+			City:           locEntry.City,
+			IsActive:       relay.Active,
+			IsMullvadOwned: relay.Owned,
+			Provider:       relay.Provider,
+			Weight:         relay.Weight,
+		})
+	}
+
+	return locations, skipped, nil
+}
+
 // matchesAntiCensorshipFeatures checks if a relay matches the specified anti-censorship protocol
 func matchesAntiCensorshipFeatures(relay WireGuardRelay, ac AntiCensorship) bool {
 	switch ac {
@@ -2,12 +2,20 @@ package relays
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"log"
 	"os"
 	"path/filepath"
+	"reflect"
+	"slices"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/Ch00k/mullvad-compass/internal/errs"
+	"github.com/Ch00k/mullvad-compass/internal/logging"
 )
 
 func TestParseRelaysFile(t *testing.T) {
@@ -23,6 +31,36 @@ func TestParseRelaysFile(t *testing.T) {
 	if len(relays.WireGuard.Relays) == 0 {
 		t.Error("No WireGuard relays found in relays.json")
 	}
+
+	if len(relays.WireGuard.PortRanges) != 5 || relays.WireGuard.PortRanges[0] != (PortRange{53, 53}) {
+		t.Errorf("Unexpected WireGuard port ranges: %v", relays.WireGuard.PortRanges)
+	}
+
+	if len(relays.WireGuard.ShadowsocksPortRanges) != 1 ||
+		relays.WireGuard.ShadowsocksPortRanges[0] != (PortRange{51900, 51949}) {
+		t.Errorf("Unexpected Shadowsocks port ranges: %v", relays.WireGuard.ShadowsocksPortRanges)
+	}
+}
+
+func TestContextCancellation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := ParseRelaysFileContext(ctx, "../../testdata/relays.json", logging.LogLevelError)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("ParseRelaysFileContext: expected context.Canceled, got %v", err)
+	}
+
+	file, err := ParseRelaysFile("../../testdata/relays.json")
+	if err != nil {
+		t.Fatalf("Failed to parse relays.json: %v", err)
+	}
+	if _, _, err := GetLocationsContext(ctx, file, ACNone, false, 0, IPv4); !errors.Is(err, context.Canceled) {
+		t.Errorf("GetLocationsContext: expected context.Canceled, got %v", err)
+	}
+	if _, _, err := GetBridgeLocationsContext(ctx, file, IPv4); !errors.Is(err, context.Canceled) {
+		t.Errorf("GetBridgeLocationsContext: expected context.Canceled, got %v", err)
+	}
 }
 
 func TestGetRelaysFilePathHonorsEnvOverride(t *testing.T) {
@@ -46,8 +84,8 @@ func TestGetRelaysFilePathReportsMissingFile(t *testing.T) {
 	defer log.SetOutput(nil)
 
 	_, err := GetRelaysFilePath()
-	if err == nil {
-		t.Fatal("Expected error for missing relays file, got nil")
+	if !errors.Is(err, errs.ErrRelaysNotFound) {
+		t.Fatalf("Expected errs.ErrRelaysNotFound for missing relays file, got: %v", err)
 	}
 
 	if !strings.Contains(logBuf.String(), "relays.json not found") {
@@ -55,6 +93,164 @@ func TestGetRelaysFilePathReportsMissingFile(t *testing.T) {
 	}
 }
 
+func TestGetRelaysFilePathSearchPath(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "relays.json")
+	if err := os.WriteFile(file, []byte("{}"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	missing := filepath.Join(t.TempDir(), "absent.json")
+
+	tests := []struct {
+		name       string
+		searchPath string
+	}{
+		{"file", file},
+		{"directory", dir},
+		{"skips missing entries", strings.Join([]string{missing, "", dir}, string(os.PathListSeparator))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			isolateUserCache(t)
+			t.Setenv("MULLVAD_COMPASS_RELAYS_FILE", "")
+			t.Setenv(RelaysSearchPathEnv, tt.searchPath)
+
+			path, err := GetRelaysFilePath()
+			if err != nil {
+				t.Fatalf("GetRelaysFilePath failed: %v", err)
+			}
+			if path != file {
+				t.Errorf("Expected %s, got %s", file, path)
+			}
+		})
+	}
+}
+
+// isolateUserCache points the user cache directory at a temporary directory and returns the per-user relay list path
+func isolateUserCache(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	t.Setenv("XDG_CACHE_HOME", dir)
+	t.Setenv("HOME", dir)
+	t.Setenv("LocalAppData", dir)
+
+	path, err := UserCacheFilePath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestGetRelaysFilePathPrefersNewerUserCache(t *testing.T) {
+	cachePath := isolateUserCache(t)
+	if err := os.MkdirAll(filepath.Dir(cachePath), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(cachePath, []byte("{}"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	systemPath := filepath.Join(t.TempDir(), "relays.json")
+	if err := os.WriteFile(systemPath, []byte("{}"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	older, newer := now.Add(-time.Hour), now
+
+	tests := []struct {
+		name       string
+		searchPath string
+		cacheTime  time.Time
+		want       string
+	}{
+		{"newer user cache", systemPath, newer, cachePath},
+		{"older user cache", systemPath, older.Add(-time.Hour), systemPath},
+		// Newer than any relay list an installed Mullvad app may have
+		{"no system file", filepath.Join(t.TempDir(), "absent.json"), now.Add(time.Hour), cachePath},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("MULLVAD_COMPASS_RELAYS_FILE", "")
+			t.Setenv(RelaysSearchPathEnv, tt.searchPath)
+			if err := os.Chtimes(systemPath, older, older); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chtimes(cachePath, tt.cacheTime, tt.cacheTime); err != nil {
+				t.Fatal(err)
+			}
+
+			path, err := GetRelaysFilePath()
+			if err != nil {
+				t.Fatalf("GetRelaysFilePath failed: %v", err)
+			}
+			if path != tt.want {
+				t.Errorf("Expected %s, got %s", tt.want, path)
+			}
+		})
+	}
+}
+
+func TestDefaultRelaysFileCandidates(t *testing.T) {
+	t.Setenv("HOME", "/home/user")
+	t.Setenv("USERPROFILE", "/home/user")
+	t.Setenv("ProgramData", "/ProgramData")
+	t.Setenv("LOCALAPPDATA", "/Users/user/AppData/Local")
+	t.Setenv("ProgramFiles", "/Program Files")
+
+	tests := []struct {
+		goos      string
+		want      []string
+		supported bool
+	}{
+		{
+			goos: "linux",
+			want: []string{
+				"/var/cache/mullvad-vpn/relays.json",
+				"/var/snap/mullvad-vpn/common/cache/relays.json",
+				"/home/user/.var/app/net.mullvad.MullvadVPN/cache/mullvad-vpn/relays.json",
+			},
+			supported: true,
+		},
+		{
+			goos: "darwin",
+			want: []string{
+				"/Library/Caches/mullvad-vpn/relays.json",
+				"/opt/homebrew/var/cache/mullvad-vpn/relays.json",
+				"/usr/local/var/cache/mullvad-vpn/relays.json",
+			},
+			supported: true,
+		},
+		{
+			goos: "windows",
+			want: []string{
+				"/ProgramData/Mullvad VPN/cache/relays.json",
+				"/Users/user/AppData/Local/Mullvad VPN/cache/relays.json",
+				"/Program Files/Mullvad VPN/resources/relays.json",
+			},
+			supported: true,
+		},
+		{goos: "plan9", supported: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.goos, func(t *testing.T) {
+			got, supported := defaultRelaysFileCandidates(tt.goos)
+			if supported != tt.supported {
+				t.Fatalf("Expected supported=%v, got %v", tt.supported, supported)
+			}
+			var want []string
+			for _, path := range tt.want {
+				want = append(want, filepath.FromSlash(path))
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("Expected %v, got %v", want, got)
+			}
+		})
+	}
+}
+
 func TestParseRelaysFileErrors(t *testing.T) {
 	t.Run("Missing file", func(t *testing.T) {
 		var logBuf bytes.Buffer
@@ -90,6 +286,57 @@ func TestParseRelaysFileErrors(t *testing.T) {
 			t.Errorf("Expected parse-failure log, got: %q", logBuf.String())
 		}
 	})
+
+	t.Run("Oversized file", func(t *testing.T) {
+		// Leading whitespace is valid JSON, so only the size limit can reject this file
+		path := filepath.Join(t.TempDir(), "huge.json")
+		if err := os.WriteFile(path, bytes.Repeat([]byte(" "), MaxFileSize+1), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		var logBuf bytes.Buffer
+		log.SetOutput(&logBuf)
+		defer log.SetOutput(os.Stderr)
+
+		_, err := ParseRelaysFile(path)
+		if err == nil || !strings.Contains(err.Error(), "maximum size") {
+			t.Fatalf("Expected size limit error, got: %v", err)
+		}
+	})
+
+	t.Run("Excessive nesting", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "deep.json")
+		data := `{"locations": ` + strings.Repeat("[", 1000) + strings.Repeat("]", 1000) + `}`
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		var logBuf bytes.Buffer
+		log.SetOutput(&logBuf)
+		defer log.SetOutput(os.Stderr)
+
+		_, err := ParseRelaysFile(path)
+		if err == nil || !strings.Contains(err.Error(), "nesting") {
+			t.Fatalf("Expected nesting error, got: %v", err)
+		}
+	})
+
+	t.Run("Too many relays", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "many.json")
+		data := `{"wireguard": {"relays": [` + strings.Repeat("{},", MaxRelayCount) + `{}]}}`
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		var logBuf bytes.Buffer
+		log.SetOutput(&logBuf)
+		defer log.SetOutput(os.Stderr)
+
+		_, err := ParseRelaysFile(path)
+		if err == nil || !strings.Contains(err.Error(), "maximum of") {
+			t.Fatalf("Expected relay count error, got: %v", err)
+		}
+	})
 }
 
 func TestGetLocations(t *testing.T) {
@@ -143,6 +390,24 @@ func TestGetLocations(t *testing.T) {
 		}
 	})
 
+	t.Run("Carries the raw relay fields", func(t *testing.T) {
+		locations, _, err := GetLocations(relays, ACNone, false, IPv4)
+		if err != nil {
+			t.Fatalf("GetLocations failed: %v", err)
+		}
+
+		i := slices.IndexFunc(locations, func(loc Location) bool { return loc.Hostname == "al-tia-wg-003" })
+		if i < 0 {
+			t.Fatal("Expected al-tia-wg-003 among the locations")
+		}
+		if got := locations[i].PublicKey; got != "rWiQxq5lAWD8v/bws9ITSAvThyZW8cR2x+Ins9ZvvRo=" {
+			t.Errorf("PublicKey = %q, want the relay's public_key", got)
+		}
+		if got := locations[i].Weight; got != 100 {
+			t.Errorf("Weight = %d, want 100", got)
+		}
+	})
+
 	t.Run("Exclude relays with include_in_country=false", func(t *testing.T) {
 		testRelays := &File{
 			Locations: map[string]LocationEntry{
@@ -485,6 +750,18 @@ func TestGetLocations(t *testing.T) {
 		if len(ssLocs) != 1 || ssLocs[0].Hostname != "ss-server" {
 			t.Errorf("Shadowsocks filter: expected [ss-server], got %v", hostnames(ssLocs))
 		}
+
+		ctx := context.Background()
+		noQUIC, _, _ := GetLocationsContext(ctx, testRelays, ACNone, false, FeatureQUIC, IPv4)
+		if got := hostnames(noQUIC); !slices.Equal(got, []string{"lwo-server", "ss-server", "plain-server"}) {
+			t.Errorf("QUIC exclusion: expected [lwo-server ss-server plain-server], got %v", got)
+		}
+
+		excluded := FeatureLWO | FeatureQUIC | FeatureShadowsocks
+		plain, _, _ := GetLocationsContext(ctx, testRelays, ACNone, false, excluded, IPv4)
+		if got := hostnames(plain); !slices.Equal(got, []string{"plain-server"}) {
+			t.Errorf("Combined exclusion: expected [plain-server], got %v", got)
+		}
 	})
 
 	t.Run("Filter by DAITA with inline data", func(t *testing.T) {
@@ -520,6 +797,11 @@ func TestGetLocations(t *testing.T) {
 		if len(daitaLocs) != 1 || daitaLocs[0].Hostname != "daita-server" {
 			t.Errorf("DAITA filter: expected [daita-server], got %v", hostnames(daitaLocs))
 		}
+
+		noDaitaLocs, _, _ := GetLocationsContext(context.Background(), testRelays, ACNone, false, FeatureDAITA, IPv4)
+		if len(noDaitaLocs) != 1 || noDaitaLocs[0].Hostname != "plain-server" {
+			t.Errorf("DAITA exclusion: expected [plain-server], got %v", hostnames(noDaitaLocs))
+		}
 	})
 }
 
@@ -536,3 +818,96 @@ func hostnames(locs []Location) []string {
 	}
 	return names
 }
+
+func TestHasRelay(t *testing.T) {
+	relays, err := ParseRelaysFile("../../testdata/relays.json")
+	if err != nil {
+		t.Fatalf("Failed to parse relays.json: %v", err)
+	}
+
+	for hostname, want := range map[string]bool{
+		"de-ber-wg-001": true,
+		"au-syd-br-001": true,
+		"de-ber-wg-999": false,
+		"":              false,
+	} {
+		if got := relays.HasRelay(hostname); got != want {
+			t.Errorf("HasRelay(%q) = %v, want %v", hostname, got, want)
+		}
+	}
+}
+
+func TestGetBridgeLocations(t *testing.T) {
+	relays, err := ParseRelaysFile("../../testdata/relays.json")
+	if err != nil {
+		t.Fatalf("Failed to parse relays.json: %v", err)
+	}
+
+	t.Run("IPv4 bridges", func(t *testing.T) {
+		locations, skipped, err := GetBridgeLocations(relays, IPv4)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if skipped != 0 {
+			t.Errorf("Expected no skipped relays, got %d", skipped)
+		}
+		if len(locations) == 0 {
+			t.Fatal("Expected bridge locations")
+		}
+		for _, loc := range locations {
+			if loc.Type != "bridge" {
+				t.Errorf("Expected type bridge, got %s for %s", loc.Type, loc.Hostname)
+			}
+			if !strings.Contains(loc.Hostname, "-br-") {
+				t.Errorf("Expected bridge hostname, got %s", loc.Hostname)
+			}
+			if loc.City == "" || loc.Country == "" {
+				t.Errorf("Expected resolved location for %s", loc.Hostname)
+			}
+		}
+	})
+
+	t.Run("IPv6 excludes bridges without IPv6 address", func(t *testing.T) {
+		locations, _, err := GetBridgeLocations(relays, IPv6)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		for _, loc := range locations {
+			if loc.IPv6Address == "" {
+				t.Errorf("Expected IPv6 address for %s", loc.Hostname)
+			}
+		}
+	})
+
+	t.Run("Skips inactive and unresolvable relays", func(t *testing.T) {
+		file := &File{
+			Locations: map[string]LocationEntry{"se-sto": {City: "Stockholm", Country: "Sweden"}},
+			Bridge: BridgeSection{Relays: []BridgeRelay{
+				{Hostname: "se-sto-br-001", Active: true, IncludeInCountry: true, Location: "se-sto", IPv4AddrIn: "1.2.3.4"},
+				{Hostname: "se-sto-br-002", Active: false, IncludeInCountry: true, Location: "se-sto", IPv4AddrIn: "1.2.3.5"},
+				{Hostname: "xx-xxx-br-001", Active: true, IncludeInCountry: true, Location: "xx-xxx", IPv4AddrIn: "1.2.3.6"},
+			}},
+		}
+
+		locations, skipped, err := GetBridgeLocations(file, IPv4)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if len(locations) != 1 || locations[0].Hostname != "se-sto-br-001" {
+			t.Errorf("Expected only se-sto-br-001, got %v", locations)
+		}
+		if skipped != 1 {
+			t.Errorf("Expected 1 skipped relay, got %d", skipped)
+		}
+	})
+
+	t.Run("Parses bridge Shadowsocks endpoints", func(t *testing.T) {
+		if len(relays.Bridge.Shadowsocks) != 3 {
+			t.Fatalf("Expected 3 Shadowsocks endpoints, got %d", len(relays.Bridge.Shadowsocks))
+		}
+		first := relays.Bridge.Shadowsocks[0]
+		if first.Port != 443 || first.Protocol != "tcp" || first.Cipher != "aes-256-gcm" {
+			t.Errorf("Unexpected first Shadowsocks endpoint: %+v", first)
+		}
+	})
+}
@@ -0,0 +1,40 @@
+package relays
+
+import "math/rand/v2"
+
+// SampleByCity randomly selects at most perCity locations from every city, preserving the input order.
+// Cities with perCity or fewer locations are kept in full.
+func SampleByCity(locations []Location, perCity int, rng *rand.Rand) []Location {
+	byCity := make(map[string][]int)
+	var order []string
+	for i, loc := range locations {
+		key := CityKey(loc)
+		if _, ok := byCity[key]; !ok {
+			order = append(order, key)
+		}
+		byCity[key] = append(byCity[key], i)
+	}
+
+	selected := make([]bool, len(locations))
+	for _, key := range order {
+		indices := byCity[key]
+		if len(indices) > perCity {
+			rng.Shuffle(len(indices), func(i, j int) {
+				indices[i], indices[j] = indices[j], indices[i]
+			})
+			indices = indices[:perCity]
+		}
+		for _, idx := range indices {
+			selected[idx] = true
+		}
+	}
+
+	sampled := make([]Location, 0, len(locations))
+	for i, loc := range locations {
+		if selected[i] {
+			sampled = append(sampled, loc)
+		}
+	}
+
+	return sampled
+}
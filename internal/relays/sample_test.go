@@ -0,0 +1,77 @@
+package relays
+
+import (
+	"math/rand/v2"
+	"testing"
+)
+
+func makeCityLocations() []Location {
+	return []Location{
+		{Country: "Germany", City: "Berlin", Hostname: "de-ber-wg-001"},
+		{Country: "Germany", City: "Berlin", Hostname: "de-ber-wg-002"},
+		{Country: "Germany", City: "Berlin", Hostname: "de-ber-wg-003"},
+		{Country: "Germany", City: "Berlin", Hostname: "de-ber-wg-004"},
+		{Country: "Germany", City: "Frankfurt", Hostname: "de-fra-wg-001"},
+		{Country: "Czech Republic", City: "Prague", Hostname: "cz-prg-wg-101"},
+		{Country: "Czech Republic", City: "Prague", Hostname: "cz-prg-wg-102"},
+		{Country: "Czech Republic", City: "Prague", Hostname: "cz-prg-wg-103"},
+	}
+}
+
+func TestSampleByCity(t *testing.T) {
+	t.Run("Limits servers per city", func(t *testing.T) {
+		sampled := SampleByCity(makeCityLocations(), 2, rand.New(rand.NewPCG(1, 0)))
+
+		counts := make(map[string]int)
+		for _, loc := range sampled {
+			counts[loc.City]++
+		}
+		if counts["Berlin"] != 2 {
+			t.Errorf("Expected 2 Berlin servers, got %d", counts["Berlin"])
+		}
+		if counts["Frankfurt"] != 1 {
+			t.Errorf("Expected 1 Frankfurt server, got %d", counts["Frankfurt"])
+		}
+		if counts["Prague"] != 2 {
+			t.Errorf("Expected 2 Prague servers, got %d", counts["Prague"])
+		}
+	})
+
+	t.Run("Same seed yields same sample", func(t *testing.T) {
+		a := SampleByCity(makeCityLocations(), 1, rand.New(rand.NewPCG(42, 0)))
+		b := SampleByCity(makeCityLocations(), 1, rand.New(rand.NewPCG(42, 0)))
+
+		if len(a) != len(b) {
+			t.Fatalf("Expected equal sample sizes, got %d and %d", len(a), len(b))
+		}
+		for i := range a {
+			if a[i].Hostname != b[i].Hostname {
+				t.Errorf("Expected identical samples, got %s and %s at index %d", a[i].Hostname, b[i].Hostname, i)
+			}
+		}
+	})
+
+	t.Run("Preserves input order", func(t *testing.T) {
+		locations := makeCityLocations()
+		sampled := SampleByCity(locations, 2, rand.New(rand.NewPCG(7, 0)))
+
+		position := make(map[string]int)
+		for i, loc := range locations {
+			position[loc.Hostname] = i
+		}
+		for i := 1; i < len(sampled); i++ {
+			if position[sampled[i-1].Hostname] > position[sampled[i].Hostname] {
+				t.Errorf("Expected sampled locations to keep input order, got %s before %s",
+					sampled[i-1].Hostname, sampled[i].Hostname)
+			}
+		}
+	})
+
+	t.Run("Sample larger than city keeps everything", func(t *testing.T) {
+		locations := makeCityLocations()
+		sampled := SampleByCity(locations, 10, rand.New(rand.NewPCG(1, 0)))
+		if len(sampled) != len(locations) {
+			t.Errorf("Expected %d locations, got %d", len(locations), len(sampled))
+		}
+	})
+}
@@ -0,0 +1,300 @@
+package relays
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// limitError reports a relays file that exceeds one of the parsing limits
+type limitError struct {
+	msg string
+}
+
+func (e *limitError) Error() string {
+	return e.msg
+}
+
+// guardReader enforces a size and nesting limit, such as MaxFileSize and MaxNestingDepth, on a JSON stream as the
+// decoder consumes it, so that limits are checked without first reading the whole file into memory
+type guardReader struct {
+	r         io.Reader
+	maxSize   int64
+	remaining int64
+	maxDepth  int
+	depth     int
+	inString  bool
+	escaped   bool
+}
+
+// newGuardReader wraps r with size and nesting limits
+func newGuardReader(r io.Reader, maxSize int64, maxDepth int) *guardReader {
+	return &guardReader{r: r, maxSize: maxSize, remaining: maxSize, maxDepth: maxDepth}
+}
+
+// Read implements io.Reader, failing once the stream grows beyond the size or nesting limit
+func (g *guardReader) Read(p []byte) (int, error) {
+	n, err := g.r.Read(p)
+
+	g.remaining -= int64(n)
+	if g.remaining < 0 {
+		return 0, &limitError{fmt.Sprintf("file exceeds maximum size of %d bytes", g.maxSize)}
+	}
+
+	if depthErr := g.scan(p[:n]); depthErr != nil {
+		return 0, depthErr
+	}
+
+	return n, err
+}
+
+// scan tracks object/array nesting outside of string literals, leaving syntax validation to the JSON decoder
+func (g *guardReader) scan(data []byte) error {
+	for _, b := range data {
+		if g.inString {
+			switch {
+			case g.escaped:
+				g.escaped = false
+			case b == '\\':
+				g.escaped = true
+			case b == '"':
+				g.inString = false
+			}
+			continue
+		}
+
+		switch b {
+		case '"':
+			g.inString = true
+		case '{', '[':
+			g.depth++
+			if g.depth > g.maxDepth {
+				return &limitError{fmt.Sprintf("JSON nesting exceeds maximum depth of %d", g.maxDepth)}
+			}
+		case '}', ']':
+			g.depth--
+		}
+	}
+
+	return nil
+}
+
+// streamDecoder decodes relays.json one relay at a time
+type streamDecoder struct {
+	ctx          context.Context
+	dec          *json.Decoder
+	relayCount   int
+	unselectable []string
+}
+
+// featurePresent stands in for the object of a relay feature, whose presence is all that is read
+var featurePresent = json.RawMessage(`{}`)
+
+// decodeFile decodes a relays file from r in a single pass, holding one relay's JSON at a time. Of the relays that
+// are inactive or excluded from country selection only the hostnames are kept, in File.Unselectable. The others
+// keep their endpoint_data only in an unknown format, for strict mode to reject, and their features without the
+// objects' contents. Decoding stops with the context's error once it is cancelled.
+func decodeFile(ctx context.Context, r io.Reader) (*File, error) {
+	s := &streamDecoder{ctx: ctx, dec: json.NewDecoder(r)}
+	var file File
+
+	err := s.decodeObject(func(key string) error {
+		switch key {
+		case "locations":
+			return s.dec.Decode(&file.Locations)
+		case "wireguard":
+			return s.decodeWireGuardSection(&file.WireGuard)
+		case "bridge":
+			return s.decodeBridgeSection(&file.Bridge)
+		default:
+			return s.skipValue()
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Reject trailing data after the top-level object, as json.Unmarshal would
+	if _, err := s.dec.Token(); err != io.EOF {
+		if err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("unexpected data after top-level object")
+	}
+
+	file.Unselectable = s.unselectable
+	return &file, nil
+}
+
+// decodeWireGuardSection decodes the wireguard section, streaming its relays
+func (s *streamDecoder) decodeWireGuardSection(section *WireGuardSection) error {
+	return s.decodeObject(func(key string) error {
+		switch key {
+		case "port_ranges":
+			return s.dec.Decode(&section.PortRanges)
+		case "shadowsocks_port_ranges":
+			return s.dec.Decode(&section.ShadowsocksPortRanges)
+		case "relays":
+			return s.decodeArray(func() error {
+				var relay WireGuardRelay
+				if err := s.decodeRelay(&relay); err != nil {
+					return err
+				}
+				if !s.selectable(relay.Hostname, relay.Active, relay.IncludeInCountry) {
+					return nil
+				}
+				relay.EndpointData = unknownEndpointData(relay.EndpointData)
+				relay.Features = RelayFeatures{
+					Daita: featureMarker(relay.Features.Daita),
+					QUIC:  featureMarker(relay.Features.QUIC),
+					LWO:   featureMarker(relay.Features.LWO),
+				}
+				section.Relays = append(section.Relays, relay)
+				return nil
+			})
+		default:
+			return s.skipValue()
+		}
+	})
+}
+
+// decodeBridgeSection decodes the bridge section, streaming its relays
+func (s *streamDecoder) decodeBridgeSection(section *BridgeSection) error {
+	return s.decodeObject(func(key string) error {
+		switch key {
+		case "shadowsocks":
+			return s.dec.Decode(&section.Shadowsocks)
+		case "relays":
+			return s.decodeArray(func() error {
+				var relay BridgeRelay
+				if err := s.decodeRelay(&relay); err != nil {
+					return err
+				}
+				if !s.selectable(relay.Hostname, relay.Active, relay.IncludeInCountry) {
+					return nil
+				}
+				relay.EndpointData = unknownEndpointData(relay.EndpointData)
+				section.Relays = append(section.Relays, relay)
+				return nil
+			})
+		default:
+			return s.skipValue()
+		}
+	})
+}
+
+// decodeRelay decodes the next relay, enforcing MaxRelayCount across both relay lists
+func (s *streamDecoder) decodeRelay(v any) error {
+	if err := s.ctx.Err(); err != nil {
+		return err
+	}
+	s.relayCount++
+	if s.relayCount > MaxRelayCount {
+		return &limitError{fmt.Sprintf("relays file lists more than the maximum of %d relays", MaxRelayCount)}
+	}
+	return s.dec.Decode(v)
+}
+
+// selectable reports whether a relay can be selected, recording the hostname of one that cannot
+func (s *streamDecoder) selectable(hostname string, active, includeInCountry bool) bool {
+	if active && includeInCountry {
+		return true
+	}
+	s.unselectable = append(s.unselectable, hostname)
+	return false
+}
+
+// unknownEndpointData returns endpoint_data in an unknown format, which strict mode rejects, and nil otherwise
+func unknownEndpointData(endpoint json.RawMessage) json.RawMessage {
+	if endpoint == nil || knownEndpointFormat(endpoint) {
+		return nil
+	}
+	return endpoint
+}
+
+// featureMarker returns featurePresent for a feature the relay offers and nil otherwise
+func featureMarker(feature *json.RawMessage) *json.RawMessage {
+	if feature == nil {
+		return nil
+	}
+	return &featurePresent
+}
+
+// decodeObject reads a JSON object, calling field for each key with the decoder positioned at its value.
+// A null value is treated as an empty object.
+func (s *streamDecoder) decodeObject(field func(key string) error) error {
+	tok, err := s.dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok == nil {
+		return nil
+	}
+	if delim, ok := tok.(json.Delim); !ok || delim != '{' {
+		return fmt.Errorf("expected JSON object, got %v", tok)
+	}
+
+	for s.dec.More() {
+		tok, err := s.dec.Token()
+		if err != nil {
+			return err
+		}
+		key, ok := tok.(string)
+		if !ok {
+			return fmt.Errorf("expected object key, got %v", tok)
+		}
+		if err := field(key); err != nil {
+			return err
+		}
+	}
+
+	_, err = s.dec.Token() // closing '}'
+	return err
+}
+
+// decodeArray reads a JSON array, calling elem with the decoder positioned at each element.
+// A null value is treated as an empty array.
+func (s *streamDecoder) decodeArray(elem func() error) error {
+	tok, err := s.dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok == nil {
+		return nil
+	}
+	if delim, ok := tok.(json.Delim); !ok || delim != '[' {
+		return fmt.Errorf("expected JSON array, got %v", tok)
+	}
+
+	for s.dec.More() {
+		if err := elem(); err != nil {
+			return err
+		}
+	}
+
+	_, err = s.dec.Token() // closing ']'
+	return err
+}
+
+// skipValue consumes the next JSON value without retaining it
+func (s *streamDecoder) skipValue() error {
+	depth := 0
+	for {
+		tok, err := s.dec.Token()
+		if err != nil {
+			return err
+		}
+		if delim, ok := tok.(json.Delim); ok {
+			switch delim {
+			case '{', '[':
+				depth++
+			case '}', ']':
+				depth--
+			}
+		}
+		if depth == 0 {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,270 @@
+package relays
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestGuardReaderNesting(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		wantErr bool
+	}{
+		{"Flat object", `{"a": 1}`, false},
+		{"At limit", strings.Repeat("[", MaxNestingDepth) + strings.Repeat("]", MaxNestingDepth), false},
+		{"Over limit", strings.Repeat("[", MaxNestingDepth+1) + strings.Repeat("]", MaxNestingDepth+1), true},
+		{"Brackets inside strings are ignored", `{"a": "` + strings.Repeat("[", MaxNestingDepth+1) + `"}`, false},
+		{"Escaped quote does not end string", `{"a": "\"` + strings.Repeat("{", MaxNestingDepth+1) + `"}`, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := io.ReadAll(newGuardReader(strings.NewReader(tt.data), MaxFileSize, MaxNestingDepth))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ReadAll() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestGuardReaderNestingAcrossReads(t *testing.T) {
+	// One byte per read must not reset string or depth state between chunks
+	data := `{"a": "\"[[[", "b": ` + strings.Repeat("[", MaxNestingDepth) + strings.Repeat("]", MaxNestingDepth) + `}`
+	_, err := io.ReadAll(newGuardReader(iotestOneByte{strings.NewReader(data)}, MaxFileSize, MaxNestingDepth))
+
+	var limitErr *limitError
+	if !errors.As(err, &limitErr) {
+		t.Fatalf("Expected nesting limit error, got: %v", err)
+	}
+}
+
+func TestGuardReaderSize(t *testing.T) {
+	if _, err := io.ReadAll(newGuardReader(strings.NewReader("12345"), 5, MaxNestingDepth)); err != nil {
+		t.Errorf("Expected no error at the size limit, got: %v", err)
+	}
+
+	_, err := io.ReadAll(newGuardReader(strings.NewReader("123456"), 5, MaxNestingDepth))
+	var limitErr *limitError
+	if !errors.As(err, &limitErr) {
+		t.Errorf("Expected size limit error, got: %v", err)
+	} else if !strings.Contains(err.Error(), "maximum size of 5 bytes") {
+		t.Errorf("Expected the error to name the limit given, got: %v", err)
+	}
+}
+
+// iotestOneByte returns at most one byte per Read call
+type iotestOneByte struct {
+	r io.Reader
+}
+
+func (o iotestOneByte) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	return o.r.Read(p[:1])
+}
+
+func TestDecodeFileKeepsUnselectableHostnames(t *testing.T) {
+	data := `{
+		"etag": "\"abc\"",
+		"wireguard": {
+			"ipv4_gateway": "10.64.0.1",
+			"port_ranges": [[53, 53], [4000, 33433]],
+			"relays": [
+				{"hostname": "active", "active": true, "include_in_country": true, "location": "se-got",
+				 "ipv4_addr_in": "10.0.0.1"},
+				{"hostname": "inactive", "active": false, "include_in_country": true, "location": "se-got",
+				 "ipv4_addr_in": "10.0.0.2"},
+				{"hostname": "excluded", "active": true, "include_in_country": false, "location": "se-got",
+				 "ipv4_addr_in": "10.0.0.3"}
+			]
+		},
+		"bridge": {
+			"shadowsocks": [{"port": 443, "cipher": "aes-256-gcm", "password": "mullvad", "protocol": "tcp"}],
+			"relays": [
+				{"hostname": "bridge", "active": true, "include_in_country": true, "location": "se-got"},
+				{"hostname": "down-bridge", "active": false, "include_in_country": true, "location": "se-got"}
+			]
+		},
+		"locations": {"se-got": {"city": "Gothenburg", "country": "Sweden", "latitude": 57.7, "longitude": 11.97}}
+	}`
+
+	file, err := decodeFile(context.Background(), strings.NewReader(data))
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	if len(file.WireGuard.Relays) != 1 || len(file.Bridge.Relays) != 1 {
+		t.Errorf("Expected only the selectable relays to be kept whole, got %+v", file)
+	}
+	if want := []string{"inactive", "excluded", "down-bridge"}; !reflect.DeepEqual(file.Unselectable, want) {
+		t.Errorf("Expected the hostnames %v, got %v", want, file.Unselectable)
+	}
+	if !file.HasRelay("inactive") || !file.HasRelay("excluded") || !file.HasRelay("down-bridge") {
+		t.Error("Expected relays that cannot be selected to be known to HasRelay")
+	}
+
+	locations, _, err := GetLocations(file, ACNone, false, IPv4)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if len(locations) != 1 || locations[0].Hostname != "active" {
+		t.Errorf("Expected only the active relay to be selectable, got %+v", locations)
+	}
+	if !reflect.DeepEqual(file.WireGuard.PortRanges, []PortRange{{53, 53}, {4000, 33433}}) {
+		t.Errorf("Unexpected port ranges: %v", file.WireGuard.PortRanges)
+	}
+	if len(file.Bridge.Shadowsocks) != 1 || file.Bridge.Shadowsocks[0].Port != 443 {
+		t.Errorf("Unexpected Shadowsocks endpoints: %+v", file.Bridge.Shadowsocks)
+	}
+	if file.Locations["se-got"].City != "Gothenburg" {
+		t.Errorf("Expected locations decoded after relays to resolve, got %+v", file.Locations)
+	}
+}
+
+func TestDecodeFileDropsUnreadPayloads(t *testing.T) {
+	data := `{
+		"wireguard": {"relays": [
+			{"hostname": "known", "active": true, "include_in_country": true,
+			 "endpoint_data": {"wireguard": {"public_key": "abc"}},
+			 "features": {"quic": {"addr_in": ["10.0.0.9"], "domain": "known.example"}, "lwo": null}},
+			{"hostname": "unknown", "active": true, "include_in_country": true, "endpoint_data": {"carrier-pigeon": {}}}
+		]},
+		"bridge": {"relays": [{"hostname": "bridge", "active": true, "include_in_country": true,
+			"endpoint_data": "bridge"}]}
+	}`
+
+	file, err := decodeFile(context.Background(), strings.NewReader(data))
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	known, unknown := file.WireGuard.Relays[0], file.WireGuard.Relays[1]
+	if known.EndpointData != nil || file.Bridge.Relays[0].EndpointData != nil {
+		t.Errorf("Expected endpoint_data in a known format to be dropped, got %s", known.EndpointData)
+	}
+	if string(unknown.EndpointData) != `{"carrier-pigeon": {}}` {
+		t.Errorf("Expected endpoint_data in an unknown format to be kept, got %s", unknown.EndpointData)
+	}
+	if known.Features.QUIC == nil || string(*known.Features.QUIC) != "{}" || known.Features.LWO != nil {
+		t.Errorf("Expected only the presence of the features to be kept, got %+v", known.Features)
+	}
+	if _, err := ValidateEndpoints(file, true); err == nil || !strings.Contains(err.Error(), "unknown") {
+		t.Errorf("Expected strict mode to reject the unknown format, got: %v", err)
+	}
+}
+
+func TestDecodeFileMemory(t *testing.T) {
+	data, err := os.ReadFile("../../testdata/relays.json")
+	if err != nil {
+		t.Fatalf("Failed to read test data: %v", err)
+	}
+
+	// retained returns the heap still in use by what decode returns, once garbage is collected
+	retained := func(decode func() any) int64 {
+		var before, after runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&before)
+		v := decode()
+		runtime.GC()
+		runtime.ReadMemStats(&after)
+		runtime.KeepAlive(v)
+		return int64(after.HeapAlloc) - int64(before.HeapAlloc)
+	}
+
+	streamed := retained(func() any {
+		file, err := decodeFile(context.Background(), bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		return file
+	})
+	unmarshaled := retained(func() any {
+		var file File
+		if err := json.Unmarshal(data, &file); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		return &file
+	})
+	runtime.KeepAlive(data)
+
+	if streamed*3 > unmarshaled*2 {
+		t.Errorf("Expected at most two thirds of the memory json.Unmarshal holds, got %d and %d bytes",
+			streamed, unmarshaled)
+	}
+}
+
+func TestDecodeFileErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"Not an object", `[]`},
+		{"Truncated", `{"wireguard": {"relays": [{"hostname": "a"}`},
+		{"Wrong relays type", `{"wireguard": {"relays": {}}}`},
+		{"Trailing data", `{} {}`},
+		{"Wrong field type", `{"locations": {"se-got": {"city": 1}}}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := decodeFile(context.Background(), strings.NewReader(tt.data)); err == nil {
+				t.Error("Expected error, got nil")
+			}
+		})
+	}
+}
+
+func TestDecodeFileAcceptsNullSections(t *testing.T) {
+	file, err := decodeFile(context.Background(), strings.NewReader(`{"wireguard": null, "bridge": {"relays": null}}`))
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if len(file.WireGuard.Relays) != 0 || len(file.Bridge.Relays) != 0 {
+		t.Errorf("Expected no relays, got %+v", file)
+	}
+}
+
+func TestDecodeFileMatchesUnmarshal(t *testing.T) {
+	streamed, err := ParseRelaysFile("../../testdata/relays.json")
+	if err != nil {
+		t.Fatalf("Failed to parse relays.json: %v", err)
+	}
+
+	var full File
+	data, err := os.ReadFile("../../testdata/relays.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, &full); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, ipVersion := range []IPVersion{IPv4, IPv6} {
+		want, _, _ := GetLocations(&full, ACNone, false, ipVersion)
+		got, _, _ := GetLocations(streamed, ACNone, false, ipVersion)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Streamed WireGuard locations differ from full unmarshal for %v", ipVersion)
+		}
+
+		want, _, _ = GetBridgeLocations(&full, ipVersion)
+		got, _, _ = GetBridgeLocations(streamed, ipVersion)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Streamed bridge locations differ from full unmarshal for %v", ipVersion)
+		}
+	}
+
+	if !reflect.DeepEqual(streamed.WireGuard.PortRanges, full.WireGuard.PortRanges) ||
+		!reflect.DeepEqual(streamed.Bridge.Shadowsocks, full.Bridge.Shadowsocks) {
+		t.Error("Streamed port ranges or Shadowsocks endpoints differ from full unmarshal")
+	}
+}
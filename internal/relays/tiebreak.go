@@ -0,0 +1,111 @@
+package relays
+
+import (
+	"fmt"
+	"math/rand/v2"
+)
+
+// TieBreak is the policy picking the best server among servers with near-equal latency
+type TieBreak int
+
+// Tie-break policies
+const (
+	TieBreakFirst        TieBreak = iota // The first after sorting
+	TieBreakRandom                       // Uniformly at random
+	TieBreakWeight                       // At random by relay weight, like the Mullvad app
+	TieBreakAlphabetical                 // The first by hostname
+)
+
+func (t TieBreak) String() string {
+	switch t {
+	case TieBreakRandom:
+		return "random"
+	case TieBreakWeight:
+		return "weight"
+	case TieBreakAlphabetical:
+		return "alphabetical"
+	default:
+		return "first"
+	}
+}
+
+// ParseTieBreak parses a tie-break policy string into its type.
+func ParseTieBreak(s string) (TieBreak, error) {
+	switch s {
+	case "first":
+		return TieBreakFirst, nil
+	case "random":
+		return TieBreakRandom, nil
+	case "weight":
+		return TieBreakWeight, nil
+	case "alphabetical":
+		return TieBreakAlphabetical, nil
+	default:
+		return TieBreakFirst, fmt.Errorf(
+			"invalid tie-break policy: %s (must be 'first', 'random', 'weight', or 'alphabetical')",
+			s,
+		)
+	}
+}
+
+// Ties returns the number of ranked locations at the front responding within margin milliseconds of the first,
+// including the first itself. It returns 0 if the first did not respond.
+func Ties(ranked []Location, margin float64) int {
+	if len(ranked) == 0 || ranked[0].Latency == nil {
+		return 0
+	}
+	limit := *ranked[0].Latency + margin
+	n := 1
+	for n < len(ranked) && ranked[n].Latency != nil && *ranked[n].Latency <= limit {
+		n++
+	}
+	return n
+}
+
+// BreakTie moves the location the policy picks among the first ties ranked locations to the front, keeping the
+// order of the others. It returns the index the picked location had.
+func BreakTie(ranked []Location, ties int, policy TieBreak, rng *rand.Rand) int {
+	if ties < 2 {
+		return 0
+	}
+
+	var picked int
+	switch policy {
+	case TieBreakRandom:
+		picked = rng.IntN(ties)
+	case TieBreakWeight:
+		picked = pickByWeight(ranked[:ties], rng)
+	case TieBreakAlphabetical:
+		for i := 1; i < ties; i++ {
+			if ranked[i].Hostname < ranked[picked].Hostname {
+				picked = i
+			}
+		}
+	}
+
+	best := ranked[picked]
+	copy(ranked[1:picked+1], ranked[:picked])
+	ranked[0] = best
+	return picked
+}
+
+// pickByWeight returns the index of a location picked at random in proportion to its weight, uniformly if no
+// location has a weight
+func pickByWeight(locations []Location, rng *rand.Rand) int {
+	var total int
+	for _, loc := range locations {
+		total += max(loc.Weight, 0)
+	}
+	if total == 0 {
+		return rng.IntN(len(locations))
+	}
+
+	n := rng.IntN(total)
+	for i, loc := range locations {
+		n -= max(loc.Weight, 0)
+		if n < 0 {
+			return i
+		}
+	}
+	return len(locations) - 1
+}
@@ -0,0 +1,116 @@
+package relays
+
+import (
+	"math/rand/v2"
+	"slices"
+	"testing"
+)
+
+func TestParseTieBreak(t *testing.T) {
+	for _, policy := range []TieBreak{TieBreakFirst, TieBreakRandom, TieBreakWeight, TieBreakAlphabetical} {
+		got, err := ParseTieBreak(policy.String())
+		if err != nil || got != policy {
+			t.Errorf("ParseTieBreak(%q) = %v, %v, want %v", policy.String(), got, err, policy)
+		}
+	}
+	if _, err := ParseTieBreak("fastest"); err == nil {
+		t.Error("Expected an error for an unknown policy")
+	}
+}
+
+func TestTies(t *testing.T) {
+	latency := func(ms float64) *float64 { return &ms }
+	ranked := []Location{
+		{Hostname: "a", Latency: latency(10)},
+		{Hostname: "b", Latency: latency(10.5)},
+		{Hostname: "c", Latency: latency(11)},
+		{Hostname: "d", Latency: latency(11.5)},
+		{Hostname: "e"},
+	}
+
+	tests := []struct {
+		name   string
+		ranked []Location
+		margin float64
+		want   int
+	}{
+		{"Within the margin", ranked, 1, 3},
+		{"No margin", ranked, 0, 1},
+		{"Timeouts never tie", ranked, 100, 4},
+		{"First timed out", ranked[4:], 1, 0},
+		{"Empty", nil, 1, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Ties(tt.ranked, tt.margin); got != tt.want {
+				t.Errorf("Ties() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBreakTie(t *testing.T) {
+	ranked := func() []Location {
+		return []Location{
+			{Hostname: "se-sto-wg-003", Weight: 0},
+			{Hostname: "se-sto-wg-001", Weight: 100},
+			{Hostname: "se-sto-wg-002", Weight: 0},
+			{Hostname: "se-got-wg-001", Weight: 500},
+		}
+	}
+	hostnames := func(locs []Location) []string {
+		names := make([]string, len(locs))
+		for i, loc := range locs {
+			names[i] = loc.Hostname
+		}
+		return names
+	}
+
+	t.Run("First keeps the order", func(t *testing.T) {
+		locs := ranked()
+		if picked := BreakTie(locs, 3, TieBreakFirst, nil); picked != 0 {
+			t.Errorf("Picked %d, want 0", picked)
+		}
+		if !slices.Equal(hostnames(locs), hostnames(ranked())) {
+			t.Errorf("Order changed to %v", hostnames(locs))
+		}
+	})
+
+	t.Run("Alphabetical moves the first hostname to the front", func(t *testing.T) {
+		locs := ranked()
+		BreakTie(locs, 3, TieBreakAlphabetical, nil)
+		want := []string{"se-sto-wg-001", "se-sto-wg-003", "se-sto-wg-002", "se-got-wg-001"}
+		if got := hostnames(locs); !slices.Equal(got, want) {
+			t.Errorf("Order = %v, want %v", got, want)
+		}
+	})
+
+	t.Run("Weight only picks weighted ties", func(t *testing.T) {
+		for seed := range uint64(20) {
+			locs := ranked()
+			BreakTie(locs, 3, TieBreakWeight, rand.New(rand.NewPCG(seed, 0)))
+			if locs[0].Hostname != "se-sto-wg-001" {
+				t.Fatalf("Seed %d picked %s, want the only weighted tie", seed, locs[0].Hostname)
+			}
+		}
+	})
+
+	t.Run("Random stays among the ties", func(t *testing.T) {
+		for seed := range uint64(20) {
+			locs := ranked()
+			if picked := BreakTie(locs, 3, TieBreakRandom, rand.New(rand.NewPCG(seed, 0))); picked > 2 {
+				t.Fatalf("Seed %d picked %d, beyond the ties", seed, picked)
+			}
+			if locs[3].Hostname != "se-got-wg-001" {
+				t.Fatalf("Seed %d moved a location that does not tie", seed)
+			}
+		}
+	})
+
+	t.Run("No tie", func(t *testing.T) {
+		locs := ranked()
+		if picked := BreakTie(locs, 1, TieBreakRandom, nil); picked != 0 {
+			t.Errorf("Picked %d, want 0", picked)
+		}
+	})
+}
@@ -1,6 +1,9 @@
 package relays
 
-import "fmt"
+import (
+	"fmt"
+	"strconv"
+)
 
 // AntiCensorship represents the anti-censorship protocol for WireGuard connections.
 type AntiCensorship int
@@ -28,6 +31,20 @@ func (w AntiCensorship) String() string {
 	}
 }
 
+// Feature returns the relay capability offering the protocol, or 0 for ACNone
+func (w AntiCensorship) Feature() Feature {
+	switch w {
+	case LWO:
+		return FeatureLWO
+	case QUIC:
+		return FeatureQUIC
+	case Shadowsocks:
+		return FeatureShadowsocks
+	default:
+		return 0
+	}
+}
+
 // ParseAntiCensorship parses an anti-censorship protocol string into its type.
 func ParseAntiCensorship(s string) (AntiCensorship, error) {
 	switch s {
@@ -44,6 +61,38 @@ func ParseAntiCensorship(s string) (AntiCensorship, error) {
 	}
 }
 
+// ServerType represents the kind of relay to search (WireGuard or bridge).
+type ServerType int
+
+// Server type constants
+const (
+	WireGuardServer ServerType = iota // WireGuard relays
+	BridgeServer                      // Shadowsocks bridge relays
+)
+
+func (t ServerType) String() string {
+	switch t {
+	case WireGuardServer:
+		return "wireguard"
+	case BridgeServer:
+		return "bridge"
+	default:
+		return "wireguard"
+	}
+}
+
+// ParseServerType parses a server type string into its type.
+func ParseServerType(s string) (ServerType, error) {
+	switch s {
+	case "wireguard":
+		return WireGuardServer, nil
+	case "bridge":
+		return BridgeServer, nil
+	default:
+		return WireGuardServer, fmt.Errorf("invalid server type: %s (must be 'wireguard' or 'bridge')", s)
+	}
+}
+
 // IPVersion represents the IP protocol version (IPv4 or IPv6).
 type IPVersion int
 
@@ -74,14 +123,36 @@ type Location struct {
 	IPv4Address            string
 	IPv6Address            string
 	Country                string
+	CountryCode            string // ISO 3166-1 alpha-2 code, lowercase
+	CityCode               string // Mullvad city code, e.g. "got" for Gothenburg
 	Latitude               float64
 	Longitude              float64
 	Hostname               string
-	Type                   string // "wireguard"
+	Type                   string // "wireguard" or "bridge"
 	City                   string
 	IsActive               bool
 	IsMullvadOwned         bool
 	Provider               string
+	PublicKey              string   // WireGuard public key in base64, empty for bridges
+	Weight                 int      // Relative weight by which the Mullvad app picks among matching relays
 	Latency                *float64 // nil indicates timeout or error
+	LatencyIPv6            *float64 // Latency over IPv6 when both IP versions are pinged, nil on timeout
 	DistanceFromMyLocation *float64
+	Favorite               bool    // Marked with "mullvad-compass favorite add"
+	Features               Feature // Capabilities of WireGuard relays
+	Stboot                 *bool   // Boots a verified image into RAM with stboot, nil if the relay list does not say
+	ASN                    uint    // Autonomous system announcing the address, 0 if unknown
+	ASNOrganization        string  // Operator of the autonomous system
+
+	ShadowsocksExtraAddresses []string // Addresses accepting Shadowsocks on any port
+}
+
+// PortRange represents an inclusive range of ports, encoded as a [first, last] pair in relays.json
+type PortRange [2]int
+
+func (r PortRange) String() string {
+	if r[0] == r[1] {
+		return strconv.Itoa(r[0])
+	}
+	return fmt.Sprintf("%d-%d", r[0], r[1])
 }
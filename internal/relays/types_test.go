@@ -25,6 +25,24 @@ func TestAntiCensorshipString(t *testing.T) {
 	}
 }
 
+func TestAntiCensorshipFeature(t *testing.T) {
+	tests := []struct {
+		obf  AntiCensorship
+		want Feature
+	}{
+		{LWO, FeatureLWO},
+		{QUIC, FeatureQUIC},
+		{Shadowsocks, FeatureShadowsocks},
+		{ACNone, 0},
+	}
+
+	for _, tt := range tests {
+		if got := tt.obf.Feature(); got != tt.want {
+			t.Errorf("%v.Feature() = %v, want %v", tt.obf, got, tt.want)
+		}
+	}
+}
+
 func TestParseAntiCensorship(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -91,3 +109,50 @@ func TestIPVersionIsIPv6(t *testing.T) {
 		})
 	}
 }
+
+func TestPortRangeString(t *testing.T) {
+	tests := []struct {
+		name string
+		r    PortRange
+		want string
+	}{
+		{"Single port", PortRange{53, 53}, "53"},
+		{"Range", PortRange{4000, 33433}, "4000-33433"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.r.String(); got != tt.want {
+				t.Errorf("PortRange.String() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseServerType(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    ServerType
+		wantErr bool
+	}{
+		{"wireguard", WireGuardServer, false},
+		{"bridge", BridgeServer, false},
+		{"openvpn", WireGuardServer, true},
+		{"", WireGuardServer, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseServerType(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseServerType(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseServerType(%q) = %v, want %v", tt.input, got, tt.want)
+			}
+			if !tt.wantErr && got.String() != tt.input {
+				t.Errorf("ServerType.String() = %v, want %v", got.String(), tt.input)
+			}
+		})
+	}
+}
@@ -0,0 +1,145 @@
+package relays
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/Ch00k/mullvad-compass/internal/netguard"
+)
+
+// Limits applied when parsing relays.json so that a corrupted or hostile cache file cannot exhaust memory
+const (
+	// MaxFileSize is the largest relays.json accepted, in bytes (the real file is well under 1 MiB)
+	MaxFileSize = 32 << 20
+	// MaxNestingDepth is the deepest JSON object/array nesting accepted
+	MaxNestingDepth = 32
+	// MaxRelayCount is the largest number of WireGuard plus bridge relays accepted
+	MaxRelayCount = 50000
+)
+
+// validAddress reports whether addr is empty (absent) or a literal IP address of the expected family
+func validAddress(addr string, ipv6 bool) bool {
+	if addr == "" {
+		return true
+	}
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	return (ip.To4() == nil) == ipv6
+}
+
+// ValidateEndpoints checks the entry addresses of every relay in the file.
+// In strict mode the first malformed endpoint, or endpoint_data of an unknown format, is returned as an error.
+// Otherwise relays with a malformed IPv4 or IPv6 entry address are removed from the file, malformed Shadowsocks
+// extra addresses are dropped, and the number of skipped endpoints is returned.
+func ValidateEndpoints(file *File, strict bool) (int, error) {
+	var skipped int
+
+	wgRelays := file.WireGuard.Relays[:0]
+	for _, relay := range file.WireGuard.Relays {
+		if err := checkRelayAddresses(relay.Hostname, relay.IPv4AddrIn, relay.IPv6AddrIn); err != nil {
+			if strict {
+				return skipped, err
+			}
+			skipped++
+			continue
+		}
+		if strict {
+			if err := checkEndpointData(relay.Hostname, relay.EndpointData); err != nil {
+				return skipped, err
+			}
+		}
+
+		extra := make([]string, 0, len(relay.ShadowsocksExtraAddrIn))
+		for _, addr := range relay.ShadowsocksExtraAddrIn {
+			if net.ParseIP(addr) == nil {
+				if strict {
+					return skipped, fmt.Errorf("relay %s has invalid Shadowsocks address %q", relay.Hostname, addr)
+				}
+				skipped++
+				continue
+			}
+			extra = append(extra, addr)
+		}
+		relay.ShadowsocksExtraAddrIn = extra
+
+		wgRelays = append(wgRelays, relay)
+	}
+	file.WireGuard.Relays = wgRelays
+
+	bridgeRelays := file.Bridge.Relays[:0]
+	for _, relay := range file.Bridge.Relays {
+		if err := checkRelayAddresses(relay.Hostname, relay.IPv4AddrIn, relay.IPv6AddrIn); err != nil {
+			if strict {
+				return skipped, err
+			}
+			skipped++
+			continue
+		}
+		if strict {
+			if err := checkEndpointData(relay.Hostname, relay.EndpointData); err != nil {
+				return skipped, err
+			}
+		}
+		bridgeRelays = append(bridgeRelays, relay)
+	}
+	file.Bridge.Relays = bridgeRelays
+
+	return skipped, nil
+}
+
+// checkRelayAddresses returns an error describing the first malformed entry address of a relay
+func checkRelayAddresses(hostname, ipv4Addr, ipv6Addr string) error {
+	if !validAddress(ipv4Addr, false) {
+		return fmt.Errorf("relay %s has invalid IPv4 address %q", hostname, ipv4Addr)
+	}
+	if !validAddress(ipv6Addr, true) {
+		return fmt.Errorf("relay %s has invalid IPv6 address %q", hostname, ipv6Addr)
+	}
+	return nil
+}
+
+// checkEndpointData returns an error if a relay lists endpoint_data in none of the known formats
+func checkEndpointData(hostname string, endpoint json.RawMessage) error {
+	if endpoint != nil && !knownEndpointFormat(endpoint) {
+		return fmt.Errorf("relay %s has unknown endpoint_data format %s", hostname, strings.TrimSpace(string(endpoint)))
+	}
+	return nil
+}
+
+// RemoveRestricted removes the relays whose IPv4 or IPv6 entry address is loopback, link-local, multicast or
+// otherwise refused by netguard.CheckTarget, which no real relay has, so that an edited relays.json cannot turn the
+// probes against the local host or network. Returns the number of relays removed. Addresses must be validated first.
+func RemoveRestricted(file *File) int {
+	var removed int
+
+	wgRelays := file.WireGuard.Relays[:0]
+	for _, relay := range file.WireGuard.Relays {
+		if restrictedAddress(relay.IPv4AddrIn) || restrictedAddress(relay.IPv6AddrIn) {
+			removed++
+			continue
+		}
+		wgRelays = append(wgRelays, relay)
+	}
+	file.WireGuard.Relays = wgRelays
+
+	bridgeRelays := file.Bridge.Relays[:0]
+	for _, relay := range file.Bridge.Relays {
+		if restrictedAddress(relay.IPv4AddrIn) || restrictedAddress(relay.IPv6AddrIn) {
+			removed++
+			continue
+		}
+		bridgeRelays = append(bridgeRelays, relay)
+	}
+	file.Bridge.Relays = bridgeRelays
+
+	return removed
+}
+
+// restrictedAddress reports whether addr is present and refused as a probe target
+func restrictedAddress(addr string) bool {
+	return addr != "" && netguard.CheckTarget(net.ParseIP(addr)) != nil
+}
@@ -0,0 +1,127 @@
+package relays
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func newEndpointTestFile() *File {
+	return &File{
+		WireGuard: WireGuardSection{Relays: []WireGuardRelay{
+			{Hostname: "good-wg", IPv4AddrIn: "10.0.0.1", IPv6AddrIn: "2001:db8::1"},
+			{Hostname: "bad-v4", IPv4AddrIn: "10.0.0.256"},
+			{Hostname: "swapped", IPv4AddrIn: "2001:db8::2"},
+			{
+				Hostname:               "bad-extra",
+				IPv4AddrIn:             "10.0.0.3",
+				ShadowsocksExtraAddrIn: []string{"10.0.0.4", "wg.example.com"},
+			},
+		}},
+		Bridge: BridgeSection{Relays: []BridgeRelay{
+			{Hostname: "good-br", IPv4AddrIn: "10.0.1.1"},
+			{Hostname: "bad-v6", IPv4AddrIn: "10.0.1.2", IPv6AddrIn: "10.0.1.3"},
+		}},
+	}
+}
+
+func TestValidateEndpoints(t *testing.T) {
+	t.Run("Lenient mode skips malformed endpoints", func(t *testing.T) {
+		file := newEndpointTestFile()
+
+		skipped, err := ValidateEndpoints(file, false)
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if skipped != 4 {
+			t.Errorf("Expected 4 skipped endpoints, got %d", skipped)
+		}
+
+		var wgHosts []string
+		for _, relay := range file.WireGuard.Relays {
+			wgHosts = append(wgHosts, relay.Hostname)
+		}
+		if strings.Join(wgHosts, ",") != "good-wg,bad-extra" {
+			t.Errorf("Unexpected WireGuard relays kept: %v", wgHosts)
+		}
+		if extra := file.WireGuard.Relays[1].ShadowsocksExtraAddrIn; len(extra) != 1 || extra[0] != "10.0.0.4" {
+			t.Errorf("Expected only the valid Shadowsocks address to be kept, got %v", extra)
+		}
+
+		if len(file.Bridge.Relays) != 1 || file.Bridge.Relays[0].Hostname != "good-br" {
+			t.Errorf("Unexpected bridge relays kept: %v", file.Bridge.Relays)
+		}
+	})
+
+	t.Run("Strict mode reports the first malformed endpoint", func(t *testing.T) {
+		_, err := ValidateEndpoints(newEndpointTestFile(), true)
+		if err == nil {
+			t.Fatal("Expected error in strict mode, got nil")
+		}
+		if !strings.Contains(err.Error(), "bad-v4") || !strings.Contains(err.Error(), "10.0.0.256") {
+			t.Errorf("Expected error to name the relay and address, got: %v", err)
+		}
+	})
+
+	t.Run("Strict mode reports malformed Shadowsocks addresses", func(t *testing.T) {
+		file := &File{WireGuard: WireGuardSection{Relays: []WireGuardRelay{
+			{Hostname: "bad-extra", IPv4AddrIn: "10.0.0.3", ShadowsocksExtraAddrIn: []string{"nope"}},
+		}}}
+		_, err := ValidateEndpoints(file, true)
+		if err == nil || !strings.Contains(err.Error(), "Shadowsocks") {
+			t.Errorf("Expected Shadowsocks address error, got: %v", err)
+		}
+	})
+
+	t.Run("Strict mode reports unknown endpoint_data formats", func(t *testing.T) {
+		file := &File{
+			WireGuard: WireGuardSection{Relays: []WireGuardRelay{
+				{Hostname: "known", IPv4AddrIn: "10.0.0.1", EndpointData: json.RawMessage(`{"wireguard": {}}`)},
+			}},
+			Bridge: BridgeSection{Relays: []BridgeRelay{
+				{Hostname: "unknown", IPv4AddrIn: "10.0.1.1", EndpointData: json.RawMessage(`"carrier-pigeon"`)},
+			}},
+		}
+		if _, err := ValidateEndpoints(file, false); err != nil {
+			t.Errorf("Expected lenient mode to accept unknown formats, got: %v", err)
+		}
+		_, err := ValidateEndpoints(file, true)
+		if err == nil || !strings.Contains(err.Error(), "unknown endpoint_data format \"carrier-pigeon\"") {
+			t.Errorf("Expected the unknown format of the bridge relay, got: %v", err)
+		}
+	})
+
+	t.Run("Real relays file is valid in strict mode", func(t *testing.T) {
+		file, err := ParseRelaysFile("../../testdata/relays.json")
+		if err != nil {
+			t.Fatalf("Failed to parse relays.json: %v", err)
+		}
+		if _, err := ValidateEndpoints(file, true); err != nil {
+			t.Errorf("Expected testdata relays to pass strict validation, got: %v", err)
+		}
+	})
+}
+
+func TestRemoveRestricted(t *testing.T) {
+	file := &File{
+		WireGuard: WireGuardSection{Relays: []WireGuardRelay{
+			{Hostname: "public", IPv4AddrIn: "185.213.154.66", IPv6AddrIn: "2a03:1b20:1:f011::a01f"},
+			{Hostname: "loopback", IPv4AddrIn: "127.0.0.1"},
+			{Hostname: "link-local-v6", IPv4AddrIn: "185.213.154.67", IPv6AddrIn: "fe80::1"},
+		}},
+		Bridge: BridgeSection{Relays: []BridgeRelay{
+			{Hostname: "private", IPv4AddrIn: "192.168.1.1"},
+			{Hostname: "multicast", IPv4AddrIn: "224.0.0.1"},
+		}},
+	}
+
+	if removed := RemoveRestricted(file); removed != 3 {
+		t.Errorf("Expected 3 relays removed, got %d", removed)
+	}
+	if len(file.WireGuard.Relays) != 1 || file.WireGuard.Relays[0].Hostname != "public" {
+		t.Errorf("Unexpected WireGuard relays kept: %v", file.WireGuard.Relays)
+	}
+	if len(file.Bridge.Relays) != 1 || file.Bridge.Relays[0].Hostname != "private" {
+		t.Errorf("Expected private network addresses to be kept, got %v", file.Bridge.Relays)
+	}
+}
@@ -0,0 +1,206 @@
+// Package report renders a standalone HTML report of a run, with a sortable table of the ranked servers, summary
+// statistics and a map, for sharing measurements with people who do not use the command line.
+package report
+
+import (
+	"bytes"
+	_ "embed"
+	"fmt"
+	"html/template"
+	"io"
+	"math"
+	"os"
+	"time"
+
+	"github.com/Ch00k/mullvad-compass/internal/api"
+	"github.com/Ch00k/mullvad-compass/internal/formatter"
+	"github.com/Ch00k/mullvad-compass/internal/relays"
+)
+
+//go:embed report.html.tmpl
+var reportTemplate string
+
+// tmpl is the parsed report template
+var tmpl = template.Must(template.New("report").Funcs(template.FuncMap{
+	"latency":  formatLatency,
+	"distance": formatDistance,
+}).Parse(reportTemplate))
+
+// Map bounds in degrees: the margin around the plotted points and the smallest span shown
+const (
+	mapMargin  = 2.0
+	mapMinSpan = 10.0
+)
+
+// Report is a run rendered as HTML
+type Report struct {
+	Version   string
+	Generated time.Time
+	City      string // User's city
+	Country   string // User's country
+	IPVersion string
+	Servers   []Server
+	Summary   formatter.Summary
+	Map       *Map // nil when no server has coordinates
+}
+
+// Server is a ranked server in a report
+type Server struct {
+	Rank     int
+	Hostname string
+	Country  string
+	City     string
+	IP       string
+	Latency  *float64 // nil indicates timeout
+	Distance *float64 // nil when unknown
+}
+
+// Map is an equirectangular plot of the user and the servers, in degrees from the top left corner of the bounds
+type Map struct {
+	Width  float64
+	Height float64
+	Radius float64 // Of the points, scaled to the bounds
+	User   Point
+	Points []Point // Servers, worst first so that the best are drawn on top
+}
+
+// Point is a position on the map
+type Point struct {
+	X     float64
+	Y     float64
+	Label string
+	Color string // Green for the fastest server through red for the slowest, grey for timeouts
+}
+
+// New builds a report of the ranked locations. The user's IP address is left out.
+func New(
+	version string,
+	generated time.Time,
+	userLoc api.UserLocation,
+	locations []relays.Location,
+	useIPv6 bool,
+) Report {
+	ipVersion := relays.IPv4
+	if useIPv6 {
+		ipVersion = relays.IPv6
+	}
+
+	report := Report{
+		Version:   version,
+		Generated: generated,
+		City:      userLoc.City,
+		Country:   userLoc.Country,
+		IPVersion: ipVersion.String(),
+		Servers:   make([]Server, len(locations)),
+		Summary:   formatter.Summarize(locations),
+		Map:       newMap(userLoc, locations),
+	}
+	for i, loc := range locations {
+		ip := loc.IPv4Address
+		if useIPv6 {
+			ip = loc.IPv6Address
+		}
+		report.Servers[i] = Server{
+			Rank:     i + 1,
+			Hostname: loc.Hostname,
+			Country:  loc.Country,
+			City:     loc.City,
+			IP:       ip,
+			Latency:  loc.Latency,
+			Distance: loc.DistanceFromMyLocation,
+		}
+	}
+	return report
+}
+
+// newMap plots the user and the servers with coordinates, or returns nil if no server has any
+func newMap(userLoc api.UserLocation, locations []relays.Location) *Map {
+	minLat, maxLat := userLoc.Latitude, userLoc.Latitude
+	minLon, maxLon := userLoc.Longitude, userLoc.Longitude
+	fastest, slowest := math.Inf(1), math.Inf(-1)
+	var located []relays.Location
+	for _, loc := range locations {
+		if !loc.HasCoordinates() {
+			continue
+		}
+		located = append(located, loc)
+		minLat, maxLat = min(minLat, loc.Latitude), max(maxLat, loc.Latitude)
+		minLon, maxLon = min(minLon, loc.Longitude), max(maxLon, loc.Longitude)
+		if loc.Latency != nil {
+			fastest, slowest = min(fastest, *loc.Latency), max(slowest, *loc.Latency)
+		}
+	}
+	if len(located) == 0 {
+		return nil
+	}
+
+	// Small regions are widened around their center, so that nearby points do not fill the whole map
+	width := max(maxLon-minLon+2*mapMargin, mapMinSpan)
+	height := max(maxLat-minLat+2*mapMargin, mapMinSpan/2)
+	left := (minLon+maxLon)/2 - width/2
+	top := (minLat+maxLat)/2 + height/2
+	project := func(lat, lon float64) (float64, float64) {
+		return round(lon - left), round(top - lat)
+	}
+
+	m := &Map{Width: round(width), Height: round(height), Radius: round(max(width, height) / 80)}
+	m.User.X, m.User.Y = project(userLoc.Latitude, userLoc.Longitude)
+	m.User.Label = "You"
+	for i := len(located) - 1; i >= 0; i-- {
+		loc := located[i]
+		point := Point{Label: loc.Hostname + ": " + formatLatency(loc.Latency), Color: "#999999"}
+		if loc.Latency != nil {
+			point.Color = latencyColor(*loc.Latency, fastest, slowest)
+		}
+		point.X, point.Y = project(loc.Latitude, loc.Longitude)
+		m.Points = append(m.Points, point)
+	}
+	return m
+}
+
+// round rounds map coordinates to a thousandth of a degree, about 100 m, which is finer than a point
+func round(degrees float64) float64 {
+	return math.Round(degrees*1000) / 1000
+}
+
+// latencyColor returns a color from green for the fastest latency to red for the slowest
+func latencyColor(latency, fastest, slowest float64) string {
+	share := 0.0
+	if slowest > fastest {
+		share = (latency - fastest) / (slowest - fastest)
+	}
+	return fmt.Sprintf("hsl(%.0f, 70%%, 45%%)", 120*(1-share))
+}
+
+// formatLatency formats a latency in milliseconds, or "timeout" for nil
+func formatLatency(latency *float64) string {
+	if latency == nil {
+		return "timeout"
+	}
+	return fmt.Sprintf("%.2f ms", *latency)
+}
+
+// formatDistance formats a distance in kilometres, or an empty string when unknown
+func formatDistance(distance *float64) string {
+	if distance == nil {
+		return ""
+	}
+	return fmt.Sprintf("%.0f km", *distance)
+}
+
+// Render writes the report as a standalone HTML page, which needs no network access to display
+func Render(w io.Writer, report Report) error {
+	return tmpl.Execute(w, report)
+}
+
+// WriteFile renders the report to the file at path, replacing it if it exists
+func WriteFile(path string, report Report) error {
+	var buf bytes.Buffer
+	if err := Render(&buf, report); err != nil {
+		return fmt.Errorf("failed to render the HTML report: %w", err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		return fmt.Errorf("failed to write the HTML report: %w", err)
+	}
+	return nil
+}
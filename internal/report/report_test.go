@@ -0,0 +1,121 @@
+package report
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Ch00k/mullvad-compass/internal/api"
+	"github.com/Ch00k/mullvad-compass/internal/relays"
+)
+
+func ptr(f float64) *float64 {
+	return &f
+}
+
+func testLocations() []relays.Location {
+	return []relays.Location{
+		{Hostname: "cz-prg-wg-201", Country: "Czech Republic", City: "Prague", IPv4Address: "178.249.209.162",
+			Latitude: 50.08, Longitude: 14.42, Latency: ptr(9.78), DistanceFromMyLocation: ptr(121)},
+		{Hostname: "de-ber-wg-001", Country: "Germany", City: "Berlin", IPv4Address: "193.32.248.66",
+			Latitude: 52.52, Longitude: 13.40, Latency: ptr(15.88), DistanceFromMyLocation: ptr(165)},
+		{Hostname: "xx-unk-wg-001", Country: "Unknown", City: "Unknown", IPv4Address: "192.0.2.1"},
+	}
+}
+
+func TestNew(t *testing.T) {
+	userLoc := api.UserLocation{City: "Dresden", Country: "Germany", IP: "203.0.113.42", Latitude: 51.05,
+		Longitude: 13.73}
+	report := New("1.2.3", time.Unix(0, 0), userLoc, testLocations(), false)
+
+	if len(report.Servers) != 3 || report.Servers[0].Rank != 1 || report.Servers[2].Rank != 3 {
+		t.Fatalf("Expected 3 servers ranked 1-3, got %+v", report.Servers)
+	}
+	if report.Summary.Count != 3 || report.Summary.Reachable != 2 {
+		t.Errorf("Summary = %+v, want 3 servers, 2 reachable", report.Summary)
+	}
+
+	if report.Map == nil {
+		t.Fatal("Expected a map")
+	}
+	if len(report.Map.Points) != 2 {
+		t.Fatalf("Expected the 2 servers with coordinates on the map, got %d", len(report.Map.Points))
+	}
+	// The best server is drawn last, on top of the others, in green
+	best := report.Map.Points[len(report.Map.Points)-1]
+	if !strings.HasPrefix(best.Label, "cz-prg-wg-201") || best.Color != "hsl(120, 70%, 45%)" {
+		t.Errorf("Expected the best server last in green, got %+v", best)
+	}
+	for _, p := range append(report.Map.Points, report.Map.User) {
+		if p.X < 0 || p.X > report.Map.Width || p.Y < 0 || p.Y > report.Map.Height {
+			t.Errorf("Point %+v is outside the %vx%v map", p, report.Map.Width, report.Map.Height)
+		}
+	}
+}
+
+func TestNewWithoutCoordinates(t *testing.T) {
+	report := New("1.2.3", time.Unix(0, 0), api.UserLocation{}, testLocations()[2:], false)
+	if report.Map != nil {
+		t.Errorf("Expected no map without coordinates, got %+v", report.Map)
+	}
+}
+
+func TestRender(t *testing.T) {
+	userLoc := api.UserLocation{City: "Dresden", Country: "Germany", IP: "203.0.113.42", Latitude: 51.05,
+		Longitude: 13.73}
+	locations := testLocations()
+	locations[0].Hostname = "<script>alert(1)</script>"
+
+	var buf bytes.Buffer
+	if err := Render(&buf, New("1.2.3", time.Unix(0, 0).UTC(), userLoc, locations, false)); err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+	html := buf.String()
+
+	for _, want := range []string{
+		"<!DOCTYPE html>",
+		"From Dresden, Germany",
+		"mullvad-compass 1.2.3",
+		"<strong>67%</strong>reachable",
+		"178.249.209.162",
+		"9.78 ms",
+		"121 km",
+		`class="timeout"`,
+		"<svg",
+		"<script>",
+	} {
+		if !strings.Contains(html, want) {
+			t.Errorf("Expected %q in the report", want)
+		}
+	}
+	if strings.Contains(html, "<script>alert(1)</script>") {
+		t.Error("Expected hostnames to be escaped")
+	}
+	if strings.Contains(html, userLoc.IP) {
+		t.Error("Expected the user's IP address to be left out")
+	}
+	if strings.Contains(html, "http://") || strings.Contains(html, "https://") {
+		t.Error("Expected a standalone report without external resources")
+	}
+}
+
+func TestWriteFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "report.html")
+	if err := WriteFile(path, New("1.2.3", time.Now(), api.UserLocation{}, testLocations(), false)); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read the report: %v", err)
+	}
+	if !bytes.Contains(data, []byte("cz-prg-wg-201")) {
+		t.Errorf("Expected the servers in the written report, got:\n%s", data)
+	}
+
+	if err := WriteFile(filepath.Join(t.TempDir(), "missing", "report.html"), Report{}); err == nil {
+		t.Error("Expected an error for a missing directory")
+	}
+}
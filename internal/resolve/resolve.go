@@ -0,0 +1,280 @@
+// Package resolve resolves hostnames, either with the system resolver or over DNS-over-HTTPS (RFC 8484) for
+// networks where plain DNS answers may be tampered with.
+package resolve
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Ch00k/mullvad-compass/internal/logging"
+	"github.com/Ch00k/mullvad-compass/internal/netguard"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// DefaultDoHURL is Mullvad's public DNS-over-HTTPS endpoint
+const DefaultDoHURL = "https://dns.mullvad.net/dns-query"
+
+const (
+	defaultTimeout = 5 * time.Second
+	dnsMessageType = "application/dns-message"
+
+	// maxResponseSize caps a DNS response, which fits in a single UDP datagram at most
+	maxResponseSize = 65535
+)
+
+// Resolver looks up the IP addresses of a host. network is "ip", "ip4" or "ip6", as for net.Resolver.LookupIP.
+type Resolver interface {
+	LookupIP(ctx context.Context, network, host string) ([]net.IP, error)
+}
+
+// System returns the resolver of the operating system, which refuses to look up hostnames in contexts that forbid
+// network access
+func System() Resolver {
+	return systemResolver{}
+}
+
+// systemResolver looks up hostnames with net.DefaultResolver
+type systemResolver struct{}
+
+// LookupIP looks up the addresses of host. IP literals are returned as they are, even offline.
+func (systemResolver) LookupIP(ctx context.Context, network, host string) ([]net.IP, error) {
+	if ip := net.ParseIP(host); ip == nil {
+		if err := netguard.Check(ctx, host); err != nil {
+			return nil, err
+		}
+	}
+	return net.DefaultResolver.LookupIP(ctx, network, host)
+}
+
+type resolverKey struct{}
+
+// WithResolver returns a context whose HTTP requests look up hostnames with r, once Install has been called
+func WithResolver(ctx context.Context, r Resolver) context.Context {
+	return context.WithValue(ctx, resolverKey{}, r)
+}
+
+// WithSystemResolver returns a context whose HTTP requests look up hostnames with the system resolver, undoing
+// WithResolver
+func WithSystemResolver(ctx context.Context) context.Context {
+	return context.WithValue(ctx, resolverKey{}, nil)
+}
+
+// fromContext returns the resolver set by WithResolver, nil for the system resolver
+func fromContext(ctx context.Context) Resolver {
+	r, _ := ctx.Value(resolverKey{}).(Resolver)
+	return r
+}
+
+// defaultTransport is http.DefaultTransport as the standard library created it, before other packages wrap it
+var defaultTransport = http.DefaultTransport
+
+var installOnce sync.Once
+
+// Install makes http.DefaultTransport, which every HTTP client of mullvad-compass uses, dial the addresses that the
+// resolver of the request context returns. Requests without one keep the system resolver. It is safe to call more
+// than once.
+func Install() {
+	installOnce.Do(func() {
+		if t, ok := defaultTransport.(*http.Transport); ok {
+			t.DialContext = dialContext(t.DialContext)
+		}
+	})
+}
+
+// dialContext wraps dial so that hostnames are looked up with the resolver of the context and each address is dialed
+// in turn until one connects
+func dialContext(
+	dial func(ctx context.Context, network, addr string) (net.Conn, error),
+) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		r := fromContext(ctx)
+		if r == nil {
+			return dial(ctx, network, addr)
+		}
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil || net.ParseIP(host) != nil {
+			return dial(ctx, network, addr)
+		}
+
+		ips, err := r.LookupIP(ctx, ipNetwork(network), host)
+		if err != nil {
+			return nil, err
+		}
+		var firstErr error
+		for _, ip := range ips {
+			conn, err := dial(ctx, network, net.JoinHostPort(ip.String(), port))
+			if err == nil {
+				return conn, nil
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+		return nil, firstErr
+	}
+}
+
+// ipNetwork returns the LookupIP network matching a dial network such as "tcp4"
+func ipNetwork(network string) string {
+	switch network {
+	case "tcp4", "udp4":
+		return "ip4"
+	case "tcp6", "udp6":
+		return "ip6"
+	default:
+		return "ip"
+	}
+}
+
+// DoH resolves hostnames by sending DNS queries to a DNS-over-HTTPS endpoint
+type DoH struct {
+	httpClient *http.Client
+	url        string
+	logLevel   logging.LogLevel
+}
+
+// DoHOption configures a DoH resolver
+type DoHOption func(*DoH)
+
+// WithTimeout sets the timeout of each DNS-over-HTTPS request
+func WithTimeout(timeout time.Duration) DoHOption {
+	return func(r *DoH) {
+		r.httpClient.Timeout = timeout
+	}
+}
+
+// WithLogLevel sets the log level of the resolver
+func WithLogLevel(logLevel logging.LogLevel) DoHOption {
+	return func(r *DoH) {
+		r.logLevel = logLevel
+	}
+}
+
+// NewDoH creates a resolver querying the DNS-over-HTTPS endpoint at url
+func NewDoH(url string, opts ...DoHOption) *DoH {
+	r := &DoH{
+		httpClient: &http.Client{Timeout: defaultTimeout},
+		url:        url,
+		logLevel:   logging.LogLevelError,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// LookupIP looks up the IPv4 and/or IPv6 addresses of host. IP literals are returned as they are.
+func (r *DoH) LookupIP(ctx context.Context, network, host string) ([]net.IP, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}, nil
+	}
+
+	var types []dnsmessage.Type
+	switch network {
+	case "ip":
+		types = []dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeAAAA}
+	case "ip4":
+		types = []dnsmessage.Type{dnsmessage.TypeA}
+	case "ip6":
+		types = []dnsmessage.Type{dnsmessage.TypeAAAA}
+	default:
+		return nil, fmt.Errorf("unsupported network: %s", network)
+	}
+
+	var ips []net.IP
+	for _, qtype := range types {
+		found, err := r.query(ctx, host, qtype)
+		if err != nil {
+			return nil, err
+		}
+		ips = append(ips, found...)
+	}
+	if len(ips) == 0 {
+		return nil, &net.DNSError{Err: "no such host", Name: host, Server: r.url, IsNotFound: true}
+	}
+
+	if r.logLevel <= logging.LogLevelDebug {
+		log.Printf("Resolved %s over DNS-over-HTTPS to %v", host, ips)
+	}
+	return ips, nil
+}
+
+// query sends a single question to the endpoint and returns the addresses in the answer
+func (r *DoH) query(ctx context.Context, host string, qtype dnsmessage.Type) ([]net.IP, error) {
+	name, err := dnsmessage.NewName(dnsName(host))
+	if err != nil {
+		return nil, fmt.Errorf("invalid hostname %q: %w", host, err)
+	}
+
+	// RFC 8484 asks for ID 0, so that identical queries can be cached by HTTP caches
+	query := dnsmessage.Message{
+		Header:    dnsmessage.Header{RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: name, Type: qtype, Class: dnsmessage.ClassINET}},
+	}
+	packed, err := query.Pack()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build DNS query: %w", err)
+	}
+
+	// The endpoint itself is looked up with the system resolver, as nothing else could bootstrap it
+	req, err := http.NewRequestWithContext(WithSystemResolver(ctx), http.MethodPost, r.url, bytes.NewReader(packed))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create DNS-over-HTTPS request: %w", err)
+	}
+	req.Header.Set("Content-Type", dnsMessageType)
+	req.Header.Set("Accept", dnsMessageType)
+
+	if r.logLevel <= logging.LogLevelDebug {
+		log.Printf("Querying %s for %s %s", r.url, host, qtype)
+	}
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("DNS-over-HTTPS request failed: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("DNS-over-HTTPS request failed with status %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read DNS-over-HTTPS response: %w", err)
+	}
+
+	var answer dnsmessage.Message
+	if err := answer.Unpack(body); err != nil {
+		return nil, fmt.Errorf("invalid DNS-over-HTTPS response: %w", err)
+	}
+	switch {
+	case answer.RCode == dnsmessage.RCodeNameError:
+		return nil, nil
+	case answer.RCode != dnsmessage.RCodeSuccess:
+		return nil, fmt.Errorf("DNS-over-HTTPS lookup of %s failed: %s", host, answer.RCode)
+	}
+
+	var ips []net.IP
+	for _, rr := range answer.Answers {
+		switch body := rr.Body.(type) {
+		case *dnsmessage.AResource:
+			ips = append(ips, net.IP(body.A[:]))
+		case *dnsmessage.AAAAResource:
+			ips = append(ips, net.IP(body.AAAA[:]))
+		}
+	}
+	return ips, nil
+}
+
+// dnsName returns host as a fully qualified domain name
+func dnsName(host string) string {
+	if host == "" || host[len(host)-1] != '.' {
+		return host + "."
+	}
+	return host
+}
@@ -0,0 +1,173 @@
+package resolve
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// newDoHServer starts a DNS-over-HTTPS endpoint answering from records, keyed by name and type
+func newDoHServer(t *testing.T, records map[string][]dnsmessage.Resource) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != dnsMessageType {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		var query dnsmessage.Message
+		if err := query.Unpack(body); err != nil || len(query.Questions) != 1 {
+			http.Error(w, "bad query", http.StatusBadRequest)
+			return
+		}
+
+		q := query.Questions[0]
+		answer := dnsmessage.Message{
+			Header:    dnsmessage.Header{Response: true, RecursionAvailable: true},
+			Questions: query.Questions,
+		}
+		rrs, ok := records[q.Name.String()]
+		if !ok {
+			answer.RCode = dnsmessage.RCodeNameError
+		}
+		for _, rr := range rrs {
+			if rr.Header.Type == q.Type {
+				rr.Header.Name = q.Name
+				rr.Header.Class = dnsmessage.ClassINET
+				answer.Answers = append(answer.Answers, rr)
+			}
+		}
+
+		packed, err := answer.Pack()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", dnsMessageType)
+		_, _ = w.Write(packed)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestDoHLookupIP(t *testing.T) {
+	server := newDoHServer(t, map[string][]dnsmessage.Resource{
+		"example.com.": {
+			{Header: dnsmessage.ResourceHeader{Type: dnsmessage.TypeA}, Body: &dnsmessage.AResource{A: [4]byte{192, 0, 2, 1}}},
+			{
+				Header: dnsmessage.ResourceHeader{Type: dnsmessage.TypeAAAA},
+				Body:   &dnsmessage.AAAAResource{AAAA: [16]byte{0x20, 0x01, 0x0d, 0xb8, 15: 1}},
+			},
+		},
+	})
+	r := NewDoH(server.URL)
+
+	tests := []struct {
+		network string
+		want    []string
+	}{
+		{"ip4", []string{"192.0.2.1"}},
+		{"ip6", []string{"2001:db8::1"}},
+		{"ip", []string{"192.0.2.1", "2001:db8::1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.network, func(t *testing.T) {
+			ips, err := r.LookupIP(context.Background(), tt.network, "example.com")
+			if err != nil {
+				t.Fatalf("LookupIP failed: %v", err)
+			}
+			var got []string
+			for _, ip := range ips {
+				got = append(got, ip.String())
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("LookupIP(%s) = %v, want %v", tt.network, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDoHLookupIPLiteral(t *testing.T) {
+	// An IP literal never reaches the endpoint
+	r := NewDoH("http://127.0.0.1:0")
+	ips, err := r.LookupIP(context.Background(), "ip4", "192.0.2.7")
+	if err != nil || len(ips) != 1 || ips[0].String() != "192.0.2.7" {
+		t.Errorf("LookupIP() = %v, %v; want the literal", ips, err)
+	}
+}
+
+func TestDoHLookupIPErrors(t *testing.T) {
+	server := newDoHServer(t, map[string][]dnsmessage.Resource{})
+
+	t.Run("Unknown host", func(t *testing.T) {
+		_, err := NewDoH(server.URL).LookupIP(context.Background(), "ip4", "missing.example")
+		var dnsErr *net.DNSError
+		if !errors.As(err, &dnsErr) || !dnsErr.IsNotFound {
+			t.Errorf("Expected a not-found DNS error, got: %v", err)
+		}
+	})
+
+	t.Run("HTTP error", func(t *testing.T) {
+		failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		defer failing.Close()
+
+		if _, err := NewDoH(failing.URL).LookupIP(context.Background(), "ip4", "example.com"); err == nil {
+			t.Error("Expected an error for a failing endpoint")
+		}
+	})
+
+	t.Run("Unsupported network", func(t *testing.T) {
+		if _, err := NewDoH(server.URL).LookupIP(context.Background(), "tcp", "example.com"); err == nil {
+			t.Error("Expected an error for an unsupported network")
+		}
+	})
+}
+
+func TestInstall(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = io.WriteString(w, "ok")
+	}))
+	defer target.Close()
+	// Every request dials again instead of reusing a connection to the same host
+	target.Config.SetKeepAlivesEnabled(false)
+	_, port, _ := net.SplitHostPort(target.Listener.Addr().String())
+
+	doh := newDoHServer(t, map[string][]dnsmessage.Resource{
+		"compass.test.": {{Header: dnsmessage.ResourceHeader{Type: dnsmessage.TypeA},
+			Body: &dnsmessage.AResource{A: [4]byte{127, 0, 0, 1}}}},
+	})
+	_, dohPort, _ := net.SplitHostPort(doh.Listener.Addr().String())
+
+	Install()
+	Install()
+
+	get := func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://compass.test:"+port+"/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err == nil {
+			_ = resp.Body.Close()
+		}
+		return err
+	}
+
+	// The endpoint is named by a hostname, which only the system resolver can look up without recursing
+	ctx := WithResolver(context.Background(), NewDoH("http://localhost:"+dohPort+"/dns-query"))
+	if err := get(ctx); err != nil {
+		t.Errorf("Expected the host to be resolved over DNS-over-HTTPS, got %v", err)
+	}
+	if err := get(WithSystemResolver(ctx)); err == nil {
+		t.Error("Expected the system resolver not to know the host")
+	}
+}
@@ -0,0 +1,99 @@
+// Package service registers scheduled runs of mullvad-compass as a Windows scheduled task, so that the relays are
+// monitored in the background from system startup without setting up Task Scheduler by hand.
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// TaskName is the name the scheduled task is registered under
+const TaskName = "mullvad-compass"
+
+// DefaultEvery is the interval between the task's searches unless --every is given
+const DefaultEvery = 15 * time.Minute
+
+// startupDelay gives the network time to come up after boot before the first search, in schtasks' mmmm:ss format
+const startupDelay = "0001:00"
+
+// ConfigDir is where the task, running as SYSTEM, keeps its history store and reads its favorites and ignore lists:
+// the SYSTEM account's profile rather than the profile of the user installing the task
+const ConfigDir = `C:\Windows\System32\config\systemprofile\AppData\Roaming\mullvad-compass`
+
+// ErrUnsupported is returned by Install and Remove on platforms other than Windows
+var ErrUnsupported = errors.New("the service command is only supported on Windows")
+
+// Command returns the command line the task runs: the server search with flags every interval, keeping the
+// history for retain
+func Command(exe string, every, retain time.Duration, flags []string) string {
+	command := fmt.Sprintf(`"%s" --every %s --retain %s`, exe, every, retain)
+	for _, flag := range flags {
+		command += " " + quoteArg(flag)
+	}
+	return command
+}
+
+// quoteArg quotes an argument containing spaces or quotes the way Windows programs split their command line
+func quoteArg(arg string) string {
+	if arg != "" && !strings.ContainsAny(arg, " \t\"") {
+		return arg
+	}
+	var b strings.Builder
+	b.WriteByte('"')
+	slashes := 0
+	for _, r := range arg {
+		switch r {
+		case '\\':
+			slashes++
+		case '"':
+			// Backslashes before a quote are doubled, and the quote itself is escaped
+			b.WriteString(strings.Repeat(`\`, slashes+1))
+			slashes = 0
+		default:
+			slashes = 0
+		}
+		b.WriteRune(r)
+	}
+	// Backslashes before the closing quote are doubled as well
+	b.WriteString(strings.Repeat(`\`, slashes))
+	b.WriteByte('"')
+	return b.String()
+}
+
+// installSteps returns the schtasks invocations registering the task and starting it right away. The task runs as
+// SYSTEM, so that it keeps running while no user is logged in and never opens a console window.
+func installSteps(command string) [][]string {
+	return [][]string{
+		{
+			"/Create", "/F",
+			"/TN", TaskName,
+			"/TR", command,
+			"/SC", "ONSTART",
+			"/DELAY", startupDelay,
+			"/RU", "SYSTEM",
+		},
+		{"/Run", "/TN", TaskName},
+	}
+}
+
+// removeSteps returns the schtasks invocations stopping the running task and deleting it
+func removeSteps() [][]string {
+	return [][]string{
+		{"/End", "/TN", TaskName},
+		{"/Delete", "/F", "/TN", TaskName},
+	}
+}
+
+// stepError describes a failed schtasks invocation with the message it printed
+func stepError(step []string, output []byte, err error) error {
+	message := strings.TrimSpace(string(output))
+	if message == "" {
+		message = err.Error()
+	}
+	if strings.Contains(message, "Access is denied") {
+		return fmt.Errorf("schtasks %s failed: %s (run from an elevated prompt)", step[0], message)
+	}
+	return fmt.Errorf("schtasks %s failed: %s", step[0], message)
+}
@@ -0,0 +1,15 @@
+//go:build !windows
+
+package service
+
+import "time"
+
+// Install registers the scheduled task running exe with flags every interval. Only supported on Windows.
+func Install(string, time.Duration, time.Duration, []string) error {
+	return ErrUnsupported
+}
+
+// Remove stops and deletes the scheduled task. Only supported on Windows.
+func Remove() error {
+	return ErrUnsupported
+}
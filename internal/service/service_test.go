@@ -0,0 +1,72 @@
+package service
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCommand(t *testing.T) {
+	exe := `C:\Program Files\mullvad-compass\mullvad-compass.exe`
+	got := Command(exe, DefaultEvery, 7*24*time.Hour, nil)
+	want := `"C:\Program Files\mullvad-compass\mullvad-compass.exe" --every 15m0s --retain 168h0m0s`
+	if got != want {
+		t.Errorf("Command = %s, want %s", got, want)
+	}
+
+	got = Command(exe, DefaultEvery, 7*24*time.Hour, []string{"-m", "500", "--best-in", "New York"})
+	if want := want + ` -m 500 --best-in "New York"`; got != want {
+		t.Errorf("Command = %s, want %s", got, want)
+	}
+}
+
+func TestQuoteArg(t *testing.T) {
+	for arg, want := range map[string]string{
+		"-m":         "-m",
+		"New York":   `"New York"`,
+		"":           `""`,
+		`say "hi"`:   `"say \"hi\""`,
+		`C:\my dir\`: `"C:\my dir\\"`,
+		`a\"b`:       `"a\\\"b"`,
+	} {
+		if got := quoteArg(arg); got != want {
+			t.Errorf("quoteArg(%s) = %s, want %s", arg, got, want)
+		}
+	}
+}
+
+func TestInstallSteps(t *testing.T) {
+	steps := installSteps(`"mullvad-compass.exe" --every 15m0s --retain 168h0m0s`)
+	if len(steps) != 2 || steps[0][0] != "/Create" || steps[1][0] != "/Run" {
+		t.Fatalf("Expected the task to be created, then run, got %q", steps)
+	}
+
+	create := steps[0]
+	for _, want := range [][]string{
+		{"/TN", TaskName},
+		{"/TR", `"mullvad-compass.exe" --every 15m0s --retain 168h0m0s`},
+		{"/SC", "ONSTART"},
+		{"/RU", "SYSTEM"},
+	} {
+		i := slices.Index(create, want[0])
+		if i < 0 || i+1 >= len(create) || create[i+1] != want[1] {
+			t.Errorf("Expected %s %s in %q", want[0], want[1], create)
+		}
+	}
+}
+
+func TestStepError(t *testing.T) {
+	step := []string{"/Create", "/F"}
+
+	err := stepError(step, []byte("ERROR: Access is denied.\r\n"), errors.New("exit status 1"))
+	if !strings.Contains(err.Error(), "elevated prompt") {
+		t.Errorf("Expected a hint to elevate, got: %v", err)
+	}
+
+	err = stepError(step, nil, errors.New("exec: schtasks.exe not found"))
+	if err.Error() != "schtasks /Create failed: exec: schtasks.exe not found" {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
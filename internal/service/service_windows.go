@@ -0,0 +1,35 @@
+//go:build windows
+
+package service
+
+import (
+	"os/exec"
+	"time"
+)
+
+// schtasks runs schtasks.exe and returns its combined output; replaced in tests
+var schtasks = func(args ...string) ([]byte, error) {
+	return exec.Command("schtasks.exe", args...).CombinedOutput()
+}
+
+// Install registers the scheduled task running exe with flags every interval from system startup, replacing an
+// existing one, and starts it. Registering a task that runs as SYSTEM requires an elevated prompt.
+func Install(exe string, every, retain time.Duration, flags []string) error {
+	for _, step := range installSteps(Command(exe, every, retain, flags)) {
+		if output, err := schtasks(step...); err != nil {
+			return stepError(step, output, err)
+		}
+	}
+	return nil
+}
+
+// Remove stops and deletes the scheduled task
+func Remove() error {
+	steps := removeSteps()
+	// Ending a task that is not running fails, which only matters if the task does not exist either
+	_, _ = schtasks(steps[0]...)
+	if output, err := schtasks(steps[1]...); err != nil {
+		return stepError(steps[1], output, err)
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+//go:build windows
+
+package service
+
+import (
+	"errors"
+	"testing"
+)
+
+func stubSchtasks(t *testing.T, fail string) *[][]string {
+	var calls [][]string
+	original := schtasks
+	schtasks = func(args ...string) ([]byte, error) {
+		calls = append(calls, args)
+		if args[0] == fail {
+			return []byte("ERROR: The system cannot find the file specified."), errors.New("exit status 1")
+		}
+		return nil, nil
+	}
+	t.Cleanup(func() { schtasks = original })
+	return &calls
+}
+
+func TestInstall(t *testing.T) {
+	calls := stubSchtasks(t, "")
+	if err := Install(`C:\mullvad-compass.exe`, DefaultEvery, DefaultEvery, nil); err != nil {
+		t.Fatalf("Install failed: %v", err)
+	}
+	if len(*calls) != 2 || (*calls)[0][0] != "/Create" || (*calls)[1][0] != "/Run" {
+		t.Errorf("Expected the task to be created and run, got %q", *calls)
+	}
+}
+
+func TestRemove(t *testing.T) {
+	t.Run("Ignores a task that is not running", func(t *testing.T) {
+		calls := stubSchtasks(t, "/End")
+		if err := Remove(); err != nil {
+			t.Fatalf("Remove failed: %v", err)
+		}
+		if len(*calls) != 2 || (*calls)[1][0] != "/Delete" {
+			t.Errorf("Expected the task to be deleted, got %q", *calls)
+		}
+	})
+
+	t.Run("Reports a missing task", func(t *testing.T) {
+		stubSchtasks(t, "/Delete")
+		if err := Remove(); err == nil {
+			t.Error("Expected an error for a missing task")
+		}
+	})
+}
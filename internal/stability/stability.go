@@ -0,0 +1,107 @@
+// Package stability measures how steady the latency of servers is over a window of repeated pings.
+package stability
+
+import (
+	"cmp"
+	"context"
+	"math"
+	"slices"
+	"time"
+
+	"github.com/Ch00k/mullvad-compass/internal/clock"
+	"github.com/Ch00k/mullvad-compass/internal/relays"
+)
+
+// Stats summarizes the pings of a server over the window
+type Stats struct {
+	Location relays.Location
+	Sent     int
+	Received int
+	Mean     float64 // Mean latency in ms of the received pings
+	StdDev   float64 // Standard deviation of the latency in ms
+}
+
+// Loss returns the share of pings that timed out, in percent
+func (s Stats) Loss() float64 {
+	if s.Sent == 0 {
+		return 0
+	}
+	return float64(s.Sent-s.Received) / float64(s.Sent) * 100
+}
+
+// PingFunc pings locations once and returns them with their latency set
+type PingFunc func(ctx context.Context, locations []relays.Location) ([]relays.Location, error)
+
+// Probe pings the candidates once per round, waiting interval between rounds, and returns their stats ranked by
+// stability. Cancelling the context stops the probes and returns the error.
+func Probe(
+	ctx context.Context,
+	candidates []relays.Location,
+	rounds int,
+	interval time.Duration,
+	ping PingFunc,
+) ([]Stats, error) {
+	samples := make(map[string][]*float64, len(candidates))
+	for round := 0; round < rounds; round++ {
+		if round > 0 {
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-clock.FromContext(ctx).After(interval):
+			}
+		}
+
+		results, err := ping(ctx, candidates)
+		if err != nil {
+			return nil, err
+		}
+		for _, loc := range results {
+			samples[loc.Hostname] = append(samples[loc.Hostname], loc.Latency)
+		}
+	}
+
+	stats := make([]Stats, len(candidates))
+	for i, loc := range candidates {
+		stats[i] = summarize(loc, samples[loc.Hostname])
+	}
+	Rank(stats)
+	return stats, nil
+}
+
+// summarize computes the stats of a server from its latencies, nil for a timeout
+func summarize(loc relays.Location, latencies []*float64) Stats {
+	s := Stats{Location: loc, Sent: len(latencies)}
+	var sum float64
+	for _, l := range latencies {
+		if l != nil {
+			s.Received++
+			sum += *l
+		}
+	}
+	if s.Received == 0 {
+		return s
+	}
+
+	s.Mean = sum / float64(s.Received)
+	var squares float64
+	for _, l := range latencies {
+		if l != nil {
+			squares += (*l - s.Mean) * (*l - s.Mean)
+		}
+	}
+	s.StdDev = math.Sqrt(squares / float64(s.Received))
+	return s
+}
+
+// Rank sorts stats by loss, then standard deviation, then mean latency
+func Rank(stats []Stats) {
+	slices.SortStableFunc(stats, func(a, b Stats) int {
+		if c := cmp.Compare(a.Loss(), b.Loss()); c != 0 {
+			return c
+		}
+		if c := cmp.Compare(a.StdDev, b.StdDev); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Mean, b.Mean)
+	})
+}
@@ -0,0 +1,117 @@
+package stability
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/Ch00k/mullvad-compass/internal/clock"
+	"github.com/Ch00k/mullvad-compass/internal/relays"
+)
+
+func TestProbe(t *testing.T) {
+	candidates := []relays.Location{
+		{Hostname: "jittery"},
+		{Hostname: "lossy"},
+		{Hostname: "steady"},
+	}
+	// Latencies per round, nil for a timeout
+	latencies := map[string][]*float64{
+		"jittery": {ms(10), ms(30), ms(20), ms(20)},
+		"lossy":   {ms(5), nil, ms(5), ms(5)},
+		"steady":  {ms(25), ms(25), ms(25), ms(25)},
+	}
+
+	var rounds int
+	ping := func(_ context.Context, locations []relays.Location) ([]relays.Location, error) {
+		results := make([]relays.Location, len(locations))
+		for i, loc := range locations {
+			loc.Latency = latencies[loc.Hostname][rounds]
+			results[i] = loc
+		}
+		rounds++
+		return results, nil
+	}
+
+	clk := clock.NewFake(time.Now())
+	stats, err := Probe(clock.WithClock(context.Background(), clk), candidates, 4, time.Second, ping)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if rounds != 4 {
+		t.Errorf("Expected 4 rounds, got %d", rounds)
+	}
+	if waits := clk.Waits(); len(waits) != 3 || waits[0] != time.Second {
+		t.Errorf("Expected a second between the rounds, waited %v", waits)
+	}
+
+	want := []struct {
+		hostname string
+		received int
+		mean     float64
+		stdDev   float64
+		loss     float64
+	}{
+		{"steady", 4, 25, 0, 0},
+		{"jittery", 4, 20, math.Sqrt(50), 0},
+		{"lossy", 3, 5, 0, 25},
+	}
+	if len(stats) != len(want) {
+		t.Fatalf("Expected %d stats, got %d", len(want), len(stats))
+	}
+	for i, w := range want {
+		s := stats[i]
+		if s.Location.Hostname != w.hostname || s.Sent != 4 || s.Received != w.received {
+			t.Errorf("stats[%d]: expected %s with 4/%d pings, got %s with %d/%d",
+				i, w.hostname, w.received, s.Location.Hostname, s.Sent, s.Received)
+		}
+		if s.Mean != w.mean || math.Abs(s.StdDev-w.stdDev) > 1e-9 || s.Loss() != w.loss {
+			t.Errorf("stats[%d]: expected mean %v, std dev %v, loss %v, got %v, %v, %v",
+				i, w.mean, w.stdDev, w.loss, s.Mean, s.StdDev, s.Loss())
+		}
+	}
+}
+
+func TestProbeNeverResponded(t *testing.T) {
+	ping := func(_ context.Context, locations []relays.Location) ([]relays.Location, error) {
+		return locations, nil
+	}
+
+	stats, err := Probe(context.Background(), []relays.Location{{Hostname: "down"}}, 2, 0, ping)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if s := stats[0]; s.Received != 0 || s.Loss() != 100 || s.Mean != 0 {
+		t.Errorf("Expected 100%% loss and no mean, got %+v", s)
+	}
+}
+
+func TestProbeErrors(t *testing.T) {
+	t.Run("Ping error", func(t *testing.T) {
+		pingErr := errors.New("socket closed")
+		ping := func(_ context.Context, _ []relays.Location) ([]relays.Location, error) {
+			return nil, pingErr
+		}
+		if _, err := Probe(context.Background(), []relays.Location{{}}, 3, 0, ping); !errors.Is(err, pingErr) {
+			t.Errorf("Expected the ping error, got: %v", err)
+		}
+	})
+
+	t.Run("Cancelled between rounds", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		ping := func(_ context.Context, locations []relays.Location) ([]relays.Location, error) {
+			cancel()
+			return locations, nil
+		}
+		_, err := Probe(ctx, []relays.Location{{}}, 3, time.Hour, ping)
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Expected context.Canceled, got: %v", err)
+		}
+	})
+}
+
+func ms(v float64) *float64 {
+	return &v
+}
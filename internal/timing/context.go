@@ -0,0 +1,16 @@
+package timing
+
+import "context"
+
+type contextKey struct{}
+
+// WithCollector returns a context carrying the collector, for steps deep in the call chain to record into
+func WithCollector(ctx context.Context, c *Collector) context.Context {
+	return context.WithValue(ctx, contextKey{}, c)
+}
+
+// FromContext returns the collector carried by the context, or nil if there is none
+func FromContext(ctx context.Context) *Collector {
+	c, _ := ctx.Value(contextKey{}).(*Collector)
+	return c
+}
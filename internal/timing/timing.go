@@ -0,0 +1,150 @@
+// Package timing collects how long each phase of a run takes.
+package timing
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/Ch00k/mullvad-compass/internal/logging"
+)
+
+// Phase is a stage of a run that one or more timed steps belong to
+type Phase string
+
+// Phases of a run, in the order they usually happen
+const (
+	PhaseParse  Phase = "parse"  // Reading relays.json
+	PhaseFilter Phase = "filter" // Selecting relays and filtering them by distance
+	PhaseGeoIP  Phase = "geoip"  // Looking up the user's location
+	PhasePing   Phase = "ping"   // Measuring latency
+	PhaseSort   Phase = "sort"   // Ranking results
+	PhaseFormat Phase = "format" // Rendering output
+)
+
+// Collector records the duration of timed steps, grouped by phase. A nil Collector discards everything.
+type Collector struct {
+	mu       sync.Mutex
+	now      func() time.Time
+	logLevel logging.LogLevel
+	start    time.Time
+	phases   []PhaseTiming
+}
+
+// PhaseTiming is the total duration of the steps of a phase
+type PhaseTiming struct {
+	Phase    Phase
+	Steps    int
+	Duration time.Duration
+}
+
+// Report is a snapshot of the collected timings, suitable for JSON output
+type Report struct {
+	Phases  []ReportPhase `json:"phases"`
+	TotalMs float64       `json:"total_ms"` // Time since the collector was created
+}
+
+// ReportPhase is a phase of a Report
+type ReportPhase struct {
+	Phase      Phase   `json:"phase"`
+	Steps      int     `json:"steps"`
+	DurationMs float64 `json:"duration_ms"`
+}
+
+// Option configures a Collector
+type Option func(*Collector)
+
+// WithLogLevel logs the duration of every step at debug level
+func WithLogLevel(level logging.LogLevel) Option {
+	return func(c *Collector) {
+		c.logLevel = level
+	}
+}
+
+// WithClock replaces the clock used to measure steps
+func WithClock(now func() time.Time) Option {
+	return func(c *Collector) {
+		c.now = now
+	}
+}
+
+// New creates a Collector and starts measuring the total time
+func New(opts ...Option) *Collector {
+	c := &Collector{
+		now:      time.Now,
+		logLevel: logging.LogLevelError,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	c.start = c.now()
+	return c
+}
+
+// Start begins timing a step of a phase. Calling the returned function ends the step, adds its duration to the
+// phase and logs it as "<step> completed in <duration>" at debug level.
+func (c *Collector) Start(phase Phase, step string) func() {
+	if c == nil {
+		return func() {}
+	}
+
+	start := c.now()
+	return func() {
+		elapsed := c.now().Sub(start)
+		c.add(phase, elapsed)
+		if c.logLevel <= logging.LogLevelDebug {
+			log.Printf("%s completed in %v", step, elapsed)
+		}
+	}
+}
+
+// add adds the duration of a step to its phase
+func (c *Collector) add(phase Phase, elapsed time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i := range c.phases {
+		if c.phases[i].Phase == phase {
+			c.phases[i].Steps++
+			c.phases[i].Duration += elapsed
+			return
+		}
+	}
+	c.phases = append(c.phases, PhaseTiming{Phase: phase, Steps: 1, Duration: elapsed})
+}
+
+// Phases returns the timed phases in the order their first step ended
+func (c *Collector) Phases() []PhaseTiming {
+	if c == nil {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	phases := make([]PhaseTiming, len(c.phases))
+	copy(phases, c.phases)
+	return phases
+}
+
+// Total returns the time since the collector was created
+func (c *Collector) Total() time.Duration {
+	if c == nil {
+		return 0
+	}
+	return c.now().Sub(c.start)
+}
+
+// Report returns the collected timings in milliseconds
+func (c *Collector) Report() Report {
+	phases := c.Phases()
+	report := Report{Phases: make([]ReportPhase, len(phases)), TotalMs: milliseconds(c.Total())}
+	for i, p := range phases {
+		report.Phases[i] = ReportPhase{Phase: p.Phase, Steps: p.Steps, DurationMs: milliseconds(p.Duration)}
+	}
+	return report
+}
+
+// milliseconds converts a duration to fractional milliseconds
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,89 @@
+package timing
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Ch00k/mullvad-compass/internal/logging"
+)
+
+// fakeClock advances by step on every reading
+func fakeClock(step time.Duration) func() time.Time {
+	now := time.Unix(0, 0)
+	return func() time.Time {
+		now = now.Add(step)
+		return now
+	}
+}
+
+func TestCollector(t *testing.T) {
+	c := New(WithClock(fakeClock(time.Millisecond)))
+
+	c.Start(PhaseParse, "Parse relays file")()
+	stop := c.Start(PhaseFilter, "Get locations")
+	c.Start(PhasePing, "Ping locations")() // Nested steps are timed independently
+	stop()
+	c.Start(PhaseFilter, "Filter by distance")()
+
+	phases := c.Phases()
+	want := []PhaseTiming{
+		{Phase: PhaseParse, Steps: 1, Duration: time.Millisecond},
+		{Phase: PhasePing, Steps: 1, Duration: time.Millisecond},
+		{Phase: PhaseFilter, Steps: 2, Duration: 4 * time.Millisecond},
+	}
+	if len(phases) != len(want) {
+		t.Fatalf("Expected %d phases, got %+v", len(want), phases)
+	}
+	for i := range want {
+		if phases[i] != want[i] {
+			t.Errorf("Phase %d = %+v, want %+v", i, phases[i], want[i])
+		}
+	}
+
+	report := c.Report()
+	if report.Phases[2].DurationMs != 4 || report.Phases[2].Steps != 2 {
+		t.Errorf("Unexpected filter phase in report: %+v", report.Phases[2])
+	}
+	if report.TotalMs <= 0 {
+		t.Errorf("Expected a positive total, got %v", report.TotalMs)
+	}
+}
+
+func TestCollectorLogging(t *testing.T) {
+	var logBuf bytes.Buffer
+	log.SetOutput(&logBuf)
+	defer log.SetOutput(os.Stderr)
+
+	New(WithLogLevel(logging.LogLevelError)).Start(PhaseSort, "Sort locations by latency")()
+	if logBuf.Len() != 0 {
+		t.Errorf("Did not expect timing log at error level, got: %s", logBuf.String())
+	}
+
+	New(WithLogLevel(logging.LogLevelDebug)).Start(PhaseSort, "Sort locations by latency")()
+	if !strings.Contains(logBuf.String(), "Sort locations by latency completed in") {
+		t.Errorf("Expected timing log message, got: %s", logBuf.String())
+	}
+}
+
+func TestNilCollector(t *testing.T) {
+	var c *Collector
+	c.Start(PhasePing, "Ping locations")()
+	if c.Phases() != nil || c.Total() != 0 || len(c.Report().Phases) != 0 {
+		t.Error("Expected a nil collector to record nothing")
+	}
+}
+
+func TestContext(t *testing.T) {
+	if FromContext(context.Background()) != nil {
+		t.Error("Expected no collector in an empty context")
+	}
+	c := New()
+	if FromContext(WithCollector(context.Background(), c)) != c {
+		t.Error("Expected the collector carried by the context")
+	}
+}
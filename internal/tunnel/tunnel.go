@@ -0,0 +1,135 @@
+// Package tunnel measures latency through the SOCKS5 proxy that Mullvad relays offer inside the tunnel, to
+// evaluate how the current relay performs toward real destinations.
+package tunnel
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/net/proxy"
+
+	"github.com/Ch00k/mullvad-compass/internal/logging"
+)
+
+// DefaultProxy is the SOCKS5 proxy reachable inside every Mullvad WireGuard tunnel
+const DefaultProxy = "10.64.0.1:1080"
+
+// DefaultDestinations are measured when no destinations are given
+var DefaultDestinations = []string{"1.1.1.1:443", "8.8.8.8:443", "9.9.9.9:443"}
+
+// attempts is the number of connections made to each destination, the fastest of which is reported
+const attempts = 3
+
+// Result is the in-tunnel latency to a destination. Latencies are nil when no attempt succeeded.
+type Result struct {
+	Destination string
+	Relay       *float64 // Time to connect to the proxy on the relay in milliseconds
+	Total       *float64 // Time to connect to the destination through the proxy in milliseconds
+	Err         error    // Error of the last failed attempt when none succeeded
+}
+
+// Measure connects to each destination through the SOCKS5 proxy and reports the fastest of a few attempts
+func Measure(
+	ctx context.Context,
+	proxyAddr string,
+	destinations []string,
+	timeout time.Duration,
+	logLevel logging.LogLevel,
+) []Result {
+	results := make([]Result, len(destinations))
+	for i, dest := range destinations {
+		results[i] = measure(ctx, proxyAddr, dest, timeout, logLevel)
+	}
+	return results
+}
+
+// measure connects to a destination through the proxy a few times and keeps the fastest connection
+func measure(
+	ctx context.Context,
+	proxyAddr, dest string,
+	timeout time.Duration,
+	logLevel logging.LogLevel,
+) Result {
+	result := Result{Destination: dest}
+	for range attempts {
+		relay, total, err := connect(ctx, proxyAddr, dest, timeout)
+		if err != nil {
+			if logLevel <= logging.LogLevelDebug {
+				log.Printf("Failed to connect to %s through %s: %v", dest, proxyAddr, err)
+			}
+			result.Err = err
+			continue
+		}
+		if result.Total == nil || total < *result.Total {
+			result.Relay, result.Total = &relay, &total
+		}
+	}
+	if result.Total != nil {
+		result.Err = nil
+	}
+	return result
+}
+
+// connect opens one connection to the destination through the proxy and returns the time taken to connect to the
+// proxy and the total time until the proxy reported the destination connected, in milliseconds
+func connect(ctx context.Context, proxyAddr, dest string, timeout time.Duration) (float64, float64, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	forward := &timedDialer{}
+	dialer, err := proxy.SOCKS5("tcp", proxyAddr, nil, forward)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	start := time.Now()
+	conn, err := dialer.(proxy.ContextDialer).DialContext(ctx, "tcp", dest)
+	if err != nil {
+		return 0, 0, err
+	}
+	total := time.Since(start)
+	_ = conn.Close()
+
+	return milliseconds(forward.elapsed()), milliseconds(total), nil
+}
+
+// timedDialer dials the proxy and records how long the connection took
+type timedDialer struct {
+	mu       sync.Mutex
+	duration time.Duration
+}
+
+// Dial implements proxy.Dialer
+func (d *timedDialer) Dial(network, addr string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, addr)
+}
+
+// DialContext implements proxy.ContextDialer
+func (d *timedDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	start := time.Now()
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, fmt.Errorf("proxy %s is unreachable: %w", addr, err)
+	}
+	d.mu.Lock()
+	d.duration = time.Since(start)
+	d.mu.Unlock()
+	return conn, nil
+}
+
+// elapsed returns how long connecting to the proxy took
+func (d *timedDialer) elapsed() time.Duration {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.duration
+}
+
+// milliseconds converts a duration to fractional milliseconds
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,133 @@
+package tunnel
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Ch00k/mullvad-compass/internal/logging"
+)
+
+// serveSOCKS5 accepts connections on a local listener and answers SOCKS5 CONNECT requests without
+// authentication, connecting to the requested address. Returns the address of the proxy.
+func serveSOCKS5(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go handleSOCKS5(conn)
+		}
+	}()
+	return listener.Addr().String()
+}
+
+// handleSOCKS5 answers a single SOCKS5 CONNECT request for an IPv4 address
+func handleSOCKS5(conn net.Conn) {
+	defer func() { _ = conn.Close() }()
+
+	// Greeting: version, number of methods, methods
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return
+	}
+	if _, err := io.ReadFull(conn, make([]byte, header[1])); err != nil {
+		return
+	}
+	if _, err := conn.Write([]byte{5, 0}); err != nil {
+		return
+	}
+
+	// Request: version, command, reserved, address type, IPv4 address, port
+	request := make([]byte, 10)
+	if _, err := io.ReadFull(conn, request); err != nil || request[3] != 1 {
+		return
+	}
+	addr := net.JoinHostPort(net.IP(request[4:8]).String(), strconv.Itoa(int(binary.BigEndian.Uint16(request[8:]))))
+
+	reply := byte(0)
+	target, err := net.Dial("tcp", addr)
+	if err != nil {
+		reply = 5 // Connection refused
+	} else {
+		_ = target.Close()
+	}
+	_, _ = conn.Write([]byte{5, reply, 0, 1, 0, 0, 0, 0, 0, 0})
+}
+
+func TestMeasure(t *testing.T) {
+	proxyAddr := serveSOCKS5(t)
+
+	destination, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	go func() {
+		for {
+			conn, err := destination.Accept()
+			if err != nil {
+				return
+			}
+			_ = conn.Close()
+		}
+	}()
+
+	// A port that was just released refuses connections
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	_ = closed.Close()
+
+	results := Measure(
+		context.Background(),
+		proxyAddr,
+		[]string{destination.Addr().String(), closed.Addr().String()},
+		time.Second,
+		logging.LogLevelError,
+	)
+	_ = destination.Close()
+
+	if len(results) != 2 {
+		t.Fatalf("Expected 2 results, got %d", len(results))
+	}
+
+	reachable := results[0]
+	if reachable.Err != nil || reachable.Relay == nil || reachable.Total == nil {
+		t.Fatalf("Expected a latency to the reachable destination, got %+v", reachable)
+	}
+	if *reachable.Relay > *reachable.Total {
+		t.Errorf("Expected the relay latency %v to be part of the total %v", *reachable.Relay, *reachable.Total)
+	}
+
+	refused := results[1]
+	if refused.Err == nil || refused.Total != nil {
+		t.Errorf("Expected an error for the refused destination, got %+v", refused)
+	}
+}
+
+func TestMeasureUnreachableProxy(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	proxyAddr := listener.Addr().String()
+	_ = listener.Close()
+
+	results := Measure(context.Background(), proxyAddr, []string{"1.1.1.1:443"}, time.Second, logging.LogLevelError)
+	if results[0].Err == nil || results[0].Total != nil {
+		t.Errorf("Expected an error without a proxy, got %+v", results[0])
+	}
+}
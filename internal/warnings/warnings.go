@@ -0,0 +1,85 @@
+// Package warnings collects the non-fatal problems of a run as structured values, so that they are rendered the
+// same way wherever they come from and included in machine-readable output instead of only being logged.
+package warnings
+
+import (
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/Ch00k/mullvad-compass/internal/logging"
+)
+
+// Kind identifies a class of warnings, for consumers that react to some of them
+type Kind string
+
+// Warning kinds
+const (
+	SkippedRelays   Kind = "skipped_relays"   // Relays left out for malformed or missing data
+	IPv6Unavailable Kind = "ipv6_unavailable" // The host cannot reach IPv6 servers, probes fell back to IPv4
+	ProbeFailed     Kind = "probe_failed"     // A probe method failed and its results are missing
+	NetworkBlock    Kind = "network_block"    // Every server of a network prefix timed out
+	LocationSource  Kind = "location_source"  // The location came from another source than asked for
+	CaptureFailed   Kind = "capture_failed"   // The packet capture file is incomplete
+)
+
+// Warning is a non-fatal problem of a run
+type Warning struct {
+	Kind    Kind   `json:"kind"`
+	Message string `json:"message"`
+}
+
+// Collector gathers the warnings of a run. It is safe for concurrent use, and a nil Collector discards warnings.
+type Collector struct {
+	mu       sync.Mutex
+	warnings []Warning
+	logLevel logging.LogLevel
+}
+
+// Option configures a Collector
+type Option func(*Collector)
+
+// WithLogLevel sets the log level at which warnings are also logged as "Warning: <message>" when added
+func WithLogLevel(level logging.LogLevel) Option {
+	return func(c *Collector) {
+		c.logLevel = level
+	}
+}
+
+// New creates an empty Collector, logging nothing by default
+func New(opts ...Option) *Collector {
+	c := &Collector{logLevel: logging.LogLevelError}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Add records a warning of the given kind, with a message formatted as by fmt.Sprintf
+func (c *Collector) Add(kind Kind, format string, args ...any) {
+	if c == nil {
+		return
+	}
+
+	w := Warning{Kind: kind, Message: fmt.Sprintf(format, args...)}
+	c.mu.Lock()
+	c.warnings = append(c.warnings, w)
+	c.mu.Unlock()
+
+	if c.logLevel <= logging.LogLevelWarning {
+		log.Printf("Warning: %s", w.Message)
+	}
+}
+
+// Warnings returns the recorded warnings in the order they were added
+func (c *Collector) Warnings() []Warning {
+	if c == nil {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	warnings := make([]Warning, len(c.warnings))
+	copy(warnings, c.warnings)
+	return warnings
+}
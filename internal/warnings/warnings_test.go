@@ -0,0 +1,56 @@
+package warnings
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"os"
+	"slices"
+	"testing"
+
+	"github.com/Ch00k/mullvad-compass/internal/logging"
+)
+
+func TestCollector(t *testing.T) {
+	var logBuf bytes.Buffer
+	log.SetOutput(&logBuf)
+	log.SetFlags(0)
+	defer func() {
+		log.SetOutput(os.Stderr)
+		log.SetFlags(log.LstdFlags)
+	}()
+
+	c := New(WithLogLevel(logging.LogLevelWarning))
+	ctx := WithCollector(context.Background(), c)
+	FromContext(ctx).Add(SkippedRelays, "%d relay(s) skipped", 3)
+	FromContext(ctx).Add(IPv6Unavailable, "IPv6 is not available")
+
+	want := []Warning{
+		{Kind: SkippedRelays, Message: "3 relay(s) skipped"},
+		{Kind: IPv6Unavailable, Message: "IPv6 is not available"},
+	}
+	if got := c.Warnings(); !slices.Equal(got, want) {
+		t.Errorf("Warnings() = %v, want %v", got, want)
+	}
+	if logBuf.String() != "Warning: 3 relay(s) skipped\nWarning: IPv6 is not available\n" {
+		t.Errorf("Unexpected log output: %q", logBuf.String())
+	}
+}
+
+func TestCollectorQuiet(t *testing.T) {
+	var logBuf bytes.Buffer
+	log.SetOutput(&logBuf)
+	defer log.SetOutput(os.Stderr)
+
+	c := New()
+	c.Add(ProbeFailed, "failed to ping over IPv6")
+	if len(c.Warnings()) != 1 || logBuf.Len() != 0 {
+		t.Errorf("Expected one unlogged warning, got %v and log %q", c.Warnings(), logBuf.String())
+	}
+
+	// Without a collector in the context, warnings are discarded
+	FromContext(context.Background()).Add(ProbeFailed, "discarded")
+	if FromContext(context.Background()).Warnings() != nil {
+		t.Error("Expected no warnings from a missing collector")
+	}
+}
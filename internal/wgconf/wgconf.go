@@ -0,0 +1,160 @@
+// Package wgconf points the peer of a wg-quick configuration file at another relay.
+package wgconf
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// DefaultPort is the port of an Endpoint written without one
+const DefaultPort = 51820
+
+// Peer is the relay a configuration connects to
+type Peer struct {
+	PublicKey string // WireGuard public key in base64
+	Host      string // IPv4 or IPv6 address, without port
+}
+
+// Rewrite returns the configuration with the PublicKey and Endpoint of its single [Peer] section replaced by those
+// of peer, keeping the port of the endpoint, and reports whether anything changed. Everything else, including
+// comments and the interface's private key, is left as it is.
+func Rewrite(config []byte, peer Peer) ([]byte, bool, error) {
+	lines := strings.Split(string(config), "\n")
+
+	peers := 0
+	keyLine, endpointLine := -1, -1
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "[") {
+			if strings.EqualFold(trimmed, "[Peer]") {
+				peers++
+			}
+			continue
+		}
+		if peers != 1 {
+			continue
+		}
+		key, _, ok := strings.Cut(trimmed, "=")
+		if !ok {
+			continue
+		}
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "publickey":
+			keyLine = i
+		case "endpoint":
+			endpointLine = i
+		}
+	}
+
+	switch {
+	case peers != 1:
+		return nil, false, fmt.Errorf("expected one [Peer] section, found %d", peers)
+	case keyLine < 0:
+		return nil, false, fmt.Errorf("[Peer] section has no PublicKey")
+	case endpointLine < 0:
+		return nil, false, fmt.Errorf("[Peer] section has no Endpoint")
+	}
+
+	port := strconv.Itoa(DefaultPort)
+	if _, existing, err := net.SplitHostPort(value(lines[endpointLine])); err == nil {
+		port = existing
+	}
+
+	endpoint := net.JoinHostPort(peer.Host, port)
+	if value(lines[keyLine]) == peer.PublicKey && value(lines[endpointLine]) == endpoint {
+		return config, false, nil
+	}
+	lines[keyLine] = replaceValue(lines[keyLine], peer.PublicKey)
+	lines[endpointLine] = replaceValue(lines[endpointLine], endpoint)
+	return []byte(strings.Join(lines, "\n")), true, nil
+}
+
+// Apply rewrites the configuration file at path as Rewrite does, after copying it to path with a .bak suffix.
+// The file is replaced through a temporary file keeping its permissions, so that an interrupted write never
+// leaves a truncated configuration behind. It returns whether the file changed; an unchanged file is not touched.
+func Apply(path string, peer Peer) (bool, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return false, err
+	}
+	config, err := os.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+	rewritten, changed, err := Rewrite(config, peer)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", path, err)
+	}
+	if !changed {
+		return false, nil
+	}
+
+	// The configuration holds a private key, so the backup is readable by its owner only
+	if err := os.WriteFile(BackupPath(path), config, 0o600); err != nil {
+		return false, fmt.Errorf("failed to back up %s: %w", path, err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return false, fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+
+	_, err = tmp.Write(rewritten)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), info.Mode().Perm())
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return true, nil
+}
+
+// BackupPath returns the path Apply copies the configuration at path to
+func BackupPath(path string) string {
+	return path + ".bak"
+}
+
+// Restart takes the wg-quick interface of the configuration at path down and up again, so that it connects to
+// the new peer
+func Restart(ctx context.Context, path string) error {
+	for _, action := range []string{"down", "up"} {
+		var stderr bytes.Buffer
+		cmd := exec.CommandContext(ctx, "wg-quick", action, path)
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("wg-quick %s %s failed: %w: %s", action, path, err, strings.TrimSpace(stderr.String()))
+		}
+	}
+	return nil
+}
+
+// value returns the value of a "Key = Value" line
+func value(line string) string {
+	_, v, _ := strings.Cut(line, "=")
+	return strings.TrimSpace(v)
+}
+
+// replaceValue replaces the value of a "Key = Value" line, keeping the key as written and a trailing carriage
+// return of CRLF files
+func replaceValue(line, v string) string {
+	key, _, _ := strings.Cut(line, "=")
+	suffix := ""
+	if strings.HasSuffix(line, "\r") {
+		suffix = "\r"
+	}
+	return strings.TrimRight(key, " \t") + " = " + v + suffix
+}
@@ -0,0 +1,97 @@
+package wgconf
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+const config = `[Interface]
+# Device: Quick Otter
+PrivateKey = cNlwWq9AjZ6TyEGaGnOOQUfOOkJTMaK7OBJhKt1RT2A=
+Address = 10.64.12.34/32
+DNS = 10.64.0.1
+
+[Peer]
+PublicKey=5JMPeO7gXIbR5CnUa/NPNK4L5GqUnreF0/Bozai4pl4=
+AllowedIPs = 0.0.0.0/0
+Endpoint = 185.213.154.68:3478
+`
+
+func TestRewrite(t *testing.T) {
+	peer := Peer{PublicKey: "veLqpZazR9j/Ol2G8TfrO32yEhc1i543MCN8rpy1FBA=", Host: "193.32.249.66"}
+
+	t.Run("Replaces the key and address, keeping the port", func(t *testing.T) {
+		got, changed, err := Rewrite([]byte(config), peer)
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		want := strings.NewReplacer(
+			"PublicKey=5JMPeO7gXIbR5CnUa/NPNK4L5GqUnreF0/Bozai4pl4=",
+			"PublicKey = veLqpZazR9j/Ol2G8TfrO32yEhc1i543MCN8rpy1FBA=",
+			"185.213.154.68:3478",
+			"193.32.249.66:3478",
+		).Replace(config)
+		if !changed || string(got) != want {
+			t.Errorf("Got (changed: %v):\n%s\nwant:\n%s", changed, got, want)
+		}
+
+		if _, changed, _ := Rewrite(got, peer); changed {
+			t.Error("Expected a configuration already pointing at the peer to be unchanged")
+		}
+	})
+
+	t.Run("IPv6 endpoint without port", func(t *testing.T) {
+		input := "[Peer]\r\nPublicKey = old\r\nEndpoint = 185.213.154.68\r\n"
+		got, _, err := Rewrite([]byte(input), Peer{PublicKey: "new", Host: "2a03:1b20:1:f011::a01f"})
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		want := "[Peer]\r\nPublicKey = new\r\nEndpoint = [2a03:1b20:1:f011::a01f]:51820\r\n"
+		if string(got) != want {
+			t.Errorf("Got %q, want %q", got, want)
+		}
+	})
+
+	for _, input := range []string{
+		"[Interface]\nPrivateKey = x\n",
+		"[Peer]\nPublicKey = a\nEndpoint = 1.2.3.4:51820\n[Peer]\nPublicKey = b\nEndpoint = 1.2.3.5:51820\n",
+		"[Peer]\nEndpoint = 1.2.3.4:51820\n",
+		"[Peer]\nPublicKey = a\n",
+	} {
+		if _, _, err := Rewrite([]byte(input), peer); err == nil {
+			t.Errorf("Expected an error for %q", input)
+		}
+	}
+}
+
+func TestApply(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mullvad.conf")
+	if err := os.WriteFile(path, []byte(config), 0o640); err != nil {
+		t.Fatal(err)
+	}
+	peer := Peer{PublicKey: "veLqpZazR9j/Ol2G8TfrO32yEhc1i543MCN8rpy1FBA=", Host: "193.32.249.66"}
+
+	changed, err := Apply(path, peer)
+	if err != nil || !changed {
+		t.Fatalf("Expected the configuration to change, got %v, %v", changed, err)
+	}
+
+	backup, err := os.ReadFile(BackupPath(path))
+	if err != nil || string(backup) != config {
+		t.Errorf("Expected the original configuration as backup, got %q (%v)", backup, err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil || !strings.Contains(string(data), "Endpoint = 193.32.249.66:3478") {
+		t.Errorf("Expected the new endpoint, got %q (%v)", data, err)
+	}
+	if info, err := os.Stat(path); runtime.GOOS != "windows" && (err != nil || info.Mode().Perm() != 0o640) {
+		t.Errorf("Expected permissions to be kept, got %v (%v)", info.Mode().Perm(), err)
+	}
+
+	if changed, err := Apply(path, peer); err != nil || changed {
+		t.Errorf("Expected a second apply to change nothing, got %v, %v", changed, err)
+	}
+}
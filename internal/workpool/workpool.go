@@ -0,0 +1,99 @@
+// Package workpool runs tasks concurrently with a bound on the number running at once.
+package workpool
+
+import (
+	"context"
+	"sync"
+)
+
+// Semaphore hands out a fixed number of slots
+type Semaphore chan struct{}
+
+// NewSemaphore returns a semaphore with n slots
+func NewSemaphore(n int) Semaphore {
+	return make(Semaphore, n)
+}
+
+// Acquire waits for a free slot. Returns false if the context is done first.
+func (s Semaphore) Acquire(ctx context.Context) bool {
+	// A free slot and a done context may both be ready, and a done context must win
+	if ctx.Err() != nil {
+		return false
+	}
+	select {
+	case s <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Release frees a slot taken by Acquire
+func (s Semaphore) Release() {
+	<-s
+}
+
+// Group runs tasks in goroutines, at most a fixed number at a time, and collects the first error like
+// errgroup.Group with a limit
+type Group struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	slots  Semaphore
+	wg     sync.WaitGroup
+	once   sync.Once
+	err    error
+}
+
+// WithContext returns a group running at most limit tasks at once, and a context derived from ctx that is cancelled
+// when a task fails or Wait returns. A limit of 0 or less runs any number of tasks at once.
+func WithContext(ctx context.Context, limit int) (*Group, context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	g := &Group{ctx: ctx, cancel: cancel}
+	if limit > 0 {
+		g.slots = NewSemaphore(limit)
+	}
+	return g, ctx
+}
+
+// Go waits for a free slot and runs task in a goroutine. The task is skipped if the group's context is done before
+// a slot is free, so that cancelling stops queued tasks from starting.
+func (g *Group) Go(task func() error) {
+	if g.slots != nil && !g.slots.Acquire(g.ctx) {
+		return
+	}
+	if g.slots == nil && g.ctx.Err() != nil {
+		return
+	}
+
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		if g.slots != nil {
+			defer g.slots.Release()
+		}
+		if err := task(); err != nil {
+			g.once.Do(func() {
+				g.err = err
+				g.cancel()
+			})
+		}
+	}()
+}
+
+// Wait waits for the started tasks to finish and returns the first error they returned
+func (g *Group) Wait() error {
+	g.wg.Wait()
+	g.cancel()
+	return g.err
+}
+
+// ForEach calls task for each index in order from 0 to n-1, running at most limit calls at once. Calls are passed
+// a context that is cancelled once a call fails, and no further calls start then or once ctx is done. Returns the
+// first error of a call.
+func ForEach(ctx context.Context, n, limit int, task func(ctx context.Context, i int) error) error {
+	g, ctx := WithContext(ctx, limit)
+	for i := range n {
+		g.Go(func() error { return task(ctx, i) })
+	}
+	return g.Wait()
+}
@@ -0,0 +1,116 @@
+package workpool
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSemaphore(t *testing.T) {
+	sem := NewSemaphore(1)
+	if !sem.Acquire(context.Background()) {
+		t.Fatal("Expected a free slot")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if sem.Acquire(ctx) {
+		t.Error("Expected Acquire to give up once the context is done")
+	}
+
+	sem.Release()
+	if !sem.Acquire(context.Background()) {
+		t.Error("Expected the released slot to be free")
+	}
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if NewSemaphore(1).Acquire(cancelled) {
+		t.Error("Expected a done context to win over a free slot")
+	}
+}
+
+func TestForEach(t *testing.T) {
+	t.Run("Bounded concurrency", func(t *testing.T) {
+		const limit = 3
+		var running, peak atomic.Int32
+		var mu sync.Mutex
+		seen := make(map[int]bool)
+
+		err := ForEach(context.Background(), 20, limit, func(_ context.Context, i int) error {
+			n := running.Add(1)
+			for {
+				p := peak.Load()
+				if n <= p || peak.CompareAndSwap(p, n) {
+					break
+				}
+			}
+			time.Sleep(time.Millisecond)
+			running.Add(-1)
+
+			mu.Lock()
+			seen[i] = true
+			mu.Unlock()
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if len(seen) != 20 {
+			t.Errorf("Expected every index to be visited, got %d", len(seen))
+		}
+		if peak.Load() > limit {
+			t.Errorf("Expected at most %d tasks at once, got %d", limit, peak.Load())
+		}
+	})
+
+	t.Run("First error stops queued tasks", func(t *testing.T) {
+		errBoom := errors.New("boom")
+		var started atomic.Int32
+
+		err := ForEach(context.Background(), 100, 1, func(ctx context.Context, i int) error {
+			started.Add(1)
+			if i == 2 {
+				return errBoom
+			}
+			return ctx.Err()
+		})
+		if !errors.Is(err, errBoom) {
+			t.Errorf("Expected the task's error, got %v", err)
+		}
+		if started.Load() != 3 {
+			t.Errorf("Expected no task to start after the failure, got %d started", started.Load())
+		}
+	})
+
+	t.Run("Cancelled context", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		var started atomic.Int32
+		err := ForEach(ctx, 10, 2, func(context.Context, int) error {
+			started.Add(1)
+			return nil
+		})
+		if err != nil || started.Load() != 0 {
+			t.Errorf("Expected no task to start, got %d started and error %v", started.Load(), err)
+		}
+	})
+
+	t.Run("Unbounded", func(t *testing.T) {
+		// Every task waits for all of them to start, which only finishes if none waits for a slot
+		var ready sync.WaitGroup
+		ready.Add(5)
+		err := ForEach(context.Background(), 5, 0, func(context.Context, int) error {
+			ready.Done()
+			ready.Wait()
+			return nil
+		})
+		if err != nil {
+			t.Errorf("Expected no error, got: %v", err)
+		}
+	})
+}